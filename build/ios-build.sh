@@ -124,7 +124,7 @@ build_ios_device() {
         -trimpath \
         -ldflags="-s -w" \
         -o "$DIST_DIR/ios-arm64/mgit" \
-        .
+        ./cmd/mgit
     
     if [ $? -eq 0 ]; then
         log_success "iOS device binary built successfully"
@@ -164,7 +164,7 @@ build_ios_simulator() {
         -trimpath \
         -ldflags="-s -w" \
         -o "$DIST_DIR/ios-simulator/mgit" \
-        .
+        ./cmd/mgit
     
     if [ $? -eq 0 ]; then
         log_success "iOS simulator binary built successfully"
@@ -196,7 +196,7 @@ build_macos() {
         -trimpath \
         -ldflags="-s -w" \
         -o "$DIST_DIR/darwin-amd64/mgit" \
-        .
+        ./cmd/mgit
     
     if [ $? -eq 0 ]; then
         log_success "macOS binary built successfully"
@@ -0,0 +1,9 @@
+// Command mgit is git with nostr identities: commits get MGit hashes that
+// cover their authors' nostr keys. Run mgit help for its commands.
+package main
+
+import "github.com/imyjimmy/mgit/internal/cli"
+
+func main() {
+	cli.Main()
+}
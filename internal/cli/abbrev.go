@@ -0,0 +1,244 @@
+package cli
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// Short hashes are resolved through an index of the known commit hashes,
+// .mgit/abbrev: the git hashes of the commits the refs reach and the MGit
+// hashes of the hash mappings, sorted, one per line with g or m after it.
+// Its first line records the refs and mappings it covers. When they
+// change, as after a commit or a fetch, the next lookup adds the commits
+// not indexed yet and rewrites the file. A prefix is then found by binary
+// search instead of by reading every commit object; one no indexed hash
+// has, such as that of a commit no ref reaches, is still looked for the
+// slow way.
+//
+// The index also gives a hash's shortest unambiguous abbreviation: at
+// least 7 characters, and more where another known hash shares them.
+
+// abbrevIndexHeader starts the index file, before the state it covers
+const abbrevIndexHeader = "mgit-abbrev 1"
+
+// minAbbrev is the shortest abbreviation shown
+const minAbbrev = 7
+
+// AbbrevIndex is the sorted list of known commit hashes
+type AbbrevIndex struct {
+	state   string   // The refs and mappings it covers
+	entries []string // "<hash> g" for git commits, "<hash> m" for MGit commits
+}
+
+var (
+	abbrevIndexesMu sync.Mutex
+	abbrevIndexes   = map[string]*AbbrevIndex{} // By file, once loaded
+)
+
+// abbrevIndexPath returns the file holding the abbreviation index
+func (s *MGitStorage) abbrevIndexPath() string {
+	return filepath.Join(s.RootDir, "abbrev")
+}
+
+// loadAbbrevIndex returns the repository's index, brought up to date with
+// its refs and mappings
+func loadAbbrevIndex(repo *git.Repository, storage *MGitStorage) (*AbbrevIndex, error) {
+	state, tips, err := abbrevState(repo, storage)
+	if err != nil {
+		return nil, err
+	}
+	path := storage.abbrevIndexPath()
+
+	abbrevIndexesMu.Lock()
+	defer abbrevIndexesMu.Unlock()
+	index, ok := abbrevIndexes[path]
+	if !ok {
+		index = readAbbrevIndex(path)
+		abbrevIndexes[path] = index
+	}
+	if index.state == state {
+		return index, nil
+	}
+
+	index.update(repo, storage, tips)
+	index.state = state
+	// The index only saves work; when it can't be saved, the next command
+	// builds it again
+	index.write(path)
+	return index, nil
+}
+
+// abbrevState fingerprints the refs and mappings, and returns the commits
+// the refs point to
+func abbrevState(repo *git.Repository, storage *MGitStorage) (string, []plumbing.Hash, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting references: %w", err)
+	}
+	lines := []string{}
+	tips := []plumbing.Hash{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		lines = append(lines, ref.Name().String()+" "+ref.Hash().String())
+		hash := ref.Hash()
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		tips = append(tips, hash)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if head, err := repo.Head(); err == nil {
+		lines = append(lines, "HEAD "+head.Hash().String())
+		tips = append(tips, head.Hash())
+	}
+	sort.Strings(lines)
+
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(mappings) > 0 {
+		lines = append(lines, fmt.Sprintf("mappings %d %s", len(mappings), mappings[len(mappings)-1].MGitHash))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:]), tips, nil
+}
+
+// readAbbrevIndex reads an index file; one that is missing or not an
+// index reads as empty
+func readAbbrevIndex(path string) *AbbrevIndex {
+	index := &AbbrevIndex{}
+	file, err := os.Open(path)
+	if err != nil {
+		return index
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), abbrevIndexHeader+" ") {
+		return index
+	}
+	state := strings.TrimPrefix(scanner.Text(), abbrevIndexHeader+" ")
+	entries := []string{}
+	for scanner.Scan() {
+		entries = append(entries, scanner.Text())
+	}
+	if scanner.Err() != nil || !sort.StringsAreSorted(entries) {
+		return index
+	}
+	index.state, index.entries = state, entries
+	return index
+}
+
+// update adds the commits the tips reach and the mapped MGit hashes that
+// the index doesn't have yet
+func (x *AbbrevIndex) update(repo *git.Repository, storage *MGitStorage, tips []plumbing.Hash) {
+	known := make(map[string]bool, len(x.entries))
+	stop := map[plumbing.Hash]bool{}
+	for _, entry := range x.entries {
+		known[entry] = true
+		if strings.HasSuffix(entry, " g") {
+			// An indexed commit's history was indexed with it
+			stop[plumbing.NewHash(strings.TrimSuffix(entry, " g"))] = true
+		}
+	}
+	add := func(entry string) {
+		if !known[entry] {
+			known[entry] = true
+			x.entries = append(x.entries, entry)
+		}
+	}
+	walkCommits(repo, tips, stop, func(c *object.Commit) { add(c.Hash.String() + " g") })
+	if mappings, err := storage.GetMappings(); err == nil {
+		for _, mapping := range mappings {
+			if mapping.MGitHash != "" {
+				add(mapping.MGitHash + " m")
+			}
+		}
+	}
+	sort.Strings(x.entries)
+}
+
+// write saves the index
+func (x *AbbrevIndex) write(path string) error {
+	lock, err := fsutil.LockFile(path, fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	var b strings.Builder
+	b.WriteString(abbrevIndexHeader + " " + x.state + "\n")
+	for _, entry := range x.entries {
+		b.WriteString(entry + "\n")
+	}
+	return fsutil.WriteFile(path, []byte(b.String()))
+}
+
+// Matches returns the indexed hashes of a kind (g or m) starting with
+// prefix
+func (x *AbbrevIndex) Matches(prefix string, kind byte) []string {
+	prefix = strings.ToLower(prefix)
+	matches := []string{}
+	for i := sort.SearchStrings(x.entries, prefix); i < len(x.entries) && strings.HasPrefix(x.entries[i], prefix); i++ {
+		if entry := x.entries[i]; entry[len(entry)-1] == kind {
+			matches = append(matches, entry[:len(entry)-2])
+		}
+	}
+	return matches
+}
+
+// Abbrev returns the shortest abbreviation of a hash that no other
+// indexed hash shares, and at least minAbbrev characters
+func (x *AbbrevIndex) Abbrev(hash string) string {
+	length := minAbbrev
+	i := sort.SearchStrings(x.entries, hash)
+	for _, j := range []int{i - 1, i, i + 1} {
+		if j < 0 || j >= len(x.entries) {
+			continue
+		}
+		other := x.entries[j][:len(x.entries[j])-2]
+		if other == hash {
+			continue
+		}
+		common := 0
+		for common < len(hash) && common < len(other) && hash[common] == other[common] {
+			common++
+		}
+		if common+1 > length {
+			length = common + 1
+		}
+	}
+	if length > len(hash) {
+		length = len(hash)
+	}
+	return hash[:length]
+}
+
+// abbrevHash abbreviates a hash of the working directory's repository as
+// far as it stays unambiguous, or to 7 characters without an index
+func abbrevHash(hash string) string {
+	if repo, err := currentSession().Repo(); err == nil {
+		if index, err := loadAbbrevIndex(repo, currentSession().Storage()); err == nil {
+			return index.Abbrev(hash)
+		}
+	}
+	return shortHash(hash)
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	serverclient "github.com/imyjimmy/mgit/pkg/server-client"
+)
+
+// Who may read and push a repository on an mgit server is managed through
+// its access endpoints:
+//
+//	GET    /api/mgit/repos/<id>/access          list the grants
+//	POST   /api/mgit/repos/<id>/access          {"pubkey": "<npub>", "access": "read|write"}
+//	DELETE /api/mgit/repos/<id>/access/<npub>   revoke a grant
+//
+// Granting a key that already has access changes its level. The server
+// requires the caller to have admin access to the repository.
+
+const accessUsage = "Usage: mgit access list | grant <npub> read|write | revoke <npub>"
+
+// AccessGrant is one key's access to a repository on the server
+type AccessGrant = serverclient.AccessGrant
+
+// HandleAccess handles the access command
+func HandleAccess(args []string) {
+	if len(args) == 0 {
+		fmt.Println(accessUsage)
+		os.Exit(1)
+	}
+
+	remote, err := getRepo().Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 || !isServerURL(remote.Config().URLs[0]) {
+		fmt.Println("Error: access needs an origin remote on an MGit server")
+		os.Exit(1)
+	}
+	remoteURL := remote.Config().URLs[0]
+	auth := authForRepo(remoteURL)
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		err = listAccess(remoteURL, auth)
+	case args[0] == "grant" && len(args) == 3:
+		err = grantAccess(remoteURL, auth, args[1], args[2])
+	case args[0] == "revoke" && len(args) == 2:
+		err = revokeAccess(remoteURL, auth, args[1])
+	default:
+		fmt.Println(accessUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// accessClient returns a client for the repository's access endpoints.
+// Its calls are not retried, and a refusal is explained.
+func accessClient(remoteURL string, auth *Credentials) *serverclient.Client {
+	client := newServerClient(remoteURL, auth, 30*time.Second)
+	client.HTTP = serverHTTPClient(remoteURL, 30*time.Second)
+	client.ResponseError = func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			resp.Body.Close()
+			return fmt.Errorf("the server refused: managing access needs admin access to the repository")
+		}
+		return fmt.Errorf("error response from server (%d): %s", resp.StatusCode, responseMessage(resp))
+	}
+	return client
+}
+
+// listAccess prints the keys with access to the repository
+func listAccess(remoteURL string, auth *Credentials) error {
+	grants, err := accessClient(remoteURL, auth).Access(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(grants) == 0 {
+		fmt.Println("No keys have been granted access")
+		return nil
+	}
+	resolver := currentNIP05Resolver()
+	for _, grant := range grants {
+		line := fmt.Sprintf("%-6s %s", grant.Access, canonicalNostrPubKey(grant.Pubkey))
+		if resolver != nil {
+			if identifier := resolver.Resolve(grant.Pubkey); identifier != "" {
+				line += fmt.Sprintf(" (%s)", identifier)
+			}
+		}
+		if when, err := time.Parse(time.RFC3339, grant.GrantedAt); err == nil {
+			line += fmt.Sprintf(", granted %s", when.Format("2006-01-02"))
+			if grant.GrantedBy != "" {
+				line += " by " + canonicalNostrPubKey(grant.GrantedBy)
+			}
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// grantAccess gives a key read or write access, or changes the access it has
+func grantAccess(remoteURL string, auth *Credentials, pubkey, access string) error {
+	npub, err := NormalizeNostrPubKey(pubkey)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	if access != "read" && access != "write" {
+		return fmt.Errorf("access must be read or write, not '%s'", access)
+	}
+	if err := accessClient(remoteURL, auth).Grant(context.Background(), npub, access); err != nil {
+		return err
+	}
+	fmt.Printf("Granted %s %s access to %s\n", npub, access, extractRepoID(remoteURL))
+	return nil
+}
+
+// revokeAccess removes a key's access
+func revokeAccess(remoteURL string, auth *Credentials, pubkey string) error {
+	npub, err := NormalizeNostrPubKey(pubkey)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	if err := accessClient(remoteURL, auth).Revoke(context.Background(), npub); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked %s's access to %s\n", npub, extractRepoID(remoteURL))
+	return nil
+}
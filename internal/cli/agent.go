@@ -0,0 +1,298 @@
+package cli
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// AgentOptions controls the background sync agent
+type AgentOptions struct {
+	Listen string   // Address the webhook endpoint listens on
+	Secret string   // Shared secret the server signs webhook bodies with
+	Repos  []string // Working copies (or mirrors) the agent keeps in sync
+}
+
+// AgentWebhook is the body the MGit server posts to the agent after a push
+type AgentWebhook struct {
+	Repo string `json:"repo"` // Repository ID on the server
+	Type string `json:"type"` // e.g. push
+	Ref  string `json:"ref,omitempty"`
+}
+
+// agentSignatureHeader carries "sha256=<hex HMAC of the body>"
+const agentSignatureHeader = "X-MGit-Signature"
+
+const agentUsage = "Usage: mgit agent [--listen <addr>] [--secret <secret>] <repo-dir>..."
+
+// HandleAgent handles the agent command
+func HandleAgent(args []string) {
+	opts := &AgentOptions{
+		Listen: GetConfigValue("agent.listen", "127.0.0.1:7071"),
+		Secret: GetConfigValue("agent.secret", ""),
+	}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--listen", "--secret":
+			if i+1 >= len(args) {
+				fmt.Println(agentUsage)
+				os.Exit(1)
+			}
+			if args[i] == "--listen" {
+				opts.Listen = args[i+1]
+			} else {
+				opts.Secret = args[i+1]
+			}
+			i++
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				fmt.Println(agentUsage)
+				os.Exit(1)
+			}
+			opts.Repos = append(opts.Repos, args[i])
+		}
+	}
+	if len(opts.Repos) == 0 {
+		opts.Repos = []string{"."}
+	}
+	if opts.Secret == "" {
+		fmt.Println("Error: the agent needs a webhook secret. Pass --secret or set agent.secret:")
+		fmt.Println("  mgit config --global agent.secret \"<shared secret>\"")
+		os.Exit(1)
+	}
+
+	ctx := commandContext()
+	agent, err := newSyncAgent(ctx, opts)
+	if err != nil {
+		fmt.Printf("Error starting agent: %s\n", err)
+		os.Exit(1)
+	}
+
+	// An interrupt stops taking webhooks and cancels the syncs in flight,
+	// which release their repository locks as they return
+	server := &http.Server{Addr: opts.Listen, Handler: agent}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	fmt.Printf("Agent listening on http://%s/webhook for %d repositor(ies)\n", opts.Listen, len(agent.repos))
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("Error running agent: %s\n", err)
+		os.Exit(1)
+	}
+	agent.syncs.Wait()
+	exitIfInterrupted(ctx.Err())
+}
+
+// agentRepo is one repository the agent syncs. Syncs of the same repository
+// never overlap; a webhook arriving mid-sync queues exactly one more run.
+type agentRepo struct {
+	dir      string
+	remoteID string
+
+	mu      sync.Mutex
+	running bool
+	pending bool
+}
+
+// syncAgent receives push webhooks and syncs the affected repositories
+type syncAgent struct {
+	ctx    context.Context
+	secret []byte
+	repos  []*agentRepo
+	syncs  sync.WaitGroup
+}
+
+// newSyncAgent resolves each repository's origin to a server repository ID
+func newSyncAgent(ctx context.Context, opts *AgentOptions) (*syncAgent, error) {
+	agent := &syncAgent{ctx: ctx, secret: []byte(opts.Secret)}
+	for _, dir := range opts.Repos {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := git.PlainOpen(abs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		remote, err := repo.Remote("origin")
+		if err != nil || len(remote.Config().URLs) == 0 {
+			return nil, fmt.Errorf("%s: no origin remote configured", dir)
+		}
+		agent.repos = append(agent.repos, &agentRepo{
+			dir:      abs,
+			remoteID: extractRepoID(remote.Config().URLs[0]),
+		})
+	}
+	return agent, nil
+}
+
+func (a *syncAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/webhook" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+	if !a.validSignature(body, r.Header.Get(agentSignatureHeader)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var hook AgentWebhook
+	if err := json.Unmarshal(body, &hook); err != nil || hook.Repo == "" {
+		http.Error(w, "expected a JSON body with a repo ID", http.StatusBadRequest)
+		return
+	}
+
+	matched := 0
+	for _, repo := range a.repos {
+		if repo.remoteID == hook.Repo {
+			matched++
+			a.syncs.Add(1)
+			go func(repo *agentRepo) {
+				defer a.syncs.Done()
+				repo.requestSync(a.ctx)
+			}(repo)
+		}
+	}
+	agentLogf("%s for %s: syncing %d repositor(ies)", hook.Type, hook.Repo, matched)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"syncing": matched})
+}
+
+// validSignature checks the HMAC-SHA256 of body against the signature header
+func (a *syncAgent) validSignature(body []byte, header string) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// requestSync syncs the repository, or queues another sync if one is
+// already running
+func (r *agentRepo) requestSync(ctx context.Context) {
+	r.mu.Lock()
+	if r.running {
+		r.pending = true
+		r.mu.Unlock()
+		return
+	}
+	r.running = true
+	r.mu.Unlock()
+
+	for {
+		if err := syncRepository(ctx, r.dir); err != nil {
+			agentLogf("sync of %s failed: %s", r.dir, err)
+		} else {
+			agentLogf("synced %s", r.dir)
+		}
+
+		r.mu.Lock()
+		if !r.pending || ctx.Err() != nil {
+			r.running = false
+			r.mu.Unlock()
+			return
+		}
+		r.pending = false
+		r.mu.Unlock()
+	}
+}
+
+// syncRepository fast-forwards a repository from its origin and refreshes its
+// MGit metadata from the server
+func syncRepository(ctx context.Context, dir string) error {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return fmt.Errorf("no origin remote configured")
+	}
+	remoteURL := remote.Config().URLs[0]
+
+	// Credentials are found before the repository is locked, so a
+	// repository without them fails its own sync at once, neither holding
+	// up commits nor ending the agent
+	var auth *Credentials
+	if isServerURL(remoteURL) {
+		auth, err = lookupAuth(remoteURL)
+		if err != nil {
+			return fmt.Errorf("%w; log in with mgit login %s", err, remoteURL)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Wait for a commit or checkout running in the repository to finish
+	storage := &MGitStorage{RootDir: filepath.Join(dir, ".mgit")}
+	lock, err := storage.LockRepo("agent sync")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	verb := "pull"
+	gitArgs := []string{"pull", "--ff-only", "--quiet", "origin"}
+	if _, err := repo.Worktree(); err == git.ErrIsBareRepository {
+		// Mirrors have no worktree to fast-forward; update the branches directly
+		verb = "fetch"
+		gitArgs = []string{"fetch", "--quiet", "origin", "+refs/heads/*:refs/heads/*"}
+	}
+	if auth != nil {
+		gitArgs = append(append(serverGitArgs(remoteURL), "-c", auth.gitConfig()), gitArgs...)
+	}
+	cmd := exec.CommandContext(ctx, "git", gitArgs...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("git %s: %s", verb, strings.TrimSpace(string(output)))
+	}
+
+	if !isServerURL(remoteURL) {
+		return nil
+	}
+	fetch, err := syncRemoteMetadata(ctx, storage, remoteURL, auth)
+	if err != nil {
+		return fmt.Errorf("error fetching MGit metadata: %w", err)
+	}
+	if fetch.NotModified {
+		return nil
+	}
+	return reconstructMGitObjects(ctx, dir)
+}
+
+// agentLogf prints a timestamped agent log line
+func agentLogf(format string, args ...interface{}) {
+	fmt.Printf("[%s] %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+func TestSyncRepositoryWithoutCredentials(t *testing.T) {
+	isolateConfig(t)
+	t.Setenv("MGIT_USERNAME", "")
+	t.Setenv("MGIT_PASSWORD", "")
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"http://127.0.0.1:1/records"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reaching the end of the test at all means the agent wasn't ended
+	err = syncRepository(context.Background(), dir)
+	if err == nil || !strings.Contains(err.Error(), "mgit login") {
+		t.Errorf("syncRepository() = %v, want an error asking to log in", err)
+	}
+}
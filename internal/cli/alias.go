@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Aliases name a command with arguments, as in git:
+//
+//	[alias]
+//		co = checkout
+//		lg = log --graph -n 20
+//		who = !mgit config user.name && mgit config user.pubkey
+//
+// mgit lg -- src runs mgit log --graph -n 20 -- src. An alias may expand to
+// another alias, but not back to itself. Words in an alias may be quoted
+// with ' or " to hold spaces. An alias starting with ! is a shell command,
+// run through sh in the current directory with the remaining arguments as
+// $1, $2 and so on. Built-in commands can't be redefined; an alias takes
+// precedence over an mgit-<name> plugin.
+
+// expandedAliases holds the aliases expanded so far, to catch loops
+var expandedAliases = map[string]bool{}
+
+// runAlias runs the command an alias stands for, with args after its own
+func runAlias(name, alias string, args []string) {
+	if expandedAliases[name] {
+		fmt.Printf("Error: alias loop: %s expands to itself\n", name)
+		os.Exit(1)
+	}
+	expandedAliases[name] = true
+
+	if strings.HasPrefix(alias, "!") {
+		cmd := exec.Command("sh", append([]string{"-c", alias[1:] + ` "$@"`, alias[1:]}, args...)...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			fmt.Printf("Error running alias %s: %s\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	words, err := splitAliasWords(alias)
+	if err == nil && len(words) == 0 {
+		err = fmt.Errorf("it is empty")
+	}
+	if err != nil {
+		fmt.Printf("Error: bad alias %s: %s\n", name, err)
+		os.Exit(1)
+	}
+	runCommand(words[0], append(words[1:], args...))
+}
+
+// splitAliasWords splits an alias into words at spaces, keeping quoted
+// text together and taking the character after a backslash as it is
+func splitAliasWords(alias string) ([]string, error) {
+	words := []string{}
+	word := strings.Builder{}
+	inWord := false
+	quote := rune(0)
+	escaped := false
+	for _, c := range alias {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
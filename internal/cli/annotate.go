@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// Link types an annotation can carry
+var annotationLinkTypes = []string{"imaging", "lab", "consent"}
+
+const annotateUsage = "Usage: mgit annotate-commit <hash> --link <uri> --type imaging|lab|consent"
+
+// HandleAnnotateCommit handles the annotate-commit command
+func HandleAnnotateCommit(args []string) {
+	hash, uri, linkType := "", "", ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--link":
+			if i+1 >= len(args) {
+				fmt.Println(annotateUsage)
+				os.Exit(1)
+			}
+			uri = args[i+1]
+			i++
+		case "--type":
+			if i+1 >= len(args) {
+				fmt.Println(annotateUsage)
+				os.Exit(1)
+			}
+			linkType = args[i+1]
+			i++
+		default:
+			if hash != "" || strings.HasPrefix(args[i], "-") {
+				fmt.Println(annotateUsage)
+				os.Exit(1)
+			}
+			hash = args[i]
+		}
+	}
+
+	if hash == "" || uri == "" || linkType == "" {
+		fmt.Println(annotateUsage)
+		os.Exit(1)
+	}
+	if !isAnnotationLinkType(linkType) {
+		fmt.Printf("Error: unknown link type '%s' (expected %s)\n", linkType, strings.Join(annotationLinkTypes, ", "))
+		os.Exit(1)
+	}
+
+	userName := GetConfigValue("user.name", "")
+	userEmail := GetConfigValue("user.email", "")
+	userPubkey := GetNostrPubKey()
+	if userPubkey == "" {
+		fmt.Println("Annotations are signed with your nostr key. Set it first:")
+		fmt.Println("  mgit config --global user.pubkey \"npub...\"")
+		os.Exit(1)
+	}
+
+	storage := NewMGitStorage()
+	target, err := resolveMGitCommitHash(getRepo(), storage, hash)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	annotation := &MAnnotationStruct{
+		Type:     mgitlib.AnnotationObject,
+		Target:   target,
+		LinkType: linkType,
+		URI:      uri,
+		Author: &mgitlib.Signature{
+			Name:   userName,
+			Email:  userEmail,
+			Pubkey: userPubkey,
+			When:   time.Now(),
+		},
+	}
+	payload := annotationPayload(annotation)
+	annotation.Hash = fmt.Sprintf("%x", sha1.Sum([]byte(payload)))
+	annotation.Signature, err = SignWithNostrKey(payload)
+	if err != nil {
+		fmt.Printf("Error signing annotation: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := storage.StoreAnnotation(annotation); err != nil {
+		fmt.Printf("Error storing annotation: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Annotated %s with %s link %s (annotation %s)\n",
+		shortHash(target), linkType, uri, shortHash(annotation.Hash))
+}
+
+// isAnnotationLinkType reports whether linkType is one annotate-commit accepts
+func isAnnotationLinkType(linkType string) bool {
+	for _, t := range annotationLinkTypes {
+		if t == linkType {
+			return true
+		}
+	}
+	return false
+}
+
+// annotationPayload is the content an annotation's hash and signature cover
+func annotationPayload(a *MAnnotationStruct) string {
+	return fmt.Sprintf("annotation\ntarget %s\ntype %s\nuri %s\nauthor %s <%s> %d %s\n",
+		a.Target, a.LinkType, a.URI,
+		a.Author.Name, a.Author.Email, a.Author.When.Unix(), a.Author.Pubkey)
+}
+
+// resolveMGitCommitHash turns a git or MGit hash (or any revision) into the
+// full hash of an MGit commit. Annotations only attach to MGit commits.
+func resolveMGitCommitHash(repo *git.Repository, storage *MGitStorage, rev string) (string, error) {
+	if commit, err := storage.GetCommit(rev); err == nil {
+		return commit.MGitHash, nil
+	}
+
+	revision, err := Resolve(repo, storage, rev)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", rev, err)
+	}
+	if revision.MGit == "" {
+		return "", fmt.Errorf("commit %s has no MGit hash", shortHash(revision.Git.String()))
+	}
+	return revision.MGit, nil
+}
+
+// writeCommitLinks writes the external document links annotated on a commit
+func writeCommitLinks(w io.Writer, storage *MGitStorage, mgitHash string) error {
+	annotations, err := storage.GetAnnotations(mgitHash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "commit %s\n", mgitHash)
+	if len(annotations) == 0 {
+		fmt.Fprintln(w, "No links")
+		return nil
+	}
+
+	for _, a := range annotations {
+		status := "valid"
+		if !VerifyNostrSignature(annotationPayload(a), a.Signature, a.Author.Pubkey) {
+			status = "INVALID"
+		}
+		fmt.Fprintf(w, "%-8s %s\n", a.LinkType, a.URI)
+		fmt.Fprintf(w, "         annotation %s by %s <%s> on %s, signature %s\n",
+			shortHash(a.Hash), a.Author.Name, a.Author.Pubkey,
+			a.Author.When.Format("Mon Jan 2 15:04:05 2006 -0700"), status)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// RepoAnnouncement describes a repository for a NIP-34 announcement event
+type RepoAnnouncement struct {
+	Identifier     string // "d" tag - the repository's identifier
+	Name           string
+	Description    string
+	CloneURLs      []string
+	Relays         []string
+	Maintainers    []string // Hex pubkeys of other maintainers
+	EarliestCommit string   // "r" tag marked "euc" - the repository's root commit
+}
+
+// Event builds the unsigned kind 30617 announcement event
+func (a *RepoAnnouncement) Event() *nostr.Event {
+	tags := [][]string{{"d", a.Identifier}}
+	if a.Name != "" {
+		tags = append(tags, []string{"name", a.Name})
+	}
+	if a.Description != "" {
+		tags = append(tags, []string{"description", a.Description})
+	}
+	if len(a.CloneURLs) > 0 {
+		tags = append(tags, append([]string{"clone"}, a.CloneURLs...))
+	}
+	if len(a.Relays) > 0 {
+		tags = append(tags, append([]string{"relays"}, a.Relays...))
+	}
+	if len(a.Maintainers) > 0 {
+		tags = append(tags, append([]string{"maintainers"}, a.Maintainers...))
+	}
+	if a.EarliestCommit != "" {
+		tags = append(tags, []string{"r", a.EarliestCommit, "euc"})
+	}
+	return nostr.NewEvent(nostr.KindRepoAnnouncement, tags, "")
+}
+
+// parseAnnouncement reads a kind 30617 announcement event
+func parseAnnouncement(event *nostr.Event) *RepoAnnouncement {
+	a := &RepoAnnouncement{}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "d":
+			a.Identifier = tag[1]
+		case "name":
+			a.Name = tag[1]
+		case "description":
+			a.Description = tag[1]
+		case "clone":
+			a.CloneURLs = append(a.CloneURLs, tag[1:]...)
+		case "relays":
+			a.Relays = append(a.Relays, tag[1:]...)
+		case "maintainers":
+			a.Maintainers = append(a.Maintainers, tag[1:]...)
+		case "r":
+			if len(tag) > 2 && tag[2] == "euc" {
+				a.EarliestCommit = tag[1]
+			}
+		}
+	}
+	return a
+}
+
+// fetchAnnouncement finds the newest announcement at addr on relays. Only
+// events signed by the address's author with its identifier count.
+func fetchAnnouncement(addr *NostrAddress, relays []string) (*nostr.Event, error) {
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("no relays to look up the repository on (set nostr.relays)")
+	}
+	events, err := queryRelays(NostrFilter{
+		"kinds":   []int{nostr.KindRepoAnnouncement},
+		"authors": []string{addr.Pubkey},
+		"#d":      []string{addr.Identifier},
+	}, relays)
+	if err != nil {
+		return nil, err
+	}
+	var newest *nostr.Event
+	for _, event := range events {
+		if event.Kind != nostr.KindRepoAnnouncement || event.Pubkey != addr.Pubkey || eventTag(event, "d") != addr.Identifier {
+			continue
+		}
+		if newest == nil || event.CreatedAt > newest.CreatedAt {
+			newest = event
+		}
+	}
+	if newest == nil {
+		return nil, fmt.Errorf("no announcement for '%s' found on %s", addr.Identifier, strings.Join(relays, ", "))
+	}
+	return newest, nil
+}
+
+// announceRepository signs an announcement for the repository at path,
+// publishes it to the configured relays and keeps a copy in
+// .mgit/announcement.json
+func announceRepository(path string) error {
+	relays := configuredRelays()
+	if len(relays) == 0 {
+		return fmt.Errorf("no relays configured (add one with mgit relay add <url>)")
+	}
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+
+	config, err := LoadConfig(filepath.Join(path, ".mgit", "config"))
+	if err != nil {
+		return fmt.Errorf("error loading MGit config: %w", err)
+	}
+	announcement := &RepoAnnouncement{
+		Identifier:  config.Get("repository", "name"),
+		Name:        config.Get("repository", "name"),
+		Description: config.Get("repository", "description"),
+		Relays:      relays,
+	}
+	if cloneURL := GetConfigValue("nostr.cloneURL", ""); cloneURL != "" {
+		announcement.CloneURLs = []string{cloneURL}
+	}
+
+	event := announcement.Event()
+	if err := event.Sign(secret); err != nil {
+		return fmt.Errorf("error signing announcement: %w", err)
+	}
+
+	data, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding announcement: %w", err)
+	}
+	if err := fsutil.WriteFile(filepath.Join(path, ".mgit", "announcement.json"), data); err != nil {
+		return fmt.Errorf("error saving announcement: %w", err)
+	}
+
+	accepted := 0
+	for _, result := range publishToRelays(event, relays) {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("  %s: %s\n", result.Relay, result.Err)
+		case !result.Accepted:
+			fmt.Printf("  %s: rejected: %s\n", result.Relay, result.Message)
+		default:
+			accepted++
+			fmt.Printf("  %s: accepted\n", result.Relay)
+		}
+	}
+	if accepted == 0 {
+		return fmt.Errorf("no relay accepted the announcement")
+	}
+
+	fmt.Printf("Announced repository '%s' (event %s) to %d of %d relays\n",
+		announcement.Identifier, shortHash(event.ID), accepted, len(relays))
+	addr := &NostrAddress{Identifier: announcement.Identifier, Pubkey: event.Pubkey, Kind: event.Kind, Relays: relays}
+	if naddr, err := addr.Encode(); err == nil {
+		fmt.Printf("Address: nostr:%s\n", naddr)
+	}
+	return nil
+}
+
+// repoAddress returns the repository nostr events are addressed to: the
+// given naddr, or else this one. This repository's identifier is
+// repository.name in .mgit/config; its owner and relays come from the
+// announcement mgit init --announce saved, or else repository.owner.
+func repoAddress(storage *MGitStorage, naddr string) (*NostrAddress, error) {
+	if naddr != "" {
+		addr, err := parseNaddr(naddr)
+		if err != nil {
+			return nil, err
+		}
+		if addr.Kind != nostr.KindRepoAnnouncement {
+			return nil, fmt.Errorf("%s is not a repository address (kind %d)", naddr, addr.Kind)
+		}
+		return addr, nil
+	}
+
+	config, err := LoadConfig(filepath.Join(storage.RootDir, "config"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading MGit config: %w", err)
+	}
+	addr := &NostrAddress{Identifier: config.Get("repository", "name"), Kind: nostr.KindRepoAnnouncement}
+
+	data, err := os.ReadFile(filepath.Join(storage.RootDir, "announcement.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading announcement: %w", err)
+	}
+	if err == nil {
+		event := &nostr.Event{}
+		if err := json.Unmarshal(data, event); err != nil {
+			return nil, fmt.Errorf("error parsing announcement: %w", err)
+		}
+		announcement := parseAnnouncement(event)
+		if addr.Identifier == "" || addr.Identifier == announcement.Identifier {
+			addr.Identifier = announcement.Identifier
+			addr.Pubkey = event.Pubkey
+			addr.Relays = announcement.Relays
+		}
+	}
+	if addr.Identifier == "" {
+		return nil, fmt.Errorf("no repository given (pass its naddr, or set repository.name in .mgit/config)")
+	}
+
+	if owner := config.Get("repository", "owner"); addr.Pubkey == "" && owner != "" {
+		if addr.Pubkey, err = nostr.PubkeyHex(owner); err != nil {
+			return nil, fmt.Errorf("invalid repository.owner: %w", err)
+		}
+	}
+	if addr.Pubkey == "" {
+		return nil, fmt.Errorf("repository owner unknown (announce it with mgit init --announce, or set repository.owner in .mgit/config)")
+	}
+	return addr, nil
+}
+
+// repoRelays returns the relays given on the command line, or else the
+// repository's and the configured ones
+func repoRelays(addr *NostrAddress, relays []string) []string {
+	if len(relays) > 0 {
+		return relays
+	}
+	for _, relay := range append(append([]string{}, addr.Relays...), configuredRelays()...) {
+		relays = appendUnique(relays, relay)
+	}
+	return relays
+}
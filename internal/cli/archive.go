@@ -0,0 +1,370 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// mgit archive writes the tree of a commit as a tar, tar.gz or zip file, as
+// git archive does. With --include-mgit the snapshot carries what is needed
+// to check it offline, under .mgit-archive/:
+//
+//	manifest.json                the archived commit's git, MGit and tree hashes
+//	objects/<xx>/<rest>          MGit commit objects for the commit and its
+//	                             ancestry, and their signed annotations, stored
+//	                             as in .mgit/objects
+//	mappings/hash_mappings.json  the git/MGit mappings of those commits
+//
+// The files' git tree hash can be recomputed and compared with the MGit
+// commit's, and each MGit object's hash recomputed from its contents back to
+// the root commit.
+
+const archiveUsage = "Usage: mgit archive [--format=tar|tar.gz|zip] [-o <file>] [--prefix=<dir>/] [--include-mgit] [<ref>]"
+
+// archiveMetadataDir is where --include-mgit puts the MGit metadata
+const archiveMetadataDir = ".mgit-archive"
+
+// ArchiveOptions controls what archive writes
+type ArchiveOptions struct {
+	Format      string // tar, tar.gz or zip
+	Prefix      string // Prepended to every path in the archive
+	IncludeMGit bool
+}
+
+// ArchiveManifest describes the archived commit in an --include-mgit archive
+type ArchiveManifest struct {
+	Ref        string `json:"ref"`
+	GitHash    string `json:"git_hash"`
+	MGitHash   string `json:"mgit_hash"`
+	TreeHash   string `json:"tree_hash"`
+	Pubkey     string `json:"pubkey,omitempty"`
+	Commits    int    `json:"commits"`    // MGit commit objects included
+	Incomplete bool   `json:"incomplete"` // Ancestry stops short of the root (shallow clone)
+}
+
+// archiveWriter adds entries to an archive in one of the formats
+type archiveWriter interface {
+	addFile(name string, mode os.FileMode, data []byte) error
+	addSymlink(name, target string) error
+	Close() error
+}
+
+// HandleArchive handles the archive command
+func HandleArchive(args []string) {
+	opts := &ArchiveOptions{}
+	output := ""
+	rev := "HEAD"
+	revSet := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case strings.HasPrefix(arg, "--format="):
+			opts.Format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--prefix="):
+			opts.Prefix = strings.TrimPrefix(arg, "--prefix=")
+		case (arg == "-o" || arg == "--output") && i+1 < len(args):
+			i++
+			output = args[i]
+		case strings.HasPrefix(arg, "--output="):
+			output = strings.TrimPrefix(arg, "--output=")
+		case arg == "--include-mgit":
+			opts.IncludeMGit = true
+		case strings.HasPrefix(arg, "-") || revSet:
+			fmt.Println(archiveUsage)
+			os.Exit(1)
+		default:
+			rev = arg
+			revSet = true
+		}
+	}
+	if opts.Format == "" {
+		opts.Format = archiveFormatFor(output)
+	}
+	if opts.Format == "tgz" {
+		opts.Format = "tar.gz"
+	}
+	if opts.Format != "tar" && opts.Format != "tar.gz" && opts.Format != "zip" {
+		fmt.Printf("Error: unknown archive format '%s' (use tar, tar.gz or zip)\n", opts.Format)
+		os.Exit(1)
+	}
+
+	repo := getRepo()
+	storage := NewMGitStorage()
+	revision, err := Resolve(repo, storage, rev)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	out := io.Writer(os.Stdout)
+	var file *os.File
+	if output != "" {
+		file, err = os.Create(output)
+		if err != nil {
+			fmt.Printf("Error creating %s: %s\n", output, err)
+			os.Exit(1)
+		}
+		out = file
+	}
+	err = writeArchive(out, repo, storage, revision.Git, rev, opts)
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(output)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// archiveFormatFor picks the format from an output file's extension, tar by
+// default
+func archiveFormatFor(output string) string {
+	switch {
+	case strings.HasSuffix(output, ".zip"):
+		return "zip"
+	case strings.HasSuffix(output, ".tar.gz"), strings.HasSuffix(output, ".tgz"):
+		return "tar.gz"
+	}
+	return "tar"
+}
+
+// writeArchive writes the tree of the commit at hash to out, with its MGit
+// metadata if opts.IncludeMGit is set
+func writeArchive(out io.Writer, repo *git.Repository, storage *MGitStorage, hash plumbing.Hash, rev string, opts *ArchiveOptions) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("error getting commit %s: %w", shortHash(hash.String()), err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("error getting tree: %w", err)
+	}
+
+	// Entries are stamped with the commit time, so archiving the same commit
+	// twice gives the same archive
+	when := commit.Committer.When
+	var archive archiveWriter
+	switch opts.Format {
+	case "zip":
+		archive = &zipArchive{w: zip.NewWriter(out), when: when}
+	case "tar.gz":
+		gz := gzip.NewWriter(out)
+		archive = &tarArchive{w: tar.NewWriter(gz), gz: gz, when: when}
+	default:
+		archive = &tarArchive{w: tar.NewWriter(out), when: when}
+	}
+
+	err = tree.Files().ForEach(func(file *object.File) error {
+		contents, err := file.Contents()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", file.Name, err)
+		}
+		name := opts.Prefix + file.Name
+		switch file.Mode {
+		case filemode.Symlink:
+			return archive.addSymlink(name, contents)
+		case filemode.Executable:
+			return archive.addFile(name, 0755, []byte(contents))
+		default:
+			return archive.addFile(name, 0644, []byte(contents))
+		}
+	})
+	if err == nil && opts.IncludeMGit {
+		err = writeArchiveMetadata(archive, storage, commit, rev, opts.Prefix+archiveMetadataDir+"/")
+	}
+	if err != nil {
+		archive.Close()
+		return err
+	}
+	return archive.Close()
+}
+
+// writeArchiveMetadata adds the manifest, MGit objects and mappings for
+// commit and its ancestry under dir
+func writeArchiveMetadata(archive archiveWriter, storage *MGitStorage, commit *object.Commit, rev, dir string) error {
+	mgitHash := GetMGitHashForCommit(commit.Hash)
+	if mgitHash == "" {
+		return fmt.Errorf("commit %s has no MGit hash (run mgit migrate)", shortHash(commit.Hash.String()))
+	}
+	manifest := &ArchiveManifest{
+		Ref:      rev,
+		GitHash:  commit.Hash.String(),
+		MGitHash: mgitHash,
+		TreeHash: commit.TreeHash.String(),
+	}
+
+	// The commit's MGit ancestry, with the annotations on each commit
+	mappings := []NostrCommitMapping{}
+	queue := []string{mgitHash}
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		mgitCommit, err := storage.GetCommit(hash)
+		if err != nil {
+			// Shallow clones stop short of the root
+			manifest.Incomplete = true
+			continue
+		}
+		if hash == mgitHash {
+			manifest.Pubkey = mgitCommit.Author.Pubkey
+		}
+		if err := addArchiveObject(archive, storage, dir, hash); err != nil {
+			return err
+		}
+		manifest.Commits++
+		if mapping, ok, err := storage.Mappings().ByMGit(hash); err != nil {
+			return err
+		} else if ok {
+			mapping.Source = ""
+			mappings = append(mappings, mapping)
+		}
+
+		annotations, err := storage.annotationHashes(hash)
+		if err != nil {
+			return err
+		}
+		for _, annotation := range annotations {
+			if err := addArchiveObject(archive, storage, dir, annotation); err != nil {
+				return err
+			}
+		}
+		queue = append(queue, mgitCommit.ParentHashes...)
+	}
+
+	data, err := json.MarshalIndent(mappings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode mappings: %w", err)
+	}
+	if err := archive.addFile(dir+"mappings/hash_mappings.json", 0644, data); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return archive.addFile(dir+"manifest.json", 0644, data)
+}
+
+// addArchiveObject adds an MGit object in its stored form, at the same path
+// it has under .mgit
+func addArchiveObject(archive archiveWriter, storage *MGitStorage, dir, hash string) error {
+	raw, err := storage.readStoredObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to read MGit object %s: %w", shortHash(hash), err)
+	}
+	return archive.addFile(dir+path.Join("objects", hash[:2], hash[2:]), 0444, raw)
+}
+
+// tarArchive writes a tar archive, gzipped if gz is set
+type tarArchive struct {
+	w    *tar.Writer
+	gz   *gzip.Writer
+	when time.Time
+	dirs map[string]bool
+}
+
+// addDirs adds the directories leading to name that aren't in yet
+func (a *tarArchive) addDirs(name string) error {
+	if a.dirs == nil {
+		a.dirs = map[string]bool{}
+	}
+	dir := path.Dir(name)
+	if dir == "." || dir == "/" || a.dirs[dir] {
+		return nil
+	}
+	if err := a.addDirs(dir); err != nil {
+		return err
+	}
+	a.dirs[dir] = true
+	return a.w.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755, ModTime: a.when})
+}
+
+func (a *tarArchive) addFile(name string, mode os.FileMode, data []byte) error {
+	if err := a.addDirs(name); err != nil {
+		return err
+	}
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: int64(mode), Size: int64(len(data)), ModTime: a.when}
+	if err := a.w.WriteHeader(header); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if _, err := a.w.Write(data); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	return nil
+}
+
+func (a *tarArchive) addSymlink(name, target string) error {
+	if err := a.addDirs(name); err != nil {
+		return err
+	}
+	header := &tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0777, ModTime: a.when}
+	if err := a.w.WriteHeader(header); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	return nil
+}
+
+func (a *tarArchive) Close() error {
+	err := a.w.Close()
+	if a.gz != nil {
+		if gzErr := a.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	return err
+}
+
+// zipArchive writes a zip archive
+type zipArchive struct {
+	w    *zip.Writer
+	when time.Time
+}
+
+func (a *zipArchive) add(name string, mode os.FileMode, data []byte) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.when}
+	header.SetMode(mode)
+	w, err := a.w.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	return nil
+}
+
+func (a *zipArchive) addFile(name string, mode os.FileMode, data []byte) error {
+	return a.add(name, mode, data)
+}
+
+func (a *zipArchive) addSymlink(name, target string) error {
+	return a.add(name, os.ModeSymlink|0777, []byte(target))
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
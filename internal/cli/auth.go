@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Requests to an mgit server are authenticated by the first provider in an
+// ordered chain that has credentials for the URL. The chain is read from the
+// auth.providers config value; providers not listed there are never asked.
+//
+//	flag            a token given on the command line (clone -jwt)
+//	env             basic auth from MGIT_USERNAME and MGIT_PASSWORD
+//	token-store     the JWT saved for the repository in ~/.mgitconfig/tokens.enc
+//	git-credential  what git's credential helpers have for the URL
+//
+// Remotes that are not mgit servers (local paths, ssh) are left to git and
+// never go through the chain.
+
+const defaultAuthProviders = "flag,env,token-store,git-credential"
+
+// Credentials are what a provider supplies for one URL
+type Credentials struct {
+	Provider string // Name of the provider that supplied them
+	Scheme   string // "Bearer" or "Basic"
+	Value    string // The Authorization header value after the scheme
+	Source   string // Where the provider found them, for auth explain
+}
+
+// header returns the Authorization header value
+func (c *Credentials) header() string {
+	return c.Scheme + " " + c.Value
+}
+
+// setHeader adds the credentials to an HTTP request
+func (c *Credentials) setHeader(req *http.Request) {
+	req.Header.Set("Authorization", c.header())
+}
+
+// gitConfig returns the http.extraHeader setting that passes the credentials
+// to the git CLI, for use after "-c"
+func (c *Credentials) gitConfig() string {
+	return "http.extraHeader=Authorization: " + c.header()
+}
+
+// AuthProvider finds credentials for a repository URL. A provider that has
+// none returns an error saying why; the chain then asks the next one.
+type AuthProvider interface {
+	Name() string
+	Credentials(repoURL string) (*Credentials, error)
+}
+
+// AuthAttempt records one provider's answer, for auth explain
+type AuthAttempt struct {
+	Provider string
+	Err      error // Why the provider had no credentials, or nil if it had
+}
+
+// AuthChain asks its providers in order
+type AuthChain struct {
+	Providers []AuthProvider
+}
+
+// newAuthChain builds the configured chain. jwt is a token given on the
+// command line, offered by the flag provider.
+func newAuthChain(jwt string) (*AuthChain, error) {
+	chain := &AuthChain{}
+	for _, name := range strings.Split(GetConfigValue("auth.providers", defaultAuthProviders), ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case "flag":
+			chain.Providers = append(chain.Providers, &flagAuthProvider{token: jwt})
+		case "env":
+			chain.Providers = append(chain.Providers, envAuthProvider{})
+		case "token-store":
+			chain.Providers = append(chain.Providers, tokenStoreAuthProvider{})
+		case "git-credential":
+			chain.Providers = append(chain.Providers, gitCredentialAuthProvider{})
+		default:
+			return nil, fmt.Errorf("unknown auth provider '%s' in auth.providers", name)
+		}
+	}
+	return chain, nil
+}
+
+// Resolve returns the credentials of the first provider that has some, with
+// every provider's answer up to that one
+func (c *AuthChain) Resolve(repoURL string) (*Credentials, []AuthAttempt, error) {
+	attempts := []AuthAttempt{}
+	for _, provider := range c.Providers {
+		creds, err := provider.Credentials(repoURL)
+		attempts = append(attempts, AuthAttempt{Provider: provider.Name(), Err: err})
+		if err == nil {
+			return creds, attempts, nil
+		}
+	}
+	return nil, attempts, fmt.Errorf("No authentication credentials found for %s", repoURL)
+}
+
+// lookupAuth returns the credentials the configured chain finds for a URL
+func lookupAuth(repoURL string) (*Credentials, error) {
+	return lookupAuthWithJWT(repoURL, "")
+}
+
+// lookupAuthWithJWT is lookupAuth with a token from the command line
+func lookupAuthWithJWT(repoURL, jwt string) (*Credentials, error) {
+	chain, err := newAuthChain(jwt)
+	if err != nil {
+		return nil, err
+	}
+	creds, _, err := chain.Resolve(repoURL)
+	return creds, err
+}
+
+// authForRepo returns the credentials for a URL, exiting if there are none
+func authForRepo(repoURL string) *Credentials {
+	creds, err := lookupAuth(repoURL)
+	if err != nil {
+		fmt.Printf("%s. Log in first with mgit login <url>.\n", err)
+		os.Exit(1)
+	}
+	return creds
+}
+
+// flagAuthProvider offers a token given on the command line
+type flagAuthProvider struct {
+	token string
+}
+
+func (p *flagAuthProvider) Name() string { return "flag" }
+
+func (p *flagAuthProvider) Credentials(repoURL string) (*Credentials, error) {
+	if p.token == "" {
+		return nil, fmt.Errorf("no -jwt token given")
+	}
+	return &Credentials{Provider: p.Name(), Scheme: "Bearer", Value: p.token, Source: "-jwt flag"}, nil
+}
+
+// envAuthProvider reads credentials from the environment
+type envAuthProvider struct{}
+
+func (envAuthProvider) Name() string { return "env" }
+
+func (p envAuthProvider) Credentials(repoURL string) (*Credentials, error) {
+	user, password := os.Getenv("MGIT_USERNAME"), os.Getenv("MGIT_PASSWORD")
+	if user == "" {
+		return nil, fmt.Errorf("MGIT_USERNAME is not set")
+	}
+	value := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	return &Credentials{Provider: p.Name(), Scheme: "Basic", Value: value, Source: "MGIT_USERNAME and MGIT_PASSWORD"}, nil
+}
+
+// tokenStoreAuthProvider uses the JWTs saved by mgit login or the web interface
+type tokenStoreAuthProvider struct{}
+
+func (tokenStoreAuthProvider) Name() string { return "token-store" }
+
+func (p tokenStoreAuthProvider) Credentials(repoURL string) (*Credentials, error) {
+	token, err := lookupTokenForRepo(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if token, err = freshToken(token); err != nil {
+		return nil, err
+	}
+	return token.credentials(), nil
+}
+
+// HandleAuth handles the auth command
+func HandleAuth(args []string) {
+	if len(args) < 2 || args[0] != "explain" {
+		fmt.Println("Usage: mgit auth explain <url> [-jwt <token>]")
+		os.Exit(1)
+	}
+	repoURL := strings.TrimSuffix(args[1], "/")
+	jwt := ""
+	if len(args) == 4 && args[2] == "-jwt" {
+		jwt = args[3]
+	} else if len(args) != 2 {
+		fmt.Println("Usage: mgit auth explain <url> [-jwt <token>]")
+		os.Exit(1)
+	}
+
+	if !isServerURL(repoURL) {
+		fmt.Printf("%s is not an mgit server URL; git uses its own transport (local path or ssh) and no provider is asked\n", repoURL)
+		return
+	}
+
+	chain, err := newAuthChain(jwt)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	names := make([]string, len(chain.Providers))
+	for i, provider := range chain.Providers {
+		names[i] = provider.Name()
+	}
+	fmt.Printf("Provider chain: %s\n", strings.Join(names, ", "))
+
+	creds, attempts, err := chain.Resolve(repoURL)
+	for i, attempt := range attempts {
+		if attempt.Err != nil {
+			fmt.Printf("  %d. %-14s skipped: %s\n", i+1, attempt.Provider, attempt.Err)
+		} else {
+			fmt.Printf("  %d. %-14s has credentials\n", i+1, attempt.Provider)
+		}
+	}
+	for i := len(attempts); i < len(chain.Providers); i++ {
+		fmt.Printf("  %d. %-14s not asked\n", i+1, chain.Providers[i].Name())
+	}
+	if err != nil {
+		fmt.Printf("No provider has credentials for %s\n", repoURL)
+		os.Exit(1)
+	}
+	fmt.Printf("Using %s (%s auth from %s)\n", creds.Provider, creds.Scheme, creds.Source)
+}
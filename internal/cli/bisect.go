@@ -0,0 +1,397 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// Bisect finds the commit that introduced a change by binary search. The
+// session's state lives in .mgit/bisect: the branch (or commit) HEAD was on
+// before it started, and the git hashes marked bad, good and skipped.
+// Commits are named by git or MGit hash alike, and reported by MGit hash.
+// Each step checks out the candidate that splits the remaining range most
+// evenly, with git itself, as checkout does.
+
+const bisectUsage = `Usage: mgit bisect start [<bad> [<good>...]]
+       mgit bisect bad [<commit>]
+       mgit bisect good|skip [<commit>...]
+       mgit bisect run <cmd> [<args>...]
+       mgit bisect reset [<commit>]`
+
+// bisectSkipExit is the exit status with which a bisect run command marks
+// the commit untestable, as with git bisect run
+const bisectSkipExit = 125
+
+// BisectState is an ongoing bisect session
+type BisectState struct {
+	Start string   `json:"start"` // Branch ref HEAD was on, or its commit when detached
+	Bad   string   `json:"bad,omitempty"`
+	Good  []string `json:"good,omitempty"`
+	Skip  []string `json:"skip,omitempty"`
+}
+
+// bisectPath returns the file holding the bisect state
+func (s *MGitStorage) bisectPath() string {
+	return filepath.Join(s.RootDir, "bisect")
+}
+
+// GetBisectState returns the ongoing bisect session, or nil if there is none
+func (s *MGitStorage) GetBisectState() (*BisectState, error) {
+	data, err := os.ReadFile(s.bisectPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bisect state: %w", err)
+	}
+	state := &BisectState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse bisect state: %w", err)
+	}
+	return state, nil
+}
+
+// SaveBisectState records the bisect session
+func (s *MGitStorage) SaveBisectState(state *BisectState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bisect state: %w", err)
+	}
+	if err := fsutil.WriteFile(s.bisectPath(), data); err != nil {
+		return fmt.Errorf("failed to write bisect state: %w", err)
+	}
+	return nil
+}
+
+// HandleBisect handles the bisect command
+func HandleBisect(args []string) {
+	if len(args) == 0 {
+		fmt.Println(bisectUsage)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "start":
+		bisectStep(func(repo *git.Repository, storage *MGitStorage) (bool, error) {
+			return bisectStart(repo, storage, args[1:])
+		})
+	case "good", "bad", "skip":
+		if args[0] == "bad" && len(args) > 2 {
+			fmt.Println(bisectUsage)
+			os.Exit(1)
+		}
+		bisectStep(func(repo *git.Repository, storage *MGitStorage) (bool, error) {
+			state, err := currentBisect(storage)
+			if err != nil {
+				return false, err
+			}
+			if err := markBisect(repo, storage, state, args[0], args[1:]); err != nil {
+				return false, err
+			}
+			return bisectNext(repo, state)
+		})
+	case "run":
+		if len(args) < 2 {
+			fmt.Println(bisectUsage)
+			os.Exit(1)
+		}
+		bisectRun(args[1:])
+	case "reset":
+		if len(args) > 2 {
+			fmt.Println(bisectUsage)
+			os.Exit(1)
+		}
+		bisectStep(func(repo *git.Repository, storage *MGitStorage) (bool, error) {
+			return true, bisectReset(repo, storage, args[1:])
+		})
+	default:
+		fmt.Println(bisectUsage)
+		os.Exit(1)
+	}
+}
+
+// bisectStep runs one bisect step under the repository lock. The lock is
+// released before an error exits, and between the steps of bisect run so
+// the command it tests can use mgit. It returns whether the bisect is over.
+func bisectStep(fn func(*git.Repository, *MGitStorage) (bool, error)) bool {
+	lock := lockRepoOrExit("bisect")
+	done, err := fn(getRepo(), NewMGitStorage())
+	lock.Release()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	return done
+}
+
+// currentBisect returns the ongoing bisect session, failing if there is none
+func currentBisect(storage *MGitStorage) (*BisectState, error) {
+	state, err := storage.GetBisectState()
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("not bisecting (start with mgit bisect start)")
+	}
+	return state, nil
+}
+
+// bisectStart begins a bisect session, optionally marking the bad commit and
+// good ones up front
+func bisectStart(repo *git.Repository, storage *MGitStorage, args []string) (bool, error) {
+	if state, err := storage.GetBisectState(); err != nil {
+		return false, err
+	} else if state != nil {
+		return false, fmt.Errorf("already bisecting (mgit bisect reset ends it)")
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return false, fmt.Errorf("error getting HEAD: %w", err)
+	}
+	state := &BisectState{Start: head.Hash().String()}
+	if head.Name().IsBranch() {
+		state.Start = head.Name().String()
+	}
+
+	if len(args) > 0 {
+		if err := markBisect(repo, storage, state, "bad", args[:1]); err != nil {
+			return false, err
+		}
+	}
+	if len(args) > 1 {
+		if err := markBisect(repo, storage, state, "good", args[1:]); err != nil {
+			return false, err
+		}
+	}
+	if err := storage.SaveBisectState(state); err != nil {
+		return false, err
+	}
+	return bisectNext(repo, state)
+}
+
+// markBisect marks commits (HEAD when revs is empty) good, bad or skipped and
+// saves the state
+func markBisect(repo *git.Repository, storage *MGitStorage, state *BisectState, term string, revs []string) error {
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	for _, rev := range revs {
+		revision, err := Resolve(repo, storage, rev)
+		if err != nil {
+			return err
+		}
+		switch term {
+		case "bad":
+			state.Bad = revision.Git.String()
+		case "good":
+			state.Good = appendUnique(state.Good, revision.Git.String())
+		case "skip":
+			state.Skip = appendUnique(state.Skip, revision.Git.String())
+		}
+	}
+	return storage.SaveBisectState(state)
+}
+
+// bisectNext checks out the next commit to test, or reports the first bad
+// commit. It returns true once there is nothing left to test.
+func bisectNext(repo *git.Repository, state *BisectState) (bool, error) {
+	if state.Bad == "" || len(state.Good) == 0 {
+		fmt.Println("Waiting for both a good and a bad commit (mgit bisect good|bad)")
+		return false, nil
+	}
+
+	// The candidates are the bad commit and its ancestors that no good
+	// commit has, newest first
+	good := map[plumbing.Hash]bool{}
+	goodTips := []plumbing.Hash{}
+	for _, hash := range state.Good {
+		goodTips = append(goodTips, plumbing.NewHash(hash))
+	}
+	walkCommits(repo, goodTips, nil, func(c *object.Commit) { good[c.Hash] = true })
+	bad := plumbing.NewHash(state.Bad)
+	if good[bad] {
+		return false, fmt.Errorf("bad commit %s is an ancestor of a good commit", commitLabel(bad))
+	}
+	candidates := []*object.Commit{}
+	walkCommits(repo, []plumbing.Hash{bad}, good, func(c *object.Commit) {
+		candidates = append(candidates, c)
+	})
+
+	skipped := map[plumbing.Hash]bool{}
+	for _, hash := range state.Skip {
+		skipped[plumbing.NewHash(hash)] = true
+	}
+
+	// Testing a commit settles either its ancestors in the range (if good)
+	// or everything else (if bad); the best one splits the range evenly
+	total := len(candidates)
+	var best *object.Commit
+	bestAncestors := 0
+	for _, candidate := range candidates {
+		if candidate.Hash == bad || skipped[candidate.Hash] {
+			continue
+		}
+		ancestors := rangeAncestors(repo, candidate.Hash, good)
+		if best == nil || min(ancestors, total-ancestors) > min(bestAncestors, total-bestAncestors) {
+			best, bestAncestors = candidate, ancestors
+		}
+	}
+
+	if best == nil {
+		if total == 1 {
+			fmt.Printf("%s is the first bad commit\n", commitLabel(bad))
+			writeCommit(os.Stdout, candidates[0])
+			return true, nil
+		}
+		fmt.Println("There are only skipped commits left to test.")
+		fmt.Println("The first bad commit could be any of:")
+		for _, candidate := range candidates {
+			fmt.Printf("%s %s\n", commitLabel(candidate.Hash), commitSubject(candidate.Message))
+		}
+		return true, nil
+	}
+
+	if conflicts, err := checkoutConflicts(repo, best.Hash); err != nil {
+		return false, err
+	} else if len(conflicts) > 0 {
+		return false, fmt.Errorf("local changes to %s would be overwritten by checking out %s; commit or restore them first",
+			strings.Join(conflicts, ", "), commitLabel(best.Hash))
+	}
+	if err := runGitCheckout("--detach", best.Hash.String()); err != nil {
+		return false, fmt.Errorf("error checking out %s: %w", commitLabel(best.Hash), err)
+	}
+	syncMGitHead(repo, "bisect: moving to "+commitLabel(best.Hash))
+
+	left := total - min(bestAncestors, total-bestAncestors) - 1
+	fmt.Printf("Bisecting: %d revision(s) left to test after this (roughly %d step(s))\n", left, bits.Len(uint(left)))
+	fmt.Printf("[%s] %s\n", commitLabel(best.Hash), commitSubject(best.Message))
+	return false, nil
+}
+
+// rangeAncestors counts hash and its ancestors that no good commit has
+func rangeAncestors(repo *git.Repository, hash plumbing.Hash, good map[plumbing.Hash]bool) int {
+	count := 0
+	walkCommits(repo, []plumbing.Hash{hash}, good, func(*object.Commit) {
+		count++
+	})
+	return count
+}
+
+// commitLabel names a git commit by its MGit hash where it has one
+func commitLabel(hash plumbing.Hash) string {
+	if mgitHash := GetMGitHashForCommit(hash); mgitHash != "" {
+		return mgitHash
+	}
+	return hash.String()
+}
+
+// bisectReset ends the bisect session and checks out the branch (or commit)
+// it started on, or commit if given
+func bisectReset(repo *git.Repository, storage *MGitStorage, args []string) error {
+	state, err := storage.GetBisectState()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		fmt.Println("Not bisecting")
+		return nil
+	}
+
+	target := state.Start
+	if len(args) > 0 {
+		revision, err := Resolve(repo, storage, args[0])
+		if err != nil {
+			return err
+		}
+		target = revision.Git.String()
+	}
+
+	name := plumbing.ReferenceName(target)
+	if name.IsBranch() {
+		err = runGitCheckout(name.Short())
+	} else {
+		err = runGitCheckout("--detach", target)
+	}
+	if err != nil {
+		return fmt.Errorf("error checking out %s: %w", target, err)
+	}
+	syncMGitHead(repo, "bisect: resetting")
+	if err := os.Remove(storage.bisectPath()); err != nil {
+		return fmt.Errorf("failed to remove bisect state: %w", err)
+	}
+
+	if name.IsBranch() {
+		fmt.Printf("Switched to branch '%s'\n", name.Short())
+	} else {
+		fmt.Printf("Checked out commit %s\n", commitLabel(plumbing.NewHash(target)))
+	}
+	return nil
+}
+
+// bisectRun runs a command on each commit bisect checks out, marking it by
+// the exit status: 0 good, 125 skip, 1-127 bad. Any other status, or a
+// command that can't be started, stops the run.
+func bisectRun(command []string) {
+	storage := NewMGitStorage()
+	state, err := currentBisect(storage)
+	if err == nil && (state.Bad == "" || len(state.Good) == 0) {
+		err = fmt.Errorf("bisect run needs a good and a bad commit first")
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	for {
+		fmt.Printf("running %s\n", strings.Join(command, " "))
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		status := 0
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				fmt.Printf("Error: bisect run failed to run %s: %s\n", command[0], err)
+				os.Exit(1)
+			}
+			status = exitErr.ExitCode()
+		}
+
+		term := "bad"
+		switch {
+		case status == 0:
+			term = "good"
+		case status == bisectSkipExit:
+			term = "skip"
+		case status < 0 || status >= 128:
+			fmt.Printf("Error: bisect run stopped: %s exited with status %d\n", command[0], status)
+			os.Exit(1)
+		}
+
+		done := bisectStep(func(repo *git.Repository, storage *MGitStorage) (bool, error) {
+			state, err := currentBisect(storage)
+			if err != nil {
+				return false, err
+			}
+			if err := markBisect(repo, storage, state, term, nil); err != nil {
+				return false, err
+			}
+			return bisectNext(repo, state)
+		})
+		if done {
+			return
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package cli
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// An MGit bundle carries history between repositories that can't reach each
+// other, on a USB stick or the like. It is a tar file holding:
+//
+//	mgit-bundle.json             the bundled refs, with git and MGit hashes
+//	git.bundle                   a git bundle of the refs, from git bundle create
+//	objects/<xx>/<rest>          the MGit commit objects of the bundled commits
+//	                             and their signed annotations, as in .mgit/objects
+//	mappings/hash_mappings.json  the git/MGit mappings of the bundled commits
+//
+// Unbundling checks every MGit object against its hash and its git commit,
+// and every mapping against its MGit commit, before storing any of them, so
+// a tampered bundle is refused as a whole.
+
+const bundleUsage = `Usage: mgit bundle create <file> <git-rev-list-args>...
+       mgit bundle unbundle <file>`
+
+// bundleVersion is the version of the bundle layout written
+const bundleVersion = 1
+
+// BundleManifest lists what a bundle carries
+type BundleManifest struct {
+	Version int         `json:"version"`
+	Refs    []BundleRef `json:"refs"`
+}
+
+// BundleRef is a ref in a bundle
+type BundleRef struct {
+	Name     string `json:"name"`
+	GitHash  string `json:"git_hash"`
+	MGitHash string `json:"mgit_hash,omitempty"`
+}
+
+// bundleObject is an MGit object read from a bundle
+type bundleObject struct {
+	Hash string
+	Type mgitlib.ObjectType
+	Body []byte
+}
+
+// HandleBundle handles the bundle command
+func HandleBundle(args []string) {
+	var err error
+	switch {
+	case len(args) >= 3 && args[0] == "create":
+		err = createBundle(args[1], args[2:])
+	case len(args) == 2 && args[0] == "unbundle":
+		err = unbundle(args[1])
+	default:
+		fmt.Println(bundleUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// createBundle writes a bundle of the commits revs selects, as git bundle
+// create takes them
+func createBundle(path string, revs []string) error {
+	session := currentSession()
+	repo := session.MustRepo()
+	storage := session.Storage()
+
+	tmpDir, err := os.MkdirTemp("", "mgit-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	gitBundle := filepath.Join(tmpDir, "git.bundle")
+	if err := runGitIn(session.Path, append([]string{"bundle", "create", gitBundle}, revs...)...); err != nil {
+		return fmt.Errorf("error creating git bundle: %w", err)
+	}
+
+	manifest, objects, mappings, err := bundleContents(repo, storage, gitBundle)
+	if err != nil {
+		return err
+	}
+	if err := writeBundle(path, manifest, gitBundle, objects, mappings, storage); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error writing bundle: %w", err)
+	}
+	fmt.Printf("Bundled %d ref(s), %d MGit object(s) and %d mapping(s) into %s\n",
+		len(manifest.Refs), len(objects), len(mappings), path)
+	return nil
+}
+
+// bundleContents works out the refs, MGit objects and mappings that go with
+// a git bundle: everything for the commits it carries
+func bundleContents(repo *git.Repository, storage *MGitStorage, gitBundle string) (*BundleManifest, []string, []NostrCommitMapping, error) {
+	heads, prerequisites, err := readGitBundleHeader(gitBundle)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	manifest := &BundleManifest{Version: bundleVersion}
+	tips := []plumbing.Hash{}
+	for _, head := range heads {
+		revision, _ := Resolve(repo, storage, head.GitHash)
+		head.MGitHash = revision.MGit
+		manifest.Refs = append(manifest.Refs, head)
+		tips = append(tips, revision.Git)
+	}
+
+	// The commits the bundle carries are those its prerequisites don't have
+	have := map[plumbing.Hash]bool{}
+	walkCommits(repo, prerequisites, nil, func(c *object.Commit) { have[c.Hash] = true })
+	objects := []string{}
+	mappings := []NostrCommitMapping{}
+	unmapped := 0
+	walkCommits(repo, tips, have, func(c *object.Commit) {
+		mapping, ok, err := storage.Mappings().ByGit(c.Hash.String())
+		if err != nil || !ok {
+			unmapped++
+			return
+		}
+		mapping.Source = ""
+		mappings = append(mappings, mapping)
+		objects = append(objects, mapping.MGitHash)
+	})
+	if unmapped > 0 {
+		fmt.Printf("Warning: %d bundled commit(s) have no MGit hash (run mgit migrate); they can't be verified\n", unmapped)
+	}
+
+	for _, hash := range append([]string{}, objects...) {
+		annotations, err := storage.annotationHashes(hash)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		objects = append(objects, annotations...)
+	}
+	return manifest, objects, mappings, nil
+}
+
+// readGitBundleHeader reads the refs and prerequisite commits from a git
+// bundle's header
+func readGitBundleHeader(path string) ([]BundleRef, []plumbing.Hash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	signature, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(signature, "# v") || !strings.Contains(signature, "git bundle") {
+		return nil, nil, fmt.Errorf("%s is not a git bundle", path)
+	}
+	heads := []BundleRef{}
+	prerequisites := []plumbing.Hash{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("truncated git bundle header: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return heads, prerequisites, nil
+		case strings.HasPrefix(line, "@"):
+			// v3 capabilities
+		case strings.HasPrefix(line, "-"):
+			fields := strings.Fields(line[1:])
+			if len(fields) > 0 {
+				prerequisites = append(prerequisites, plumbing.NewHash(fields[0]))
+			}
+		default:
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return nil, nil, fmt.Errorf("malformed git bundle ref line: %s", line)
+			}
+			heads = append(heads, BundleRef{Name: fields[1], GitHash: fields[0]})
+		}
+	}
+}
+
+// writeBundle writes the bundle file
+func writeBundle(path string, manifest *BundleManifest, gitBundle string, objects []string, mappings []NostrCommitMapping, storage *MGitStorage) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	archive := &tarArchive{w: tar.NewWriter(file), when: time.Now()}
+
+	err = func() error {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := archive.addFile("mgit-bundle.json", 0644, data); err != nil {
+			return err
+		}
+		data, err = os.ReadFile(gitBundle)
+		if err != nil {
+			return err
+		}
+		if err := archive.addFile("git.bundle", 0644, data); err != nil {
+			return err
+		}
+		for _, hash := range objects {
+			if err := addArchiveObject(archive, storage, "", hash); err != nil {
+				return err
+			}
+		}
+		data, err = json.MarshalIndent(mappings, "", "  ")
+		if err != nil {
+			return err
+		}
+		return archive.addFile("mappings/hash_mappings.json", 0644, data)
+	}()
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// unbundle imports a bundle's git objects, then its MGit objects and
+// mappings once they all check out, and lists the refs it carries
+func unbundle(path string) error {
+	session := currentSession()
+	session.MustRepo()
+	storage := session.Storage()
+	if err := storage.Initialize(); err != nil {
+		return fmt.Errorf("error initializing MGit storage: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "mgit-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	lock, err := storage.LockRepo("bundle")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	manifest, err := importBundle(session, path, tmpDir)
+	if err != nil {
+		return err
+	}
+	for _, ref := range manifest.Refs {
+		fmt.Printf("%s %s\n", orNone(ref.MGitHash), ref.Name)
+	}
+	return nil
+}
+
+// importBundle reads the bundle at path, unbundling its git objects through
+// tmpDir, and stores its MGit objects and mappings after checking them
+func importBundle(session *Session, path, tmpDir string) (*BundleManifest, error) {
+	repo := session.MustRepo()
+	storage := session.Storage()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest *BundleManifest
+	var mappings []NostrCommitMapping
+	gitBundle := ""
+	objects := []bundleObject{}
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an MGit bundle: %w", path, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s from the bundle: %w", header.Name, err)
+		}
+
+		switch name := header.Name; {
+		case name == "mgit-bundle.json":
+			manifest = &BundleManifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, fmt.Errorf("unreadable bundle manifest: %w", err)
+			}
+		case name == "git.bundle":
+			gitBundle = filepath.Join(tmpDir, "git.bundle")
+			if err := os.WriteFile(gitBundle, data, 0644); err != nil {
+				return nil, err
+			}
+		case name == "mappings/hash_mappings.json":
+			if err := json.Unmarshal(data, &mappings); err != nil {
+				return nil, fmt.Errorf("unreadable bundle mappings: %w", err)
+			}
+		case strings.HasPrefix(name, "objects/"):
+			hash := strings.ReplaceAll(strings.TrimPrefix(name, "objects/"), "/", "")
+			if !isFullHash(hash) {
+				return nil, fmt.Errorf("unexpected bundle entry %s", name)
+			}
+			objType, body, err := decodeObject(data)
+			if err == nil {
+				objType, err = checkObjectBody(hash, objType, body)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("MGit object %s is corrupt: %w", shortHash(hash), err)
+			}
+			objects = append(objects, bundleObject{Hash: hash, Type: objType, Body: body})
+		}
+	}
+	if manifest == nil || gitBundle == "" {
+		return nil, fmt.Errorf("%s is not an MGit bundle", path)
+	}
+	if manifest.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+
+	// git checks that the commits the bundle builds on are here
+	if err := runGitIn(session.Path, "bundle", "verify", gitBundle); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "bundle", "unbundle", gitBundle)
+	cmd.Dir = session.Path
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("git bundle unbundle: %s", strings.TrimSpace(string(output)))
+	}
+
+	// Every MGit commit must be what its git commit and pubkey hash to, and
+	// every mapping must name a commit the bundle or this repository has
+	commits := map[string]*mgitlib.Commit{}
+	for _, obj := range objects {
+		if obj.Type != mgitlib.CommitObject {
+			continue
+		}
+		commit, err := mgitlib.ParseCommit(obj.Hash, obj.Body)
+		if err != nil {
+			return nil, err
+		}
+		gitCommit, err := repo.CommitObject(plumbing.NewHash(commit.GitHash))
+		if err != nil {
+			return nil, fmt.Errorf("MGit commit %s names git commit %s, which the bundle lacks", shortHash(obj.Hash), shortHash(commit.GitHash))
+		}
+		expected := mgitlib.GitHashInput(gitCommit, commit.ParentHashes, commit.Author.Pubkey)
+		expected.Format = commit.Format
+		if mgitlib.Hash(expected).String() != obj.Hash {
+			return nil, fmt.Errorf("MGit commit %s does not match git commit %s", shortHash(obj.Hash), shortHash(commit.GitHash))
+		}
+		commits[obj.Hash] = commit
+	}
+	for i, mapping := range mappings {
+		commit, ok := commits[mapping.MGitHash]
+		if !ok {
+			if local, err := storage.GetCommit(mapping.MGitHash); err == nil {
+				commit, ok = local, true
+			}
+		}
+		if !ok || commit.GitHash != mapping.GitHash || commit.Author.Pubkey != mapping.Pubkey {
+			return nil, fmt.Errorf("mapping %s -> %s does not match an MGit commit", shortHash(mapping.GitHash), shortHash(mapping.MGitHash))
+		}
+		mappings[i].Source = mappingSourceBundle
+	}
+
+	for _, obj := range objects {
+		if obj.Type == mgitlib.AnnotationObject {
+			annotation := &MAnnotationStruct{}
+			if err := json.Unmarshal(obj.Body, annotation); err != nil {
+				return nil, fmt.Errorf("unreadable annotation %s: %w", shortHash(obj.Hash), err)
+			}
+			err = storage.StoreAnnotation(annotation)
+		} else {
+			err = storage.writeObject(obj.Hash, obj.Type, obj.Body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := storage.Mappings().PutAll(mappings); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Imported %d MGit object(s) and %d mapping(s) from %s\n", len(objects), len(mappings), path)
+	return manifest, nil
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Long operations (clone, verify, metadata fetches and uploads, relay
+// publishes and queries) run under the command context. The first SIGINT or
+// SIGTERM cancels it: git processes started under it are killed, server
+// requests and relay exchanges are abandoned, and the operation returns
+// context.Canceled so its caller can clean up partial state before the
+// command exits with status 130. A second signal exits at once.
+//
+// The handler is installed the first time a command asks for the context,
+// so commands that never do keep the default behavior of dying on Ctrl-C.
+
+// interruptedExitCode is the status of a command ended by an interrupt
+const interruptedExitCode = 130
+
+var (
+	commandCtx     context.Context
+	commandCtxOnce sync.Once
+)
+
+// commandContext returns the context of the running command, canceled when
+// the process is interrupted
+func commandContext() context.Context {
+	commandCtxOnce.Do(func() {
+		var cancel context.CancelFunc
+		commandCtx, cancel = context.WithCancel(context.Background())
+
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			fmt.Println("\nInterrupted; cleaning up (interrupt again to exit now)")
+			cancel()
+			<-signals
+			os.Exit(interruptedExitCode)
+		}()
+	})
+	return commandCtx
+}
+
+// contextErr returns ctx's error in place of err once ctx is done, so an
+// operation killed by cancellation reports why rather than how it died
+func contextErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// isInterrupted reports whether err, or the command, was canceled
+func isInterrupted(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return true
+	}
+	return commandCtx != nil && commandCtx.Err() != nil
+}
+
+// exitIfInterrupted ends the command with the interrupted status when err
+// comes from an interrupt; callers clean up before calling it
+func exitIfInterrupted(err error) {
+	if err != nil && isInterrupted(err) {
+		fmt.Println("Interrupted")
+		os.Exit(interruptedExitCode)
+	}
+}
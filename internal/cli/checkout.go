@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// CheckoutMode controls how a checkout treats uncommitted changes
+type CheckoutMode int
+
+const (
+	// checkoutSafe refuses to switch when local changes would be overwritten
+	checkoutSafe CheckoutMode = iota
+	// checkoutForce discards local changes (--force)
+	checkoutForce
+	// checkoutMerge carries local changes over with a three-way merge (--merge)
+	checkoutMerge
+)
+
+// gitArgs prefixes args with the git checkout flag for the mode
+func (m CheckoutMode) gitArgs(args ...string) []string {
+	switch m {
+	case checkoutForce:
+		return append([]string{"--force"}, args...)
+	case checkoutMerge:
+		return append([]string{"--merge"}, args...)
+	}
+	return args
+}
+
+// requireSafeCheckout aborts when switching to target would clobber
+// uncommitted changes, listing the files involved. Force and merge modes
+// skip the check since the caller asked for the changes to be handled.
+func requireSafeCheckout(repo *git.Repository, target plumbing.Hash, mode CheckoutMode) {
+	if mode != checkoutSafe {
+		return
+	}
+
+	conflicts, err := checkoutConflicts(repo, target)
+	if err != nil {
+		fmt.Printf("Error checking working tree: %s\n", err)
+		os.Exit(1)
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+
+	fmt.Println("Error: your local changes to the following files would be overwritten by checkout:")
+	for _, file := range conflicts {
+		fmt.Printf("  %s\n", file)
+	}
+	fmt.Println("Commit your changes, or rerun with --merge to carry them over")
+	fmt.Println("or --force to discard them.")
+	os.Exit(1)
+}
+
+// checkoutConflicts returns the tracked files with uncommitted changes that
+// differ between HEAD and target
+func checkoutConflicts(repo *git.Repository, target plumbing.Hash) ([]string, error) {
+	var status git.Status
+	var err error
+	if cryptInUse() {
+		status, err = scopedStatus(".", nil)
+	} else {
+		status, err = worktreeStatus(repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting status: %w", err)
+	}
+
+	dirty := map[string]bool{}
+	for file, fileStatus := range status {
+		if fileStatus.Staging == git.Untracked && fileStatus.Worktree == git.Untracked {
+			continue
+		}
+		if fileStatus.Staging != git.Unmodified || fileStatus.Worktree != git.Unmodified {
+			dirty[file] = true
+		}
+	}
+	if len(dirty) == 0 {
+		return nil, nil
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		// No commits yet - any dirty file is at risk
+		return sortedKeys(dirty), nil
+	}
+
+	changed, err := changedFiles(repo, head.Hash(), target)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []string{}
+	for file := range dirty {
+		if changed[file] {
+			conflicts = append(conflicts, file)
+		}
+	}
+	sort.Strings(conflicts)
+
+	return conflicts, nil
+}
+
+// changedFiles returns the set of paths that differ between two commits
+func changedFiles(repo *git.Repository, from, to plumbing.Hash) (map[string]bool, error) {
+	changed := map[string]bool{}
+	if from == to {
+		return changed, nil
+	}
+
+	fromTree, err := commitTree(repo, from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := commitTree(repo, to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("error comparing trees: %w", err)
+	}
+	for _, change := range changes {
+		if change.From.Name != "" {
+			changed[change.From.Name] = true
+		}
+		if change.To.Name != "" {
+			changed[change.To.Name] = true
+		}
+	}
+
+	return changed, nil
+}
+
+// commitTree loads the tree of a commit
+func commitTree(repo *git.Repository, hash plumbing.Hash) (*object.Tree, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("error getting commit %s: %w", shortHash(hash.String()), err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting tree for %s: %w", shortHash(hash.String()), err)
+	}
+	return tree, nil
+}
+
+// sortedKeys returns the keys of a set in sorted order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
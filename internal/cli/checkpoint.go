@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// mgit verify records the commits it has verified in .mgit/verified, one
+// MGit hash per line. Hashes commit to their whole ancestry, so a later
+// verify stops walking at any recorded commit and only checks what was added
+// since; mgit verify --full ignores the file. Only the newest
+// maxVerifiedCheckpoints tips are kept.
+
+const maxVerifiedCheckpoints = 32
+
+// verifiedPath returns the path of the verify checkpoint file
+func (s *MGitStorage) verifiedPath() string {
+	return filepath.Join(s.RootDir, "verified")
+}
+
+// VerifiedCheckpoints returns the commits a previous verify checked, with
+// all of their ancestors
+func (s *MGitStorage) VerifiedCheckpoints() (map[string]bool, error) {
+	data, err := os.ReadFile(s.verifiedPath())
+	if os.IsNotExist(err) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read verify checkpoint: %w", err)
+	}
+	checkpoints := map[string]bool{}
+	for _, hash := range strings.Fields(string(data)) {
+		if isFullHash(hash) {
+			checkpoints[hash] = true
+		}
+	}
+	return checkpoints, nil
+}
+
+// AddVerifiedCheckpoint records that hash and its ancestry verified
+func (s *MGitStorage) AddVerifiedCheckpoint(hash string) error {
+	lock, err := fsutil.LockFile(s.verifiedPath(), fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	hashes := []string{hash}
+	if data, err := os.ReadFile(s.verifiedPath()); err == nil {
+		for _, existing := range strings.Fields(string(data)) {
+			if existing != hash && isFullHash(existing) && len(hashes) < maxVerifiedCheckpoints {
+				hashes = append(hashes, existing)
+			}
+		}
+	}
+	return fsutil.WriteFile(s.verifiedPath(), []byte(strings.Join(hashes, "\n")+"\n"))
+}
@@ -0,0 +1,992 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+	serverclient "github.com/imyjimmy/mgit/pkg/server-client"
+)
+
+// AuthToken represents an authentication token for a repository
+type AuthToken struct {
+	Token        string `json:"token"`
+	RepoURL      string `json:"repoUrl"`
+	Access       string `json:"access"`
+	RefreshToken string `json:"refreshToken,omitempty"` // From mgit login, when the server issues one
+	Server       string `json:"server,omitempty"`       // Set for a server-wide token from mgit server add
+}
+
+// TokenStore represents the token storage in mgitconfig
+type TokenStore struct {
+	Tokens []AuthToken `json:"tokens"`
+}
+
+// CloneOptions represents options for the clone command
+type CloneOptions struct {
+	NoCheckout   bool
+	Depth        int
+	Branch       string
+	SingleBranch bool
+	Force        bool // Allow cloning into a non-empty directory
+	Resumable    bool // Keep partial state on failure so a re-run can resume
+	Partial      string // Only check out this directory; fetch other blobs on demand
+}
+
+// gitArgs returns the git clone flags for these options
+func (o *CloneOptions) gitArgs() []string {
+	args := []string{}
+	if o.NoCheckout {
+		args = append(args, "--no-checkout")
+	}
+	if o.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(o.Depth))
+	}
+	if o.Branch != "" {
+		args = append(args, "--branch", o.Branch)
+	}
+	if o.SingleBranch {
+		args = append(args, "--single-branch")
+	}
+	if o.Partial != "" {
+		args = append(args, "--filter=blob:none", "--sparse")
+	}
+	return args
+}
+
+const cloneUsage = "Usage: mgit clone [-jwt <token>] [--depth <n>] [--branch <name>] [--single-branch] [--no-checkout] [--force] [--resumable] [--partial <path-prefix>] [--verbose] <url|nostr:naddr> [destination]"
+
+// HandleClone handles the clone command
+func HandleClone(args []string) {
+	if len(args) < 1 {
+		fmt.Println(cloneUsage)
+		os.Exit(1)
+	}
+
+	// Parse arguments for -jwt flag
+	var jwtToken string
+	var url string
+	var destination string
+	opts := &CloneOptions{}
+	
+	// flagValue consumes the value of a flag given as "--flag value" or "--flag=value"
+	i := 0
+	flagValue := func(name string) string {
+		if strings.HasPrefix(args[i], name+"=") {
+			i++
+			return strings.TrimPrefix(args[i-1], name+"=")
+		}
+		if i+1 >= len(args) {
+			fmt.Printf("Error: %s flag requires an argument\n", name)
+			fmt.Println(cloneUsage)
+			os.Exit(1)
+		}
+		i += 2
+		return args[i-1]
+	}
+	
+	// Parse command line arguments
+	for i < len(args) {
+		arg := args[i]
+		if arg == "-jwt" {
+			jwtToken = flagValue("-jwt")
+		} else if arg == "--depth" || strings.HasPrefix(arg, "--depth=") {
+			depth, err := strconv.Atoi(flagValue("--depth"))
+			if err != nil || depth < 1 {
+				fmt.Println("Error: --depth must be a positive number")
+				os.Exit(1)
+			}
+			opts.Depth = depth
+		} else if arg == "-b" {
+			opts.Branch = flagValue("-b")
+		} else if arg == "--branch" || strings.HasPrefix(arg, "--branch=") {
+			opts.Branch = flagValue("--branch")
+		} else if arg == "--single-branch" {
+			opts.SingleBranch = true
+			i++
+		} else if arg == "--no-checkout" || arg == "-n" {
+			opts.NoCheckout = true
+			i++
+		} else if arg == "--force" || arg == "-f" {
+			opts.Force = true
+			i++
+		} else if arg == "--resumable" {
+			opts.Resumable = true
+			i++
+		} else if arg == "--partial" || strings.HasPrefix(arg, "--partial=") {
+			prefix, err := normalizePartialPrefix(flagValue("--partial"))
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+			opts.Partial = prefix
+		} else if arg == "--verbose" || arg == "-v" {
+			if !logEnabled(logVerbose) {
+				verbosity = logVerbose
+			}
+			i++
+		} else if url == "" {
+			url = arg
+			i++
+		} else if destination == "" {
+			destination = arg
+			i++
+		} else {
+			fmt.Printf("Error: unexpected argument '%s'\n", arg)
+			fmt.Println(cloneUsage)
+			os.Exit(1)
+		}
+	}
+
+	// Validate that we have at least a URL
+	if url == "" {
+		fmt.Println("Error: repository URL is required")
+		fmt.Println(cloneUsage)
+		os.Exit(1)
+	}
+
+	// A nostr:<naddr> address is resolved to a clone URL from the repository's
+	// announcement on the relays
+	var announced *nostr.Event
+	if isNostrAddress(url) {
+		event, cloneURL, err := resolveNostrClone(url)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		announced = event
+		url = cloneURL
+		if destination == "" {
+			destination = parseAnnouncement(event).Identifier
+		}
+	}
+
+	// If no destination is specified, use the last part of the URL as the directory name
+	if destination == "" {
+		parts := strings.Split(url, "/")
+		destination = strings.TrimSuffix(parts[len(parts)-1], ".git")
+	}
+
+	// Normalize URL to ensure it doesn't end with a slash
+	url = strings.TrimSuffix(url, "/")
+
+	// A destination holding an interrupted resumable clone is picked up again
+	resuming := isServerURL(url) && hasCloneState(destination)
+	if resuming {
+		infof("Resuming interrupted clone in %s\n", destination)
+		opts.Resumable = true
+	} else if GetConfigBool("clone.resumable", false) {
+		opts.Resumable = true
+	}
+
+	// Refuse to mix a clone into existing files unless asked to
+	if !resuming {
+		if err := checkCloneDestination(destination, opts.Force); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Local paths and non-HTTP remotes are cloned without the mgit server
+	ctx := commandContext()
+	if !isServerURL(url) {
+		err := cloneInto(destination, func(dir string) error {
+			return plainClone(ctx, url, dir, opts)
+		})
+		if err != nil {
+			exitIfInterrupted(err)
+			fmt.Printf("Error cloning repository: %s\n", err)
+			os.Exit(1)
+		}
+		finishClone(destination, announced, opts)
+		return
+	}
+
+	// Ask the auth provider chain; a -jwt token is offered by its flag provider
+	auth, err := lookupAuthWithJWT(url, jwtToken)
+	if err != nil {
+		fmt.Printf("%s. Log in first with mgit login <url>.\n", err)
+		os.Exit(1)
+	}
+	verbosef("Using %s credentials for authentication\n", auth.Provider)
+
+	// Clone the repository. Resumable clones keep their partial state on
+	// failure instead of being cleaned up.
+	if opts.Resumable {
+		err = cloneRepository(ctx, url, destination, auth, opts)
+	} else {
+		err = cloneInto(destination, func(dir string) error {
+			return cloneRepository(ctx, url, dir, auth, opts)
+		})
+	}
+	if err != nil {
+		if opts.Resumable {
+			fmt.Printf("Partial clone kept in %s; run the same command again to resume\n", destination)
+		}
+		exitIfInterrupted(err)
+		fmt.Printf("Error cloning repository: %s\n", err)
+		os.Exit(1)
+	}
+
+	finishClone(destination, announced, opts)
+}
+
+// finishClone runs the steps that follow a successful clone and reports it
+func finishClone(destination string, announced *nostr.Event, opts *CloneOptions) {
+	if err := finishNostrClone(destination, announced, opts); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := applyClonePartialScope(destination, opts); err != nil {
+		fmt.Printf("Error setting partial scope: %s\n", err)
+		os.Exit(1)
+	}
+	if opts.Partial != "" {
+		infof("Checked out %s only (mgit partial add/remove to change)\n", opts.Partial)
+	}
+	infof("Successfully cloned repository to %s\n", destination)
+}
+
+// applyClonePartialScope narrows a fresh clone to the --partial directory
+func applyClonePartialScope(destination string, opts *CloneOptions) error {
+	if opts.Partial == "" {
+		return nil
+	}
+	return setPartialScope(destination, []string{opts.Partial})
+}
+
+// isNostrAddress reports whether a clone URL is a nostr:<naddr> address
+func isNostrAddress(url string) bool {
+	return strings.HasPrefix(url, "nostr:") || strings.HasPrefix(url, "naddr1")
+}
+
+// resolveNostrClone looks up the repository announcement an naddr points to
+// and picks a clone URL from it, preferring an mgit server
+func resolveNostrClone(value string) (*nostr.Event, string, error) {
+	addr, err := parseNaddr(value)
+	if err != nil {
+		return nil, "", err
+	}
+	if addr.Kind != nostr.KindRepoAnnouncement {
+		return nil, "", fmt.Errorf("%s is not a repository address (kind %d)", value, addr.Kind)
+	}
+	relays := repoRelays(addr, nil)
+
+	infof("Looking up repository '%s' on %d relay(s)...\n", addr.Identifier, len(relays))
+	event, err := fetchAnnouncement(addr, relays)
+	if err != nil {
+		return nil, "", err
+	}
+	announcement := parseAnnouncement(event)
+
+	// The announcement's signature was checked against the address's author;
+	// the maintainers it names must be valid pubkeys too
+	maintainers := []string{}
+	for _, pubkey := range append([]string{event.Pubkey}, announcement.Maintainers...) {
+		hexKey, err := nostr.PubkeyHex(pubkey)
+		if err != nil {
+			fmt.Printf("Warning: ignoring invalid maintainer pubkey '%s'\n", pubkey)
+			continue
+		}
+		if npub, err := nostr.Npub(hexKey); err == nil {
+			maintainers = appendUnique(maintainers, npub)
+		}
+	}
+	infof("Found announcement %s for '%s'\n", shortHash(event.ID), announcement.Identifier)
+	for _, npub := range maintainers {
+		infof("  Maintainer: %s\n", npub)
+	}
+
+	if len(announcement.CloneURLs) == 0 {
+		return nil, "", fmt.Errorf("the announcement for '%s' lists no clone URL", announcement.Identifier)
+	}
+	cloneURL := announcement.CloneURLs[0]
+	for _, candidate := range announcement.CloneURLs {
+		if isServerURL(candidate) {
+			cloneURL = candidate
+			break
+		}
+	}
+	infof("Cloning from %s\n", cloneURL)
+	return event, cloneURL, nil
+}
+
+// finishNostrClone checks a clone made from an announcement holds the
+// repository's announced root commit, and keeps the announcement in
+// .mgit/announcement.json so send-patch and fetch-patches know the
+// repository
+func finishNostrClone(destination string, announced *nostr.Event, opts *CloneOptions) error {
+	if announced == nil {
+		return nil
+	}
+	// A shallow clone stops short of the root commit
+	if root := parseAnnouncement(announced).EarliestCommit; root != "" && opts.Depth == 0 {
+		repo, err := git.PlainOpen(destination)
+		if err == nil {
+			_, err = repo.CommitObject(plumbing.NewHash(root))
+		}
+		if err != nil {
+			return fmt.Errorf("the clone in %s does not contain the announced root commit %s; the clone URL may not serve this repository", destination, shortHash(root))
+		}
+	}
+
+	data, err := json.MarshalIndent(announced, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Join(destination, ".mgit"), 0755)
+	}
+	if err == nil {
+		err = fsutil.WriteFile(filepath.Join(destination, ".mgit", "announcement.json"), data)
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to save the repository announcement: %s\n", err)
+	}
+	return nil
+}
+
+// lookupTokenForRepo finds the stored authentication token for a repository URL
+func lookupTokenForRepo(repoURL string) (*AuthToken, error) {
+	store, err := loadTokenStore()
+	if err != nil {
+		return nil, err
+	}
+	if len(store.Tokens) == 0 {
+		return nil, fmt.Errorf("No authentication token found")
+	}
+
+	// Find the token for the repository
+	for i, t := range store.Tokens {
+		if t.Server != "" {
+			continue
+		}
+		// Check if the repo URL matches
+		if matchRepoURL(t.RepoURL, repoURL) {
+			debugf("using the token stored for %s", t.RepoURL)
+			return &store.Tokens[i], nil
+		}
+		debugf("token stored for %s does not match %s", t.RepoURL, repoURL)
+	}
+	// Then a token saved for the repository's server
+	if token := serverToken(store, repoURL); token != nil {
+		return token, nil
+	}
+
+	return nil, fmt.Errorf("No authentication token found for this repository")
+}
+
+// matchRepoURL checks if two repository URLs refer to the same repository
+func matchRepoURL(storedURL, providedURL string) bool {
+	return sameRepoURL(storedURL, providedURL)
+}
+
+// getTokenConfigPath returns the path to the token config file:
+// MGIT_TOKENS_PATH, else tokens.enc in the user's mgit directory
+func getTokenConfigPath() string {
+	if path := os.Getenv("MGIT_TOKENS_PATH"); path != "" {
+		return path
+	}
+	dir := mgitUserDir()
+	if dir == "" {
+		fmt.Println("Error getting home directory")
+		os.Exit(1)
+	}
+	return filepath.Join(dir, "tokens.enc")
+}
+
+// cloneRepository clones a repository
+func cloneRepository(ctx context.Context, url, destination string, auth *Credentials, opts *CloneOptions) error {
+	// Create the destination directory if it doesn't exist
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return fmt.Errorf("error creating destination directory: %w", err)
+	}
+
+	// Resumable clones record each finished stage so a re-run can skip it
+	var state *CloneState
+	if opts.Resumable {
+		var err error
+		state, err = loadCloneState(destination, url)
+		if err != nil {
+			return err
+		}
+	}
+
+	// First, we use the mgit-fetch endpoint to get repository metadata
+	// This requires authentication and will give us information about the repository
+	infof("Fetching repository metadata...\n")
+	repoInfo, err := fetchRepositoryInfo(ctx, url, auth)
+	if err != nil {
+		return fmt.Errorf("error fetching repository metadata: %w", err)
+	}
+
+	infof("Repository: %s\nAccess level: %s\n", repoInfo.Name, repoInfo.Access)
+
+	// First, clone the Git data using git-upload-pack
+	if state != nil {
+		if !state.Done(cloneStageGit) {
+			infof("Fetching Git repository...\n")
+			if err := resumableGitFetch(ctx, url, destination, auth, opts, state); err != nil {
+				return fmt.Errorf("error fetching Git repository: %w", err)
+			}
+			if err := state.Mark(cloneStageGit); err != nil {
+				return err
+			}
+		}
+	} else {
+		infof("Cloning Git repository...\n")
+		if err := gitClone(ctx, url, destination, auth, opts); err != nil {
+			return fmt.Errorf("error cloning Git repository: %w", err)
+		}
+	}
+
+	// Fetch and set up MGit metadata
+	infof("Setting up MGit metadata...\n")
+	if state != nil {
+		if !state.Done(cloneStageMetadata) {
+			if err := resumableMetadataFetch(ctx, url, destination, auth); err != nil {
+				return fmt.Errorf("error fetching MGit metadata: %w", err)
+			}
+			if err := state.Mark(cloneStageMetadata); err != nil {
+				return err
+			}
+		}
+	} else if err := fetchMGitMetadata(ctx, url, destination, auth); err != nil {
+		// Don't fail the clone if metadata fetch fails - log warning and continue
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Printf("Warning: Failed to fetch MGit metadata: %s\n", err)
+	}
+
+	// Reconstruct MGit objects from mappings
+	infof("Reconstructing MGit objects...\n")
+	if err := reconstructMGitObjects(ctx, destination); err != nil {
+		// Don't fail the clone if reconstruction fails - log warning and continue
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Printf("Warning: Failed to reconstruct MGit objects: %s\n", err)
+	}
+
+	// Set up MGit configuration
+	if err := setupMGitConfig(destination, repoInfo); err != nil {
+		return fmt.Errorf("error setting up MGit config: %w", err)
+	}
+
+	if state != nil {
+		return state.Remove()
+	}
+
+	return nil
+}
+
+// checkCloneDestination rejects a destination that exists and is not an
+// empty directory, unless force is set
+func checkCloneDestination(destination string, force bool) error {
+	info, err := os.Stat(destination)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot access destination %s: %w", destination, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("destination %s exists and is not a directory", destination)
+	}
+	
+	entries, err := os.ReadDir(destination)
+	if err != nil {
+		return fmt.Errorf("cannot read destination %s: %w", destination, err)
+	}
+	if len(entries) > 0 && !force {
+		return fmt.Errorf("destination path '%s' already exists and is not an empty directory (use --force to clone into it anyway)", destination)
+	}
+	
+	return nil
+}
+
+// cloneInto runs clone against destination and removes whatever it created
+// if any stage fails. A non-empty destination (only allowed with --force) is
+// cloned into a temporary sibling directory first and the result moved in,
+// so a failed clone never leaves partial state among the existing files.
+func cloneInto(destination string, clone func(dir string) error) error {
+	entries, statErr := os.ReadDir(destination)
+	existed := statErr == nil
+	
+	if existed && len(entries) > 0 {
+		tmpDir, err := os.MkdirTemp(filepath.Dir(filepath.Clean(destination)), ".mgit-clone-")
+		if err != nil {
+			return fmt.Errorf("error creating temporary clone directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		
+		if err := clone(tmpDir); err != nil {
+			return err
+		}
+		return moveDirEntries(tmpDir, destination)
+	}
+	
+	if err := clone(destination); err != nil {
+		if existed {
+			// Keep the (previously empty) directory, drop what we put in it
+			cleanEntries, _ := os.ReadDir(destination)
+			for _, entry := range cleanEntries {
+				os.RemoveAll(filepath.Join(destination, entry.Name()))
+			}
+		} else {
+			os.RemoveAll(destination)
+		}
+		infof("Removed partially cloned files from %s\n", destination)
+		return err
+	}
+	
+	return nil
+}
+
+// moveDirEntries moves every entry of src into dst, refusing to overwrite
+// anything already in dst
+func moveDirEntries(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("error reading cloned files: %w", err)
+	}
+	
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(dst, entry.Name())); err == nil {
+			return fmt.Errorf("cannot move cloned %s into %s: a file with that name already exists", entry.Name(), dst)
+		}
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return fmt.Errorf("error moving cloned %s into %s: %w", entry.Name(), dst, err)
+		}
+	}
+	
+	return nil
+}
+
+// isServerURL reports whether url points at an mgit server (HTTP) rather
+// than a local path or another kind of git remote
+func isServerURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// plainClone clones a local path or non-HTTP remote with git, carrying over
+// the MGit mappings when the source is a local mgit repository
+func plainClone(ctx context.Context, url, destination string, opts *CloneOptions) error {
+	infof("Cloning Git repository...\n")
+	gitArgs := append([]string{"clone", "--progress"}, opts.gitArgs()...)
+	gitArgs = append(gitArgs, url, destination)
+	cmd := exec.CommandContext(ctx, "git", gitArgs...)
+	cmd.Stdout = os.Stdout
+	if err := runGitProgress(cmd, "clone"); err != nil {
+		return fmt.Errorf("error running git clone: %w", contextErr(ctx, err))
+	}
+
+	source := &MGitStorage{
+		RootDir: filepath.Join(strings.TrimPrefix(url, "file://"), ".mgit"),
+	}
+	if _, err := os.Stat(source.RootDir); err != nil {
+		// Plain git repository - nothing more to set up
+		return nil
+	}
+
+	infof("Setting up MGit metadata...\n")
+	mappings, err := source.GetMappings()
+	if err != nil {
+		fmt.Printf("Warning: Failed to read source MGit metadata: %s\n", err)
+		return nil
+	}
+	dest := &MGitStorage{
+		RootDir: filepath.Join(destination, ".mgit"),
+	}
+	if err := dest.WriteMappings(mappings); err != nil {
+		fmt.Printf("Warning: Failed to store MGit metadata: %s\n", err)
+		return nil
+	}
+
+	infof("Reconstructing MGit objects...\n")
+	if err := reconstructMGitObjects(ctx, destination); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Printf("Warning: Failed to reconstruct MGit objects: %s\n", err)
+	}
+
+	return nil
+}
+
+// RepositoryInfo represents information about a repository
+type RepositoryInfo = serverclient.RepoInfo
+
+// fetchRepositoryInfo fetches information about the repository
+func fetchRepositoryInfo(ctx context.Context, url string, auth *Credentials) (*RepositoryInfo, error) {
+	return newServerClient(url, auth, 0).Info(ctx)
+}
+
+// extractRepoID extracts the repository ID from a URL
+func extractRepoID(url string) string {
+	if parsed, err := parseServerURL(url); err == nil {
+		return parsed.RepoID
+	}
+	// Not an http(s) URL: take the last path element
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-1]
+}
+
+// extractServerBaseURL extracts the server base URL from a repository URL
+func extractServerBaseURL(url string) string {
+	if parsed, err := parseServerURL(url); err == nil {
+		return parsed.Base
+	}
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	return strings.TrimSuffix(url, "/"+extractRepoID(url))
+}
+
+// repoEndpoint returns the URL of one of a repository's API endpoints on
+// its server, such as "/metadata", or of the repository itself for ""
+func repoEndpoint(url, path string) string {
+	if parsed, err := parseServerURL(url); err == nil {
+		return parsed.Endpoint(path)
+	}
+	return fmt.Sprintf("%s%s%s%s", extractServerBaseURL(url), apiReposPath, extractRepoID(url), path)
+}
+
+// gitClone performs the actual Git clone operation
+func gitClone(ctx context.Context, url, destination string, auth *Credentials, opts *CloneOptions) error {
+	gitURL := mgitGitURL(url)
+
+	// Use git clone with the -c option for Authorization header
+	authHeader := auth.gitConfig()
+	debugf("git clone %s into %s with %s credentials", gitURL, destination, auth.Provider)
+	
+	// Use git clone with the temporary config
+	gitArgs := append(serverGitArgs(url), "clone", "--progress", "-c", authHeader)
+	gitArgs = append(gitArgs, opts.gitArgs()...)
+	gitArgs = append(gitArgs, gitURL, destination)
+	cmd := exec.CommandContext(ctx, "git", gitArgs...)
+	cmd.Stdout = os.Stdout
+	
+	if err := runGitProgress(cmd, "clone"); err != nil {
+		return fmt.Errorf("error running git clone: %w", contextErr(ctx, err))
+	}
+	
+	return nil
+}
+
+// mgitGitURL returns the Git protocol endpoint for a repository on the server
+func mgitGitURL(url string) string {
+	return repoEndpoint(url, "")
+}
+
+// fetchMGitMetadata fetches the MGit metadata and sets it up in the
+// repository, recording where the fetch left off so later ones only
+// transfer what is new
+func fetchMGitMetadata(ctx context.Context, url, destination string, auth *Credentials) error {
+	storage := &MGitStorage{RootDir: filepath.Join(destination, ".mgit")}
+	if _, err := syncRemoteMetadata(ctx, storage, url, auth); err != nil {
+		return err
+	}
+	
+	infof("Successfully fetched and stored MGit metadata\n")
+	return nil
+}
+
+// storeFetchedMappings writes mappings fetched from the server to the
+// canonical location in the destination's .mgit
+func storeFetchedMappings(destination string, mappings []NostrCommitMapping) error {
+	storage := &MGitStorage{
+		RootDir: filepath.Join(destination, ".mgit"),
+	}
+	if err := storage.WriteMappings(mappings); err != nil {
+		return fmt.Errorf("error writing hash mappings: %w", err)
+	}
+	
+	infof("Successfully fetched and stored MGit metadata\n")
+	return nil
+}
+
+// mgitMetadataURL returns the server endpoint holding a repository's hash mappings
+func mgitMetadataURL(url string) string {
+	return repoEndpoint(url, "/metadata")
+}
+
+// fetchRemoteMappings downloads the server's hash mappings for a repository
+func fetchRemoteMappings(ctx context.Context, url string, auth *Credentials) ([]NostrCommitMapping, error) {
+	collected := &metadataCollector{}
+	if _, err := streamRemoteMetadata(ctx, url, auth, nil, collected.add); err != nil {
+		return nil, err
+	}
+	return collected.mappings, nil
+}
+
+// fetchRemoteMetadata downloads the server's metadata for a repository: the
+// hash mappings and any notes on the commits
+func fetchRemoteMetadata(ctx context.Context, url string, auth *Credentials) ([]remoteMetadataEntry, error) {
+	var entries []remoteMetadataEntry
+	_, err := streamRemoteMetadata(ctx, url, auth, nil, func(entry remoteMetadataEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// uploadRemoteMappings sends hash mappings to the server's metadata endpoint
+func uploadRemoteMappings(ctx context.Context, url string, auth *Credentials, mappings []NostrCommitMapping) error {
+	entries := make([]remoteMetadataEntry, len(mappings))
+	for i, mapping := range mappings {
+		// Where a mapping came from only means something locally
+		mapping.Source = ""
+		entries[i] = remoteMetadataEntry{Mapping: mapping}
+	}
+	return uploadRemoteMetadata(ctx, url, auth, entries)
+}
+
+// uploadRemoteMetadata sends mappings, with any notes, to the server's
+// metadata endpoint
+func uploadRemoteMetadata(ctx context.Context, url string, auth *Credentials, entries []remoteMetadataEntry) error {
+	return newServerClient(url, auth, 0).PostMetadata(ctx, entries)
+}
+
+// setupMGitConfig sets up the MGit configuration for the cloned repository
+func setupMGitConfig(destination string, repoInfo *RepositoryInfo) error {
+	// Create the MGit config
+	configPath := filepath.Join(destination, ".mgit", "config")
+	
+	// Set the repository information, keeping anything already configured
+	err := UpdateConfig(configPath, func(config *Config) error {
+		config.Set("repository", "id", repoInfo.ID)
+		config.Set("repository", "name", repoInfo.Name)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving MGit config: %w", err)
+	}
+	
+	return nil
+}
+
+// reconstructMGitObjects reconstructs MGit objects from Git commits using
+// mappings, stopping early when ctx is canceled
+func reconstructMGitObjects(ctx context.Context, repoPath string) error {
+	// Create necessary directory structure first
+	mgitDir := filepath.Join(repoPath, ".mgit")
+	objDir := filepath.Join(mgitDir, "objects")
+	refsDir := filepath.Join(mgitDir, "refs")
+	refsHeadsDir := filepath.Join(refsDir, "heads")
+	mappingsDir := filepath.Join(mgitDir, "mappings")
+	
+	dirs := []string{objDir, refsDir, refsHeadsDir, mappingsDir}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating MGit directory structure: %w", err)
+		}
+	}
+
+	// Open the Git repository
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("error opening Git repository: %w", err)
+	}
+	
+	// Create the MGit storage
+	storage := &MGitStorage{
+		RootDir: filepath.Join(repoPath, ".mgit"),
+	}
+	
+	// Initialize the MGit storage
+	if err := storage.Initialize(); err != nil {
+		return fmt.Errorf("error initializing MGit storage: %w", err)
+	}
+	
+	// Read the mappings
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		return fmt.Errorf("error reading mappings: %w", err)
+	}
+	if len(mappings) == 0 {
+		return fmt.Errorf("no MGit mappings found in the repository")
+	}
+	
+	// Parents are looked up by git hash
+	byGit := make(map[string]string, len(mappings))
+	for _, mapping := range mappings {
+		byGit[mapping.GitHash] = mapping.MGitHash
+	}
+	
+	// Rebuild and store the commits in parallel, keeping each one's outcome
+	// to report in mapping order
+	absent := make([]bool, len(mappings))
+	reports := make([]string, len(mappings))
+	repos := newWorkerRepos(repoPath, repo)
+	progress := newProgress("reconstruct", nil)
+	progress.Phase("Reconstructing MGit commits", int64(len(mappings)))
+	jobsErr := runJobsContext(ctx, len(mappings), func(worker, i int) {
+		defer progress.Add(1)
+		mapping := mappings[i]
+		workerRepo, err := repos.get(worker)
+		if err != nil {
+			reports[i] = fmt.Sprintf("Warning: Could not open repository: %s\n", err)
+			return
+		}
+		
+		// Get the Git commit
+		commit, err := workerRepo.CommitObject(plumbing.NewHash(mapping.GitHash))
+		if err != nil {
+			// Expected for shallow and single-branch clones
+			absent[i] = true
+			return
+		}
+		
+		mgitCommit, ok := mgitlib.RebuildCommit(commit, mapping, byGit)
+		if !ok {
+			reports[i] = fmt.Sprintf("Warning: MGit hash %s does not match Git commit %s; not reconstructed\n",
+				mapping.MGitHash[:7], mapping.GitHash[:7])
+			return
+		}
+		
+		// Store the MGit commit
+		if err := storage.StoreCommit(mgitCommit); err != nil {
+			reports[i] = fmt.Sprintf("Warning: Could not store MGit commit %s: %s\n", mapping.MGitHash, err)
+			return
+		}
+		
+		if logEnabled(logVerbose) {
+			reports[i] = fmt.Sprintf("Reconstructed MGit commit: %s (from Git %s)\n", mapping.MGitHash[:7], mapping.GitHash[:7])
+		}
+	})
+	progress.Finish()
+	// Refs are left alone when interrupted; the objects written so far are
+	// picked up by the next reconstruct
+	if jobsErr != nil {
+		return jobsErr
+	}
+	
+	missing := 0
+	for i, report := range reports {
+		if absent[i] {
+			missing++
+		}
+		fmt.Print(report)
+	}
+	
+	if missing > 0 {
+		infof("Skipped %d mapping(s) for commits not present locally\n", missing)
+	}
+	
+	return syncMGitRefs(repo, storage, mappings, "reconstruct: from hash mappings")
+}
+
+// syncMGitRefs points the MGit branches, tags and HEAD at the MGit hashes
+// mapped to their git counterparts, logging reason in the reflog
+func syncMGitRefs(repo *git.Repository, storage *MGitStorage, mappings []NostrCommitMapping, reason string) error {
+	// Update branch references to point to MGit hashes
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("error getting references: %w", err)
+	}
+	
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsBranch() {
+			branchName := ref.Name().Short()
+			gitHash := ref.Hash().String()
+			
+			// Find the corresponding MGit hash
+			var mgitHash string
+			for _, mapping := range mappings {
+				if mapping.GitHash == gitHash {
+					mgitHash = mapping.MGitHash
+					
+					if err := storage.UpdateRef(ref.Name().String(), mapping.MGitHash, reason); err != nil {
+						fmt.Printf("Warning: Could not update branch ref %s: %s\n", branchName, err)
+					} else {
+						verbosef("Set branch reference %s to MGit hash %s\n", branchName, mgitHash[:7])
+					}
+					break
+				}
+			}
+			
+			if mgitHash == "" {
+				fmt.Printf("Warning: Could not find MGit hash for branch %s at git hash %s\n", branchName, gitHash)
+			}
+		} else if ref.Name().IsTag() {
+			tagName := ref.Name().Short()
+			
+			// Annotated tags point at a tag object; peel to the commit
+			gitHash := ref.Hash()
+			if tagObj, err := repo.TagObject(gitHash); err == nil {
+				gitHash = tagObj.Target
+			}
+			
+			for _, mapping := range mappings {
+				if mapping.GitHash == gitHash.String() {
+					if err := storage.UpdateTag(tagName, mapping.MGitHash); err != nil {
+						fmt.Printf("Warning: Could not update tag %s: %s\n", tagName, err)
+					} else {
+						verbosef("Set tag %s to MGit hash %s\n", tagName, mapping.MGitHash[:7])
+					}
+					break
+				}
+			}
+		}
+		return nil
+	})
+	
+	if err != nil {
+		return fmt.Errorf("error processing references: %w", err)
+	}
+	
+	// Update HEAD
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("error getting Git HEAD: %w", err)
+	}
+	
+	// Create HEAD file pointing to the current branch
+	if head.Name().IsBranch() {
+		branchName := head.Name().Short()
+		
+		if err := storage.UpdateHead(head.Name().String(), reason); err != nil {
+			return fmt.Errorf("error writing HEAD file: %w", err)
+		}
+		
+		verbosef("Set HEAD to branch: %s\n", branchName)
+	} else {
+		// Detached HEAD - try to find the corresponding MGit hash
+		gitHash := head.Hash().String()
+		
+		// Find the MGit hash for this Git hash
+		var mgitHash string
+		for _, mapping := range mappings {
+			if mapping.GitHash == gitHash {
+				mgitHash = mapping.MGitHash
+				break
+			}
+		}
+		
+		if mgitHash == "" {
+			return fmt.Errorf("could not find MGit hash for detached HEAD at %s", gitHash)
+		}
+		
+		// Write the direct hash as HEAD
+		if err := storage.SetDetachedHead(mgitHash, reason); err != nil {
+			return fmt.Errorf("error writing HEAD file: %w", err)
+		}
+		
+		verbosef("Set HEAD to detached commit: %s\n", mgitHash[:7])
+	}
+	
+	return nil
+}
\ No newline at end of file
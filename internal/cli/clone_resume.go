@@ -0,0 +1,310 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	serverclient "github.com/imyjimmy/mgit/pkg/server-client"
+)
+
+// Stages of a resumable clone, recorded in the clone state file
+const (
+	cloneStageGit      = "git"
+	cloneStageMetadata = "metadata"
+)
+
+// defaultResumeBatch is how many commits of history each resumable fetch
+// pulls in; override with clone.resumeBatch
+const defaultResumeBatch = 500
+
+// CloneState tracks the progress of a resumable clone so an interrupted
+// clone can continue where it left off
+type CloneState struct {
+	URL          string          `json:"url"`
+	Stages       map[string]bool `json:"stages"`
+	FetchStarted bool            `json:"fetch_started"`
+
+	path string
+}
+
+// cloneStatePath returns where a destination's clone state is kept
+func cloneStatePath(destination string) string {
+	return filepath.Join(destination, ".mgit", "clone-state.json")
+}
+
+// hasCloneState reports whether destination holds an interrupted clone
+func hasCloneState(destination string) bool {
+	_, err := os.Stat(cloneStatePath(destination))
+	return err == nil
+}
+
+// loadCloneState reads the clone state for destination, or starts a new one.
+// Resuming a clone of a different URL is refused.
+func loadCloneState(destination, url string) (*CloneState, error) {
+	state := &CloneState{
+		URL:    url,
+		Stages: map[string]bool{},
+		path:   cloneStatePath(destination),
+	}
+
+	data, err := os.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return state, state.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading clone state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("error parsing clone state: %w", err)
+	}
+	if state.URL != url {
+		return nil, fmt.Errorf("%s holds an interrupted clone of %s, not %s", destination, state.URL, url)
+	}
+	if state.Stages == nil {
+		state.Stages = map[string]bool{}
+	}
+
+	return state, nil
+}
+
+// Done reports whether a stage already finished
+func (s *CloneState) Done(stage string) bool {
+	return s.Stages[stage]
+}
+
+// Mark records a finished stage
+func (s *CloneState) Mark(stage string) error {
+	s.Stages[stage] = true
+	return s.save()
+}
+
+// Remove deletes the state file once the clone is complete
+func (s *CloneState) Remove() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing clone state: %w", err)
+	}
+	return nil
+}
+
+func (s *CloneState) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("error creating clone state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding clone state: %w", err)
+	}
+	if err := fsutil.WriteFile(s.path, data); err != nil {
+		return fmt.Errorf("error writing clone state: %w", err)
+	}
+	return nil
+}
+
+// resumableGitFetch builds the repository with init + fetch rather than git
+// clone. History is fetched in batches (a shallow fetch deepened step by
+// step), so an interruption only loses the batch in flight.
+func resumableGitFetch(ctx context.Context, url, destination string, auth *Credentials, opts *CloneOptions, state *CloneState) error {
+	gitConfig := append(serverGitArgs(url), "-c", auth.gitConfig())
+	git := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", append(append([]string{"-C", destination}, gitConfig...), args...)...)
+		cmd.Stdout = os.Stdout
+		if args[0] == "fetch" {
+			cmd.Args = append(cmd.Args, "--progress")
+			return contextErr(ctx, runGitProgress(cmd, "clone"))
+		}
+		cmd.Stderr = os.Stderr
+		return contextErr(ctx, cmd.Run())
+	}
+
+	if _, err := os.Stat(filepath.Join(destination, ".git")); os.IsNotExist(err) {
+		if err := git("init", "-q"); err != nil {
+			return fmt.Errorf("error initializing repository: %w", err)
+		}
+		remoteArgs := []string{"remote", "add"}
+		if opts.SingleBranch && opts.Branch != "" {
+			remoteArgs = append(remoteArgs, "-t", opts.Branch)
+		}
+		remoteArgs = append(remoteArgs, "origin", mgitGitURL(url))
+		if err := git(remoteArgs...); err != nil {
+			return fmt.Errorf("error adding remote: %w", err)
+		}
+	}
+
+	if opts.Depth > 0 {
+		// The caller asked for a shallow clone - one fetch is all there is
+		if err := git("fetch", "--depth", strconv.Itoa(opts.Depth), "origin"); err != nil {
+			return fmt.Errorf("error fetching: %w", err)
+		}
+	} else {
+		batch := strconv.Itoa(resumeBatchSize())
+		if !state.FetchStarted {
+			if err := git("fetch", "--depth", batch, "origin"); err != nil {
+				return fmt.Errorf("error fetching: %w", err)
+			}
+			state.FetchStarted = true
+			if err := state.save(); err != nil {
+				return err
+			}
+		}
+		for isShallowRepository(destination) {
+			fmt.Printf("Fetching %s more commits of history...\n", batch)
+			if err := git("fetch", "--deepen", batch, "origin"); err != nil {
+				return fmt.Errorf("error fetching: %w", err)
+			}
+		}
+	}
+
+	branch := opts.Branch
+	if branch == "" {
+		var err error
+		branch, err = remoteDefaultBranch(destination, gitConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.NoCheckout {
+		if err := git("update-ref", "refs/heads/"+branch, "refs/remotes/origin/"+branch); err != nil {
+			return fmt.Errorf("error creating branch %s: %w", branch, err)
+		}
+		if err := git("symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+			return fmt.Errorf("error setting HEAD: %w", err)
+		}
+		return git("branch", "-q", "--set-upstream-to=origin/"+branch, branch)
+	}
+
+	if err := git("checkout", "-q", "-B", branch, "--track", "origin/"+branch); err != nil {
+		return fmt.Errorf("error checking out %s: %w", branch, err)
+	}
+	return nil
+}
+
+// resumeBatchSize returns the configured number of commits per fetch batch
+func resumeBatchSize() int {
+	if n := GetConfigInt("clone.resumeBatch", defaultResumeBatch); n > 0 {
+		return n
+	}
+	return defaultResumeBatch
+}
+
+// isShallowRepository reports whether the repository still has history to fetch
+func isShallowRepository(dir string) bool {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--is-shallow-repository").Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// remoteDefaultBranch asks the remote which branch its HEAD points to
+func remoteDefaultBranch(dir string, gitConfig []string) (string, error) {
+	args := append(append([]string{"-C", dir}, gitConfig...), "ls-remote", "--symref", "origin", "HEAD")
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("error querying remote HEAD: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		// ref: refs/heads/main	HEAD
+		if strings.HasPrefix(line, "ref: ") {
+			ref := strings.Fields(strings.TrimPrefix(line, "ref: "))[0]
+			return strings.TrimPrefix(ref, "refs/heads/"), nil
+		}
+	}
+	return "", fmt.Errorf("remote did not report a default branch; use --branch")
+}
+
+// resumableMetadataFetch downloads the hash mappings into a .part file,
+// continuing a previous partial download with an HTTP Range request
+func resumableMetadataFetch(ctx context.Context, url, destination string, auth *Credentials) error {
+	partPath := filepath.Join(destination, ".mgit", "mappings", "hash_mappings.json.part")
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		return fmt.Errorf("error creating .mgit/mappings directory: %w", err)
+	}
+
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", mgitMetadataURL(url), nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	auth.setHeader(req)
+	if offset > 0 {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-", offset))
+		fmt.Printf("Resuming metadata download at byte %d\n", offset)
+	}
+
+	client := serverHTTPClient(url, 0)
+	resp, err := serverDo(client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// Server ignored the range - start over
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Already have every byte
+		flags = -1
+	default:
+		return serverResponseError(resp)
+	}
+
+	if flags != -1 {
+		file, err := os.OpenFile(partPath, flags, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening %s: %w", partPath, err)
+		}
+		_, copyErr := io.Copy(file, resp.Body)
+		closeErr := file.Close()
+		if copyErr != nil {
+			return fmt.Errorf("metadata download interrupted: %w", contextErr(ctx, copyErr))
+		}
+		if closeErr != nil {
+			return fmt.Errorf("error writing %s: %w", partPath, closeErr)
+		}
+	}
+
+	file, err := os.Open(partPath)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", partPath, err)
+	}
+	collected := &metadataCollector{}
+	err = serverclient.DecodeMetadata(file, collected.add)
+	file.Close()
+	if err != nil {
+		// A corrupt partial file cannot be resumed - drop it so the next run starts fresh
+		os.Remove(partPath)
+		return err
+	}
+
+	if err := storeFetchedMappings(destination, collected.mappings); err != nil {
+		return err
+	}
+	storage := &MGitStorage{RootDir: filepath.Join(destination, ".mgit")}
+	if _, err := mergeRemoteNotes(storage, collected.noted); err != nil {
+		return fmt.Errorf("error storing notes: %w", err)
+	}
+	// Later fetches start where this one left off, when the server said
+	if cursor, etag := resp.Header.Get("X-MGit-Cursor"), resp.Header.Get("ETag"); cursor != "" || etag != "" {
+		state := &MetadataSyncState{URL: mgitMetadataURL(url), Cursor: cursor, ETag: etag}
+		if err := storage.saveMetadataSync(state); err != nil {
+			return fmt.Errorf("error recording metadata sync: %w", err)
+		}
+	}
+	return os.Remove(partPath)
+}
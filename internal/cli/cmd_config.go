@@ -0,0 +1,445 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const configUsage = `Usage: mgit config [<scope>] [<key> [<value>]]
+       mgit config [<scope>] --add <key> <value> | --get-all <key> | --get-regexp <pattern>
+       mgit config [<scope>] --unset <key> [<value>] | --unset-all <key> [<value>]
+       mgit config [<scope>] --remove-section <section>
+       mgit config [<scope>] --list [--show-origin] | --show-origin <key>
+The scope is one of --local (.mgit/config, the default for changes), --git (.git/config),
+--global and --system`
+
+// HandleConfig handles the config command
+func HandleConfig(args []string) {
+	// Check for the scope flags and the multi-valued key actions
+	scope := ""
+	action := ""
+	showOrigin := false
+	filteredArgs := []string{}
+	for _, arg := range args {
+		if arg == "--local" || arg == "--git" || arg == "--global" || arg == "--system" {
+			if scope != "" && scope != arg[2:] {
+				fmt.Println("Error: only one of --local, --git, --global and --system may be given")
+				os.Exit(1)
+			}
+			scope = arg[2:]
+		} else if arg == "--show-origin" {
+			showOrigin = true
+		} else if arg == "--list" || arg == "--add" || arg == "--get-all" || arg == "--get-regexp" || arg == "--unset" || arg == "--unset-all" || arg == "--remove-section" {
+			action = arg
+		} else {
+			filteredArgs = append(filteredArgs, arg)
+		}
+	}
+	args = filteredArgs
+
+	if showOrigin && (action != "--list" && (action != "" || len(args) != 1)) {
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+	if action == "--list" {
+		listConfigEntries(args, scope, showOrigin)
+		return
+	}
+	if showOrigin {
+		getConfigOrigin(args[0], scope)
+		return
+	}
+	if len(args) == 0 && action == "" {
+		// List all config values, or those of one scope
+		listConfig(scope)
+		return
+	}
+	if action == "--remove-section" {
+		removeConfigSection(args, scope)
+		return
+	}
+	if action == "--get-regexp" {
+		getConfigRegexp(args, scope)
+		return
+	}
+	if action != "" {
+		handleMultiValuedConfig(action, args, scope)
+		return
+	}
+
+	if len(args) == 1 {
+		// Get a config value, from every scope or only the one given
+		value := GetConfigValue(args[0], "")
+		if scope != "" {
+			value = getScopedConfigValue(args[0], scope)
+		}
+		if value == "" {
+			fmt.Printf("No value set for %s\n", args[0])
+		} else {
+			fmt.Println(value)
+		}
+		return
+	}
+
+	if len(args) == 2 {
+		// Set a config value
+		key := args[0]
+		value := args[1]
+		value, err := checkConfigValue(key, value)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		section, name, ok := splitConfigKey(key)
+		if !ok {
+			fmt.Printf("Error setting config value: invalid config key format: %s\n", key)
+			os.Exit(1)
+		}
+		err = updateConfigScope(scope, func(config *Config) error {
+			config.Set(section, name, value)
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error setting config value: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Set %s to %s in %s config\n", key, value, configScopeName(scope))
+		return
+	}
+
+	fmt.Println(configUsage)
+	os.Exit(1)
+}
+
+// handleMultiValuedConfig adds, lists or removes the values of a key that
+// may be given more than once
+func handleMultiValuedConfig(action string, args []string, scope string) {
+	if len(args) == 0 || (action == "--add" && len(args) != 2) || (action == "--get-all" && len(args) != 1) || len(args) > 2 {
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+	key := args[0]
+	if action == "--get-all" && scope == "" {
+		for _, value := range GetConfigValues(key) {
+			fmt.Println(value)
+		}
+		return
+	}
+
+	section, name, ok := splitConfigKey(key)
+	if !ok {
+		fmt.Printf("Error: invalid config key format: %s\n", key)
+		os.Exit(1)
+	}
+	if action == "--get-all" {
+		config, err := readConfigScope(scope)
+		if err != nil {
+			fmt.Printf("Error reading %s config: %s\n", scope, err)
+			os.Exit(1)
+		}
+		for _, value := range config.GetAll(section, name) {
+			fmt.Println(value)
+		}
+		return
+	}
+	value := ""
+	if len(args) == 2 {
+		value = args[1]
+	}
+	if action == "--add" {
+		checked, err := checkConfigValue(key, value)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		value = checked
+	}
+	removed := 0
+	err := updateConfigScope(scope, func(config *Config) error {
+		if action == "--add" {
+			config.Add(section, name, value)
+			return nil
+		}
+		// --unset removes one value and refuses to guess which of several;
+		// --unset-all removes them all
+		removed = config.Matching(section, name, value)
+		switch {
+		case removed == 0 && value != "":
+			return fmt.Errorf("%s has no value %s in %s config", key, value, configScopeName(scope))
+		case removed == 0:
+			return fmt.Errorf("%s is not set in %s config", key, configScopeName(scope))
+		case removed > 1 && action == "--unset":
+			return fmt.Errorf("%s has %d values in %s config; use --unset-all, or name the value to remove", key, removed, configScopeName(scope))
+		}
+		config.Unset(section, name, value)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if action == "--add" {
+		fmt.Printf("Added %s to %s in %s config\n", value, key, configScopeName(scope))
+	} else if removed > 1 {
+		fmt.Printf("Unset %d values of %s in %s config\n", removed, key, configScopeName(scope))
+	} else {
+		fmt.Printf("Unset %s in %s config\n", key, configScopeName(scope))
+	}
+}
+
+// getConfigRegexp prints every value of the keys whose names match a
+// pattern, as "<key> <value>": those of the system config, then the global
+// and the local one's, or only those of the scope given. Keys in a section with a
+// subsection are named as git names them, mirror.github.url for url in
+// [mirror "github"].
+func getConfigRegexp(args []string, scope string) {
+	if len(args) != 1 {
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+	re, err := regexp.Compile(args[0])
+	if err != nil {
+		fmt.Printf("Error: invalid pattern: %s\n", err)
+		os.Exit(1)
+	}
+	scopes := configScopes
+	if scope != "" {
+		scopes = []string{scope}
+	}
+	found := false
+	for _, scope := range scopes {
+		config, err := readConfigScope(scope)
+		if err != nil {
+			fmt.Printf("Error reading %s config: %s\n", scope, err)
+			os.Exit(1)
+		}
+		for _, section := range config.SectionNames() {
+			for _, key := range config.KeyNames(section) {
+				name := configKeyName(section, key)
+				if !re.MatchString(name) {
+					continue
+				}
+				for _, value := range config.GetAll(section, key) {
+					fmt.Printf("%s %s\n", name, value)
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		os.Exit(1)
+	}
+}
+
+// configKeyName names a key as git does: section.key, or
+// section.subsection.key for a key in [section "subsection"]
+func configKeyName(section, key string) string {
+	if i := strings.Index(section, " \""); i > 0 && strings.HasSuffix(section, "\"") {
+		section = section[:i] + "." + section[i+2:len(section)-1]
+	}
+	return section + "." + key
+}
+
+// removeConfigSection removes a section and all of its keys. A section
+// with a subsection may be named as in the file, mirror "github", or as
+// mirror.github.
+func removeConfigSection(args []string, scope string) {
+	if len(args) != 1 {
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+	section := args[0]
+	err := updateConfigScope(scope, func(config *Config) error {
+		if config.RemoveSection(section) {
+			return nil
+		}
+		if parts := strings.SplitN(section, ".", 2); len(parts) == 2 && config.RemoveSection(fmt.Sprintf("%s \"%s\"", parts[0], parts[1])) {
+			return nil
+		}
+		return fmt.Errorf("no section %s in %s config", section, configScopeName(scope))
+	})
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed section %s from %s config\n", section, configScopeName(scope))
+}
+
+// listConfig lists the values of every config file, highest precedence
+// first, or those of one scope
+func listConfig(scope string) {
+	scopes := []string{"local", "git", "global", "system"}
+	if scope != "" {
+		scopes = []string{scope}
+	}
+	printed := false
+	for _, scope := range scopes {
+		config, err := readConfigScope(scope)
+		if err != nil || len(config.Sections) == 0 {
+			continue
+		}
+		if printed {
+			fmt.Println()
+		}
+		fmt.Printf("%s%s config:\n", strings.ToUpper(scope[:1]), scope[1:])
+		printConfig(config)
+		printed = true
+	}
+}
+
+// configEntry is a config value and where it comes from: "file:<path>",
+// "profile:<name>" or "env:<variable>"
+type configEntry struct {
+	Name   string
+	Value  string
+	Origin string
+}
+
+// configEntries returns every config value in order of precedence, lowest
+// first: the system, global, git and local config with the files they
+// include, the active identity profile's values between the global and
+// the git ones, and the environment's last. Of a key read as a single value, the
+// last entry is the one in effect. With a scope, only that file's values
+// are returned.
+func configEntries(scope string) ([]configEntry, error) {
+	entries := []configEntry{}
+	names := []string{}
+	addFile := func(scope string) error {
+		config, err := readConfigScope(scope)
+		if err != nil {
+			return fmt.Errorf("error reading %s config: %w", scope, err)
+		}
+		for _, section := range config.SectionNames() {
+			for _, key := range config.KeyNames(section) {
+				name := configKeyName(section, key)
+				names = appendUnique(names, name)
+				origins := config.Origins(section, key)
+				for i, value := range config.GetAll(section, key) {
+					origin := configScopePath(scope)
+					if i < len(origins) {
+						origin = origins[i]
+					}
+					entries = append(entries, configEntry{name, value, "file:" + origin})
+				}
+			}
+		}
+		return nil
+	}
+	if scope != "" {
+		return entries, addFile(scope)
+	}
+
+	for _, scope := range configScopes {
+		if scope == "git" {
+			if profile, _ := activeProfile(); profile != nil {
+				profileNames := []string{}
+				for name := range profileKeys {
+					profileNames = append(profileNames, name)
+				}
+				sort.Strings(profileNames)
+				for _, name := range profileNames {
+					names = appendUnique(names, name)
+					if value := profile.Values[profileKeys[name]]; value != "" {
+						entries = append(entries, configEntry{name, value, "profile:" + profile.Name})
+					}
+				}
+			}
+		}
+		if err := addFile(scope); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		envKey := "MGIT_" + strings.ToUpper(strings.Replace(name, ".", "_", -1))
+		if value, exists := os.LookupEnv(envKey); exists {
+			entries = append(entries, configEntry{name, value, "env:" + envKey})
+		}
+	}
+	return entries, nil
+}
+
+// listConfigEntries lists every config value in order of precedence,
+// lowest first, or one scope's, with showOrigin where each comes from
+func listConfigEntries(args []string, scope string, showOrigin bool) {
+	if len(args) != 0 {
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+	entries, err := configEntries(scope)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	for _, entry := range entries {
+		if showOrigin {
+			fmt.Printf("%s\t%s=%s\n", entry.Origin, entry.Name, entry.Value)
+		} else {
+			fmt.Printf("%s=%s\n", entry.Name, entry.Value)
+		}
+	}
+}
+
+// getConfigOrigin prints the value in effect for a key and where it comes
+// from. As in GetConfigValue, an empty value in a file doesn't count.
+func getConfigOrigin(key, scope string) {
+	entries, err := configEntries(scope)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	var found *configEntry
+	for i := range entries {
+		if entries[i].Name == key && (entries[i].Value != "" || strings.HasPrefix(entries[i].Origin, "env:")) {
+			found = &entries[i]
+		}
+	}
+	if found == nil {
+		fmt.Printf("No value set for %s\n", key)
+		return
+	}
+	fmt.Printf("%s\t%s\n", found.Origin, found.Value)
+}
+
+// getScopedConfigValue gets a config value from one scope's file only
+func getScopedConfigValue(key, scope string) string {
+	section, name, ok := splitConfigKey(key)
+	if !ok {
+		return ""
+	}
+	config, err := readConfigScope(scope)
+	if err != nil {
+		return ""
+	}
+	return config.Get(section, name)
+}
+
+// printConfig prints a config
+func printConfig(config *Config) {
+	for _, section := range config.SectionNames() {
+		for _, key := range config.KeyNames(section) {
+			for _, value := range config.GetAll(section, key) {
+				fmt.Printf("\t%s=%s\n", configKeyName(section, key), value)
+			}
+		}
+	}
+}
+
+// configScopeName names a scope for messages; changes go to the local
+// config when none is given
+func configScopeName(scope string) string {
+	if scope == "" {
+		return "local"
+	}
+	return scope
+}
+
+// getConfigType returns the type of config
+func getConfigType(global bool) string {
+	if global {
+		return "global"
+	}
+	return "local"
+}
\ No newline at end of file
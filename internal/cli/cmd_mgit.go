@@ -0,0 +1,562 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+const commitUsage = "Usage: mgit commit [-m <message>] [-t <template>] [--no-verify]"
+
+// HandleMGitCommit handles the mgit commit command
+func HandleMGitCommit(args []string) {
+	message := ""
+	messageGiven := false
+	template := ""
+	noVerify := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--no-verify" || args[i] == "-n":
+			noVerify = true
+		case (args[i] == "-m" || args[i] == "-t" || args[i] == "--template") && i+1 < len(args):
+			if args[i] == "-m" {
+				message, messageGiven = args[i+1], true
+			} else {
+				template = args[i+1]
+			}
+			i++
+		default:
+			fmt.Println(commitUsage)
+			os.Exit(1)
+		}
+	}
+
+	if messageGiven && message == "" {
+		fmt.Println("Error: aborting commit due to empty commit message")
+		os.Exit(1)
+	}
+
+	// Get user information from config
+	userName := GetConfigValue("user.name", "")
+	userEmail := GetConfigValue("user.email", "")
+	userPubkey := GetNostrPubKey()
+
+	if userName == "" || userEmail == "" {
+		fmt.Println("Please set your user name and email first:")
+		fmt.Println("  mgit config --global user.name \"Your Name\"")
+		fmt.Println("  mgit config --global user.email \"your.email@example.com\"")
+		os.Exit(1)
+	}
+
+	// Without -m the message is written in the editor, from the template
+	if !messageGiven {
+		var err error
+		if message, err = editCommitMessage(commitTemplatePath(template)); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if !noVerify {
+		mode, problems, err := lintCommitMessage(message)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("Error: the commit message does not pass commit.lint (%s):\n", mode)
+			for _, problem := range problems {
+				fmt.Printf("  %s\n", problem)
+			}
+			if !messageGiven {
+				fmt.Printf("The message is kept in %s\n", filepath.Join(".mgit", commitEditMsgFile))
+			}
+			fmt.Println("Fix the message, or commit with --no-verify to skip the check")
+			os.Exit(1)
+		}
+	}
+	defer lockRepoOrExit("commit").Release()
+
+	// A partial working copy only commits changes inside its scope
+	prefixes, err := partialScope(".")
+	if err != nil {
+		fmt.Printf("Error reading partial scope: %s\n", err)
+		os.Exit(1)
+	}
+	outside, err := stagedOutsideScope(".", prefixes)
+	if err != nil {
+		fmt.Printf("Error checking partial scope: %s\n", err)
+		os.Exit(1)
+	}
+	if len(outside) > 0 {
+		fmt.Printf("Error: staged changes outside the partial scope (%s):\n", strings.Join(prefixes, ", "))
+		for _, file := range outside {
+			fmt.Printf("  %s\n", file)
+		}
+		os.Exit(1)
+	}
+
+	// Staged files must pass the validate rules that match them
+	if !noVerify {
+		failures, err := validateStaged(".")
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if len(failures) > 0 {
+			printValidationFailures(failures)
+			fmt.Println("Fix the files, or commit with --no-verify to skip the check")
+			os.Exit(1)
+		}
+	}
+
+	// Create the commit with MCommit
+	hash, err := MGitCommit(message, &MCommitOptions{
+		Author: &Signature{
+			Name:   userName,
+			Email:  userEmail,
+			Pubkey: userPubkey,
+			When:   time.Now(),
+		},
+	})
+
+	if err != nil {
+		fmt.Printf("Error committing changes: %s\n", err)
+		os.Exit(1)
+	}
+
+	infof("Committed changes [%s]: %s\n", abbrevHash(hash.String()), strings.SplitN(message, "\n", 2)[0])
+	if userPubkey != "" {
+		autoTimestamp(currentSession().Storage(), "commit", []string{hash.String()})
+	}
+}
+
+// HandleMGitLog handles the mgit log command for the MGit hash chain
+func HandleMGitLog(args []string) {
+	// Parse command line flags
+	oneline := false
+	graph := false
+	decorate := false
+	all := false
+	maxCount := 10 // Default
+	rev := ""
+	
+	for i, arg := range args {
+			switch arg {
+			case "--oneline":
+					oneline = true
+			case "--graph":
+					graph = true
+			case "--decorate=short", "--decorate":
+					decorate = true
+			case "--all":
+					all = true
+			case "--no-resolve":
+					nip05Disabled = true
+			}
+			
+			// A revision to start from, unless it is -n's count
+			if !strings.HasPrefix(arg, "-") && (i == 0 || args[i-1] != "-n") {
+					rev = arg
+			}
+			
+			// Handle -n flag for limiting commits
+			if strings.HasPrefix(arg, "-n") {
+					if len(arg) > 2 {
+							fmt.Sscanf(arg[2:], "%d", &maxCount)
+					} else {
+							// Look for the next argument
+							for i, a := range args {
+									if a == "-n" && i+1 < len(args) {
+											fmt.Sscanf(args[i+1], "%d", &maxCount)
+											break
+									}
+							}
+					}
+			}
+	}
+
+	// Initialize storage
+	session := currentSession()
+	storage := session.Storage()
+	repo := session.MustRepo()
+	requireNoHeadDrift(repo, storage)
+
+	// Collect starting commits based on flags
+	startingCommits := []*mgitlib.Commit{}
+
+	// Get the HEAD commit
+	headCommit, err := storage.GetHeadCommit()
+	if err != nil {
+			fmt.Printf("Error getting HEAD commit: %s\n", err)
+			os.Exit(1)
+	}
+
+	// Or the commit a revision names
+	if rev != "" {
+			revision, err := Resolve(repo, storage, rev)
+			if err != nil {
+					fmt.Printf("Error resolving '%s': %s\n", rev, err)
+					os.Exit(1)
+			}
+			if revision.MGit == "" {
+					fmt.Printf("Error: commit %s has no MGit hash\n", shortHash(revision.Git.String()))
+					os.Exit(1)
+			}
+			headCommit, err = storage.GetCommit(revision.MGit)
+			if err != nil {
+					fmt.Printf("Error getting commit %s: %s\n", shortHash(revision.MGit), err)
+					os.Exit(1)
+			}
+	}
+
+	// If --all flag is specified, include commits from all branches
+	if all {
+		// Get all branches
+		refs, err := repo.References()
+		if err == nil {
+				_ = refs.ForEach(func(ref *plumbing.Reference) error {
+						if ref.Name().IsBranch() {
+								// Skip if this is the current branch (already added as HEAD)
+								if headCommit != nil && ref.Hash().String() == headCommit.GitHash {
+										return nil
+								}
+								
+								// Get MGit hash for this Git hash
+								mgitHash, err := storage.GetMGitHashFromGit(ref.Hash().String())
+								if err == nil {
+										commit, err := storage.GetCommit(mgitHash)
+										if err == nil {
+												startingCommits = append(startingCommits, commit)
+										}
+								}
+						}
+						return nil
+				})
+		}
+	}
+	
+	headRef, err := repo.Head()
+	currentBranch := ""
+	if err == nil && headRef.Name().IsBranch() && rev == "" {
+			currentBranch = headRef.Name().Short()
+	}
+
+	// If not using special formatting, use the default format
+	if !oneline && !graph {
+			fmt.Println("MGit Commit History:")
+			fmt.Println("====================")
+	}
+
+	// A partial working copy only lists commits that touch its scope
+	prefixes, err := partialScope(".")
+	if err != nil {
+			fmt.Printf("Error reading partial scope: %s\n", err)
+			os.Exit(1)
+	}
+	inScope := func(commit *mgitlib.Commit) bool {
+			if len(prefixes) == 0 {
+					return true
+			}
+			gitCommit, err := repo.CommitObject(plumbing.NewHash(commit.GitHash))
+			return err != nil || commitTouchesScope(gitCommit, prefixes)
+	}
+
+	// Start with head commit
+	count := 0
+	if inScope(headCommit) {
+			if oneline {
+					printMGitCommitOneline(headCommit, graph, decorate, currentBranch)
+			} else {
+					printMGitCommit(headCommit)
+			}
+			count++
+	}
+
+	// Process parents recursively with a breadth-first approach
+	visited := map[string]bool{headCommit.MGitHash: true}
+	queue := headCommit.ParentHashes
+
+	for len(queue) > 0 && count < maxCount {
+			currentHash := queue[0]
+			queue = queue[1:]
+
+			if visited[currentHash] {
+					continue
+			}
+
+			commit, err := storage.GetCommit(currentHash)
+			if err != nil {
+					fmt.Printf("Warning: Could not load commit %s: %s\n", currentHash, err)
+					continue
+			}
+
+			if inScope(commit) {
+					if oneline {
+							printMGitCommitOneline(commit, graph, decorate, "")
+					} else {
+							printMGitCommit(commit)
+					}
+					count++
+			}
+			visited[currentHash] = true
+
+			// Add parents to queue
+			for _, parent := range commit.ParentHashes {
+					if !visited[parent] {
+							queue = append(queue, parent)
+					}
+			}
+	}
+}
+
+// printMGitCommitOneline prints a single MGit commit in oneline format
+func printMGitCommitOneline(commit *mgitlib.Commit, showGraph bool, decorate bool, branchName string) {
+	// At least 7 characters of hash (like git), more where they're ambiguous
+	shortHash := abbrevHash(commit.MGitHash)
+	
+	// Add graph symbol if requested
+	prefix := ""
+	if showGraph {
+			prefix = "* "
+	}
+	
+	// Add decoration if requested
+	decoration := ""
+	if decorate && branchName != "" {
+			decoration = fmt.Sprintf(" (HEAD -> %s)", branchName)
+	}
+	
+	// Get first line of commit message
+	message := commit.Message
+	if idx := strings.Index(message, "\n"); idx != -1 {
+			message = message[:idx]
+	}
+	
+	fmt.Printf("%s%s%s %s\n", prefix, shortHash, decoration, message)
+}
+
+// printMGitCommit prints a single MGit commit
+func printMGitCommit(commit *mgitlib.Commit) {
+	writeMGitCommit(os.Stdout, commit)
+}
+
+// writeMGitCommit writes a single MGit commit to w
+func writeMGitCommit(w io.Writer, commit *mgitlib.Commit) {
+	fmt.Fprintf(w, "commit %s\n", commit.MGitHash)
+	fmt.Fprintf(w, "git-commit %s\n", commit.GitHash)
+	
+	pubkeyInfo := ""
+	if commit.Author.Pubkey != "" {
+			pubkeyInfo = fmt.Sprintf(" <%s>", displayPubkey(commit.Author.Pubkey))
+	}
+	
+	author := currentIdentityMap().Resolve(commit.Author.Name, commit.Author.Email)
+	fmt.Fprintf(w, "Author: %s <%s>%s\n", 
+			author.Name, 
+			author.Email,
+			pubkeyInfo)
+	
+	fmt.Fprintf(w, "Date:   %s\n\n", 
+			commit.Author.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	
+	// Print the commit message with indentation
+	for _, line := range strings.Split(commit.Message, "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+	}
+	
+	fmt.Fprintln(w)
+}
+
+// HandleMGitVerify verifies the integrity of the MGit commit chain
+func HandleMGitVerify(args []string) {
+	full := false
+	wot := false
+	for _, arg := range args {
+		switch arg {
+		case "--full":
+			full = true
+		case "--wot":
+			wot = true
+		default:
+			fmt.Println("Usage: mgit verify [--full] [--wot]")
+			os.Exit(1)
+		}
+	}
+	
+	// An interrupted verify records no checkpoint
+	ctx := commandContext()
+	
+	// Checking keys covers the whole history, as the trust set may have
+	// changed since the last verify
+	var trustSet *TrustSet
+	if wot {
+		var err error
+		if trustSet, err = LoadTrustSet(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		full = true
+	}
+	
+	session := currentSession()
+	repo := session.MustRepo()
+	storage := session.Storage()
+	requireNoHeadDrift(repo, storage)
+	
+	if trustSet != nil {
+		rotations, err := loadKeyRotations(storage)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		trustSet.followRotations(rotations)
+	}
+	
+	// Commits an earlier verify checked need not be walked again
+	checkpoints := map[string]bool{}
+	if !full {
+		var err error
+		if checkpoints, err = storage.VerifiedCheckpoints(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	
+	// Get all commits
+	headCommit, err := storage.GetHeadCommit()
+	if err != nil {
+		fmt.Printf("Error getting HEAD commit: %s\n", err)
+		os.Exit(1)
+	}
+	
+	// Build the commit graph a generation at a time, reading each
+	// generation's commits in parallel
+	hashes := []string{}
+	commits := []*mgitlib.Commit{}
+	visited := make(map[string]bool)
+	frontier := []string{headCommit.MGitHash}
+	reachedCheckpoint := false
+	progress := newProgress("verify", nil)
+	progress.Phase("Reading commits", 0)
+	
+	for len(frontier) > 0 {
+		pending := []string{}
+		for _, hash := range frontier {
+			if visited[hash] {
+				continue
+			}
+			visited[hash] = true
+			if checkpoints[hash] {
+				reachedCheckpoint = true
+				continue
+			}
+			pending = append(pending, hash)
+		}
+		
+		read := make([]*mgitlib.Commit, len(pending))
+		readErrs := make([]error, len(pending))
+		err := runJobsContext(ctx, len(pending), func(_, i int) {
+			read[i], readErrs[i] = storage.GetCommit(pending[i])
+		})
+		exitIfInterrupted(err)
+		progress.Add(int64(len(pending)))
+		
+		frontier = nil
+		for i, commit := range read {
+			if readErrs[i] != nil {
+				progress.Println(os.Stdout, fmt.Sprintf("Error getting commit %s: %s", pending[i], readErrs[i]))
+				continue
+			}
+			hashes = append(hashes, pending[i])
+			commits = append(commits, commit)
+			for _, parent := range commit.ParentHashes {
+				if !visited[parent] {
+					frontier = append(frontier, parent)
+				}
+			}
+		}
+	}
+	
+	progress.Finish()
+	
+	// Verify each commit's hash
+	if reachedCheckpoint {
+		infof("Verifying %d MGit commits added since the last verify (--full to check all)...\n", len(commits))
+	} else {
+		infof("Verifying %d MGit commits...\n", len(commits))
+	}
+	
+	// Each check's output is kept and printed in walk order
+	reports := make([]string, len(commits))
+	repos := newWorkerRepos(session.Path, repo)
+	progress.Phase("Verifying commits", int64(len(commits)))
+	err = runJobsContext(ctx, len(commits), func(worker, i int) {
+		defer progress.Add(1)
+		hash, commit := hashes[i], commits[i]
+		workerRepo, err := repos.get(worker)
+		if err != nil {
+			reports[i] = fmt.Sprintf("Error opening repository: %s\n", err)
+			return
+		}
+		
+		// Get the Git commit
+		gitHash := commit.GitHash
+		gitCommit, err := workerRepo.CommitObject(plumbing.NewHash(gitHash))
+		if err != nil {
+			reports[i] = fmt.Sprintf("Error: Cannot find Git commit %s: %s\n", gitHash, err)
+			return
+		}
+		
+		// Compute the expected MGit hash, in the format the commit was made in
+		expected := mgitlib.GitHashInput(gitCommit, commit.ParentHashes, commit.Author.Pubkey)
+		expected.Format = commit.Format
+		expectedHash := mgitlib.Hash(expected)
+		
+		if expectedHash.String() != hash {
+			reports[i] = fmt.Sprintf("Hash verification failed for commit %s:\n  Expected: %s\n  Actual:   %s\n",
+				hash, expectedHash.String(), hash)
+		}
+	})
+	exitIfInterrupted(err)
+	progress.Finish()
+	
+	valid := true
+	for _, report := range reports {
+		if report != "" {
+			fmt.Print(report)
+			valid = false
+		}
+	}
+	
+	if !reportTimestamps(storage, hashes) {
+		valid = false
+	}
+	
+	if valid {
+		if err := storage.AddVerifiedCheckpoint(headCommit.MGitHash); err != nil {
+			fmt.Printf("Warning: could not record verify checkpoint: %s\n", err)
+		}
+		infof("MGit commit chain verification successful!\n")
+	} else {
+		fmt.Println("MGit commit chain verification failed!")
+	}
+	
+	if trustSet != nil {
+		infof("Checking commit keys against a trust set of %s...\n", trustSet.describe())
+		if untrusted := reportUntrusted(trustSet, commits); untrusted > 0 {
+			fmt.Printf("%d of %d commits are from untrusted keys\n", untrusted, len(commits))
+			valid = false
+		} else {
+			fmt.Printf("All %d commits are from trusted keys\n", len(commits))
+		}
+	}
+	if !valid {
+		os.Exit(1)
+	}
+}
\ No newline at end of file
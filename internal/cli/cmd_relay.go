@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const relayUsage = "Usage: mgit relay list | add [--local] <url>... | remove [--local] <url>... | test [<url>...]"
+
+// HandleRelay handles the relay command. Relays are kept in nostr.relays, in
+// the global config unless --local is given.
+func HandleRelay(args []string) {
+	if len(args) == 0 {
+		fmt.Println(relayUsage)
+		os.Exit(1)
+	}
+	local := false
+	relays := []string{}
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--local":
+			local = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println(relayUsage)
+			os.Exit(1)
+		default:
+			relays = append(relays, strings.TrimSpace(arg))
+		}
+	}
+
+	var err error
+	switch {
+	case args[0] == "list" && len(relays) == 0 && !local:
+		listRelays()
+	case args[0] == "add" && len(relays) > 0:
+		err = addRelays(relays, !local)
+	case args[0] == "remove" && len(relays) > 0:
+		err = removeRelays(relays, !local)
+	case args[0] == "test" && !local:
+		if len(relays) == 0 {
+			relays = configuredRelays()
+		}
+		if len(relays) == 0 {
+			err = fmt.Errorf("no relays configured (add one with mgit relay add <url>)")
+		} else if !testRelays(relays) {
+			os.Exit(1)
+		}
+	default:
+		fmt.Println(relayUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// listRelays prints the configured relays and where each is set
+func listRelays() {
+	if value, exists := os.LookupEnv("MGIT_NOSTR_RELAYS"); exists {
+		for _, relay := range GetConfigValues("nostr.relays") {
+			fmt.Printf("%s (MGIT_NOSTR_RELAYS)\n", relay)
+		}
+		if strings.TrimSpace(value) == "" {
+			fmt.Println("No relays (MGIT_NOSTR_RELAYS is empty)")
+		}
+		return
+	}
+
+	found := false
+	for _, scope := range configScopes {
+		config, err := readConfigScope(scope)
+		if err != nil {
+			continue
+		}
+		for _, relay := range relayItems(config.GetAll("nostr", "relays")) {
+			fmt.Printf("%s (%s)\n", relay, scope)
+			found = true
+		}
+	}
+	if !found {
+		fmt.Println("No relays configured (add one with mgit relay add <url>)")
+	}
+}
+
+// relayItems splits config values that may list several relays separated by
+// commas
+func relayItems(values []string) []string {
+	items := []string{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = appendUnique(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// addRelays adds relays to nostr.relays, skipping those already there
+func addRelays(relays []string, global bool) error {
+	for _, relay := range relays {
+		if err := validateRelayURL(relay); err != nil {
+			return err
+		}
+	}
+	return UpdateConfig(GetConfigFilePath(global), func(config *Config) error {
+		existing := relayItems(config.GetAll("nostr", "relays"))
+		for _, relay := range relays {
+			if containsString(existing, relay) {
+				fmt.Printf("%s is already in the %s config\n", relay, getConfigType(global))
+				continue
+			}
+			config.Add("nostr", "relays", relay)
+			existing = append(existing, relay)
+			fmt.Printf("Added %s to the %s config\n", relay, getConfigType(global))
+		}
+		return nil
+	})
+}
+
+// removeRelays removes relays from nostr.relays, one value per relay left
+func removeRelays(relays []string, global bool) error {
+	return UpdateConfig(GetConfigFilePath(global), func(config *Config) error {
+		existing := relayItems(config.GetAll("nostr", "relays"))
+		for _, relay := range relays {
+			if !containsString(existing, relay) {
+				return fmt.Errorf("%s is not in the %s config", relay, getConfigType(global))
+			}
+		}
+		config.Unset("nostr", "relays", "")
+		for _, relay := range existing {
+			if containsString(relays, relay) {
+				fmt.Printf("Removed %s from the %s config\n", relay, getConfigType(global))
+			} else {
+				config.Add("nostr", "relays", relay)
+			}
+		}
+		return nil
+	})
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// testRelays connects to each relay and prints its round trip time and the
+// capabilities its NIP-11 document advertises. It reports whether all
+// answered.
+func testRelays(relays []string) bool {
+	type report struct {
+		rtt  time.Duration
+		err  error
+		info *RelayInfo
+	}
+	pool := relayPool()
+	reports := make([]report, len(relays))
+	var wg sync.WaitGroup
+	for i, relay := range relays {
+		wg.Add(1)
+		go func(i int, relay string) {
+			defer wg.Done()
+			if err := validateRelayURL(relay); err != nil {
+				reports[i].err = err
+				return
+			}
+			reports[i].info, _ = pool.Info(relay)
+			reports[i].rtt, reports[i].err = pool.Ping(commandContext(), relay)
+		}(i, relay)
+	}
+	wg.Wait()
+
+	ok := true
+	for i, relay := range relays {
+		r := reports[i]
+		fmt.Println(relay)
+		if r.err != nil {
+			ok = false
+			fmt.Printf("  error: %s\n", r.err)
+		} else {
+			fmt.Printf("  connected, answered in %s\n", r.rtt.Round(time.Millisecond))
+		}
+		if r.info == nil {
+			fmt.Println("  no NIP-11 information")
+			continue
+		}
+		if about := strings.Join(strings.Fields(r.info.Name+" "+r.info.Software+" "+r.info.Version), " "); about != "" {
+			fmt.Printf("  %s\n", about)
+		}
+		if len(r.info.SupportedNIPs) > 0 {
+			nips := append([]int{}, r.info.SupportedNIPs...)
+			sort.Ints(nips)
+			list := []string{}
+			for _, nip := range nips {
+				list = append(list, fmt.Sprint(nip))
+			}
+			fmt.Printf("  NIPs: %s\n", strings.Join(list, ", "))
+		}
+		limits := []string{}
+		if n := r.info.Limitation.MaxMessageLength; n > 0 {
+			limits = append(limits, fmt.Sprintf("messages up to %d bytes", n))
+		}
+		if r.info.Limitation.AuthRequired {
+			limits = append(limits, "NIP-42 authentication required (mgit can't publish here)")
+		}
+		if r.info.Limitation.PaymentRequired {
+			limits = append(limits, "payment required")
+		}
+		if r.info.Limitation.RestrictedWrites {
+			limits = append(limits, "restricted writes")
+		}
+		if len(limits) > 0 {
+			fmt.Printf("  Limits: %s\n", strings.Join(limits, "; "))
+		}
+	}
+	return ok
+}
@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// mgit commit without -m opens an editor on .mgit/COMMIT_EDITMSG, filled
+// with the commit.template file when one is configured (or given with
+// --template). Lines starting with '#' are dropped, and a message left empty
+// or unchanged from the template aborts the commit, as in git.
+//
+// Messages are then checked by the linter commit.lint selects:
+//
+//	off           no checks (the default)
+//	conventional  Conventional Commits: "type(scope)!: description", a type
+//	              from commit.lintTypes, and a blank line before any body
+//	regex         the subject line must match commit.lintPattern
+//
+// In either mode the subject may be at most commit.lintMaxSubject
+// characters (default 100). --no-verify skips the linter.
+
+const (
+	commitEditMsgFile     = "COMMIT_EDITMSG"
+	defaultLintMaxSubject = 100
+	commitMessageHelp     = "# Please enter the commit message for your changes. Lines starting\n# with '#' will be ignored, and an empty message aborts the commit.\n"
+)
+
+// defaultLintTypes are the Conventional Commits types accepted when
+// commit.lintTypes is not set
+var defaultLintTypes = []string{"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert"}
+
+// conventionalHeader matches "type(scope)!: description"
+var conventionalHeader = regexp.MustCompile(`^([a-zA-Z]+)(\([^()\s]+\))?(!)?: (\S.*)$`)
+
+// commitTemplatePath returns the template file to start a message from:
+// the one given, or commit.template
+func commitTemplatePath(given string) string {
+	if given == "" {
+		given = GetConfigPath("commit.template", "")
+	}
+	if given == "" {
+		return ""
+	}
+	return expandHome(given)
+}
+
+// editCommitMessage opens the editor on .mgit/COMMIT_EDITMSG, filled with
+// the template, and returns the message written
+func editCommitMessage(templatePath string) (string, error) {
+	template := ""
+	if templatePath != "" {
+		data, err := os.ReadFile(templatePath)
+		if err != nil {
+			return "", fmt.Errorf("error reading commit template: %w", err)
+		}
+		template = string(data)
+	}
+
+	path := filepath.Join(".mgit", commitEditMsgFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	content := template
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += "\n" + commitMessageHelp
+	if err := fsutil.WriteFile(path, []byte(content)); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", path, err)
+	}
+
+	editor := commitEditor()
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor '%s' failed: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	message := cleanupCommitMessage(string(data))
+	if message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	if template != "" && message == cleanupCommitMessage(template) {
+		return "", fmt.Errorf("aborting commit; you did not edit the message from the template")
+	}
+	return message, nil
+}
+
+// commitEditor returns the editor command, chosen as git chooses it
+func commitEditor() string {
+	if editor := GetConfigValue("core.editor", ""); editor != "" {
+		return editor
+	}
+	for _, name := range []string{"GIT_EDITOR", "VISUAL", "EDITOR"} {
+		if editor := os.Getenv(name); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// cleanupCommitMessage drops comment lines, trailing whitespace and
+// surrounding or repeated blank lines
+func cleanupCommitMessage(message string) string {
+	lines := []string{}
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// lintCommitMessage checks a message against the commit.lint rules and
+// returns what is wrong with it
+func lintCommitMessage(message string) (string, []string, error) {
+	mode := GetConfigValue("commit.lint", "off")
+	if mode == "off" || mode == "false" || mode == "" {
+		return mode, nil, nil
+	}
+
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	subject := lines[0]
+	problems := []string{}
+
+	switch mode {
+	case "conventional":
+		types := GetConfigValues("commit.lintTypes")
+		if len(types) == 0 {
+			types = defaultLintTypes
+		}
+		match := conventionalHeader.FindStringSubmatch(subject)
+		if match == nil {
+			problems = append(problems, `the subject must read "type(scope): description", e.g. "fix(parser): handle empty input"`)
+		} else if !containsString(types, match[1]) {
+			problems = append(problems, fmt.Sprintf("type '%s' is not one of %s", match[1], strings.Join(types, ", ")))
+		}
+		if len(lines) > 1 && lines[1] != "" {
+			problems = append(problems, "the body must be separated from the subject by a blank line")
+		}
+	case "regex":
+		pattern := GetConfigValue("commit.lintPattern", "")
+		if pattern == "" {
+			return mode, nil, fmt.Errorf("commit.lint is regex but commit.lintPattern is not set")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return mode, nil, fmt.Errorf("invalid commit.lintPattern: %w", err)
+		}
+		if !re.MatchString(subject) {
+			problems = append(problems, fmt.Sprintf("the subject does not match commit.lintPattern %s", pattern))
+		}
+	default:
+		return mode, nil, fmt.Errorf("unknown commit.lint mode '%s' (use off, conventional or regex)", mode)
+	}
+
+	maxSubject := defaultLintMaxSubject
+	if n := GetConfigInt("commit.lintMaxSubject", defaultLintMaxSubject); n > 0 {
+		maxSubject = n
+	}
+	if length := len([]rune(subject)); length > maxSubject {
+		problems = append(problems, fmt.Sprintf("the subject is %d characters; at most %d are allowed", length, maxSubject))
+	}
+	return mode, problems, nil
+}
@@ -0,0 +1,469 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// Config represents a git-like config file. A key may be given more than
+// once, as git allows; Sections holds its last value and GetAll all of them,
+// in order. Sections and keys are saved in the order they were first seen,
+// so a file keeps its layout when a value changes.
+type Config struct {
+	Sections map[string]map[string]string
+	values   map[string]map[string][]string
+	sections []string                       // Section names, in order
+	keys     map[string][]string            // Key names per section, in order
+	origins  map[string]map[string][]string // Files the values were read from, by ReadConfig
+}
+
+// Load config from file
+func LoadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Return empty config if file doesn't exist
+			return &Config{
+				Sections: make(map[string]map[string]string),
+			}, nil
+		}
+		return nil, err
+	}
+
+	return parseConfig(string(data))
+}
+
+// Parse a config file content
+func parseConfig(content string) (*Config, error) {
+	config := &Config{
+		Sections: make(map[string]map[string]string),
+	}
+	return config, config.parse(content, nil)
+}
+
+// parse reads a config file's content into the config. entry, when given,
+// is called for each value once it is added, so the values of a file it
+// includes come before those after the include.
+func (c *Config) parse(content string, entry func(section, key, value string) error) error {
+	lines := strings.Split(content, "\n")
+	currentSection := ""
+	
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue // Skip empty lines and comments
+		}
+
+		// Section header [section] or [section "subsection"]
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			sectionName := line[1 : len(line)-1]
+			currentSection = sectionName
+			if _, exists := c.Sections[currentSection]; !exists {
+				c.Sections[currentSection] = make(map[string]string)
+				c.sections = append(c.sections, currentSection)
+			}
+			continue
+		}
+
+		if currentSection == "" {
+			continue // No section defined yet
+		}
+
+		// Key-value pair
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue // Invalid format
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		c.Add(currentSection, key, value)
+		
+		if entry != nil {
+			if err := entry(currentSection, key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Save config to file
+func (c *Config) Save(file string) error {
+	content := ""
+	
+	for _, section := range c.SectionNames() {
+		keys := c.KeyNames(section)
+		if len(keys) == 0 {
+			continue
+		}
+		
+		content += fmt.Sprintf("[%s]\n", section)
+		for _, key := range keys {
+			for _, value := range c.GetAll(section, key) {
+				content += fmt.Sprintf("\t%s = %s\n", key, value)
+			}
+		}
+		content += "\n"
+	}
+	
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	
+	return fsutil.WriteFile(file, []byte(content))
+}
+
+// Get a config value
+func (c *Config) Get(section, key string) string {
+	if values, exists := c.Sections[section]; exists {
+		return values[key]
+	}
+	return ""
+}
+
+// Set a config value, replacing all of the key's values
+func (c *Config) Set(section, key, value string) {
+	if _, exists := c.Sections[section]; !exists {
+		c.Sections[section] = make(map[string]string)
+	}
+	c.Sections[section][key] = value
+	c.setAll(section, key, []string{value})
+}
+
+// Add a value to a key, keeping the values it already has
+func (c *Config) Add(section, key, value string) {
+	values := append(c.GetAll(section, key), value)
+	c.Set(section, key, value)
+	c.setAll(section, key, values)
+}
+
+// GetAll returns all of a key's values, in order
+func (c *Config) GetAll(section, key string) []string {
+	value, exists := c.Sections[section][key]
+	if !exists {
+		return nil
+	}
+	// Sections may have been changed directly since the values were recorded
+	if values := c.values[section][key]; len(values) > 0 && values[len(values)-1] == value {
+		return append([]string{}, values...)
+	}
+	return []string{value}
+}
+
+// Unset removes a key's values equal to value, or all of them for ""
+func (c *Config) Unset(section, key, value string) {
+	kept := []string{}
+	for _, v := range c.GetAll(section, key) {
+		if value != "" && v != value {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == 0 {
+		delete(c.Sections[section], key)
+		delete(c.values[section], key)
+		return
+	}
+	c.Sections[section][key] = kept[len(kept)-1]
+	c.setAll(section, key, kept)
+}
+
+// Matching counts a key's values equal to value, or all of them for ""
+func (c *Config) Matching(section, key, value string) int {
+	count := 0
+	for _, v := range c.GetAll(section, key) {
+		if value == "" || v == value {
+			count++
+		}
+	}
+	return count
+}
+
+// RemoveSection removes a section and all of its keys, reporting whether
+// it existed
+func (c *Config) RemoveSection(section string) bool {
+	if _, exists := c.Sections[section]; !exists {
+		return false
+	}
+	delete(c.Sections, section)
+	delete(c.values, section)
+	delete(c.keys, section)
+	return true
+}
+
+// SectionNames returns the sections in order: those read or set through
+// Config's methods as they came, then any added to Sections directly
+func (c *Config) SectionNames() []string {
+	present := []string{}
+	for section := range c.Sections {
+		present = append(present, section)
+	}
+	return orderedNames(c.sections, present)
+}
+
+// KeyNames returns a section's keys in order, as SectionNames does
+func (c *Config) KeyNames(section string) []string {
+	present := []string{}
+	for key := range c.Sections[section] {
+		present = append(present, key)
+	}
+	return orderedNames(c.keys[section], present)
+}
+
+// orderedNames returns the names in present in the order given, followed
+// by the rest, sorted
+func orderedNames(order, present []string) []string {
+	names := []string{}
+	for _, name := range order {
+		if containsString(present, name) && !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	rest := []string{}
+	for _, name := range present {
+		if !containsString(names, name) {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// setAll records all of a key's values
+func (c *Config) setAll(section, key string, values []string) {
+	if c.values == nil {
+		c.values = make(map[string]map[string][]string)
+	}
+	if _, exists := c.values[section]; !exists {
+		c.values[section] = make(map[string][]string)
+	}
+	c.values[section][key] = values
+	
+	if !containsString(c.sections, section) {
+		c.sections = append(c.sections, section)
+	}
+	if c.keys == nil {
+		c.keys = make(map[string][]string)
+	}
+	if !containsString(c.keys[section], key) {
+		c.keys[section] = append(c.keys[section], key)
+	}
+}
+
+// mgitUserDir returns the directory holding the user's mgit files:
+// ~/.mgitconfig, or on Linux $XDG_CONFIG_HOME/mgit when XDG_CONFIG_HOME is
+// set and there is no ~/.mgitconfig yet
+func mgitUserDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Join(home, ".mgitconfig")
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); runtime.GOOS == "linux" && filepath.IsAbs(xdg) {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return filepath.Join(xdg, "mgit")
+		}
+	}
+	return dir
+}
+
+// GetConfigFilePath returns the path to the config file. The global one is
+// MGIT_CONFIG_GLOBAL, else config in the user's mgit directory; keys and
+// caches are kept next to it.
+func GetConfigFilePath(global bool) string {
+	if global {
+		if path := os.Getenv("MGIT_CONFIG_GLOBAL"); path != "" {
+			return path
+		}
+		dir := mgitUserDir()
+		if dir == "" {
+			return ""
+		}
+		return filepath.Join(dir, "config")
+	}
+	
+	// Local config
+	return ".mgit/config"
+}
+
+// defaultSystemConfigPath is the system config, shared by every user of
+// the machine
+const defaultSystemConfigPath = "/etc/mgitconfig"
+
+// configScopes are the config files values are read from, lowest
+// precedence first. Environment variables override them all.
+var configScopes = []string{"system", "global", "git", "local"}
+
+// GetSystemConfigFilePath returns the path to the system config:
+// MGIT_CONFIG_SYSTEM, or /etc/mgitconfig
+func GetSystemConfigFilePath() string {
+	if path := os.Getenv("MGIT_CONFIG_SYSTEM"); path != "" {
+		return path
+	}
+	return defaultSystemConfigPath
+}
+
+// configScopePath returns the file of a config scope, the local one for ""
+func configScopePath(scope string) string {
+	switch scope {
+	case "system":
+		return GetSystemConfigFilePath()
+	case "global":
+		return GetConfigFilePath(true)
+	case "git":
+		return gitConfigPath
+	}
+	return GetConfigFilePath(false)
+}
+
+// splitConfigKey splits a key into the section it is in, as the file names
+// the section, and its name: user.name is name in [user], and
+// remote.origin.url is url in [remote "origin"]
+func splitConfigKey(key string) (section, name string, ok bool) {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return "", "", false
+	}
+	if first == last {
+		return key[:first], key[first+1:], true
+	}
+	return fmt.Sprintf("%s \"%s\"", key[:first], key[first+1:last]), key[last+1:], true
+}
+
+// GetConfigValue gets a config value: from the environment, else the local
+// config, the repository's git config, the active identity profile, the
+// global config and the system config, in that order
+func GetConfigValue(key, defaultValue string) string {
+	// First check environment variables (for backward compatibility)
+	envKey := "MGIT_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+	if value, exists := os.LookupEnv(envKey); exists {
+		return value
+	}
+	
+	// Parse the key into section and name
+	section, name, ok := splitConfigKey(key)
+	if !ok {
+		return defaultValue
+	}
+	
+	// Check local config first
+	localConfigPath := GetConfigFilePath(false)
+	localConfig, err := ReadConfig(localConfigPath)
+	if err == nil {
+		value := localConfig.Get(section, name)
+		if value != "" {
+			return value
+		}
+	}
+	
+	// Then the repository's git config
+	gitConfig, err := readGitConfig(gitConfigPath)
+	if err == nil {
+		value := gitConfig.Get(section, name)
+		if value != "" {
+			return value
+		}
+	}
+	
+	// Then the active identity profile, for the keys it stands in for
+	if value, ok := profileConfigValue(key); ok {
+		if value == "" {
+			return defaultValue
+		}
+		return value
+	}
+	
+	// Then check global config
+	globalConfigPath := GetConfigFilePath(true)
+	globalConfig, err := ReadConfig(globalConfigPath)
+	if err == nil {
+		value := globalConfig.Get(section, name)
+		if value != "" {
+			return value
+		}
+	}
+	
+	// And last the system config
+	systemConfig, err := ReadConfig(GetSystemConfigFilePath())
+	if err == nil {
+		value := systemConfig.Get(section, name)
+		if value != "" {
+			return value
+		}
+	}
+	
+	return defaultValue
+}
+
+// GetConfigValues gets all of a multi-valued key's values: those in the
+// system config, then the global, the git and the local one's, or the
+// environment variable's instead. Values may also list several items separated by commas.
+func GetConfigValues(key string) []string {
+	raw := []string{}
+	envKey := "MGIT_" + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+	if value, exists := os.LookupEnv(envKey); exists {
+		raw = append(raw, value)
+	} else if section, name, ok := splitConfigKey(key); ok {
+		for _, scope := range configScopes {
+			config, err := readConfigScope(scope)
+			if err == nil {
+				raw = append(raw, config.GetAll(section, name)...)
+			}
+		}
+	}
+	
+	values := []string{}
+	for _, value := range raw {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = appendUnique(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// SetConfigValue sets a config value in either local or global config
+func SetConfigValue(key, value string, global bool) error {
+	return setConfigFileValue(GetConfigFilePath(global), key, value)
+}
+
+// setConfigFileValue sets a config value in the given config file
+func setConfigFileValue(file, key, value string) error {
+	section, name, ok := splitConfigKey(key)
+	if !ok {
+		return fmt.Errorf("invalid config key format: %s", key)
+	}
+	return UpdateConfig(file, func(config *Config) error {
+		config.Set(section, name, value)
+		return nil
+	})
+}
+
+// UpdateConfig loads a config file, applies fn and saves the result. The
+// file is locked from load to save, so concurrent updates don't lose each
+// other's changes.
+func UpdateConfig(file string, fn func(*Config) error) error {
+	lock, err := fsutil.LockFile(file, fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	
+	config, err := LoadConfig(file)
+	if err != nil {
+		return err
+	}
+	if err := fn(config); err != nil {
+		return err
+	}
+	return config.Save(file)
+}
\ No newline at end of file
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A config file can pull in others, as git's can:
+//
+//	[include]
+//		path = ~/.mgitconfig/org-defaults
+//	[includeIf "gitdir:~/work/"]
+//		path = ~/.mgitconfig/work
+//
+// An include section's files are read where the section is, so values
+// after it override theirs. includeIf applies only when its condition
+// holds; "gitdir:<pattern>" (or "gitdir/i:" to ignore case) matches the
+// .git directory of the repository mgit runs in. As in git, a pattern
+// starting with ~/ is under the home directory and one starting with ./
+// is next to the config file; any other relative pattern may match at any
+// depth, and a pattern ending in / matches everything under it. Relative
+// paths are next to the including file, and files that don't exist are
+// skipped.
+//
+// Includes apply to the values commands read, not to mgit config's
+// changes, which rewrite only the file they name.
+
+// maxIncludeDepth bounds how deeply includes may nest
+const maxIncludeDepth = 10
+
+// ReadConfig loads a config file for reading values: LoadConfig, with the
+// files it includes read in. The result must not be saved.
+func ReadConfig(file string) (*Config, error) {
+	config := &Config{
+		Sections: make(map[string]map[string]string),
+	}
+	return config, config.readFile(file, 0)
+}
+
+// readFile parses a file, and those it includes, into the config
+func (c *Config) readFile(file string, depth int) error {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.parse(string(data), func(section, key, path string) error {
+		c.addOrigin(section, key, file)
+		if key != "path" || !isIncludeSection(section) || !includeApplies(section, file) {
+			return nil
+		}
+		if depth+1 >= maxIncludeDepth {
+			return fmt.Errorf("includes nest more than %d deep at %s", maxIncludeDepth, file)
+		}
+		path = expandHome(path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(file), path)
+		}
+		return c.readFile(path, depth+1)
+	})
+}
+
+// addOrigin records the file a key's latest value was read from
+func (c *Config) addOrigin(section, key, file string) {
+	if c.origins == nil {
+		c.origins = make(map[string]map[string][]string)
+	}
+	if c.origins[section] == nil {
+		c.origins[section] = make(map[string][]string)
+	}
+	c.origins[section][key] = append(c.origins[section][key], file)
+}
+
+// Origins returns the files a key's values were read from, in the order of
+// GetAll, for a config loaded with ReadConfig
+func (c *Config) Origins(section, key string) []string {
+	return c.origins[section][key]
+}
+
+// isIncludeSection reports whether a section names files to include
+func isIncludeSection(section string) bool {
+	return section == "include" || strings.HasPrefix(section, "includeIf \"")
+}
+
+// includeApplies reports whether an include section's files are read,
+// for the config file holding it
+func includeApplies(section, file string) bool {
+	if section == "include" {
+		return true
+	}
+	condition := strings.TrimSuffix(strings.TrimPrefix(section, "includeIf \""), "\"")
+	switch {
+	case strings.HasPrefix(condition, "gitdir:"):
+		return matchGitDir(strings.TrimPrefix(condition, "gitdir:"), file, false)
+	case strings.HasPrefix(condition, "gitdir/i:"):
+		return matchGitDir(strings.TrimPrefix(condition, "gitdir/i:"), file, true)
+	}
+	// Conditions mgit doesn't know never hold, as in git
+	return false
+}
+
+// matchGitDir reports whether the .git directory of the repository in the
+// working directory matches a gitdir pattern
+func matchGitDir(pattern, file string, foldCase bool) bool {
+	dir, err := filepath.Abs(".git")
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(pattern, "~/"):
+		pattern = expandHome(pattern)
+	case strings.HasPrefix(pattern, "./"):
+		if abs, err := filepath.Abs(filepath.Dir(file)); err == nil {
+			pattern = filepath.Join(abs, pattern[2:])
+		}
+	case !filepath.IsAbs(pattern):
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	pattern, dir = filepath.ToSlash(pattern), filepath.ToSlash(dir)
+	if foldCase {
+		pattern, dir = strings.ToLower(pattern), strings.ToLower(dir)
+	}
+	return matchPathPattern(strings.TrimPrefix(pattern, "/"), strings.TrimPrefix(dir, "/"))
+}
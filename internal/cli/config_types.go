@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config values are strings; these accessors read them as the type a key
+// holds. Booleans are read as git reads them: true, yes, on and 1 are
+// true, false, no, off and 0 false, in any case. Integers may end in k, m
+// or g for units of 1024. Durations are Go durations (90s, 1h30m), and
+// paths may start with ~/ for the home directory. A key that isn't set
+// gives the default; so does one that doesn't parse, with a warning once
+// per command.
+//
+// The keys below are checked when mgit config sets them, so a bad value
+// is refused then rather than ignored later.
+
+// configKeyTypes gives the type of the known keys that aren't free text
+var configKeyTypes = map[string]string{
+	"clone.resumable":        "bool",
+	"http.sslVerify":         "bool",
+	"http.verbose":           "bool",
+	"mirror.auto":            "bool",
+	"nip05.resolve":          "bool",
+	"push.notify":            "bool",
+	"resolve.remoteFallback": "bool",
+	"status.cache":           "bool",
+	"clone.resumeBatch":      "int",
+	"commit.lintMaxSubject":  "int",
+	"http.retries":           "int",
+	"mgit.jobs":              "int",
+	"nostr.relayRetries":     "int",
+	"auth.refreshBefore":     "duration",
+	"http.retryDelay":        "duration",
+	"lock.staleAfter":        "duration",
+	"nip05.cacheTTL":         "duration",
+	"nostr.relayTimeout":     "duration",
+	"serve.tokenTTL":         "duration",
+	"http.timeout":           "timeout",
+	"commit.template":        "path",
+	"daemon.socket":          "path",
+	"repository.owner":       "pubkey",
+	"user.pubkey":            "pubkey",
+}
+
+// configWarnings holds the keys already warned about
+var (
+	configWarnings   = map[string]bool{}
+	configWarningsMu sync.Mutex
+)
+
+// GetConfigBool gets a boolean config value
+func GetConfigBool(key string, defaultValue bool) bool {
+	value := GetConfigValue(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	b, err := parseConfigBool(value)
+	if err != nil {
+		warnConfigValue(key, value, strconv.FormatBool(defaultValue))
+		return defaultValue
+	}
+	return b
+}
+
+// GetConfigInt gets an integer config value
+func GetConfigInt(key string, defaultValue int) int {
+	value := GetConfigValue(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	n, err := parseConfigInt(value)
+	if err != nil {
+		warnConfigValue(key, value, strconv.Itoa(defaultValue))
+		return defaultValue
+	}
+	return n
+}
+
+// GetConfigDuration gets a duration config value
+func GetConfigDuration(key string, defaultValue time.Duration) time.Duration {
+	value := GetConfigValue(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		warnConfigValue(key, value, defaultValue.String())
+		return defaultValue
+	}
+	return d
+}
+
+// GetConfigPath gets a path config value, with a leading ~ expanded
+func GetConfigPath(key, defaultValue string) string {
+	return expandHome(GetConfigValue(key, defaultValue))
+}
+
+// parseConfigBool parses a boolean as git does
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("'%s' is not a boolean (use true or false)", value)
+}
+
+// parseConfigInt parses an integer, which may end in k, m or g
+func parseConfigInt(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	unit := 1
+	if value != "" {
+		switch strings.ToLower(value[len(value)-1:]) {
+		case "k":
+			unit = 1 << 10
+		case "m":
+			unit = 1 << 20
+		case "g":
+			unit = 1 << 30
+		}
+		if unit > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not an integer", value)
+	}
+	return n * unit, nil
+}
+
+// checkConfigValue checks a value for a known key before it is set, and
+// returns it as it is saved: public keys as npubs, other values unchanged
+func checkConfigValue(key, value string) (string, error) {
+	var err error
+	switch configKeyTypes[key] {
+	case "bool":
+		_, err = parseConfigBool(value)
+	case "int":
+		_, err = parseConfigInt(value)
+	case "duration":
+		if _, perr := time.ParseDuration(value); perr != nil {
+			err = fmt.Errorf("'%s' is not a duration (such as 30s or 1h)", value)
+		}
+	case "timeout":
+		_, err = parseHTTPTimeout(value)
+	case "pubkey":
+		value, err = NormalizeNostrPubKey(value)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// warnConfigValue reports a value that doesn't parse, once per key
+func warnConfigValue(key, value, fallback string) {
+	configWarningsMu.Lock()
+	defer configWarningsMu.Unlock()
+	if configWarnings[key] {
+		return
+	}
+	configWarnings[key] = true
+	fmt.Printf("Warning: invalid %s '%s'; using %s\n", key, value, fallback)
+}
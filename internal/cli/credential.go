@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// mgit speaks git's credential helper protocol both ways. The git-credential
+// auth provider asks `git credential fill` for a URL's credentials, so the
+// helpers already configured for a host (a credential manager, the macOS
+// keychain, a store file) serve mgit too. git is told not to prompt: a URL
+// no helper knows is simply skipped.
+//
+// mgit credential is itself a helper, answering git with the tokens in the
+// token store:
+//
+//	git config --global credential.https://git.clinic.example.helper "!mgit credential"
+//	git config --global credential.https://git.clinic.example.useHttpPath true
+//
+// With useHttpPath git names the repository and gets its own token;
+// without it only a server-wide token (mgit server add --token) is found.
+// git 2.46 and later take the token as a Bearer credential; older ones get
+// it as the password of basic auth. Tokens are added with mgit login and
+// removed with mgit logout, so store and erase requests are ignored.
+
+const credentialUsage = "Usage: mgit credential get|store|erase (as a git credential helper)"
+
+// gitCredentialAuthProvider asks git's credential helpers
+type gitCredentialAuthProvider struct{}
+
+func (gitCredentialAuthProvider) Name() string { return "git-credential" }
+
+func (p gitCredentialAuthProvider) Credentials(repoURL string) (*Credentials, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("not a URL git can look up")
+	}
+	request := fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\ncapability[]=authtype\n\n",
+		u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"))
+
+	cmd := exec.Command("git", "-c", "core.askPass=", "credential", "fill")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	cmd.Stdin = strings.NewReader(request)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("no git credential helper has credentials for %s", u.Host)
+	}
+	answer := parseCredentialAttributes(strings.NewReader(string(out)))
+
+	source := "git credential fill"
+	switch {
+	case answer["authtype"] != "" && answer["credential"] != "":
+		return &Credentials{Provider: p.Name(), Scheme: answer["authtype"], Value: answer["credential"], Source: source}, nil
+	case answer["password"] != "":
+		value := base64.StdEncoding.EncodeToString([]byte(answer["username"] + ":" + answer["password"]))
+		return &Credentials{Provider: p.Name(), Scheme: "Basic", Value: value, Source: source}, nil
+	}
+	return nil, fmt.Errorf("git credential fill returned no password for %s", u.Host)
+}
+
+// parseCredentialAttributes reads key=value lines up to a blank line or the
+// end, as git's credential protocol writes them. The values of a repeated
+// key, such as capability[], are joined with spaces.
+func parseCredentialAttributes(r io.Reader) map[string]string {
+	attributes := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if key, value, found := strings.Cut(line, "="); found {
+			if previous, exists := attributes[key]; exists {
+				value = previous + " " + value
+			}
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+// HandleCredential handles the credential command, git's credential helper
+// entry point
+func HandleCredential(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, credentialUsage)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "get":
+		credentialGet(stdinLines, os.Stdout)
+	case "store", "erase":
+		io.Copy(io.Discard, stdinLines)
+	default:
+		fmt.Fprintln(os.Stderr, credentialUsage)
+		os.Exit(1)
+	}
+}
+
+// credentialGet answers a get request with the stored token for the URL,
+// or with nothing so git asks its next helper
+func credentialGet(in io.Reader, out io.Writer) {
+	request := parseCredentialAttributes(in)
+	if request["protocol"] != "http" && request["protocol"] != "https" || request["host"] == "" {
+		return
+	}
+	repoURL := request["protocol"] + "://" + request["host"]
+	if request["path"] != "" {
+		repoURL += "/" + strings.TrimPrefix(request["path"], "/")
+	}
+
+	// Only the answer may go to git on stdout; messages such as a token
+	// refresh go to stderr, where git shows them
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	token, err := lookupTokenForRepo(repoURL)
+	if err == nil {
+		token, err = freshToken(token)
+	}
+	os.Stdout = stdout
+	if err != nil {
+		return
+	}
+
+	if containsString(strings.Fields(request["capability[]"]), "authtype") {
+		fmt.Fprintf(out, "capability[]=authtype\nauthtype=Bearer\ncredential=%s\n", token.Token)
+		return
+	}
+	username := "mgit"
+	if claims, err := parseJWTClaims(token.Token); err == nil && claims.Subject != "" {
+		username = claims.Subject
+	}
+	fmt.Fprintf(out, "username=%s\npassword=%s\n", username, token.Token)
+}
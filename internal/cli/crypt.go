@@ -0,0 +1,891 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/chacha20"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// Encrypted repositories keep the contents of chosen paths encrypted in
+// every commit, so sensitive records can be hosted on a server that is only
+// trusted to store them. mgit crypt add marks paths in .gitattributes with
+// filter=mgit-crypt; git then runs mgit crypt clean on them as they are
+// staged and mgit crypt smudge as they are checked out.
+//
+// Each version of a file gets its own key, which the file carries sealed
+// (NIP-44) to each recipient npub and to the repository secret. Recipients
+// have the repository secrets sealed to them in .mgitcrypt/keys/<hex>.json;
+// mgit crypt unlock opens theirs into .git/mgit-crypt/secrets, where the
+// filters find them without asking for a passphrase. Encryption is
+// deterministic, keyed by the repository secret, so an unchanged file never
+// shows as modified, and the file keys stay unguessable to those without it.
+//
+// An encrypted file is
+//
+//	"\x00MGITCRYPT\x00" | header (JSON) | "\n" | nonce (32) | ciphertext | mac (32)
+//
+// where the ciphertext is ChaCha20 and the mac HMAC-SHA256, under keys
+// expanded from the file key and nonce as in NIP-44. File names, sizes and
+// history stay visible to the server.
+
+const cryptUsage = "Usage: mgit crypt init [<npub>...] | add <pattern>... | grant <npub> | revoke <npub> | unlock | lock | status | cat <file>"
+
+const (
+	// cryptFilter is the gitattributes filter that encrypts a path
+	cryptFilter = "mgit-crypt"
+	// cryptDir holds the recipients' sealed repository secrets
+	cryptDir   = ".mgitcrypt"
+	cryptMagic = "\x00MGITCRYPT\x00"
+)
+
+// CryptGrant is the repository secrets sealed to one recipient
+type CryptGrant struct {
+	Ephemeral string   `json:"ephemeral"` // Key the secrets are sealed from (hex)
+	Secrets   []string `json:"secrets"`   // NIP-44 payloads, oldest first
+}
+
+// cryptHeader describes an encrypted file's key
+type cryptHeader struct {
+	Secret    string            `json:"secret"`    // ID of the repository secret
+	Ephemeral string            `json:"ephemeral"` // Key the file key is sealed from (hex)
+	Keys      map[string]string `json:"keys"`      // File key sealed to each recipient (hex)
+	Sealed    string            `json:"sealed"`    // File key sealed under the repository secret
+}
+
+// cryptInUse reports whether the working directory's repository encrypts
+// files. go-git doesn't run filters, so staging and status go through git.
+func cryptInUse() bool {
+	info, err := os.Stat(cryptDir)
+	return err == nil && info.IsDir()
+}
+
+// cryptSecretID identifies a repository secret without revealing it
+func cryptSecretID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:8])
+}
+
+// cryptDerive derives a 32-byte value from a key, a label and data
+func cryptDerive(key []byte, label string, data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	mac.Write([]byte{0})
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// cryptEphemeralKey derives a secret key from seed
+func cryptEphemeralKey(seed []byte) ([]byte, string, error) {
+	for counter := byte(0); ; counter++ {
+		secret := cryptDerive(seed, "mgit-crypt ephemeral", []byte{counter})
+		// The few derivations outside the key range are skipped
+		pubkey, err := nostr.PublicKey(secret)
+		if err != nil {
+			continue
+		}
+		return secret, hex.EncodeToString(pubkey), nil
+	}
+}
+
+// cryptStream encrypts or decrypts a file's contents in place
+func cryptStream(fileKey, nonce, data []byte) ([]byte, error) {
+	chachaKey, chachaNonce, hmacKey, err := nostr.NIP44MessageKeys(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return nil, err
+	}
+	cipher.XORKeyStream(data, data)
+	return hmacKey, nil
+}
+
+// isCryptFile reports whether data is an encrypted file
+func isCryptFile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(cryptMagic))
+}
+
+// encryptCryptFile encrypts plaintext under the repository secret for the
+// recipients (hex). The same input always gives the same output.
+func encryptCryptFile(secret []byte, recipients []string, plaintext []byte) ([]byte, error) {
+	fileKey := cryptDerive(secret, "mgit-crypt file key", plaintext)
+	ephemeral, ephemeralPub, err := cryptEphemeralKey(cryptDerive(secret, "mgit-crypt ephemeral seed", fileKey))
+	if err != nil {
+		return nil, err
+	}
+
+	header := cryptHeader{Secret: cryptSecretID(secret), Ephemeral: ephemeralPub, Keys: map[string]string{}}
+	for _, recipient := range recipients {
+		conversationKey, err := nostr.NIP44ConversationKey(ephemeral, recipient)
+		if err != nil {
+			return nil, err
+		}
+		nonce := cryptDerive(secret, "mgit-crypt recipient nonce", fileKey, []byte(recipient))
+		if header.Keys[recipient], err = nostr.NIP44Encrypt(conversationKey, nonce, fileKey); err != nil {
+			return nil, err
+		}
+	}
+	if header.Sealed, err = nostr.NIP44Encrypt(secret, cryptDerive(secret, "mgit-crypt sealed nonce", fileKey), fileKey); err != nil {
+		return nil, err
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := cryptDerive(fileKey, "mgit-crypt content nonce")
+	ciphertext := append([]byte{}, plaintext...)
+	hmacKey, err := cryptStream(fileKey, nonce, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(cryptMagic), headerJSON...)
+	out = append(out, '\n')
+	out = append(out, nonce...)
+	out = append(out, ciphertext...)
+	return append(out, nostr.NIP44Mac(hmacKey, nonce, ciphertext)...), nil
+}
+
+// parseCryptFile splits an encrypted file into its header and body
+func parseCryptFile(data []byte) (*cryptHeader, []byte, error) {
+	if !isCryptFile(data) {
+		return nil, nil, fmt.Errorf("not an encrypted file")
+	}
+	rest := data[len(cryptMagic):]
+	end := bytes.IndexByte(rest, '\n')
+	if end < 0 {
+		return nil, nil, fmt.Errorf("encrypted file header is truncated")
+	}
+	header := &cryptHeader{}
+	if err := json.Unmarshal(rest[:end], header); err != nil {
+		return nil, nil, fmt.Errorf("invalid encrypted file header: %w", err)
+	}
+	body := rest[end+1:]
+	if len(body) < 64 {
+		return nil, nil, fmt.Errorf("encrypted file is truncated")
+	}
+	return header, body, nil
+}
+
+// openCryptFile decrypts the body of an encrypted file with its key
+func openCryptFile(fileKey, body []byte) ([]byte, error) {
+	nonce, ciphertext, mac := body[:32], body[32:len(body)-32], body[len(body)-32:]
+	plaintext := append([]byte{}, ciphertext...)
+	hmacKey, err := cryptStream(fileKey, nonce, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, nostr.NIP44Mac(hmacKey, nonce, ciphertext)) {
+		return nil, fmt.Errorf("encrypted file failed authentication")
+	}
+	return plaintext, nil
+}
+
+// decryptCryptFile decrypts a file with one of the repository secrets
+func decryptCryptFile(secrets [][]byte, data []byte) ([]byte, error) {
+	header, body, err := parseCryptFile(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		if cryptSecretID(secret) != header.Secret {
+			continue
+		}
+		fileKey, err := nostr.NIP44Decrypt(secret, header.Sealed)
+		if err != nil {
+			return nil, err
+		}
+		return openCryptFile(fileKey, body)
+	}
+	return nil, fmt.Errorf("encrypted with a repository secret this clone doesn't have")
+}
+
+// decryptCryptFileWithKey decrypts a file with a recipient's secret key
+func decryptCryptFileWithKey(nostrSecret []byte, data []byte) ([]byte, error) {
+	header, body, err := parseCryptFile(data)
+	if err != nil {
+		return nil, err
+	}
+	pubkey, err := nostr.PublicKey(nostrSecret)
+	if err != nil {
+		return nil, err
+	}
+	sealed, ok := header.Keys[hex.EncodeToString(pubkey)]
+	if !ok {
+		return nil, fmt.Errorf("not encrypted to %s", canonicalNostrPubKey(hex.EncodeToString(pubkey)))
+	}
+	conversationKey, err := nostr.NIP44ConversationKey(nostrSecret, header.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := nostr.NIP44Decrypt(conversationKey, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return openCryptFile(fileKey, body)
+}
+
+// cryptGrantPath returns where a recipient's (hex) grant is kept
+func cryptGrantPath(recipient string) string {
+	return filepath.Join(cryptDir, "keys", recipient+".json")
+}
+
+// cryptRecipients lists the recipients (hex) with a grant
+func cryptRecipients() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(cryptDir, "keys"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", cryptDir, err)
+	}
+	recipients := []string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		if _, err := NormalizeNostrPubKey(name); err == nil && name != entry.Name() {
+			recipients = append(recipients, name)
+		}
+	}
+	sort.Strings(recipients)
+	return recipients, nil
+}
+
+// writeCryptGrant seals the repository secrets to a recipient (hex)
+func writeCryptGrant(recipient string, secrets [][]byte) error {
+	ephemeral, err := generateSecretKey()
+	if err != nil {
+		return err
+	}
+	pubkey, err := nostr.PublicKey(ephemeral)
+	if err != nil {
+		return err
+	}
+	conversationKey, err := nostr.NIP44ConversationKey(ephemeral, recipient)
+	if err != nil {
+		return err
+	}
+	grant := CryptGrant{Ephemeral: hex.EncodeToString(pubkey)}
+	for _, secret := range secrets {
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		payload, err := nostr.NIP44Encrypt(conversationKey, nonce, secret)
+		if err != nil {
+			return err
+		}
+		grant.Secrets = append(grant.Secrets, payload)
+	}
+
+	data, err := json.MarshalIndent(grant, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cryptGrantPath(recipient)), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %w", cryptDir, err)
+	}
+	if err := fsutil.WriteFile(cryptGrantPath(recipient), append(data, '\n')); err != nil {
+		return err
+	}
+	return os.Chmod(cryptGrantPath(recipient), 0644)
+}
+
+// openCryptGrant opens the repository secrets sealed to a secret key
+func openCryptGrant(nostrSecret []byte) ([][]byte, error) {
+	pubkey, err := nostr.PublicKey(nostrSecret)
+	if err != nil {
+		return nil, err
+	}
+	recipient := hex.EncodeToString(pubkey)
+	data, err := os.ReadFile(cryptGrantPath(recipient))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s is not a recipient of this repository", canonicalNostrPubKey(recipient))
+	}
+	if err != nil {
+		return nil, err
+	}
+	grant := &CryptGrant{}
+	if err := json.Unmarshal(data, grant); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", cryptGrantPath(recipient), err)
+	}
+	conversationKey, err := nostr.NIP44ConversationKey(nostrSecret, grant.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	secrets := [][]byte{}
+	for _, payload := range grant.Secrets {
+		secret, err := nostr.NIP44Decrypt(conversationKey, payload)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %w", cryptGrantPath(recipient), err)
+		}
+		secrets = append(secrets, secret)
+	}
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("%s holds no secrets", cryptGrantPath(recipient))
+	}
+	return secrets, nil
+}
+
+// cryptSecretsPath returns where an unlocked clone keeps its secrets
+func cryptSecretsPath() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--git-path", "mgit-crypt/secrets").Output()
+	if err != nil {
+		return "", fmt.Errorf("not in a git repository")
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// loadCryptSecrets returns the unlocked repository secrets, oldest first,
+// or none when the clone is locked
+func loadCryptSecrets() ([][]byte, error) {
+	path, err := cryptSecretsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secrets := [][]byte{}
+	for _, line := range strings.Fields(string(data)) {
+		secret, err := hex.DecodeString(line)
+		if err != nil || len(secret) != 32 {
+			return nil, fmt.Errorf("invalid secret in %s", path)
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// saveCryptSecrets keeps the repository secrets in the clone
+func saveCryptSecrets(secrets [][]byte) error {
+	path, err := cryptSecretsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	var data strings.Builder
+	for _, secret := range secrets {
+		data.WriteString(hex.EncodeToString(secret) + "\n")
+	}
+	return fsutil.WritePrivateFile(path, []byte(data.String()))
+}
+
+// requireCryptSecrets returns the unlocked repository secrets
+func requireCryptSecrets() ([][]byte, error) {
+	secrets, err := loadCryptSecrets()
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("this clone is locked (run mgit crypt unlock)")
+	}
+	return secrets, nil
+}
+
+// configureCryptFilter points git's mgit-crypt filter at this binary
+func configureCryptFilter() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error locating mgit: %w", err)
+	}
+	quoted := "'" + strings.ReplaceAll(exe, "'", `'\''`) + "'"
+	for key, value := range map[string]string{
+		"clean":    quoted + " crypt clean %f",
+		"smudge":   quoted + " crypt smudge %f",
+		"required": "true",
+	} {
+		if err := runGitIn(".", "config", "filter."+cryptFilter+"."+key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cryptFiles lists the tracked files marked for encryption
+func cryptFiles() ([]string, error) {
+	listed, err := exec.Command("git", "ls-files", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files: %w", err)
+	}
+	cmd := exec.Command("git", "check-attr", "-z", "--stdin", "filter")
+	cmd.Stdin = bytes.NewReader(listed)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git check-attr: %w", err)
+	}
+	// Records are path, attribute and value, each NUL-terminated
+	files := []string{}
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == cryptFilter {
+			files = append(files, fields[i])
+		}
+	}
+	return files, nil
+}
+
+// recheckoutCryptFiles rewrites the encrypted files in the working tree
+// from the index, through the filter if it is configured
+func recheckoutCryptFiles(files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return runGitIn(".", append([]string{"checkout", "-q", "--"}, files...)...)
+}
+
+// HandleCrypt handles the crypt command
+func HandleCrypt(args []string) {
+	if len(args) == 0 {
+		fmt.Println(cryptUsage)
+		os.Exit(1)
+	}
+
+	// The filters talk to git over stdin and stdout
+	if (args[0] == "clean" || args[0] == "smudge") && len(args) <= 2 {
+		path := ""
+		if len(args) == 2 {
+			path = args[1]
+		}
+		if err := runCryptFilter(args[0], path, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "mgit crypt %s: %s\n", args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	getRepo()
+	var err error
+	switch {
+	case args[0] == "init":
+		err = cryptInit(args[1:])
+	case args[0] == "add" && len(args) >= 2:
+		err = cryptAdd(args[1:])
+	case args[0] == "grant" && len(args) == 2:
+		err = cryptGrant(args[1])
+	case args[0] == "revoke" && len(args) == 2:
+		err = cryptRevoke(args[1])
+	case args[0] == "unlock" && len(args) == 1:
+		err = cryptUnlock()
+	case args[0] == "lock" && len(args) == 1:
+		err = cryptLock()
+	case args[0] == "status" && len(args) == 1:
+		err = cryptStatus()
+	case args[0] == "cat" && len(args) == 2:
+		err = cryptCat(args[1])
+	default:
+		fmt.Println(cryptUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runCryptFilter encrypts (clean) or decrypts (smudge) the file at path for
+// git
+func runCryptFilter(mode, path string, in io.Reader, out io.Writer) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	if mode == "clean" && !isCryptFile(data) {
+		secrets, err := requireCryptSecrets()
+		if err != nil {
+			return err
+		}
+		secret := secrets[len(secrets)-1]
+		if staged := stagedCryptFile(path, secret, data); staged != nil {
+			data = staged
+		} else {
+			recipients, err := cryptRecipients()
+			if err != nil {
+				return err
+			}
+			if data, err = encryptCryptFile(secret, recipients, data); err != nil {
+				return err
+			}
+		}
+	} else if mode == "smudge" && isCryptFile(data) {
+		secrets, err := loadCryptSecrets()
+		if err != nil {
+			return err
+		}
+		// A file this clone can't open is checked out still encrypted
+		if plaintext, err := decryptCryptFile(secrets, data); err == nil {
+			data = plaintext
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: left a file encrypted: %s\n", err)
+		}
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+// stagedCryptFile returns the staged version of path when it holds
+// plaintext under secret, so that a change of recipients alone doesn't
+// show every encrypted file as modified
+func stagedCryptFile(path string, secret, plaintext []byte) []byte {
+	if path == "" {
+		return nil
+	}
+	staged, err := exec.Command("git", "cat-file", "blob", ":"+path).Output()
+	if err != nil {
+		return nil
+	}
+	header, _, err := parseCryptFile(staged)
+	if err != nil || header.Secret != cryptSecretID(secret) {
+		return nil
+	}
+	if existing, err := decryptCryptFile([][]byte{secret}, staged); err != nil || !bytes.Equal(existing, plaintext) {
+		return nil
+	}
+	return staged
+}
+
+// cryptRecipientArg converts an npub or hex argument to hex
+func cryptRecipientArg(arg string) (string, error) {
+	npub, err := NormalizeNostrPubKey(arg)
+	if err != nil {
+		return "", fmt.Errorf("invalid recipient %s: %w", arg, err)
+	}
+	return nostr.PubkeyHex(npub)
+}
+
+// cryptInit sets the repository up for encryption to the signing key and
+// any other recipients given
+func cryptInit(args []string) error {
+	if cryptInUse() {
+		return fmt.Errorf("%s already exists (see mgit crypt status)", cryptDir)
+	}
+	nostrSecret, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+	pubkey, err := nostr.PublicKey(nostrSecret)
+	if err != nil {
+		return err
+	}
+	recipients := []string{hex.EncodeToString(pubkey)}
+	for _, arg := range args {
+		recipient, err := cryptRecipientArg(arg)
+		if err != nil {
+			return err
+		}
+		recipients = appendUnique(recipients, recipient)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := writeCryptGrant(recipient, [][]byte{secret}); err != nil {
+			return err
+		}
+	}
+	if err := saveCryptSecrets([][]byte{secret}); err != nil {
+		return err
+	}
+	if err := configureCryptFilter(); err != nil {
+		return err
+	}
+	fmt.Printf("Set up encryption for %d recipient(s) in %s\n", len(recipients), cryptDir)
+	fmt.Println("Mark paths to encrypt with mgit crypt add <pattern>, then add and commit")
+	fmt.Printf("%s and .gitattributes\n", cryptDir)
+	return nil
+}
+
+// cryptAdd marks paths for encryption in .gitattributes and restages the
+// tracked files they cover
+func cryptAdd(patterns []string) error {
+	if !cryptInUse() {
+		return fmt.Errorf("encryption is not set up (run mgit crypt init)")
+	}
+	if _, err := requireCryptSecrets(); err != nil {
+		return err
+	}
+	before, err := cryptFiles()
+	if err != nil {
+		return err
+	}
+
+	existing, err := os.ReadFile(".gitattributes")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.Split(string(existing), "\n")
+	var added strings.Builder
+	for _, pattern := range patterns {
+		if pattern == ".gitattributes" || strings.HasPrefix(pattern, cryptDir) || strings.ContainsAny(pattern, " \t") {
+			return fmt.Errorf("can't encrypt %s", pattern)
+		}
+		line := pattern + " filter=" + cryptFilter + " -diff"
+		if containsString(lines, line) {
+			continue
+		}
+		added.WriteString(line + "\n")
+		fmt.Printf("Encrypting %s\n", pattern)
+	}
+	if added.Len() == 0 {
+		return nil
+	}
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		existing = append(existing, '\n')
+	}
+	if err := os.WriteFile(".gitattributes", append(existing, added.String()...), 0644); err != nil {
+		return err
+	}
+
+	// Files already tracked are still stored in plain text until restaged
+	after, err := cryptFiles()
+	if err != nil {
+		return err
+	}
+	restage := []string{}
+	for _, file := range after {
+		if !containsString(before, file) {
+			restage = append(restage, file)
+		}
+	}
+	if len(restage) > 0 {
+		if err := runGitIn(".", append([]string{"add", "--renormalize", "--"}, restage...)...); err != nil {
+			return err
+		}
+		fmt.Printf("Staged %d tracked file(s) encrypted; earlier commits still hold them in plain text\n", len(restage))
+	}
+	return nil
+}
+
+// cryptGrant lets another key read the repository
+func cryptGrant(arg string) error {
+	recipient, err := cryptRecipientArg(arg)
+	if err != nil {
+		return err
+	}
+	secrets, err := requireCryptSecrets()
+	if err != nil {
+		return err
+	}
+	if err := writeCryptGrant(recipient, secrets); err != nil {
+		return err
+	}
+	fmt.Printf("Granted %s access; commit %s to share it\n", canonicalNostrPubKey(recipient), cryptGrantPath(recipient))
+	fmt.Println("Files are also encrypted to it directly as they change")
+	return nil
+}
+
+// cryptRevoke removes a recipient and moves the others to a new repository
+// secret, so files encrypted from now on are closed to the revoked key
+func cryptRevoke(arg string) error {
+	recipient, err := cryptRecipientArg(arg)
+	if err != nil {
+		return err
+	}
+	recipients, err := cryptRecipients()
+	if err != nil {
+		return err
+	}
+	if !containsString(recipients, recipient) {
+		return fmt.Errorf("%s is not a recipient", canonicalNostrPubKey(recipient))
+	}
+	if len(recipients) == 1 {
+		return fmt.Errorf("can't revoke the only recipient")
+	}
+	secrets, err := requireCryptSecrets()
+	if err != nil {
+		return err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	secrets = append(secrets, secret)
+	for _, other := range recipients {
+		if other == recipient {
+			continue
+		}
+		if err := writeCryptGrant(other, secrets); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(cryptGrantPath(recipient)); err != nil {
+		return err
+	}
+	if err := saveCryptSecrets(secrets); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked %s and moved the other recipients to a new secret\n", canonicalNostrPubKey(recipient))
+	fmt.Println("The revoked key can still read what it could before; files are re-encrypted as they change")
+	fmt.Printf("Commit %s to share the change\n", cryptDir)
+	return nil
+}
+
+// cryptUnlock opens the repository secrets with the signing key and checks
+// the encrypted files out decrypted
+func cryptUnlock() error {
+	if !cryptInUse() {
+		return fmt.Errorf("this repository has no encrypted files")
+	}
+	nostrSecret, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+	secrets, err := openCryptGrant(nostrSecret)
+	if err != nil {
+		return err
+	}
+	if err := saveCryptSecrets(secrets); err != nil {
+		return err
+	}
+	if err := configureCryptFilter(); err != nil {
+		return err
+	}
+	files, err := cryptFiles()
+	if err != nil {
+		return err
+	}
+	if err := recheckoutCryptFiles(files); err != nil {
+		return err
+	}
+	fmt.Printf("Unlocked; %d encrypted file(s) checked out\n", len(files))
+	return nil
+}
+
+// cryptLock forgets the repository secrets and checks the encrypted files
+// out as stored
+func cryptLock() error {
+	files, err := cryptFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		out, err := exec.Command("git", append([]string{"status", "--porcelain", "--"}, files...)...).Output()
+		if err != nil {
+			return fmt.Errorf("git status: %w", err)
+		}
+		if len(bytes.TrimSpace(out)) > 0 {
+			return fmt.Errorf("encrypted files have uncommitted changes; commit or restore them first")
+		}
+	}
+	path, err := cryptSecretsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	runGitIn(".", "config", "--remove-section", "filter."+cryptFilter)
+	if err := recheckoutCryptFiles(files); err != nil {
+		return err
+	}
+	fmt.Printf("Locked; %d encrypted file(s) checked out as stored\n", len(files))
+	return nil
+}
+
+// cryptStatus prints the recipients, whether the clone is unlocked, and the
+// encrypted files, flagging any staged in plain text
+func cryptStatus() error {
+	if !cryptInUse() {
+		fmt.Println("Encryption is not set up (run mgit crypt init)")
+		return nil
+	}
+	recipients, err := cryptRecipients()
+	if err != nil {
+		return err
+	}
+	secrets, err := loadCryptSecrets()
+	if err != nil {
+		return err
+	}
+	if len(secrets) > 0 {
+		fmt.Printf("Unlocked (%d repository secret(s))\n", len(secrets))
+	} else {
+		fmt.Println("Locked (run mgit crypt unlock)")
+	}
+	fmt.Println("Recipients:")
+	for _, recipient := range recipients {
+		fmt.Printf("  %s\n", canonicalNostrPubKey(recipient))
+	}
+
+	files, err := cryptFiles()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Encrypted files: %d\n", len(files))
+	plain := 0
+	for _, file := range files {
+		blob, err := exec.Command("git", "cat-file", "blob", ":"+file).Output()
+		if err == nil && len(blob) > 0 && !isCryptFile(blob) {
+			fmt.Printf("  %s is staged in plain text (run mgit add %s)\n", file, file)
+			plain++
+		}
+	}
+	if plain > 0 {
+		return fmt.Errorf("%d file(s) marked for encryption are stored in plain text", plain)
+	}
+	return nil
+}
+
+// cryptCat prints a file decrypted, with the repository secrets if the
+// clone is unlocked and otherwise with the signing key. "-" reads stdin.
+func cryptCat(file string) error {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+	if !isCryptFile(data) {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	secrets, err := loadCryptSecrets()
+	if err != nil {
+		return err
+	}
+	plaintext, err := decryptCryptFile(secrets, data)
+	if err != nil {
+		nostrSecret, keyErr := loadNostrSecretKey()
+		if keyErr != nil {
+			return err
+		}
+		if plaintext, err = decryptCryptFileWithKey(nostrSecret, data); err != nil {
+			return err
+		}
+	}
+	_, err = os.Stdout.Write(plaintext)
+	return err
+}
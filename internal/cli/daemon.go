@@ -0,0 +1,587 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// mgit daemon serves repository operations to IDEs and mobile apps over a
+// local Unix socket, so they get structured results instead of parsing the
+// commands' text. The protocol is JSON-RPC 2.0, one JSON object per line in
+// each direction; requests on a connection may overlap. Methods are
+// versioned by namespace: v1 keeps its shape, and a change that breaks it
+// would come as v2 beside it.
+//
+//	version                                    -> {"api", "methods"}
+//	v1.status  {"repo"}                        -> {"branch", "head", "partial", "files", "clean"}
+//	v1.log     {"repo", "limit"}               -> {"commits": [MGit commit]} newest first (limit default 10)
+//	v1.commit  {"repo", "message", "paths"}    -> {"mgit_hash", "git_hash"} (paths are added first)
+//	v1.clone   {"url", "path", "depth", "branch"} -> {"path", "head"}
+//	v1.verify  {"repo", "full"}                -> {"verified"}
+//
+// commit, clone and verify run the mgit binary in the repository, as the
+// command line would, and stream each line it prints as a notification
+// before the response:
+//
+//	{"jsonrpc": "2.0", "method": "v1.progress", "params": {"id": <request id>, "message": "..."}}
+//
+// A failed operation answers error code -32000 with the command's output in
+// data. The socket is ~/.mgitconfig/daemon.sock unless daemon.socket or
+// --socket names another, and only its owner can connect.
+
+const (
+	daemonUsage      = "Usage: mgit daemon [--socket <path>]"
+	daemonAPIVersion = "v1"
+
+	// JSON-RPC error codes
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcOperationError = -32000
+)
+
+// rpcRequest is a JSON-RPC request, or a notification when it has no ID
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse answers one request
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcNotification is a message the daemon sends without being asked
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// rpcError is a failed request
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// DaemonFileStatus is one changed path in a status
+type DaemonFileStatus struct {
+	Path     string `json:"path"`
+	Staging  string `json:"staging"`  // git's status letter for the index, "" if unchanged
+	Worktree string `json:"worktree"` // git's status letter for the working tree, "" if unchanged
+}
+
+// DaemonHead names the commit HEAD is on
+type DaemonHead struct {
+	MGitHash string `json:"mgit_hash,omitempty"`
+	GitHash  string `json:"git_hash,omitempty"`
+}
+
+// DaemonStatus is the result of v1.status
+type DaemonStatus struct {
+	Branch  string             `json:"branch"`
+	Head    *DaemonHead        `json:"head,omitempty"`
+	Partial []string           `json:"partial,omitempty"`
+	Files   []DaemonFileStatus `json:"files"`
+	Clean   bool               `json:"clean"`
+}
+
+// daemonMethodNames are the methods the daemon answers
+var daemonMethodNames = []string{"version", "v1.status", "v1.log", "v1.commit", "v1.clone", "v1.verify"}
+
+// daemonHandler answers one method; an *rpcError return sets the error code
+type daemonHandler func(c *daemonConn, id, params json.RawMessage) (interface{}, error)
+
+// mgitDaemon answers requests on the socket
+type mgitDaemon struct {
+	exe string
+}
+
+// daemonConn is one client connection; responses and notifications from
+// overlapping requests are written a line at a time
+type daemonConn struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	pending sync.WaitGroup // Requests still being answered
+}
+
+// HandleDaemon handles the daemon command
+func HandleDaemon(args []string) {
+	socket := GetConfigPath("daemon.socket", "")
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--socket" && i+1 < len(args) {
+			socket = args[i+1]
+			i++
+			continue
+		}
+		fmt.Println(daemonUsage)
+		os.Exit(1)
+	}
+	if socket == "" {
+		dir := mgitUserDir()
+		if dir == "" {
+			fmt.Println("Error: no home directory for the daemon socket (set daemon.socket)")
+			os.Exit(1)
+		}
+		socket = filepath.Join(dir, "daemon.sock")
+	}
+	socket = expandHome(socket)
+
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Error: cannot locate the mgit binary: %s\n", err)
+		os.Exit(1)
+	}
+	listener, err := listenDaemonSocket(socket)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Leave no socket behind on Ctrl-C
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+		os.Remove(socket)
+		os.Exit(0)
+	}()
+
+	fmt.Printf("mgit daemon (API %s) listening on %s\n", daemonAPIVersion, socket)
+	daemon := &mgitDaemon{exe: exe}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("Error accepting connection: %s\n", err)
+			os.Exit(1)
+		}
+		go daemon.serve(&daemonConn{conn: conn})
+	}
+}
+
+// listenDaemonSocket listens on socket, replacing a socket no daemon
+// answers on any more
+func listenDaemonSocket(socket string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", filepath.Dir(socket), err)
+	}
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", socket)
+		}
+		os.Remove(socket)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error restricting %s: %w", socket, err)
+	}
+	return listener, nil
+}
+
+// serve reads requests from a connection until the client stops sending,
+// and closes it once they are answered
+func (d *mgitDaemon) serve(c *daemonConn) {
+	defer c.conn.Close()
+	defer c.pending.Wait()
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			d.handle(c, line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle answers one request, in the background so a long clone does not
+// hold up the requests behind it
+func (d *mgitDaemon) handle(c *daemonConn, line []byte) {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		c.send(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{Code: rpcParseError, Message: "parse error: " + err.Error()}})
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		c.send(rpcResponse{JSONRPC: "2.0", ID: requestID(req.ID),
+			Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}})
+		return
+	}
+
+	c.pending.Add(1)
+	go func() {
+		defer c.pending.Done()
+		var result interface{}
+		var err error
+		if method := d.method(req.Method); method != nil {
+			result, err = method(c, req.ID, req.Params)
+		} else {
+			err = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method '%s'", req.Method)}
+		}
+		if req.ID == nil {
+			// Notifications get no answer
+			return
+		}
+		resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+		var failure *rpcError
+		switch {
+		case errors.As(err, &failure):
+			resp.Error = failure
+		case err != nil:
+			resp.Error = &rpcError{Code: rpcOperationError, Message: err.Error()}
+		default:
+			resp.Result = result
+		}
+		c.send(resp)
+	}()
+}
+
+// method returns the handler for a method name, or nil
+func (d *mgitDaemon) method(name string) daemonHandler {
+	switch name {
+	case "version":
+		return d.rpcVersion
+	case "v1.status":
+		return d.rpcStatus
+	case "v1.log":
+		return d.rpcLog
+	case "v1.commit":
+		return d.rpcCommit
+	case "v1.clone":
+		return d.rpcClone
+	case "v1.verify":
+		return d.rpcVerify
+	}
+	return nil
+}
+
+// requestID is the ID to answer an invalid request with
+func requestID(id json.RawMessage) json.RawMessage {
+	if id == nil {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+// send writes one message as a line
+func (c *daemonConn) send(message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write(append(data, '\n'))
+}
+
+// progress sends a line of an operation's output to the client
+func (c *daemonConn) progress(id json.RawMessage, message string) {
+	if id == nil {
+		return
+	}
+	c.send(rpcNotification{
+		JSONRPC: "2.0",
+		Method:  daemonAPIVersion + ".progress",
+		Params:  map[string]interface{}{"id": id, "message": message},
+	})
+}
+
+// decodeParams reads a request's params into v
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+// repoDir resolves a request's repository to an absolute path of a git
+// repository
+func repoDir(repo string) (string, error) {
+	if repo == "" {
+		return "", &rpcError{Code: rpcInvalidParams, Message: "invalid params: repo is required"}
+	}
+	dir, err := filepath.Abs(expandHome(repo))
+	if err != nil {
+		return "", &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	if _, err := git.PlainOpen(dir); err != nil {
+		return "", &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("%s is not a repository: %s", dir, err)}
+	}
+	return dir, nil
+}
+
+func (d *mgitDaemon) rpcVersion(c *daemonConn, id, params json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{"api": daemonAPIVersion, "methods": daemonMethodNames}, nil
+}
+
+func (d *mgitDaemon) rpcStatus(c *daemonConn, id, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Repo string `json:"repo"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	dir, err := repoDir(p.Repo)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &DaemonStatus{Files: []DaemonFileStatus{}}
+	if head, err := repo.Head(); err == nil {
+		status.Branch = getCurrentBranch(repo)
+		status.Head = &DaemonHead{GitHash: head.Hash().String()}
+		// Commits run as subprocesses and write the mappings behind the
+		// daemon's cached copy
+		store := (&MGitStorage{RootDir: filepath.Join(dir, ".mgit")}).Mappings()
+		store.Invalidate()
+		if mapping, ok, err := store.ByGit(head.Hash().String()); err == nil && ok {
+			status.Head.MGitHash = mapping.MGitHash
+		}
+	}
+	if status.Partial, err = partialScope(dir); err != nil {
+		return nil, fmt.Errorf("error reading partial scope: %w", err)
+	}
+	// git's own status honours sparse checkouts and the crypt filters
+	files, err := scopedStatus(dir, status.Partial)
+	if err != nil {
+		return nil, err
+	}
+	for path, file := range files {
+		status.Files = append(status.Files, DaemonFileStatus{
+			Path:     path,
+			Staging:  strings.TrimSpace(string(file.Staging)),
+			Worktree: strings.TrimSpace(string(file.Worktree)),
+		})
+	}
+	sort.Slice(status.Files, func(i, j int) bool { return status.Files[i].Path < status.Files[j].Path })
+	status.Clean = len(status.Files) == 0
+	return status, nil
+}
+
+func (d *mgitDaemon) rpcLog(c *daemonConn, id, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Repo  string `json:"repo"`
+		Limit int    `json:"limit"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Limit <= 0 {
+		p.Limit = 10
+	}
+	dir, err := repoDir(p.Repo)
+	if err != nil {
+		return nil, err
+	}
+	storage := &MGitStorage{RootDir: filepath.Join(dir, ".mgit")}
+	head, err := storage.GetHeadCommit()
+	if err != nil {
+		return nil, fmt.Errorf("error getting HEAD commit: %w", err)
+	}
+
+	// Newest first across merges, as mgit log lists them
+	commits := []*mgitlib.Commit{}
+	seen := map[string]bool{head.MGitHash: true}
+	pending := []*mgitlib.Commit{head}
+	for len(pending) > 0 && len(commits) < p.Limit {
+		sort.Slice(pending, func(i, j int) bool {
+			return pending[i].Committer.When.After(pending[j].Committer.When)
+		})
+		commit := pending[0]
+		pending = pending[1:]
+		commits = append(commits, commit)
+		for _, parent := range commit.ParentHashes {
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			if parentCommit, err := storage.GetCommit(parent); err == nil {
+				pending = append(pending, parentCommit)
+			}
+		}
+	}
+	return map[string]interface{}{"commits": commits}, nil
+}
+
+func (d *mgitDaemon) rpcCommit(c *daemonConn, id, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Repo    string   `json:"repo"`
+		Message string   `json:"message"`
+		Paths   []string `json:"paths"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Message == "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: message is required"}
+	}
+	dir, err := repoDir(p.Repo)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Paths) > 0 {
+		if err := d.run(c, id, dir, append([]string{"add"}, p.Paths...)...); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.run(c, id, dir, "commit", "-m", p.Message); err != nil {
+		return nil, err
+	}
+	head, err := (&MGitStorage{RootDir: filepath.Join(dir, ".mgit")}).GetHeadCommit()
+	if err != nil {
+		return nil, fmt.Errorf("error getting HEAD commit: %w", err)
+	}
+	return &DaemonHead{MGitHash: head.MGitHash, GitHash: head.GitHash}, nil
+}
+
+func (d *mgitDaemon) rpcClone(c *daemonConn, id, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		URL    string `json:"url"`
+		Path   string `json:"path"`
+		Depth  int    `json:"depth"`
+		Branch string `json:"branch"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.URL == "" || p.Path == "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: url and path are required"}
+	}
+	path, err := filepath.Abs(expandHome(p.Path))
+	if err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+
+	args := []string{"clone"}
+	if p.Depth > 0 {
+		args = append(args, "--depth", fmt.Sprint(p.Depth))
+	}
+	if p.Branch != "" {
+		args = append(args, "--branch", p.Branch)
+	}
+	if err := d.run(c, id, filepath.Dir(path), append(args, p.URL, path)...); err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{"path": path}
+	if head, err := (&MGitStorage{RootDir: filepath.Join(path, ".mgit")}).GetHeadCommit(); err == nil {
+		result["head"] = &DaemonHead{MGitHash: head.MGitHash, GitHash: head.GitHash}
+	}
+	return result, nil
+}
+
+func (d *mgitDaemon) rpcVerify(c *daemonConn, id, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Repo string `json:"repo"`
+		Full bool   `json:"full"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	dir, err := repoDir(p.Repo)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"verify"}
+	if p.Full {
+		args = append(args, "--full")
+	}
+	if err := d.run(c, id, dir, args...); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"verified": true}, nil
+}
+
+// run runs an mgit command in dir, sending each line it prints to the
+// client as progress. A failure carries the whole output.
+func (d *mgitDaemon) run(c *daemonConn, id json.RawMessage, dir string, args ...string) error {
+	cmd := exec.Command(d.exe, args...)
+	cmd.Dir = dir
+	reader, writer := io.Pipe()
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	output := &bytes.Buffer{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(reader)
+		// git redraws progress with carriage returns
+		scanner.Split(scanProgressLines)
+		for scanner.Scan() {
+			line := scanner.Text()
+			output.WriteString(line + "\n")
+			if strings.TrimSpace(line) != "" {
+				c.progress(id, line)
+			}
+		}
+		io.Copy(io.Discard, reader)
+	}()
+	err := cmd.Wait()
+	writer.Close()
+	<-done
+	if err != nil {
+		return &rpcError{
+			Code:    rpcOperationError,
+			Message: fmt.Sprintf("mgit %s failed: %s", args[0], err),
+			Data:    map[string]string{"output": output.String()},
+		}
+	}
+	return nil
+}
+
+// scanProgressLines splits output at newlines and carriage returns
+func scanProgressLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// testCommit commits a file to a repository as plain git
+func testCommit(t *testing.T, repo *git.Repository, dir, content string) *object.Commit {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "Tester", Email: "tester@example.com", When: time.Now()}
+	hash, err := worktree.Commit(content+"\n", &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
+
+func TestDaemonStatusAfterCommit(t *testing.T) {
+	isolateConfig(t)
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &MGitStorage{RootDir: filepath.Join(dir, ".mgit")}
+	if err := storage.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	first := testCommit(t, repo, dir, "first")
+	if err := storage.StoreMapping(first.Hash.String(), mgitlib.CommitFromGit(first, nil, "npub1a").ComputeHash(), "npub1a", mappingSourceLocal); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &mgitDaemon{}
+	params, _ := json.Marshal(map[string]string{"repo": dir})
+	status := func() *DaemonStatus {
+		t.Helper()
+		result, err := d.rpcStatus(nil, nil, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.(*DaemonStatus)
+	}
+	if got := status(); got.Head == nil || got.Head.MGitHash == "" {
+		t.Fatalf("status before the commit = %+v, want HEAD's MGit hash", got.Head)
+	}
+
+	// v1.commit runs mgit commit in another process, which writes the
+	// mappings file behind this one's cached copy
+	second := testCommit(t, repo, dir, "second")
+	mgitHash := mgitlib.CommitFromGit(second, nil, "npub1a").ComputeHash()
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mappings = append(mappings, NostrCommitMapping{GitHash: second.Hash.String(), MGitHash: mgitHash, Pubkey: "npub1a"})
+	data, err := json.Marshal(mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(storage.mappingsPath(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := status(); got.Head == nil || got.Head.GitHash != second.Hash.String() || got.Head.MGitHash != mgitHash {
+		t.Errorf("status after the commit = %+v, want %s", got.Head, mgitHash)
+	}
+}
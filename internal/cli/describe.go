@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const describeUsage = "Usage: mgit describe [--tags] [--long] [--always] [<commit>]"
+
+// describeCandidates is how many tagged commits describe weighs before
+// picking the closest, as git describe does
+const describeCandidates = 10
+
+// describeTag is a tag describe can name a commit after
+type describeTag struct {
+	Name      string
+	Annotated bool
+	When      time.Time // Tagger date; zero for lightweight tags
+}
+
+// DescribeOptions controls how describe names a commit
+type DescribeOptions struct {
+	Tags   bool // Weigh lightweight tags the same as annotated ones
+	Long   bool // Always print the count and hash, even on a tag
+	Always bool // Fall back to the short MGit hash when no tag is found
+}
+
+// HandleDescribe handles the describe command
+func HandleDescribe(args []string) {
+	opts := &DescribeOptions{}
+	rev := "HEAD"
+	revSet := false
+	for _, arg := range args {
+		switch arg {
+		case "--tags":
+			opts.Tags = true
+		case "--long":
+			opts.Long = true
+		case "--always":
+			opts.Always = true
+		default:
+			if revSet || len(arg) > 0 && arg[0] == '-' {
+				fmt.Println(describeUsage)
+				os.Exit(1)
+			}
+			rev = arg
+			revSet = true
+		}
+	}
+
+	repo := getRepo()
+	revision, err := Resolve(repo, NewMGitStorage(), rev)
+	if err != nil {
+		fmt.Printf("Error: %s: %s\n", rev, err)
+		os.Exit(1)
+	}
+	name, err := describeCommit(repo, revision.Git, opts)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(name)
+}
+
+// describeCommit names a commit after the nearest tag it descends from, as
+// <tag>-<count>-g<short mgit hash>, where count is how many commits it has
+// that the tag doesn't. Annotated tags are preferred over closer
+// lightweight ones unless opts.Tags is set.
+func describeCommit(repo *git.Repository, hash plumbing.Hash, opts *DescribeOptions) (string, error) {
+	mgitHash := GetMGitHashForCommit(hash)
+	if mgitHash == "" {
+		return "", fmt.Errorf("commit %s has no MGit hash (run mgit migrate)", shortHash(hash.String()))
+	}
+
+	tags, err := describeTags(repo)
+	if err != nil {
+		return "", err
+	}
+
+	// The first tagged commits met walking back from the commit
+	candidates := []plumbing.Hash{}
+	walkCommits(repo, []plumbing.Hash{hash}, nil, func(commit *object.Commit) {
+		if len(tags[commit.Hash]) > 0 && len(candidates) < describeCandidates {
+			candidates = append(candidates, commit.Hash)
+		}
+	})
+
+	var best *describeTag
+	bestCount := 0
+	for _, candidate := range candidates {
+		tag := tags[candidate][0]
+		if best != nil && !opts.Tags && best.Annotated && !tag.Annotated {
+			continue
+		}
+		count := commitsSince(repo, hash, candidate)
+		if best == nil || describeBetter(tag, count, best, bestCount, opts.Tags) {
+			best, bestCount = tag, count
+		}
+	}
+
+	if best == nil {
+		if opts.Always {
+			return shortHash(mgitHash), nil
+		}
+		return "", fmt.Errorf("no tags can describe %s (use --always to fall back to the hash)", shortHash(mgitHash))
+	}
+	if bestCount == 0 && !opts.Long {
+		return best.Name, nil
+	}
+	return fmt.Sprintf("%s-%d-g%s", best.Name, bestCount, shortHash(mgitHash)), nil
+}
+
+// describeBetter reports whether tag, count commits back, describes a commit
+// better than best, bestCount back: fewer commits win, but an annotated tag
+// beats a lightweight one at any distance unless tags is set, and on a tie
+func describeBetter(tag *describeTag, count int, best *describeTag, bestCount int, tags bool) bool {
+	if tag.Annotated != best.Annotated && (!tags || count == bestCount) {
+		return tag.Annotated
+	}
+	return count < bestCount
+}
+
+// describeTags returns the tags on each commit, best first: annotated before
+// lightweight, then the most recently tagged, then by name
+func describeTags(repo *git.Repository) (map[plumbing.Hash][]*describeTag, error) {
+	refs, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("error listing tags: %w", err)
+	}
+
+	tags := map[plumbing.Hash][]*describeTag{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		tag := &describeTag{Name: ref.Name().Short()}
+		target := ref.Hash()
+		if tagObj, err := repo.TagObject(target); err == nil {
+			tag.Annotated = true
+			tag.When = tagObj.Tagger.When
+			target = tagObj.Target
+		}
+		tags[target] = append(tags[target], tag)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error processing tags: %w", err)
+	}
+
+	for _, list := range tags {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Annotated != list[j].Annotated {
+				return list[i].Annotated
+			}
+			if !list[i].When.Equal(list[j].When) {
+				return list[i].When.After(list[j].When)
+			}
+			return list[i].Name < list[j].Name
+		})
+	}
+	return tags, nil
+}
+
+// commitsSince counts the commits reachable from hash but not from base
+func commitsSince(repo *git.Repository, hash, base plumbing.Hash) int {
+	ancestors := map[plumbing.Hash]bool{}
+	walkCommits(repo, []plumbing.Hash{base}, nil, func(commit *object.Commit) {
+		ancestors[commit.Hash] = true
+	})
+	count := 0
+	walkCommits(repo, []plumbing.Hash{hash}, ancestors, func(*object.Commit) {
+		count++
+	})
+	return count
+}
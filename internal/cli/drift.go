@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// HeadDrift describes how .mgit/HEAD disagrees with the git HEAD
+type HeadDrift struct {
+	GitBranch    string // Branch git HEAD points to ("" when detached)
+	GitHash      string // Commit git HEAD resolves to
+	MGitRef      string // Ref .mgit/HEAD points to ("" when detached)
+	MGitHeadHash string // MGit hash .mgit/HEAD resolves to
+	MGitHeadGit  string // Git hash recorded in the MGit HEAD commit
+	ExpectedHash string // MGit hash mapped to GitHash, if any
+	Unmapped     bool   // GitHash has no MGit hash, as when committed without a pubkey
+	Reasons      []string
+}
+
+// Drifted reports whether any disagreement was found
+func (d *HeadDrift) Drifted() bool {
+	return len(d.Reasons) > 0
+}
+
+// detectHeadDrift compares .mgit/HEAD with the git HEAD of repo
+func detectHeadDrift(repo *git.Repository, storage *MGitStorage) (*HeadDrift, error) {
+	drift := &HeadDrift{}
+
+	head, err := repo.Head()
+	if err != nil {
+		// Nothing to compare against in an empty repository
+		return drift, nil
+	}
+	drift.GitHash = head.Hash().String()
+	if head.Name().IsBranch() {
+		drift.GitBranch = head.Name().Short()
+	}
+	drift.ExpectedHash, _ = storage.GetMGitHashFromGit(drift.GitHash)
+
+	mgitHead, err := storage.GetHead()
+	if err != nil {
+		// No MGit state at all - only a problem if git HEAD has an MGit hash
+		if drift.ExpectedHash != "" {
+			drift.Reasons = append(drift.Reasons, ".mgit/HEAD is missing")
+		}
+		return drift, nil
+	}
+	mgitHead = strings.TrimSpace(mgitHead)
+	if strings.HasPrefix(mgitHead, "refs/") {
+		drift.MGitRef = mgitHead
+	}
+
+	if drift.GitBranch != "" && drift.MGitRef != "" && drift.MGitRef != "refs/heads/"+drift.GitBranch {
+		drift.Reasons = append(drift.Reasons, fmt.Sprintf(
+			"git HEAD is on branch '%s' but .mgit/HEAD points to '%s'", drift.GitBranch, drift.MGitRef))
+	}
+
+	headCommit, err := storage.GetHeadCommit()
+	if err != nil {
+		if drift.ExpectedHash != "" {
+			drift.Reasons = append(drift.Reasons, fmt.Sprintf(
+				".mgit/HEAD does not resolve to a commit (git HEAD %s maps to MGit %s)",
+				shortHash(drift.GitHash), shortHash(drift.ExpectedHash)))
+		}
+		return drift, nil
+	}
+	drift.MGitHeadHash = headCommit.MGitHash
+	drift.MGitHeadGit = headCommit.GitHash
+
+	if headCommit.GitHash != drift.GitHash {
+		if drift.ExpectedHash == "" {
+			// A commit without an MGit hash, made with plain git or without
+			// a pubkey, leaves MGit HEAD on the last one that has one; there
+			// is nothing else to point it at until the commit is mapped
+			drift.Unmapped = true
+		} else {
+			drift.Reasons = append(drift.Reasons, fmt.Sprintf(
+				"MGit HEAD %s records git commit %s but git HEAD is %s",
+				shortHash(headCommit.MGitHash), shortHash(headCommit.GitHash), shortHash(drift.GitHash)))
+		}
+	}
+
+	return drift, nil
+}
+
+// requireNoHeadDrift stops the command when .mgit/HEAD and git HEAD disagree,
+// since anything reading MGit state would otherwise report the wrong hashes
+func requireNoHeadDrift(repo *git.Repository, storage *MGitStorage) {
+	drift, err := detectHeadDrift(repo, storage)
+	if err != nil {
+		fmt.Printf("Error checking MGit HEAD: %s\n", err)
+		os.Exit(1)
+	}
+	if !drift.Drifted() {
+		return
+	}
+
+	fmt.Println("Error: MGit state is out of sync with git:")
+	for _, reason := range drift.Reasons {
+		fmt.Printf("  - %s\n", reason)
+	}
+	fmt.Println()
+	fmt.Println("This usually happens when git commands (commit, checkout, reset) are run")
+	fmt.Println("directly instead of through mgit. Output based on MGit state would be wrong.")
+	fmt.Println()
+	fmt.Println("Run 'mgit check-drift' for details or 'mgit check-drift --fix' to")
+	fmt.Println("point .mgit/HEAD back at the git HEAD.")
+	os.Exit(1)
+}
+
+// HandleCheckDrift handles the check-drift command
+func HandleCheckDrift(args []string) {
+	fix := false
+	for _, arg := range args {
+		switch arg {
+		case "--fix":
+			fix = true
+		default:
+			fmt.Println("Usage: mgit check-drift [--fix]")
+			os.Exit(1)
+		}
+	}
+
+	repo := getRepo()
+	storage := NewMGitStorage()
+
+	drift, err := detectHeadDrift(repo, storage)
+	if err != nil {
+		fmt.Printf("Error checking MGit HEAD: %s\n", err)
+		os.Exit(1)
+	}
+
+	if !drift.Drifted() {
+		fmt.Println("MGit HEAD is in sync with git HEAD")
+		if drift.Unmapped {
+			fmt.Printf("git HEAD %s has no MGit hash yet; mgit migrate maps it once a pubkey is set\n", shortHash(drift.GitHash))
+		}
+		return
+	}
+
+	fmt.Println("MGit HEAD has drifted from git HEAD:")
+	for _, reason := range drift.Reasons {
+		fmt.Printf("  - %s\n", reason)
+	}
+
+	if !fix {
+		fmt.Println()
+		fmt.Println("Run 'mgit check-drift --fix' to repair")
+		os.Exit(1)
+	}
+
+	if drift.ExpectedHash == "" {
+		fmt.Printf("Error: no MGit hash is recorded for git HEAD %s; cannot repair automatically\n",
+			shortHash(drift.GitHash))
+		os.Exit(1)
+	}
+
+	if err := storage.Initialize(); err != nil {
+		fmt.Printf("Error initializing MGit storage: %s\n", err)
+		os.Exit(1)
+	}
+
+	if drift.GitBranch != "" {
+		refName := "refs/heads/" + drift.GitBranch
+		if err := storage.UpdateRef(refName, drift.ExpectedHash, "check-drift --fix"); err != nil {
+			fmt.Printf("Error updating %s: %s\n", refName, err)
+			os.Exit(1)
+		}
+		if err := storage.UpdateHead(refName, "check-drift --fix"); err != nil {
+			fmt.Printf("Error updating HEAD: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Fixed: .mgit/HEAD -> %s -> %s\n", refName, shortHash(drift.ExpectedHash))
+	} else {
+		if err := storage.SetDetachedHead(drift.ExpectedHash, "check-drift --fix"); err != nil {
+			fmt.Printf("Error updating HEAD: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Fixed: .mgit/HEAD -> %s (detached)\n", shortHash(drift.ExpectedHash))
+	}
+}
+
+// shortHash abbreviates a hash to 7 characters for display
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// syncMGitHead points .mgit/HEAD at whatever git HEAD now refers to, so
+// switching branches through mgit does not leave the two out of step.
+// reason is recorded in the reflog.
+func syncMGitHead(repo *git.Repository, reason string) {
+	storage := NewMGitStorage()
+	if _, err := os.Stat(storage.RootDir); os.IsNotExist(err) {
+		return
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return
+	}
+
+	if head.Name().IsBranch() {
+		err = storage.UpdateHead(head.Name().String(), reason)
+	} else if mgitHash, lookupErr := storage.GetMGitHashFromGit(head.Hash().String()); lookupErr == nil {
+		err = storage.SetDetachedHead(mgitHash, reason)
+	}
+	if err != nil {
+		fmt.Printf("Warning: Failed to update MGit HEAD: %s\n", err)
+	}
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+func TestDriftUnmappedHead(t *testing.T) {
+	isolateConfig(t)
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &MGitStorage{RootDir: filepath.Join(dir, ".mgit")}
+	if err := storage.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	first := testCommit(t, repo, dir, "first")
+	commit := mgitlib.CommitFromGit(first, nil, "npub1a")
+	commit.MGitHash = commit.ComputeHash()
+	if err := storage.RecordCommit(commit, "npub1a", "refs/heads/master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.UpdateHead("refs/heads/master", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A commit made without a pubkey on top of the MGit one
+	second := testCommit(t, repo, dir, "second")
+	drift, err := detectHeadDrift(repo, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift.Drifted() || !drift.Unmapped {
+		t.Errorf("unmapped HEAD: drifted %v (%v), unmapped %v; want unmapped, not drifted", drift.Drifted(), drift.Reasons, drift.Unmapped)
+	}
+
+	// Once it is mapped, an MGit HEAD left behind it has drifted
+	mgitHash := mgitlib.CommitFromGit(second, []string{commit.MGitHash}, "npub1a").ComputeHash()
+	if err := storage.StoreMapping(second.Hash.String(), mgitHash, "npub1a", mappingSourceLocal); err != nil {
+		t.Fatal(err)
+	}
+	drift, err = detectHeadDrift(repo, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !drift.Drifted() || drift.Unmapped {
+		t.Errorf("mapped HEAD behind MGit HEAD: drifted %v, unmapped %v; want drifted", drift.Drifted(), drift.Unmapped)
+	}
+}
@@ -0,0 +1,386 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// mgit export shares a slice of a record: the commits since a revision,
+// with only the files matching some paths, and what the recipient needs to
+// check them without the repository. The export is one JSON document:
+//
+//	commits  every commit in the range, oldest first: its MGit commit object
+//	         (so its MGit hash can be recomputed) and the blob hash of each
+//	         exported file at that commit
+//	objects  the exported blobs, and the git tree objects on the way from
+//	         each commit's root tree to them, by git hash
+//	base     the MGit hashes of the commits the range builds on
+//	tip      the MGit hash of the newest commit
+//
+// mgit export --verify recomputes every commit's MGit hash, checks that its
+// parents are exported commits or base commits, and follows each file from
+// the commit's tree hash through the tree objects to the blob, hashing each
+// object on the way. That proves the files are those the commits hold and
+// the commits form one history from base to tip; the recipient checks tip
+// or base against a copy they trust (a checkpoint, a relay announcement).
+//
+// What is disclosed beyond the files: the header and message of every commit
+// in the range, even those touching no exported file (the chain needs them),
+// and the names and hashes of the other entries in each directory on an
+// exported file's path, but not their content.
+
+const exportUsage = `Usage: mgit export [--since <rev>] --paths <glob>[,<glob>...] [-o <file>] [<rev>]
+       mgit export --verify <file>`
+
+// exportVersion is the version of the export layout written
+const exportVersion = 1
+
+// HistoryExport is a verifiable slice of a repository's history
+type HistoryExport struct {
+	Version int               `json:"version"`
+	Paths   []string          `json:"paths"`
+	Tip     string            `json:"tip"`
+	Base    []string          `json:"base"`
+	Commits []ExportedCommit  `json:"commits"`
+	Objects map[string]string `json:"objects"` // Git hash -> base64 content
+}
+
+// ExportedCommit is an MGit commit and the exported files it holds
+type ExportedCommit struct {
+	*mgitlib.Commit
+	Files map[string]string `json:"files,omitempty"` // Path -> blob hash
+}
+
+// HandleExport handles the export command
+func HandleExport(args []string) {
+	since, tip, output := "", "HEAD", "mgit-export.json"
+	tipGiven := false
+	paths := []string{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--verify" && len(args) == 2 && i == 0:
+			if err := verifyExport(args[1]); err != nil {
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		case args[i] == "--since" && i+1 < len(args):
+			i++
+			since = args[i]
+		case args[i] == "--paths" && i+1 < len(args):
+			i++
+			for _, pattern := range strings.Split(args[i], ",") {
+				if pattern = strings.TrimSpace(pattern); pattern != "" {
+					paths = append(paths, pattern)
+				}
+			}
+		case (args[i] == "-o" || args[i] == "--output") && i+1 < len(args):
+			i++
+			output = args[i]
+		case !strings.HasPrefix(args[i], "-") && !tipGiven:
+			tip, tipGiven = args[i], true
+		default:
+			fmt.Println(exportUsage)
+			os.Exit(1)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Println(exportUsage)
+		os.Exit(1)
+	}
+
+	export, err := exportHistory(since, tip, paths)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(output, append(data, '\n'), 0644); err != nil {
+		fmt.Printf("Error writing %s: %s\n", output, err)
+		os.Exit(1)
+	}
+
+	versions := 0
+	for _, commit := range export.Commits {
+		versions += len(commit.Files)
+	}
+	fmt.Printf("Exported %d commit(s) and %d file version(s) to %s\n", len(export.Commits), versions, output)
+	fmt.Printf("Tip: %s\n", export.Tip)
+	if versions == 0 {
+		fmt.Println("Warning: no file in the range matches the paths")
+	}
+}
+
+// exportHistory builds the export of the commits reachable from tip but not
+// from since, keeping the files that match paths
+func exportHistory(since, tip string, paths []string) (*HistoryExport, error) {
+	session := currentSession()
+	repo, err := session.Repo()
+	if err != nil {
+		return nil, err
+	}
+	storage := session.Storage()
+
+	rangeSpec := tip
+	if since != "" {
+		rangeSpec = since + ".." + tip
+	}
+	include, excluded, err := commitRange(repo, rangeSpec, nil)
+	if err != nil {
+		return nil, err
+	}
+	gitCommits := []*object.Commit{}
+	walkCommits(repo, include, excluded, func(c *object.Commit) { gitCommits = append(gitCommits, c) })
+	if len(gitCommits) == 0 {
+		return nil, fmt.Errorf("no commits in %s", rangeSpec)
+	}
+	// Oldest first; the walk went from the tip back
+	for i, j := 0, len(gitCommits)-1; i < j; i, j = i+1, j-1 {
+		gitCommits[i], gitCommits[j] = gitCommits[j], gitCommits[i]
+	}
+	sort.SliceStable(gitCommits, func(i, j int) bool {
+		return gitCommits[i].Committer.When.Before(gitCommits[j].Committer.When)
+	})
+
+	export := &HistoryExport{
+		Version: exportVersion,
+		Paths:   paths,
+		Base:    []string{},
+		Objects: map[string]string{},
+	}
+	exported := map[string]bool{}
+	for _, gitCommit := range gitCommits {
+		mapping, ok, err := storage.Mappings().ByGit(gitCommit.Hash.String())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("commit %s has no MGit hash (run mgit migrate)", gitCommit.Hash)
+		}
+		commit, err := storage.GetCommit(mapping.MGitHash)
+		if err != nil {
+			return nil, err
+		}
+		if commit.TreeHash != gitCommit.TreeHash.String() {
+			return nil, fmt.Errorf("MGit commit %s does not match git commit %s", commit.MGitHash, gitCommit.Hash)
+		}
+		files, err := exportFiles(repo, gitCommit, paths, export.Objects)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", shortHash(commit.MGitHash), err)
+		}
+		export.Commits = append(export.Commits, ExportedCommit{Commit: commit, Files: files})
+		exported[commit.MGitHash] = true
+	}
+
+	for _, commit := range export.Commits {
+		for _, parent := range commit.ParentHashes {
+			if !exported[parent] && !containsString(export.Base, parent) {
+				export.Base = append(export.Base, parent)
+			}
+		}
+	}
+	tipRevision, _ := Resolve(repo, storage, tip)
+	export.Tip = tipRevision.MGit
+	return export, nil
+}
+
+// exportFiles adds the files of a commit that match paths, and the tree
+// objects leading to them, to objects, and returns their blob hashes
+func exportFiles(repo *git.Repository, commit *object.Commit, paths []string, objects map[string]string) (map[string]string, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{}
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if !matchExportPath(paths, file.Name) {
+			return nil
+		}
+		files[file.Name] = file.Hash.String()
+		if err := addExportObject(repo, commit.TreeHash, objects); err != nil {
+			return err
+		}
+		// Every tree between the root and the file, then the file
+		dir := tree
+		parts := strings.Split(file.Name, "/")
+		for _, name := range parts[:len(parts)-1] {
+			entry, err := dir.FindEntry(name)
+			if err != nil {
+				return err
+			}
+			if err := addExportObject(repo, entry.Hash, objects); err != nil {
+				return err
+			}
+			if dir, err = repo.TreeObject(entry.Hash); err != nil {
+				return err
+			}
+		}
+		return addExportObject(repo, file.Hash, objects)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// addExportObject adds a git object's content to objects
+func addExportObject(repo *git.Repository, hash plumbing.Hash, objects map[string]string) error {
+	if _, ok := objects[hash.String()]; ok {
+		return nil
+	}
+	obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, hash)
+	if err != nil {
+		return fmt.Errorf("error reading object %s: %w", hash, err)
+	}
+	reader, err := obj.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	objects[hash.String()] = base64.StdEncoding.EncodeToString(data)
+	return nil
+}
+
+// matchExportPath reports whether a file, or a directory it is in, matches
+// one of the patterns
+func matchExportPath(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		for name := file; name != "." && name != "/"; name = path.Dir(name) {
+			if matchPathPattern(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyExport checks an export, as described above, and reports what it
+// proves
+func verifyExport(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	export := &HistoryExport{}
+	if err := json.Unmarshal(data, export); err != nil {
+		return fmt.Errorf("%s is not an MGit export: %w", file, err)
+	}
+	if export.Version != exportVersion {
+		return fmt.Errorf("unsupported export version %d", export.Version)
+	}
+
+	objects := map[string][]byte{}
+	for hash, encoded := range export.Objects {
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("object %s is not valid base64", hash)
+		}
+		objects[hash] = content
+	}
+
+	commits := map[string]bool{}
+	for _, commit := range export.Commits {
+		if commit.Commit == nil {
+			return fmt.Errorf("an exported commit is empty")
+		}
+		if computed := commit.Commit.ComputeHash(); computed != commit.MGitHash {
+			return fmt.Errorf("commit %s: its content hashes to %s", commit.MGitHash, computed)
+		}
+		commits[commit.MGitHash] = true
+	}
+	versions := 0
+	for _, commit := range export.Commits {
+		for _, parent := range commit.ParentHashes {
+			if !commits[parent] && !containsString(export.Base, parent) {
+				return fmt.Errorf("commit %s: parent %s is neither exported nor a base commit", commit.MGitHash, parent)
+			}
+		}
+		for name, blobHash := range commit.Files {
+			if !matchExportPath(export.Paths, name) {
+				return fmt.Errorf("commit %s: %s is outside the exported paths", commit.MGitHash, name)
+			}
+			if err := verifyExportedFile(objects, commit.TreeHash, name, blobHash); err != nil {
+				return fmt.Errorf("commit %s: %s: %w", commit.MGitHash, name, err)
+			}
+			versions++
+		}
+	}
+	if !commits[export.Tip] {
+		return fmt.Errorf("the tip %s is not an exported commit", export.Tip)
+	}
+
+	fmt.Printf("Verified %d commit(s) and %d file version(s) of %s\n", len(export.Commits), versions, strings.Join(export.Paths, ", "))
+	fmt.Printf("Tip:  %s\n", export.Tip)
+	if len(export.Base) == 0 {
+		fmt.Println("Base: none (the export starts at the first commit)")
+	}
+	for _, base := range export.Base {
+		fmt.Printf("Base: %s\n", base)
+	}
+	fmt.Println("Check the tip or the base against a copy of the repository you trust")
+	return nil
+}
+
+// verifyExportedFile follows a file's path from a root tree to its blob,
+// checking each object against the hash that leads to it
+func verifyExportedFile(objects map[string][]byte, treeHash, name, blobHash string) error {
+	hash := treeHash
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		content, ok := objects[hash]
+		if !ok {
+			return fmt.Errorf("tree %s is missing", hash)
+		}
+		if computed := plumbing.ComputeHash(plumbing.TreeObject, content); computed.String() != hash {
+			return fmt.Errorf("tree %s hashes to %s", hash, computed)
+		}
+		obj := &plumbing.MemoryObject{}
+		obj.SetType(plumbing.TreeObject)
+		obj.Write(content)
+		tree := &object.Tree{}
+		if err := tree.Decode(obj); err != nil {
+			return fmt.Errorf("tree %s: %w", hash, err)
+		}
+		entry, err := tree.FindEntry(part)
+		if err != nil {
+			return fmt.Errorf("not in tree %s", hash)
+		}
+		if isDir := entry.Mode == filemode.Dir; isDir != (i < len(parts)-1) {
+			return fmt.Errorf("%s has the wrong type in tree %s", part, hash)
+		}
+		hash = entry.Hash.String()
+	}
+
+	if hash != blobHash {
+		return fmt.Errorf("the tree holds blob %s, not %s", hash, blobHash)
+	}
+	content, ok := objects[hash]
+	if !ok {
+		return fmt.Errorf("blob %s is missing", hash)
+	}
+	if computed := plumbing.ComputeHash(plumbing.BlobObject, content); computed.String() != hash {
+		return fmt.Errorf("blob %s hashes to %s", hash, computed)
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// FsckReport collects the problems mgit fsck finds. Errors mean the store is
+// damaged; warnings are expected in some setups (shallow clones have parents
+// and mappings without local objects).
+type FsckReport struct {
+	Objects  int
+	Packs    int
+	Legacy   int // Uncompressed objects from before compressed storage
+	JSON     int // Commits stored as JSON rather than the canonical encoding
+	Errors   []string
+	Warnings []string
+}
+
+func (r *FsckReport) errorf(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *FsckReport) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// HandleFsck handles the fsck command
+func HandleFsck(args []string) {
+	verbose := false
+	for _, arg := range args {
+		switch arg {
+		case "-v", "--verbose":
+			verbose = true
+		default:
+			fmt.Println("Usage: mgit fsck [--verbose]")
+			os.Exit(1)
+		}
+	}
+
+	storage := currentSession().Storage()
+	if _, err := os.Stat(storage.RootDir); os.IsNotExist(err) {
+		fmt.Println("Error: no .mgit directory")
+		os.Exit(1)
+	}
+
+	report, err := fsckStorage(storage)
+	if err != nil {
+		fmt.Printf("Error checking MGit objects: %s\n", err)
+		os.Exit(1)
+	}
+
+	for _, problem := range report.Errors {
+		fmt.Printf("error: %s\n", problem)
+	}
+	if verbose {
+		for _, problem := range report.Warnings {
+			fmt.Printf("warning: %s\n", problem)
+		}
+	}
+
+	fmt.Printf("Checked %d object(s), %d pack(s): %d error(s), %d warning(s)\n",
+		report.Objects, report.Packs, len(report.Errors), len(report.Warnings))
+	if len(report.Warnings) > 0 && !verbose {
+		fmt.Println("Run with --verbose to list the warnings")
+	}
+	if report.Legacy > 0 {
+		fmt.Printf("%d object(s) use the old uncompressed format\n", report.Legacy)
+	}
+	if report.JSON > 0 {
+		fmt.Printf("%d commit(s) are stored as JSON; run mgit gc to convert them\n", report.JSON)
+	}
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// fsckStorage checks every stored object and every reference to one
+func fsckStorage(storage *MGitStorage) (*FsckReport, error) {
+	report := &FsckReport{}
+
+	packs, err := packsFor(storage.packDir()).list()
+	if err != nil {
+		return nil, err
+	}
+	for _, pack := range packs {
+		report.Packs++
+		if err := verifyPack(pack); err != nil {
+			report.errorf("%s", err)
+		}
+	}
+
+	hashes, err := storage.ListObjects()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(hashes)
+
+	objects := map[string]bool{}
+	for _, hash := range hashes {
+		objects[hash] = true
+	}
+
+	for _, hash := range hashes {
+		report.Objects++
+		if !isFullHash(hash) {
+			report.errorf("%s: file name is not an object hash", hash)
+			continue
+		}
+
+		raw, err := storage.readStoredObject(hash)
+		if err != nil {
+			report.errorf("%s: %s", hash, err)
+			continue
+		}
+		objType, body, err := decodeObject(raw)
+		if err != nil {
+			report.errorf("%s: %s", hash, err)
+			continue
+		}
+		if objType == "" {
+			report.Legacy++
+		}
+		objType, err = checkObjectBody(hash, objType, body)
+		if err != nil {
+			report.errorf("%s: %s", hash, err)
+			continue
+		}
+
+		switch objType {
+		case mgitlib.CommitObject:
+			commit, err := mgitlib.ParseCommit(hash, body)
+			if err != nil {
+				report.errorf("%s: invalid commit: %s", hash, err)
+				continue
+			}
+			if !mgitlib.IsEncodedCommit(body) {
+				report.JSON++
+			}
+			if commit.GitHash == "" || commit.Author == nil {
+				report.errorf("%s: commit is missing its git hash or author", hash)
+			}
+			for _, parent := range commit.ParentHashes {
+				if !objects[parent] {
+					report.warnf("%s: parent %s is not in the object store", hash, parent)
+				}
+			}
+		case mgitlib.AnnotationObject:
+			var annotation MAnnotationStruct
+			if err := json.Unmarshal(body, &annotation); err != nil {
+				report.errorf("%s: invalid annotation: %s", hash, err)
+				continue
+			}
+			if !objects[annotation.Target] {
+				report.warnf("%s: annotated commit %s is not in the object store", hash, annotation.Target)
+			}
+		default:
+			report.errorf("%s: unknown object type %q", hash, objType)
+		}
+	}
+
+	// Refs must point at objects we have
+	refs, err := storage.ListRefs("refs")
+	if err != nil {
+		return nil, err
+	}
+	refNames := make([]string, 0, len(refs))
+	for name := range refs {
+		refNames = append(refNames, name)
+	}
+	sort.Strings(refNames)
+	for _, name := range refNames {
+		if !objects[refs[name]] {
+			report.errorf("%s points at missing object %s", name, refs[name])
+		}
+	}
+	if head, err := storage.GetHead(); err == nil && !strings.HasPrefix(head, "refs/") && !objects[head] {
+		report.errorf("detached HEAD points at missing object %s", head)
+	}
+
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		return nil, err
+	}
+	for _, mapping := range mappings {
+		if !objects[mapping.MGitHash] {
+			report.warnf("mapping %s -> %s has no object", shortHash(mapping.GitHash), mapping.MGitHash)
+		}
+	}
+
+	return report, nil
+}
+
+// isFullHash reports whether s is a 40 character lowercase hex hash
+func isFullHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,291 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// mgit fsmonitor watches the working copy it is started in and keeps the
+// paths that change, so that status, asking it (see status_cache.go), only
+// looks at those. It runs in the foreground and answers on
+// .mgit/fsmonitor.sock; set status.cache = true and status.fsmonitor =
+// native to use it.
+//
+// A query is the token of the last one, and the reply a new token and the
+// paths changed since, each NUL-terminated, as in git's fsmonitor hook
+// protocol; "/" means anything may have changed. A token is the monitor's
+// run ID and a sequence number. One from another run, or older than the
+// changes kept, gets "/", and so do all queries after the kernel dropped
+// events. With status.fsmonitor = watchman, status asks watchman instead,
+// whose clocks serve as tokens.
+
+const fsmonitorUsage = "Usage: mgit fsmonitor [run]"
+
+// fsmonitorMaxChanges bounds the changes the monitor keeps
+const fsmonitorMaxChanges = 100000
+
+// errFsmonitorNotRunning is returned when no monitor answers
+var errFsmonitorNotRunning = errors.New("mgit fsmonitor is not running")
+
+// fsMonitor holds the changes seen since the monitor started
+type fsMonitor struct {
+	mu      sync.Mutex
+	id      string // Tells this run's tokens from another's
+	seq     uint64
+	changes []fsChange
+	since   uint64 // Tokens before this miss changes no longer kept
+}
+
+// fsChange is one changed path, relative to the working copy
+type fsChange struct {
+	seq  uint64
+	path string
+}
+
+// fsmonitorSocketPath returns the socket the monitor answers on
+func (s *MGitStorage) fsmonitorSocketPath() string {
+	return filepath.Join(s.RootDir, "fsmonitor.sock")
+}
+
+// HandleFsmonitor handles the fsmonitor command
+func HandleFsmonitor(args []string) {
+	if len(args) > 1 || len(args) == 1 && args[0] != "run" {
+		fmt.Println(fsmonitorUsage)
+		os.Exit(1)
+	}
+	runFsmonitor()
+}
+
+// runFsmonitor watches the working copy and answers queries until stopped
+func runFsmonitor() {
+	getRepo()
+	root, err := filepath.Abs(".")
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	socket, err := filepath.Abs(currentSession().Storage().fsmonitorSocketPath())
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	monitor := &fsMonitor{id: strconv.FormatInt(time.Now().UnixNano(), 36)}
+	ready := make(chan struct{})
+	failed := make(chan error, 1)
+	go func() {
+		failed <- watchTree(root, func() { close(ready) }, monitor.changed, monitor.overflowed)
+	}()
+	// Tokens are only handed out once every directory is watched
+	select {
+	case <-ready:
+	case err := <-failed:
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	listener, err := listenDaemonSocket(socket)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	stop := func(code int) {
+		listener.Close()
+		os.Remove(socket)
+		os.Exit(code)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			stop(0)
+		case err := <-failed:
+			fmt.Printf("Error: %s\n", err)
+			stop(1)
+		}
+	}()
+
+	fmt.Printf("mgit fsmonitor watching %s on %s\n", root, socket)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("Error accepting connection: %s\n", err)
+			stop(1)
+		}
+		go monitor.answer(conn)
+	}
+}
+
+// changed records a changed path
+func (m *fsMonitor) changed(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	m.changes = append(m.changes, fsChange{seq: m.seq, path: path})
+	if len(m.changes) > fsmonitorMaxChanges {
+		drop := len(m.changes) - fsmonitorMaxChanges
+		m.since = m.changes[drop-1].seq
+		m.changes = append([]fsChange{}, m.changes[drop:]...)
+	}
+}
+
+// overflowed forgets the changes when some were lost
+func (m *fsMonitor) overflowed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	m.changes = nil
+	m.since = m.seq
+}
+
+// query returns a new token and the paths changed since token, or "/"
+// when that isn't known
+func (m *fsMonitor) query(token string) (string, []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	newToken := m.id + ":" + strconv.FormatUint(m.seq, 10)
+
+	id, seqText, ok := strings.Cut(token, ":")
+	seq, err := strconv.ParseUint(seqText, 10, 64)
+	if !ok || id != m.id || err != nil || seq < m.since || seq > m.seq {
+		return newToken, []string{"/"}
+	}
+	paths := []string{}
+	seen := map[string]bool{}
+	for i := len(m.changes) - 1; i >= 0 && m.changes[i].seq > seq; i-- {
+		if path := m.changes[i].path; !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return newToken, paths
+}
+
+// answer reads a token from a connection and writes the query's reply
+func (m *fsMonitor) answer(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	token, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	newToken, paths := m.query(strings.TrimSuffix(token, "\n"))
+	conn.Write(fsmonitorReply(newToken, paths))
+}
+
+// fsmonitorReply formats a token and paths for a reply
+func fsmonitorReply(token string, paths []string) []byte {
+	var b bytes.Buffer
+	b.WriteString(token + "\x00")
+	for _, path := range paths {
+		b.WriteString(path + "\x00")
+	}
+	return b.Bytes()
+}
+
+// queryNativeMonitor asks mgit fsmonitor for the paths changed since token
+func queryNativeMonitor(token string) (string, []string, error) {
+	conn, err := net.DialTimeout("unix", NewMGitStorage().fsmonitorSocketPath(), time.Second)
+	if err != nil {
+		return "", nil, errFsmonitorNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintf(conn, "%s\n", token); err != nil {
+		return "", nil, err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", nil, err
+	}
+	fields := strings.Split(strings.TrimSuffix(string(reply), "\x00"), "\x00")
+	if fields[0] == "" {
+		return "", nil, fmt.Errorf("empty reply from mgit fsmonitor")
+	}
+	return fields[0], fields[1:], nil
+}
+
+// watchmanResult holds the parts of watchman's replies status reads
+type watchmanResult struct {
+	Error           string   `json:"error"`
+	Watch           string   `json:"watch"`
+	RelativePath    string   `json:"relative_path"`
+	Clock           string   `json:"clock"`
+	Files           []string `json:"files"`
+	IsFreshInstance bool     `json:"is_fresh_instance"`
+}
+
+// queryWatchman asks watchman for the paths changed since the clock token
+func queryWatchman(token string) (string, []string, error) {
+	root, err := filepath.Abs(".")
+	if err != nil {
+		return "", nil, err
+	}
+	project, err := watchmanCommand([]interface{}{"watch-project", root})
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Without a clock of watchman's, anything may have changed
+	if !strings.HasPrefix(token, "c:") {
+		clock, err := watchmanCommand([]interface{}{"clock", project.Watch})
+		if err != nil {
+			return "", nil, err
+		}
+		return clock.Clock, []string{"/"}, nil
+	}
+
+	query := map[string]interface{}{
+		"since":      token,
+		"fields":     []string{"name"},
+		"expression": []interface{}{"not", []interface{}{"dirname", ".git"}},
+	}
+	if project.RelativePath != "" {
+		query["relative_root"] = project.RelativePath
+	}
+	result, err := watchmanCommand([]interface{}{"query", project.Watch, query})
+	if err != nil {
+		return "", nil, err
+	}
+	if result.IsFreshInstance {
+		return result.Clock, []string{"/"}, nil
+	}
+	return result.Clock, result.Files, nil
+}
+
+// watchmanCommand runs one watchman command
+func watchmanCommand(command []interface{}) (*watchmanResult, error) {
+	input, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("watchman", "-j", "--no-pretty")
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("watchman %s: %w", command[0], err)
+	}
+	result := &watchmanResult{}
+	if err := json.Unmarshal(output, result); err != nil {
+		return nil, fmt.Errorf("error parsing watchman's reply: %w", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("watchman %s: %s", command[0], result.Error)
+	}
+	return result, nil
+}
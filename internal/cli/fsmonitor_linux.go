@@ -0,0 +1,105 @@
+//go:build linux
+
+package cli
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsmonitorWatchMask is the inotify events that change a working copy
+const fsmonitorWatchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_ATTRIB |
+	unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+
+// watchTree watches every directory under root but .git with inotify and
+// reports each changed path, relative to root, to changed; directories end
+// in /. ready is called once the tree is watched, and overflow when the
+// kernel dropped events. It returns only on an error.
+func watchTree(root string, ready func(), changed func(string), overflow func()) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("inotify: %w", err)
+	}
+	defer unix.Close(fd)
+
+	dirs := map[int32]string{}
+	watch := func(dir string) error {
+		return filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				// Gone since it was listed
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if rel == ".git" {
+				return filepath.SkipDir
+			}
+			wd, err := unix.InotifyAddWatch(fd, path, fsmonitorWatchMask)
+			if err == unix.ENOSPC {
+				return fmt.Errorf("too many directories to watch (raise fs.inotify.max_user_watches): %w", err)
+			}
+			if rel == "." {
+				rel = ""
+			}
+			if err == nil {
+				dirs[int32(wd)] = filepath.ToSlash(rel)
+			}
+			return nil
+		})
+	}
+	if err := watch(""); err != nil {
+		return err
+	}
+	ready()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("inotify: %w", err)
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				overflow()
+				continue
+			}
+			if event.Mask&unix.IN_IGNORED != 0 {
+				delete(dirs, event.Wd)
+				continue
+			}
+			dir, ok := dirs[event.Wd]
+			if !ok || len(name) == 0 {
+				continue
+			}
+			path := strings.TrimRight(string(name), "\x00")
+			if dir != "" {
+				path = dir + "/" + path
+			}
+			if event.Mask&unix.IN_ISDIR != 0 {
+				// A new directory's files may predate its watch; status looks
+				// at all of it, as the path ends in /
+				if event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+					if err := watch(path); err != nil {
+						return err
+					}
+				}
+				path += "/"
+			}
+			changed(path)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package cli
+
+import "fmt"
+
+// watchTree is only available on Linux, with inotify
+func watchTree(root string, ready func(), changed func(string), overflow func()) error {
+	return fmt.Errorf("mgit fsmonitor needs Linux; use status.fsmonitor = watchman instead")
+}
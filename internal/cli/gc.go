@@ -0,0 +1,474 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// defaultPruneExpire is how old an unreachable loose object must be before
+// gc deletes it, so objects written by a command still running survive
+const defaultPruneExpire = "336h"
+
+// HandleGC handles the gc command: it compacts the hash mappings, prunes
+// unreachable objects and packs the rest
+func HandleGC(args []string) {
+	expire := GetConfigValue("gc.pruneExpire", defaultPruneExpire)
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--prune=") {
+			expire = strings.TrimPrefix(arg, "--prune=")
+			continue
+		}
+		fmt.Println("Usage: mgit gc [--prune=<now|never|duration>]")
+		os.Exit(1)
+	}
+	cutoff, pruneAll, err := parsePruneExpire(expire, time.Now())
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	storage := NewMGitStorage()
+	if _, err := os.Stat(storage.RootDir); os.IsNotExist(err) {
+		fmt.Println("Nothing to do: no .mgit directory")
+		return
+	}
+	defer lockRepoOrExit("gc").Release()
+
+	merged, dropped, err := storage.CompactMappings()
+	if err != nil {
+		fmt.Printf("Error compacting hash mappings: %s\n", err)
+		os.Exit(1)
+	}
+
+	if merged > 0 {
+		fmt.Printf("Merged %d mapping(s) from legacy nostr_mappings.json\n", merged)
+	}
+	if dropped > 0 {
+		fmt.Printf("Dropped %d duplicate mapping(s)\n", dropped)
+	}
+	fmt.Println("Hash mappings compacted")
+
+	// Without a git repository, mapped commits can't be checked for
+	// reachability and are all kept
+	repo, _ := currentSession().Repo()
+
+	result, err := collectGarbage(repo, storage, cutoff, pruneAll)
+	if err != nil {
+		fmt.Printf("Error collecting garbage: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Packed %d object(s)", result.Packed)
+	if result.Pack != "" {
+		fmt.Printf(" into %s", filepath.Base(result.Pack))
+	}
+	fmt.Println()
+	fmt.Printf("Pruned %d unreachable object(s)\n", result.Pruned)
+	if result.Held > 0 {
+		fmt.Printf("Kept %d unreachable object(s) under legal hold\n", result.Held)
+	}
+	if result.Recent > 0 {
+		fmt.Printf("Kept %d unreachable object(s) newer than the prune expiry\n", result.Recent)
+	}
+	if result.Converted > 0 {
+		fmt.Printf("Converted %d JSON commit(s) to the canonical encoding\n", result.Converted)
+	}
+	if result.Unconverted > 0 {
+		fmt.Printf("Left %d commit(s) as JSON: their hash can't be recomputed from their fields\n", result.Unconverted)
+	}
+}
+
+// parsePruneExpire turns a --prune value into the modification time before
+// which unreachable objects are deleted. pruneAll is set for "now", which
+// also drops unreachable objects that are already packed.
+func parsePruneExpire(expire string, now time.Time) (cutoff time.Time, pruneAll bool, err error) {
+	switch expire {
+	case "now":
+		return now, true, nil
+	case "never":
+		return time.Time{}, false, nil
+	}
+	age, err := time.ParseDuration(expire)
+	if err != nil || age < 0 {
+		return time.Time{}, false, fmt.Errorf("invalid prune expiry %q: use now, never or a duration like 336h", expire)
+	}
+	return now.Add(-age), false, nil
+}
+
+// GCResult summarises what collectGarbage did
+type GCResult struct {
+	Pack   string // Path of the new pack, if one was written
+	Packed int
+	Pruned int
+	Held   int // Unreachable objects kept because of a legal hold
+	Recent int // Unreachable objects kept because they are too new
+
+	Converted   int // JSON commits rewritten in the canonical encoding
+	Unconverted int // JSON commits whose hash the encoding can't reproduce
+}
+
+// collectGarbage packs every object worth keeping into a single new pack and
+// deletes the rest. Reachable and held objects are always kept. Unreachable
+// loose objects are deleted once older than cutoff; unreachable packed
+// objects only when pruneAll is set.
+func collectGarbage(repo *git.Repository, storage *MGitStorage, cutoff time.Time, pruneAll bool) (*GCResult, error) {
+	result := &GCResult{}
+
+	reachable, err := reachableObjects(repo, storage)
+	if err != nil {
+		return nil, err
+	}
+	held := map[string][]*LegalHold{}
+	if repo != nil {
+		if held, err = heldHistory(repo, storage); err != nil {
+			return nil, fmt.Errorf("failed to read legal holds: %w", err)
+		}
+		// A held commit's ancestry is held with it, so it still verifies
+		queue := []string{}
+		for hash := range held {
+			queue = append(queue, hash)
+		}
+		for len(queue) > 0 {
+			hash := queue[0]
+			queue = queue[1:]
+			commit, err := storage.GetCommit(hash)
+			if err != nil {
+				continue
+			}
+			for _, parent := range commit.ParentHashes {
+				if len(held[parent]) == 0 {
+					held[parent] = held[hash]
+					queue = append(queue, parent)
+				}
+			}
+		}
+		// And so are its annotations
+		for hash, holds := range held {
+			annotations, err := storage.annotationHashes(hash)
+			if err != nil {
+				return nil, err
+			}
+			for _, annotation := range annotations {
+				held[annotation] = holds
+			}
+		}
+	}
+
+	loose, err := storage.ListLooseObjects()
+	if err != nil {
+		return nil, err
+	}
+	isLoose := map[string]bool{}
+	for _, hash := range loose {
+		isLoose[hash] = true
+	}
+	all, err := storage.ListObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	keep := map[string][]byte{}
+	prune := []string{}
+	for _, hash := range all {
+		if !reachable[hash] {
+			if len(held[hash]) > 0 {
+				result.Held++
+			} else if isLoose[hash] {
+				info, err := os.Stat(storage.objectPath(hash))
+				if err != nil {
+					return nil, fmt.Errorf("failed to stat object %s: %w", hash, err)
+				}
+				if info.ModTime().Before(cutoff) || pruneAll {
+					prune = append(prune, hash)
+				} else {
+					// Leave it loose, so its age keeps counting
+					result.Recent++
+				}
+				continue
+			} else if pruneAll {
+				prune = append(prune, hash)
+				continue
+			}
+		}
+
+		// Never pack a damaged object; that would hide it from fsck
+		raw, err := storage.readStoredObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", hash, err)
+		}
+		objType, body, err := decodeObject(raw)
+		var bodyType mgitlib.ObjectType
+		if err == nil {
+			bodyType, err = checkObjectBody(hash, objType, body)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("object %s is corrupt (run mgit fsck): %w", hash, err)
+		}
+		rewrite := objType == ""
+		if bodyType == mgitlib.CommitObject && !mgitlib.IsEncodedCommit(body) {
+			if converted, ok := convertStoredCommit(repo, hash, body); ok {
+				body, rewrite = converted, true
+				result.Converted++
+			} else {
+				result.Unconverted++
+			}
+		}
+		if rewrite {
+			// Legacy uncompressed object or JSON commit - pack it in the
+			// current format
+			if raw, err = encodeObject(bodyType, body); err != nil {
+				return nil, fmt.Errorf("failed to compress object %s: %w", hash, err)
+			}
+		}
+		keep[hash] = raw
+	}
+
+	oldPacks, err := packsFor(storage.packDir()).list()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keep) > 0 {
+		if result.Pack, err = writePack(storage.packDir(), keep); err != nil {
+			return nil, err
+		}
+		result.Packed = len(keep)
+	}
+	for _, pack := range oldPacks {
+		if filepath.Base(pack.path) == filepath.Base(result.Pack) {
+			continue
+		}
+		if err := os.Remove(strings.TrimSuffix(pack.path, ".pack") + ".idx"); err != nil {
+			return nil, fmt.Errorf("failed to remove old pack: %w", err)
+		}
+		if err := os.Remove(pack.path); err != nil {
+			return nil, fmt.Errorf("failed to remove old pack: %w", err)
+		}
+	}
+	packsFor(storage.packDir()).invalidate()
+
+	// The new pack holds everything kept, so the loose copies can go
+	for hash := range keep {
+		if isLoose[hash] {
+			if err := os.Remove(storage.objectPath(hash)); err != nil {
+				return nil, fmt.Errorf("failed to remove packed object: %w", err)
+			}
+		}
+	}
+	for _, hash := range prune {
+		if isLoose[hash] {
+			if err := os.Remove(storage.objectPath(hash)); err != nil {
+				return nil, fmt.Errorf("failed to prune object: %w", err)
+			}
+		}
+		// Annotation indexes and notes only exist for commits
+		os.Remove(storage.annotationIndexPath(hash))
+		os.Remove(storage.notesPath(hash))
+	}
+	result.Pruned = len(prune)
+	removeEmptyObjectDirs(storage)
+
+	if len(prune) > 0 {
+		if err := dropPrunedMappings(storage, prune); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// reachableObjects returns the MGit objects reachable from MGit refs, a
+// detached MGit HEAD, or a git commit reachable from a git ref. Annotations
+// are reachable when the commit they annotate is.
+func reachableObjects(repo *git.Repository, storage *MGitStorage) (map[string]bool, error) {
+	roots := []string{}
+
+	refs, err := storage.ListRefs("refs")
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range refs {
+		roots = append(roots, hash)
+	}
+	if head, err := storage.GetHead(); err == nil && !strings.HasPrefix(head, "refs/") {
+		roots = append(roots, head)
+	}
+	// Keep what the reflogs name, so mistakes can still be undone
+	logged, err := storage.reflogHashes()
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, logged...)
+
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		return nil, err
+	}
+	if repo == nil {
+		for _, mapping := range mappings {
+			roots = append(roots, mapping.MGitHash)
+		}
+	} else {
+		gitReachable, err := reachableGitCommits(repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, mapping := range mappings {
+			if gitReachable[plumbing.NewHash(mapping.GitHash)] {
+				roots = append(roots, mapping.MGitHash)
+			}
+		}
+	}
+
+	reachable := map[string]bool{}
+	for len(roots) > 0 {
+		hash := roots[0]
+		roots = roots[1:]
+		if hash == "" || reachable[hash] {
+			continue
+		}
+		reachable[hash] = true
+
+		commit, err := storage.GetCommit(hash)
+		if err != nil {
+			// Parents cut off by a shallow clone
+			continue
+		}
+		roots = append(roots, commit.ParentHashes...)
+
+		annotations, err := storage.annotationHashes(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, annotation := range annotations {
+			reachable[annotation] = true
+		}
+	}
+	return reachable, nil
+}
+
+// reachableGitCommits returns every git commit reachable from HEAD or any
+// ref, remote-tracking refs included
+func reachableGitCommits(repo *git.Repository) (map[plumbing.Hash]bool, error) {
+	tips := []plumbing.Hash{}
+	if head, err := repo.Head(); err == nil {
+		tips = append(tips, head.Hash())
+	}
+	refs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("error getting references: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		hash := ref.Hash()
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		tips = append(tips, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error processing references: %w", err)
+	}
+
+	reachable := map[plumbing.Hash]bool{}
+	walkCommits(repo, tips, nil, func(c *object.Commit) { reachable[c.Hash] = true })
+	return reachable, nil
+}
+
+// dropPrunedMappings removes the mappings of pruned commits
+func dropPrunedMappings(storage *MGitStorage, pruned []string) error {
+	gone := map[string]bool{}
+	for _, hash := range pruned {
+		gone[hash] = true
+	}
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		return err
+	}
+	kept := []NostrCommitMapping{}
+	for _, mapping := range mappings {
+		if !gone[mapping.MGitHash] {
+			kept = append(kept, mapping)
+		}
+	}
+	if len(kept) == len(mappings) {
+		return nil
+	}
+	return storage.WriteMappings(kept)
+}
+
+// removeEmptyObjectDirs deletes fan-out directories left empty by gc
+func removeEmptyObjectDirs(storage *MGitStorage) {
+	objectsDir := filepath.Join(storage.RootDir, "objects")
+	dirs, err := os.ReadDir(objectsDir)
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		if dir.IsDir() && len(dir.Name()) == 2 {
+			// Fails, harmlessly, unless the directory is empty
+			os.Remove(filepath.Join(objectsDir, dir.Name()))
+		}
+	}
+}
+
+// convertStoredCommit returns the canonical encoding of a commit stored as
+// JSON, or false if its hash can't be reproduced from the encoding. The git
+// commit's committer is offered in case the stored one is wrong.
+func convertStoredCommit(repo *git.Repository, hash string, body []byte) ([]byte, bool) {
+	commit, err := mgitlib.ParseCommit(hash, body)
+	if err != nil {
+		return nil, false
+	}
+	var gitCommitter *mgitlib.Signature
+	if repo != nil && commit.GitHash != "" {
+		if gitCommit, err := repo.CommitObject(plumbing.NewHash(commit.GitHash)); err == nil {
+			gitCommitter = &mgitlib.Signature{
+				Name:  gitCommit.Committer.Name,
+				Email: gitCommit.Committer.Email,
+				When:  gitCommit.Committer.When,
+			}
+			if commit.Author != nil {
+				gitCommitter.Pubkey = commit.Author.Pubkey
+			}
+		}
+	}
+	converted, err := convertLegacyCommit(commit, gitCommitter)
+	if err != nil {
+		return nil, false
+	}
+	return converted, true
+}
+// convertLegacyCommit turns a commit stored as JSON into the canonical
+// format 0 encoding, provided its hash can then be recomputed from what is
+// stored. Commits rebuilt from mappings by older clones recorded the author
+// as committer; gitCommitter, if known, is tried in its place.
+func convertLegacyCommit(commit *mgitlib.Commit, gitCommitter *mgitlib.Signature) ([]byte, error) {
+	converted := *commit
+	converted.Format = mgitlib.FormatLegacy
+	if converted.ComputeHash() != commit.MGitHash && gitCommitter != nil {
+		converted.Committer = gitCommitter
+	}
+
+	// The encoding must also round-trip: names with '<' or '>' would not
+	data := converted.Encode(true)
+	decoded, err := mgitlib.DecodeCommit(data)
+	if err != nil {
+		return nil, err
+	}
+	if decoded.ComputeHash() != commit.MGitHash {
+		return nil, fmt.Errorf("hash %s cannot be recomputed from the commit's fields", shortHash(commit.MGitHash))
+	}
+	return data, nil
+}
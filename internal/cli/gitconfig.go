@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gitconfig "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// mgit keeps its settings in .mgit/config, but the repository's git config,
+// .git/config, holds what git and its tools set: remotes, branch tracking,
+// core settings and often user.name and user.email. mgit reads it as the
+// "git" scope, between .mgit/config and the global config:
+//
+//	environment > .mgit/config > .git/config > identity profile > global > system
+//
+// mgit config --git reads or changes .git/config only. The file is read
+// and written with go-git's config format, which quotes values as git
+// does; it is locked as .git/config.lock, the lock git itself takes. Keys
+// in sections with a subsection are named as in git, remote.origin.url for
+// url in [remote "origin"].
+
+// gitConfigPath is the repository's git config, from the working directory
+const gitConfigPath = ".git/config"
+
+// readConfigScope loads the config of a scope for reading values
+func readConfigScope(scope string) (*Config, error) {
+	if scope == "git" {
+		return readGitConfig(gitConfigPath)
+	}
+	return ReadConfig(configScopePath(scope))
+}
+
+// updateConfigScope loads the config of a scope, applies fn and saves the
+// result, as UpdateConfig does
+func updateConfigScope(scope string, fn func(*Config) error) error {
+	if scope == "git" {
+		return updateGitConfig(gitConfigPath, fn)
+	}
+	return UpdateConfig(configScopePath(scope), fn)
+}
+
+// readGitConfig loads a git config file; a missing file is empty
+func readGitConfig(file string) (*Config, error) {
+	config := &Config{
+		Sections: make(map[string]map[string]string),
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw := gitconfig.New()
+	if err := gitconfig.NewDecoder(bytes.NewReader(data)).Decode(raw); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", file, err)
+	}
+	for _, section := range raw.Sections {
+		for _, option := range section.Options {
+			config.Add(section.Name, option.Key, option.Value)
+		}
+		for _, subsection := range section.Subsections {
+			name := fmt.Sprintf("%s \"%s\"", section.Name, subsection.Name)
+			for _, option := range subsection.Options {
+				config.Add(name, option.Key, option.Value)
+			}
+		}
+	}
+	return config, nil
+}
+
+// updateGitConfig loads a git config file, applies fn and writes the
+// result back in git's format, holding git's lock on the file meanwhile
+func updateGitConfig(file string, fn func(*Config) error) error {
+	if _, err := os.Stat(filepath.Dir(file)); err != nil {
+		return fmt.Errorf("not in a git repository (%s not found)", filepath.Dir(file))
+	}
+	lock, err := fsutil.LockFile(file, fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	config, err := readGitConfig(file)
+	if err != nil {
+		return err
+	}
+	if err := fn(config); err != nil {
+		return err
+	}
+
+	raw := gitconfig.New()
+	for _, section := range config.SectionNames() {
+		name, subsection := section, gitconfig.NoSubsection
+		if i := strings.Index(section, " \""); i > 0 && strings.HasSuffix(section, "\"") {
+			name, subsection = section[:i], section[i+2:len(section)-1]
+		}
+		for _, key := range config.KeyNames(section) {
+			for _, value := range config.GetAll(section, key) {
+				raw.AddOption(name, subsection, key, value)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := gitconfig.NewEncoder(&buf).Encode(raw); err != nil {
+		return fmt.Errorf("error encoding %s: %w", file, err)
+	}
+	return fsutil.WriteFile(file, buf.Bytes())
+}
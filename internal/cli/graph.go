@@ -0,0 +1,296 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// GraphNode is one commit in an exported commit graph
+type GraphNode struct {
+	ID      string    `json:"id"` // MGit hash, or git hash for commits without one
+	MGit    string    `json:"mgit_hash,omitempty"`
+	Git     string    `json:"git_hash"`
+	Parents []string  `json:"parents"` // Parent IDs
+	Pubkey  string    `json:"pubkey,omitempty"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+	Refs    []string  `json:"refs,omitempty"` // Branch and tag names pointing here
+}
+
+// CommitGraph is the exported DAG, newest commits first
+type CommitGraph struct {
+	Nodes []*GraphNode `json:"nodes"`
+}
+
+const graphUsage = "Usage: mgit graph export [--format dot|json|mermaid] [<rev> | <rev>..<rev>]"
+
+// HandleGraph handles the graph command
+func HandleGraph(args []string) {
+	if len(args) == 0 || args[0] != "export" {
+		fmt.Println(graphUsage)
+		os.Exit(1)
+	}
+
+	format := "dot"
+	rangeSpec := ""
+	for i := 1; i < len(args); i++ {
+		switch {
+		case args[i] == "--format":
+			if i+1 >= len(args) {
+				fmt.Println(graphUsage)
+				os.Exit(1)
+			}
+			format = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--format="):
+			format = strings.TrimPrefix(args[i], "--format=")
+		case strings.HasPrefix(args[i], "-") || rangeSpec != "":
+			fmt.Println(graphUsage)
+			os.Exit(1)
+		default:
+			rangeSpec = args[i]
+		}
+	}
+
+	session := currentSession()
+	graph, err := buildCommitGraph(session.MustRepo(), session.Storage(), rangeSpec)
+	if err != nil {
+		fmt.Printf("Error building commit graph: %s\n", err)
+		os.Exit(1)
+	}
+
+	switch format {
+	case "dot":
+		writeGraphDot(os.Stdout, graph)
+	case "mermaid":
+		writeGraphMermaid(os.Stdout, graph)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(graph); err != nil {
+			fmt.Printf("Error encoding graph: %s\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Error: unknown format '%s' (expected dot, json or mermaid)\n", format)
+		os.Exit(1)
+	}
+}
+
+// buildCommitGraph collects the commits selected by rangeSpec: everything
+// reachable from a revision, "<a>..<b>" for commits reachable from b but not
+// a, or every branch and tag when empty
+func buildCommitGraph(repo *git.Repository, storage *MGitStorage, rangeSpec string) (*CommitGraph, error) {
+	refLabels, refTips, err := graphRefs(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	include, excluded, err := commitRange(repo, rangeSpec, refTips)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		return nil, err
+	}
+	mgitHashes := make(map[string]NostrCommitMapping, len(mappings))
+	for _, mapping := range mappings {
+		mgitHashes[mapping.GitHash] = mapping
+	}
+	nodeID := func(gitHash string) string {
+		if mapping, ok := mgitHashes[gitHash]; ok {
+			return mapping.MGitHash
+		}
+		return gitHash
+	}
+
+	graph := &CommitGraph{Nodes: []*GraphNode{}}
+	walkCommits(repo, include, excluded, func(c *object.Commit) {
+		gitHash := c.Hash.String()
+		node := &GraphNode{
+			ID:      nodeID(gitHash),
+			Git:     gitHash,
+			Parents: []string{},
+			Author:  c.Author.Name,
+			Email:   c.Author.Email,
+			Date:    c.Author.When,
+			Subject: strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0],
+			Refs:    refLabels[c.Hash],
+		}
+		if mapping, ok := mgitHashes[gitHash]; ok {
+			node.MGit = mapping.MGitHash
+			node.Pubkey = mapping.Pubkey
+		}
+		for _, parent := range c.ParentHashes {
+			if !excluded[parent] {
+				node.Parents = append(node.Parents, nodeID(parent.String()))
+			}
+		}
+		graph.Nodes = append(graph.Nodes, node)
+	})
+
+	sort.SliceStable(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].Date.After(graph.Nodes[j].Date)
+	})
+	return graph, nil
+}
+
+// commitRange resolves rangeSpec to the commits to walk from and the commits
+// to leave out: a revision alone, "<a>..<b>" for commits reachable from b but
+// not a, or tips when rangeSpec is empty
+func commitRange(repo *git.Repository, rangeSpec string, tips []plumbing.Hash) ([]plumbing.Hash, map[plumbing.Hash]bool, error) {
+	include := tips
+	exclude := []plumbing.Hash{}
+	if rangeSpec != "" {
+		from, to := "", rangeSpec
+		if parts := strings.SplitN(rangeSpec, "..", 2); len(parts) == 2 {
+			from, to = parts[0], parts[1]
+			if to == "" {
+				to = "HEAD"
+			}
+		}
+		storage := NewMGitStorage()
+		toRev, err := Resolve(repo, storage, to)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", to, err)
+		}
+		include = []plumbing.Hash{toRev.Git}
+		if from != "" {
+			fromRev, err := Resolve(repo, storage, from)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", from, err)
+			}
+			exclude = append(exclude, fromRev.Git)
+		}
+	}
+
+	excluded := map[plumbing.Hash]bool{}
+	walkCommits(repo, exclude, nil, func(c *object.Commit) { excluded[c.Hash] = true })
+	return include, excluded, nil
+}
+
+// graphRefs returns the branch and tag names per commit, and the commits
+// they point to
+func graphRefs(repo *git.Repository) (map[plumbing.Hash][]string, []plumbing.Hash, error) {
+	labels := map[plumbing.Hash][]string{}
+	tips := []plumbing.Hash{}
+
+	refs, err := repo.References()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting references: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsBranch() && !ref.Name().IsTag() {
+			return nil
+		}
+		hash := ref.Hash()
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		label := ref.Name().Short()
+		if ref.Name().IsTag() {
+			label = "tag: " + label
+		}
+		labels[hash] = append(labels[hash], label)
+		tips = append(tips, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error processing references: %w", err)
+	}
+	for _, names := range labels {
+		sort.Strings(names)
+	}
+
+	return labels, tips, nil
+}
+
+// walkCommits visits every commit reachable from start once, not descending
+// into commits in stop
+func walkCommits(repo *git.Repository, start []plumbing.Hash, stop map[plumbing.Hash]bool, visit func(*object.Commit)) {
+	seen := map[plumbing.Hash]bool{}
+	queue := append([]plumbing.Hash{}, start...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] || stop[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			// Tags on non-commits, or history cut off by a shallow clone
+			continue
+		}
+		visit(commit)
+		queue = append(queue, commit.ParentHashes...)
+	}
+}
+
+// writeGraphDot writes the graph in Graphviz dot format
+func writeGraphDot(w io.Writer, graph *CommitGraph) {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+	}
+
+	fmt.Fprintln(w, "digraph mgit {")
+	fmt.Fprintln(w, "  rankdir=BT;")
+	fmt.Fprintln(w, "  node [shape=box, fontname=monospace];")
+	for _, node := range graph.Nodes {
+		label := shortHash(node.ID) + " " + node.Subject
+		if node.Pubkey != "" {
+			label += "\n" + node.Pubkey
+		}
+		fmt.Fprintf(w, "  %s [label=%s];\n", quote(node.ID), quote(label))
+		for _, parent := range node.Parents {
+			fmt.Fprintf(w, "  %s -> %s;\n", quote(node.ID), quote(parent))
+		}
+		for _, ref := range node.Refs {
+			refID := "ref:" + ref
+			fmt.Fprintf(w, "  %s [label=%s, shape=ellipse, style=filled, fillcolor=lightyellow];\n", quote(refID), quote(ref))
+			fmt.Fprintf(w, "  %s -> %s [style=dashed];\n", quote(refID), quote(node.ID))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// writeGraphMermaid writes the graph as a Mermaid flowchart
+func writeGraphMermaid(w io.Writer, graph *CommitGraph) {
+	// Mermaid labels cannot contain double quotes
+	text := func(s string) string {
+		return strings.ReplaceAll(s, `"`, "#quot;")
+	}
+	id := func(hash string) string {
+		return "c" + hash
+	}
+
+	fmt.Fprintln(w, "flowchart BT")
+	for i, node := range graph.Nodes {
+		label := shortHash(node.ID) + " " + text(node.Subject)
+		if node.Pubkey != "" {
+			label += "<br/>" + text(node.Pubkey)
+		}
+		fmt.Fprintf(w, "  %s[\"%s\"]\n", id(node.ID), label)
+		for _, parent := range node.Parents {
+			fmt.Fprintf(w, "  %s --> %s\n", id(node.ID), id(parent))
+		}
+		for j, ref := range node.Refs {
+			refID := fmt.Sprintf("r%d_%d", i, j)
+			fmt.Fprintf(w, "  %s([\"%s\"]) -.-> %s\n", refID, text(ref), id(node.ID))
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const grepUsage = "Usage: mgit grep [-n] [-i] [--rev <ref>] <pattern> [--] [<path>...]"
+
+// binarySniffLen is how much of a file is checked for NUL bytes to decide it
+// is binary, as git does
+const binarySniffLen = 8000
+
+// GrepOptions controls what grep searches and how it prints matches
+type GrepOptions struct {
+	LineNumbers bool
+	IgnoreCase  bool
+	Rev         string   // Search this commit's tree instead of the worktree
+	Paths       []string // Only search under these paths
+}
+
+// HandleGrep handles the grep command
+func HandleGrep(args []string) {
+	opts := &GrepOptions{}
+	pattern := ""
+	patternSet := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-n" || arg == "--line-number":
+			opts.LineNumbers = true
+		case arg == "-i" || arg == "--ignore-case":
+			opts.IgnoreCase = true
+		case arg == "--rev" && i+1 < len(args):
+			i++
+			opts.Rev = args[i]
+		case strings.HasPrefix(arg, "--rev="):
+			opts.Rev = strings.TrimPrefix(arg, "--rev=")
+		case arg == "--":
+			opts.Paths = append(opts.Paths, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			fmt.Println(grepUsage)
+			os.Exit(1)
+		case !patternSet:
+			pattern = arg
+			patternSet = true
+		default:
+			opts.Paths = append(opts.Paths, arg)
+		}
+	}
+	if !patternSet {
+		fmt.Println(grepUsage)
+		os.Exit(1)
+	}
+
+	if opts.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Printf("Error: invalid pattern: %s\n", err)
+		os.Exit(1)
+	}
+
+	found, err := grepRepo(os.Stdout, getRepo(), re, opts)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if !found {
+		os.Exit(1)
+	}
+}
+
+// grepRepo writes the lines matching re in the tracked files of the worktree,
+// or of opts.Rev's tree, as git grep does. It reports whether anything
+// matched.
+func grepRepo(w io.Writer, repo *git.Repository, re *regexp.Regexp, opts *GrepOptions) (bool, error) {
+	found := false
+	if opts.Rev != "" {
+		revision, err := Resolve(repo, NewMGitStorage(), opts.Rev)
+		if err != nil {
+			return false, err
+		}
+		tree, err := commitTree(repo, revision.Git)
+		if err != nil {
+			return false, err
+		}
+		err = tree.Files().ForEach(func(file *object.File) error {
+			if !grepPathMatches(file.Name, opts.Paths) {
+				return nil
+			}
+			reader, err := file.Reader()
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", file.Name, err)
+			}
+			defer reader.Close()
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", file.Name, err)
+			}
+			if grepContent(w, opts.Rev+":"+file.Name, content, re, opts) {
+				found = true
+			}
+			return nil
+		})
+		return found, err
+	}
+
+	// The worktree's tracked files are the index entries, read from disk
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false, fmt.Errorf("error getting worktree: %w", err)
+	}
+	index, err := repo.Storer.Index()
+	if err != nil {
+		return false, fmt.Errorf("error reading index: %w", err)
+	}
+	// Conflicted files have an entry per stage
+	names := []string{}
+	for _, entry := range index.Entries {
+		if grepPathMatches(entry.Name, opts.Paths) {
+			names = appendUnique(names, entry.Name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file, err := worktree.Filesystem.Open(name)
+		if os.IsNotExist(err) {
+			// Deleted but not yet staged
+			continue
+		}
+		if err != nil {
+			return found, fmt.Errorf("error opening %s: %w", name, err)
+		}
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return found, fmt.Errorf("error reading %s: %w", name, err)
+		}
+		if grepContent(w, name, content, re, opts) {
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// grepContent writes the lines of one file that match re, prefixed by label,
+// or a single notice for a binary file. It reports whether anything matched.
+func grepContent(w io.Writer, label string, content []byte, re *regexp.Regexp, opts *GrepOptions) bool {
+	if bytes.IndexByte(content[:min(len(content), binarySniffLen)], 0) != -1 {
+		if re.Match(content) {
+			fmt.Fprintf(w, "Binary file %s matches\n", label)
+			return true
+		}
+		return false
+	}
+
+	found := false
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	for i, line := range lines {
+		if !re.MatchString(line) {
+			continue
+		}
+		found = true
+		if opts.LineNumbers {
+			fmt.Fprintf(w, "%s:%d:%s\n", label, i+1, line)
+		} else {
+			fmt.Fprintf(w, "%s:%s\n", label, line)
+		}
+	}
+	return found
+}
+
+// grepPathMatches reports whether name is one of paths or under one of them;
+// no paths matches everything
+func grepPathMatches(name string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, path := range paths {
+		path = strings.TrimSuffix(strings.TrimPrefix(path, "./"), "/")
+		if path == "" || path == "." || name == path || strings.HasPrefix(name, path+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,412 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// Legal holds are signed nostr events kept in .mgit/holds. A hold on a ref
+// protects the commit it pointed at and all of its history; a hold on a path
+// protects every commit that changed that path. Releasing a hold writes a
+// second signed event next to it instead of deleting anything, so the record
+// of the hold survives. Commands that drop history must check heldHistory
+// first.
+
+// NostrKindLegalHold is an application-specific (NIP-78) event used for hold
+// and release markers
+const NostrKindLegalHold = 30078
+
+// LegalHold is a parsed hold marker
+type LegalHold struct {
+	Event    *nostr.Event
+	Path     string // Held path, or ""
+	Ref      string // Held ref as given, or ""
+	Commit   string // MGit hash the ref pointed at when the hold was set
+	Reason   string
+	Released *nostr.Event // Release marker, if the hold was lifted
+	Valid    bool         // Whether the marker's signature checks out
+}
+
+// ID returns the hold's event ID
+func (h *LegalHold) ID() string {
+	return h.Event.ID
+}
+
+// Active reports whether the hold is in force
+func (h *LegalHold) Active() bool {
+	return h.Released == nil
+}
+
+const holdUsage = "Usage: mgit hold set <path|ref> [--reason <text>] | list [--all] | release <hold-id>"
+
+// HandleHold handles the hold command
+func HandleHold(args []string) {
+	if len(args) == 0 {
+		fmt.Println(holdUsage)
+		os.Exit(1)
+	}
+
+	session := currentSession()
+	storage := session.Storage()
+
+	switch args[0] {
+	case "set":
+		target, reason := "", ""
+		for i := 1; i < len(args); i++ {
+			switch {
+			case args[i] == "--reason" && i+1 < len(args):
+				reason = args[i+1]
+				i++
+			case strings.HasPrefix(args[i], "-") || target != "":
+				fmt.Println(holdUsage)
+				os.Exit(1)
+			default:
+				target = args[i]
+			}
+		}
+		if target == "" {
+			fmt.Println(holdUsage)
+			os.Exit(1)
+		}
+		hold, err := setLegalHold(session.MustRepo(), storage, target, reason)
+		if err != nil {
+			fmt.Printf("Error setting legal hold: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Legal hold %s set on %s\n", shortHash(hold.ID()), hold.describe())
+
+	case "list":
+		all := len(args) == 2 && args[1] == "--all"
+		if len(args) > 1 && !all {
+			fmt.Println(holdUsage)
+			os.Exit(1)
+		}
+		holds, err := loadLegalHolds(storage)
+		if err != nil {
+			fmt.Printf("Error reading legal holds: %s\n", err)
+			os.Exit(1)
+		}
+		shown := 0
+		for _, hold := range holds {
+			if !hold.Active() && !all {
+				continue
+			}
+			shown++
+			status := "active"
+			if !hold.Active() {
+				status = "released " + time.Unix(hold.Released.CreatedAt, 0).Format("2006-01-02")
+			}
+			if !hold.Valid {
+				status += ", INVALID SIGNATURE"
+			}
+			fmt.Printf("%s  %s  (%s)\n", shortHash(hold.ID()), hold.describe(), status)
+			fmt.Printf("         set %s by %s\n",
+				time.Unix(hold.Event.CreatedAt, 0).Format("Mon Jan 2 15:04:05 2006 -0700"), hold.Event.Pubkey)
+			if hold.Reason != "" {
+				fmt.Printf("         %s\n", hold.Reason)
+			}
+		}
+		if shown == 0 {
+			fmt.Println("No active legal holds")
+		}
+
+	case "release":
+		if len(args) != 2 {
+			fmt.Println(holdUsage)
+			os.Exit(1)
+		}
+		hold, err := releaseLegalHold(storage, args[1])
+		if err != nil {
+			fmt.Printf("Error releasing legal hold: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Legal hold %s on %s released\n", shortHash(hold.ID()), hold.describe())
+
+	default:
+		fmt.Println(holdUsage)
+		os.Exit(1)
+	}
+}
+
+// describe returns what the hold covers
+func (h *LegalHold) describe() string {
+	if h.Path != "" {
+		return "path " + h.Path
+	}
+	return fmt.Sprintf("%s (%s)", h.Ref, shortHash(h.Commit))
+}
+
+func (s *MGitStorage) holdsDir() string {
+	return filepath.Join(s.RootDir, "holds")
+}
+
+// setLegalHold signs and stores a hold on a ref or path. Anything that
+// resolves to a commit is taken as a ref; otherwise target must be a path in
+// HEAD or the working tree.
+func setLegalHold(repo *git.Repository, storage *MGitStorage, target, reason string) (*LegalHold, error) {
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Kind 30078 events are addressed by their "d" tag, so give each hold its
+	// own rather than letting a later hold on the same target replace it
+	tags := [][]string{
+		{"d", fmt.Sprintf("mgit-hold:%s:%d", target, time.Now().UnixNano())},
+		{"t", "legal-hold"},
+	}
+	if mgitHash, err := resolveMGitCommit(repo, storage, target); err == nil {
+		tags = append(tags, []string{"ref", target}, []string{"commit", mgitHash})
+	} else {
+		heldPath := path.Clean(filepath.ToSlash(target))
+		if strings.HasPrefix(heldPath, "../") || path.IsAbs(heldPath) {
+			return nil, fmt.Errorf("%s is outside the repository", target)
+		}
+		if !pathInHead(repo, heldPath) {
+			if _, statErr := os.Stat(target); statErr != nil {
+				return nil, fmt.Errorf("%s is neither a ref nor a path in the repository", target)
+			}
+		}
+		tags = append(tags, []string{"path", heldPath})
+	}
+
+	event := nostr.NewEvent(NostrKindLegalHold, tags, reason)
+	if err := event.Sign(secret); err != nil {
+		return nil, fmt.Errorf("error signing hold: %w", err)
+	}
+	if err := writeHoldEvent(filepath.Join(storage.holdsDir(), event.ID+".json"), event); err != nil {
+		return nil, err
+	}
+	return parseLegalHold(event), nil
+}
+
+// releaseLegalHold records a signed release of the hold whose ID starts with
+// idPrefix
+func releaseLegalHold(storage *MGitStorage, idPrefix string) (*LegalHold, error) {
+	holds, err := loadLegalHolds(storage)
+	if err != nil {
+		return nil, err
+	}
+	var match *LegalHold
+	for _, hold := range holds {
+		if strings.HasPrefix(hold.ID(), idPrefix) {
+			if match != nil {
+				return nil, fmt.Errorf("ambiguous hold ID %s", idPrefix)
+			}
+			match = hold
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no legal hold %s", idPrefix)
+	}
+	if !match.Active() {
+		return nil, fmt.Errorf("legal hold %s is already released", shortHash(match.ID()))
+	}
+
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	event := nostr.NewEvent(NostrKindLegalHold, [][]string{
+		{"d", "mgit-hold-release:" + match.ID()},
+		{"t", "legal-hold-release"},
+		{"e", match.ID()},
+	}, "")
+	if err := event.Sign(secret); err != nil {
+		return nil, fmt.Errorf("error signing release: %w", err)
+	}
+	if err := writeHoldEvent(filepath.Join(storage.holdsDir(), "released", match.ID()+".json"), event); err != nil {
+		return nil, err
+	}
+	match.Released = event
+	return match, nil
+}
+
+func writeHoldEvent(file string, event *nostr.Event) error {
+	data, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding hold marker: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("error creating holds directory: %w", err)
+	}
+	if err := fsutil.WriteFile(file, data); err != nil {
+		return fmt.Errorf("error writing hold marker: %w", err)
+	}
+	return nil
+}
+
+// loadLegalHolds reads every hold marker, oldest first. A marker whose
+// signature does not verify is still returned (and still protects history),
+// flagged as invalid.
+func loadLegalHolds(storage *MGitStorage) ([]*LegalHold, error) {
+	entries, err := os.ReadDir(storage.holdsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading holds directory: %w", err)
+	}
+
+	holds := []*LegalHold{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		event, err := readHoldEvent(filepath.Join(storage.holdsDir(), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		hold := parseLegalHold(event)
+
+		releasePath := filepath.Join(storage.holdsDir(), "released", entry.Name())
+		if release, err := readHoldEvent(releasePath); err == nil {
+			// A release only counts if it is genuine and names this hold
+			if release.Verify() == nil && eventTag(release, "e") == hold.ID() {
+				hold.Released = release
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+
+	sort.SliceStable(holds, func(i, j int) bool {
+		return holds[i].Event.CreatedAt < holds[j].Event.CreatedAt
+	})
+	return holds, nil
+}
+
+func readHoldEvent(file string) (*nostr.Event, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("error parsing hold marker %s: %w", filepath.Base(file), err)
+	}
+	return &event, nil
+}
+
+func parseLegalHold(event *nostr.Event) *LegalHold {
+	return &LegalHold{
+		Event:  event,
+		Path:   eventTag(event, "path"),
+		Ref:    eventTag(event, "ref"),
+		Commit: eventTag(event, "commit"),
+		Reason: event.Content,
+		Valid:  event.Verify() == nil,
+	}
+}
+
+// eventTag returns the first value of the named tag, or ""
+func eventTag(event *nostr.Event, name string) string {
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// pathInHead reports whether a path exists in the HEAD commit
+func pathInHead(repo *git.Repository, file string) bool {
+	head, err := repo.Head()
+	if err != nil {
+		return false
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return false
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return false
+	}
+	_, err = tree.FindEntry(file)
+	return err == nil
+}
+
+// heldHistory returns the MGit hashes of every commit under an active legal
+// hold, with the holds that cover each one
+func heldHistory(repo *git.Repository, storage *MGitStorage) (map[string][]*LegalHold, error) {
+	holds, err := loadLegalHolds(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	held := map[string][]*LegalHold{}
+	paths := []*LegalHold{}
+	for _, hold := range holds {
+		if !hold.Active() {
+			continue
+		}
+		if hold.Path != "" {
+			paths = append(paths, hold)
+			continue
+		}
+
+		// The held commit and its whole MGit ancestry
+		queue := []string{hold.Commit}
+		seen := map[string]bool{}
+		for len(queue) > 0 {
+			hash := queue[0]
+			queue = queue[1:]
+			if hash == "" || seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			held[hash] = append(held[hash], hold)
+			if commit, err := storage.GetCommit(hash); err == nil {
+				queue = append(queue, commit.ParentHashes...)
+			}
+		}
+	}
+
+	if len(paths) > 0 {
+		tips := []plumbing.Hash{}
+		if head, err := repo.Head(); err == nil {
+			tips = append(tips, head.Hash())
+		}
+		_, refTips, err := graphRefs(repo)
+		if err != nil {
+			return nil, err
+		}
+		tips = append(tips, refTips...)
+		// Commits no ref reaches any more, which gc would otherwise prune
+		mappings, err := storage.GetMappings()
+		if err != nil {
+			return nil, err
+		}
+		for _, mapping := range mappings {
+			tips = append(tips, plumbing.NewHash(mapping.GitHash))
+		}
+
+		walkCommits(repo, tips, nil, func(c *object.Commit) {
+			mgitHash := GetMGitHashForCommit(c.Hash)
+			if mgitHash == "" {
+				return
+			}
+			for _, hold := range paths {
+				if commitTouchesScope(c, []string{hold.Path}) {
+					held[mgitHash] = append(held[mgitHash], hold)
+				}
+			}
+		})
+	}
+
+	return held, nil
+}
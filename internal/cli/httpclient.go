@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests to mgit servers, and the git commands mgit runs against them,
+// follow the http settings in config, named as git names them:
+//
+//	[http]
+//		proxy = http://proxy.example:3128
+//		timeout = 30s
+//		sslCAInfo = /etc/ssl/corp-ca.pem
+//		sslCert = ~/.mgitconfig/client.crt
+//		sslKey = ~/.mgitconfig/client.key
+//		sslVerify = false
+//
+// Without http.proxy the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment
+// variables apply. http.timeout bounds connecting and waiting for a server
+// to start answering (default 30s, 0 for no limit); a download or event
+// stream that has started is not cut off. A server profile's caFile,
+// certFile, keyFile and insecure take the place of the ssl settings for its
+// URLs. git gets the same settings as -c options; as it has no connect
+// timeout, a transfer that stalls for http.timeout is aborted instead.
+
+// defaultHTTPTimeout bounds connecting and waiting for a response; override
+// with http.timeout
+const defaultHTTPTimeout = "30s"
+
+// httpTimeoutWarning reports an invalid http.timeout once per command
+var httpTimeoutWarning sync.Once
+
+// HTTPSettings are the settings for requests to one URL
+type HTTPSettings struct {
+	Proxy    string
+	Timeout  time.Duration // 0 for no limit
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+	From     string // Where the TLS settings come from, for errors
+}
+
+// httpSettings returns the settings for requests to url: the http config,
+// with its server profile's TLS settings in place of the ssl ones
+func httpSettings(url string) *HTTPSettings {
+	settings := &HTTPSettings{
+		Proxy:    GetConfigValue("http.proxy", ""),
+		CAFile:   GetConfigValue("http.sslCAInfo", ""),
+		CertFile: GetConfigValue("http.sslCert", ""),
+		KeyFile:  GetConfigValue("http.sslKey", ""),
+		Insecure: !GetConfigBool("http.sslVerify", true),
+		From:     "http config",
+	}
+	if settings.KeyFile == "" {
+		settings.KeyFile = settings.CertFile
+	}
+	timeout, err := parseHTTPTimeout(GetConfigValue("http.timeout", defaultHTTPTimeout))
+	if err != nil {
+		httpTimeoutWarning.Do(func() { fmt.Printf("Warning: %s; using %s\n", err, defaultHTTPTimeout) })
+		timeout, _ = parseHTTPTimeout(defaultHTTPTimeout)
+	}
+	settings.Timeout = timeout
+
+	if server := serverForURL(url); server != nil && (server.CAFile != "" || server.CertFile != "" || server.Insecure) {
+		settings.CAFile = server.CAFile
+		settings.CertFile = server.CertFile
+		settings.KeyFile = server.KeyFile
+		settings.Insecure = server.Insecure
+		settings.From = fmt.Sprintf("server '%s'", server.Name)
+	}
+	return settings
+}
+
+// parseHTTPTimeout reads a duration such as 30s or 2m; a bare number is
+// seconds
+func parseHTTPTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid http.timeout '%s'", value)
+	}
+	return timeout, nil
+}
+
+// tlsConfig returns the TLS settings for requests, or nil when there are
+// none beyond the defaults
+func (h *HTTPSettings) tlsConfig() (*tls.Config, error) {
+	if h.CAFile == "" && h.CertFile == "" && !h.Insecure {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: h.Insecure}
+	if h.CAFile != "" {
+		pem, err := os.ReadFile(expandHome(h.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file from %s: %w", h.From, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA file %s from %s", h.CAFile, h.From)
+		}
+		config.RootCAs = pool
+	}
+	if h.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(expandHome(h.CertFile), expandHome(h.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate from %s: %w", h.From, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// serverHTTPClient returns an HTTP client for requests to url that follows
+// the http settings. timeout caps a whole request, body included; 0 means
+// none.
+func serverHTTPClient(url string, timeout time.Duration) *http.Client {
+	// In the browser the request goes through fetch, which brings its own
+	// proxy, TLS and connection settings
+	if wasmBuild {
+		return &http.Client{Timeout: timeout}
+	}
+	settings := httpSettings(url)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if settings.Proxy != "" {
+		proxy, err := parseProxyURL(settings.Proxy)
+		if err != nil {
+			fmt.Printf("Warning: %s\n", err)
+		} else {
+			transport.Proxy = http.ProxyURL(proxy)
+		}
+	}
+	if settings.Timeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: settings.Timeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = settings.Timeout
+		transport.ResponseHeaderTimeout = settings.Timeout
+	}
+	config, err := settings.tlsConfig()
+	if err != nil {
+		fmt.Printf("Warning: %s\n", err)
+	} else if config != nil {
+		transport.TLSClientConfig = config
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// parseProxyURL reads http.proxy; a bare host:port is an http proxy, as
+// for git
+func parseProxyURL(value string) (*url.URL, error) {
+	proxy, err := url.Parse(value)
+	if err != nil || proxy.Scheme == "" || proxy.Host == "" {
+		proxy, err = url.Parse("http://" + value)
+	}
+	if err != nil || proxy.Host == "" {
+		return nil, fmt.Errorf("invalid http.proxy '%s'", value)
+	}
+	return proxy, nil
+}
+
+// serverGitArgs returns the git -c options that apply the http settings for
+// url to git
+func serverGitArgs(url string) []string {
+	settings := httpSettings(url)
+	args := []string{}
+	if settings.Proxy != "" {
+		args = append(args, "-c", "http.proxy="+settings.Proxy)
+	}
+	if settings.Timeout > 0 {
+		seconds := int(math.Ceil(settings.Timeout.Seconds()))
+		args = append(args, "-c", "http.lowSpeedLimit=1", "-c", "http.lowSpeedTime="+strconv.Itoa(seconds))
+	}
+	if settings.CAFile != "" {
+		args = append(args, "-c", "http.sslCAInfo="+expandHome(settings.CAFile))
+	}
+	if settings.CertFile != "" {
+		args = append(args, "-c", "http.sslCert="+expandHome(settings.CertFile),
+			"-c", "http.sslKey="+expandHome(settings.KeyFile))
+	}
+	if settings.Insecure {
+		args = append(args, "-c", "http.sslVerify=false")
+	}
+	return args
+}
@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// An identity map canonicalizes the names and emails commits were made under
+// and ties them to nostr pubkeys. It is read from the repository's .mailmap
+// and then .mgit/identitymap, which wins where both match. Both use git's
+// mailmap lines, optionally led by the pubkey:
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+//	npub1... Proper Name <proper@email> <commit@email>
+//	npub1... <commit@email>
+//
+// Emails and commit names match case-insensitively. Where several entries
+// match, later ones and ones naming the commit name win, field by field.
+
+// identityMapFile is the MGit-specific identity map inside .mgit
+const identityMapFile = "identitymap"
+
+// Identity is a canonical author identity
+type Identity struct {
+	Name   string
+	Email  string
+	Pubkey string
+}
+
+// identityEntry is one identity map line
+type identityEntry struct {
+	pubkey      string
+	properName  string
+	properEmail string
+	commitName  string // Lowercased; empty matches any name
+	commitEmail string // Lowercased
+}
+
+// IdentityMap maps the identities commits were made under to canonical ones
+type IdentityMap struct {
+	entries []identityEntry
+}
+
+var (
+	identityMapOnce sync.Once
+	identityMap     *IdentityMap
+)
+
+// currentIdentityMap returns the identity map of the repository in the
+// working directory, read once. An unreadable map is reported and ignored.
+func currentIdentityMap() *IdentityMap {
+	identityMapOnce.Do(func() {
+		var err error
+		identityMap, err = LoadIdentityMap(".")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring identity map: %s\n", err)
+			identityMap = &IdentityMap{}
+		}
+	})
+	return identityMap
+}
+
+// LoadIdentityMap reads the .mailmap and .mgit/identitymap of the repository
+// at root, then any extra files, each overriding the ones before
+func LoadIdentityMap(root string, extra ...string) (*IdentityMap, error) {
+	m := &IdentityMap{}
+	paths := append([]string{filepath.Join(root, ".mailmap"), filepath.Join(root, ".mgit", identityMapFile)}, extra...)
+	for i, path := range paths {
+		err := m.load(path)
+		if os.IsNotExist(err) && i < 2 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// load adds the entries in the file at path
+func (m *IdentityMap) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseIdentityLine(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		m.entries = append(m.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// parseIdentityLine parses one identity map line
+func parseIdentityLine(line string) (identityEntry, error) {
+	entry := identityEntry{}
+	line = strings.TrimSpace(line)
+	if fields := strings.Fields(line); len(fields) > 0 && isPubkeyToken(fields[0]) {
+		pubkey, err := NormalizeNostrPubKey(fields[0])
+		if err != nil {
+			return entry, err
+		}
+		entry.pubkey = pubkey
+		line = strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	}
+
+	// The rest alternates names and <emails>: up to two of each
+	names, emails := []string{}, []string{}
+	for line != "" {
+		open := strings.Index(line, "<")
+		if open == -1 {
+			return entry, fmt.Errorf("expected an <email> after \"%s\"", line)
+		}
+		end := strings.Index(line[open:], ">")
+		if end == -1 {
+			return entry, fmt.Errorf("unterminated <email>")
+		}
+		names = append(names, strings.TrimSpace(line[:open]))
+		emails = append(emails, line[open+1:open+end])
+		line = strings.TrimSpace(line[open+end+1:])
+	}
+
+	switch len(emails) {
+	case 1:
+		// Proper Name <commit@email>, or pubkey <commit@email>
+		entry.properName = names[0]
+	case 2:
+		entry.properName = names[0]
+		entry.properEmail = emails[0]
+		entry.commitName = strings.ToLower(names[1])
+	default:
+		return entry, fmt.Errorf("expected one or two <emails>")
+	}
+	entry.commitEmail = strings.ToLower(emails[len(emails)-1])
+	if entry.properName == "" && entry.properEmail == "" && entry.pubkey == "" {
+		return entry, fmt.Errorf("nothing to map <%s> to", entry.commitEmail)
+	}
+	return entry, nil
+}
+
+// isPubkeyToken reports whether a leading field is a nostr pubkey: an npub,
+// or 64 hex digits
+func isPubkeyToken(field string) bool {
+	return strings.HasPrefix(field, "npub1") || len(field) == 64 && isHexString(field)
+}
+
+// HasPubkeys reports whether any entry ties an identity to a pubkey
+func (m *IdentityMap) HasPubkeys() bool {
+	for _, entry := range m.entries {
+		if entry.pubkey != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the canonical identity for a name and email. Parts no
+// matching entry gives keep their original value; Pubkey is empty unless an
+// entry sets one.
+func (m *IdentityMap) Resolve(name, email string) Identity {
+	identity := Identity{Name: name, Email: email}
+	if m == nil {
+		return identity
+	}
+	lowerName, lowerEmail := strings.ToLower(name), strings.ToLower(email)
+
+	// Email-only entries apply first and entries that also name the commit
+	// name after them, each in file order, so later and more specific
+	// entries override the fields they set
+	for _, specific := range []bool{false, true} {
+		for _, entry := range m.entries {
+			if entry.commitEmail != lowerEmail || (entry.commitName != "") != specific {
+				continue
+			}
+			if specific && entry.commitName != lowerName {
+				continue
+			}
+			if entry.properName != "" {
+				identity.Name = entry.properName
+			}
+			if entry.properEmail != "" {
+				identity.Email = entry.properEmail
+			}
+			if entry.pubkey != "" {
+				identity.Pubkey = entry.pubkey
+			}
+		}
+	}
+	return identity
+}
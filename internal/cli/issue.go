@@ -0,0 +1,386 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// Issues live on the nostr relays as NIP-34 issue events (kind 1621)
+// addressed to the repository with ["a", "30617:<owner>:<identifier>"].
+// Comments are NIP-22 comments (kind 1111) on the issue event, and closing
+// or reopening one publishes a NIP-34 status event (kinds 1630-1633). Only
+// status events from the issue's author or the repository's maintainers
+// count; the newest one sets the issue's state.
+
+const issueUsage = `Usage: mgit issue list [--all]
+       mgit issue show <id>
+       mgit issue create -t <title> [-m <body>] [-l <label>]...
+       mgit issue comment <id> -m <text>
+       mgit issue close <id> [-m <reason>]
+       mgit issue reopen <id> [-m <reason>]
+Each takes --repo <naddr> to name another repository and --relay <url> to
+use particular relays.`
+
+// Issue is an issue event with its comments and current state
+type Issue struct {
+	Event    *nostr.Event
+	Subject  string
+	Labels   []string
+	Status   int // Kind of the newest valid status event
+	Comments []*nostr.Event
+}
+
+// statusName returns how a status kind is shown
+func statusName(kind int) string {
+	switch kind {
+	case nostr.KindStatusApplied:
+		return "resolved"
+	case nostr.KindStatusClosed:
+		return "closed"
+	case nostr.KindStatusDraft:
+		return "draft"
+	}
+	return "open"
+}
+
+// HandleIssue handles the issue command
+func HandleIssue(args []string) {
+	if len(args) == 0 {
+		fmt.Println(issueUsage)
+		os.Exit(1)
+	}
+	subcommand := args[0]
+
+	naddr := ""
+	relays := []string{}
+	title, message := "", ""
+	labels := []string{}
+	all := false
+	positional := []string{}
+	for i := 1; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--repo" && i+1 < len(args):
+			i++
+			naddr = args[i]
+		case arg == "--relay" && i+1 < len(args):
+			i++
+			relays = append(relays, args[i])
+		case (arg == "-t" || arg == "--title") && i+1 < len(args):
+			i++
+			title = args[i]
+		case (arg == "-m" || arg == "--message") && i+1 < len(args):
+			i++
+			message = args[i]
+		case (arg == "-l" || arg == "--label") && i+1 < len(args):
+			i++
+			labels = append(labels, args[i])
+		case arg == "--all" || arg == "-a":
+			all = true
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println(issueUsage)
+			os.Exit(1)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	addr, err := repoAddress(NewMGitStorage(), naddr)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	relays = repoRelays(addr, relays)
+	if len(relays) == 0 {
+		fmt.Println("Error: no relays configured (set nostr.relays or use --relay)")
+		os.Exit(1)
+	}
+
+	switch {
+	case subcommand == "list" && len(positional) == 0:
+		err = listIssues(addr, relays, all)
+	case subcommand == "show" && len(positional) == 1:
+		err = showIssue(addr, relays, positional[0])
+	case subcommand == "create" && len(positional) == 0 && title != "":
+		err = createIssue(addr, relays, title, message, labels)
+	case subcommand == "comment" && len(positional) == 1 && message != "":
+		err = commentOnIssue(addr, relays, positional[0], message)
+	case subcommand == "close" && len(positional) == 1:
+		err = setIssueStatus(addr, relays, positional[0], nostr.KindStatusClosed, message)
+	case subcommand == "reopen" && len(positional) == 1:
+		err = setIssueStatus(addr, relays, positional[0], nostr.KindStatusOpen, message)
+	default:
+		fmt.Println(issueUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// fetchIssues queries relays for the repository's issues, their comments and
+// their status events, newest issue first
+func fetchIssues(addr *NostrAddress, relays []string) ([]*Issue, error) {
+	events, err := queryRelays(NostrFilter{
+		"kinds": []int{nostr.KindIssue},
+		"#a":    []string{addr.Coordinate()},
+	}, relays)
+	if err != nil {
+		return nil, err
+	}
+	issues := []*Issue{}
+	byID := map[string]*Issue{}
+	ids := []string{}
+	for _, event := range events {
+		if event.Kind != nostr.KindIssue || eventTag(event, "a") != addr.Coordinate() {
+			continue
+		}
+		issue := &Issue{Event: event, Subject: eventTag(event, "subject"), Status: nostr.KindStatusOpen}
+		for _, tag := range event.Tags {
+			if len(tag) > 1 && tag[0] == "t" {
+				issue.Labels = append(issue.Labels, tag[1])
+			}
+		}
+		issues = append(issues, issue)
+		byID[event.ID] = issue
+		ids = append(ids, event.ID)
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Event.CreatedAt != issues[j].Event.CreatedAt {
+			return issues[i].Event.CreatedAt > issues[j].Event.CreatedAt
+		}
+		return issues[i].Event.ID < issues[j].Event.ID
+	})
+	if len(issues) == 0 {
+		return issues, nil
+	}
+
+	// Status events count from the issue's author and the maintainers
+	maintainers := repoMaintainers(addr, relays)
+	related, err := queryRelays(NostrFilter{
+		"kinds": []int{nostr.KindComment, nostr.KindStatusOpen, nostr.KindStatusApplied, nostr.KindStatusClosed, nostr.KindStatusDraft},
+		"#e":    ids,
+	}, relays)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(related, func(i, j int) bool { return related[i].CreatedAt < related[j].CreatedAt })
+	for _, event := range related {
+		var issue *Issue
+		for _, tag := range event.Tags {
+			if len(tag) > 1 && (tag[0] == "e" || tag[0] == "E") && byID[tag[1]] != nil {
+				issue = byID[tag[1]]
+				break
+			}
+		}
+		switch {
+		case issue == nil:
+		case event.Kind == nostr.KindComment:
+			issue.Comments = append(issue.Comments, event)
+		case event.Pubkey == issue.Event.Pubkey || maintainers[event.Pubkey]:
+			issue.Status = event.Kind
+		}
+	}
+	return issues, nil
+}
+
+// repoMaintainers returns the hex pubkeys of the repository's owner and the
+// maintainers its announcement names
+func repoMaintainers(addr *NostrAddress, relays []string) map[string]bool {
+	maintainers := map[string]bool{addr.Pubkey: true}
+	if announcement, err := fetchAnnouncement(addr, relays); err == nil {
+		for _, pubkey := range parseAnnouncement(announcement).Maintainers {
+			if hexKey, err := nostr.PubkeyHex(pubkey); err == nil {
+				maintainers[hexKey] = true
+			}
+		}
+	}
+	return maintainers
+}
+
+// findIssue finds the issue whose event ID starts with id
+func findIssue(issues []*Issue, id string) (*Issue, error) {
+	var found *Issue
+	for _, issue := range issues {
+		if strings.HasPrefix(issue.Event.ID, strings.ToLower(id)) {
+			if found != nil {
+				return nil, fmt.Errorf("issue id %s is ambiguous", id)
+			}
+			found = issue
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no issue %s", id)
+	}
+	return found, nil
+}
+
+// listIssues prints the repository's open issues, or all of them
+func listIssues(addr *NostrAddress, relays []string, all bool) error {
+	issues, err := fetchIssues(addr, relays)
+	if err != nil {
+		return err
+	}
+	shown := 0
+	for _, issue := range issues {
+		if !all && issue.Status != nostr.KindStatusOpen {
+			continue
+		}
+		shown++
+		line := fmt.Sprintf("%s  %-8s %s", shortHash(issue.Event.ID), statusName(issue.Status), orNone(issue.Subject))
+		if len(issue.Labels) > 0 {
+			line += fmt.Sprintf(" [%s]", strings.Join(issue.Labels, ", "))
+		}
+		switch len(issue.Comments) {
+		case 0:
+		case 1:
+			line += " (1 comment)"
+		default:
+			line += fmt.Sprintf(" (%d comments)", len(issue.Comments))
+		}
+		fmt.Println(line)
+	}
+	if shown == 0 && all {
+		fmt.Printf("No issues for %s\n", addr.Identifier)
+	} else if shown == 0 {
+		fmt.Printf("No open issues for %s\n", addr.Identifier)
+	}
+	return nil
+}
+
+// showIssue prints an issue and its comments
+func showIssue(addr *NostrAddress, relays []string, id string) error {
+	issues, err := fetchIssues(addr, relays)
+	if err != nil {
+		return err
+	}
+	issue, err := findIssue(issues, id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("issue %s (%s)\n", issue.Event.ID, statusName(issue.Status))
+	fmt.Printf("Title:  %s\n", orNone(issue.Subject))
+	if len(issue.Labels) > 0 {
+		fmt.Printf("Labels: %s\n", strings.Join(issue.Labels, ", "))
+	}
+	printIssueEvent(issue.Event)
+	for _, comment := range issue.Comments {
+		fmt.Println()
+		fmt.Println("comment")
+		printIssueEvent(comment)
+	}
+	return nil
+}
+
+// printIssueEvent prints the author, date and indented text of an issue or
+// comment
+func printIssueEvent(event *nostr.Event) {
+	author, err := nostr.Npub(event.Pubkey)
+	if err != nil {
+		author = event.Pubkey
+	}
+	fmt.Printf("Author: %s\n", author)
+	fmt.Printf("Date:   %s\n\n", time.Unix(event.CreatedAt, 0).Format("Mon Jan 2 15:04:05 2006 -0700"))
+	for _, line := range strings.Split(strings.TrimRight(event.Content, "\n"), "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// createIssue publishes a new issue
+func createIssue(addr *NostrAddress, relays []string, title, body string, labels []string) error {
+	tags := [][]string{{"a", addr.Coordinate()}, {"p", addr.Pubkey}, {"subject", title}}
+	for _, label := range labels {
+		tags = append(tags, []string{"t", label})
+	}
+	event, err := signAndPublish(nostr.NewEvent(nostr.KindIssue, tags, body), relays)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created issue %s: %s\n", shortHash(event.ID), title)
+	return nil
+}
+
+// commentOnIssue publishes a comment on an issue
+func commentOnIssue(addr *NostrAddress, relays []string, id, text string) error {
+	issues, err := fetchIssues(addr, relays)
+	if err != nil {
+		return err
+	}
+	issue, err := findIssue(issues, id)
+	if err != nil {
+		return err
+	}
+	root, author := issue.Event.ID, issue.Event.Pubkey
+	kind := fmt.Sprint(nostr.KindIssue)
+	tags := [][]string{
+		{"E", root, "", author}, {"K", kind}, {"P", author},
+		{"e", root, "", author}, {"k", kind}, {"p", author},
+	}
+	event, err := signAndPublish(nostr.NewEvent(nostr.KindComment, tags, text), relays)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Commented on issue %s (%s)\n", shortHash(issue.Event.ID), shortHash(event.ID))
+	return nil
+}
+
+// setIssueStatus publishes a status event closing or reopening an issue
+func setIssueStatus(addr *NostrAddress, relays []string, id string, kind int, reason string) error {
+	issues, err := fetchIssues(addr, relays)
+	if err != nil {
+		return err
+	}
+	issue, err := findIssue(issues, id)
+	if err != nil {
+		return err
+	}
+	if issue.Status == kind {
+		fmt.Printf("Issue %s is already %s\n", shortHash(issue.Event.ID), statusName(kind))
+		return nil
+	}
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+	signer, err := nostr.PublicKey(secret)
+	if err != nil {
+		return err
+	}
+	if pubkey := hex.EncodeToString(signer); pubkey != issue.Event.Pubkey && !repoMaintainers(addr, relays)[pubkey] {
+		return fmt.Errorf("only the issue's author and the repository's maintainers can change its status")
+	}
+
+	tags := [][]string{
+		{"e", issue.Event.ID, "", "root"},
+		{"p", issue.Event.Pubkey},
+		{"p", addr.Pubkey},
+		{"a", addr.Coordinate()},
+	}
+	if _, err := signAndPublish(nostr.NewEvent(kind, tags, reason), relays); err != nil {
+		return err
+	}
+	fmt.Printf("Issue %s is now %s\n", shortHash(issue.Event.ID), statusName(kind))
+	return nil
+}
+
+// signAndPublish signs an event with nostr.secretKey and publishes it,
+// failing if no relay accepts it
+func signAndPublish(event *nostr.Event, relays []string) (*nostr.Event, error) {
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := event.Sign(secret); err != nil {
+		return nil, fmt.Errorf("error signing event: %w", err)
+	}
+	if publishEvent(event, relays) == 0 {
+		return nil, fmt.Errorf("no relay accepted the event")
+	}
+	return event, nil
+}
@@ -0,0 +1,415 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// A JSON Schema checker for validate rules. It covers the keywords record
+// schemas (FHIR's among them) lean on: type, enum, const, $ref to a part of
+// the same schema, properties, required, additionalProperties,
+// patternProperties, items, the size and range limits, pattern, and allOf,
+// anyOf, oneOf and not. Unknown keywords, format among them, are ignored, as
+// the specification allows.
+
+// maxSchemaErrors is how many errors are reported for one file
+const maxSchemaErrors = 20
+
+// JSONSchema is a parsed JSON Schema
+type JSONSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// parseJSONSchema parses a JSON Schema document
+func parseJSONSchema(data []byte) (*JSONSchema, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %w", err)
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, fmt.Errorf("a schema must be an object or a boolean")
+	}
+	return &JSONSchema{root: root, patterns: map[string]*regexp.Regexp{}}, nil
+}
+
+// Validate checks a JSON document against the schema and returns what is
+// wrong with it, each error led by the JSON pointer of the value at fault
+func (s *JSONSchema) Validate(data []byte) []string {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("not valid JSON: %s", err)}
+	}
+	if decoder.More() {
+		return []string{"not valid JSON: more than one value"}
+	}
+	errs := []string{}
+	s.validate(s.root, value, "", &errs, 0)
+	if len(errs) > maxSchemaErrors {
+		errs = append(errs[:maxSchemaErrors], fmt.Sprintf("... and %d more", len(errs)-maxSchemaErrors))
+	}
+	return errs
+}
+
+// validate checks value against schema, adding errors for the value at ptr
+func (s *JSONSchema) validate(schema interface{}, value interface{}, ptr string, errs *[]string, depth int) {
+	fail := func(format string, args ...interface{}) {
+		location := ptr
+		if location == "" {
+			location = "/"
+		}
+		*errs = append(*errs, location+": "+fmt.Sprintf(format, args...))
+	}
+	if depth > 64 {
+		fail("schema nests too deeply (is a $ref circular?)")
+		return
+	}
+
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			fail("no value is allowed here")
+		}
+		return
+	case map[string]interface{}:
+		s.validateObjectSchema(schema, value, ptr, errs, depth, fail)
+	}
+}
+
+// validateObjectSchema applies the keywords of an object schema
+func (s *JSONSchema) validateObjectSchema(schema map[string]interface{}, value interface{}, ptr string, errs *[]string, depth int, fail func(string, ...interface{})) {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := s.resolveRef(ref)
+		if err != nil {
+			fail("%s", err)
+			return
+		}
+		s.validate(target, value, ptr, errs, depth+1)
+	}
+
+	if types, ok := schemaTypes(schema["type"]); ok && !containsJSONType(types, value) {
+		fail("expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+		// The other keywords would only repeat the mismatch
+		return
+	}
+	if options, ok := schema["enum"].([]interface{}); ok && !containsJSONValue(options, value) {
+		fail("%s is not one of the allowed values", jsonPreview(value))
+	}
+	if expected, ok := schema["const"]; ok && !reflect.DeepEqual(expected, value) {
+		fail("expected %s, got %s", jsonPreview(expected), jsonPreview(value))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(schema, value, ptr, errs, depth, fail)
+	case []interface{}:
+		s.validateArray(schema, value, ptr, errs, depth, fail)
+	case string:
+		length := utf8.RuneCountInString(value)
+		if min, ok := schemaNumber(schema["minLength"]); ok && float64(length) < min {
+			fail("string is shorter than %v characters", min)
+		}
+		if max, ok := schemaNumber(schema["maxLength"]); ok && float64(length) > max {
+			fail("string is longer than %v characters", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := s.pattern(pattern)
+			if err != nil {
+				fail("%s", err)
+			} else if !re.MatchString(value) {
+				fail("%s does not match %s", jsonPreview(value), pattern)
+			}
+		}
+	case float64:
+		validateNumber(schema, value, fail)
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			s.validate(sub, value, ptr, errs, depth+1)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if s.matches(sub, value, ptr, depth) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any of the schemas in anyOf")
+		}
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range one {
+			if s.matches(sub, value, ptr, depth) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("matches %d of the schemas in oneOf, not exactly one", matched)
+		}
+	}
+	if not, ok := schema["not"]; ok && s.matches(not, value, ptr, depth) {
+		fail("matches the schema in not")
+	}
+}
+
+// matches reports whether value passes schema, without reporting errors
+func (s *JSONSchema) matches(schema interface{}, value interface{}, ptr string, depth int) bool {
+	errs := []string{}
+	s.validate(schema, value, ptr, &errs, depth+1)
+	return len(errs) == 0
+}
+
+// validateObject applies the keywords for objects
+func (s *JSONSchema) validateObject(schema map[string]interface{}, value map[string]interface{}, ptr string, errs *[]string, depth int, fail func(string, ...interface{})) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := value[name]; !present {
+					fail("missing required property %q", name)
+				}
+			}
+		}
+	}
+	if min, ok := schemaNumber(schema["minProperties"]); ok && float64(len(value)) < min {
+		fail("object has fewer than %v properties", min)
+	}
+	if max, ok := schemaNumber(schema["maxProperties"]); ok && float64(len(value)) > max {
+		fail("object has more than %v properties", max)
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	patternProperties, _ := schema["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := schema["additionalProperties"]
+
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := ptr + "/" + escapeJSONPointer(name)
+		known := false
+		if sub, ok := properties[name]; ok {
+			known = true
+			s.validate(sub, value[name], child, errs, depth+1)
+		}
+		for pattern, sub := range patternProperties {
+			re, err := s.pattern(pattern)
+			if err != nil {
+				fail("%s", err)
+				continue
+			}
+			if re.MatchString(name) {
+				known = true
+				s.validate(sub, value[name], child, errs, depth+1)
+			}
+		}
+		if known || !hasAdditional {
+			continue
+		}
+		if allowed, ok := additional.(bool); ok && !allowed {
+			fail("property %q is not allowed", name)
+			continue
+		}
+		s.validate(additional, value[name], child, errs, depth+1)
+	}
+}
+
+// validateArray applies the keywords for arrays
+func (s *JSONSchema) validateArray(schema map[string]interface{}, value []interface{}, ptr string, errs *[]string, depth int, fail func(string, ...interface{})) {
+	if min, ok := schemaNumber(schema["minItems"]); ok && float64(len(value)) < min {
+		fail("array has fewer than %v items", min)
+	}
+	if max, ok := schemaNumber(schema["maxItems"]); ok && float64(len(value)) > max {
+		fail("array has more than %v items", max)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range value {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(value[i], value[j]) {
+					fail("items %d and %d are the same", j, i)
+				}
+			}
+		}
+	}
+
+	switch items := schema["items"].(type) {
+	case []interface{}:
+		// One schema per position; additionalItems covers the rest
+		for i, item := range value {
+			child := fmt.Sprintf("%s/%d", ptr, i)
+			if i < len(items) {
+				s.validate(items[i], item, child, errs, depth+1)
+			} else if extra, ok := schema["additionalItems"]; ok {
+				s.validate(extra, item, child, errs, depth+1)
+			}
+		}
+	case nil:
+	default:
+		for i, item := range value {
+			s.validate(items, item, fmt.Sprintf("%s/%d", ptr, i), errs, depth+1)
+		}
+	}
+}
+
+// validateNumber checks the range keywords, in both the boolean (draft 4)
+// and numeric forms of exclusiveMinimum and exclusiveMaximum
+func validateNumber(schema map[string]interface{}, value float64, fail func(string, ...interface{})) {
+	exclusiveMin, _ := schema["exclusiveMinimum"].(bool)
+	exclusiveMax, _ := schema["exclusiveMaximum"].(bool)
+	if min, ok := schemaNumber(schema["minimum"]); ok {
+		if value < min || (exclusiveMin && value == min) {
+			fail("%v is less than the minimum %v", value, min)
+		}
+	}
+	if max, ok := schemaNumber(schema["maximum"]); ok {
+		if value > max || (exclusiveMax && value == max) {
+			fail("%v is greater than the maximum %v", value, max)
+		}
+	}
+	if min, ok := schemaNumber(schema["exclusiveMinimum"]); ok && value <= min {
+		fail("%v is not greater than %v", value, min)
+	}
+	if max, ok := schemaNumber(schema["exclusiveMaximum"]); ok && value >= max {
+		fail("%v is not less than %v", value, max)
+	}
+	if step, ok := schemaNumber(schema["multipleOf"]); ok && step > 0 {
+		if quotient := value / step; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			fail("%v is not a multiple of %v", value, step)
+		}
+	}
+}
+
+// resolveRef finds the part of the schema a local $ref ("#/definitions/X",
+// "#/$defs/X" or "#") points to
+func (s *JSONSchema) resolveRef(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("$ref %s: only references within the schema are supported", ref)
+	}
+	target := s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := target.(type) {
+		case map[string]interface{}:
+			next, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("$ref %s does not exist in the schema", ref)
+			}
+			target = next
+		default:
+			return nil, fmt.Errorf("$ref %s does not exist in the schema", ref)
+		}
+	}
+	return target, nil
+}
+
+// pattern compiles a pattern once per schema
+func (s *JSONSchema) pattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := s.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("schema pattern %s: %w", pattern, err)
+	}
+	s.patterns[pattern] = re
+	return re, nil
+}
+
+// schemaTypes reads the type keyword, a name or a list of them
+func schemaTypes(value interface{}) ([]string, bool) {
+	switch value := value.(type) {
+	case string:
+		return []string{value}, true
+	case []interface{}:
+		types := []string{}
+		for _, name := range value {
+			if name, ok := name.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types, len(types) > 0
+	}
+	return nil, false
+}
+
+// schemaNumber reads a numeric keyword
+func schemaNumber(value interface{}) (float64, bool) {
+	number, ok := value.(float64)
+	return number, ok
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// containsJSONType reports whether value has one of the types; an integer
+// is a number too
+func containsJSONType(types []string, value interface{}) bool {
+	actual := jsonType(value)
+	for _, name := range types {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsJSONValue reports whether value equals one of the options
+func containsJSONValue(options []interface{}, value interface{}) bool {
+	for _, option := range options {
+		if reflect.DeepEqual(option, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPreview renders a value for an error message, shortened
+func jsonPreview(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	if preview := []rune(string(data)); len(preview) > 40 {
+		return string(preview[:37]) + "..."
+	}
+	return string(data)
+}
+
+// escapeJSONPointer escapes a property name for a JSON pointer
+func escapeJSONPointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,451 @@
+package cli
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// Keys are kept in ~/.mgitconfig/keys/<name>.json, one per file and readable
+// only by the user. Each holds the public key and the secret key encrypted
+// with a passphrase as a NIP-49 ncryptsec. nostr.key names the key mgit
+// signs with; its passphrase is asked for when a command first needs it, or
+// read from MGIT_KEY_PASSPHRASE.
+
+const (
+	keygenUsage = "Usage: mgit keygen [<name>]"
+	keyUsage    = "Usage: mgit key list | import <name> [<nsec|ncryptsec>] | export <name> [--nsec] | fingerprint <name> | use <name> | remove <name> | rotate <old> <new> [--reason <text>]"
+)
+
+// defaultKeyName names a key when keygen is given none
+const defaultKeyName = "default"
+
+// keyNamePattern is what a key name may contain, as it names a file
+var keyNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// StoredKey is a key in the key store
+type StoredKey struct {
+	Name      string    `json:"name"`
+	Pubkey    string    `json:"pubkey"` // npub
+	Encrypted string    `json:"ncryptsec"`
+	Created   time.Time `json:"created"`
+	// Rotation retired the key in favour of a successor
+	Rotation *KeyRotation `json:"rotation,omitempty"`
+}
+
+// keysDir returns the key store directory
+func keysDir() string {
+	return filepath.Join(filepath.Dir(GetConfigFilePath(true)), "keys")
+}
+
+// keyPath returns the file holding a named key
+func keyPath(name string) (string, error) {
+	if !keyNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid key name '%s' (use letters, digits, '.', '_' and '-')", name)
+	}
+	return filepath.Join(keysDir(), name+".json"), nil
+}
+
+// loadStoredKey reads a named key
+func loadStoredKey(name string) (*StoredKey, error) {
+	path, err := keyPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no key named '%s' (see mgit key list)", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading key '%s': %w", name, err)
+	}
+	key := &StoredKey{}
+	if err := json.Unmarshal(data, key); err != nil {
+		return nil, fmt.Errorf("error parsing key '%s': %w", name, err)
+	}
+	key.Name = name
+	return key, nil
+}
+
+// listStoredKeys reads every key in the store, by name
+func listStoredKeys() ([]*StoredKey, error) {
+	entries, err := os.ReadDir(keysDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading key store: %w", err)
+	}
+	keys := []*StoredKey{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		if entry.IsDir() || name == entry.Name() || !keyNamePattern.MatchString(name) {
+			continue
+		}
+		key, err := loadStoredKey(name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// storeSecretKey encrypts a secret key (asking for a new passphrase) and
+// adds it to the store under name. An ncryptsec already encrypting it can be
+// given instead, and is stored as it is.
+func storeSecretKey(name string, secret []byte, encrypted string) (*StoredKey, error) {
+	path, err := keyPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("a key named '%s' already exists", name)
+	}
+	pubkey, err := nostr.PublicKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	npub, err := nostr.Npub(hex.EncodeToString(pubkey))
+	if err != nil {
+		return nil, err
+	}
+	if encrypted == "" {
+		passphrase, err := readNewPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		if encrypted, err = encryptNcryptsec(secret, passphrase); err != nil {
+			return nil, fmt.Errorf("error encrypting key: %w", err)
+		}
+	}
+
+	key := &StoredKey{Name: name, Pubkey: npub, Encrypted: encrypted, Created: time.Now().UTC()}
+	data, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(keysDir(), 0700); err != nil {
+		return nil, fmt.Errorf("error creating key store: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error saving key: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("error saving key: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("error saving key: %w", err)
+	}
+	return key, nil
+}
+
+// updateStoredKey rewrites a key already in the store
+func updateStoredKey(key *StoredKey) error {
+	path, err := keyPath(key.Name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WritePrivateFile(path, data); err != nil {
+		return fmt.Errorf("error saving key: %w", err)
+	}
+	return nil
+}
+
+// unlockStoredKey asks for a key's passphrase and returns its secret key
+func unlockStoredKey(name string) ([]byte, error) {
+	key, err := loadStoredKey(name)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := readPassphrase(fmt.Sprintf("Passphrase for key '%s': ", name))
+	if err != nil {
+		return nil, err
+	}
+	secret, err := decryptNcryptsec(key.Encrypted, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("key '%s': %w", name, err)
+	}
+	pubkey, err := nostr.PublicKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	if npub, err := nostr.Npub(hex.EncodeToString(pubkey)); err != nil || npub != key.Pubkey {
+		return nil, fmt.Errorf("key '%s' does not match its public key", name)
+	}
+	return secret, nil
+}
+
+// generateSecretKey returns a new random secret key
+func generateSecretKey() ([]byte, error) {
+	for {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		// Almost every 32 bytes is a valid key; the rest are redrawn
+		if _, err := nostr.PublicKey(secret); err == nil {
+			return secret, nil
+		}
+	}
+}
+
+// keyFingerprint returns a short, readable digest of a public key (npub or
+// hex) for comparing keys by eye: the first 8 bytes of its sha256
+func keyFingerprint(pubkey string) string {
+	hexKey, err := nostr.PubkeyHex(pubkey)
+	if err != nil {
+		return "?"
+	}
+	raw, _ := hex.DecodeString(hexKey)
+	sum := sha256.Sum256(raw)
+	digest := strings.ToUpper(hex.EncodeToString(sum[:8]))
+	return fmt.Sprintf("%s %s %s %s", digest[0:4], digest[4:8], digest[8:12], digest[12:16])
+}
+
+// HandleKeygen handles the keygen command
+func HandleKeygen(args []string) {
+	if len(args) > 1 || (len(args) == 1 && strings.HasPrefix(args[0], "-")) {
+		fmt.Println(keygenUsage)
+		os.Exit(1)
+	}
+	name := defaultKeyName
+	if len(args) == 1 {
+		name = args[0]
+	}
+	path, err := keyPath(name)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if fileExists(path) {
+		fmt.Printf("Error: a key named '%s' already exists\n", name)
+		os.Exit(1)
+	}
+
+	secret, err := generateSecretKey()
+	if err != nil {
+		fmt.Printf("Error generating key: %s\n", err)
+		os.Exit(1)
+	}
+	key, err := storeSecretKey(name, secret, "")
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Generated key '%s'\n", name)
+	printStoredKey(key)
+	adoptNewKey(key)
+}
+
+// adoptNewKey makes a new key the signing key and user.pubkey when none are
+// configured yet
+func adoptNewKey(key *StoredKey) {
+	if GetConfigValue("nostr.key", "") == "" && GetConfigValue("nostr.secretKey", "") == "" {
+		if err := SetConfigValue("nostr.key", key.Name, true); err != nil {
+			fmt.Printf("Warning: failed to set nostr.key: %s\n", err)
+		} else {
+			fmt.Printf("Signing with key '%s' (nostr.key)\n", key.Name)
+		}
+	}
+	if GetNostrPubKey() == "" {
+		if err := SetConfigValue("user.pubkey", key.Pubkey, true); err != nil {
+			fmt.Printf("Warning: failed to set user.pubkey: %s\n", err)
+		} else {
+			fmt.Printf("Set user.pubkey to %s\n", key.Pubkey)
+		}
+	}
+}
+
+// printStoredKey prints a key's public key and fingerprint
+func printStoredKey(key *StoredKey) {
+	fmt.Printf("  Public key:  %s\n", key.Pubkey)
+	fmt.Printf("  Fingerprint: %s\n", keyFingerprint(key.Pubkey))
+}
+
+// fileExists reports whether a path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// HandleKey handles the key command
+func HandleKey(args []string) {
+	if len(args) == 0 {
+		fmt.Println(keyUsage)
+		os.Exit(1)
+	}
+	var err error
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		err = listKeys()
+	case args[0] == "import" && (len(args) == 2 || len(args) == 3):
+		value := ""
+		if len(args) == 3 {
+			value = args[2]
+		}
+		err = importKey(args[1], value)
+	case args[0] == "export" && len(args) == 2:
+		err = exportKey(args[1], false)
+	case args[0] == "export" && len(args) == 3 && args[2] == "--nsec":
+		err = exportKey(args[1], true)
+	case args[0] == "fingerprint" && len(args) == 2:
+		var key *StoredKey
+		if key, err = loadStoredKey(args[1]); err == nil {
+			fmt.Println(keyFingerprint(key.Pubkey))
+		}
+	case args[0] == "use" && len(args) == 2:
+		if _, err = loadStoredKey(args[1]); err == nil {
+			if err = SetConfigValue("nostr.key", args[1], true); err == nil {
+				fmt.Printf("Signing with key '%s' (nostr.key)\n", args[1])
+			}
+		}
+	case args[0] == "remove" && len(args) == 2:
+		err = removeKey(args[1])
+	case args[0] == "rotate" && len(args) == 3:
+		err = rotateKey(args[1], args[2], "")
+	case args[0] == "rotate" && len(args) == 5 && args[3] == "--reason":
+		err = rotateKey(args[1], args[2], args[4])
+	default:
+		fmt.Println(keyUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// listKeys prints the stored keys, marking the signing key
+func listKeys() error {
+	keys, err := listStoredKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		fmt.Println("No keys (create one with mgit keygen)")
+		return nil
+	}
+	current := GetConfigValue("nostr.key", "")
+	for _, key := range keys {
+		marker := " "
+		if key.Name == current {
+			marker = "*"
+		}
+		retired := ""
+		if key.Rotation != nil {
+			retired = fmt.Sprintf("  (retired %s)", key.Rotation.When().Format("2006-01-02"))
+		}
+		fmt.Printf("%s %-12s %s  %s%s\n", marker, key.Name, keyFingerprint(key.Pubkey), key.Pubkey, retired)
+	}
+	return nil
+}
+
+// importKey adds an existing key to the store: an nsec or hex secret key,
+// which is encrypted with a new passphrase, or an ncryptsec, whose
+// passphrase is checked. With no value the key is read from stdin.
+func importKey(name, value string) error {
+	if _, err := keyPath(name); err != nil {
+		return err
+	}
+	if value == "" {
+		var err error
+		if value, err = readSecretLine("Secret key (nsec, hex or ncryptsec): "); err != nil {
+			return err
+		}
+	}
+	value = strings.TrimSpace(value)
+
+	var key *StoredKey
+	if strings.HasPrefix(value, "ncryptsec1") {
+		passphrase, err := readPassphrase("Passphrase for the ncryptsec: ")
+		if err != nil {
+			return err
+		}
+		secret, err := decryptNcryptsec(value, passphrase)
+		if err != nil {
+			return err
+		}
+		if key, err = storeSecretKey(name, secret, value); err != nil {
+			return err
+		}
+	} else {
+		secret, err := parseNostrSecretKey(value)
+		if err != nil {
+			return err
+		}
+		if key, err = storeSecretKey(name, secret, ""); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Imported key '%s'\n", name)
+	printStoredKey(key)
+	adoptNewKey(key)
+	return nil
+}
+
+// exportKey prints a key as its ncryptsec, or decrypted as an nsec
+func exportKey(name string, plain bool) error {
+	if !plain {
+		key, err := loadStoredKey(name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(key.Encrypted)
+		return nil
+	}
+	secret, err := unlockStoredKey(name)
+	if err != nil {
+		return err
+	}
+	nsec, err := nostr.Bech32Encode("nsec", secret)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Warning: anyone who sees this nsec can sign as you")
+	fmt.Println(nsec)
+	return nil
+}
+
+// removeKey deletes a key from the store, unsetting nostr.key if it named it
+func removeKey(name string) error {
+	if _, err := loadStoredKey(name); err != nil {
+		return err
+	}
+	path, _ := keyPath(name)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("error removing key: %w", err)
+	}
+	fmt.Printf("Removed key '%s'\n", name)
+	if GetConfigValue("nostr.key", "") == name {
+		err := UpdateConfig(GetConfigFilePath(true), func(config *Config) error {
+			config.Unset("nostr", "key", "")
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Unset nostr.key; choose another with mgit key use <name>")
+	}
+	return nil
+}
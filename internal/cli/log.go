@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How much a command prints is set by a global option before it:
+//
+//	mgit --quiet <command>    errors, warnings and results only: no
+//	                          progress and no steps
+//	mgit --verbose <command>  also each server request, and each commit
+//	                          and ref a clone sets up
+//	mgit --debug <command>    also diagnostics, such as which stored token
+//	                          a server call uses, and the trace below
+//
+// MGIT_TRACE traces the work behind a command - every HTTP request to a
+// server with its answer and time, every MGit hash computed with what it
+// covered, and the diagnostics --debug shows - as GIT_TRACE does for git:
+// 1 or true traces to stderr, and an absolute path appends to that file.
+// --debug traces to stderr unless MGIT_TRACE names a file. Credentials are
+// never traced.
+
+// logLevel is how much a command prints
+type logLevel int
+
+const (
+	logQuiet logLevel = iota
+	logNormal
+	logVerbose
+	logDebug
+)
+
+// verbosity is the level set by --quiet, --verbose or --debug
+var verbosity = logNormal
+
+var (
+	traceOnce sync.Once
+	traceMu   sync.Mutex
+	traceOut  io.Writer // nil when not tracing
+)
+
+// parseGlobalFlags sets the level from the global options at the start of
+// args and returns the rest
+func parseGlobalFlags(args []string) []string {
+	for len(args) > 0 {
+		switch args[0] {
+		case "--quiet", "-q":
+			verbosity = logQuiet
+		case "--verbose":
+			verbosity = logVerbose
+		case "--debug":
+			verbosity = logDebug
+		default:
+			return args
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+// logEnabled reports whether messages of a level are printed
+func logEnabled(level logLevel) bool {
+	return verbosity >= level
+}
+
+// infof prints a step of a command's work, which --quiet leaves out
+func infof(format string, args ...interface{}) {
+	if logEnabled(logNormal) {
+		fmt.Printf(format, args...)
+	}
+}
+
+// verbosef prints detail only --verbose and --debug show
+func verbosef(format string, args ...interface{}) {
+	if logEnabled(logVerbose) {
+		fmt.Printf(format, args...)
+	}
+}
+
+// debugf traces a diagnostic, which --debug shows
+func debugf(format string, args ...interface{}) {
+	tracef("debug", format, args...)
+}
+
+// tracing reports whether a trace is being written
+func tracing() bool {
+	traceOnce.Do(openTrace)
+	return traceOut != nil
+}
+
+// openTrace opens where MGIT_TRACE or --debug sends the trace
+func openTrace() {
+	switch value := os.Getenv("MGIT_TRACE"); strings.ToLower(value) {
+	case "", "0", "false", "off", "no":
+	case "1", "2", "true", "on", "yes":
+		traceOut = os.Stderr
+	default:
+		if !filepath.IsAbs(value) {
+			fmt.Fprintf(os.Stderr, "Warning: MGIT_TRACE must be 1, true or an absolute path, not '%s'\n", value)
+			break
+		}
+		file, err := os.OpenFile(value, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot open the MGIT_TRACE file: %s\n", err)
+			break
+		}
+		traceOut = file
+	}
+	if traceOut == nil && logEnabled(logDebug) {
+		traceOut = os.Stderr
+	}
+}
+
+// tracef writes a line of the trace, under a category such as http or
+// hash
+func tracef(category, format string, args ...interface{}) {
+	if !tracing() {
+		return
+	}
+	line := fmt.Sprintf("%s %s: %s\n", time.Now().Format("15:04:05.000000"), category, fmt.Sprintf(format, args...))
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	io.WriteString(traceOut, line)
+}
@@ -0,0 +1,274 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// mgit login gets a token for a repository from its server and saves it in
+// the token store (~/.mgitconfig/tokens.enc), where the token-store auth
+// provider finds it. By default it runs a device authorization flow (RFC
+// 8628):
+//
+//	POST /api/mgit/auth/device        {"repo_id"} -> device_code, user_code, verification_uri, interval
+//	POST /api/mgit/auth/device/token  {"device_code"} -> token, or error authorization_pending,
+//	                                  slow_down, access_denied or expired_token
+//
+// so the user approves the login in a browser where they are signed in.
+// With --nostr it instead answers a challenge with the nostr signing key:
+//
+//	GET  /api/mgit/auth/challenge?repo_id=<id> -> challenge
+//	POST /api/mgit/auth/nostr         {"repo_id", "event"} -> token
+//
+// where the event is a NIP-98 HTTP auth event for that POST carrying the
+// challenge in a "challenge" tag.
+
+const loginUsage = "Usage: mgit login [--nostr] <url>"
+
+// DeviceAuthorization is the server's answer to a device login request
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"` // Seconds
+	Interval                int    `json:"interval"`   // Seconds between polls
+}
+
+// LoginResult is the server's answer to a token request
+type LoginResult struct {
+	Token            string `json:"token"`
+	Access           string `json:"access"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// reason returns why the server refused a token request
+func (r *LoginResult) reason() string {
+	if r.ErrorDescription != "" {
+		return r.ErrorDescription
+	}
+	return r.Error
+}
+
+// HandleLogin handles the login command
+func HandleLogin(args []string) {
+	useNostr := false
+	repoURL := ""
+	for _, arg := range args {
+		switch {
+		case arg == "--nostr":
+			useNostr = true
+		case repoURL == "" && !strings.HasPrefix(arg, "-"):
+			repoURL = strings.TrimSuffix(arg, "/")
+		default:
+			fmt.Println(loginUsage)
+			os.Exit(1)
+		}
+	}
+	if repoURL == "" {
+		fmt.Println(loginUsage)
+		os.Exit(1)
+	}
+	if !isServerURL(repoURL) {
+		fmt.Printf("Error: %s is not an mgit server URL\n", repoURL)
+		os.Exit(1)
+	}
+
+	base := extractServerBaseURL(repoURL)
+	repoID := extractRepoID(repoURL)
+	var result *LoginResult
+	var err error
+	if useNostr {
+		result, err = nostrLogin(base, repoID)
+	} else {
+		result, err = deviceLogin(base, repoID)
+	}
+	if err != nil {
+		fmt.Printf("Error logging in: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := saveToken(repoURL, result); err != nil {
+		fmt.Printf("Error saving token: %s\n", err)
+		os.Exit(1)
+	}
+	if result.Access != "" {
+		fmt.Printf("Logged in to %s with %s access\n", repoURL, result.Access)
+	} else {
+		fmt.Printf("Logged in to %s\n", repoURL)
+	}
+	infof("Token saved to %s\n", getTokenConfigPath())
+}
+
+// loginRequest sends a login request, with body as JSON unless it is nil and
+// with auth unless it is nil, and decodes any JSON response into result
+// whatever its status
+func loginRequest(method, url string, auth *Credentials, body interface{}, result interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("error encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth != nil {
+		auth.setHeader(req)
+	}
+
+	client := serverHTTPClient(url, 30*time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("error reading response: %w", err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return resp.StatusCode, nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return resp.StatusCode, fmt.Errorf("error response from server (%d): %s", resp.StatusCode, bytes.TrimSpace(data))
+		}
+		return resp.StatusCode, fmt.Errorf("error parsing response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// deviceLogin has the user approve the login in a browser and waits for the
+// server to hand out the token
+func deviceLogin(base, repoID string) (*LoginResult, error) {
+	device := &DeviceAuthorization{}
+	status, err := loginRequest("POST", base+"/api/mgit/auth/device", nil, map[string]string{"repo_id": repoID}, device)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || device.DeviceCode == "" {
+		return nil, fmt.Errorf("the server does not support device login (try --nostr)")
+	}
+
+	if device.VerificationURIComplete != "" {
+		fmt.Printf("To log in, open %s\n", device.VerificationURIComplete)
+		fmt.Printf("and check that it shows the code %s\n", device.UserCode)
+	} else {
+		fmt.Printf("To log in, open %s\n", device.VerificationURI)
+		fmt.Printf("and enter the code %s\n", device.UserCode)
+	}
+	infof("Waiting for approval...\n")
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiresIn := time.Duration(device.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 15 * time.Minute
+	}
+	deadline := time.Now().Add(expiresIn)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		result := &LoginResult{}
+		status, err := loginRequest("POST", base+"/api/mgit/auth/device/token", nil, map[string]string{"device_code": device.DeviceCode}, result)
+		if err != nil {
+			return nil, err
+		}
+		switch result.Error {
+		case "":
+			if status == http.StatusOK && result.Token != "" {
+				return result, nil
+			}
+			return nil, fmt.Errorf("the server returned no token (status %d)", status)
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return nil, fmt.Errorf("the login was denied")
+		case "expired_token":
+			return nil, fmt.Errorf("the code expired before the login was approved")
+		default:
+			return nil, fmt.Errorf("%s", result.reason())
+		}
+	}
+	return nil, fmt.Errorf("the code expired before the login was approved")
+}
+
+// nostrLogin signs the server's challenge with the nostr signing key
+func nostrLogin(base, repoID string) (*LoginResult, error) {
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge struct {
+		Challenge string `json:"challenge"`
+	}
+	challengeURL := base + "/api/mgit/auth/challenge?repo_id=" + url.QueryEscape(repoID)
+	status, err := loginRequest("GET", challengeURL, nil, nil, &challenge)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || challenge.Challenge == "" {
+		return nil, fmt.Errorf("the server does not support nostr login")
+	}
+
+	verifyURL := base + "/api/mgit/auth/nostr"
+	event := nostr.NewEvent(nostr.KindHTTPAuth, [][]string{
+		{"u", verifyURL},
+		{"method", "POST"},
+		{"challenge", challenge.Challenge},
+	}, "")
+	if err := event.Sign(secret); err != nil {
+		return nil, fmt.Errorf("error signing challenge: %w", err)
+	}
+
+	result := &LoginResult{}
+	status, err = loginRequest("POST", verifyURL, nil, map[string]interface{}{"repo_id": repoID, "event": event}, result)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || result.Token == "" {
+		if reason := result.reason(); reason != "" {
+			return nil, fmt.Errorf("the server refused: %s", reason)
+		}
+		return nil, fmt.Errorf("the server refused the signed challenge (status %d)", status)
+	}
+	return result, nil
+}
+
+// saveToken stores a repository's token, replacing any it had
+func saveToken(repoURL string, result *LoginResult) error {
+	store, err := loadTokenStore()
+	if err != nil {
+		return err
+	}
+	tokens := []AuthToken{{Token: result.Token, RepoURL: repoURL, Access: result.Access, RefreshToken: result.RefreshToken}}
+	for _, token := range store.Tokens {
+		if token.Server != "" || !sameRepoURL(token.RepoURL, repoURL) {
+			tokens = append(tokens, token)
+		}
+	}
+	store.Tokens = tokens
+	return store.save()
+}
@@ -0,0 +1,987 @@
+// Package cli is the mgit command: it parses the command line, runs the
+// command and reports errors the way git does, exiting on failure. The
+// commands are built on pkg/mgitlib, pkg/mapping and pkg/server-client.
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+func Main() {
+	// The js/wasm build serves its API to the page instead of a command line
+	if wasmBuild {
+		serveWASM()
+		return
+	}
+
+	// Run as git-remote-mgit, git drives mgit through the remote helper protocol
+	if isRemoteHelper() {
+		runRemoteHelper(os.Args[1:])
+		return
+	}
+
+	// --quiet, --verbose and --debug come before the command
+	args := parseGlobalFlags(os.Args[1:])
+	if tracing() {
+		mgitlib.Tracef = tracef
+	}
+	if len(args) < 1 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	command := args[0]
+	args = args[1:]
+
+	// Finish whatever a crashed mgit process left half-written
+	if recovered, err := NewMGitStorage().recoverTransaction(); err != nil {
+		fmt.Printf("Error: failed to recover an interrupted .mgit update: %s\n", err)
+		os.Exit(1)
+	} else if recovered {
+		infof("Recovered an interrupted .mgit update\n")
+	}
+
+	runCommand(command, args)
+}
+
+// runCommand runs a built-in command, else an alias or a plugin
+func runCommand(command string, args []string) {
+	switch command {
+	case "init":
+		initRepo(args)
+	case "clone":
+		HandleClone(args)
+	case "add":
+		addFiles(args)
+	case "commit":
+		HandleMGitCommit(args)
+	case "push":
+		pushChanges(args)
+	case "pull":
+		pullChanges(args)
+	case "status":
+		showStatus(args)
+	case "branch":
+		handleBranch(args)
+	case "checkout":
+		checkoutBranch(args)
+	case "restore":
+		HandleRestore(args)
+	case "log":
+		HandleMGitLog(args)
+	case "show":
+		HandleMGitShow(args)
+	case "verify":
+		HandleMGitVerify(args)
+	case "annotate-commit":
+		HandleAnnotateCommit(args)
+	case "check-drift":
+		HandleCheckDrift(args)
+	case "gc":
+		HandleGC(args)
+	case "cat-file":
+		HandleCatFile(args)
+	case "rev-parse":
+		HandleRevParse(args)
+	case "update-ref":
+		HandleUpdateRef(args)
+	case "fsck":
+		HandleFsck(args)
+	case "hold":
+		HandleHold(args)
+	case "migrate":
+		HandleMigrate(args)
+	case "graph":
+		HandleGraph(args)
+	case "partial":
+		HandlePartial(args)
+	case "watch-remote":
+		HandleWatchRemote(args)
+	case "agent":
+		HandleAgent(args)
+	case "trust":
+		HandleTrust(args)
+	case "auth":
+		HandleAuth(args)
+	case "config":
+		HandleConfig(args)
+	case "selftest":
+		HandleSelftest(args)
+	case "upload-pack":
+		HandleUploadPack(args)
+	case "reflog":
+		HandleReflog(args)
+	case "map":
+		HandleMap(args)
+	case "shortlog":
+		HandleShortlog(args)
+	case "describe":
+		HandleDescribe(args)
+	case "notes":
+		HandleNotes(args)
+	case "reconcile":
+		HandleReconcile(args)
+	case "bisect":
+		HandleBisect(args)
+	case "grep":
+		HandleGrep(args)
+	case "archive":
+		HandleArchive(args)
+	case "bundle":
+		HandleBundle(args)
+	case "format-patch":
+		HandleFormatPatch(args)
+	case "apply":
+		HandleApply(args)
+	case "am":
+		HandleAm(args)
+	case "send-patch":
+		HandleSendPatch(args)
+	case "fetch-patches":
+		HandleFetchPatches(args)
+	case "issue":
+		HandleIssue(args)
+	case "relay":
+		HandleRelay(args)
+	case "keygen":
+		HandleKeygen(args)
+	case "key":
+		HandleKey(args)
+	case "identity":
+		HandleIdentity(args)
+	case "crypt":
+		HandleCrypt(args)
+	case "access":
+		HandleAccess(args)
+	case "login":
+		HandleLogin(args)
+	case "logout":
+		HandleLogout(args)
+	case "token":
+		HandleToken(args)
+	case "server":
+		HandleServer(args)
+	case "credential":
+		HandleCredential(args)
+	case "repo":
+		HandleRepo(args)
+	case "propose":
+		HandlePropose(args)
+	case "proposals":
+		HandleProposals(args)
+	case "proposal":
+		HandleProposal(args)
+	case "mirror":
+		HandleMirror(args)
+	case "serve":
+		HandleServe(args)
+	case "remote-helper":
+		HandleRemoteHelper(args)
+	case "daemon":
+		HandleDaemon(args)
+	case "plugin":
+		HandlePlugin(args)
+	case "validate":
+		HandleValidate(args)
+	case "export":
+		HandleExport(args)
+	case "prove":
+		HandleProve(args)
+	case "timestamp":
+		HandleTimestamp(args)
+	case "fsmonitor":
+		HandleFsmonitor(args)
+	case "--force-unlock":
+		HandleForceUnlock(args)
+	default:
+		// An [alias] entry names the command to run instead
+		if alias := GetConfigValue("alias."+command, ""); alias != "" {
+			runAlias(command, alias, args)
+			return
+		}
+		// An mgit-<command> executable on PATH adds the command
+		if path := findPlugin(command); path != "" {
+			runPlugin(path, args)
+			return
+		}
+		fmt.Printf("Unknown command: %s\n", command)
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("mgit - A go-git wrapper")
+	fmt.Println("Usage: mgit [--quiet|--verbose|--debug] <command> [args]")
+	fmt.Println("Commands:")
+	fmt.Println("  init [--bare] [-b <name>]   Initialize a new repository")
+	fmt.Println("       [--announce]           Publish a NIP-34 announcement to nostr.relays")
+	fmt.Println("  clone [-jwt <token>] <url>  Clone a repository (or nostr:<naddr> to find it from its announcement)")
+	fmt.Println("        [--depth <n>] [--branch <name>] [--single-branch] [--no-checkout] [--force] [--resumable]")
+	fmt.Println("        [--partial <path-prefix>] [--verbose]")
+	fmt.Println("  add <files...>              Add files to staging")
+	fmt.Println("  commit [-m <msg>] [-t <template>] [--no-verify]")
+	fmt.Println("                              Commit staged changes (without -m, write the message in the editor)")
+	fmt.Println("  push [--notify]             Push commits to remote (--notify: announce them on nostr, see push.notify)")
+	fmt.Println("  pull                        Pull changes from remote")
+	fmt.Println("  status [--no-cache]         Show repository status (--no-cache: ignore status.cache)")
+	fmt.Println("  branch                      List branches")
+	fmt.Println("  branch <name>               Create a new branch")
+	fmt.Println("  checkout [-f|-m] <ref>      Checkout a branch or commit")
+	fmt.Println("  checkout -b <name> [<ref>]  Create a branch at <ref> and switch to it")
+	fmt.Println("  checkout [<ref>] -- <paths> Restore files without moving HEAD")
+	fmt.Println("  restore [--staged] <paths>  Restore working tree files or unstage changes")
+	fmt.Println("  log [--no-resolve] [<rev>]  Show commit history from HEAD or <rev> (authors by NIP-05 identifier unless --no-resolve)")
+	fmt.Println("  show [--no-resolve] [commit]")
+	fmt.Println("                              Show commit details and changes")
+	fmt.Println("  show --batch                Show each hash read from stdin as a sized record")
+	fmt.Println("  show --links <hash>         List external documents linked to a commit")
+	fmt.Println("  verify [--full] [--wot]     Verify MGit hashes added since the last verify (--full: all;")
+	fmt.Println("                              --wot: also report commits from keys outside the trust set)")
+	fmt.Println("  annotate-commit <hash> --link <uri> --type imaging|lab|consent")
+	fmt.Println("                              Link a commit to an external document")
+	fmt.Println("  config                      Get, set and unset configuration values")
+	fmt.Println("  check-drift [--fix]         Detect (and repair) MGit HEAD drifting from git HEAD")
+	fmt.Println("  graph export [--format dot|json|mermaid] [<range>]")
+	fmt.Println("                              Export the commit DAG with MGit hashes and pubkeys")
+	fmt.Println("  partial add <path-prefix>   Scope this working copy to a subdirectory (also remove, list)")
+	fmt.Println("  gc [--prune=<expiry>]       Pack MGit objects, prune unreachable ones, compact mappings")
+	fmt.Println("  fsck [--verbose]            Check MGit objects, refs and mappings for corruption")
+	fmt.Println("  cat-file -p <object>        Print an MGit object (also -t type, -s size, -e exists)")
+	fmt.Println("  rev-parse [--git] <name>... Resolve names and prefixes to full MGit (or git) hashes")
+	fmt.Println("  update-ref <ref> <new> [<old>]")
+	fmt.Println("                              Point an MGit ref at a commit, only if it is still at <old> (-d deletes)")
+	fmt.Println("  hold set <path|ref>         Record a signed legal hold (also list, release <id>)")
+	fmt.Println("  migrate [--pubkey <npub>]   Compute MGit hashes for an existing git history")
+	fmt.Println("          [--mailmap <file>] [--upload]")
+	fmt.Println("  trust explain <hash>        Show which mapping source is trusted for a hash")
+	fmt.Println("  auth explain <url>          Show which auth provider would be used for a URL, and why")
+	fmt.Println("  watch-remote [--exec <cmd>] Stream server events (pushes, branches, permissions)")
+	fmt.Println("  agent [--listen <addr>] <dir>...")
+	fmt.Println("                              Sync repositories when the server's push webhook fires")
+	fmt.Println("  selftest [--server <url>]   Run clone/commit/push/pull/verify round trips and report pass/fail")
+	fmt.Println("  reflog [<ref>]              Show where HEAD or a branch has been (use as <ref>@{n})")
+	fmt.Println("  map <hash>                  Show the git/MGit mapping for either hash, its pubkey and source")
+	fmt.Println("  shortlog [--json] [<range>]  Commits, line churn and dates per pubkey (or email)")
+	fmt.Println("  describe [--tags] [--long] [<commit>]")
+	fmt.Println("                              Name a commit <tag>-<count>-g<short-mgit-hash> (--always: hash if untagged)")
+	fmt.Println("  notes set <commit> <key> <value>")
+	fmt.Println("                              Attach metadata to a commit (also list, get, remove, push, pull)")
+	fmt.Println("  reconcile [--fetch] [--dry-run]")
+	fmt.Println("                              Repair missing MGit objects and mappings (--fetch: from the server)")
+	fmt.Println("  bisect start [<bad> [<good>...]]")
+	fmt.Println("                              Binary-search for a bad commit (then good, bad, skip, run <cmd>, reset)")
+	fmt.Println("  grep [-n] [-i] [--rev <ref>] <pattern> [<path>...]")
+	fmt.Println("                              Search tracked files (or a commit's tree) for a regular expression")
+	fmt.Println("  archive [--format=tar|tar.gz|zip] [-o <file>] [--include-mgit] [<ref>]")
+	fmt.Println("                              Export a commit's files (--include-mgit: with MGit hashes for offline checks)")
+	fmt.Println("  bundle create <file> <refs> Package git and MGit objects and mappings for offline transfer")
+	fmt.Println("  bundle unbundle <file>      Verify and import a bundle, listing the refs it carries")
+	fmt.Println("  format-patch [-o <dir>] [--stdout] <range>")
+	fmt.Println("                              Write commits as mail patches carrying their MGit hash, pubkey and parents")
+	fmt.Println("  apply <patch>               Apply a raw diff to the working tree")
+	fmt.Println("  am <mbox|patch>...          Apply mailed patches as commits, keeping each author's pubkey")
+	fmt.Println("  send-patch [--to <naddr>] <range>")
+	fmt.Println("                              Publish commits as NIP-34 patch events to the repository's relays")
+	fmt.Println("  fetch-patches [<naddr>] [--show <id> | --apply <id>]")
+	fmt.Println("                              List patch series sent over nostr, show one, or apply it with am")
+	fmt.Println("  issue list|show|create|comment|close|reopen")
+	fmt.Println("                              Track issues as NIP-34 events on the repository's relays")
+	fmt.Println("  relay list|add|remove|test  Manage the nostr relays (nostr.relays) and check they answer")
+	fmt.Println("  keygen [<name>]             Generate a nostr key pair, stored encrypted in ~/.mgitconfig/keys")
+	fmt.Println("  key list|import|export|fingerprint|use|remove|rotate")
+	fmt.Println("                              Manage the stored nostr keys (rotate: retire one for a successor)")
+	fmt.Println("  identity list|show|add|remove|use")
+	fmt.Println("                              Manage identity profiles (name, email, npub, signer) per repository")
+	fmt.Println("  crypt init|add|grant|revoke|unlock|lock|status|cat")
+	fmt.Println("                              Encrypt chosen paths in every commit to a set of npubs")
+	fmt.Println("  access list|grant|revoke    Manage who can read or push the repository on the server")
+	fmt.Println("  login [--nostr] <url>       Log in to a repository's server and save the token (--nostr: sign a challenge)")
+	fmt.Println("  logout [<url> | --all]      Revoke and forget the token for a repository (default: origin's)")
+	fmt.Println("  token list                  Show the stored tokens with their access, scopes and expiry")
+	fmt.Println("  server list|add|remove      Manage server profiles: base URL, URL patterns, TLS settings and token")
+	fmt.Println("  credential get|store|erase  Act as a git credential helper answering with the stored tokens")
+	fmt.Println("  repo create <name>          Create a repository on the server and make it the origin")
+	fmt.Println("  propose [<branch>] [--to <branch>] [-m <title>]")
+	fmt.Println("                              Push a branch and open a proposal to merge it on the server")
+	fmt.Println("  proposals list [--state <s>]")
+	fmt.Println("                              List the repository's proposals (default: open ones)")
+	fmt.Println("  proposal merge|close <n>    Merge a proposal on the server, or close it unmerged")
+	fmt.Println("  mirror list|add|remove|push|status")
+	fmt.Println("                              Copy the git history to plain git hosts after each push")
+	fmt.Println("  serve [--listen <addr>] [<root>]")
+	fmt.Println("                              Host the repositories under a directory as an mgit server")
+	fmt.Println("  remote-helper install [<dir>]")
+	fmt.Println("                              Install git-remote-mgit so git can use mgit:: URLs")
+	fmt.Println("  daemon [--socket <path>]    Serve status, log, commit, clone and verify as JSON-RPC on a local socket")
+	fmt.Println("  plugin list [--json] | context")
+	fmt.Println("                              List mgit-<name> plugins on PATH, or print the JSON context they get")
+	fmt.Println("  validate [--all]            Check staged (or all) files against the validate rules")
+	fmt.Println("  export [--since <rev>] --paths <globs> [-o <file>] [<rev>] | --verify <file>")
+	fmt.Println("                              Export a verifiable slice of the history, or verify one")
+	fmt.Println("  prove <commit> [--checkpoint <rev>] [-o <file>] | --verify <file>")
+	fmt.Println("                              Write a signed proof that a commit is in the history, or verify one")
+	fmt.Println("  timestamp [<commit>...] | show <commit>")
+	fmt.Println("                              Anchor commits' MGit hashes on relays, or list a commit's anchors")
+	fmt.Println("  fsmonitor [run]             Watch the working copy so status looks only at changed files")
+	fmt.Println("  <alias> [args]              Run the command an [alias] config entry names, with args appended")
+	fmt.Println("  --force-unlock [--all]      Remove a stale repository lock (--all: leftover file locks too)")
+}
+
+/* 
+	Runs the standard Git initialization process
+	Gets the path to the .gitignore file in the newly created repository
+	Checks if the .gitignore file already exists
+
+	If it does, reads its current content
+	Verifies if .mgit is already in the .gitignore file
+
+	If not, appends .mgit/ to the file with a trailing newline
+	Provides user feedback when the .gitignore file is updated
+
+	Non-bare repositories also get their .mgit directory (objects, refs,
+	mappings, config, HEAD) up front, and with --announce a NIP-34
+	repository announcement is published to the configured relays
+*/
+func initRepo(args []string) {
+	path := "."
+	bare := false
+	announce := false
+	branch := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--bare":
+			bare = true
+		case args[i] == "--announce":
+			announce = true
+		case args[i] == "--initial-branch" || args[i] == "-b":
+			if i+1 >= len(args) {
+				fmt.Println("Error: --initial-branch requires a branch name")
+				os.Exit(1)
+			}
+			branch = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--initial-branch="):
+			branch = strings.TrimPrefix(args[i], "--initial-branch=")
+		case strings.HasPrefix(args[i], "-"):
+			fmt.Println("Usage: mgit init [--bare] [--initial-branch <name>] [--announce] [<directory>]")
+			os.Exit(1)
+		default:
+			path = args[i]
+		}
+	}
+	if branch == "" {
+		branch = defaultBranchName()
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if err := branchRef.Validate(); err != nil {
+		fmt.Printf("Error: invalid branch name '%s'\n", branch)
+		os.Exit(1)
+	}
+
+	_, err := git.PlainInitWithOptions(path, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: branchRef},
+		Bare:        bare,
+	})
+	if err != nil {
+		fmt.Printf("Error initializing repository: %s\n", err)
+		os.Exit(1)
+	}
+	if bare {
+		// Server-side repository - there is no worktree to keep .mgit out of
+		if announce {
+			fmt.Println("Error: --announce is not supported for bare repositories")
+			os.Exit(1)
+		}
+		infof("Initialized empty bare Git repository in %s (branch %s)\n", path, branch)
+		return
+	}
+	infof("Initialized empty Git repository in %s (branch %s)\n", path, branch)
+	
+	if err := scaffoldMGit(path, branch); err != nil {
+		fmt.Printf("Error initializing MGit metadata: %s\n", err)
+		os.Exit(1)
+	}
+	
+	if announce {
+		infof("Announcing repository to relays...\n")
+		if err := announceRepository(path); err != nil {
+			fmt.Printf("Error announcing repository: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	
+	// Add .mgit to .gitignore
+	gitignorePath := filepath.Join(path, ".gitignore")
+	
+	// Check if .gitignore already exists
+	var content []byte
+	if _, err := os.Stat(gitignorePath); !os.IsNotExist(err) {
+		// Read existing content
+		content, err = os.ReadFile(gitignorePath)
+		if err != nil {
+			fmt.Printf("Warning: Failed to read .gitignore: %s\n", err)
+			return
+		}
+	}
+	
+	// Check if .mgit is already in .gitignore
+	if !strings.Contains(string(content), ".mgit") {
+		// Append .mgit to .gitignore (with newline)
+		newContent := string(content)
+		if len(newContent) > 0 && !strings.HasSuffix(newContent, "\n") {
+			newContent += "\n"
+		}
+		newContent += ".mgit/\n"
+		
+		// Write back to .gitignore
+		err = os.WriteFile(gitignorePath, []byte(newContent), 0644)
+		if err != nil {
+			fmt.Printf("Warning: Failed to update .gitignore: %s\n", err)
+			return
+		}
+		infof("Added .mgit/ to .gitignore\n")
+	}
+}
+
+// scaffoldMGit creates the .mgit directory of a new repository: objects,
+// refs, an empty mapping store, config, and HEAD on the initial branch
+func scaffoldMGit(path, branch string) error {
+	storage := &MGitStorage{RootDir: filepath.Join(path, ".mgit")}
+	if err := storage.Initialize(); err != nil {
+		return err
+	}
+	if err := storage.UpdateHead(branch, "init"); err != nil {
+		return err
+	}
+	if _, err := os.Stat(storage.mappingsPath()); os.IsNotExist(err) {
+		if err := storage.WriteMappings([]NostrCommitMapping{}); err != nil {
+			return err
+		}
+	}
+	
+	configPath := filepath.Join(storage.RootDir, "config")
+	err := UpdateConfig(configPath, func(config *Config) error {
+		if config.Get("repository", "name") == "" {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			config.Set("repository", "name", filepath.Base(absPath))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error saving MGit config: %w", err)
+	}
+	
+	infof("Initialized MGit metadata in %s\n", storage.RootDir)
+	return nil
+}
+
+// defaultBranchName returns the branch new repositories start on
+// (init.defaultBranch, falling back to master)
+func defaultBranchName() string {
+	return GetConfigValue("init.defaultBranch", "master")
+}
+
+// getRepo returns the repository in the working directory, shared by
+// everything in this process (see Session)
+func getRepo() *git.Repository {
+	return currentSession().MustRepo()
+}
+
+func addFiles(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: mgit add <files...>")
+		os.Exit(1)
+	}
+
+	repo := getRepo()
+	w, err := repo.Worktree()
+	if err != nil {
+		fmt.Printf("Error getting worktree: %s\n", err)
+		os.Exit(1)
+	}
+
+	prefixes, err := partialScope(".")
+	if err != nil {
+		fmt.Printf("Error reading partial scope: %s\n", err)
+		os.Exit(1)
+	}
+
+	for _, file := range args {
+		if !inPartialScope(file, prefixes) {
+			fmt.Printf("Error: %s is outside the partial scope (%s)\n", file, strings.Join(prefixes, ", "))
+			os.Exit(1)
+		}
+		var err error
+		if cryptInUse() {
+			// Encrypted paths must pass through git's clean filter
+			err = runGitIn(".", "add", "--", file)
+		} else {
+			_, err = w.Add(file)
+		}
+		if err != nil {
+			fmt.Printf("Error adding file %s: %s\n", file, err)
+			os.Exit(1)
+		}
+	}
+	infof("Changes staged for commit\n")
+}
+
+func commitChanges(args []string) {
+	message := ""
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-m" && i+1 < len(args) {
+			message = args[i+1]
+			break
+		}
+	}
+
+	if message == "" {
+		fmt.Println("Usage: mgit commit -m <message>")
+		os.Exit(1)
+	}
+
+	// Use the custom MGitCommit function with MCommitOptions
+	commit, err := MGitCommit(message, &MCommitOptions{
+		Author: &Signature{
+			Name:   GetConfigValue("user.name", "mgit User"),
+			Email:  GetConfigValue("user.email", "mgit@example.com"),
+			Pubkey: GetNostrPubKey(),
+			When:   time.Now(),
+		},
+	})
+	if err != nil {
+		fmt.Printf("Error committing changes: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Since we're using a custom hash, we need to handle how to display it
+	// Option 1: Try to get the commit object (may not work with custom hash)
+	repo := getRepo()
+	obj, err := repo.CommitObject(commit)
+	if err != nil {
+		// Option 2: Just display the hash if we can't get the object
+		infof("Committed changes [%s]: %s\n", commit.String()[:7], message)
+	} else {
+		infof("Committed changes [%s]: %s\n", obj.Hash.String()[:7], message)
+	}
+}
+
+func pushChanges(args []string) {
+	repo := getRepo()
+	
+	// Collect what the push sends first, if it is to be announced
+	notify := GetConfigBool("push.notify", false)
+	for _, arg := range args {
+			switch arg {
+			case "--notify":
+					notify = true
+			case "--no-notify":
+					notify = false
+			}
+	}
+	anchor := GetConfigValue("timestamp.auto", "off") == "push"
+	var summary *PushSummary
+	if notify || anchor {
+			var err error
+			if summary, err = pendingPush(repo); err != nil {
+					fmt.Printf("Warning: could not list the commits to push: %s\n", err)
+			}
+	}
+	
+	// Get the remote URL
+	remoteURL := ""
+	remote, err := repo.Remote("origin")
+	if err == nil && len(remote.Config().URLs) > 0 {
+			remoteURL = remote.Config().URLs[0]
+	}
+
+	// Use git push with temporary header configuration. Local paths and
+	// non-HTTP remotes need no token.
+	gitArgs := []string{"push", "--progress", "origin", "HEAD"}
+	if isServerURL(remoteURL) {
+			gitArgs = append(append(serverGitArgs(remoteURL), "-c", authForRepo(remoteURL).gitConfig()), gitArgs...)
+	}
+	cmd := exec.Command("git", gitArgs...)
+	
+	cmd.Stdout = os.Stdout
+	cmd.Dir = "."
+	
+	if err := runGitProgress(cmd, "push"); err != nil {
+			fmt.Printf("Error pushing changes: %s\n", err)
+			os.Exit(1)
+	}
+	infof("Changes pushed to remote\n")
+	autoPushMirrors()
+	
+	if notify && summary != nil && len(summary.Commits) > 0 {
+			if err := notifyPush(currentSession().Storage(), summary); err != nil {
+					fmt.Printf("Warning: push notification not sent: %s\n", err)
+			}
+	}
+	if anchor && summary != nil {
+			hashes := []string{}
+			for _, commit := range summary.Commits {
+					if commit.MGitHash != "" {
+							hashes = append(hashes, commit.MGitHash)
+					}
+			}
+			autoTimestamp(currentSession().Storage(), "push", hashes)
+	}
+}
+
+func pullChanges(args []string) {
+	repo := getRepo()
+	defer lockRepoOrExit("pull").Release()
+	
+	remoteURL := ""
+	remote, err := repo.Remote("origin")
+	if err == nil && len(remote.Config().URLs) > 0 {
+			remoteURL = remote.Config().URLs[0]
+	}
+	
+	// Pull with the git CLI, as push does - go-git's pull fails on the packed
+	// refs that git clone writes
+	gitArgs := []string{"pull", "--progress", "--ff-only", "origin"}
+	if isServerURL(remoteURL) {
+			gitArgs = append(append(serverGitArgs(remoteURL), "-c", authForRepo(remoteURL).gitConfig()), gitArgs...)
+	}
+	cmd := exec.Command("git", gitArgs...)
+	cmd.Stdout = os.Stdout
+	
+	if err := runGitProgress(cmd, "pull"); err != nil {
+		fmt.Printf("Error pulling changes: %s\n", err)
+		os.Exit(1)
+	}
+	infof("Changes pulled from remote\n")
+}
+
+func showStatus(args []string) {
+	for _, arg := range args {
+		if arg == "--no-cache" {
+			statusCacheDisabled = true
+		} else {
+			fmt.Println("Usage: mgit status [--no-cache]")
+			os.Exit(1)
+		}
+	}
+
+	repo := getRepo()
+
+	// A partial working copy only reports paths inside its scope
+	prefixes, err := partialScope(".")
+	if err != nil {
+		fmt.Printf("Error reading partial scope: %s\n", err)
+		os.Exit(1)
+	}
+
+	var status git.Status
+	if len(prefixes) > 0 || cryptInUse() {
+		status, err = scopedStatus(".", prefixes)
+	} else {
+		status, err = worktreeStatus(repo)
+	}
+	if err != nil {
+		fmt.Printf("Error getting status: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Current branch:", getCurrentBranch(repo))
+	if len(prefixes) > 0 {
+		fmt.Println("Partial scope:", strings.Join(prefixes, ", "))
+	}
+	fmt.Println()
+	
+	if status.IsClean() {
+		fmt.Println("Nothing to commit, working tree clean")
+		return
+	}
+
+	fmt.Println("Changes to be committed:")
+	for file, fileStatus := range status {
+		if fileStatus.Staging == git.Added {
+			fmt.Printf("  new file:   %s\n", file)
+		} else if fileStatus.Staging == git.Modified {
+			fmt.Printf("  modified:   %s\n", file)
+		} else if fileStatus.Staging == git.Deleted {
+			fmt.Printf("  deleted:    %s\n", file)
+		}
+	}
+	fmt.Println()
+
+	fmt.Println("Changes not staged for commit:")
+	for file, fileStatus := range status {
+		if fileStatus.Worktree == git.Modified {
+			fmt.Printf("  modified:   %s\n", file)
+		} else if fileStatus.Worktree == git.Deleted {
+			fmt.Printf("  deleted:    %s\n", file)
+		}
+	}
+	fmt.Println()
+
+	fmt.Println("Untracked files:")
+	for file, fileStatus := range status {
+		if fileStatus.Worktree == git.Untracked {
+			fmt.Printf("  %s\n", file)
+		}
+	}
+}
+
+func getCurrentBranch(repo *git.Repository) string {
+	head, err := repo.Head()
+	if err != nil {
+		fmt.Printf("Error getting HEAD: %s\n", err)
+		return "unknown"
+	}
+	
+	if head.Name().IsBranch() {
+		return head.Name().Short()
+	}
+	
+	return head.Hash().String()[:7]
+}
+
+func handleBranch(args []string) {
+	repo := getRepo()
+	
+	if len(args) == 0 {
+		// List branches
+		branches, err := repo.Branches()
+		if err != nil {
+			fmt.Printf("Error listing branches: %s\n", err)
+			os.Exit(1)
+		}
+		
+		currentBranch := getCurrentBranch(repo)
+		fmt.Println("Branches:")
+		
+		err = branches.ForEach(func(branch *plumbing.Reference) error {
+			name := branch.Name().Short()
+			if name == currentBranch {
+				fmt.Printf("* %s\n", name)
+			} else {
+				fmt.Printf("  %s\n", name)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error iterating branches: %s\n", err)
+			os.Exit(1)
+		}
+	} else {
+		// Create a new branch
+		checkoutNewBranch(args[0], "HEAD", false, checkoutSafe)
+	}
+}
+
+func checkoutBranch(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: mgit checkout [-f|-m] [-b|-B <new-branch>] <branch> [<start-point>] | [<rev>] -- <paths...>")
+		os.Exit(1)
+	}
+	defer lockRepoOrExit("checkout").Release()
+	
+	// Pull out --force / --merge, which apply to branch switches
+	mode := checkoutSafe
+	filteredArgs := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			filteredArgs = append(filteredArgs, args[i:]...)
+			break
+		}
+		switch arg {
+		case "-f", "--force":
+			mode = checkoutForce
+		case "-m", "--merge":
+			mode = checkoutMerge
+		default:
+			filteredArgs = append(filteredArgs, arg)
+		}
+	}
+	args = filteredArgs
+	if len(args) < 1 {
+		fmt.Println("Usage: mgit checkout [-f|-m] <branch>")
+		os.Exit(1)
+	}
+	
+	// Handle "checkout [<rev>] -- <paths>" (restore files, HEAD stays put)
+	for i, arg := range args {
+		if arg != "--" {
+			continue
+		}
+		if i > 1 || i == len(args)-1 {
+			fmt.Println("Usage: mgit checkout [<rev>] -- <paths...>")
+			os.Exit(1)
+		}
+		opts := &RestoreOptions{Worktree: true}
+		if i == 1 {
+			// Checking out from a commit updates the index as well
+			opts.Source = args[0]
+			opts.Staged = true
+		}
+		if err := restorePaths(args[i+1:], opts); err != nil {
+			fmt.Printf("Error restoring files: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	
+	// Handle -b / -B (create and switch)
+	if args[0] == "-b" || args[0] == "-B" {
+		if len(args) < 2 || len(args) > 3 {
+			fmt.Println("Usage: mgit checkout -b|-B <new-branch> [<start-point>]")
+			os.Exit(1)
+		}
+		startPoint := "HEAD"
+		if len(args) == 3 {
+			startPoint = args[2]
+		}
+		checkoutNewBranch(args[1], startPoint, args[0] == "-B", mode)
+		return
+	}
+	
+	repo := getRepo()
+	branchName := args[0]
+	
+	if ref, err := repo.Reference(plumbing.NewBranchReferenceName(branchName), false); err == nil {
+		requireSafeCheckout(repo, ref.Hash(), mode)
+		if err := runGitCheckout(mode.gitArgs(branchName)...); err != nil {
+			fmt.Printf("Error checking out %s: %s\n", branchName, err)
+			os.Exit(1)
+		}
+		syncMGitHead(repo, "checkout: moving to "+branchName)
+		infof("Switched to branch '%s'\n", branchName)
+		return
+	}
+	
+	// Maybe it's a commit hash?
+	hash, err := resolveRevision(repo, branchName)
+	if err != nil {
+		fmt.Printf("Error checking out %s: %s\n", branchName, err)
+		os.Exit(1)
+	}
+	requireSafeCheckout(repo, hash, mode)
+	if err := runGitCheckout(mode.gitArgs("--detach", hash.String())...); err != nil {
+		fmt.Printf("Error checking out %s: %s\n", branchName, err)
+		os.Exit(1)
+	}
+	syncMGitHead(repo, "checkout: moving to "+branchName)
+	infof("Checked out commit %s\n", branchName)
+}
+
+// runGitCheckout runs git checkout with the given arguments. go-git's checkout
+// removes untracked files (including .mgit), so the switch is left to git
+// itself, as push and clone already do.
+func runGitCheckout(args ...string) error {
+	cmd := exec.Command("git", append([]string{"checkout", "-q"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// checkoutNewBranch creates a branch at startPoint and switches to it.
+// startPoint may be a branch, tag, Git hash or MGit hash. With reset set
+// (-B), an existing branch of the same name is moved to startPoint.
+// mode decides what happens to uncommitted changes in the way.
+func checkoutNewBranch(branchName, startPoint string, reset bool, mode CheckoutMode) {
+	repo := getRepo()
+	
+	hash, err := resolveRevision(repo, startPoint)
+	if err != nil {
+		fmt.Printf("Error: '%s' is not a valid start point: %s\n", startPoint, err)
+		os.Exit(1)
+	}
+	
+	refName := plumbing.NewBranchReferenceName(branchName)
+	_, err = repo.Reference(refName, false)
+	exists := err == nil
+	if exists && !reset {
+		fmt.Printf("Error: a branch named '%s' already exists\n", branchName)
+		os.Exit(1)
+	}
+	
+	requireSafeCheckout(repo, hash, mode)
+	
+	flag := "-b"
+	if reset {
+		flag = "-B"
+	}
+	if err := runGitCheckout(mode.gitArgs(flag, branchName, hash.String())...); err != nil {
+		fmt.Printf("Error creating branch %s: %s\n", branchName, err)
+		os.Exit(1)
+	}
+	
+	// Keep the MGit refs in step with the new git branch. A start point
+	// without an MGit hash leaves no MGit branch ref, and -B drops the one
+	// left pointing at the branch's old commit; HEAD follows the branch
+	// either way.
+	storage := NewMGitStorage()
+	if mgitHash, err := storage.GetMGitHashFromGit(hash.String()); err == nil {
+		if err := storage.UpdateRef(refName.String(), mgitHash, "branch: Created from "+startPoint); err != nil {
+			fmt.Printf("Warning: Failed to update MGit branch ref: %s\n", err)
+		}
+	} else if _, err := storage.GetRef(refName.String()); err == nil {
+		if err := storage.DeleteRef(refName.String()); err != nil {
+			fmt.Printf("Warning: Failed to update MGit branch ref: %s\n", err)
+		}
+	}
+	syncMGitHead(repo, "checkout: moving to "+branchName)
+	
+	if exists {
+		infof("Switched to and reset branch '%s'\n", branchName)
+	} else {
+		infof("Switched to a new branch '%s'\n", branchName)
+	}
+}
+
+func showLog(args []string) {
+	repo := getRepo()
+	
+	// Get the HEAD reference
+	ref, err := repo.Head()
+	if err != nil {
+		fmt.Printf("Error getting HEAD: %s\n", err)
+		os.Exit(1)
+	}
+	
+	// Get commit object
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		fmt.Printf("Error getting commit: %s\n", err)
+		os.Exit(1)
+	}
+	
+	// Get commit history
+	commitIter, err := repo.Log(&git.LogOptions{From: commit.Hash})
+	if err != nil {
+		fmt.Printf("Error getting log: %s\n", err)
+		os.Exit(1)
+	}
+	
+	fmt.Println("Commit History:")
+	err = commitIter.ForEach(func(c *object.Commit) error {
+		fmt.Printf("Commit: %s\n", c.Hash.String())
+		fmt.Printf("Author: %s <%s>\n", c.Author.Name, c.Author.Email)
+		fmt.Printf("Date:   %s\n", c.Author.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+		fmt.Printf("\n    %s\n\n", c.Message)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error iterating commits: %s\n", err)
+		os.Exit(1)
+	}
+}
\ No newline at end of file
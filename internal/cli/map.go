@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// HandleMap handles the map command: it looks a git or MGit hash (or a
+// unique prefix of one) up in the mapping store and prints both sides of the
+// mapping, the pubkey and where the mapping came from
+func HandleMap(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: mgit map <hash>")
+		os.Exit(1)
+	}
+	hash := strings.ToLower(args[0])
+	if len(hash) < 4 || len(hash) > 40 || !isHexString(hash) {
+		fmt.Printf("Error: '%s' is not a hash or hash prefix of at least 4 characters\n", args[0])
+		os.Exit(1)
+	}
+
+	session := currentSession()
+	storage := session.Storage()
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	mapping, err := findMapping(mappings, hash)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if mapping == nil {
+		fmt.Printf("Error: %s\n", unmappedHash(session, hash))
+		os.Exit(1)
+	}
+
+	gitState := "present"
+	if repo, err := session.Repo(); err != nil {
+		gitState = "unknown"
+	} else if _, err := repo.CommitObject(plumbing.NewHash(mapping.GitHash)); err != nil {
+		gitState = "not present locally"
+	}
+	mgitState := "present"
+	if _, err := storage.GetCommit(mapping.MGitHash); err != nil {
+		mgitState = "object missing"
+	}
+
+	fmt.Printf("Git:    %s (%s)\n", mapping.GitHash, gitState)
+	fmt.Printf("MGit:   %s (%s)\n", mapping.MGitHash, mgitState)
+	fmt.Printf("Pubkey: %s\n", orNone(mapping.Pubkey))
+	fmt.Printf("Source: %s\n", mappingSourceName(mapping.Source))
+}
+
+// unmappedHash explains why hash has no mapping: it names a git commit or
+// MGit object that was never mapped, or nothing at all
+func unmappedHash(session *Session, hash string) error {
+	if repo, err := session.Repo(); err == nil {
+		if revision, err := Resolve(repo, session.Storage(), hash); err == nil && strings.HasPrefix(revision.Git.String(), hash) {
+			return fmt.Errorf("git commit %s has no MGit mapping", revision.Git.String())
+		}
+	}
+	if commit, err := session.Storage().GetCommit(hash); err == nil {
+		return fmt.Errorf("MGit commit %s has no mapping to a git commit", commit.MGitHash)
+	}
+	return fmt.Errorf("no git or MGit commit matches %s", hash)
+}
+
+// mappingSourceName describes a mapping's recorded source
+func mappingSourceName(source string) string {
+	if source == "" {
+		return "unknown (recorded before sources were tracked)"
+	}
+	return source
+}
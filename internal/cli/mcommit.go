@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// Signature represents the author or committer information including nostr pubkey
+type Signature struct {
+	// Name represents a person name. It is an arbitrary string.
+	Name string
+	// Email is an email, but it cannot be assumed to be well-formed.
+	Email string
+	// Pubkey is the nostr public key
+	Pubkey string
+	// When is the timestamp of the signature.
+	When time.Time
+}
+
+// MCommitOptions holds information for committing changes with enhanced mgit features
+type MCommitOptions struct {
+	Author    *Signature
+	Committer *Signature
+	// Additional fields can be added here if needed
+}
+
+// convertToGitSignature converts our Signature to go-git's object.Signature
+func convertToGitSignature(sig *Signature) *object.Signature {
+	return &object.Signature{
+		Name:  sig.Name,
+		Email: sig.Email,
+		When:  sig.When,
+	}
+}
+
+// MGitCommit creates a commit that incorporates the nostr pubkey in hash calculation
+func MGitCommit(message string, opts *MCommitOptions) (plumbing.Hash, error) {
+	// Get repository
+	repo := getRepo()
+	w, err := repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error getting worktree: %s", err)
+	}
+
+	// Convert our signature to go-git signature
+	author := convertToGitSignature(opts.Author)
+	
+	// Create a standard commit using go-git
+	commitOpts := &git.CommitOptions{
+		Author: author,
+	}
+	
+	// If committer is specified, use it
+	if opts.Committer != nil {
+		commitOpts.Committer = convertToGitSignature(opts.Committer)
+	}
+	
+	// Perform the standard git commit
+	gitHash, err := w.Commit(message, commitOpts)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error committing: %s", err)
+	}
+	
+	// If no pubkey is present, just return the Git hash
+	if opts.Author.Pubkey == "" {
+		return gitHash, nil
+	}
+	
+	// Get the commit object we just created
+	gitCommit, err := repo.CommitObject(gitHash)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error retrieving commit: %w", err)
+	}
+	
+	// Initialize MGit storage
+	storage := NewMGitStorage()
+	if err := storage.Initialize(); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error initializing MGit storage: %w", err)
+	}
+	
+	// Collect MGit hashes for parent commits
+	parentMGitHashes := []string{}
+	for _, parentGitHash := range gitCommit.ParentHashes {
+		mgitHash, err := storage.GetMGitHashFromGit(parentGitHash.String())
+		if err == nil {
+			// We found an MGit hash for this parent
+			parentMGitHashes = append(parentMGitHashes, mgitHash)
+			debugf("found MGit hash for parent %s: %s", 
+				parentGitHash.String()[:7], mgitHash[:7])
+		} else {
+			// No MGit hash found, use the Git hash as a fallback
+			parentMGitHashes = append(parentMGitHashes, parentGitHash.String())
+			debugf("no MGit hash found for parent %s", parentGitHash.String()[:7])
+		}
+	}
+	
+	// Create an MGit commit object and compute its hash. The committer is
+	// attributed to the author's pubkey for now.
+	mgitCommit := mgitlib.CommitFromGit(gitCommit, parentMGitHashes, opts.Author.Pubkey)
+	mgitCommit.Metadata = map[string]string{"version": "1.0"}
+	mgitHash := mgitlib.Hash(mgitCommit.HashInput())
+	mgitCommit.MGitHash = mgitHash.String()
+	
+	// Store the object and mapping and advance the branch (or a detached
+	// HEAD) together
+	refName := ""
+	if head, err := repo.Head(); err == nil && head.Name().IsBranch() {
+		refName = head.Name().String()
+	}
+	if err := storage.RecordCommit(mgitCommit, opts.Author.Pubkey, refName); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error recording MGit commit for Git commit %s (run mgit migrate to retry): %w",
+			gitHash.String()[:7], err)
+	}
+	
+	infof("Created MGit commit: %s (Git hash: %s)\n", 
+		mgitHash.String(), gitHash.String())
+	
+	return mgitHash, nil
+}
+
+// getMGitHashForCommit retrieves the MGit hash for a Git commit hash
+func GetMGitHashForCommit(gitHash plumbing.Hash) string {
+	mapping, ok, err := NewMGitStorage().Mappings().ByGit(gitHash.String())
+	if err != nil || !ok {
+		return ""
+	}
+	return mapping.MGitHash
+}
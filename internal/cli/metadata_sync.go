@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	serverclient "github.com/imyjimmy/mgit/pkg/server-client"
+)
+
+// Fetching a server's metadata after a clone only transfers what is new.
+// The metadata endpoint may hand out a cursor with each response:
+//
+//	GET /api/mgit/repos/<id>/metadata?since=<cursor>
+//	    -> X-MGit-Since: <cursor>   the cursor the server applied; entries added after it
+//	       X-MGit-Cursor: <cursor>  where the next fetch starts
+//	       ETag: "<tag>"
+//
+// A server that leaves X-MGit-Since out sent everything. One without
+// cursors can still answer If-None-Match with 304 Not Modified. Where the
+// last fetch left off is kept per remote in .mgit/mappings/sync.json.
+//
+// The response is decoded as a stream, one entry at a time, so a large
+// mapping set is never held as one JSON document in memory.
+
+// MetadataSyncState records where the last metadata fetch from a server
+// left off
+type MetadataSyncState struct {
+	URL    string `json:"url"` // The metadata endpoint it applies to
+	Cursor string `json:"cursor,omitempty"`
+	ETag   string `json:"etag,omitempty"`
+}
+
+// MetadataFetch describes one metadata response
+type MetadataFetch struct {
+	State       *MetadataSyncState // Where the next fetch starts
+	Incremental bool               // Only entries added since the cursor were sent
+	NotModified bool               // Nothing changed since the last fetch
+}
+
+// metadataSyncPath returns the file recording where metadata fetches left off
+func (s *MGitStorage) metadataSyncPath() string {
+	return filepath.Join(s.RootDir, "mappings", "sync.json")
+}
+
+// loadMetadataSync returns where the last fetch from a metadata endpoint
+// left off; a fetch that has never been made starts from nothing
+func (s *MGitStorage) loadMetadataSync(metadataURL string) *MetadataSyncState {
+	states := []*MetadataSyncState{}
+	if data, err := os.ReadFile(s.metadataSyncPath()); err == nil {
+		json.Unmarshal(data, &states)
+	}
+	for _, state := range states {
+		if state.URL == metadataURL {
+			return state
+		}
+	}
+	return &MetadataSyncState{URL: metadataURL}
+}
+
+// saveMetadataSync records where a fetch left off, replacing the previous
+// record for the same endpoint
+func (s *MGitStorage) saveMetadataSync(state *MetadataSyncState) error {
+	states := []*MetadataSyncState{}
+	if data, err := os.ReadFile(s.metadataSyncPath()); err == nil {
+		json.Unmarshal(data, &states)
+	}
+	kept := []*MetadataSyncState{state}
+	for _, existing := range states {
+		if existing.URL != state.URL {
+			kept = append(kept, existing)
+		}
+	}
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.metadataSyncPath()), 0755); err != nil {
+		return err
+	}
+	return fsutil.WriteFile(s.metadataSyncPath(), data)
+}
+
+// streamRemoteMetadata fetches a repository's metadata, from where since
+// left off when it is given, and passes each entry to handle as it is
+// decoded
+func streamRemoteMetadata(ctx context.Context, repoURL string, auth *Credentials, since *MetadataSyncState, handle func(remoteMetadataEntry) error) (*MetadataFetch, error) {
+	var cursor *serverclient.MetadataCursor
+	if since != nil {
+		cursor = &serverclient.MetadataCursor{Cursor: since.Cursor, ETag: since.ETag}
+	}
+	fetch, err := newServerClient(repoURL, auth, 0).Metadata(ctx, cursor, handle)
+	if err != nil {
+		return nil, err
+	}
+	return &MetadataFetch{
+		State: &MetadataSyncState{
+			URL:    mgitMetadataURL(repoURL),
+			Cursor: fetch.Next.Cursor,
+			ETag:   fetch.Next.ETag,
+		},
+		Incremental: fetch.Incremental,
+		NotModified: fetch.NotModified,
+	}, nil
+}
+
+// metadataCollector gathers streamed entries: every mapping, and the
+// entries that carry notes
+type metadataCollector struct {
+	mappings []NostrCommitMapping
+	noted    []remoteMetadataEntry
+}
+
+func (c *metadataCollector) add(entry remoteMetadataEntry) error {
+	mapping := entry.Mapping
+	mapping.Source = mappingSourceServer
+	c.mappings = append(c.mappings, mapping)
+	if len(entry.Notes) > 0 {
+		c.noted = append(c.noted, entry)
+	}
+	return nil
+}
+
+// syncRemoteMetadata brings a repository's mappings and notes up to date
+// with the server, fetching only what is new when the server can say what
+// that is. A full fetch replaces the mappings, as the server's are
+// canonical; an incremental one adds to them.
+func syncRemoteMetadata(ctx context.Context, storage *MGitStorage, repoURL string, auth *Credentials) (*MetadataFetch, error) {
+	since := storage.loadMetadataSync(mgitMetadataURL(repoURL))
+	collected := &metadataCollector{}
+	fetch, err := streamRemoteMetadata(ctx, repoURL, auth, since, collected.add)
+	if err != nil {
+		return nil, err
+	}
+	if fetch.NotModified {
+		return fetch, nil
+	}
+
+	if fetch.Incremental {
+		if len(collected.mappings) > 0 {
+			err = storage.Mappings().PutAll(collected.mappings)
+		}
+	} else {
+		err = storage.WriteMappings(collected.mappings)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing hash mappings: %w", err)
+	}
+	if _, err := mergeRemoteNotes(storage, collected.noted); err != nil {
+		return nil, fmt.Errorf("error storing notes: %w", err)
+	}
+	if err := storage.saveMetadataSync(fetch.State); err != nil {
+		return nil, fmt.Errorf("error recording metadata sync: %w", err)
+	}
+	return fetch, nil
+}
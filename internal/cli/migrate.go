@@ -0,0 +1,246 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// MigrateOptions controls how migrate attributes commits
+type MigrateOptions struct {
+	Pubkey  string // Pubkey for commits the mailmap does not cover
+	Mailmap string // Mailmap applied over .mailmap and .mgit/identitymap
+	Upload  bool   // Send the mappings to the origin server afterwards
+}
+
+const migrateUsage = "Usage: mgit migrate [--pubkey <npub>] [--mailmap <file>] [--upload]"
+
+// HandleMigrate handles the migrate command
+func HandleMigrate(args []string) {
+	opts := &MigrateOptions{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--pubkey", "--mailmap":
+			if i+1 >= len(args) {
+				fmt.Println(migrateUsage)
+				os.Exit(1)
+			}
+			if args[i] == "--pubkey" {
+				opts.Pubkey = args[i+1]
+			} else {
+				opts.Mailmap = args[i+1]
+			}
+			i++
+		case "--upload":
+			opts.Upload = true
+		default:
+			fmt.Println(migrateUsage)
+			os.Exit(1)
+		}
+	}
+	if opts.Pubkey == "" {
+		opts.Pubkey = GetNostrPubKey()
+	} else {
+		pubkey, err := NormalizeNostrPubKey(opts.Pubkey)
+		if err != nil {
+			fmt.Printf("Error: invalid --pubkey: %s\n", err)
+			os.Exit(1)
+		}
+		opts.Pubkey = pubkey
+	}
+
+	// The repository's .mailmap and .mgit/identitymap, then --mailmap
+	mailmaps := []string{}
+	if opts.Mailmap != "" {
+		mailmaps = append(mailmaps, opts.Mailmap)
+	}
+	identities, err := LoadIdentityMap(".", mailmaps...)
+	if err != nil {
+		fmt.Printf("Error reading mailmap: %s\n", err)
+		os.Exit(1)
+	}
+	if opts.Pubkey == "" && !identities.HasPubkeys() {
+		fmt.Println("No pubkey to attribute commits to. Pass --pubkey, --mailmap, or set user.pubkey:")
+		fmt.Println("  mgit config user.pubkey \"npub...\"")
+		os.Exit(1)
+	}
+
+	session := currentSession()
+	repo := session.MustRepo()
+	defer lockRepoOrExit("migrate").Release()
+	storage := session.Storage()
+	if err := storage.Initialize(); err != nil {
+		fmt.Printf("Error initializing MGit storage: %s\n", err)
+		os.Exit(1)
+	}
+
+	mappings, migrated, err := migrateHistory(repo, storage, func(c *object.Commit) string {
+		if identity := identities.Resolve(c.Author.Name, c.Author.Email); identity.Pubkey != "" {
+			return identity.Pubkey
+		}
+		return opts.Pubkey
+	})
+	if err != nil {
+		fmt.Printf("Error migrating history: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Migrated %d commit(s); %d already had MGit hashes\n", migrated, len(mappings)-migrated)
+
+	if err := syncMGitRefs(repo, storage, mappings, "migrate"); err != nil {
+		fmt.Printf("Error updating MGit refs: %s\n", err)
+		os.Exit(1)
+	}
+
+	if opts.Upload {
+		remote, err := repo.Remote("origin")
+		if err != nil || len(remote.Config().URLs) == 0 {
+			fmt.Println("Error: --upload needs an origin remote")
+			os.Exit(1)
+		}
+		remoteURL := remote.Config().URLs[0]
+		if err := uploadRemoteMappings(commandContext(), remoteURL, authForRepo(remoteURL), mappings); err != nil {
+			exitIfInterrupted(err)
+			fmt.Printf("Error uploading mappings: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Uploaded %d mapping(s) to %s\n", len(mappings), remoteURL)
+	}
+}
+
+// migrateHistory computes MGit commits for every commit reachable from the
+// repository's refs, parents before children. Commits that already have a
+// mapping keep it. It returns the full mapping set and how many commits were
+// newly migrated.
+func migrateHistory(repo *git.Repository, storage *MGitStorage, pubkeyFor func(*object.Commit) string) ([]NostrCommitMapping, int, error) {
+	existing, err := storage.GetMappings()
+	if err != nil {
+		return nil, 0, err
+	}
+	mgitHashes := map[string]string{}
+	for _, mapping := range existing {
+		mgitHashes[mapping.GitHash] = mapping.MGitHash
+	}
+
+	commits, err := commitsParentsFirst(repo)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	mappings := existing
+	migrated := 0
+	for _, commit := range commits {
+		gitHash := commit.Hash.String()
+		if _, ok := mgitHashes[gitHash]; ok {
+			continue
+		}
+
+		pubkey := pubkeyFor(commit)
+		if pubkey == "" {
+			return nil, 0, fmt.Errorf("no pubkey for %s <%s> (commit %s); add them to the mailmap or pass --pubkey",
+				commit.Author.Name, commit.Author.Email, shortHash(gitHash))
+		}
+
+		// Parents outside the local history (shallow clones) fall back to
+		// their git hash, as commit does
+		parentMGitHashes := []string{}
+		for _, parent := range commit.ParentHashes {
+			if mgitHash, ok := mgitHashes[parent.String()]; ok {
+				parentMGitHashes = append(parentMGitHashes, mgitHash)
+			} else {
+				parentMGitHashes = append(parentMGitHashes, parent.String())
+			}
+		}
+
+		mgitCommit := mgitlib.CommitFromGit(commit, parentMGitHashes, pubkey)
+		mgitCommit.Metadata = map[string]string{"version": "1.0", "migrated": "true"}
+		mgitHash := mgitCommit.ComputeHash()
+		mgitCommit.MGitHash = mgitHash
+		if err := storage.StoreCommit(mgitCommit); err != nil {
+			return nil, 0, err
+		}
+
+		mgitHashes[gitHash] = mgitHash
+		mappings = append(mappings, NostrCommitMapping{GitHash: gitHash, MGitHash: mgitHash, Pubkey: pubkey, Source: mappingSourceLocal})
+		migrated++
+	}
+
+	if migrated > 0 {
+		if err := storage.WriteMappings(mappings); err != nil {
+			return nil, 0, err
+		}
+	}
+	return mappings, migrated, nil
+}
+
+// commitsParentsFirst returns every commit reachable from HEAD, branches and
+// tags, ordered so each commit comes after all of its parents
+func commitsParentsFirst(repo *git.Repository) ([]*object.Commit, error) {
+	tips := []plumbing.Hash{}
+	if head, err := repo.Head(); err == nil {
+		tips = append(tips, head.Hash())
+	}
+	refs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("error getting references: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsBranch() && !ref.Name().IsTag() {
+			return nil
+		}
+		hash := ref.Hash()
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		tips = append(tips, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error processing references: %w", err)
+	}
+
+	// Iterative post-order walk, so long histories cannot overflow the stack
+	ordered := []*object.Commit{}
+	done := map[plumbing.Hash]bool{}
+	for _, tip := range tips {
+		type frame struct {
+			commit *object.Commit
+			next   int // Index of the next parent to visit
+		}
+		if done[tip] {
+			continue
+		}
+		commit, err := repo.CommitObject(tip)
+		if err != nil {
+			// Tags can point at trees or blobs
+			continue
+		}
+		done[tip] = true
+		stack := []*frame{{commit: commit}}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.next < len(top.commit.ParentHashes) {
+				parent := top.commit.ParentHashes[top.next]
+				top.next++
+				if done[parent] {
+					continue
+				}
+				done[parent] = true
+				parentCommit, err := repo.CommitObject(parent)
+				if err != nil {
+					// Missing from a shallow clone - the history stops here
+					continue
+				}
+				stack = append(stack, &frame{commit: parentCommit})
+				continue
+			}
+			ordered = append(ordered, top.commit)
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	return ordered, nil
+}
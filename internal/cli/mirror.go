@@ -0,0 +1,376 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Mirrors are plain git hosts (GitHub, GitLab, Gitea) that receive a copy of
+// the repository's git history. Each is a section of .mgit/config:
+//
+//	[mirror "github"]
+//		url = git@github.com:clinic/records.git
+//
+// A mirror gets the branches and tags only; MGit metadata (hash mappings,
+// nostr attributions) stays in .mgit and on the mgit server. Mirrors are
+// pushed after every successful mgit push unless mirror.auto is false, and
+// with git's own credentials (ssh keys, credential helpers), never the
+// mgit server's token. A mirror that has commits of its own is not
+// overwritten: the rejected branches are reported, and mgit mirror status
+// shows how each branch compares.
+
+const mirrorUsage = "Usage: mgit mirror list | add <git-url> [--name <name>] | remove <name> | push [<name>] [--force] | status [<name>]"
+
+// mirrorRefspecs are what a mirror receives
+var mirrorRefspecs = []string{"refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*"}
+
+// Mirror is a plain git host receiving the repository's history
+type Mirror struct {
+	Name string
+	URL  string
+}
+
+// mirrorSection returns the config section holding a mirror
+func mirrorSection(name string) string {
+	return fmt.Sprintf("mirror \"%s\"", name)
+}
+
+// loadMirrors reads the mirrors in the repository's config, by name
+func loadMirrors() ([]*Mirror, error) {
+	config, err := LoadConfig(GetConfigFilePath(false))
+	if err != nil {
+		return nil, err
+	}
+	mirrors := []*Mirror{}
+	for section := range config.Sections {
+		if !strings.HasPrefix(section, "mirror \"") || !strings.HasSuffix(section, "\"") || len(section) <= len("mirror \"\"") {
+			continue
+		}
+		mirrors = append(mirrors, &Mirror{
+			Name: section[len("mirror \"") : len(section)-1],
+			URL:  config.Get(section, "url"),
+		})
+	}
+	sort.Slice(mirrors, func(i, j int) bool { return mirrors[i].Name < mirrors[j].Name })
+	return mirrors, nil
+}
+
+// selectMirrors returns the named mirror, or all of them for ""
+func selectMirrors(name string) ([]*Mirror, error) {
+	mirrors, err := loadMirrors()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		if len(mirrors) == 0 {
+			return nil, fmt.Errorf("no mirrors (add one with mgit mirror add <git-url>)")
+		}
+		return mirrors, nil
+	}
+	for _, mirror := range mirrors {
+		if mirror.Name == name {
+			return []*Mirror{mirror}, nil
+		}
+	}
+	return nil, fmt.Errorf("no mirror named '%s'", name)
+}
+
+// HandleMirror handles the mirror command
+func HandleMirror(args []string) {
+	if len(args) == 0 {
+		fmt.Println(mirrorUsage)
+		os.Exit(1)
+	}
+	if info, err := os.Stat(".mgit"); err != nil || !info.IsDir() {
+		fmt.Println("Error: not at the top of an mgit repository")
+		os.Exit(1)
+	}
+	var err error
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		err = listMirrors()
+	case args[0] == "add" && len(args) == 2:
+		err = addMirror(args[1], "")
+	case args[0] == "add" && len(args) == 4 && args[2] == "--name":
+		err = addMirror(args[1], args[3])
+	case args[0] == "remove" && len(args) == 2:
+		err = removeMirror(args[1])
+	case args[0] == "push" && len(args) <= 3:
+		name, force := "", false
+		for _, arg := range args[1:] {
+			if arg == "--force" {
+				force = true
+			} else if name == "" && !strings.HasPrefix(arg, "-") {
+				name = arg
+			} else {
+				fmt.Println(mirrorUsage)
+				os.Exit(1)
+			}
+		}
+		err = pushMirrorsCommand(name, force)
+	case args[0] == "status" && len(args) <= 2:
+		name := ""
+		if len(args) == 2 {
+			name = args[1]
+		}
+		err = mirrorStatus(name)
+	default:
+		fmt.Println(mirrorUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// listMirrors prints the mirrors
+func listMirrors() error {
+	mirrors, err := loadMirrors()
+	if err != nil {
+		return err
+	}
+	if len(mirrors) == 0 {
+		fmt.Println("No mirrors (add one with mgit mirror add <git-url>)")
+		return nil
+	}
+	for _, mirror := range mirrors {
+		fmt.Printf("%-12s %s\n", mirror.Name, mirror.URL)
+	}
+	if !GetConfigBool("mirror.auto", true) {
+		fmt.Println("(mirror.auto is false: push them with mgit mirror push)")
+	}
+	return nil
+}
+
+// scpLikeURL matches git's user@host:path form and captures the host
+var scpLikeURL = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):`)
+
+// mirrorHostName derives a mirror name from its URL's host, such as github
+// for git@github.com:clinic/records.git
+func mirrorHostName(gitURL string) string {
+	host := ""
+	if u, err := url.Parse(gitURL); err == nil && u.Host != "" {
+		host = u.Hostname()
+	} else if m := scpLikeURL.FindStringSubmatch(gitURL); m != nil {
+		host = m[1]
+	}
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	if dot := strings.LastIndex(host, "."); dot > 0 {
+		host = host[:dot]
+	}
+	if i := strings.LastIndex(host, "."); i >= 0 {
+		host = host[i+1:]
+	}
+	if !keyNamePattern.MatchString(host) {
+		return "mirror"
+	}
+	return host
+}
+
+// addMirror records a mirror
+func addMirror(gitURL, name string) error {
+	if name == "" {
+		name = mirrorHostName(gitURL)
+	}
+	if !keyNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid mirror name '%s' (use letters, digits, '.', '_' and '-')", name)
+	}
+	if remote, err := getRepo().Remote("origin"); err == nil && len(remote.Config().URLs) > 0 && remote.Config().URLs[0] == gitURL {
+		return fmt.Errorf("%s is the origin remote, not a mirror", gitURL)
+	}
+	err := UpdateConfig(GetConfigFilePath(false), func(config *Config) error {
+		section := mirrorSection(name)
+		if _, exists := config.Sections[section]; exists {
+			return fmt.Errorf("there is already a mirror named '%s' (choose another with --name)", name)
+		}
+		config.Set(section, "url", gitURL)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added mirror '%s' (%s)\n", name, gitURL)
+	if GetConfigBool("mirror.auto", true) {
+		fmt.Println("It is pushed after each mgit push; run mgit mirror push to push it now")
+	}
+	return nil
+}
+
+// removeMirror forgets a mirror; the host keeps what it has
+func removeMirror(name string) error {
+	err := UpdateConfig(GetConfigFilePath(false), func(config *Config) error {
+		section := mirrorSection(name)
+		if _, exists := config.Sections[section]; !exists {
+			return fmt.Errorf("no mirror named '%s'", name)
+		}
+		delete(config.Sections, section)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed mirror '%s'\n", name)
+	return nil
+}
+
+// pushMirror pushes the branches and tags to a mirror. It returns the refs
+// the mirror rejected because it has commits they don't.
+func pushMirror(mirror *Mirror, force bool) ([]string, error) {
+	args := []string{"push", "--porcelain", mirror.URL}
+	for _, refspec := range mirrorRefspecs {
+		if force {
+			refspec = "+" + refspec
+		}
+		args = append(args, refspec)
+	}
+	cmd := exec.Command("git", args...)
+	out, err := cmd.Output()
+	rejected := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		// <flag>\t<from>:<to>\t<summary>
+		fields := strings.Split(line, "\t")
+		if len(fields) >= 3 && fields[0] == "!" {
+			ref := fields[1][strings.Index(fields[1], ":")+1:]
+			rejected = append(rejected, strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/"))
+		}
+	}
+	if err != nil && len(rejected) == 0 {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("git push: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return rejected, nil
+}
+
+// pushMirrorsCommand pushes the named mirror, or all of them
+func pushMirrorsCommand(name string, force bool) error {
+	mirrors, err := selectMirrors(name)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, mirror := range mirrors {
+		rejected, err := pushMirror(mirror, force)
+		switch {
+		case err != nil:
+			fmt.Printf("%s: %s\n", mirror.Name, err)
+			failed++
+		case len(rejected) > 0:
+			fmt.Printf("%s: diverged on %s; the mirror has commits this repository lacks (see mgit mirror status, or push with --force)\n",
+				mirror.Name, strings.Join(rejected, ", "))
+			failed++
+		default:
+			fmt.Printf("%s: up to date\n", mirror.Name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d mirrors not updated", failed, len(mirrors))
+	}
+	return nil
+}
+
+// autoPushMirrors pushes every mirror after an mgit push, unless mirror.auto
+// is false. Problems are warnings: the push to the mgit server stands.
+func autoPushMirrors() {
+	if !GetConfigBool("mirror.auto", true) {
+		return
+	}
+	mirrors, err := loadMirrors()
+	if err != nil || len(mirrors) == 0 {
+		return
+	}
+	for _, mirror := range mirrors {
+		rejected, err := pushMirror(mirror, false)
+		switch {
+		case err != nil:
+			fmt.Printf("Warning: mirror %s not updated: %s\n", mirror.Name, err)
+		case len(rejected) > 0:
+			fmt.Printf("Warning: mirror %s has diverged on %s (see mgit mirror status)\n", mirror.Name, strings.Join(rejected, ", "))
+		default:
+			infof("Mirrored to %s\n", mirror.Name)
+		}
+	}
+}
+
+// mirrorStatus compares each branch and tag with what the mirror has
+func mirrorStatus(name string) error {
+	mirrors, err := selectMirrors(name)
+	if err != nil {
+		return err
+	}
+	local, err := gitRefHashes(exec.Command("git", "for-each-ref", "--format=%(objectname) %(refname)", "refs/heads", "refs/tags"))
+	if err != nil {
+		return fmt.Errorf("error listing refs: %w", err)
+	}
+	for _, mirror := range mirrors {
+		fmt.Printf("%s (%s)\n", mirror.Name, mirror.URL)
+		remote, err := gitRefHashes(exec.Command("git", "ls-remote", "--heads", "--tags", "--refs", mirror.URL))
+		if err != nil {
+			fmt.Printf("  unreachable: %s\n", err)
+			continue
+		}
+		refs := []string{}
+		for ref := range local {
+			refs = append(refs, ref)
+		}
+		for ref := range remote {
+			if _, exists := local[ref]; !exists {
+				refs = append(refs, ref)
+			}
+		}
+		sort.Strings(refs)
+		for _, ref := range refs {
+			fmt.Printf("  %-20s %s\n", strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")+":",
+				compareMirrorRef(local[ref], remote[ref]))
+		}
+	}
+	return nil
+}
+
+// compareMirrorRef describes how a mirror's ref compares with ours
+func compareMirrorRef(ours, theirs string) string {
+	switch {
+	case ours == theirs:
+		return "in sync"
+	case theirs == "":
+		return "not on the mirror"
+	case ours == "":
+		return "only on the mirror"
+	}
+	if exec.Command("git", "cat-file", "-e", theirs+"^{commit}").Run() != nil {
+		return "diverged: the mirror has commits this repository lacks"
+	}
+	if exec.Command("git", "merge-base", "--is-ancestor", theirs, ours).Run() == nil {
+		count, _ := exec.Command("git", "rev-list", "--count", theirs+".."+ours).Output()
+		return fmt.Sprintf("%s commit(s) behind (mgit mirror push)", strings.TrimSpace(string(count)))
+	}
+	if exec.Command("git", "merge-base", "--is-ancestor", ours, theirs).Run() == nil {
+		return "ahead of this repository: the mirror has commits of its own"
+	}
+	return "diverged: both have commits the other lacks"
+}
+
+// gitRefHashes runs a git command printing "<hash> <ref>" lines
+func gitRefHashes(cmd *exec.Cmd) (map[string]string, error) {
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	refs := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// NIP-19 TLV types in an naddr
+const (
+	tlvSpecial = 0 // The "d" identifier
+	tlvRelay   = 1
+	tlvAuthor  = 2
+	tlvKind    = 3
+)
+
+// NostrAddress is a NIP-19 naddr: a pointer to a replaceable event such as a
+// repository announcement
+type NostrAddress struct {
+	Identifier string
+	Pubkey     string // Hex
+	Kind       int
+	Relays     []string
+}
+
+// parseNaddr decodes an naddr, with or without a "nostr:" prefix
+func parseNaddr(value string) (*NostrAddress, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "nostr:")
+	hrp, data, err := nostr.Bech32Decode(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid naddr: %w", err)
+	}
+	if hrp != "naddr" {
+		return nil, fmt.Errorf("invalid naddr: expected an naddr, got %s", hrp)
+	}
+
+	addr := &NostrAddress{Kind: -1}
+	haveIdentifier := false
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return nil, fmt.Errorf("invalid naddr: truncated")
+		}
+		typ, value := data[0], data[2:2+int(data[1])]
+		data = data[2+int(data[1]):]
+		switch typ {
+		case tlvSpecial:
+			addr.Identifier = string(value)
+			haveIdentifier = true
+		case tlvRelay:
+			addr.Relays = append(addr.Relays, string(value))
+		case tlvAuthor:
+			if len(value) != 32 {
+				return nil, fmt.Errorf("invalid naddr: bad author")
+			}
+			addr.Pubkey = hex.EncodeToString(value)
+		case tlvKind:
+			if len(value) != 4 {
+				return nil, fmt.Errorf("invalid naddr: bad kind")
+			}
+			addr.Kind = int(binary.BigEndian.Uint32(value))
+		}
+	}
+	if !haveIdentifier || addr.Pubkey == "" || addr.Kind < 0 {
+		return nil, fmt.Errorf("invalid naddr: missing identifier, author or kind")
+	}
+	return addr, nil
+}
+
+// Encode returns the address as an naddr
+func (a *NostrAddress) Encode() (string, error) {
+	author, err := hex.DecodeString(a.Pubkey)
+	if err != nil || len(author) != 32 {
+		return "", fmt.Errorf("invalid author pubkey")
+	}
+	if len(a.Identifier) > 255 {
+		return "", fmt.Errorf("identifier too long for an naddr")
+	}
+	data := []byte{tlvSpecial, byte(len(a.Identifier))}
+	data = append(data, a.Identifier...)
+	for _, relay := range a.Relays {
+		if len(relay) > 255 {
+			continue
+		}
+		data = append(append(data, tlvRelay, byte(len(relay))), relay...)
+	}
+	data = append(append(data, tlvAuthor, 32), author...)
+	kind := make([]byte, 4)
+	binary.BigEndian.PutUint32(kind, uint32(a.Kind))
+	data = append(append(data, tlvKind, 4), kind...)
+	return nostr.Bech32Encode("naddr", data)
+}
+
+// Coordinate returns the address as an "a" tag value: <kind>:<pubkey>:<d>
+func (a *NostrAddress) Coordinate() string {
+	return fmt.Sprintf("%d:%s:%s", a.Kind, a.Pubkey, a.Identifier)
+}
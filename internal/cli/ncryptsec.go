@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// Secret keys are stored encrypted as NIP-49 ncryptsec strings: the bech32
+// encoding of
+//
+//	version (0x02) | log2(scrypt N) | salt (16) | nonce (24) | key security (1) | ciphertext (48)
+//
+// where the ciphertext is the 32-byte key sealed with XChaCha20-Poly1305
+// under scrypt(passphrase, salt, N, r=8, p=1), with the key security byte as
+// associated data. Other nostr clients can import them. Passphrases are used
+// as given, without NIP-49's NFKC normalization, which only changes
+// non-ASCII passphrases.
+
+const (
+	ncryptsecVersion = 0x02
+	// ncryptsecLogN is the scrypt cost for new keys: 2^16 takes 64 MiB and
+	// a fraction of a second
+	ncryptsecLogN = 16
+	// ncryptsecUnknownSecurity says nothing is known about how the key has
+	// been handled
+	ncryptsecUnknownSecurity = 0x02
+)
+
+// encryptNcryptsec encrypts a secret key with a passphrase
+func encryptNcryptsec(secret []byte, passphrase string) (string, error) {
+	if len(secret) != 32 {
+		return "", fmt.Errorf("invalid secret key")
+	}
+	salt := make([]byte, 16)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<ncryptsecLogN, 8, 1, 32)
+	if err != nil {
+		return "", err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+	ad := []byte{ncryptsecUnknownSecurity}
+
+	data := []byte{ncryptsecVersion, ncryptsecLogN}
+	data = append(data, salt...)
+	data = append(data, nonce...)
+	data = append(data, ad...)
+	data = aead.Seal(data, nonce, secret, ad)
+	return nostr.Bech32Encode("ncryptsec", data)
+}
+
+// decryptNcryptsec decrypts an ncryptsec with its passphrase
+func decryptNcryptsec(value, passphrase string) ([]byte, error) {
+	hrp, data, err := nostr.Bech32Decode(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ncryptsec: %w", err)
+	}
+	if hrp != "ncryptsec" || len(data) != 91 {
+		return nil, fmt.Errorf("invalid ncryptsec")
+	}
+	if data[0] != ncryptsecVersion {
+		return nil, fmt.Errorf("unsupported ncryptsec version %d", data[0])
+	}
+	logN := data[1]
+	if logN < 1 || logN > 22 {
+		return nil, fmt.Errorf("unsupported ncryptsec scrypt cost 2^%d", logN)
+	}
+	salt, nonce, ad, ciphertext := data[2:18], data[18:42], data[42:43], data[43:]
+
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<logN, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase")
+	}
+	return secret, nil
+}
+
+// stdinLines reads prompted answers; it is shared so a line read ahead for
+// one prompt is there for the next
+var stdinLines = bufio.NewReader(os.Stdin)
+
+// readPassphrase reads a passphrase from MGIT_KEY_PASSPHRASE or, after
+// prompting, from the terminal without echoing it
+func readPassphrase(prompt string) (string, error) {
+	return readPassphraseFrom("MGIT_KEY_PASSPHRASE", prompt)
+}
+
+// readPassphraseFrom reads a passphrase from the environment variable env
+// or, after prompting, from the terminal
+func readPassphraseFrom(env, prompt string) (string, error) {
+	if value, exists := os.LookupEnv(env); exists {
+		return value, nil
+	}
+	return readSecretLine(prompt)
+}
+
+// readSecretLine prompts on stderr and reads a line from stdin, turning off
+// the terminal's echo while it is typed
+func readSecretLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		stty := exec.Command("stty", "-echo")
+		stty.Stdin = os.Stdin
+		if stty.Run() == nil {
+			defer func() {
+				restore := exec.Command("stty", "echo")
+				restore.Stdin = os.Stdin
+				restore.Run()
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	line, err := stdinLines.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("nothing was entered")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readNewPassphrase asks for a new passphrase twice, unless it comes from
+// MGIT_KEY_PASSPHRASE
+func readNewPassphrase() (string, error) {
+	return readNewPassphraseFrom("MGIT_KEY_PASSPHRASE")
+}
+
+// readNewPassphraseFrom asks for a new passphrase twice, unless it comes
+// from the environment variable env
+func readNewPassphraseFrom(env string) (string, error) {
+	if value, exists := os.LookupEnv(env); exists {
+		return value, nil
+	}
+	passphrase, err := readSecretLine("New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("the passphrase must not be empty")
+	}
+	again, err := readSecretLine("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// log and show name authors by their NIP-05 identifier (name@domain) rather
+// than their npub when the kind 0 metadata their pubkey published names one
+// and that domain's /.well-known/nostr.json maps the name back to the
+// pubkey. Results are cached in ~/.mgitconfig/nip05.json for
+// nip05.cacheTTL (default 24h), and lookups that failed are retried after an
+// hour, so relays and domains are only asked about authors not seen lately.
+// Resolution is off with --no-resolve, with nip05.resolve set to false, or
+// when no relays are configured.
+
+const (
+	// defaultNIP05CacheTTL is how long a lookup is trusted; override with
+	// nip05.cacheTTL
+	defaultNIP05CacheTTL = 24 * time.Hour
+	// nip05RetryAfter is how long a lookup that failed (an unreachable relay
+	// or domain) is remembered
+	nip05RetryAfter = time.Hour
+	// nip05Timeout bounds each relay query and nostr.json fetch, shorter
+	// than nostr.relayTimeout as it holds up history output
+	nip05Timeout = 5 * time.Second
+)
+
+// nip05NamePattern is what the name part of an identifier may contain
+var nip05NamePattern = regexp.MustCompile(`^[a-z0-9._-]+$`)
+
+// nip05CacheEntry is the cached lookup of one pubkey
+type nip05CacheEntry struct {
+	Identifier string    `json:"nip05,omitempty"`
+	Checked    time.Time `json:"checked"`
+	Failed     bool      `json:"failed,omitempty"`
+}
+
+// NIP05Resolver looks up and caches authors' NIP-05 identifiers
+type NIP05Resolver struct {
+	relays []string
+	pool   *RelayPool
+	ttl    time.Duration
+	path   string
+
+	mu    sync.Mutex
+	cache map[string]nip05CacheEntry
+}
+
+var (
+	// nip05Disabled is set by --no-resolve
+	nip05Disabled bool
+
+	sharedNIP05Resolver     *NIP05Resolver
+	sharedNIP05ResolverOnce sync.Once
+)
+
+// currentNIP05Resolver returns the command's resolver, or nil when
+// resolution is off
+func currentNIP05Resolver() *NIP05Resolver {
+	sharedNIP05ResolverOnce.Do(func() {
+		if nip05Disabled || !GetConfigBool("nip05.resolve", true) {
+			return
+		}
+		relays := configuredRelays()
+		if len(relays) == 0 {
+			return
+		}
+		ttl := GetConfigDuration("nip05.cacheTTL", defaultNIP05CacheTTL)
+		if ttl < 0 {
+			ttl = defaultNIP05CacheTTL
+		}
+		pool := newRelayPool()
+		pool.Timeout = nip05Timeout
+		pool.Retries = 0
+		resolver := &NIP05Resolver{
+			relays: relays,
+			pool:   pool,
+			ttl:    ttl,
+			path:   filepath.Join(filepath.Dir(GetConfigFilePath(true)), "nip05.json"),
+			cache:  map[string]nip05CacheEntry{},
+		}
+		if data, err := os.ReadFile(resolver.path); err == nil {
+			if err := json.Unmarshal(data, &resolver.cache); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: ignoring NIP-05 cache: %s\n", err)
+				resolver.cache = map[string]nip05CacheEntry{}
+			}
+		}
+		sharedNIP05Resolver = resolver
+	})
+	return sharedNIP05Resolver
+}
+
+// displayPubkey returns how to show an author's pubkey: their verified
+// NIP-05 identifier when there is one, else the pubkey itself
+func displayPubkey(pubkey string) string {
+	resolver := currentNIP05Resolver()
+	if resolver == nil {
+		return pubkey
+	}
+	if identifier := resolver.Resolve(pubkey); identifier != "" {
+		return identifier
+	}
+	return pubkey
+}
+
+// Resolve returns a pubkey's verified NIP-05 identifier, or "" if it has
+// none or it could not be checked
+func (r *NIP05Resolver) Resolve(pubkey string) string {
+	hexKey, err := nostr.PubkeyHex(pubkey)
+	if err != nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.cache[hexKey]; ok {
+		age := time.Since(entry.Checked)
+		if (!entry.Failed && age < r.ttl) || (entry.Failed && age < nip05RetryAfter) {
+			return entry.Identifier
+		}
+	}
+	identifier, err := r.lookup(hexKey)
+	r.cache[hexKey] = nip05CacheEntry{Identifier: identifier, Checked: time.Now().UTC(), Failed: err != nil}
+	if err := r.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save NIP-05 cache: %s\n", err)
+	}
+	return identifier
+}
+
+// lookup finds the identifier in a pubkey's newest kind 0 metadata and
+// verifies it. It returns an error only if the answer may differ next time.
+func (r *NIP05Resolver) lookup(hexKey string) (string, error) {
+	filter := NostrFilter{"kinds": []int{nostr.KindMetadata}, "authors": []string{hexKey}}
+	// Lookups are incidental to the command showing the name and bounded by
+	// the pool's timeout, so they leave Ctrl-C to end the command as before
+	events, err := r.pool.Query(context.Background(), filter, r.relays)
+	if err != nil {
+		return "", err
+	}
+	var newest *nostr.Event
+	for _, event := range events {
+		if event.Pubkey == hexKey && (newest == nil || event.CreatedAt > newest.CreatedAt) {
+			newest = event
+		}
+	}
+	if newest == nil {
+		return "", nil
+	}
+	var metadata struct {
+		NIP05 string `json:"nip05"`
+	}
+	if json.Unmarshal([]byte(newest.Content), &metadata) != nil || metadata.NIP05 == "" {
+		return "", nil
+	}
+	return verifyNIP05(metadata.NIP05, hexKey)
+}
+
+// verifyNIP05 checks that an identifier's domain maps its name to the
+// pubkey, and returns the identifier as it is shown: just the domain for
+// the name "_"
+func verifyNIP05(identifier, hexKey string) (string, error) {
+	name, domain, found := strings.Cut(strings.ToLower(strings.TrimSpace(identifier)), "@")
+	if !found || !nip05NamePattern.MatchString(name) || domain == "" || strings.ContainsAny(domain, "/?#@") {
+		return "", nil
+	}
+
+	address := fmt.Sprintf("https://%s/.well-known/nostr.json?name=%s", domain, url.QueryEscape(name))
+	client := &http.Client{
+		Timeout: nip05Timeout,
+		// NIP-05 has clients ignore redirects
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(address)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", address, resp.Status)
+	}
+
+	var document struct {
+		Names map[string]string `json:"names"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return "", nil
+	}
+	if !strings.EqualFold(document.Names[name], hexKey) {
+		return "", nil
+	}
+	if name == "_" {
+		return domain, nil
+	}
+	return name + "@" + domain, nil
+}
+
+// save writes the cache
+func (r *NIP05Resolver) save() error {
+	data, err := json.MarshalIndent(r.cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return fsutil.WriteFile(r.path, data)
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// unlockedSecretKey is the signing key once loaded, so its passphrase is
+// asked for once per command
+var unlockedSecretKey []byte
+
+// loadNostrSecretKey reads the signing key: nostr.secretKey (an nsec,
+// 64-character hex string or passphrase-protected ncryptsec), or else the
+// key store's key named by nostr.key
+func loadNostrSecretKey() ([]byte, error) {
+	if unlockedSecretKey != nil {
+		return unlockedSecretKey, nil
+	}
+	var secret []byte
+	var err error
+	value := strings.TrimSpace(GetConfigValue("nostr.secretKey", ""))
+	switch {
+	case strings.HasPrefix(value, "ncryptsec1"):
+		var passphrase string
+		if passphrase, err = readPassphrase("Passphrase for nostr.secretKey: "); err == nil {
+			secret, err = decryptNcryptsec(value, passphrase)
+		}
+	case value != "":
+		secret, err = parseNostrSecretKey(value)
+	case GetConfigValue("nostr.key", "") != "":
+		name := GetConfigValue("nostr.key", "")
+		var key *StoredKey
+		if key, err = loadStoredKey(name); err == nil && key.Rotation != nil {
+			return nil, fmt.Errorf("key '%s' was retired on %s in favour of %s (see mgit key list)",
+				name, key.Rotation.When().Format("2006-01-02"), canonicalNostrPubKey(key.Rotation.NewKey()))
+		}
+		secret, err = unlockStoredKey(name)
+	default:
+		return nil, fmt.Errorf("no nostr secret key configured (create one with mgit keygen, or set nostr.secretKey)")
+	}
+	if err != nil {
+		return nil, err
+	}
+	unlockedSecretKey = secret
+	return secret, nil
+}
+
+// parseNostrSecretKey decodes an nsec or hex secret key
+func parseNostrSecretKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(strings.ToLower(value), "nsec1") {
+		hrp, data, err := nostr.Bech32Decode(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nsec: %w", err)
+		}
+		if hrp != "nsec" || len(data) != 32 {
+			return nil, fmt.Errorf("invalid nsec")
+		}
+		return data, nil
+	}
+
+	data, err := hex.DecodeString(value)
+	if err != nil || len(data) != 32 {
+		return nil, fmt.Errorf("secret key must be an nsec or 64 hex characters")
+	}
+	return data, nil
+}
@@ -0,0 +1,328 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	serverclient "github.com/imyjimmy/mgit/pkg/server-client"
+)
+
+// Notes attach key/value metadata (an encounter ID, a device ID, a review
+// status) to an MGit commit after the fact, without changing its hash. Each
+// commit's notes are a JSON object in .mgit/notes/<mgit hash>, keyed by note
+// name. A note records who set it and when; removing one leaves a tombstone
+// so the removal syncs too. Notes travel to and from the server alongside
+// the hash mappings on the metadata endpoint, and when both sides changed a
+// key the later change wins.
+
+const notesUsage = `Usage: mgit notes [list] <commit>
+       mgit notes get <commit> <key>
+       mgit notes set <commit> <key> <value>
+       mgit notes remove <commit> <key>
+       mgit notes push | pull`
+
+// Note is one key's value on a commit
+type Note = serverclient.Note
+
+// CommitNotes are the notes on one commit, by key
+type CommitNotes = serverclient.Notes
+
+// remoteMetadataEntry is one entry on the server's metadata endpoint: a
+// hash mapping, with the commit's notes alongside
+type remoteMetadataEntry = serverclient.MetadataEntry
+
+// notesPath returns the file holding a commit's notes
+func (s *MGitStorage) notesPath(mgitHash string) string {
+	return filepath.Join(s.RootDir, "notes", mgitHash)
+}
+
+// GetNotes returns the notes on an MGit commit, tombstones included
+func (s *MGitStorage) GetNotes(mgitHash string) (CommitNotes, error) {
+	data, err := os.ReadFile(s.notesPath(mgitHash))
+	if os.IsNotExist(err) {
+		return CommitNotes{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	notes := CommitNotes{}
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return nil, fmt.Errorf("failed to parse notes for %s: %w", shortHash(mgitHash), err)
+	}
+	return notes, nil
+}
+
+// UpdateNotes applies fn to a commit's notes and saves the result, with the
+// notes file locked throughout
+func (s *MGitStorage) UpdateNotes(mgitHash string, fn func(CommitNotes) error) error {
+	path := s.notesPath(mgitHash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create notes directory: %w", err)
+	}
+	lock, err := fsutil.LockFile(path, fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	notes, err := s.GetNotes(mgitHash)
+	if err != nil {
+		return err
+	}
+	if err := fn(notes); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(notes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode notes: %w", err)
+	}
+	if err := fsutil.WriteFile(path, data); err != nil {
+		return fmt.Errorf("failed to write notes: %w", err)
+	}
+	return nil
+}
+
+// notedCommits returns the MGit hashes of every commit with a notes file
+func (s *MGitStorage) notedCommits() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.RootDir, "notes"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes directory: %w", err)
+	}
+	hashes := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && isFullHash(entry.Name()) {
+			hashes = append(hashes, entry.Name())
+		}
+	}
+	return hashes, nil
+}
+
+// validNoteKey reports whether key can name a note: letters, digits, '.',
+// '_' and '-'
+func validNoteKey(key string) bool {
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return key != ""
+}
+
+// writeCommitNotes writes a commit's notes for show; nothing if it has none
+func writeCommitNotes(w io.Writer, storage *MGitStorage, mgitHash string) {
+	notes, err := storage.GetNotes(mgitHash)
+	if err != nil {
+		fmt.Fprintf(w, "Warning: %s\n\n", err)
+		return
+	}
+	keys := notes.Keys()
+	if len(keys) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Notes:")
+	for _, key := range keys {
+		fmt.Fprintf(w, "    %s: %s\n", key, notes[key].Value)
+	}
+	fmt.Fprintln(w)
+}
+
+// mergeRemoteNotes merges notes that came from the server into the local
+// ones. It returns how many commits' notes changed.
+func mergeRemoteNotes(storage *MGitStorage, entries []remoteMetadataEntry) (int, error) {
+	changed := 0
+	for _, entry := range entries {
+		if len(entry.Notes) == 0 || !isFullHash(entry.MGitHash) {
+			continue
+		}
+		updated := false
+		err := storage.UpdateNotes(entry.MGitHash, func(notes CommitNotes) error {
+			updated = notes.Merge(entry.Notes)
+			return nil
+		})
+		if err != nil {
+			return changed, err
+		}
+		if updated {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// HandleNotes handles the notes command
+func HandleNotes(args []string) {
+	if len(args) == 0 {
+		fmt.Println(notesUsage)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "push", "pull":
+		if len(args) != 1 {
+			fmt.Println(notesUsage)
+			os.Exit(1)
+		}
+		syncNotes(args[0] == "push")
+		return
+	case "list":
+		args = args[1:]
+		if len(args) != 1 {
+			fmt.Println(notesUsage)
+			os.Exit(1)
+		}
+	case "get", "remove":
+		if len(args) != 3 {
+			fmt.Println(notesUsage)
+			os.Exit(1)
+		}
+	case "set":
+		if len(args) != 4 {
+			fmt.Println(notesUsage)
+			os.Exit(1)
+		}
+	default:
+		if len(args) != 1 {
+			fmt.Println(notesUsage)
+			os.Exit(1)
+		}
+		args = []string{"list", args[0]}
+	}
+
+	storage := NewMGitStorage()
+	target, err := resolveMGitCommitHash(getRepo(), storage, args[1])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		notes, err := storage.GetNotes(target)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		for _, key := range notes.Keys() {
+			note := notes[key]
+			fmt.Printf("%s = %s\n", key, note.Value)
+			fmt.Printf("    set by %s on %s\n", orNone(note.Pubkey), note.When.Local().Format("Mon Jan 2 15:04:05 2006 -0700"))
+		}
+
+	case "get":
+		notes, err := storage.GetNotes(target)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		note, ok := notes[args[2]]
+		if !ok || note.Deleted {
+			fmt.Printf("Error: %s has no note '%s'\n", shortHash(target), args[2])
+			os.Exit(1)
+		}
+		fmt.Println(note.Value)
+
+	case "set", "remove":
+		key := args[2]
+		if !validNoteKey(key) {
+			fmt.Printf("Error: invalid note key '%s' (use letters, digits, '.', '_' and '-')\n", key)
+			os.Exit(1)
+		}
+		note := Note{Pubkey: GetNostrPubKey(), When: time.Now().UTC()}
+		if args[0] == "set" {
+			note.Value = args[3]
+		} else {
+			note.Deleted = true
+		}
+		err := storage.UpdateNotes(target, func(notes CommitNotes) error {
+			if current, ok := notes[key]; note.Deleted && (!ok || current.Deleted) {
+				return fmt.Errorf("%s has no note '%s'", shortHash(target), key)
+			}
+			notes[key] = note
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if note.Deleted {
+			fmt.Printf("Removed note %s from %s\n", key, shortHash(target))
+		} else {
+			fmt.Printf("Set note %s on %s\n", key, shortHash(target))
+		}
+	}
+}
+
+// syncNotes sends the local notes to the origin server's metadata endpoint,
+// or merges the server's notes into the local ones
+func syncNotes(push bool) {
+	repo := getRepo()
+	remote, err := repo.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 || !isServerURL(remote.Config().URLs[0]) {
+		fmt.Println("Error: notes sync needs an origin remote on an MGit server")
+		os.Exit(1)
+	}
+	remoteURL := remote.Config().URLs[0]
+	auth := authForRepo(remoteURL)
+	storage := NewMGitStorage()
+
+	if !push {
+		entries, err := fetchRemoteMetadata(commandContext(), remoteURL, auth)
+		if err != nil {
+			exitIfInterrupted(err)
+			fmt.Printf("Error fetching notes: %s\n", err)
+			os.Exit(1)
+		}
+		changed, err := mergeRemoteNotes(storage, entries)
+		if err != nil {
+			fmt.Printf("Error storing notes: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Updated notes on %d commit(s) from %s\n", changed, remoteURL)
+		return
+	}
+
+	// Notes go up with the mapping of the commit they are on, so the
+	// server can index them by either hash
+	hashes, err := storage.notedCommits()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	entries := []remoteMetadataEntry{}
+	for _, hash := range hashes {
+		mapping, ok, err := storage.Mappings().ByMGit(hash)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if !ok {
+			fmt.Printf("Warning: %s has notes but no mapping; not pushed\n", shortHash(hash))
+			continue
+		}
+		notes, err := storage.GetNotes(hash)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		mapping.Source = ""
+		entries = append(entries, remoteMetadataEntry{Mapping: mapping, Notes: notes})
+	}
+	if len(entries) == 0 {
+		fmt.Println("No notes to push")
+		return
+	}
+	if err := uploadRemoteMetadata(commandContext(), remoteURL, auth, entries); err != nil {
+		exitIfInterrupted(err)
+		fmt.Printf("Error pushing notes: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Pushed notes on %d commit(s) to %s\n", len(entries), remoteURL)
+}
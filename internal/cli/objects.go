@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// MGit objects use git's loose-object layout: objects/<aa>/<rest of hash>,
+// each file holding zlib("<type> <size>\x00<body>"). Commit bodies use the
+// canonical encoding (pkg/mgitlib) and reads recompute their hash from it;
+// annotations are JSON recording their own hash, and reads check that it
+// agrees with the file name. Objects written before compression was
+// introduced are plain JSON and are still readable; mgit fsck reports them.
+
+// objectPath returns where the object with the given full hash lives
+func (s *MGitStorage) objectPath(hash string) string {
+	return filepath.Join(s.RootDir, "objects", hash[:2], hash[2:])
+}
+
+// encodeObject compresses an object body together with its header
+func encodeObject(objType mgitlib.ObjectType, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	fmt.Fprintf(zw, "%s %d\x00", objType, len(body))
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeObject decompresses a stored object and checks its header. Legacy
+// uncompressed JSON objects are returned as is, with an empty type.
+func decodeObject(raw []byte) (mgitlib.ObjectType, []byte, error) {
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		return "", raw, nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return "", nil, fmt.Errorf("not a zlib stream: %w", err)
+	}
+	defer zr.Close()
+	// ReadAll also verifies the zlib checksum at the end of the stream
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, fmt.Errorf("corrupt zlib stream: %w", err)
+	}
+
+	nul := bytes.IndexByte(data, 0)
+	if nul == -1 {
+		return "", nil, fmt.Errorf("missing object header")
+	}
+	header := string(data[:nul])
+	typeName, sizeText, ok := strings.Cut(header, " ")
+	size, err := strconv.Atoi(sizeText)
+	if !ok || err != nil {
+		return "", nil, fmt.Errorf("malformed object header %q", header)
+	}
+	objType := mgitlib.ObjectType(typeName)
+	body := data[nul+1:]
+	if len(body) != size {
+		return "", nil, fmt.Errorf("object size mismatch: header says %d bytes, body has %d", size, len(body))
+	}
+	return objType, body, nil
+}
+
+// objectIdentity is the part of every object body that names the object
+type objectIdentity struct {
+	Type     mgitlib.ObjectType `json:"type"`
+	MGitHash string         `json:"mgit_hash"`
+	Hash     string         `json:"hash"`
+}
+
+// checkObjectBody verifies that a decoded body is the object stored under
+// hash, and returns its type
+func checkObjectBody(hash string, objType mgitlib.ObjectType, body []byte) (mgitlib.ObjectType, error) {
+	if mgitlib.IsEncodedCommit(body) {
+		if objType != "" && objType != mgitlib.CommitObject {
+			return "", fmt.Errorf("object header says %s but body is a commit", objType)
+		}
+		commit, err := mgitlib.DecodeCommit(body)
+		if err != nil {
+			return "", err
+		}
+		if computed := commit.ComputeHash(); computed != hash {
+			return "", fmt.Errorf("object stored as %s hashes to %s", hash, computed)
+		}
+		return mgitlib.CommitObject, nil
+	}
+
+	var id objectIdentity
+	if err := json.Unmarshal(body, &id); err != nil {
+		return "", fmt.Errorf("object body is not valid JSON: %w", err)
+	}
+	if id.Type == "" {
+		// Very old commits were written without a type
+		id.Type = mgitlib.CommitObject
+	}
+	if objType != "" && objType != id.Type {
+		return "", fmt.Errorf("object header says %s but body is a %s", objType, id.Type)
+	}
+
+	recorded := id.MGitHash
+	if id.Type == mgitlib.AnnotationObject {
+		recorded = id.Hash
+	}
+	if recorded != hash {
+		return "", fmt.Errorf("object stored as %s records hash %s", hash, recorded)
+	}
+	return id.Type, nil
+}
+
+// writeObject stores an object's body under its hash. The file is written
+// to a temporary name and renamed, so readers never see a partial object.
+func (s *MGitStorage) writeObject(hash string, objType mgitlib.ObjectType, body []byte) error {
+	if len(hash) < 4 {
+		return fmt.Errorf("invalid object hash %q", hash)
+	}
+	data, err := encodeObject(objType, body)
+	if err != nil {
+		return fmt.Errorf("failed to compress object: %w", err)
+	}
+
+	objPath := s.objectPath(hash)
+	if err := os.MkdirAll(filepath.Dir(objPath), 0755); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(objPath), "tmp_obj_")
+	if err != nil {
+		return fmt.Errorf("failed to create object file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write object file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write object file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), objPath); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write object file: %w", err)
+	}
+	return nil
+}
+
+// readObject reads, decompresses and checks the object stored under a full
+// hash, loose or packed
+func (s *MGitStorage) readObject(hash string) (mgitlib.ObjectType, []byte, error) {
+	raw, err := s.readStoredObject(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	objType, body, err := decodeObject(raw)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s is corrupt: %w", hash, err)
+	}
+	objType, err = checkObjectBody(hash, objType, body)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s is corrupt: %w", hash, err)
+	}
+	return objType, body, nil
+}
+
+// readStoredObject returns an object's stored (compressed) form, preferring
+// a loose copy over a packed one
+func (s *MGitStorage) readStoredObject(hash string) ([]byte, error) {
+	raw, err := os.ReadFile(s.objectPath(hash))
+	if os.IsNotExist(err) {
+		return s.readPackedObject(hash)
+	}
+	return raw, err
+}
+
+// ListObjects returns the hashes of every object, loose or packed
+func (s *MGitStorage) ListObjects() ([]string, error) {
+	hashes, err := s.ListLooseObjects()
+	if err != nil {
+		return nil, err
+	}
+	packed, err := s.listPackedObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, hash := range hashes {
+		seen[hash] = true
+	}
+	for _, hash := range packed {
+		if !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// ListLooseObjects returns the hashes of every loose object
+func (s *MGitStorage) ListLooseObjects() ([]string, error) {
+	objectsDir := filepath.Join(s.RootDir, "objects")
+	dirs, err := os.ReadDir(objectsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read objects directory: %w", err)
+	}
+
+	hashes := []string{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(objectsDir, dir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object directory: %w", err)
+		}
+		for _, file := range files {
+			if !file.IsDir() && !isTempObject(file.Name()) {
+				hashes = append(hashes, dir.Name()+file.Name())
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// isTempObject reports whether a file in an object directory is an
+// unfinished write
+func isTempObject(name string) bool {
+	return strings.HasPrefix(name, "tmp_obj_")
+}
@@ -0,0 +1,325 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// MGit packs bundle many objects into one file, like git's packfiles:
+//
+//	objects/pack/pack-<name>.pack  "MPAK", version, count, then per object
+//	                               its 20-byte hash, a 4-byte length and the
+//	                               object exactly as a loose file stores it
+//	                               (zlib of header and body), then the SHA-1
+//	                               of everything before
+//	objects/pack/pack-<name>.idx   "MIDX", version, count, then the hashes in
+//	                               sorted order, each with its 8-byte offset
+//	                               into the pack, then the pack's checksum and
+//	                               the SHA-1 of everything before
+//
+// All integers are big-endian. <name> is the SHA-1 of the sorted hashes.
+
+const (
+	packMagic   = "MPAK"
+	idxMagic    = "MIDX"
+	packVersion = 1
+)
+
+// mgitPack is one pack and its index
+type mgitPack struct {
+	path    string // Path of the .pack file
+	hashes  [][20]byte
+	offsets []uint64
+}
+
+// find returns the offset of hash in the pack
+func (p *mgitPack) find(hash [20]byte) (uint64, bool) {
+	i := sort.Search(len(p.hashes), func(i int) bool {
+		return bytes.Compare(p.hashes[i][:], hash[:]) >= 0
+	})
+	if i < len(p.hashes) && p.hashes[i] == hash {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// read returns the stored (compressed) form of the object at offset
+func (p *mgitPack) read(hash [20]byte, offset uint64) ([]byte, error) {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 24)
+	if _, err := file.ReadAt(header, int64(offset)); err != nil {
+		return nil, fmt.Errorf("failed to read pack entry: %w", err)
+	}
+	if !bytes.Equal(header[:20], hash[:]) {
+		return nil, fmt.Errorf("pack index points at the wrong entry for %x", hash)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[20:]))
+	if _, err := file.ReadAt(data, int64(offset)+24); err != nil {
+		return nil, fmt.Errorf("failed to read pack entry: %w", err)
+	}
+	return data, nil
+}
+
+// packSet caches the pack indexes of one objects directory for the process.
+// gc calls invalidate after replacing packs.
+type packSet struct {
+	dir string
+
+	mu     sync.Mutex
+	loaded bool
+	packs  []*mgitPack
+}
+
+var (
+	packSetsMu sync.Mutex
+	packSets   = map[string]*packSet{}
+)
+
+// packsFor returns the shared pack set for an objects/pack directory
+func packsFor(dir string) *packSet {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	packSetsMu.Lock()
+	defer packSetsMu.Unlock()
+
+	set, ok := packSets[dir]
+	if !ok {
+		set = &packSet{dir: dir}
+		packSets[dir] = set
+	}
+	return set
+}
+
+// list returns the packs, reading their indexes on first use
+func (ps *packSet) list() ([]*mgitPack, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.loaded {
+		return ps.packs, nil
+	}
+
+	entries, err := os.ReadDir(ps.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read pack directory: %w", err)
+	}
+	packs := []*mgitPack{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".idx") {
+			continue
+		}
+		pack, err := readPackIndex(filepath.Join(ps.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, pack)
+	}
+
+	ps.packs = packs
+	ps.loaded = true
+	return packs, nil
+}
+
+func (ps *packSet) invalidate() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.loaded = false
+	ps.packs = nil
+}
+
+// packDir returns the directory holding the storage's packs
+func (s *MGitStorage) packDir() string {
+	return filepath.Join(s.RootDir, "objects", "pack")
+}
+
+// readPackedObject returns the stored form of a packed object
+func (s *MGitStorage) readPackedObject(hash string) ([]byte, error) {
+	var key [20]byte
+	if _, err := hex.Decode(key[:], []byte(hash)); err != nil || len(hash) != 40 {
+		return nil, os.ErrNotExist
+	}
+	packs, err := packsFor(s.packDir()).list()
+	if err != nil {
+		return nil, err
+	}
+	for _, pack := range packs {
+		if offset, ok := pack.find(key); ok {
+			return pack.read(key, offset)
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// listPackedObjects returns the hashes of every packed object
+func (s *MGitStorage) listPackedObjects() ([]string, error) {
+	packs, err := packsFor(s.packDir()).list()
+	if err != nil {
+		return nil, err
+	}
+	hashes := []string{}
+	for _, pack := range packs {
+		for _, hash := range pack.hashes {
+			hashes = append(hashes, hex.EncodeToString(hash[:]))
+		}
+	}
+	return hashes, nil
+}
+
+// readPackIndex loads a pack's .idx file, checking its checksum
+func readPackIndex(idxPath string) (*mgitPack, error) {
+	data, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack index: %w", err)
+	}
+	if len(data) < 12+40 || string(data[:4]) != idxMagic {
+		return nil, fmt.Errorf("%s is not an MGit pack index", filepath.Base(idxPath))
+	}
+	if binary.BigEndian.Uint32(data[4:]) != packVersion {
+		return nil, fmt.Errorf("%s: unsupported pack index version", filepath.Base(idxPath))
+	}
+	sum := sha1.Sum(data[:len(data)-20])
+	if !bytes.Equal(sum[:], data[len(data)-20:]) {
+		return nil, fmt.Errorf("%s: index checksum mismatch", filepath.Base(idxPath))
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	if len(data) != 12+count*28+40 {
+		return nil, fmt.Errorf("%s: truncated pack index", filepath.Base(idxPath))
+	}
+	pack := &mgitPack{
+		path:    strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		hashes:  make([][20]byte, count),
+		offsets: make([]uint64, count),
+	}
+	for i := 0; i < count; i++ {
+		entry := data[12+i*28:]
+		copy(pack.hashes[i][:], entry[:20])
+		pack.offsets[i] = binary.BigEndian.Uint64(entry[20:])
+	}
+	return pack, nil
+}
+
+// writePack writes objects (hash -> stored form) to a new pack and index in
+// dir and returns the pack's path. Both files are written under temporary
+// names and renamed into place, index last.
+func writePack(dir string, objects map[string][]byte) (string, error) {
+	hashes := make([]string, 0, len(objects))
+	for hash := range objects {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	nameSum := sha1.New()
+	for _, hash := range hashes {
+		io.WriteString(nameSum, hash)
+	}
+	base := filepath.Join(dir, fmt.Sprintf("pack-%x", nameSum.Sum(nil)))
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	// The pack itself
+	var pack bytes.Buffer
+	pack.WriteString(packMagic)
+	binary.Write(&pack, binary.BigEndian, uint32(packVersion))
+	binary.Write(&pack, binary.BigEndian, uint32(len(hashes)))
+	offsets := make([]uint64, len(hashes))
+	for i, hash := range hashes {
+		raw, err := hex.DecodeString(hash)
+		if err != nil || len(raw) != 20 {
+			return "", fmt.Errorf("invalid object hash %q", hash)
+		}
+		offsets[i] = uint64(pack.Len())
+		pack.Write(raw)
+		binary.Write(&pack, binary.BigEndian, uint32(len(objects[hash])))
+		pack.Write(objects[hash])
+	}
+	packSum := sha1.Sum(pack.Bytes())
+	pack.Write(packSum[:])
+
+	// Its index
+	var idx bytes.Buffer
+	idx.WriteString(idxMagic)
+	binary.Write(&idx, binary.BigEndian, uint32(packVersion))
+	binary.Write(&idx, binary.BigEndian, uint32(len(hashes)))
+	for i, hash := range hashes {
+		raw, _ := hex.DecodeString(hash)
+		idx.Write(raw)
+		binary.Write(&idx, binary.BigEndian, offsets[i])
+	}
+	idx.Write(packSum[:])
+	idxSum := sha1.Sum(idx.Bytes())
+	idx.Write(idxSum[:])
+
+	if err := fsutil.WriteFile(base+".pack", pack.Bytes()); err != nil {
+		return "", err
+	}
+	if err := fsutil.WriteFile(base+".idx", idx.Bytes()); err != nil {
+		os.Remove(base + ".pack")
+		return "", err
+	}
+	return base + ".pack", nil
+}
+
+// verifyPack checks a pack's checksum against its index and that every
+// indexed entry is where the index says
+func verifyPack(pack *mgitPack) error {
+	file, err := os.Open(pack.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha1.New()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < 32 {
+		return fmt.Errorf("%s: truncated pack", filepath.Base(pack.path))
+	}
+	if _, err := io.Copy(hasher, bufio.NewReader(io.LimitReader(file, info.Size()-20))); err != nil {
+		return err
+	}
+	trailer := make([]byte, 20)
+	if _, err := file.ReadAt(trailer, info.Size()-20); err != nil {
+		return err
+	}
+	if !bytes.Equal(hasher.Sum(nil), trailer) {
+		return fmt.Errorf("%s: pack checksum mismatch", filepath.Base(pack.path))
+	}
+
+	idxData, err := os.ReadFile(strings.TrimSuffix(pack.path, ".pack") + ".idx")
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(idxData[len(idxData)-40:len(idxData)-20], trailer) {
+		return fmt.Errorf("%s: index does not belong to this pack", filepath.Base(pack.path))
+	}
+
+	for i, hash := range pack.hashes {
+		if _, err := pack.read(hash, pack.offsets[i]); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(pack.path), err)
+		}
+	}
+	return nil
+}
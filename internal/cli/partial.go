@@ -0,0 +1,310 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// A partial scope narrows a working copy to some subdirectories of the
+// repository. Only those directories are checked out (git sparse-checkout),
+// blobs elsewhere are fetched on demand (a blob:none promisor remote), and
+// status, log, add and commit only look at paths inside the scope. Commits
+// are still ordinary commits of the whole repository.
+
+const partialUsage = "Usage: mgit partial add <path-prefix> | remove <path-prefix> | list"
+
+// HandlePartial handles the partial command
+func HandlePartial(args []string) {
+	if len(args) == 0 {
+		fmt.Println(partialUsage)
+		os.Exit(1)
+	}
+
+	prefixes, err := partialScope(".")
+	if err != nil {
+		fmt.Printf("Error reading partial scope: %s\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		for _, prefix := range prefixes {
+			fmt.Println(prefix)
+		}
+		return
+	case "add", "remove":
+		if len(args) != 2 {
+			fmt.Println(partialUsage)
+			os.Exit(1)
+		}
+	default:
+		fmt.Println(partialUsage)
+		os.Exit(1)
+	}
+
+	prefix, err := normalizePartialPrefix(args[1])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if args[0] == "add" {
+		if err := requirePrefixInHead(getRepo(), prefix); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		for _, existing := range prefixes {
+			if existing == prefix {
+				fmt.Printf("%s is already in the partial scope\n", prefix)
+				return
+			}
+		}
+		prefixes = append(prefixes, prefix)
+	} else {
+		kept := []string{}
+		for _, existing := range prefixes {
+			if existing != prefix {
+				kept = append(kept, existing)
+			}
+		}
+		if len(kept) == len(prefixes) {
+			fmt.Printf("Error: %s is not in the partial scope\n", prefix)
+			os.Exit(1)
+		}
+		prefixes = kept
+	}
+
+	if err := setPartialScope(".", prefixes); err != nil {
+		fmt.Printf("Error updating partial scope: %s\n", err)
+		os.Exit(1)
+	}
+	if len(prefixes) == 0 {
+		fmt.Println("Partial scope cleared; the whole repository is checked out")
+	} else {
+		fmt.Printf("Partial scope: %s\n", strings.Join(prefixes, ", "))
+	}
+}
+
+// normalizePartialPrefix turns a user-supplied path into a slash-separated,
+// repository-relative directory prefix ending in "/"
+func normalizePartialPrefix(prefix string) (string, error) {
+	prefix = path.Clean(filepath.ToSlash(prefix))
+	if prefix == "." || prefix == "/" || strings.HasPrefix(prefix, "../") || prefix == ".." || path.IsAbs(prefix) {
+		return "", fmt.Errorf("'%s' is not a subdirectory of the repository", prefix)
+	}
+	return prefix + "/", nil
+}
+
+// requirePrefixInHead checks that prefix names a directory in the HEAD commit
+func requirePrefixInHead(repo *git.Repository, prefix string) error {
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("error getting HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("error getting HEAD commit: %w", err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("error getting HEAD tree: %w", err)
+	}
+	entry, err := tree.FindEntry(strings.TrimSuffix(prefix, "/"))
+	if err != nil || entry.Mode.IsFile() {
+		return fmt.Errorf("%s is not a directory in HEAD", prefix)
+	}
+	return nil
+}
+
+// partialScope returns the directory prefixes the working copy at dir is
+// scoped to, or nil for the whole repository
+func partialScope(dir string) ([]string, error) {
+	config, err := LoadConfig(filepath.Join(dir, ".mgit", "config"))
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for _, prefix := range strings.Split(config.Get("partial", "prefixes"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, nil
+}
+
+// setPartialScope records prefixes in the working copy's .mgit/config and
+// narrows (or, for no prefixes, restores) its checkout to match
+func setPartialScope(dir string, prefixes []string) error {
+	sort.Strings(prefixes)
+
+	configPath := filepath.Join(dir, ".mgit", "config")
+	err := UpdateConfig(configPath, func(config *Config) error {
+		if len(prefixes) == 0 {
+			delete(config.Sections["partial"], "prefixes")
+		} else {
+			config.Set("partial", "prefixes", strings.Join(prefixes, ","))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(prefixes) == 0 {
+		return runGitIn(dir, "sparse-checkout", "disable")
+	}
+
+	// Make later fetches skip blobs; the ones outside the scope are then
+	// downloaded only if something reads them
+	if out, err := exec.Command("git", "-C", dir, "remote", "get-url", "origin").Output(); err == nil && len(out) > 0 {
+		if err := runGitIn(dir, "config", "remote.origin.promisor", "true"); err != nil {
+			return err
+		}
+		if err := runGitIn(dir, "config", "remote.origin.partialclonefilter", "blob:none"); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"sparse-checkout", "set", "--cone"}
+	for _, prefix := range prefixes {
+		args = append(args, strings.TrimSuffix(prefix, "/"))
+	}
+	return runGitIn(dir, args...)
+}
+
+// runGitIn runs a git command in dir, returning its output on failure
+func runGitIn(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// inPartialScope reports whether a repository-relative path lies inside the
+// scope. An empty scope covers everything.
+func inPartialScope(file string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	file = filepath.ToSlash(file)
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(file, prefix) || file+"/" == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// commitTouchesScope reports whether a commit changed anything inside the
+// scope, by comparing the scoped subtrees with those of its parents. Only
+// trees are read, so this works without the blobs of a partial clone.
+func commitTouchesScope(commit *object.Commit, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return true
+	}
+	parents := []*object.Tree{}
+	err = commit.Parents().ForEach(func(parent *object.Commit) error {
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return err
+		}
+		parents = append(parents, parentTree)
+		return nil
+	})
+	if err != nil {
+		// Parents cut off by a shallow clone - count the commit as relevant
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		dir := strings.TrimSuffix(prefix, "/")
+		hash := subtreeHash(tree, dir)
+		if len(parents) == 0 && hash != "" {
+			return true
+		}
+		for _, parentTree := range parents {
+			if subtreeHash(parentTree, dir) != hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// subtreeHash returns the hash of the entry at dir in tree, or "" if absent
+func subtreeHash(tree *object.Tree, dir string) string {
+	entry, err := tree.FindEntry(dir)
+	if err != nil {
+		return ""
+	}
+	return entry.Hash.String()
+}
+
+// scopedStatus reports the status of the paths inside the scope. It asks the
+// git CLI, because go-git's status does not understand sparse checkouts and
+// reports every path outside the checkout as deleted.
+func scopedStatus(dir string, prefixes []string) (git.Status, error) {
+	args := []string{"status", "--porcelain", "-z", "--untracked-files=all", "--"}
+	for _, prefix := range prefixes {
+		args = append(args, strings.TrimSuffix(prefix, "/"))
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status: %w", err)
+	}
+
+	// Records are "XY path", NUL-terminated; renames and copies are followed
+	// by the original path
+	status := git.Status{}
+	records := strings.Split(string(output), "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if len(record) < 4 {
+			continue
+		}
+		status[record[3:]] = &git.FileStatus{
+			Staging:  git.StatusCode(record[0]),
+			Worktree: git.StatusCode(record[1]),
+		}
+		if record[0] == 'R' || record[0] == 'C' {
+			i++
+		}
+	}
+	return status, nil
+}
+
+// stagedOutsideScope lists staged changes to paths outside the scope
+func stagedOutsideScope(dir string, prefixes []string) ([]string, error) {
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+	cmd := exec.Command("git", "diff", "--cached", "--name-only", "-z")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	outside := []string{}
+	for _, file := range strings.Split(string(output), "\x00") {
+		if file != "" && !inPartialScope(file, prefixes) {
+			outside = append(outside, file)
+		}
+	}
+	return outside, nil
+}
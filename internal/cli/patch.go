@@ -0,0 +1,422 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// Patches travel by email as git format-patch makes them. mgit format-patch
+// adds the MGit side of each commit as mail headers, which git am ignores:
+//
+//	X-MGit-Hash: <mgit hash of the original commit>
+//	X-MGit-Pubkey: <author's nostr pubkey>
+//	X-MGit-Parents: <mgit hashes of its parents>
+//
+// mgit am applies a series with git am, then gives each new commit an MGit
+// commit attributed to the pubkey its patch carried, so authorship survives
+// the round trip. The new commits have new MGit hashes, since their parents
+// and committer differ. The pubkeys of a series in progress are kept in
+// .mgit/am, so mgit am --continue after resolving a conflict still has them.
+
+const (
+	formatPatchUsage = "Usage: mgit format-patch [-o <dir>] [--stdout] <git-format-patch-args>..."
+	amUsage          = "Usage: mgit am <mbox|patch>... | --continue | --skip | --abort"
+)
+
+// Patch mail headers
+const (
+	headerMGitHash    = "X-MGit-Hash"
+	headerMGitPubkey  = "X-MGit-Pubkey"
+	headerMGitParents = "X-MGit-Parents"
+)
+
+// patchSeparator is the first line of each patch git format-patch writes
+var patchSeparator = regexp.MustCompile(`^From [0-9a-f]{40} Mon Sep 17 00:00:00 2001$`)
+
+// AmPatch is what mgit am needs to know about one patch of a series: the
+// author git am will give its commit, and the pubkey to attribute it to
+type AmPatch struct {
+	Email  string    `json:"email"`
+	When   time.Time `json:"when"`
+	Pubkey string    `json:"pubkey,omitempty"`
+}
+
+// AmState is a patch series mgit am is applying
+type AmState struct {
+	Start   string    `json:"start"` // Git HEAD before the series
+	Patches []AmPatch `json:"patches"`
+}
+
+// HandleFormatPatch handles the format-patch command
+func HandleFormatPatch(args []string) {
+	// Patches are always written to a directory first so their headers can
+	// be added; --stdout prints them from a scratch one
+	outputDir := ""
+	toStdout := false
+	gitArgs := []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case (arg == "-o" || arg == "--output-directory") && i+1 < len(args):
+			i++
+			outputDir = args[i]
+		case strings.HasPrefix(arg, "--output-directory="):
+			outputDir = strings.TrimPrefix(arg, "--output-directory=")
+		case arg == "--stdout":
+			toStdout = true
+		default:
+			gitArgs = append(gitArgs, arg)
+		}
+	}
+	if len(gitArgs) == 0 {
+		fmt.Println(formatPatchUsage)
+		os.Exit(1)
+	}
+	if toStdout {
+		tmpDir, err := os.MkdirTemp("", "mgit-patches-")
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		defer os.RemoveAll(tmpDir)
+		outputDir = tmpDir
+	}
+	if outputDir == "" {
+		outputDir = "."
+	}
+
+	files, err := formatPatches(NewMGitStorage(), outputDir, gitArgs)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	for _, file := range files {
+		if !toStdout {
+			fmt.Println(file)
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+	}
+}
+
+// formatPatches runs git format-patch into outputDir and adds the X-MGit
+// headers to the patches, returning their paths in order
+func formatPatches(storage *MGitStorage, outputDir string, gitArgs []string) ([]string, error) {
+	cmd := exec.Command("git", append([]string{"format-patch", "-o", outputDir}, gitArgs...)...)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git format-patch failed: %w", err)
+	}
+	files := strings.Fields(string(output))
+	for _, file := range files {
+		if err := addMGitPatchHeaders(storage, file); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// addMGitPatchHeaders adds the X-MGit headers to a patch file for the
+// commit named on its first line. Cover letters and unmapped commits are
+// left as they are.
+func addMGitPatchHeaders(storage *MGitStorage, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	end := bytes.IndexByte(data, '\n')
+	if end == -1 || !patchSeparator.Match(data[:end]) {
+		return nil
+	}
+	gitHash := string(data[5:45])
+	mapping, ok, err := storage.Mappings().ByGit(gitHash)
+	if err != nil || !ok {
+		return err
+	}
+
+	headers := fmt.Sprintf("%s: %s\n%s: %s\n", headerMGitHash, mapping.MGitHash, headerMGitPubkey, mapping.Pubkey)
+	if commit, err := storage.GetCommit(mapping.MGitHash); err == nil && len(commit.ParentHashes) > 0 {
+		headers += fmt.Sprintf("%s: %s\n", headerMGitParents, strings.Join(commit.ParentHashes, " "))
+	}
+	patched := append(append(append([]byte{}, data[:end+1]...), headers...), data[end+1:]...)
+	return fsutil.WriteFile(path, patched)
+}
+
+// HandleApply handles the apply command: a raw diff is applied with git
+// apply, leaving any commit to mgit commit
+func HandleApply(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: mgit apply [<git-apply-options>] <patch>...")
+		os.Exit(1)
+	}
+	cmd := exec.Command("git", append([]string{"apply"}, args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// amPath returns the file holding the patch series being applied
+func (s *MGitStorage) amPath() string {
+	return filepath.Join(s.RootDir, "am")
+}
+
+// HandleAm handles the am command
+func HandleAm(args []string) {
+	if len(args) == 0 {
+		fmt.Println(amUsage)
+		os.Exit(1)
+	}
+
+	session := currentSession()
+	repo := session.MustRepo()
+	storage := session.Storage()
+	if err := storage.Initialize(); err != nil {
+		fmt.Printf("Error initializing MGit storage: %s\n", err)
+		os.Exit(1)
+	}
+
+	resuming := args[0] == "--continue" || args[0] == "--skip" || args[0] == "--abort"
+	if resuming && len(args) != 1 {
+		fmt.Println(amUsage)
+		os.Exit(1)
+	}
+	if !resuming {
+		if _, err := os.Stat(storage.amPath()); err == nil {
+			fmt.Println("Error: a patch series is already being applied (mgit am --continue, --skip or --abort)")
+			os.Exit(1)
+		}
+		state, err := readAmSeries(repo, args)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := saveAmState(storage, state); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	cmd := exec.Command("git", append([]string{"am"}, args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	amErr := cmd.Run()
+
+	lock := lockRepoOrExit("am")
+	var err error
+	if args[0] == "--abort" {
+		if amErr == nil {
+			err = abortAm(repo, storage)
+		}
+	} else {
+		// Whatever git am got through gets its MGit commits, even when it
+		// stopped on a conflict part way
+		err = recordAppliedPatches(repo, storage)
+	}
+	lock.Release()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if amErr != nil && gitAmInProgress() {
+		if args[0] != "--abort" {
+			fmt.Println("Resolve the patch, then run mgit am --continue (or --skip, or --abort)")
+		}
+		os.Exit(1)
+	}
+	os.Remove(storage.amPath())
+	if amErr != nil {
+		os.Exit(1)
+	}
+}
+
+// gitAmInProgress reports whether git am stopped part way through a series
+func gitAmInProgress() bool {
+	out, err := exec.Command("git", "rev-parse", "--git-path", "rebase-apply/applying").Output()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(strings.TrimSpace(string(out)))
+	return err == nil
+}
+
+// abortAm points the MGit branch back where the series started, as git am
+// --abort has done for the git one
+func abortAm(repo *git.Repository, storage *MGitStorage) error {
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("error getting HEAD: %w", err)
+	}
+	if mgitHash := GetMGitHashForCommit(head.Hash()); mgitHash != "" && head.Name().IsBranch() {
+		if err := storage.UpdateRef(head.Name().String(), mgitHash, "am: abort"); err != nil {
+			return err
+		}
+	}
+	syncMGitHead(repo, "am: abort")
+	return nil
+}
+
+// readAmSeries reads the author and pubkey of each patch in the given
+// mailboxes and patch files
+func readAmSeries(repo *git.Repository, paths []string) (*AmState, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("error getting HEAD: %w", err)
+	}
+	state := &AmState{Start: head.Hash().String()}
+	for _, path := range paths {
+		if strings.HasPrefix(path, "-") {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range splitMailbox(data) {
+			msg, err := mail.ReadMessage(bytes.NewReader(message))
+			if err != nil {
+				return nil, fmt.Errorf("%s: unreadable patch: %w", path, err)
+			}
+			patch := AmPatch{Pubkey: canonicalNostrPubKey(strings.TrimSpace(msg.Header.Get(headerMGitPubkey)))}
+			if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+				patch.Email = from.Address
+			}
+			if when, err := msg.Header.Date(); err == nil {
+				patch.When = when
+			}
+			state.Patches = append(state.Patches, patch)
+		}
+	}
+	return state, nil
+}
+
+// splitMailbox splits a mailbox of format-patch patches into messages,
+// without their "From <hash>" separator lines
+func splitMailbox(data []byte) [][]byte {
+	messages := [][]byte{}
+	var current []byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if patchSeparator.Match(line) {
+			if len(bytes.TrimSpace(current)) > 0 {
+				messages = append(messages, current)
+			}
+			current = nil
+			continue
+		}
+		current = append(append(current, line...), '\n')
+	}
+	if len(bytes.TrimSpace(current)) > 0 {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// saveAmState records the series being applied
+func saveAmState(storage *MGitStorage, state *AmState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode patch series: %w", err)
+	}
+	if err := fsutil.WriteFile(storage.amPath(), data); err != nil {
+		return fmt.Errorf("failed to write patch series: %w", err)
+	}
+	return nil
+}
+
+// recordAppliedPatches gives the commits git am made since the series began
+// their MGit commits, oldest first. Each is attributed to the pubkey of the
+// patch with its author and date, falling back to the identity map and then
+// user.pubkey.
+func recordAppliedPatches(repo *git.Repository, storage *MGitStorage) error {
+	data, err := os.ReadFile(storage.amPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read patch series: %w", err)
+	}
+	state := &AmState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("failed to parse patch series: %w", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("error getting HEAD: %w", err)
+	}
+	applied := []*object.Commit{}
+	stop := map[plumbing.Hash]bool{plumbing.NewHash(state.Start): true}
+	walkCommits(repo, []plumbing.Hash{head.Hash()}, stop, func(c *object.Commit) {
+		if GetMGitHashForCommit(c.Hash) == "" {
+			applied = append(applied, c)
+		}
+	})
+	refName := ""
+	if head.Name().IsBranch() {
+		refName = head.Name().String()
+	}
+
+	for i := len(applied) - 1; i >= 0; i-- {
+		commit := applied[i]
+		pubkey := ""
+		for j, patch := range state.Patches {
+			if strings.EqualFold(patch.Email, commit.Author.Email) && patch.When.Equal(commit.Author.When) {
+				pubkey = patch.Pubkey
+				state.Patches = append(state.Patches[:j], state.Patches[j+1:]...)
+				break
+			}
+		}
+		if pubkey == "" {
+			pubkey = currentIdentityMap().Resolve(commit.Author.Name, commit.Author.Email).Pubkey
+		}
+		if pubkey == "" {
+			pubkey = GetNostrPubKey()
+		}
+		if pubkey == "" {
+			fmt.Printf("Warning: no pubkey for %s; run mgit migrate once one is set\n", shortHash(commit.Hash.String()))
+			continue
+		}
+
+		parents := []string{}
+		for _, parent := range commit.ParentHashes {
+			if mgitHash := GetMGitHashForCommit(parent); mgitHash != "" {
+				parents = append(parents, mgitHash)
+			} else {
+				parents = append(parents, parent.String())
+			}
+		}
+		mgitCommit := mgitlib.CommitFromGit(commit, parents, pubkey)
+		mgitCommit.MGitHash = mgitCommit.ComputeHash()
+		if err := storage.RecordCommit(mgitCommit, pubkey, refName); err != nil {
+			return fmt.Errorf("error recording MGit commit for %s: %w", shortHash(commit.Hash.String()), err)
+		}
+		fmt.Printf("Recorded MGit commit %s for %s (%s)\n", shortHash(mgitCommit.MGitHash), commitSubject(commit.Message), pubkey)
+	}
+	return saveAmState(storage, state)
+}
@@ -0,0 +1,379 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// Patch series travel over nostr as NIP-34 patch events (kind 1617), one per
+// patch, each carrying the text mgit format-patch writes, X-MGit headers
+// included. They are addressed to a repository announcement with
+//
+//	["a", "30617:<owner>:<identifier>"]
+//	["p", "<owner>"]
+//	["commit", "<git hash>"], ["parent-commit", "<git hash>"]
+//
+// The first patch of a series is tagged ["t", "root"] and the others reply to
+// it with ["e", "<root id>", "", "reply"], so mgit fetch-patches can put the
+// series back together. Applying a series goes through mgit am, with the
+// event's signer as the pubkey of any patch that does not name one.
+
+const (
+	sendPatchUsage    = "Usage: mgit send-patch [--to <naddr>] [--relay <url>]... <git-format-patch-args>..."
+	fetchPatchesUsage = "Usage: mgit fetch-patches [--relay <url>]... [<naddr>] [--show <id> | --apply <id>]"
+)
+
+// PatchSeries is a root patch event and the patches replying to it, in order
+type PatchSeries struct {
+	Root    *nostr.Event
+	Patches []*nostr.Event
+}
+
+// HandleSendPatch handles the send-patch command
+func HandleSendPatch(args []string) {
+	to := ""
+	relays := []string{}
+	gitArgs := []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--to" && i+1 < len(args):
+			i++
+			to = args[i]
+		case arg == "--relay" && i+1 < len(args):
+			i++
+			relays = append(relays, args[i])
+		default:
+			gitArgs = append(gitArgs, arg)
+		}
+	}
+	if len(gitArgs) == 0 {
+		fmt.Println(sendPatchUsage)
+		os.Exit(1)
+	}
+
+	if err := sendPatches(to, relays, gitArgs); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// sendPatches formats the commits gitArgs select and publishes them as a
+// patch series to the repository at naddr (or the one announced from here)
+func sendPatches(to string, relays, gitArgs []string) error {
+	repo := getRepo()
+	storage := NewMGitStorage()
+	target, err := repoAddress(storage, to)
+	if err != nil {
+		return err
+	}
+	relays = repoRelays(target, relays)
+	if len(relays) == 0 {
+		return fmt.Errorf("no relays to send to (set nostr.relays or use --relay)")
+	}
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "mgit-send-patch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	files, err := formatPatches(storage, tmpDir, gitArgs)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no patches to send")
+	}
+
+	// Events are a second apart so the series sorts back into order
+	created := time.Now().Unix()
+	fmt.Printf("Sending %d patch(es) to %s\n", len(files), target.Identifier)
+	rootID := ""
+	for i, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		tags := [][]string{{"a", target.Coordinate()}, {"p", target.Pubkey}}
+		if end := bytes.IndexByte(content, '\n'); end != -1 && patchSeparator.Match(content[:end]) {
+			gitHash := string(content[5:45])
+			tags = append(tags, []string{"commit", gitHash})
+			if commit, err := repo.CommitObject(plumbing.NewHash(gitHash)); err == nil && len(commit.ParentHashes) > 0 {
+				tags = append(tags, []string{"parent-commit", commit.ParentHashes[0].String()})
+			}
+		}
+		if rootID == "" {
+			tags = append(tags, []string{"t", "root"})
+		} else {
+			tags = append(tags, []string{"e", rootID, "", "reply"})
+		}
+
+		event := nostr.NewEvent(nostr.KindPatch, tags, string(content))
+		event.CreatedAt = created + int64(i)
+		if err := event.Sign(secret); err != nil {
+			return fmt.Errorf("error signing patch: %w", err)
+		}
+		if rootID == "" {
+			rootID = event.ID
+		}
+
+		accepted := publishEvent(event, relays)
+		fmt.Printf("  %s %s (%d of %d relays)\n", shortHash(event.ID), patchEventSubject(event), accepted, len(relays))
+		if accepted == 0 {
+			return fmt.Errorf("no relay accepted patch %d of %d", i+1, len(files))
+		}
+	}
+	fmt.Printf("Sent series %s\n", shortHash(rootID))
+	return nil
+}
+
+// HandleFetchPatches handles the fetch-patches command
+func HandleFetchPatches(args []string) {
+	addr := ""
+	relays := []string{}
+	show := ""
+	apply := ""
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--relay" && i+1 < len(args):
+			i++
+			relays = append(relays, args[i])
+		case arg == "--show" && i+1 < len(args):
+			i++
+			show = args[i]
+		case arg == "--apply" && i+1 < len(args):
+			i++
+			apply = args[i]
+		case strings.HasPrefix(arg, "-") || addr != "":
+			fmt.Println(fetchPatchesUsage)
+			os.Exit(1)
+		default:
+			addr = arg
+		}
+	}
+	if show != "" && apply != "" {
+		fmt.Println(fetchPatchesUsage)
+		os.Exit(1)
+	}
+
+	storage := NewMGitStorage()
+	target, err := repoAddress(storage, addr)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	series, err := fetchPatchSeries(target, repoRelays(target, relays))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case show != "":
+		s, err := findPatchSeries(series, show)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		for _, patch := range s.Patches {
+			fmt.Print(patch.Content)
+			if !strings.HasSuffix(patch.Content, "\n") {
+				fmt.Println()
+			}
+		}
+	case apply != "":
+		s, err := findPatchSeries(series, apply)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := applyPatchSeries(s); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	default:
+		if len(series) == 0 {
+			fmt.Printf("No patches for %s\n", target.Identifier)
+			return
+		}
+		for i, s := range series {
+			if i > 0 {
+				fmt.Println()
+			}
+			printPatchSeries(s)
+		}
+	}
+}
+
+// fetchPatchSeries queries relays for the repository's patch events and
+// groups them into series, newest first. Patches replying to a root by
+// another author are left out.
+func fetchPatchSeries(target *NostrAddress, relays []string) ([]*PatchSeries, error) {
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("no relays to fetch from (set nostr.relays or use --relay)")
+	}
+	events, err := queryRelays(NostrFilter{
+		"kinds": []int{nostr.KindPatch},
+		"#a":    []string{target.Coordinate()},
+	}, relays)
+	if err != nil {
+		return nil, err
+	}
+
+	byRoot := map[string]*PatchSeries{}
+	replies := []*nostr.Event{}
+	for _, event := range events {
+		if event.Kind != nostr.KindPatch || eventTag(event, "a") != target.Coordinate() {
+			continue
+		}
+		if root := patchRootID(event); root != "" {
+			replies = append(replies, event)
+		} else {
+			byRoot[event.ID] = &PatchSeries{Root: event, Patches: []*nostr.Event{event}}
+		}
+	}
+	for _, event := range replies {
+		if s, ok := byRoot[patchRootID(event)]; ok && event.Pubkey == s.Root.Pubkey {
+			s.Patches = append(s.Patches, event)
+		}
+	}
+
+	series := []*PatchSeries{}
+	for _, s := range byRoot {
+		sort.SliceStable(s.Patches, func(i, j int) bool {
+			if s.Patches[i].CreatedAt != s.Patches[j].CreatedAt {
+				return s.Patches[i].CreatedAt < s.Patches[j].CreatedAt
+			}
+			return s.Patches[i] == s.Root
+		})
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Root.CreatedAt != series[j].Root.CreatedAt {
+			return series[i].Root.CreatedAt > series[j].Root.CreatedAt
+		}
+		return series[i].Root.ID < series[j].Root.ID
+	})
+	return series, nil
+}
+
+// patchRootID returns the root a patch event replies to, or "" for a root
+func patchRootID(event *nostr.Event) string {
+	for _, tag := range event.Tags {
+		if len(tag) > 1 && tag[0] == "t" && tag[1] == "root" {
+			return ""
+		}
+	}
+	for _, tag := range event.Tags {
+		if len(tag) > 3 && tag[0] == "e" && (tag[3] == "reply" || tag[3] == "root") {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// findPatchSeries finds the series whose root event ID starts with id
+func findPatchSeries(series []*PatchSeries, id string) (*PatchSeries, error) {
+	var found *PatchSeries
+	for _, s := range series {
+		if strings.HasPrefix(s.Root.ID, strings.ToLower(id)) {
+			if found != nil {
+				return nil, fmt.Errorf("series id %s is ambiguous", id)
+			}
+			found = s
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no patch series %s", id)
+	}
+	return found, nil
+}
+
+// printPatchSeries writes a series' root, author, date and patch subjects
+func printPatchSeries(s *PatchSeries) {
+	author, err := nostr.Npub(s.Root.Pubkey)
+	if err != nil {
+		author = s.Root.Pubkey
+	}
+	fmt.Printf("series %s (%d patch(es))\n", shortHash(s.Root.ID), len(s.Patches))
+	fmt.Printf("Author: %s\n", author)
+	fmt.Printf("Date:   %s\n\n", time.Unix(s.Root.CreatedAt, 0).Format("Mon Jan 2 15:04:05 2006 -0700"))
+	for _, patch := range s.Patches {
+		fmt.Printf("    %s\n", patchEventSubject(patch))
+	}
+}
+
+// patchEventSubject returns the Subject header of a patch event's content
+func patchEventSubject(event *nostr.Event) string {
+	for _, message := range splitMailbox([]byte(event.Content)) {
+		msg, err := mail.ReadMessage(bytes.NewReader(message))
+		if err != nil {
+			break
+		}
+		subject := msg.Header.Get("Subject")
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+			subject = decoded
+		}
+		return subject
+	}
+	return "(no subject)"
+}
+
+// applyPatchSeries applies a series with mgit am. Patches that don't name an
+// MGit pubkey are attributed to the event's signer; cover letters, which
+// carry no diff, are left out.
+func applyPatchSeries(s *PatchSeries) error {
+	signer, err := nostr.Npub(s.Root.Pubkey)
+	if err != nil {
+		return err
+	}
+	var mbox bytes.Buffer
+	for _, patch := range s.Patches {
+		content := patch.Content
+		if !strings.Contains(content, "\ndiff --git ") {
+			continue
+		}
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		lines := strings.SplitN(content, "\n", 2)
+		if !patchSeparator.MatchString(lines[0]) {
+			return fmt.Errorf("patch %s is not in format-patch form", shortHash(patch.ID))
+		}
+		mbox.WriteString(lines[0] + "\n")
+		if !strings.Contains(strings.SplitN(lines[1], "\n\n", 2)[0], headerMGitPubkey+":") {
+			fmt.Fprintf(&mbox, "%s: %s\n", headerMGitPubkey, signer)
+		}
+		mbox.WriteString(lines[1])
+	}
+	if mbox.Len() == 0 {
+		return fmt.Errorf("series %s has no patches to apply", shortHash(s.Root.ID))
+	}
+
+	file, err := os.CreateTemp("", "mgit-series-*.mbox")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(mbox.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	HandleAm([]string{file.Name()})
+	return nil
+}
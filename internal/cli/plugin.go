@@ -0,0 +1,270 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A command mgit doesn't know is looked up as an mgit-<name> executable on
+// PATH and run with the rest of the arguments, as git does for git-<name>,
+// so teams can add commands without forking mgit. Built-in commands always
+// win. The plugin runs in the current directory with mgit's stdin, stdout
+// and stderr, and mgit exits with its status. Besides the environment mgit
+// was given, it gets:
+//
+//	MGIT_PLUGIN_API      the version of this contract, 1
+//	MGIT_EXEC_PATH       the mgit binary, for calling back into mgit
+//	MGIT_REPO            the working directory, when it is a repository
+//	MGIT_DIR             the repository's .mgit directory
+//	MGIT_USER_NAME, MGIT_USER_EMAIL, MGIT_USER_PUBKEY
+//	                     the identity commits are made with, when set
+//	MGIT_PLUGIN_CONTEXT  all of the above, the current branch and MGit HEAD,
+//	                     and the effective config, as one JSON object
+//
+// mgit plugin context prints the same JSON object, and mgit plugin list
+// --json lists the plugins found. Config keys that hold secrets (secret,
+// token, passphrase, password) are left out of the context; a plugin that
+// needs one asks mgit config for it.
+
+// pluginAPIVersion is the version of the plugin environment contract
+const pluginAPIVersion = 1
+
+// pluginPrefix starts the name of every plugin executable
+const pluginPrefix = "mgit-"
+
+const pluginUsage = "Usage: mgit plugin list [--json] | context"
+
+// PluginContext is what a plugin learns about the mgit invocation
+type PluginContext struct {
+	API      int               `json:"api"`
+	Mgit     string            `json:"mgit"`
+	Repo     string            `json:"repo,omitempty"`
+	MGitDir  string            `json:"mgit_dir,omitempty"`
+	Branch   string            `json:"branch,omitempty"`
+	Head     string            `json:"head,omitempty"` // MGit hash of HEAD
+	Identity PluginIdentity    `json:"identity"`
+	Config   map[string]string `json:"config"`
+}
+
+// PluginIdentity is the identity commits are made with
+type PluginIdentity struct {
+	Name   string `json:"name,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Pubkey string `json:"pubkey,omitempty"` // npub
+}
+
+// PluginInfo describes a plugin found on PATH
+type PluginInfo struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// HandlePlugin handles the plugin command
+func HandlePlugin(args []string) {
+	if len(args) == 0 {
+		fmt.Println(pluginUsage)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list":
+		asJSON := len(args) == 2 && args[1] == "--json"
+		if len(args) > 1 && !asJSON {
+			fmt.Println(pluginUsage)
+			os.Exit(1)
+		}
+		plugins := findPlugins()
+		if asJSON {
+			data, _ := json.MarshalIndent(plugins, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+		if len(plugins) == 0 {
+			fmt.Printf("No plugins found on PATH (executables named %s<command>)\n", pluginPrefix)
+			return
+		}
+		for _, plugin := range plugins {
+			fmt.Printf("%-20s %s\n", plugin.Name, plugin.Path)
+		}
+	case "context":
+		if len(args) > 1 {
+			fmt.Println(pluginUsage)
+			os.Exit(1)
+		}
+		data, err := json.MarshalIndent(pluginContext(), "", "  ")
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	default:
+		fmt.Println(pluginUsage)
+		os.Exit(1)
+	}
+}
+
+// findPlugin returns the path of the plugin for a command, or "" if there
+// is none
+func findPlugin(command string) string {
+	if command == "" || strings.HasPrefix(command, "-") || strings.ContainsAny(command, `/\`) {
+		return ""
+	}
+	path, err := exec.LookPath(pluginPrefix + command)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// findPlugins lists the plugins on PATH; where two directories hold the
+// same one, the first wins, as it does when the plugin is run
+func findPlugins() []PluginInfo {
+	plugins := []PluginInfo{}
+	seen := map[string]bool{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := strings.TrimPrefix(entry.Name(), pluginPrefix)
+			if name == entry.Name() || name == "" || seen[name] {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if info, err := os.Stat(path); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			seen[name] = true
+			plugins = append(plugins, PluginInfo{Name: name, Path: path})
+		}
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins
+}
+
+// runPlugin runs a plugin and exits with its status
+func runPlugin(path string, args []string) {
+	context := pluginContext()
+	data, err := json.Marshal(context)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	env := append(os.Environ(),
+		fmt.Sprintf("MGIT_PLUGIN_API=%d", pluginAPIVersion),
+		"MGIT_EXEC_PATH="+context.Mgit,
+		"MGIT_PLUGIN_CONTEXT="+string(data),
+	)
+	if context.Repo != "" {
+		env = append(env, "MGIT_REPO="+context.Repo, "MGIT_DIR="+context.MGitDir)
+	}
+	// Only values that are set, so an empty one can't override the config
+	// of an mgit the plugin runs
+	for key, value := range map[string]string{
+		"MGIT_USER_NAME":   context.Identity.Name,
+		"MGIT_USER_EMAIL":  context.Identity.Email,
+		"MGIT_USER_PUBKEY": context.Identity.Pubkey,
+	} {
+		if value != "" {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Ctrl-C reaches the plugin too; it decides what to do, and mgit waits
+	// for it rather than exiting underneath it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		if code := exitErr.ExitCode(); code > 0 {
+			os.Exit(code)
+		}
+		// Killed by a signal
+		os.Exit(interruptedExitCode)
+	case err != nil:
+		fmt.Printf("Error running %s: %s\n", path, err)
+		os.Exit(1)
+	}
+}
+
+// pluginContext describes the current invocation for a plugin
+func pluginContext() *PluginContext {
+	context := &PluginContext{
+		API: pluginAPIVersion,
+		Identity: PluginIdentity{
+			Name:   GetConfigValue("user.name", ""),
+			Email:  GetConfigValue("user.email", ""),
+			Pubkey: GetNostrPubKey(),
+		},
+		Config: effectiveConfig(),
+	}
+	if exe, err := os.Executable(); err == nil {
+		context.Mgit = exe
+	}
+
+	session := currentSession()
+	if repo, err := session.Repo(); err == nil {
+		if dir, err := filepath.Abs(session.Path); err == nil {
+			context.Repo = dir
+			context.MGitDir = filepath.Join(dir, ".mgit")
+		}
+		if head, err := repo.Head(); err == nil && head.Name().IsBranch() {
+			context.Branch = head.Name().Short()
+		}
+		if head, err := session.Storage().GetHeadCommit(); err == nil {
+			context.Head = head.MGitHash
+		}
+	}
+	return context
+}
+
+// effectiveConfig returns the value of every configured key, local values
+// over global ones and global over system ones, without the keys holding
+// secrets
+func effectiveConfig() map[string]string {
+	values := map[string]string{}
+	for _, scope := range configScopes {
+		config, err := readConfigScope(scope)
+		if err != nil {
+			continue
+		}
+		for section, keys := range config.Sections {
+			for key, value := range keys {
+				name := section + "." + key
+				if !isSecretConfigKey(name) {
+					values[name] = value
+				}
+			}
+		}
+	}
+	return values
+}
+
+// isSecretConfigKey reports whether a config key's value is a secret
+func isSecretConfigKey(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"secret", "token", "passphrase", "password"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
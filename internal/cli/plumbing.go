@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// Plumbing commands give scripts direct access to the MGit object store and
+// refs, in the manner of git's cat-file, rev-parse and update-ref.
+
+const (
+	catFileUsage   = "Usage: mgit cat-file (-p | -t | -s | -e) <object>"
+	revParseUsage  = "Usage: mgit rev-parse [--git] [--short[=<n>]] [--verify] <name>..."
+	updateRefUsage = "Usage: mgit update-ref <ref> <new-value> [<old-value>]\n       mgit update-ref -d <ref> [<old-value>]"
+)
+
+// HandleCatFile handles the cat-file command
+func HandleCatFile(args []string) {
+	if len(args) != 2 {
+		fmt.Println(catFileUsage)
+		os.Exit(1)
+	}
+	mode, name := args[0], args[1]
+	if mode != "-p" && mode != "-t" && mode != "-s" && mode != "-e" {
+		fmt.Println(catFileUsage)
+		os.Exit(1)
+	}
+
+	storage := NewMGitStorage()
+	repo := currentSession().MustRepo()
+	hash, err := resolveMGitObject(repo, storage, name)
+	if err != nil {
+		if mode == "-e" {
+			os.Exit(1)
+		}
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	objType, body, err := storage.readObject(hash)
+	if err != nil {
+		if mode == "-e" {
+			os.Exit(1)
+		}
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("object %s not found", hash)
+		}
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if objType == "" {
+		// Legacy objects carry their type only in the body
+		objType, _ = checkObjectBody(hash, "", body)
+	}
+
+	switch mode {
+	case "-t":
+		fmt.Println(objType)
+	case "-s":
+		fmt.Println(len(body))
+	case "-p":
+		os.Stdout.Write(bytes.TrimRight(body, "\n"))
+		fmt.Println()
+	}
+}
+
+// HandleRevParse handles the rev-parse command
+func HandleRevParse(args []string) {
+	showGit := false
+	verify := false
+	short := 0
+	names := []string{}
+	for _, arg := range args {
+		switch {
+		case arg == "--git":
+			showGit = true
+		case arg == "--verify":
+			verify = true
+		case arg == "--short":
+			short = 7
+		case strings.HasPrefix(arg, "--short="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--short="))
+			if err != nil || n < 4 || n > 40 {
+				fmt.Println("Error: --short needs a length between 4 and 40")
+				os.Exit(1)
+			}
+			short = n
+		case strings.HasPrefix(arg, "-"):
+			fmt.Println(revParseUsage)
+			os.Exit(1)
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) == 0 || (verify && len(names) != 1) {
+		fmt.Println(revParseUsage)
+		os.Exit(1)
+	}
+
+	storage := NewMGitStorage()
+	repo := currentSession().MustRepo()
+	for _, name := range names {
+		var hash string
+		var err error
+		if showGit {
+			var revision Revision
+			revision, err = Resolve(repo, storage, name)
+			hash = revision.Git.String()
+			if err != nil {
+				err = fmt.Errorf("%s: %w", name, err)
+			}
+		} else {
+			hash, err = resolveMGitCommit(repo, storage, name)
+		}
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if short > 0 {
+			hash = hash[:short]
+		}
+		fmt.Println(hash)
+	}
+}
+
+// HandleUpdateRef handles the update-ref command
+func HandleUpdateRef(args []string) {
+	del := false
+	if len(args) > 0 && args[0] == "-d" {
+		del = true
+		args = args[1:]
+	}
+	if (del && (len(args) < 1 || len(args) > 2)) || (!del && (len(args) < 2 || len(args) > 3)) {
+		fmt.Println(updateRefUsage)
+		os.Exit(1)
+	}
+
+	storage := NewMGitStorage()
+	repo := currentSession().MustRepo()
+
+	refName, err := updateRefTarget(storage, args[0])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if del && refName == "HEAD" {
+		fmt.Println("Error: refusing to delete a detached HEAD")
+		os.Exit(1)
+	}
+
+	// An old value pins the ref: the update only happens if it still points
+	// there. An all-zero old value means the ref must not exist yet.
+	var expect *string
+	oldArg := ""
+	if del && len(args) == 2 {
+		oldArg = args[1]
+	} else if !del && len(args) == 3 {
+		oldArg = args[2]
+	}
+	if oldArg != "" {
+		old := ""
+		if strings.Trim(oldArg, "0") != "" {
+			if old, err = resolveMGitCommit(repo, storage, oldArg); err != nil {
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		expect = &old
+	}
+
+	newHash := ""
+	if !del {
+		if newHash, err = resolveMGitCommit(repo, storage, args[1]); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if _, err := storage.GetCommit(newHash); err != nil {
+			fmt.Printf("Error: refusing to point %s at %s: %s\n", refName, newHash, err)
+			os.Exit(1)
+		}
+	}
+
+	if err := storage.compareAndSwapRef(refName, newHash, expect); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// updateRefTarget checks a ref name given to update-ref and returns the ref
+// to write. HEAD is followed to the branch it points at, or kept as HEAD
+// when detached.
+func updateRefTarget(storage *MGitStorage, name string) (string, error) {
+	if name == "HEAD" {
+		head, err := storage.GetHead()
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(head, "refs/") {
+			return head, nil
+		}
+		return "HEAD", nil
+	}
+	if !strings.HasPrefix(name, "refs/") {
+		return "", fmt.Errorf("'%s' is not a full ref name (refs/heads/..., refs/tags/...)", name)
+	}
+	if err := checkRefName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// checkRefName rejects ref names git would refuse, and any that could escape
+// the .mgit directory
+func checkRefName(name string) error {
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".lock") || strings.Contains(name, "..") ||
+		strings.Contains(name, "//") || strings.Contains(name, "@{") {
+		return fmt.Errorf("'%s' is not a valid ref name", name)
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return fmt.Errorf("'%s' is not a valid ref name", name)
+		}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") {
+			return fmt.Errorf("'%s' is not a valid ref name", name)
+		}
+	}
+	return nil
+}
+
+// compareAndSwapRef points refName at newHash, or deletes it when newHash is
+// empty. With expect set, the ref must currently hold *expect ("" meaning it
+// must not exist).
+func (s *MGitStorage) compareAndSwapRef(refName, newHash string, expect *string) error {
+	lock, err := s.lockRef(refName)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	if strings.HasPrefix(lock.current, "ref: ") {
+		return fmt.Errorf("%s is a symbolic ref; update %s instead", refName, strings.TrimPrefix(lock.current, "ref: "))
+	}
+	if expect != nil && lock.current != *expect {
+		if lock.current == "" {
+			return fmt.Errorf("%s does not exist", refName)
+		}
+		return fmt.Errorf("%s is at %s, not the expected %s", refName, lock.current, orNone(*expect))
+	}
+
+	if newHash == "" {
+		if lock.current == "" {
+			return fmt.Errorf("reference not found: %s", refName)
+		}
+		if err := os.Remove(lock.path); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", refName, err)
+		}
+		os.Remove(s.reflogPath(refName))
+		return nil
+	}
+	if err := lock.commit(newHash); err != nil {
+		return err
+	}
+	return s.logRefUpdate(refName, lock.current, newHash, "update-ref")
+}
+
+// refLock is a held <ref>.lock file. While it is held no other mgit process
+// updates the ref; commit renames the lock over the ref.
+type refLock struct {
+	name    string
+	path    string
+	lock    *fsutil.Lock
+	current string // The ref's content when it was locked, "" if missing
+}
+
+// lockRef locks refName and reads its current content
+func (s *MGitStorage) lockRef(refName string) (*refLock, error) {
+	refPath := filepath.Join(s.RootDir, refName)
+	lock, err := fsutil.LockFile(refPath, fsutil.LockWait)
+	if err != nil {
+		return nil, err
+	}
+	ref := &refLock{name: refName, path: refPath, lock: lock}
+
+	if data, err := os.ReadFile(refPath); err == nil {
+		ref.current = strings.TrimSpace(string(data))
+	} else if !os.IsNotExist(err) {
+		lock.Release()
+		return nil, fmt.Errorf("failed to read %s: %w", refName, err)
+	}
+	return ref, nil
+}
+
+// commit writes content to the ref and releases the lock
+func (l *refLock) commit(content string) error {
+	if err := l.lock.Commit(l.path, []byte(content)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", l.name, err)
+	}
+	return nil
+}
+
+// release drops the lock without changing the ref, unless commit already
+// succeeded
+func (l *refLock) release() {
+	l.lock.Release()
+}
+
+// orNone describes an expected ref value for error messages
+func orNone(hash string) string {
+	if hash == "" {
+		return "(none)"
+	}
+	return hash
+}
+
+// resolveMGitObject resolves a revision to its MGit commit, or a hash or
+// unique prefix to any other MGit object, such as an annotation
+func resolveMGitObject(repo *git.Repository, storage *MGitStorage, name string) (string, error) {
+	hash, err := resolveMGitCommit(repo, storage, name)
+	if err == nil || len(name) < 4 || !isHexString(name) {
+		return hash, err
+	}
+	matches, findErr := storage.findObjectByPrefix(strings.ToLower(name))
+	if findErr != nil || len(matches) == 0 {
+		return "", err
+	}
+	if len(matches) > 1 {
+		return "", ambiguousPrefixError(name, matches)
+	}
+	return matches[0], nil
+}
+
+// gitHashForMGit returns the git commit behind an MGit commit
+func gitHashForMGit(repo *git.Repository, storage *MGitStorage, mgitHash string) (string, error) {
+	if commit, err := storage.GetCommit(mgitHash); err == nil && commit.GitHash != "" {
+		return commit.GitHash, nil
+	}
+	if mapping, ok, err := storage.Mappings().ByMGit(mgitHash); err != nil {
+		return "", err
+	} else if ok {
+		return mapping.GitHash, nil
+	}
+	return "", fmt.Errorf("%s is not an MGit commit with a git hash", shortHash(mgitHash))
+}
+
+// isHexString reports whether s consists of hex digits only
+func isHexString(s string) bool {
+	for _, c := range strings.ToLower(s) {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,376 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Identity profiles let one person act under different identities, such as
+// one per hospital. Each is a section of the global config:
+//
+//	[profile "clinic"]
+//		name = Dr. Jane Doe
+//		email = jane@clinic.example
+//		pubkey = npub1...
+//		signer = clinic
+//		match = https://git.clinic.example/*
+//
+// where signer names a key in the key store (see mgit key). A repository
+// uses the profile named by identity.profile in its .mgit/config (set with
+// mgit identity use), else the first whose match pattern fits its origin
+// URL, else the one named by identity.profile in the global config. The
+// active profile's values stand in for user.name, user.email, user.pubkey
+// and nostr.key: values in .mgit/config, .git/config or the environment
+// still win, and the global user.* keys only fill in what the profile
+// leaves unset.
+
+const identityUsage = "Usage: mgit identity list | show [<profile>] | add <profile> [--name <name>] [--email <email>] [--pubkey <npub>] [--signer <key>] [--match <url-pattern>]... | remove <profile> | use [--global] <profile>"
+
+// profileKeys maps the config keys a profile stands in for to its own keys
+var profileKeys = map[string]string{
+	"user.name":   "name",
+	"user.email":  "email",
+	"user.pubkey": "pubkey",
+	"nostr.key":   "signer",
+}
+
+// IdentityProfile is a named identity from the global config
+type IdentityProfile struct {
+	Name   string
+	Values map[string]string
+	Match  []string
+}
+
+// profileSection returns the config section holding a profile
+func profileSection(name string) string {
+	return fmt.Sprintf("profile \"%s\"", name)
+}
+
+// loadProfiles reads the profiles in a config, by name
+func loadProfiles(config *Config) []*IdentityProfile {
+	profiles := []*IdentityProfile{}
+	for section, values := range config.Sections {
+		if !strings.HasPrefix(section, "profile \"") || !strings.HasSuffix(section, "\"") || len(section) <= len("profile \"\"") {
+			continue
+		}
+		profile := &IdentityProfile{
+			Name:   section[len("profile \"") : len(section)-1],
+			Values: map[string]string{},
+			Match:  config.GetAll(section, "match"),
+		}
+		for key, value := range values {
+			if key != "match" {
+				profile.Values[key] = value
+			}
+		}
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// findProfile returns the named profile, or nil
+func findProfile(profiles []*IdentityProfile, name string) *IdentityProfile {
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
+
+// activeProfile returns the profile the working directory's repository uses
+// and why, or nil when none applies
+func activeProfile() (*IdentityProfile, string) {
+	global, err := ReadConfig(GetConfigFilePath(true))
+	if err != nil {
+		return nil, ""
+	}
+	profiles := loadProfiles(global)
+	if len(profiles) == 0 {
+		return nil, ""
+	}
+
+	if name, exists := os.LookupEnv("MGIT_IDENTITY_PROFILE"); exists {
+		if name == "" {
+			return nil, ""
+		}
+		return findProfile(profiles, name), "MGIT_IDENTITY_PROFILE"
+	}
+	if local, err := ReadConfig(GetConfigFilePath(false)); err == nil {
+		if name := local.Get("identity", "profile"); name != "" {
+			return findProfile(profiles, name), "identity.profile in .mgit/config"
+		}
+	}
+	if origin := originURL(); origin != "" {
+		for _, profile := range profiles {
+			for _, pattern := range profile.Match {
+				if matchURLPattern(pattern, origin) {
+					return profile, fmt.Sprintf("origin %s matches %s", origin, pattern)
+				}
+			}
+		}
+	}
+	if name := global.Get("identity", "profile"); name != "" {
+		return findProfile(profiles, name), "identity.profile in the global config"
+	}
+	return nil, ""
+}
+
+// profileConfigValue returns the active profile's value for a config key it
+// stands in for. ok is false when the profile has no say in the key.
+func profileConfigValue(key string) (value string, ok bool) {
+	field, isProfileKey := profileKeys[key]
+	if !isProfileKey && key != "nostr.secretKey" {
+		return "", false
+	}
+	profile, _ := activeProfile()
+	if profile == nil {
+		return "", false
+	}
+	if key == "nostr.secretKey" {
+		// A profile's signer replaces a global secret key
+		return "", profile.Values["signer"] != ""
+	}
+	value = profile.Values[field]
+	return value, value != ""
+}
+
+// originURL returns the working directory repository's origin URL, or ""
+func originURL() string {
+	repo, err := currentSession().Repo()
+	if err != nil {
+		return ""
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return remote.Config().URLs[0]
+}
+
+// matchURLPattern reports whether a URL fits a pattern in which * stands
+// for any run of characters
+func matchURLPattern(pattern, url string) bool {
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	matched, err := regexp.MatchString(expr, url)
+	return err == nil && matched
+}
+
+// HandleIdentity handles the identity command
+func HandleIdentity(args []string) {
+	if len(args) == 0 {
+		fmt.Println(identityUsage)
+		os.Exit(1)
+	}
+	var err error
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		err = listProfiles()
+	case args[0] == "show" && len(args) <= 2:
+		err = showProfile(args[1:])
+	case args[0] == "add" && len(args) >= 2:
+		err = addProfile(args[1], args[2:])
+	case args[0] == "remove" && len(args) == 2:
+		err = removeProfile(args[1])
+	case args[0] == "use" && len(args) == 2 && args[1] != "--global":
+		err = useProfile(args[1], false)
+	case args[0] == "use" && len(args) == 3 && args[1] == "--global":
+		err = useProfile(args[2], true)
+	default:
+		fmt.Println(identityUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// globalProfiles reads the profiles in the global config
+func globalProfiles() ([]*IdentityProfile, error) {
+	config, err := ReadConfig(GetConfigFilePath(true))
+	if err != nil {
+		return nil, err
+	}
+	return loadProfiles(config), nil
+}
+
+// listProfiles prints the profiles, marking the active one
+func listProfiles() error {
+	profiles, err := globalProfiles()
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		fmt.Println("No identity profiles (create one with mgit identity add <profile>)")
+		return nil
+	}
+	active, _ := activeProfile()
+	for _, profile := range profiles {
+		marker := " "
+		if active != nil && active.Name == profile.Name {
+			marker = "*"
+		}
+		fmt.Printf("%s %-12s %s <%s> %s\n", marker, profile.Name, orNone(profile.Values["name"]),
+			orNone(profile.Values["email"]), orNone(profile.Values["pubkey"]))
+	}
+	return nil
+}
+
+// showProfile prints a profile, or with no name the active profile, why it
+// applies, and the identity in effect
+func showProfile(args []string) error {
+	if len(args) == 1 {
+		profiles, err := globalProfiles()
+		if err != nil {
+			return err
+		}
+		profile := findProfile(profiles, args[0])
+		if profile == nil {
+			return fmt.Errorf("no identity profile named '%s'", args[0])
+		}
+		fmt.Printf("Profile: %s\n", profile.Name)
+		for _, field := range []string{"name", "email", "pubkey", "signer"} {
+			fmt.Printf("  %-7s %s\n", field+":", orNone(profile.Values[field]))
+		}
+		for _, pattern := range profile.Match {
+			fmt.Printf("  match:  %s\n", pattern)
+		}
+		return nil
+	}
+
+	profile, reason := activeProfile()
+	if profile != nil {
+		fmt.Printf("Profile: %s (%s)\n", profile.Name, reason)
+	} else if reason != "" {
+		fmt.Printf("Profile: none (%s names a profile that does not exist)\n", reason)
+	} else {
+		fmt.Println("Profile: none")
+	}
+	for _, key := range []string{"user.name", "user.email", "user.pubkey", "nostr.key"} {
+		fmt.Printf("  %-12s %s\n", key+":", orNone(GetConfigValue(key, "")))
+	}
+	return nil
+}
+
+// addProfile creates a profile, or updates the fields given for an
+// existing one
+func addProfile(name string, args []string) error {
+	if !keyNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name '%s' (use letters, digits, '.', '_' and '-')", name)
+	}
+	values := map[string]string{}
+	match := []string{}
+	for i := 0; i < len(args); i++ {
+		flag := strings.TrimPrefix(args[i], "--")
+		if !strings.HasPrefix(args[i], "--") || i+1 >= len(args) {
+			return fmt.Errorf("%s", identityUsage)
+		}
+		switch flag {
+		case "name", "email", "signer":
+			values[flag] = args[i+1]
+		case "pubkey":
+			npub, err := NormalizeNostrPubKey(args[i+1])
+			if err != nil {
+				return fmt.Errorf("invalid --pubkey: %w", err)
+			}
+			values["pubkey"] = npub
+		case "match":
+			match = append(match, args[i+1])
+		default:
+			return fmt.Errorf("%s", identityUsage)
+		}
+		i++
+	}
+	if len(values) == 0 && len(match) == 0 {
+		return fmt.Errorf("%s", identityUsage)
+	}
+
+	// The signer's public key is the profile's unless another is given,
+	// and must agree with one that is
+	if signer := values["signer"]; signer != "" {
+		key, err := loadStoredKey(signer)
+		if err != nil {
+			return err
+		}
+		if values["pubkey"] == "" {
+			values["pubkey"] = key.Pubkey
+		} else if values["pubkey"] != key.Pubkey {
+			return fmt.Errorf("key '%s' is %s, not %s", signer, key.Pubkey, values["pubkey"])
+		}
+	}
+
+	created := false
+	err := UpdateConfig(GetConfigFilePath(true), func(config *Config) error {
+		section := profileSection(name)
+		if _, exists := config.Sections[section]; !exists {
+			created = true
+		}
+		for key, value := range values {
+			config.Set(section, key, value)
+		}
+		existing := config.GetAll(section, "match")
+		for _, pattern := range match {
+			if !containsString(existing, pattern) {
+				config.Add(section, "match", pattern)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Printf("Created identity profile '%s'\n", name)
+	} else {
+		fmt.Printf("Updated identity profile '%s'\n", name)
+	}
+	return nil
+}
+
+// removeProfile deletes a profile from the global config
+func removeProfile(name string) error {
+	err := UpdateConfig(GetConfigFilePath(true), func(config *Config) error {
+		section := profileSection(name)
+		if _, exists := config.Sections[section]; !exists {
+			return fmt.Errorf("no identity profile named '%s'", name)
+		}
+		delete(config.Sections, section)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed identity profile '%s'\n", name)
+	return nil
+}
+
+// useProfile makes a profile the repository's identity, or with global the
+// one used where no other applies
+func useProfile(name string, global bool) error {
+	profiles, err := globalProfiles()
+	if err != nil {
+		return err
+	}
+	if findProfile(profiles, name) == nil {
+		return fmt.Errorf("no identity profile named '%s' (see mgit identity list)", name)
+	}
+	if !global {
+		if info, err := os.Stat(".mgit"); err != nil || !info.IsDir() {
+			return fmt.Errorf("not in an mgit repository (use --global to set the default profile)")
+		}
+	}
+	if err := SetConfigValue("identity.profile", name, global); err != nil {
+		return err
+	}
+	if global {
+		fmt.Printf("Using identity profile '%s' where no other applies\n", name)
+	} else {
+		fmt.Printf("Using identity profile '%s' in this repository\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Long operations - clone, push, pull and verify - report their progress
+// through a Progress, one phase at a time ("Receiving objects", "Verifying
+// commits"). On a terminal each phase is drawn on one line that updates in
+// place, with object counts, the percentage done, bytes transferred, the
+// transfer rate and an ETA, and is left behind ending in ", done." as git
+// leaves its own:
+//
+//	Receiving objects:  45% (450/1000), 1.20 MiB | 2.00 MiB/s, ETA 0:03
+//
+// When stderr isn't a terminal, or with --quiet, nothing is drawn, so logs
+// and pipes only get the command's own output. Where mgit is used as a library (the browser
+// build), the caller passes a ProgressFunc instead and gets each update as
+// a ProgressUpdate.
+//
+// The git CLI's transfers run with --progress and their stderr goes
+// through a gitProgressWriter, which turns git's progress lines into
+// updates and passes everything else through.
+
+// progressInterval is how often a phase's line is redrawn
+const progressInterval = 100 * time.Millisecond
+
+// ProgressUpdate is the state of an operation's current phase
+type ProgressUpdate struct {
+	Operation string  `json:"operation"`       // Such as clone, push, pull or verify
+	Phase     string  `json:"phase"`           // Such as "Receiving objects"
+	Done      int64   `json:"done"`            // Objects or commits done
+	Total     int64   `json:"total,omitempty"` // 0 when not known
+	Percent   int     `json:"percent,omitempty"`
+	Bytes     int64   `json:"bytes,omitempty"`    // Transferred so far
+	Rate      float64 `json:"rate,omitempty"`     // Bytes per second
+	ETA       float64 `json:"eta,omitempty"`      // Seconds left, when known
+	Finished  bool    `json:"finished,omitempty"` // The phase is over
+}
+
+// ProgressFunc receives progress updates
+type ProgressFunc func(ProgressUpdate)
+
+// Progress reports an operation's progress
+type Progress struct {
+	mu        sync.Mutex
+	operation string
+	report    ProgressFunc
+	out       io.Writer // The terminal; nil when quiet
+	phase     string
+	done      int64
+	total     int64
+	bytes     int64
+	start     time.Time // When the phase started
+	drawn     time.Time // When its line was last drawn
+	width     int       // The length of that line
+}
+
+// newProgress starts reporting an operation's progress on the terminal,
+// or to report when it is set
+func newProgress(operation string, report ProgressFunc) *Progress {
+	p := &Progress{operation: operation, report: report}
+	if report == nil && logEnabled(logNormal) && isTerminal(os.Stderr) {
+		p.out = os.Stderr
+	}
+	return p
+}
+
+// isTerminal reports whether a file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Phase finishes the current phase and starts another; total is 0 when
+// not known
+func (p *Progress) Phase(name string, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.startPhase(name, total)
+}
+
+// startPhase starts a phase with p.mu held
+func (p *Progress) startPhase(name string, total int64) {
+	p.finishPhase()
+	p.phase, p.total = name, total
+	p.done, p.bytes = 0, 0
+	p.start, p.drawn = time.Now(), time.Time{}
+	p.update(false)
+}
+
+// Add counts n more objects done
+func (p *Progress) Add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	p.update(false)
+}
+
+// Set sets a phase's objects done, total and bytes transferred, as git
+// reports them, starting the phase if it is a new one
+func (p *Progress) Set(phase string, done, total, bytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.phase != phase {
+		p.startPhase(phase, total)
+	}
+	p.done, p.total, p.bytes = done, total, bytes
+	p.update(false)
+}
+
+// Finish finishes the current phase
+func (p *Progress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finishPhase()
+}
+
+// finishPhase leaves the phase's last line behind, with p.mu held
+func (p *Progress) finishPhase() {
+	if p.phase == "" {
+		return
+	}
+	p.update(true)
+	p.phase = ""
+}
+
+// Println prints a line of other output, below the phase's line
+func (p *Progress) Println(w io.Writer, line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprintln(w, line)
+	p.drawn = time.Time{}
+}
+
+// update reports the phase's state, redrawing its line no more often than
+// progressInterval unless the phase is finished
+func (p *Progress) update(finished bool) {
+	if p.phase == "" {
+		return
+	}
+	u := ProgressUpdate{
+		Operation: p.operation,
+		Phase:     p.phase,
+		Done:      p.done,
+		Total:     p.total,
+		Bytes:     p.bytes,
+		Finished:  finished,
+	}
+	if p.total > 0 {
+		u.Percent = int(p.done * 100 / p.total)
+	}
+	if elapsed := time.Since(p.start).Seconds(); elapsed >= 1 {
+		u.Rate = float64(p.bytes) / elapsed
+		if p.total > 0 && p.done > 0 && !finished {
+			u.ETA = elapsed * float64(p.total-p.done) / float64(p.done)
+		}
+	}
+	if p.report != nil {
+		p.report(u)
+	}
+	if p.out == nil || !finished && time.Since(p.drawn) < progressInterval {
+		return
+	}
+	line := formatProgress(u)
+	p.clear()
+	fmt.Fprint(p.out, line)
+	if finished {
+		fmt.Fprintln(p.out)
+		p.width = 0
+	} else {
+		p.width = len(line)
+	}
+	p.drawn = time.Now()
+}
+
+// clear rubs out the phase's line, if one is drawn
+func (p *Progress) clear() {
+	if p.out != nil && p.width > 0 {
+		fmt.Fprintf(p.out, "\r%s\r", strings.Repeat(" ", p.width))
+		p.width = 0
+	}
+}
+
+// formatProgress renders an update as git renders its progress lines
+func formatProgress(u ProgressUpdate) string {
+	var b strings.Builder
+	if u.Total > 0 {
+		fmt.Fprintf(&b, "%s: %3d%% (%d/%d)", u.Phase, u.Percent, u.Done, u.Total)
+	} else {
+		fmt.Fprintf(&b, "%s: %d", u.Phase, u.Done)
+	}
+	if u.Bytes > 0 {
+		fmt.Fprintf(&b, ", %s", formatBytes(float64(u.Bytes)))
+		if u.Rate > 0 {
+			fmt.Fprintf(&b, " | %s/s", formatBytes(u.Rate))
+		}
+	}
+	if u.Finished {
+		b.WriteString(", done.")
+	} else if u.ETA > 0 {
+		fmt.Fprintf(&b, ", ETA %s", formatETA(u.ETA))
+	}
+	return b.String()
+}
+
+// formatBytes renders a byte count in binary units, as git does
+func formatBytes(n float64) string {
+	units := []string{"bytes", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[0])
+	}
+	return fmt.Sprintf("%.2f %s", n, units[i])
+}
+
+// formatETA renders seconds as m:ss, or h:mm:ss
+func formatETA(seconds float64) string {
+	s := int64(seconds + 0.5)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// gitProgressLine matches git's progress lines, such as
+// "Receiving objects:  45% (450/1000), 1.20 MiB | 2.00 MiB/s" and
+// "remote: Enumerating objects: 12, done."
+var gitProgressLine = regexp.MustCompile(`^(?:remote: )?([A-Z][a-z]+(?: [a-z]+)*): +(?:(\d+)% \((\d+)/(\d+)\)|(\d+))(?:, ([\d.]+) (bytes|KiB|MiB|GiB|TiB)(?: \| [\d.]+ [A-Za-z/]+)?)?(, done\.)?`)
+
+// gitPackSummary matches the line that sums up a pack sent,
+// "Total 35 (delta 6), reused 0 (delta 0), pack-reused 0"
+var gitPackSummary = regexp.MustCompile(`^(?:remote: )?Total \d+ \(delta \d+\)`)
+
+// gitProgressWriter turns the stderr of a git command run with --progress
+// into a Progress, passing the lines that aren't progress on to out
+type gitProgressWriter struct {
+	progress *Progress
+	out      io.Writer
+	buf      []byte
+}
+
+// Write splits git's output into lines; progress lines end in carriage
+// returns as git redraws them
+func (w *gitProgressWriter) Write(data []byte) (int, error) {
+	w.buf = append(w.buf, data...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.line(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Close handles what is left of the output and finishes the last phase
+func (w *gitProgressWriter) Close() error {
+	if len(w.buf) > 0 {
+		w.line(string(w.buf))
+		w.buf = nil
+	}
+	w.progress.Finish()
+	return nil
+}
+
+// line handles one line of git's output
+func (w *gitProgressWriter) line(line string) {
+	m := gitProgressLine.FindStringSubmatch(line)
+	if m == nil {
+		// The pack's summary goes with the progress it ends
+		quiet := w.progress.out == nil && gitPackSummary.MatchString(line)
+		if strings.TrimSpace(line) != "" && w.out != nil && !quiet {
+			w.progress.Println(w.out, line)
+		}
+		return
+	}
+	phase := m[1]
+	var done, total int64
+	if m[5] != "" {
+		done, _ = strconv.ParseInt(m[5], 10, 64)
+	} else {
+		done, _ = strconv.ParseInt(m[3], 10, 64)
+		total, _ = strconv.ParseInt(m[4], 10, 64)
+	}
+	var transferred int64
+	if m[6] != "" {
+		transferred = parseGitBytes(m[6], m[7])
+	}
+
+	w.progress.Set(phase, done, total, transferred)
+	if m[8] != "" {
+		w.progress.Finish()
+	}
+}
+
+// gitByteUnits are the units git prints byte counts in
+var gitByteUnits = map[string]float64{"bytes": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40}
+
+// parseGitBytes reads a byte count as git prints it
+func parseGitBytes(number, unit string) int64 {
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(n * gitByteUnits[unit])
+}
+
+// runGitProgress runs a git command that transfers objects and was given
+// --progress, showing its progress as the operation's
+func runGitProgress(cmd *exec.Cmd, operation string) error {
+	if !logEnabled(logNormal) {
+		cmd.Args = append(cmd.Args, "--quiet")
+	}
+	progress := newProgress(operation, nil)
+	stderr := &gitProgressWriter{progress: progress, out: os.Stderr}
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	stderr.Close()
+	return err
+}
@@ -0,0 +1,285 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// A proposal asks for a branch to be merged into another on the server, so
+// collaborators without write access to the main branch can still offer
+// changes. The server keeps them under the repository:
+//
+//	GET  /api/mgit/repos/<id>/proposals?state=open|merged|closed|all
+//	POST /api/mgit/repos/<id>/proposals            {"title", "description", "source_branch",
+//	                                                "target_branch", "head", "mgit_head"}
+//	POST /api/mgit/repos/<id>/proposals/<n>/merge  -> the proposal, with merge_commit
+//	POST /api/mgit/repos/<id>/proposals/<n>/close
+//
+// mgit propose pushes the branch before opening the proposal. Merging
+// needs write access to the target branch and is done by the server, which
+// answers 409 when the branch no longer merges cleanly.
+
+const (
+	proposeUsage   = "Usage: mgit propose [<branch>] [--to <branch>] [-m <title>] [--description <text>]"
+	proposalsUsage = "Usage: mgit proposals list [--state open|merged|closed|all]"
+	proposalUsage  = "Usage: mgit proposal merge|close <number>"
+)
+
+// Proposal is a request to merge one branch into another
+type Proposal struct {
+	Number       int    `json:"number,omitempty"`
+	Title        string `json:"title"`
+	Description  string `json:"description,omitempty"`
+	State        string `json:"state,omitempty"` // "open", "merged" or "closed"
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	Head         string `json:"head"` // Git hash of the source branch's tip
+	MGitHead     string `json:"mgit_head,omitempty"`
+	Author       string `json:"author,omitempty"`     // npub
+	CreatedAt    string `json:"created_at,omitempty"` // RFC 3339
+	MergeCommit  string `json:"merge_commit,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// proposalRemote returns the origin remote's URL and credentials, exiting
+// when it is not on an mgit server
+func proposalRemote() (string, *Credentials) {
+	remote, err := getRepo().Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 || !isServerURL(remote.Config().URLs[0]) {
+		fmt.Println("Error: proposals need an origin remote on an MGit server")
+		os.Exit(1)
+	}
+	remoteURL := remote.Config().URLs[0]
+	return remoteURL, authForRepo(remoteURL)
+}
+
+// HandlePropose handles the propose command
+func HandlePropose(args []string) {
+	proposal := &Proposal{}
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") && proposal.SourceBranch == "" {
+			proposal.SourceBranch = args[i]
+			continue
+		}
+		if i+1 >= len(args) {
+			fmt.Println(proposeUsage)
+			os.Exit(1)
+		}
+		switch args[i] {
+		case "--to":
+			proposal.TargetBranch = args[i+1]
+		case "-m":
+			proposal.Title = args[i+1]
+		case "--description":
+			proposal.Description = args[i+1]
+		default:
+			fmt.Println(proposeUsage)
+			os.Exit(1)
+		}
+		i++
+	}
+	remoteURL, auth := proposalRemote()
+	if err := openProposal(remoteURL, auth, proposal); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// openProposal fills in what the proposal leaves out, pushes its branch
+// and opens it on the server
+func openProposal(remoteURL string, auth *Credentials, proposal *Proposal) error {
+	repo := getRepo()
+	if proposal.SourceBranch == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return fmt.Errorf("error getting HEAD: %w", err)
+		}
+		if !head.Name().IsBranch() {
+			return fmt.Errorf("HEAD is not on a branch; name the branch to propose")
+		}
+		proposal.SourceBranch = head.Name().Short()
+	}
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(proposal.SourceBranch), true)
+	if err != nil {
+		return fmt.Errorf("no branch named '%s'", proposal.SourceBranch)
+	}
+	proposal.Head = ref.Hash().String()
+	proposal.MGitHead = GetMGitHashForCommit(ref.Hash())
+
+	gitConfig := append(serverGitArgs(remoteURL), "-c", auth.gitConfig())
+	if proposal.TargetBranch == "" {
+		if proposal.TargetBranch, err = remoteDefaultBranch(".", gitConfig); err != nil {
+			return fmt.Errorf("%w (name the target with --to)", err)
+		}
+	}
+	if proposal.TargetBranch == proposal.SourceBranch {
+		return fmt.Errorf("cannot propose %s into itself (name the target with --to)", proposal.SourceBranch)
+	}
+	if proposal.Title == "" {
+		commit, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", proposal.SourceBranch, err)
+		}
+		proposal.Title = commitSubject(commit.Message)
+	}
+
+	branchRef := "refs/heads/" + proposal.SourceBranch
+	cmd := exec.Command("git", append(gitConfig, "push", "origin", branchRef+":"+branchRef)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error pushing %s: %w", proposal.SourceBranch, err)
+	}
+
+	opened := &Proposal{}
+	status, err := loginRequest("POST", repoEndpoint(remoteURL, "/proposals"), auth, proposal, opened)
+	if err != nil {
+		return err
+	}
+	if err := proposalStatusError(status, opened, "open a proposal"); err != nil {
+		return err
+	}
+	fmt.Printf("Opened proposal #%d: %s\n", opened.Number, opened.Title)
+	fmt.Printf("  %s -> %s (%s)\n", opened.SourceBranch, opened.TargetBranch, shortProposalHead(opened))
+	return nil
+}
+
+// proposalStatusError turns a refused proposal request into an error
+func proposalStatusError(status int, answer *Proposal, action string) error {
+	switch {
+	case status >= 200 && status <= 299:
+		return nil
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Errorf("the server refused to let you %s", action)
+	case status == http.StatusNotFound:
+		return fmt.Errorf("no such proposal, or the server does not support proposals")
+	case answer.Error != "":
+		return fmt.Errorf("the server refused to %s: %s", action, answer.Error)
+	}
+	return fmt.Errorf("the server refused to %s (status %d)", action, status)
+}
+
+// shortProposalHead returns the proposal's head as an abbreviated MGit hash,
+// or git hash when it has none
+func shortProposalHead(proposal *Proposal) string {
+	head := proposal.MGitHead
+	if head == "" {
+		head = proposal.Head
+	}
+	if len(head) > 8 {
+		head = head[:8]
+	}
+	return head
+}
+
+// HandleProposals handles the proposals command
+func HandleProposals(args []string) {
+	state := "open"
+	switch {
+	case len(args) == 1 && args[0] == "list":
+	case len(args) == 3 && args[0] == "list" && args[1] == "--state":
+		state = args[2]
+	default:
+		fmt.Println(proposalsUsage)
+		os.Exit(1)
+	}
+	if state != "open" && state != "merged" && state != "closed" && state != "all" {
+		fmt.Println(proposalsUsage)
+		os.Exit(1)
+	}
+	remoteURL, auth := proposalRemote()
+	if err := listProposals(remoteURL, auth, state); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// listProposals prints the repository's proposals in a state
+func listProposals(remoteURL string, auth *Credentials, state string) error {
+	proposals := []Proposal{}
+	status, err := loginRequest("GET", repoEndpoint(remoteURL, "/proposals?state="+state), auth, nil, &proposals)
+	if err != nil {
+		return err
+	}
+	if err := proposalStatusError(status, &Proposal{}, "list proposals"); err != nil {
+		return err
+	}
+	if len(proposals) == 0 {
+		if state == "all" {
+			fmt.Println("No proposals")
+		} else {
+			fmt.Printf("No %s proposals\n", state)
+		}
+		return nil
+	}
+	for _, proposal := range proposals {
+		fmt.Printf("#%-4d %-7s %s -> %s  %s\n", proposal.Number, proposal.State,
+			proposal.SourceBranch, proposal.TargetBranch, proposal.Title)
+		by := ""
+		if proposal.Author != "" {
+			by = "by " + canonicalNostrPubKey(proposal.Author)
+		}
+		if when, err := time.Parse(time.RFC3339, proposal.CreatedAt); err == nil {
+			by = strings.TrimSpace(by + " on " + when.Format("2006-01-02"))
+		}
+		if by != "" {
+			fmt.Printf("      %s\n", by)
+		}
+	}
+	return nil
+}
+
+// HandleProposal handles the proposal command
+func HandleProposal(args []string) {
+	if len(args) != 2 || (args[0] != "merge" && args[0] != "close") {
+		fmt.Println(proposalUsage)
+		os.Exit(1)
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+	if err != nil || number <= 0 {
+		fmt.Printf("Error: invalid proposal number '%s'\n", args[1])
+		os.Exit(1)
+	}
+	remoteURL, auth := proposalRemote()
+	if err := settleProposal(remoteURL, auth, args[0], number); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// settleProposal merges or closes a proposal
+func settleProposal(remoteURL string, auth *Credentials, action string, number int) error {
+	answer := &Proposal{}
+	endpoint := repoEndpoint(remoteURL, fmt.Sprintf("/proposals/%d/%s", number, action))
+	status, err := loginRequest("POST", endpoint, auth, nil, answer)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict && action == "merge" {
+		return fmt.Errorf("proposal #%d does not merge cleanly; update its branch and push it again", number)
+	}
+	if err := proposalStatusError(status, answer, action+" proposal #"+strconv.Itoa(number)); err != nil {
+		return err
+	}
+	if action == "close" {
+		fmt.Printf("Closed proposal #%d\n", number)
+		return nil
+	}
+	fmt.Printf("Merged proposal #%d", number)
+	if answer.SourceBranch != "" {
+		fmt.Printf(" (%s into %s)", answer.SourceBranch, answer.TargetBranch)
+	}
+	fmt.Println()
+	if answer.MergeCommit != "" {
+		fmt.Printf("Merge commit: %s\n", answer.MergeCommit)
+	}
+	fmt.Println("Run mgit pull to bring the merge into your branch")
+	return nil
+}
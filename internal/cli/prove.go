@@ -0,0 +1,270 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// mgit prove shows someone without the repository that a commit is part of
+// its history. The proof is a signed nostr event whose content holds the
+// MGit commit objects on one path from a checkpoint (HEAD unless another is
+// given) down the parent links to the commit:
+//
+//	{"version": 1, "commit": <MGit hash>, "checkpoint": <MGit hash>,
+//	 "chain": [checkpoint commit, ..., the commit]}
+//
+// mgit prove --verify checks the event's signature, recomputes the MGit
+// hash of every commit in the chain, and checks that each one lists the
+// next among its parents. As MGit hashes cover the parents' hashes, that
+// makes the commit an ancestor of the checkpoint. The proof says nothing
+// about whether the checkpoint is genuine: the verifier compares it, and the
+// signer, with ones they trust, or passes --checkpoint and --signer to have
+// them checked.
+
+const proveUsage = `Usage: mgit prove <commit> [--checkpoint <rev>] [-o <file>]
+       mgit prove --verify <file> [--checkpoint <mgit-hash>] [--signer <npub>]`
+
+// NostrKindCommitProof is an application-specific (NIP-78) event used for
+// commit proofs
+const NostrKindCommitProof = 30078
+
+// commitProofVersion is the version of the proof content written
+const commitProofVersion = 1
+
+// CommitProof is the content of a proof event
+type CommitProof struct {
+	Version    int              `json:"version"`
+	Commit     string           `json:"commit"`
+	Checkpoint string           `json:"checkpoint"`
+	Chain      []*mgitlib.Commit `json:"chain"` // From the checkpoint to the commit
+}
+
+// HandleProve handles the prove command
+func HandleProve(args []string) {
+	if len(args) > 0 && args[0] == "--verify" {
+		handleProveVerify(args[1:])
+		return
+	}
+
+	commit, checkpoint, output := "", "HEAD", ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--checkpoint" && i+1 < len(args):
+			i++
+			checkpoint = args[i]
+		case (args[i] == "-o" || args[i] == "--output") && i+1 < len(args):
+			i++
+			output = args[i]
+		case !strings.HasPrefix(args[i], "-") && commit == "":
+			commit = args[i]
+		default:
+			fmt.Println(proveUsage)
+			os.Exit(1)
+		}
+	}
+	if commit == "" {
+		fmt.Println(proveUsage)
+		os.Exit(1)
+	}
+
+	event, err := proveCommit(commit, checkpoint)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if output == "" {
+		fmt.Println(string(data))
+		return
+	}
+	if err := os.WriteFile(output, append(data, '\n'), 0644); err != nil {
+		fmt.Printf("Error writing %s: %s\n", output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote the proof to %s\n", output)
+}
+
+// handleProveVerify checks a proof and reports what it proves
+func handleProveVerify(args []string) {
+	file, checkpoint, signer := "", "", ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--checkpoint" && i+1 < len(args):
+			i++
+			checkpoint = args[i]
+		case args[i] == "--signer" && i+1 < len(args):
+			i++
+			signer = args[i]
+		case !strings.HasPrefix(args[i], "-") && file == "":
+			file = args[i]
+		default:
+			fmt.Println(proveUsage)
+			os.Exit(1)
+		}
+	}
+	if file == "" {
+		fmt.Println(proveUsage)
+		os.Exit(1)
+	}
+
+	event, proof, err := verifyCommitProof(file)
+	if err == nil && checkpoint != "" && proof.Checkpoint != checkpoint {
+		err = fmt.Errorf("the proof is against checkpoint %s, not %s", proof.Checkpoint, checkpoint)
+	}
+	if err == nil && signer != "" {
+		var want string
+		if want, err = nostr.PubkeyHex(signer); err == nil && want != event.Pubkey {
+			err = fmt.Errorf("the proof is not signed by %s", signer)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	npub, _ := nostr.Npub(event.Pubkey)
+	fmt.Printf("Proof verified: %s is in the history of %s (%d step(s))\n",
+		proof.Commit, proof.Checkpoint, len(proof.Chain)-1)
+	fmt.Printf("Signed by %s at %s\n", npub, time.Unix(event.CreatedAt, 0).Format(time.RFC3339))
+	if checkpoint == "" || signer == "" {
+		fmt.Println("Check the checkpoint and the signer against ones you trust (--checkpoint, --signer)")
+	}
+}
+
+// proveCommit builds and signs the proof that commit is in the history of
+// checkpoint
+func proveCommit(commit, checkpoint string) (*nostr.Event, error) {
+	session := currentSession()
+	repo, err := session.Repo()
+	if err != nil {
+		return nil, err
+	}
+	storage := session.Storage()
+
+	target, err := resolveMGitCommitHash(repo, storage, commit)
+	if err != nil {
+		return nil, err
+	}
+	top, err := resolveMGitCommitHash(repo, storage, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := commitChain(storage, top, target)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := json.Marshal(&CommitProof{
+		Version:    commitProofVersion,
+		Commit:     target,
+		Checkpoint: top,
+		Chain:      chain,
+	})
+	if err != nil {
+		return nil, err
+	}
+	event := nostr.NewEvent(NostrKindCommitProof, [][]string{
+		{"d", fmt.Sprintf("mgit-proof:%s:%s", target, top)},
+		{"t", "mgit-proof"},
+		{"commit", target},
+		{"checkpoint", top},
+	}, string(content))
+	if err := event.Sign(secret); err != nil {
+		return nil, fmt.Errorf("error signing proof: %w", err)
+	}
+	return event, nil
+}
+
+// commitChain returns the MGit commits on a shortest path down the parent
+// links from top to target, both included
+func commitChain(storage *MGitStorage, top, target string) ([]*mgitlib.Commit, error) {
+	commits := map[string]*mgitlib.Commit{}
+	child := map[string]string{} // Commit -> the commit it was reached from
+	queue := []string{top}
+	seen := map[string]bool{top: true}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		commit, err := storage.GetCommit(hash)
+		if err != nil {
+			return nil, fmt.Errorf("error reading MGit commit %s: %w", shortHash(hash), err)
+		}
+		commits[hash] = commit
+		if hash == target {
+			chain := []*mgitlib.Commit{}
+			for ; hash != top; hash = child[hash] {
+				chain = append([]*mgitlib.Commit{commits[hash]}, chain...)
+			}
+			return append([]*mgitlib.Commit{commits[top]}, chain...), nil
+		}
+		for _, parent := range commit.ParentHashes {
+			if !seen[parent] {
+				seen[parent] = true
+				child[parent] = hash
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s is not in the history of %s", shortHash(target), shortHash(top))
+}
+
+// verifyCommitProof reads a proof and checks its signature and chain
+func verifyCommitProof(file string) (*nostr.Event, *CommitProof, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	event := &nostr.Event{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, nil, fmt.Errorf("%s is not a proof: %w", file, err)
+	}
+	if event.Kind != NostrKindCommitProof {
+		return nil, nil, fmt.Errorf("%s is not a proof (event kind %d)", file, event.Kind)
+	}
+	if err := event.Verify(); err != nil {
+		return nil, nil, fmt.Errorf("the proof's signature does not verify: %w", err)
+	}
+
+	proof := &CommitProof{}
+	if err := json.Unmarshal([]byte(event.Content), proof); err != nil {
+		return nil, nil, fmt.Errorf("the proof's content is not valid: %w", err)
+	}
+	if proof.Version != commitProofVersion {
+		return nil, nil, fmt.Errorf("unsupported proof version %d", proof.Version)
+	}
+	if len(proof.Chain) == 0 || proof.Chain[0] == nil || proof.Chain[len(proof.Chain)-1] == nil {
+		return nil, nil, fmt.Errorf("the proof's chain is empty")
+	}
+	if proof.Chain[0].MGitHash != proof.Checkpoint {
+		return nil, nil, fmt.Errorf("the chain does not start at the checkpoint %s", proof.Checkpoint)
+	}
+	if proof.Chain[len(proof.Chain)-1].MGitHash != proof.Commit {
+		return nil, nil, fmt.Errorf("the chain does not end at the commit %s", proof.Commit)
+	}
+	for i, commit := range proof.Chain {
+		if commit == nil {
+			return nil, nil, fmt.Errorf("the chain has an empty commit")
+		}
+		if computed := commit.ComputeHash(); computed != commit.MGitHash {
+			return nil, nil, fmt.Errorf("commit %s: its content hashes to %s", commit.MGitHash, computed)
+		}
+		if i > 0 && !containsString(proof.Chain[i-1].ParentHashes, commit.MGitHash) {
+			return nil, nil, fmt.Errorf("commit %s is not a parent of %s", commit.MGitHash, proof.Chain[i-1].MGitHash)
+		}
+	}
+	return event, proof, nil
+}
@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// With push.notify set to true (or --notify), a successful push is announced
+// as a signed kind 1 note, so collaborators following the pusher or the
+// repository see it in any nostr client. The note names the branch and lists
+// each pushed commit's MGit hash and subject; its tags carry the same for
+// tools, and the repository's address when it has been announced. It goes
+// to the repository's relays, or else nostr.relays.
+
+// maxNotifiedCommits bounds how many commits a push notification lists
+const maxNotifiedCommits = 20
+
+// PushSummary is what a push sends
+type PushSummary struct {
+	Branch  string
+	Commits []PushedCommit // Newest first
+}
+
+// PushedCommit is one commit a push sends
+type PushedCommit struct {
+	GitHash  string
+	MGitHash string // "" when the commit has no MGit hash
+	Subject  string
+}
+
+// pendingPush lists the commits a push of HEAD will send: those no origin
+// remote-tracking ref has yet
+func pendingPush(repo *git.Repository) (*PushSummary, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("error getting HEAD: %w", err)
+	}
+	if !head.Name().IsBranch() {
+		return nil, fmt.Errorf("HEAD is not on a branch")
+	}
+	out, err := exec.Command("git", "rev-list", "HEAD", "--not", "--remotes=origin").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing commits to push: %w", err)
+	}
+
+	summary := &PushSummary{Branch: head.Name().Short()}
+	for _, line := range strings.Fields(string(out)) {
+		hash := plumbing.NewHash(line)
+		pushed := PushedCommit{GitHash: line, MGitHash: GetMGitHashForCommit(hash)}
+		if commit, err := repo.CommitObject(hash); err == nil {
+			pushed.Subject = commitSubject(commit.Message)
+		}
+		summary.Commits = append(summary.Commits, pushed)
+	}
+	return summary, nil
+}
+
+// notifyPush signs and publishes a note announcing a push
+func notifyPush(storage *MGitStorage, summary *PushSummary) error {
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	tags := [][]string{{"t", "mgit-push"}, {"branch", summary.Branch}}
+	relays := configuredRelays()
+	if addr, err := repoAddress(storage, ""); err == nil {
+		name = addr.Identifier
+		tags = append(tags, []string{"a", addr.Coordinate()}, []string{"p", addr.Pubkey})
+		relays = repoRelays(addr, nil)
+	}
+	if len(relays) == 0 {
+		return fmt.Errorf("no relays configured (add one with mgit relay add <url>)")
+	}
+
+	noun := "commits"
+	if len(summary.Commits) == 1 {
+		noun = "commit"
+	}
+	var content strings.Builder
+	if name != "" {
+		fmt.Fprintf(&content, "Pushed %d %s to %s of %s\n", len(summary.Commits), noun, summary.Branch, name)
+	} else {
+		fmt.Fprintf(&content, "Pushed %d %s to %s\n", len(summary.Commits), noun, summary.Branch)
+	}
+	for i, commit := range summary.Commits {
+		if i == maxNotifiedCommits {
+			fmt.Fprintf(&content, "... and %d more\n", len(summary.Commits)-i)
+			break
+		}
+		hash := commit.MGitHash
+		if hash == "" {
+			hash = commit.GitHash
+		}
+		fmt.Fprintf(&content, "%s %s\n", shortHash(hash), commit.Subject)
+		tags = append(tags, []string{"commit", commit.MGitHash, commit.GitHash})
+	}
+
+	event := nostr.NewEvent(nostr.KindTextNote, tags, strings.TrimSuffix(content.String(), "\n"))
+	if err := event.Sign(secret); err != nil {
+		return fmt.Errorf("error signing push notification: %w", err)
+	}
+	accepted := publishEvent(event, relays)
+	if accepted == 0 {
+		return fmt.Errorf("no relay accepted it")
+	}
+	infof("Notified %d of %d relays of the push (event %s)\n", accepted, len(relays), shortHash(event.ID))
+	return nil
+}
@@ -0,0 +1,272 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// Reconcile compares the git history with the mapping store and the stored
+// MGit objects, and repairs what can be derived locally:
+//
+//   - a mapped commit whose MGit object is missing gets the object rebuilt
+//     from its git commit
+//   - a stored MGit object with no mapping gets its mapping back, marked
+//     "reconstructed"
+//
+// Git commits with no mapping at all can only be filled in from the server
+// (--fetch), since their pubkeys aren't known locally. Mappings whose git
+// commit is absent are reported; shallow and single-branch clones have them.
+
+const reconcileUsage = "Usage: mgit reconcile [--fetch] [--dry-run]"
+
+// ReconcileReport is what a reconcile found and did
+type ReconcileReport struct {
+	GitCommits  int
+	Mappings    int
+	Objects     int
+	Regenerated []NostrCommitMapping // MGit objects rebuilt from git commits
+	Restored    []NostrCommitMapping // Mappings recovered from stored objects
+	Fetched     []NostrCommitMapping // Mappings filled in from the server
+	Unmapped    []*object.Commit     // Git commits still without a mapping
+	Orphaned    []NostrCommitMapping // Mappings whose git commit is absent
+	Mismatched  []NostrCommitMapping // Mappings their git commit doesn't reproduce
+}
+
+// HandleReconcile handles the reconcile command
+func HandleReconcile(args []string) {
+	fetch, dryRun := false, false
+	for _, arg := range args {
+		switch arg {
+		case "--fetch":
+			fetch = true
+		case "-n", "--dry-run":
+			dryRun = true
+		default:
+			fmt.Println(reconcileUsage)
+			os.Exit(1)
+		}
+	}
+
+	session := currentSession()
+	session.MustRepo()
+	if err := session.Storage().Initialize(); err != nil {
+		fmt.Printf("Error initializing MGit storage: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Released before exiting, so a failed reconcile doesn't leave the
+	// repository locked
+	var lock *fsutil.Lock
+	if !dryRun {
+		lock = lockRepoOrExit("reconcile")
+	}
+	report, err := reconcile(session, fetch, dryRun)
+	if lock != nil {
+		lock.Release()
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if !printReconcileReport(report, dryRun) {
+		os.Exit(1)
+	}
+}
+
+// reconcile diffs the git history against the mappings and MGit objects and,
+// unless dryRun, stores what it could rebuild, recover or fetch
+func reconcile(session *Session, fetch, dryRun bool) (*ReconcileReport, error) {
+	repo := session.MustRepo()
+	storage := session.Storage()
+	report := &ReconcileReport{}
+
+	commits, err := commitsParentsFirst(repo)
+	if err != nil {
+		return nil, err
+	}
+	report.GitCommits = len(commits)
+
+	mappings, err := storage.GetMappings()
+	if err != nil {
+		return nil, err
+	}
+	report.Mappings = len(mappings)
+	byGit := make(map[string]string, len(mappings))
+	mapped := make(map[string]bool, len(mappings))
+	for _, mapping := range mappings {
+		byGit[mapping.GitHash] = mapping.MGitHash
+		mapped[mapping.MGitHash] = true
+	}
+
+	// Stored MGit commits, read in parallel; other object types are skipped
+	hashes, err := storage.ListObjects()
+	if err != nil {
+		return nil, err
+	}
+	stored := make([]*mgitlib.Commit, len(hashes))
+	runJobs(len(hashes), func(_, i int) {
+		stored[i], _ = storage.GetCommit(hashes[i])
+	})
+	objects := map[string]bool{}
+	for i, commit := range stored {
+		if commit == nil {
+			continue
+		}
+		objects[hashes[i]] = true
+		report.Objects++
+
+		// An object whose mapping was lost, and whose hash its git commit
+		// (if present) still reproduces, gives the mapping back
+		if mapped[hashes[i]] || commit.GitHash == "" || byGit[commit.GitHash] != "" {
+			continue
+		}
+		mapping := NostrCommitMapping{GitHash: commit.GitHash, MGitHash: hashes[i], Pubkey: commit.Author.Pubkey, Source: mappingSourceReconstructed}
+		if gitCommit, err := repo.CommitObject(plumbing.NewHash(commit.GitHash)); err == nil {
+			expected := mgitlib.GitHashInput(gitCommit, commit.ParentHashes, commit.Author.Pubkey)
+			expected.Format = commit.Format
+			if mgitlib.Hash(expected).String() != hashes[i] {
+				report.Mismatched = append(report.Mismatched, mapping)
+				continue
+			}
+		}
+		report.Restored = append(report.Restored, mapping)
+		byGit[mapping.GitHash] = mapping.MGitHash
+		mapped[mapping.MGitHash] = true
+	}
+
+	// Git commits nothing maps, filled in from the server if asked
+	unmapped := []*object.Commit{}
+	for _, commit := range commits {
+		if byGit[commit.Hash.String()] == "" {
+			unmapped = append(unmapped, commit)
+		}
+	}
+	if fetch && len(unmapped) > 0 {
+		remote, err := (&serverMappingSource{}).fetch()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching mappings from the server: %w", err)
+		}
+		fromServer := make(map[string]NostrCommitMapping, len(remote))
+		for _, mapping := range remote {
+			fromServer[mapping.GitHash] = mapping
+		}
+		for _, commit := range unmapped {
+			if mapping, ok := fromServer[commit.Hash.String()]; ok && !mapped[mapping.MGitHash] {
+				report.Fetched = append(report.Fetched, mapping)
+				byGit[mapping.GitHash] = mapping.MGitHash
+				mapped[mapping.MGitHash] = true
+			}
+		}
+	}
+	for _, commit := range unmapped {
+		if byGit[commit.Hash.String()] == "" {
+			report.Unmapped = append(report.Unmapped, commit)
+		}
+	}
+
+	// Every mapping, old or new, whose object is missing is rebuilt from
+	// its git commit if that is here
+	all := append(append(append([]NostrCommitMapping{}, mappings...), report.Restored...), report.Fetched...)
+	missing := []NostrCommitMapping{}
+	for _, mapping := range all {
+		if _, err := repo.CommitObject(plumbing.NewHash(mapping.GitHash)); err != nil {
+			report.Orphaned = append(report.Orphaned, mapping)
+		} else if !objects[mapping.MGitHash] {
+			missing = append(missing, mapping)
+		}
+	}
+	rebuilt := make([]*mgitlib.Commit, len(missing))
+	failed := make([]error, len(missing))
+	repos := newWorkerRepos(session.Path, repo)
+	runJobs(len(missing), func(worker, i int) {
+		workerRepo, err := repos.get(worker)
+		if err != nil {
+			failed[i] = err
+			return
+		}
+		commit, err := workerRepo.CommitObject(plumbing.NewHash(missing[i].GitHash))
+		if err != nil {
+			failed[i] = err
+			return
+		}
+		mgitCommit, ok := mgitlib.RebuildCommit(commit, missing[i], byGit)
+		if !ok {
+			return
+		}
+		rebuilt[i] = mgitCommit
+		if !dryRun {
+			failed[i] = storage.StoreCommit(mgitCommit)
+		}
+	})
+	for i, mapping := range missing {
+		if failed[i] != nil {
+			return nil, fmt.Errorf("error regenerating MGit commit %s: %w", shortHash(mapping.MGitHash), failed[i])
+		}
+		if rebuilt[i] == nil {
+			report.Mismatched = append(report.Mismatched, mapping)
+		} else {
+			report.Regenerated = append(report.Regenerated, mapping)
+		}
+	}
+
+	if !dryRun && len(report.Restored)+len(report.Fetched) > 0 {
+		if err := storage.Mappings().PutAll(append(append([]NostrCommitMapping{}, report.Restored...), report.Fetched...)); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// printReconcileReport prints what reconcile found and did. It returns false
+// if problems remain that reconcile could not repair.
+func printReconcileReport(report *ReconcileReport, dryRun bool) bool {
+	verb := func(done, would string) string {
+		if dryRun {
+			return would
+		}
+		return done
+	}
+
+	fmt.Printf("Checked %d git commit(s), %d mapping(s), %d MGit commit object(s)\n",
+		report.GitCommits, report.Mappings, report.Objects)
+	for _, mapping := range report.Regenerated {
+		fmt.Printf("%s MGit commit %s from git %s\n", verb("Regenerated", "Would regenerate"),
+			shortHash(mapping.MGitHash), shortHash(mapping.GitHash))
+	}
+	for _, mapping := range report.Restored {
+		fmt.Printf("%s mapping %s -> %s from the stored MGit commit\n", verb("Restored", "Would restore"),
+			shortHash(mapping.GitHash), shortHash(mapping.MGitHash))
+	}
+	for _, mapping := range report.Fetched {
+		fmt.Printf("%s mapping %s -> %s from the server\n", verb("Fetched", "Would fetch"),
+			shortHash(mapping.GitHash), shortHash(mapping.MGitHash))
+	}
+
+	if len(report.Orphaned) > 0 {
+		fmt.Printf("%d mapping(s) for git commits not present locally (expected in shallow or single-branch clones)\n",
+			len(report.Orphaned))
+	}
+	for _, mapping := range report.Mismatched {
+		fmt.Printf("error: MGit commit %s does not match git commit %s; not repaired\n",
+			shortHash(mapping.MGitHash), shortHash(mapping.GitHash))
+	}
+	for _, commit := range report.Unmapped {
+		fmt.Printf("error: git commit %s has no MGit mapping: %s\n", shortHash(commit.Hash.String()), commitSubject(commit.Message))
+	}
+	if len(report.Unmapped) > 0 {
+		fmt.Println("Run mgit reconcile --fetch to look them up on the server, or mgit migrate to create them")
+	}
+
+	if len(report.Unmapped)+len(report.Mismatched) > 0 {
+		return false
+	}
+	if len(report.Regenerated)+len(report.Restored)+len(report.Fetched) == 0 {
+		fmt.Println("Git history and MGit mappings are consistent")
+	}
+	return true
+}
@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// Every move of .mgit/HEAD or a branch is appended to a reflog under
+// .mgit/logs, in git's format:
+//
+//	<old hash> <new hash> <name> <<email>> <unix time> <+hhmm>\t<reason>
+//
+// A branch move also goes into HEAD's log while HEAD points at the branch.
+// "<ref>@{n}" names the value a ref had n moves ago (@{0} is its current
+// value); "@{n}" alone means the current branch.
+
+// zeroHash stands in the reflog for a ref that did not exist
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// ReflogEntry is one recorded move of a ref
+type ReflogEntry struct {
+	Old     string
+	New     string
+	Name    string
+	Email   string
+	When    time.Time
+	Message string
+}
+
+// reflogPath returns where refName's log is kept
+func (s *MGitStorage) reflogPath(refName string) string {
+	return filepath.Join(s.RootDir, "logs", refName)
+}
+
+// keepsReflog reports whether moves of refName are logged; like git, only
+// HEAD and branches are
+func keepsReflog(refName string) bool {
+	return refName == "HEAD" || strings.HasPrefix(refName, "refs/heads/")
+}
+
+// resolveRefContent turns a ref file's content into the hash it stands for,
+// following a symbolic ref; "" if there is none
+func (s *MGitStorage) resolveRefContent(content string) string {
+	if strings.HasPrefix(content, "ref: ") {
+		hash, err := s.GetRef(strings.TrimPrefix(content, "ref: "))
+		if err != nil {
+			return ""
+		}
+		return hash
+	}
+	return content
+}
+
+// logRefUpdate records that refName moved from old to new, and does the same
+// for HEAD if it points at refName
+func (s *MGitStorage) logRefUpdate(refName, old, new, reason string) error {
+	if !keepsReflog(refName) || (old == "" && new == "") {
+		return nil
+	}
+	if err := s.appendReflog(refName, old, new, reason); err != nil {
+		return err
+	}
+	if refName != "HEAD" {
+		if head, err := s.GetHead(); err == nil && head == refName {
+			return s.appendReflog("HEAD", old, new, reason)
+		}
+	}
+	return nil
+}
+
+// appendReflog adds one entry to refName's log
+func (s *MGitStorage) appendReflog(refName, old, new, reason string) error {
+	if old == "" {
+		old = zeroHash
+	}
+	if new == "" {
+		new = zeroHash
+	}
+	now := time.Now()
+	line := fmt.Sprintf("%s %s %s <%s> %d %s\t%s\n", old, new,
+		mgitlib.SanitizeIdent(GetConfigValue("user.name", "unknown")), mgitlib.SanitizeIdent(GetConfigValue("user.email", "")),
+		now.Unix(), now.Format("-0700"), strings.ReplaceAll(reason, "\n", " "))
+
+	path := s.reflogPath(refName)
+	lock, err := fsutil.LockFile(path, fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog: %w", err)
+	}
+	if err := fsutil.WriteAndSync(file, []byte(line)); err != nil {
+		return fmt.Errorf("failed to write reflog: %w", err)
+	}
+	return nil
+}
+
+// ReadReflog returns refName's log, newest entry first. Lines that can't be
+// parsed, such as one cut short by a crash, are skipped.
+func (s *MGitStorage) ReadReflog(refName string) ([]ReflogEntry, error) {
+	file, err := os.Open(s.reflogPath(refName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reflog: %w", err)
+	}
+	defer file.Close()
+
+	entries := []ReflogEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if entry, ok := parseReflogLine(scanner.Text()); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reflog: %w", err)
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// parseReflogLine parses one reflog line
+func parseReflogLine(line string) (ReflogEntry, bool) {
+	header, message, ok := strings.Cut(line, "\t")
+	if !ok || len(header) < 82 {
+		return ReflogEntry{}, false
+	}
+	hashes := strings.Fields(header[:82])
+	if len(hashes) != 2 || !isFullHash(hashes[0]) || !isFullHash(hashes[1]) {
+		return ReflogEntry{}, false
+	}
+	sig, err := mgitlib.DecodeSignature(header[82:])
+	if err != nil {
+		return ReflogEntry{}, false
+	}
+	entry := ReflogEntry{Old: hashes[0], New: hashes[1], Name: sig.Name, Email: sig.Email, When: sig.When, Message: message}
+	if entry.Old == zeroHash {
+		entry.Old = ""
+	}
+	if entry.New == zeroHash {
+		entry.New = ""
+	}
+	return entry, true
+}
+
+// reflogHashes returns every hash named in any reflog
+func (s *MGitStorage) reflogHashes() ([]string, error) {
+	logsDir := filepath.Join(s.RootDir, "logs")
+	hashes := []string{}
+	err := filepath.Walk(logsDir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		refName, err := filepath.Rel(s.RootDir, path)
+		if err != nil {
+			return err
+		}
+		entries, err := s.ReadReflog(strings.TrimPrefix(filepath.ToSlash(refName), "logs/"))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			hashes = append(hashes, entry.Old, entry.New)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reflogs: %w", err)
+	}
+	return hashes, nil
+}
+
+// parseReflogSpec splits "<ref>@{n}" into its ref and n. ok is false for
+// names without a reflog suffix, and for @{upstream}.
+func parseReflogSpec(name string) (ref string, n int, ok bool, err error) {
+	at := strings.Index(name, "@{")
+	if at == -1 || !strings.HasSuffix(name, "}") {
+		return "", 0, false, nil
+	}
+	if _, ok := upstreamSpec(name); ok {
+		return "", 0, false, nil
+	}
+	n, err = strconv.Atoi(name[at+2 : len(name)-1])
+	if err != nil || n < 0 {
+		return "", 0, true, fmt.Errorf("'%s': reflog entries are numbered from @{0}", name)
+	}
+	return name[:at], n, true, nil
+}
+
+// reflogRefName turns the ref part of "<ref>@{n}" into a full ref name. An
+// empty ref means the current branch, or HEAD when detached.
+func (s *MGitStorage) reflogRefName(ref string) (string, error) {
+	switch {
+	case ref == "":
+		head, err := s.GetHead()
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(head, "refs/") {
+			return head, nil
+		}
+		return "HEAD", nil
+	case ref == "HEAD" || strings.HasPrefix(ref, "refs/"):
+		return ref, nil
+	default:
+		return "refs/heads/" + ref, nil
+	}
+}
+
+// resolveReflogSpec returns the MGit hash "<ref>@{n}" names
+func (s *MGitStorage) resolveReflogSpec(ref string, n int) (string, error) {
+	refName, err := s.reflogRefName(ref)
+	if err != nil {
+		return "", err
+	}
+	entries, err := s.ReadReflog(refName)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(entries) {
+		return "", fmt.Errorf("log for '%s' only has %d entries", shortRefName(refName), len(entries))
+	}
+	if entries[n].New == "" {
+		return "", fmt.Errorf("%s@{%d} is a deleted ref", shortRefName(refName), n)
+	}
+	return entries[n].New, nil
+}
+
+// shortRefName drops the refs/heads/ prefix for display
+func shortRefName(refName string) string {
+	return strings.TrimPrefix(refName, "refs/heads/")
+}
+
+// commitSubject returns the first line of a commit message
+func commitSubject(message string) string {
+	subject, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	return subject
+}
+
+// HandleReflog handles the reflog command
+func HandleReflog(args []string) {
+	if len(args) > 0 && args[0] == "show" {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		fmt.Println("Usage: mgit reflog [show] [<ref>]")
+		os.Exit(1)
+	}
+	ref := "HEAD"
+	if len(args) == 1 {
+		ref = args[0]
+	}
+
+	storage := NewMGitStorage()
+	refName, err := storage.reflogRefName(ref)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	entries, err := storage.ReadReflog(refName)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	for i, entry := range entries {
+		hash := "0000000"
+		if entry.New != "" {
+			hash = shortHash(entry.New)
+		}
+		fmt.Printf("%s %s@{%d}: %s\n", hash, shortRefName(ref), i, entry.Message)
+	}
+}
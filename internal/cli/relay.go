@@ -0,0 +1,517 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// Everything mgit sends to or asks of nostr relays goes through one shared
+// RelayPool. It keeps a connection per relay open for the life of the
+// command, bounds every exchange with nostr.relayTimeout, reconnects and
+// retries a relay that can't be reached up to nostr.relayRetries times, and
+// reads each relay's NIP-11 information document to skip relays that can't
+// take an event (too large, or needing NIP-42 authentication) before trying.
+
+const (
+	// defaultRelayTimeout bounds connecting to a relay and waiting for its
+	// answer; override with nostr.relayTimeout
+	defaultRelayTimeout = 10 * time.Second
+	// defaultRelayRetries is how many more times an unreachable relay is
+	// tried; override with nostr.relayRetries
+	defaultRelayRetries = 2
+	// relayInfoTimeout bounds fetching a relay's NIP-11 document, which many
+	// relays don't serve
+	relayInfoTimeout = 3 * time.Second
+)
+
+// RelayResult is a relay's answer to a published event
+type RelayResult struct {
+	Relay    string
+	Accepted bool
+	Message  string
+	Err      error
+}
+
+// RelayInfo is a relay's NIP-11 information document
+type RelayInfo struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Pubkey        string `json:"pubkey"`
+	Contact       string `json:"contact"`
+	Software      string `json:"software"`
+	Version       string `json:"version"`
+	SupportedNIPs []int  `json:"supported_nips"`
+	Limitation    struct {
+		MaxMessageLength int  `json:"max_message_length"`
+		MaxContentLength int  `json:"max_content_length"`
+		AuthRequired     bool `json:"auth_required"`
+		PaymentRequired  bool `json:"payment_required"`
+		RestrictedWrites bool `json:"restricted_writes"`
+	} `json:"limitation"`
+}
+
+// Supports reports whether the relay lists a NIP as supported
+func (i *RelayInfo) Supports(nip int) bool {
+	for _, n := range i.SupportedNIPs {
+		if n == nip {
+			return true
+		}
+	}
+	return false
+}
+
+// NostrFilter is a NIP-01 subscription filter, e.g. {"kinds": [1617],
+// "#a": ["30617:<pubkey>:<d>"]}
+type NostrFilter map[string]interface{}
+
+// RelayPool shares relay connections and NIP-11 documents across a command
+type RelayPool struct {
+	Timeout time.Duration
+	Retries int
+
+	mu    sync.Mutex
+	conns map[string]*relayConn
+	infos map[string]*relayInfoEntry
+}
+
+// relayConn is a pooled connection; its lock serializes exchanges with the
+// relay, so each reads only its own answers
+type relayConn struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+// relayInfoEntry is a fetched (or failed) NIP-11 document
+type relayInfoEntry struct {
+	once sync.Once
+	info *RelayInfo
+	err  error
+}
+
+// relayRefusal is an answer from a relay that retrying won't change
+type relayRefusal struct {
+	err error
+}
+
+func (r *relayRefusal) Error() string { return r.err.Error() }
+
+var (
+	sharedRelayPool     *RelayPool
+	sharedRelayPoolOnce sync.Once
+)
+
+// relayPool returns the pool shared by the command
+func relayPool() *RelayPool {
+	sharedRelayPoolOnce.Do(func() {
+		sharedRelayPool = newRelayPool()
+	})
+	return sharedRelayPool
+}
+
+// newRelayPool creates a pool with the configured timeout and retries
+func newRelayPool() *RelayPool {
+	timeout := GetConfigDuration("nostr.relayTimeout", defaultRelayTimeout)
+	if timeout <= 0 {
+		timeout = defaultRelayTimeout
+	}
+	retries := GetConfigInt("nostr.relayRetries", defaultRelayRetries)
+	if retries < 0 {
+		retries = defaultRelayRetries
+	}
+	return &RelayPool{
+		Timeout: timeout,
+		Retries: retries,
+		conns:   map[string]*relayConn{},
+		infos:   map[string]*relayInfoEntry{},
+	}
+}
+
+// configuredRelays returns the relays in nostr.relays, which may be given
+// more than once or list several separated by commas
+func configuredRelays() []string {
+	return GetConfigValues("nostr.relays")
+}
+
+// validateRelayURL checks a relay URL is a ws:// or wss:// URL
+func validateRelayURL(relay string) error {
+	u, err := url.Parse(relay)
+	if err != nil {
+		return fmt.Errorf("invalid relay URL %s: %w", relay, err)
+	}
+	if (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return fmt.Errorf("invalid relay URL %s: must be ws:// or wss://", relay)
+	}
+	return nil
+}
+
+// Info returns a relay's NIP-11 document, fetched once per command
+func (p *RelayPool) Info(relay string) (*RelayInfo, error) {
+	p.mu.Lock()
+	entry, ok := p.infos[relay]
+	if !ok {
+		entry = &relayInfoEntry{}
+		p.infos[relay] = entry
+	}
+	p.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.info, entry.err = fetchRelayInfo(relay)
+	})
+	return entry.info, entry.err
+}
+
+// fetchRelayInfo asks a relay's HTTP side for its NIP-11 document
+func fetchRelayInfo(relay string) (*RelayInfo, error) {
+	u, err := url.Parse(relay)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+	resp, err := (&http.Client{Timeout: relayInfoTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("no relay information (HTTP %d)", resp.StatusCode)
+	}
+	info := &RelayInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, fmt.Errorf("invalid relay information: %w", err)
+	}
+	return info, nil
+}
+
+// exchange runs fn on the relay's pooled connection, connecting first if
+// needed. A failed connection is dropped and fn retried on a new one, up to
+// p.Retries times; a relayRefusal is returned as it is. Canceling ctx cuts
+// the exchange short and drops the connection.
+func (p *RelayPool) exchange(ctx context.Context, relay string, fn func(ws *websocket.Conn) error) error {
+	p.mu.Lock()
+	conn, ok := p.conns[relay]
+	if !ok {
+		conn = &relayConn{}
+		p.conns[relay] = conn
+	}
+	p.mu.Unlock()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	var err error
+	for attempt := 0; attempt <= p.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if conn.ws == nil {
+			config, cfgErr := websocket.NewConfig(relay, "http://localhost/")
+			if cfgErr != nil {
+				return fmt.Errorf("invalid relay URL: %w", cfgErr)
+			}
+			config.Dialer = &net.Dialer{Timeout: p.Timeout}
+			ws, dialErr := websocket.DialConfig(config)
+			if dialErr != nil {
+				err = fmt.Errorf("error connecting: %w", dialErr)
+				continue
+			}
+			conn.ws = ws
+		}
+
+		conn.ws.SetDeadline(time.Now().Add(p.Timeout))
+		done := make(chan struct{})
+		go func(ws *websocket.Conn) {
+			select {
+			case <-ctx.Done():
+				// Unblocks the read or write fn is waiting on
+				ws.SetDeadline(time.Now())
+			case <-done:
+			}
+		}(conn.ws)
+		err = fn(conn.ws)
+		close(done)
+		if ctx.Err() != nil {
+			conn.ws.Close()
+			conn.ws = nil
+			return ctx.Err()
+		}
+		var refusal *relayRefusal
+		if err == nil || errors.As(err, &refusal) {
+			return err
+		}
+		conn.ws.Close()
+		conn.ws = nil
+	}
+	return err
+}
+
+// Close closes the pool's connections
+func (p *RelayPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.mu.Lock()
+		if conn.ws != nil {
+			conn.ws.Close()
+			conn.ws = nil
+		}
+		conn.mu.Unlock()
+	}
+}
+
+// checkCapabilities reports why a relay's NIP-11 document says it won't
+// take an event; relays without one are tried anyway
+func (p *RelayPool) checkCapabilities(relay string, event *nostr.Event, size int) error {
+	info, err := p.Info(relay)
+	if err != nil {
+		return nil
+	}
+	limits := info.Limitation
+	switch {
+	case limits.AuthRequired:
+		return fmt.Errorf("relay requires NIP-42 authentication")
+	case limits.MaxMessageLength > 0 && size > limits.MaxMessageLength:
+		return fmt.Errorf("event is %d bytes; relay accepts at most %d", size, limits.MaxMessageLength)
+	case event != nil && limits.MaxContentLength > 0 && len(event.Content) > limits.MaxContentLength:
+		return fmt.Errorf("event content is %d bytes; relay accepts at most %d", len(event.Content), limits.MaxContentLength)
+	}
+	return nil
+}
+
+// Publish sends an event to every relay and collects their answers
+func (p *RelayPool) Publish(ctx context.Context, event *nostr.Event, relays []string) []RelayResult {
+	results := make([]RelayResult, len(relays))
+	var wg sync.WaitGroup
+	for i, relay := range relays {
+		wg.Add(1)
+		go func(i int, relay string) {
+			defer wg.Done()
+			results[i] = p.publish(ctx, event, relay)
+		}(i, relay)
+	}
+	wg.Wait()
+	return results
+}
+
+// publish sends ["EVENT", event] and waits for the relay's
+// ["OK", id, accepted, message] (NIP-20)
+func (p *RelayPool) publish(ctx context.Context, event *nostr.Event, relay string) RelayResult {
+	result := RelayResult{Relay: relay}
+	msg, err := json.Marshal([]interface{}{"EVENT", event})
+	if err != nil {
+		result.Err = fmt.Errorf("error encoding event: %w", err)
+		return result
+	}
+	if err := p.checkCapabilities(relay, event, len(msg)); err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Err = p.exchange(ctx, relay, func(ws *websocket.Conn) error {
+		if err := websocket.Message.Send(ws, string(msg)); err != nil {
+			return fmt.Errorf("error sending event: %w", err)
+		}
+		for {
+			var reply string
+			if err := websocket.Message.Receive(ws, &reply); err != nil {
+				return fmt.Errorf("no answer from relay: %w", err)
+			}
+
+			var fields []json.RawMessage
+			if err := json.Unmarshal([]byte(reply), &fields); err != nil || len(fields) < 2 {
+				continue
+			}
+			var label string
+			json.Unmarshal(fields[0], &label)
+
+			switch label {
+			case "OK":
+				var id string
+				json.Unmarshal(fields[1], &id)
+				if id != event.ID || len(fields) < 3 {
+					continue
+				}
+				json.Unmarshal(fields[2], &result.Accepted)
+				if len(fields) > 3 {
+					json.Unmarshal(fields[3], &result.Message)
+				}
+				return nil
+			case "NOTICE":
+				json.Unmarshal(fields[1], &result.Message)
+			}
+		}
+	})
+	return result
+}
+
+// Query asks every relay for the stored events matching filter and returns
+// those with a valid signature, once each. It fails only when no relay could
+// be queried.
+func (p *RelayPool) Query(ctx context.Context, filter NostrFilter, relays []string) ([]*nostr.Event, error) {
+	type answer struct {
+		events []*nostr.Event
+		err    error
+	}
+	answers := make([]answer, len(relays))
+	var wg sync.WaitGroup
+	for i, relay := range relays {
+		wg.Add(1)
+		go func(i int, relay string) {
+			defer wg.Done()
+			events, err := p.query(ctx, filter, relay)
+			answers[i] = answer{events, err}
+		}(i, relay)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	events := []*nostr.Event{}
+	seen := map[string]bool{}
+	var lastErr error
+	answered := 0
+	for i, answer := range answers {
+		if answer.err != nil {
+			lastErr = fmt.Errorf("%s: %w", relays[i], answer.err)
+			continue
+		}
+		answered++
+		for _, event := range answer.events {
+			if seen[event.ID] || event.Verify() != nil {
+				continue
+			}
+			seen[event.ID] = true
+			events = append(events, event)
+		}
+	}
+	if answered == 0 {
+		if lastErr == nil {
+			return nil, fmt.Errorf("no relays to query")
+		}
+		return nil, lastErr
+	}
+	return events, nil
+}
+
+// query sends ["REQ", id, filter] and collects events until the relay
+// signals the end of its stored events (NIP-15 EOSE)
+func (p *RelayPool) query(ctx context.Context, filter NostrFilter, relay string) ([]*nostr.Event, error) {
+	subID := fmt.Sprintf("mgit-%d", time.Now().UnixNano())
+	msg, err := json.Marshal([]interface{}{"REQ", subID, filter})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding filter: %w", err)
+	}
+	if err := p.checkCapabilities(relay, nil, len(msg)); err != nil {
+		return nil, err
+	}
+
+	var events []*nostr.Event
+	err = p.exchange(ctx, relay, func(ws *websocket.Conn) error {
+		events = []*nostr.Event{}
+		if err := websocket.Message.Send(ws, string(msg)); err != nil {
+			return fmt.Errorf("error sending request: %w", err)
+		}
+		for {
+			var reply string
+			if err := websocket.Message.Receive(ws, &reply); err != nil {
+				return fmt.Errorf("no answer from relay: %w", err)
+			}
+
+			var fields []json.RawMessage
+			if err := json.Unmarshal([]byte(reply), &fields); err != nil || len(fields) < 2 {
+				continue
+			}
+			var label, id string
+			json.Unmarshal(fields[0], &label)
+			json.Unmarshal(fields[1], &id)
+			if id != subID {
+				continue
+			}
+
+			switch label {
+			case "EVENT":
+				if len(fields) < 3 {
+					continue
+				}
+				event := &nostr.Event{}
+				if err := json.Unmarshal(fields[2], event); err == nil {
+					events = append(events, event)
+				}
+			case "EOSE":
+				if closeMsg, err := json.Marshal([]interface{}{"CLOSE", subID}); err == nil {
+					websocket.Message.Send(ws, string(closeMsg))
+				}
+				return nil
+			case "CLOSED":
+				message := ""
+				if len(fields) > 2 {
+					json.Unmarshal(fields[2], &message)
+				}
+				return &relayRefusal{fmt.Errorf("subscription closed: %s", message)}
+			}
+		}
+	})
+	return events, err
+}
+
+// Ping connects to a relay and runs an empty subscription, returning how
+// long the round trip took
+func (p *RelayPool) Ping(ctx context.Context, relay string) (time.Duration, error) {
+	start := time.Now()
+	_, err := p.query(ctx, NostrFilter{"limit": 0, "ids": []string{strings.Repeat("0", 64)}}, relay)
+	return time.Since(start), err
+}
+
+// publishToRelays sends an event to every relay through the shared pool,
+// under the command context
+func publishToRelays(event *nostr.Event, relays []string) []RelayResult {
+	return relayPool().Publish(commandContext(), event, relays)
+}
+
+// queryRelays asks every relay for events matching filter through the
+// shared pool, under the command context
+func queryRelays(filter NostrFilter, relays []string) ([]*nostr.Event, error) {
+	return relayPool().Query(commandContext(), filter, relays)
+}
+
+// publishEvent sends an event to every relay, reporting those that fail or
+// reject it, and returns how many accepted it
+func publishEvent(event *nostr.Event, relays []string) int {
+	accepted := 0
+	for _, result := range publishToRelays(event, relays) {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("  %s: %s\n", result.Relay, result.Err)
+		case !result.Accepted:
+			fmt.Printf("  %s: rejected: %s\n", result.Relay, result.Message)
+		default:
+			accepted++
+		}
+	}
+	return accepted
+}
@@ -0,0 +1,366 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// git-remote-mgit lets stock git talk to an mgit server:
+//
+//	git clone mgit::https://server/api/mgit/repos/<id>
+//	git remote add origin mgit::https://server/api/mgit/repos/<id>
+//
+// git runs the helper for mgit:: URLs (and mgit://, taken as https://). It
+// is the mgit binary under another name; mgit remote-helper install links it
+// next to mgit, which must be on PATH. The helper finds credentials for the
+// URL as mgit push does and hands the transfer to git's own HTTP helper with
+// the Authorization header and http settings applied. Around it:
+//
+//   - after a fetch, the server's hash mappings and notes are synced into
+//     .mgit
+//   - after a push that updated a ref, the local mappings and notes are
+//     uploaded
+//
+// git updates branches only once the helper has exited, so the MGit objects
+// and refs are brought in step afterwards by post-checkout and post-merge
+// hooks the helper installs (mgit remote-helper sync). .mgit is added to
+// .git/info/exclude. Bare repositories get the metadata but not the hooks.
+
+const (
+	remoteHelperName  = "git-remote-mgit"
+	remoteHelperUsage = "Usage: mgit remote-helper install [<dir>] | sync"
+	// remoteHelperHookMarker identifies hooks the helper installed
+	remoteHelperHookMarker = "# Installed by git-remote-mgit"
+	// remoteHelperPending marks fetched metadata not yet reconstructed
+	remoteHelperPending = "remote-helper-pending"
+)
+
+// remoteHelperHooks are the hooks that run after git moves HEAD or a branch
+var remoteHelperHooks = []string{"post-checkout", "post-merge"}
+
+// isRemoteHelper reports whether mgit was run as git-remote-mgit
+func isRemoteHelper() bool {
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == remoteHelperName
+}
+
+// HandleRemoteHelper handles the remote-helper command
+func HandleRemoteHelper(args []string) {
+	if len(args) == 0 {
+		fmt.Println(remoteHelperUsage)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "install":
+		if len(args) > 2 {
+			fmt.Println(remoteHelperUsage)
+			os.Exit(1)
+		}
+		dir := ""
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		path, err := installRemoteHelper(dir)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Installed %s\n", path)
+		if _, err := exec.LookPath(remoteHelperName); err != nil {
+			fmt.Printf("Warning: %s is not on PATH; add %s to PATH so git can find it\n", remoteHelperName, filepath.Dir(path))
+		}
+	case "sync":
+		// Run from hooks: a failure is reported but must not fail git
+		if err := remoteHelperSync("."); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: mgit: %s\n", err)
+		}
+	default:
+		fmt.Println(remoteHelperUsage)
+		os.Exit(1)
+	}
+}
+
+// installRemoteHelper links git-remote-mgit to the running mgit binary in
+// dir, by default the binary's own directory
+func installRemoteHelper(dir string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate the mgit binary: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", fmt.Errorf("cannot locate the mgit binary: %w", err)
+	}
+	if dir == "" {
+		dir = filepath.Dir(exe)
+	}
+	path := filepath.Join(dir, remoteHelperName)
+	if existing, err := os.Readlink(path); err == nil && existing == exe {
+		return path, nil
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	if err := os.Symlink(exe, path); err != nil {
+		return "", fmt.Errorf("error linking %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// runRemoteHelper speaks git's remote helper protocol on stdin and stdout,
+// relaying it to git's HTTP helper
+func runRemoteHelper(args []string) {
+	// stdout carries the protocol; everything mgit prints goes to stderr
+	protocol := os.Stdout
+	os.Stdout = os.Stderr
+
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Printf("Usage: %s <remote> [<url>]\n", remoteHelperName)
+		os.Exit(1)
+	}
+	remoteURL := args[len(args)-1]
+	if strings.HasPrefix(remoteURL, "mgit://") {
+		remoteURL = "https://" + strings.TrimPrefix(remoteURL, "mgit://")
+	}
+	if !isServerURL(remoteURL) {
+		fmt.Printf("Error: %s needs an http(s) URL to an mgit server, not '%s'\n", remoteHelperName, remoteURL)
+		os.Exit(1)
+	}
+	scheme := remoteURL[:strings.Index(remoteURL, "://")]
+
+	auth, err := lookupAuth(remoteURL)
+	if err != nil {
+		fmt.Printf("Warning: no credentials for %s (%s); run mgit login %s\n", remoteURL, err, remoteURL)
+	}
+	gitArgs := serverGitArgs(remoteURL)
+	if auth != nil {
+		gitArgs = append(gitArgs, "-c", auth.gitConfig())
+	}
+	// Protocol v2 needs stateless-connect, which the relay holds back
+	gitArgs = append(gitArgs, "-c", "protocol.version=0", "remote-"+scheme, args[0], remoteURL)
+
+	cmd := exec.Command("git", gitArgs...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		var stdout io.ReadCloser
+		if stdout, err = cmd.StdoutPipe(); err == nil {
+			if err = cmd.Start(); err == nil {
+				fetched, pushed := relayRemoteHelper(os.Stdin, stdin, stdout, protocol)
+				err = cmd.Wait()
+				if err == nil {
+					remoteHelperMetadata(remoteURL, auth, fetched, pushed)
+				}
+			}
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: git remote-%s: %s\n", scheme, err)
+		os.Exit(1)
+	}
+}
+
+// relayRemoteHelper copies the protocol between git and its HTTP helper
+// line by line until the HTTP helper exits, noting whether objects were
+// fetched or a ref was pushed. stateless-connect is held back so git uses
+// the line-based fetch and push commands the relay can follow.
+func relayRemoteHelper(fromGit io.Reader, toHelper io.WriteCloser, fromHelper io.Reader, toGit io.Writer) (fetched, pushed bool) {
+	var sawFetch atomic.Bool
+	go func() {
+		defer toHelper.Close()
+		reader := bufio.NewReader(fromGit)
+		for {
+			line, err := reader.ReadString('\n')
+			if strings.HasPrefix(line, "fetch ") {
+				sawFetch.Store(true)
+			}
+			if line != "" {
+				if _, werr := io.WriteString(toHelper, line); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(fromHelper)
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.TrimSpace(line) == "stateless-connect" {
+			continue
+		}
+		if strings.HasPrefix(line, "ok ") {
+			pushed = true
+		}
+		if line != "" {
+			if _, werr := io.WriteString(toGit, line); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return sawFetch.Load(), pushed
+}
+
+// remoteHelperMetadata syncs MGit metadata with the server after a transfer.
+// Failures are warnings: the git side of the transfer has succeeded.
+func remoteHelperMetadata(remoteURL string, auth *Credentials, fetched, pushed bool) {
+	if auth == nil || (!fetched && !pushed) || os.Getenv("GIT_DIR") == "" {
+		return
+	}
+	gitDir, err := filepath.Abs(os.Getenv("GIT_DIR"))
+	if err != nil {
+		return
+	}
+	dir, bare := gitDir, true
+	if filepath.Base(gitDir) == ".git" {
+		dir, bare = filepath.Dir(gitDir), false
+	}
+	storage := &MGitStorage{RootDir: filepath.Join(dir, ".mgit")}
+
+	if fetched {
+		if err := fetchRemoteHelperMetadata(storage, gitDir, bare, remoteURL, auth); err != nil {
+			fmt.Printf("Warning: MGit metadata not fetched: %s\n", err)
+		}
+	}
+	if pushed {
+		if err := pushRemoteHelperMetadata(storage, remoteURL, auth); err != nil {
+			fmt.Printf("Warning: MGit metadata not pushed: %s\n", err)
+		}
+	}
+}
+
+// fetchRemoteHelperMetadata syncs the server's mappings and notes into
+// storage and leaves them for the hooks to reconstruct
+func fetchRemoteHelperMetadata(storage *MGitStorage, gitDir string, bare bool, remoteURL string, auth *Credentials) error {
+	if err := os.MkdirAll(filepath.Join(storage.RootDir, "mappings"), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %w", storage.RootDir, err)
+	}
+	fetch, err := syncRemoteMetadata(commandContext(), storage, remoteURL, auth)
+	if err != nil {
+		return err
+	}
+	if bare {
+		return nil
+	}
+	if err := excludeMGitDir(gitDir); err != nil {
+		return err
+	}
+	if err := installRemoteHelperHooks(gitDir); err != nil {
+		return err
+	}
+	if fetch.NotModified {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(storage.RootDir, remoteHelperPending), nil, 0644)
+}
+
+// pushRemoteHelperMetadata uploads the local mappings, with any notes
+func pushRemoteHelperMetadata(storage *MGitStorage, remoteURL string, auth *Credentials) error {
+	mappings, err := storage.GetMappings()
+	if err != nil || len(mappings) == 0 {
+		// Nothing committed with mgit yet
+		return nil
+	}
+	entries := make([]remoteMetadataEntry, 0, len(mappings))
+	for _, mapping := range mappings {
+		notes, err := storage.GetNotes(mapping.MGitHash)
+		if err != nil {
+			return err
+		}
+		mapping.Source = ""
+		entries = append(entries, remoteMetadataEntry{Mapping: mapping, Notes: notes})
+	}
+	return uploadRemoteMetadata(commandContext(), remoteURL, auth, entries)
+}
+
+// excludeMGitDir keeps .mgit out of git status through .git/info/exclude
+func excludeMGitDir(gitDir string) error {
+	path := filepath.Join(gitDir, "info", "exclude")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line := strings.TrimSpace(line); line == ".mgit" || line == ".mgit/" || line == "/.mgit/" {
+			return nil
+		}
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	data = append(data, "/.mgit/\n"...)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating %s: %w", filepath.Dir(path), err)
+	}
+	return fsutil.WriteFile(path, data)
+}
+
+// installRemoteHelperHooks installs the hooks that run mgit remote-helper
+// sync, leaving hooks the user wrote alone
+func installRemoteHelperHooks(gitDir string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot locate the mgit binary: %w", err)
+	}
+	hook := fmt.Sprintf("#!/bin/sh\n%s: keeps MGit objects and refs in step with git\n%q remote-helper sync >/dev/null\n",
+		remoteHelperHookMarker, exe)
+
+	hooksDir := filepath.Join(gitDir, "hooks")
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return fmt.Errorf("error creating %s: %w", hooksDir, err)
+	}
+	for _, name := range remoteHelperHooks {
+		path := filepath.Join(hooksDir, name)
+		existing, err := os.ReadFile(path)
+		if err == nil && !strings.Contains(string(existing), remoteHelperHookMarker) {
+			fmt.Printf("Warning: %s exists; MGit refs will not follow git until mgit remote-helper sync is run\n", path)
+			continue
+		}
+		if string(existing) == hook {
+			continue
+		}
+		if err := fsutil.WriteFile(path, []byte(hook)); err != nil {
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+		if err := os.Chmod(path, 0755); err != nil {
+			return fmt.Errorf("error making %s executable: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// remoteHelperSync rebuilds MGit objects from metadata the helper fetched,
+// and points the MGit refs at the commits git's refs are on
+func remoteHelperSync(dir string) error {
+	storage := &MGitStorage{RootDir: filepath.Join(dir, ".mgit")}
+	pending := filepath.Join(storage.RootDir, remoteHelperPending)
+	mappings, err := storage.GetMappings()
+	if err != nil || len(mappings) == 0 {
+		// A server with no MGit commits leaves nothing to reconstruct
+		os.Remove(pending)
+		return err
+	}
+	if _, err := os.Stat(pending); err == nil {
+		if err := reconstructMGitObjects(commandContext(), dir); err != nil {
+			return err
+		}
+		return os.Remove(pending)
+	}
+
+	repo, err := SessionFor(dir).Repo()
+	if err != nil {
+		return fmt.Errorf("error opening repository: %w", err)
+	}
+	return syncMGitRefs(repo, storage, mappings, "remote helper: git moved HEAD")
+}
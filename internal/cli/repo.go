@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// mgit repo create makes a repository on an mgit server for the local one:
+//
+//	POST /api/mgit/repos  {"name", "description", "visibility", "access": [{"pubkey", "access"}]}
+//	                      -> {"id", "name", "visibility"}
+//
+// then sets it as the origin remote and records its ID as repo.id in
+// .mgit/config. The server is a server profile or URL given with --server,
+// or the only server profile there is. The request carries the credentials
+// the auth chain has for the server (a server-wide token, say); without
+// any it is signed with the nostr key as a NIP-98 HTTP auth event.
+
+const repoUsage = "Usage: mgit repo create <name> [--server <profile|url>] [--description <text>] [--public] [--grant <npub>[=read|write]]..."
+
+// RepoCreateRequest is the body of a repository creation request
+type RepoCreateRequest struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Visibility  string        `json:"visibility"` // "private" or "public"
+	Access      []AccessGrant `json:"access,omitempty"`
+}
+
+// CreatedRepository is the server's answer to a creation request
+type CreatedRepository struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Visibility string `json:"visibility"`
+	Error      string `json:"error,omitempty"`
+}
+
+// HandleRepo handles the repo command
+func HandleRepo(args []string) {
+	if len(args) < 2 || args[0] != "create" || strings.HasPrefix(args[1], "-") {
+		fmt.Println(repoUsage)
+		os.Exit(1)
+	}
+	request := &RepoCreateRequest{Name: args[1], Visibility: "private"}
+	server := ""
+	for i := 2; i < len(args); i++ {
+		if args[i] == "--public" {
+			request.Visibility = "public"
+			continue
+		}
+		if i+1 >= len(args) {
+			fmt.Println(repoUsage)
+			os.Exit(1)
+		}
+		switch args[i] {
+		case "--server":
+			server = args[i+1]
+		case "--description":
+			request.Description = args[i+1]
+		case "--grant":
+			grant, err := parseRepoGrant(args[i+1])
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				os.Exit(1)
+			}
+			request.Access = append(request.Access, grant)
+		default:
+			fmt.Println(repoUsage)
+			os.Exit(1)
+		}
+		i++
+	}
+
+	if err := createRepo(request, server); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseRepoGrant reads an <npub>[=read|write] grant; read is the default
+func parseRepoGrant(arg string) (AccessGrant, error) {
+	pubkey, access, found := strings.Cut(arg, "=")
+	if !found {
+		access = "read"
+	}
+	if access != "read" && access != "write" {
+		return AccessGrant{}, fmt.Errorf("access must be read or write, not '%s'", access)
+	}
+	npub, err := NormalizeNostrPubKey(pubkey)
+	if err != nil {
+		return AccessGrant{}, fmt.Errorf("invalid --grant pubkey: %w", err)
+	}
+	return AccessGrant{Pubkey: npub, Access: access}, nil
+}
+
+// createRepo creates the repository on the server and makes it the origin
+func createRepo(request *RepoCreateRequest, server string) error {
+	if info, err := os.Stat(".mgit"); err != nil || !info.IsDir() {
+		return fmt.Errorf("not at the top of an mgit repository (run mgit init first)")
+	}
+	repo := getRepo()
+	if remote, err := repo.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+		return fmt.Errorf("origin is already %s", remote.Config().URLs[0])
+	}
+	base, err := repoCreateServer(server)
+	if err != nil {
+		return err
+	}
+	// The ID the server gives may differ, but the name stands in for it to
+	// find credentials
+	target, err := parseServerURL(base + "/" + request.Name)
+	if err != nil {
+		return err
+	}
+
+	createURL := target.Base + strings.TrimSuffix(apiReposPath, "/")
+	auth, err := lookupAuth(base + "/" + request.Name)
+	if err != nil {
+		if auth, err = nip98Credentials(createURL, "POST", request); err != nil {
+			return fmt.Errorf("no credentials for %s and no nostr key to sign with: %w", base, err)
+		}
+	}
+
+	created := &CreatedRepository{}
+	status, err := loginRequest("POST", createURL, auth, request, created)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status == http.StatusConflict:
+		return fmt.Errorf("the server already has a repository named '%s'", request.Name)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Errorf("the server refused to create the repository (%s auth from %s)", auth.Scheme, auth.Source)
+	case status < 200 || status > 299 || created.ID == "":
+		if created.Error != "" {
+			return fmt.Errorf("the server refused: %s", created.Error)
+		}
+		return fmt.Errorf("the server did not create the repository (status %d)", status)
+	}
+
+	target.RepoID = created.ID
+	remoteURL := target.Endpoint("")
+	if err := runGitIn(".", "remote", "add", "origin", remoteURL); err != nil {
+		return err
+	}
+	if err := SetConfigValue("repo.id", created.ID, false); err != nil {
+		return fmt.Errorf("error saving repo.id: %w", err)
+	}
+
+	visibility := created.Visibility
+	if visibility == "" {
+		visibility = request.Visibility
+	}
+	fmt.Printf("Created %s repository '%s' on %s\n", visibility, created.ID, target.Base)
+	for _, grant := range request.Access {
+		fmt.Printf("  %-5s %s\n", grant.Access, grant.Pubkey)
+	}
+	fmt.Printf("Origin set to %s\n", remoteURL)
+	return nil
+}
+
+// repoCreateServer returns the base URL of the server to create a
+// repository on: a profile's, a URL given, or the only profile's
+func repoCreateServer(server string) (string, error) {
+	if isServerURL(server) {
+		return strings.TrimSuffix(server, "/"), nil
+	}
+	servers, err := globalServers()
+	if err != nil {
+		return "", err
+	}
+	if server != "" {
+		for _, profile := range servers {
+			if profile.Name == server {
+				return profile.URL, nil
+			}
+		}
+		return "", fmt.Errorf("no server named '%s' (see mgit server list)", server)
+	}
+	switch len(servers) {
+	case 0:
+		return "", fmt.Errorf("name the server with --server <url>, or add one with mgit server add")
+	case 1:
+		return servers[0].URL, nil
+	}
+	names := []string{}
+	for _, profile := range servers {
+		names = append(names, profile.Name)
+	}
+	return "", fmt.Errorf("name the server with --server (one of %s)", strings.Join(names, ", "))
+}
+
+// nip98Credentials signs a request with the nostr key as a NIP-98 HTTP auth
+// event carrying the hash of its JSON body
+func nip98Credentials(requestURL, method string, body interface{}) (*Credentials, error) {
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	payload := sha256.Sum256(data)
+	event := nostr.NewEvent(nostr.KindHTTPAuth, [][]string{
+		{"u", requestURL},
+		{"method", method},
+		{"payload", hex.EncodeToString(payload[:])},
+	}, "")
+	if err := event.Sign(secret); err != nil {
+		return nil, fmt.Errorf("error signing request: %w", err)
+	}
+	signed, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{Provider: "nip98", Scheme: "Nostr", Value: base64.StdEncoding.EncodeToString(signed), Source: "the nostr key"}, nil
+}
@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// Commands that change the repository as a whole (commit, checkout, pull,
+// migrate, gc) hold .mgit/mgit.lock while they run, like git's index.lock,
+// so an IDE integration and a terminal can't interleave them. The lock file
+// records who holds it. A lock whose process is gone (checked on the same
+// host) or that is older than lock.staleAfter is stale and taken over;
+// mgit --force-unlock removes one by hand.
+
+// defaultLockStaleAfter is how old a repository lock must be before it is
+// considered abandoned even if its process can't be checked
+const defaultLockStaleAfter = time.Hour
+
+// RepoLockInfo is what a repository lock file records about its holder
+type RepoLockInfo struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// repoLockPath returns the path of the repository lock
+func (s *MGitStorage) repoLockPath() string {
+	return filepath.Join(s.RootDir, "mgit.lock")
+}
+
+// LockRepo takes the repository lock for command, waiting up to fsutil.LockWait
+// for a live holder and taking over a stale one
+func (s *MGitStorage) LockRepo(command string) (*fsutil.Lock, error) {
+	if err := os.MkdirAll(s.RootDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create MGit directory: %w", err)
+	}
+	host, _ := os.Hostname()
+	info := RepoLockInfo{PID: os.Getpid(), Host: host, Command: command, Started: time.Now().UTC()}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.repoLockPath()
+	deadline := time.Now().Add(fsutil.LockWait)
+	for {
+		lock, err := fsutil.CreateLock(path)
+		if err == nil {
+			if err := fsutil.WriteAndSync(lock.File(), data); err != nil {
+				lock.Release()
+				return nil, fmt.Errorf("failed to write repository lock: %w", err)
+			}
+			return lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock repository: %w", err)
+		}
+
+		holder, readErr := readRepoLock(path)
+		if readErr == nil {
+			if stale, reason := holder.stale(time.Now()); stale {
+				fmt.Printf("Removing stale repository lock held by %s (%s)\n", holder, reason)
+				os.Remove(path)
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			if readErr != nil {
+				return nil, fmt.Errorf("the repository is locked (%s); run mgit --force-unlock if no mgit command is running", path)
+			}
+			return nil, fmt.Errorf("the repository is locked by %s; run mgit --force-unlock if it is no longer running", holder)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// lockRepoOrExit takes the repository lock for a command handler, exiting
+// if it can't
+func lockRepoOrExit(command string) *fsutil.Lock {
+	lock, err := NewMGitStorage().LockRepo(command)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	return lock
+}
+
+// readRepoLock reads the holder recorded in a repository lock file
+func readRepoLock(path string) (*RepoLockInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info RepoLockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("unreadable lock file: %w", err)
+	}
+	return &info, nil
+}
+
+// String describes the holder for messages
+func (i *RepoLockInfo) String() string {
+	return fmt.Sprintf("mgit %s (pid %d on %s, started %s)",
+		i.Command, i.PID, i.Host, i.Started.Local().Format("2006-01-02 15:04:05"))
+}
+
+// stale reports whether the lock's holder is gone, and why
+func (i *RepoLockInfo) stale(now time.Time) (bool, string) {
+	host, _ := os.Hostname()
+	if i.Host == host && !processAlive(i.PID) {
+		return true, "process no longer running"
+	}
+	maxAge := GetConfigDuration("lock.staleAfter", defaultLockStaleAfter)
+	if age := now.Sub(i.Started); age > maxAge {
+		return true, fmt.Sprintf("older than lock.staleAfter (%s)", maxAge)
+	}
+	return false, ""
+}
+
+// processAlive reports whether a process with the given PID exists. Where
+// that can't be checked the process is assumed alive, leaving staleness to
+// the lock's age.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// HandleForceUnlock handles --force-unlock: it removes the repository lock
+// and, with --all, the per-file locks under .mgit as well
+func HandleForceUnlock(args []string) {
+	all := false
+	for _, arg := range args {
+		if arg != "--all" {
+			fmt.Println("Usage: mgit --force-unlock [--all]")
+			os.Exit(1)
+		}
+		all = true
+	}
+
+	storage := NewMGitStorage()
+	path := storage.repoLockPath()
+	if holder, err := readRepoLock(path); err == nil {
+		if stale, _ := holder.stale(time.Now()); !stale {
+			fmt.Printf("Warning: the lock holder may still be running: %s\n", holder)
+		}
+		fmt.Printf("Removing repository lock held by %s\n", holder)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	} else if os.IsNotExist(err) {
+		fmt.Println("The repository is not locked")
+	}
+
+	// File locks (refs, mappings, config) only outlive a crash
+	locks := []string{}
+	filepath.Walk(storage.RootDir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(p, ".lock") && p != path {
+			locks = append(locks, p)
+		}
+		return nil
+	})
+	for _, lock := range locks {
+		if !all {
+			fmt.Printf("Leftover file lock: %s\n", lock)
+			continue
+		}
+		if err := os.Remove(lock); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %s\n", lock)
+	}
+	if len(locks) > 0 && !all {
+		fmt.Println("Run mgit --force-unlock --all to remove them too")
+	}
+}
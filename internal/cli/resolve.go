@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Every command names commits the same way, through Resolve. A revision is
+// tried as, in order:
+//
+//	rev-spec syntax    HEAD~2, main^2, @{u}, :/fix (see revspec.go)
+//	reflog entry       HEAD@{1}, main@{2} (see reflog.go)
+//	HEAD or @
+//	git ref            a full ref name, or a branch, tag or remote branch
+//	MGit ref           a branch or tag under .mgit/refs
+//	full hash          of a git commit, or an MGit commit
+//	hash prefix        4 or more hex digits of a git or MGit commit hash
+//	the server         with resolve.remoteFallback, its mappings
+//
+// Tags are peeled to the commits they tag. A prefix is looked up in the
+// abbreviation index first, then among all commits and mappings; one that
+// more than one commit starts with, whether by its git or its MGit hash,
+// is refused with the candidates listed.
+
+// Revision is a resolved commit
+type Revision struct {
+	Git  plumbing.Hash // The git commit
+	MGit string        // Its MGit hash; empty when it has none
+}
+
+// Resolve resolves a revision to a commit
+func Resolve(repo *git.Repository, storage *MGitStorage, rev string) (Revision, error) {
+	hash, err := resolveGitCommit(repo, storage, rev)
+	if err != nil {
+		return Revision{}, err
+	}
+	revision := Revision{Git: hash, MGit: GetMGitHashForCommit(hash)}
+	if revision.MGit == "" && isHexString(rev) {
+		// An MGit commit whose mapping is missing still names itself
+		if commit, err := storage.GetCommit(strings.ToLower(rev)); err == nil && commit.GitHash == hash.String() {
+			revision.MGit = commit.MGitHash
+		}
+	}
+	return revision, nil
+}
+
+// resolveMGitCommit resolves a revision to the MGit hash of its commit
+func resolveMGitCommit(repo *git.Repository, storage *MGitStorage, rev string) (string, error) {
+	revision, err := Resolve(repo, storage, rev)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", rev, err)
+	}
+	if revision.MGit == "" {
+		return "", fmt.Errorf("git commit %s has no MGit hash", shortHash(revision.Git.String()))
+	}
+	return revision.MGit, nil
+}
+
+// resolveGitCommit resolves a revision to its git commit
+func resolveGitCommit(repo *git.Repository, storage *MGitStorage, rev string) (plumbing.Hash, error) {
+	if rev == "" {
+		return plumbing.ZeroHash, fmt.Errorf("empty revision")
+	}
+	if hash, ok, err := resolveRevSpec(repo, rev); ok {
+		return hash, err
+	}
+
+	if ref, n, ok, err := parseReflogSpec(rev); ok {
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		mgitHash, err := storage.resolveReflogSpec(ref, n)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return gitCommitForMGit(repo, storage, mgitHash)
+	}
+
+	if rev == "HEAD" || rev == "@" {
+		if head, err := repo.Head(); err == nil {
+			return head.Hash(), nil
+		}
+	}
+
+	for _, name := range []string{rev, "refs/heads/" + rev, "refs/tags/" + rev, "refs/remotes/" + rev} {
+		if ref, err := repo.Reference(plumbing.ReferenceName(name), true); err == nil {
+			return peelToCommit(repo, ref.Hash()), nil
+		}
+	}
+	if mgitHash, err := resolveMGitRef(storage, rev); err == nil {
+		return gitCommitForMGit(repo, storage, mgitHash)
+	}
+
+	if isHexString(rev) && len(rev) == 40 {
+		hash := plumbing.NewHash(rev)
+		if _, err := repo.CommitObject(hash); err == nil {
+			return hash, nil
+		}
+		if hash, err := gitCommitForMGit(repo, storage, strings.ToLower(rev)); err == nil {
+			return hash, nil
+		}
+	}
+
+	if isHexString(rev) && len(rev) >= 4 && len(rev) < 40 {
+		if hash, ok, err := resolveHashPrefix(repo, storage, rev); err != nil {
+			return plumbing.ZeroHash, err
+		} else if ok {
+			return hash, nil
+		}
+	}
+
+	// Optionally ask the server, in case local metadata is behind
+	if GetConfigBool("resolve.remoteFallback", false) {
+		hash, err := resolveRemoteMapping(repo, rev)
+		if err == nil {
+			return hash, nil
+		}
+		return plumbing.ZeroHash, fmt.Errorf("revision not found (remote lookup: %s)", err)
+	}
+	return plumbing.ZeroHash, fmt.Errorf("revision not found")
+}
+
+// resolveMGitRef looks a name up among the MGit refs, as a full ref name or
+// a branch or tag
+func resolveMGitRef(storage *MGitStorage, name string) (string, error) {
+	candidates := []string{name}
+	if !strings.HasPrefix(name, "refs/") {
+		candidates = []string{"refs/heads/" + name, "refs/tags/" + name}
+	}
+	for _, ref := range candidates {
+		if checkRefName(ref) != nil {
+			continue
+		}
+		if hash, err := storage.GetRef(ref); err == nil && hash != "" {
+			return hash, nil
+		}
+	}
+	return "", fmt.Errorf("no MGit ref '%s'", name)
+}
+
+// gitCommitForMGit returns the git commit behind an MGit commit
+func gitCommitForMGit(repo *git.Repository, storage *MGitStorage, mgitHash string) (plumbing.Hash, error) {
+	gitHash, err := gitHashForMGit(repo, storage, mgitHash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return plumbing.NewHash(gitHash), nil
+}
+
+// peelToCommit follows annotated tags to the commit they tag
+func peelToCommit(repo *git.Repository, hash plumbing.Hash) plumbing.Hash {
+	for {
+		tag, err := repo.TagObject(hash)
+		if err != nil {
+			return hash
+		}
+		hash = tag.Target
+	}
+}
+
+// resolveHashPrefix finds the commit whose git or MGit hash starts with a
+// prefix. ok is false when none does.
+func resolveHashPrefix(repo *git.Repository, storage *MGitStorage, prefix string) (hash plumbing.Hash, ok bool, err error) {
+	prefix = strings.ToLower(prefix)
+	candidates := map[plumbing.Hash]string{}
+
+	// The index knows the commits the refs reach, and every mapping
+	if index, err := loadAbbrevIndex(repo, storage); err == nil {
+		for _, gitHash := range index.Matches(prefix, 'g') {
+			if _, err := repo.CommitObject(plumbing.NewHash(gitHash)); err == nil {
+				candidates[plumbing.NewHash(gitHash)] = "git commit " + gitHash
+			}
+		}
+		for _, mgitHash := range index.Matches(prefix, 'm') {
+			if gitHash, err := gitCommitForMGit(repo, storage, mgitHash); err == nil {
+				candidates[gitHash] = "MGit commit " + mgitHash
+			}
+		}
+	}
+
+	// Otherwise look through every commit, dangling ones included
+	if len(candidates) == 0 {
+		iter, err := repo.CommitObjects()
+		if err != nil {
+			return plumbing.ZeroHash, false, fmt.Errorf("error listing commits: %w", err)
+		}
+		defer iter.Close()
+		err = iter.ForEach(func(c *object.Commit) error {
+			if strings.HasPrefix(c.Hash.String(), prefix) {
+				candidates[c.Hash] = "git commit " + c.Hash.String()
+			}
+			return nil
+		})
+		if err != nil {
+			return plumbing.ZeroHash, false, fmt.Errorf("error searching commits: %w", err)
+		}
+
+		mappings, err := storage.GetMappings()
+		if err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+		for _, mapping := range mappings {
+			if strings.HasPrefix(mapping.MGitHash, prefix) {
+				candidates[plumbing.NewHash(mapping.GitHash)] = "MGit commit " + mapping.MGitHash
+			}
+		}
+		// MGit commits whose mapping is missing still record their git hash
+		if objects, err := storage.findObjectByPrefix(prefix); err == nil {
+			for _, mgitHash := range objects {
+				if commit, err := storage.GetCommit(mgitHash); err == nil && commit.GitHash != "" {
+					candidates[plumbing.NewHash(commit.GitHash)] = "MGit commit " + mgitHash
+				}
+			}
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return plumbing.ZeroHash, false, nil
+	case 1:
+		for hash := range candidates {
+			return hash, true, nil
+		}
+	}
+	matches := []string{}
+	for _, match := range candidates {
+		matches = append(matches, match)
+	}
+	return plumbing.ZeroHash, false, ambiguousPrefixError(prefix, matches)
+}
+
+// ambiguousPrefixError reports a hash prefix that names more than one
+// object, listing them
+func ambiguousPrefixError(prefix string, matches []string) error {
+	sort.Strings(matches)
+	return fmt.Errorf("ambiguous hash prefix '%s' matches %d objects:\n  %s", prefix, len(matches), strings.Join(matches, "\n  "))
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// RestoreOptions controls where restored file content comes from and goes to
+type RestoreOptions struct {
+	Source   string // Revision to restore from ("" means the index, or HEAD for --staged)
+	Staged   bool   // Restore the index
+	Worktree bool   // Restore the working tree
+}
+
+// HandleRestore handles the restore command
+func HandleRestore(args []string) {
+	opts := &RestoreOptions{}
+	paths := []string{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--staged" || arg == "-S":
+			opts.Staged = true
+		case arg == "--worktree" || arg == "-W":
+			opts.Worktree = true
+		case arg == "--source" || arg == "-s":
+			if i+1 >= len(args) {
+				fmt.Println("Error: --source requires a revision")
+				os.Exit(1)
+			}
+			opts.Source = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--source="):
+			opts.Source = strings.TrimPrefix(arg, "--source=")
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	// Like git restore, default to the working tree only
+	if !opts.Staged && !opts.Worktree {
+		opts.Worktree = true
+	}
+
+	if len(paths) == 0 {
+		fmt.Println("Usage: mgit restore [--staged] [--worktree] [--source <rev>] <paths...>")
+		os.Exit(1)
+	}
+
+	if err := restorePaths(paths, opts); err != nil {
+		fmt.Printf("Error restoring files: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// restorePaths restores paths without moving HEAD. The source revision may be
+// a branch, tag, Git hash or MGit hash.
+func restorePaths(paths []string, opts *RestoreOptions) error {
+	gitArgs := []string{"restore"}
+
+	if opts.Source != "" {
+		hash, err := resolveRevision(getRepo(), opts.Source)
+		if err != nil {
+			return fmt.Errorf("invalid source '%s': %w", opts.Source, err)
+		}
+		gitArgs = append(gitArgs, "--source="+hash.String())
+	}
+	if opts.Staged {
+		gitArgs = append(gitArgs, "--staged")
+	}
+	if opts.Worktree {
+		gitArgs = append(gitArgs, "--worktree")
+	}
+	gitArgs = append(gitArgs, "--")
+	gitArgs = append(gitArgs, paths...)
+
+	cmd := exec.Command("git", gitArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git restore failed: %w", err)
+	}
+
+	return nil
+}
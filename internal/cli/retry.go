@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	serverclient "github.com/imyjimmy/mgit/pkg/server-client"
+)
+
+// Calls to a server's repository info and metadata endpoints are retried
+// when the server cannot be reached or answers 5xx or 429, so a brief outage
+// or network blip does not abort a clone or push. Attempts back off
+// exponentially from http.retryDelay (default 500ms) up to 8s, with jitter,
+// and honour a Retry-After the server sends. http.retries (default 3) sets
+// how many times a call is retried; 0 turns retrying off.
+//
+// mgit --verbose (or mgit clone --verbose), or http.verbose = true, logs
+// every attempt; MGIT_TRACE also traces how long each took.
+// A call that still fails returns a *ServerError, which tells rejected
+// credentials apart from a server that is down.
+
+const (
+	defaultHTTPRetries    = 3
+	defaultHTTPRetryDelay = 500 * time.Millisecond
+	maxHTTPRetryDelay     = 8 * time.Second
+	// maxRetryAfter bounds how long a server's Retry-After can hold a command up
+	maxRetryAfter = 30 * time.Second
+)
+
+// ServerError is a call to an mgit server that failed
+type ServerError struct {
+	Method   string
+	URL      string
+	Status   int    // 0 when the server could not be reached
+	Message  string // The server's answer, or why it could not be reached
+	Attempts int
+}
+
+// IsAuth reports whether the server rejected the credentials
+func (e *ServerError) IsAuth() bool {
+	return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+}
+
+// IsUnavailable reports whether the server could not be reached or was
+// unable to answer
+func (e *ServerError) IsUnavailable() bool {
+	return e.Status == 0 || retryableStatus(e.Status)
+}
+
+func (e *ServerError) Error() string {
+	message := ""
+	if e.Message != "" {
+		message = ": " + e.Message
+	}
+	switch {
+	case e.IsAuth():
+		repoURL := e.URL
+		if parsed, err := parseServerURL(e.URL); err == nil {
+			repoURL = parsed.Endpoint("")
+		}
+		return fmt.Sprintf("the server rejected the credentials (%d %s)%s; check them with mgit auth explain %s, or log in again with mgit login",
+			e.Status, http.StatusText(e.Status), message, repoURL)
+	case e.Status == 0:
+		return fmt.Sprintf("server unreachable after %d attempt(s)%s", e.Attempts, message)
+	case e.IsUnavailable():
+		return fmt.Sprintf("server unavailable (%d %s) after %d attempt(s)%s",
+			e.Status, http.StatusText(e.Status), e.Attempts, message)
+	}
+	return fmt.Sprintf("error response from server (%d)%s", e.Status, message)
+}
+
+// retryableStatus reports whether a status means the server may answer
+// if asked again
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// serverDo sends a request to an mgit server, retrying while the server
+// cannot be reached or is unavailable. It returns the response to any other
+// answer, for the caller to check; a request with a body must be made with
+// a body http.NewRequest can rewind. Canceling the request's context stops
+// the retries and returns the context's error.
+func serverDo(client *http.Client, req *http.Request) (*http.Response, error) {
+	retries := GetConfigInt("http.retries", defaultHTTPRetries)
+	if retries < 0 {
+		retries = defaultHTTPRetries
+	}
+	delay := GetConfigDuration("http.retryDelay", defaultHTTPRetryDelay)
+	if delay <= 0 {
+		delay = defaultHTTPRetryDelay
+	}
+	verbose := logEnabled(logVerbose) || GetConfigBool("http.verbose", false)
+	attempts := retries + 1
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("error resending request: %w", err)
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		sent := time.Now()
+		resp, err := client.Do(req)
+		traceRequest(req, resp, err, time.Since(sent), attempt)
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctxErr
+		}
+		failure := &ServerError{Method: req.Method, URL: req.URL.String(), Attempts: attempt}
+		var wait time.Duration
+		switch {
+		case err != nil:
+			failure.Message = err.Error()
+		case retryableStatus(resp.StatusCode):
+			failure.Status = resp.StatusCode
+			failure.Message = responseMessage(resp)
+			wait = retryAfter(resp)
+		default:
+			if verbose {
+				fmt.Printf("%s %s: %s (attempt %d of %d)\n", req.Method, req.URL, resp.Status, attempt, attempts)
+			}
+			return resp, nil
+		}
+
+		if attempt >= attempts {
+			if verbose {
+				fmt.Printf("%s %s: %s (attempt %d of %d), giving up\n", req.Method, req.URL, failure.describe(), attempt, attempts)
+			}
+			return nil, failure
+		}
+		backoff := delay << (attempt - 1)
+		if backoff > maxHTTPRetryDelay || backoff <= 0 {
+			backoff = maxHTTPRetryDelay
+		}
+		// Jitter keeps clients that failed together from retrying together
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if wait < backoff {
+			wait = backoff
+		}
+		if verbose {
+			fmt.Printf("%s %s: %s (attempt %d of %d), retrying in %s\n", req.Method, req.URL, failure.describe(), attempt, attempts, wait.Round(time.Millisecond))
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// traceRequest traces one attempt of a server call. The URL is traced
+// without its query, which may carry a token.
+func traceRequest(req *http.Request, resp *http.Response, err error, took time.Duration, attempt int) {
+	if !tracing() {
+		return
+	}
+	target := *req.URL
+	target.RawQuery = ""
+	result := ""
+	switch {
+	case err != nil:
+		result = err.Error()
+	case resp.ContentLength >= 0:
+		result = fmt.Sprintf("%s, %d bytes", resp.Status, resp.ContentLength)
+	default:
+		result = resp.Status
+	}
+	tracef("http", "%s %s (attempt %d): %s in %s", req.Method, target.String(), attempt, result, took.Round(time.Microsecond))
+}
+
+// describe summarizes the failure of one attempt for the verbose log
+func (e *ServerError) describe() string {
+	if e.Status == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+}
+
+// responseMessage reads and closes a failed response's body
+func responseMessage(resp *http.Response) string {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return string(bytes.TrimSpace(data))
+}
+
+// retryAfter returns how long the server asked to wait before the next
+// attempt, in seconds as Retry-After gives it
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait
+}
+
+// serverResponseError turns a response a caller does not accept into a
+// *ServerError
+func serverResponseError(resp *http.Response) error {
+	return &ServerError{
+		Method:   resp.Request.Method,
+		URL:      resp.Request.URL.String(),
+		Status:   resp.StatusCode,
+		Message:  responseMessage(resp),
+		Attempts: 1,
+	}
+}
+
+// serverDoer sends a server client's requests through serverDo, so they
+// are retried
+type serverDoer struct {
+	client *http.Client
+}
+
+func (d serverDoer) Do(req *http.Request) (*http.Response, error) {
+	return serverDo(d.client, req)
+}
+
+// newServerClient returns a client for a repository's API on an mgit
+// server, whose calls are retried and fail with a *ServerError
+func newServerClient(url string, auth *Credentials, timeout time.Duration) *serverclient.Client {
+	return &serverclient.Client{
+		URL:           repoEndpoint(url, ""),
+		HTTP:          serverDoer{client: serverHTTPClient(url, timeout)},
+		Authorize:     auth.setHeader,
+		ResponseError: serverResponseError,
+	}
+}
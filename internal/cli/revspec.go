@@ -0,0 +1,199 @@
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Beyond names and hashes, a revision may use git's rev-spec syntax:
+//
+//	<rev>~<n>      the n-th first-parent ancestor (~ alone is ~1)
+//	<rev>^<n>      the n-th parent (^ alone is ^1, ^0 the commit itself)
+//	@{upstream}    the branch the current branch tracks (also @{u}), or
+//	<branch>@{u}   the one <branch> tracks
+//	:/<text>       the youngest commit reachable from any ref whose message
+//	               matches the regular expression <text>
+//
+// Suffixes chain, as in main~2^2 or @{u}~1. <rev> is anything a revision
+// may otherwise be, MGit hashes and reflog entries included. The upstream
+// is read from the branch.<name>.remote and branch.<name>.merge keys git
+// keeps in .git/config.
+
+// resolveRevSpec resolves a revision using the syntax above. ok is false
+// when rev uses none of it.
+func resolveRevSpec(repo *git.Repository, rev string) (hash plumbing.Hash, ok bool, err error) {
+	if strings.HasPrefix(rev, ":/") {
+		hash, err := searchCommitMessage(repo, rev[2:])
+		return hash, true, err
+	}
+
+	base, suffix := splitRevSuffix(rev)
+	if suffix == "" {
+		if branch, ok := upstreamSpec(rev); ok {
+			hash, err := resolveUpstream(repo, branch)
+			return hash, true, err
+		}
+		return plumbing.ZeroHash, false, nil
+	}
+	if base == "" {
+		return plumbing.ZeroHash, true, fmt.Errorf("invalid revision '%s'", rev)
+	}
+	if base == "@" {
+		base = "HEAD"
+	}
+
+	hash, err = resolveRevision(repo, base)
+	if err != nil {
+		return plumbing.ZeroHash, true, err
+	}
+	if tag, err := repo.TagObject(hash); err == nil {
+		hash = tag.Target
+	}
+	hash, err = walkRevSuffix(repo, hash, suffix)
+	return hash, true, err
+}
+
+// splitRevSuffix splits the ancestry suffix, made of ~, ^ and digits, off
+// the end of a revision
+func splitRevSuffix(rev string) (string, string) {
+	start := len(rev)
+	for start > 0 && strings.ContainsRune("~^0123456789", rune(rev[start-1])) {
+		start--
+	}
+	// The suffix starts at its first ~ or ^; digits before it are the base's
+	i := strings.IndexAny(rev[start:], "~^")
+	if i == -1 {
+		return rev, ""
+	}
+	start += i
+	return rev[:start], rev[start:]
+}
+
+// walkRevSuffix follows an ancestry suffix from a commit
+func walkRevSuffix(repo *git.Repository, hash plumbing.Hash, suffix string) (plumbing.Hash, error) {
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		n := 1
+		if digits > 0 {
+			n, _ = strconv.Atoi(suffix[:digits])
+			suffix = suffix[digits:]
+		}
+
+		steps, parent := n, 0
+		if op == '^' {
+			steps, parent = 1, n-1
+			if n == 0 {
+				continue
+			}
+		}
+		for i := 0; i < steps; i++ {
+			commit, err := repo.CommitObject(hash)
+			if err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("error reading commit %s: %w", shortHash(hash.String()), err)
+			}
+			if parent >= len(commit.ParentHashes) {
+				if parent == 0 {
+					return plumbing.ZeroHash, fmt.Errorf("%s has no parent", shortHash(hash.String()))
+				}
+				return plumbing.ZeroHash, fmt.Errorf("%s has no parent %d", shortHash(hash.String()), parent+1)
+			}
+			hash = commit.ParentHashes[parent]
+		}
+	}
+	return hash, nil
+}
+
+// upstreamSpec reports whether rev names an upstream, and of which branch
+// ("" for the current one)
+func upstreamSpec(rev string) (string, bool) {
+	for _, suffix := range []string{"@{upstream}", "@{u}"} {
+		if strings.HasSuffix(strings.ToLower(rev), suffix) {
+			return rev[:len(rev)-len(suffix)], true
+		}
+	}
+	return "", false
+}
+
+// resolveUpstream resolves the remote-tracking branch a branch tracks
+func resolveUpstream(repo *git.Repository, branch string) (plumbing.Hash, error) {
+	if branch == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("error getting HEAD: %w", err)
+		}
+		if !head.Name().IsBranch() {
+			return plumbing.ZeroHash, fmt.Errorf("HEAD does not point to a branch")
+		}
+		branch = head.Name().Short()
+	}
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+
+	remote := GetConfigValue("branch."+branch+".remote", "")
+	merge := GetConfigValue("branch."+branch+".merge", "")
+	if remote == "" || merge == "" {
+		return plumbing.ZeroHash, fmt.Errorf("no upstream configured for branch '%s'", branch)
+	}
+	refName := plumbing.ReferenceName(merge)
+	if remote != "." {
+		refName = plumbing.NewRemoteReferenceName(remote, strings.TrimPrefix(merge, "refs/heads/"))
+	}
+	ref, err := repo.Reference(refName, true)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("upstream branch '%s' of '%s' not found: %w", refName.Short(), branch, err)
+	}
+	return ref.Hash(), nil
+}
+
+// searchCommitMessage finds the youngest commit reachable from any ref
+// whose message matches a regular expression
+func searchCommitMessage(repo *git.Repository, pattern string) (plumbing.Hash, error) {
+	if pattern == "" {
+		return plumbing.ZeroHash, fmt.Errorf("empty commit message search ':/'")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("invalid commit message pattern '%s': %w", pattern, err)
+	}
+
+	tips := []plumbing.Hash{}
+	refs, err := repo.References()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error getting references: %w", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		hash := ref.Hash()
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		tips = append(tips, hash)
+		return nil
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if head, err := repo.Head(); err == nil {
+		tips = append(tips, head.Hash())
+	}
+
+	var found *object.Commit
+	walkCommits(repo, tips, nil, func(c *object.Commit) {
+		if re.MatchString(c.Message) && (found == nil || c.Committer.When.After(found.Committer.When)) {
+			found = c
+		}
+	})
+	if found == nil {
+		return plumbing.ZeroHash, fmt.Errorf("no commit message matches '%s'", pattern)
+	}
+	return found.Hash, nil
+}
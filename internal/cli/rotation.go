@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// A key rotation is a continuity statement: the retiring key signs an event
+// naming its successor, and the successor signs an acknowledgement of that
+// statement, so neither key can claim the other alone. Rotations are kept
+// with the retired key in the key store, in .mgit/rotations/<old-key>.json of
+// each repository mgit key rotate is run in, and are published to the
+// configured relays. verify --wot follows them: a retired key's commits from
+// before its rotation are trusted when its successor is, and a successor is
+// trusted when the key it replaced is.
+
+// NostrKindKeyRotation is the application-specific (NIP-78) event used for
+// rotation statements and acknowledgements
+const NostrKindKeyRotation = 30078
+
+// KeyRotation is a signed rotation from one key to its successor
+type KeyRotation struct {
+	Statement       *nostr.Event `json:"statement"`
+	Acknowledgement *nostr.Event `json:"acknowledgement"`
+}
+
+// OldKey returns the retired key (hex)
+func (r *KeyRotation) OldKey() string {
+	return r.Statement.Pubkey
+}
+
+// NewKey returns the successor key (hex)
+func (r *KeyRotation) NewKey() string {
+	return eventTag(r.Statement, "p")
+}
+
+// When returns when the old key was retired
+func (r *KeyRotation) When() time.Time {
+	return time.Unix(r.Statement.CreatedAt, 0)
+}
+
+// Check verifies both signatures and that the events name each other
+func (r *KeyRotation) Check() error {
+	if r.Statement == nil || r.Acknowledgement == nil {
+		return fmt.Errorf("incomplete key rotation")
+	}
+	if err := r.Statement.Verify(); err != nil {
+		return fmt.Errorf("rotation statement: %w", err)
+	}
+	if err := r.Acknowledgement.Verify(); err != nil {
+		return fmt.Errorf("rotation acknowledgement: %w", err)
+	}
+	if r.Statement.Kind != NostrKindKeyRotation || eventTag(r.Statement, "t") != "key-rotation" || r.NewKey() == "" {
+		return fmt.Errorf("not a key rotation statement")
+	}
+	ack := r.Acknowledgement
+	if ack.Pubkey != r.NewKey() || eventTag(ack, "e") != r.Statement.ID || eventTag(ack, "p") != r.OldKey() {
+		return fmt.Errorf("the acknowledgement does not answer the rotation statement")
+	}
+	return nil
+}
+
+// newKeyRotation signs a rotation from oldSecret's key to newSecret's
+func newKeyRotation(oldSecret, newSecret []byte, reason string) (*KeyRotation, error) {
+	newPubkey, err := nostr.PublicKey(newSecret)
+	if err != nil {
+		return nil, err
+	}
+	newHex := hex.EncodeToString(newPubkey)
+	statement := nostr.NewEvent(NostrKindKeyRotation, [][]string{
+		{"d", "mgit-key-rotation"},
+		{"t", "key-rotation"},
+		{"p", newHex},
+	}, reason)
+	if err := statement.Sign(oldSecret); err != nil {
+		return nil, fmt.Errorf("error signing rotation statement: %w", err)
+	}
+	ack := nostr.NewEvent(NostrKindKeyRotation, [][]string{
+		{"d", "mgit-key-rotation-ack:" + statement.ID},
+		{"t", "key-rotation-ack"},
+		{"e", statement.ID},
+		{"p", statement.Pubkey},
+	}, "")
+	if err := ack.Sign(newSecret); err != nil {
+		return nil, fmt.Errorf("error signing rotation acknowledgement: %w", err)
+	}
+	return &KeyRotation{Statement: statement, Acknowledgement: ack}, nil
+}
+
+func (s *MGitStorage) rotationsDir() string {
+	return filepath.Join(s.RootDir, "rotations")
+}
+
+// recordKeyRotation writes a rotation to the repository's store
+func recordKeyRotation(storage *MGitStorage, rotation *KeyRotation) error {
+	data, err := json.MarshalIndent(rotation, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding key rotation: %w", err)
+	}
+	if err := os.MkdirAll(storage.rotationsDir(), 0755); err != nil {
+		return fmt.Errorf("error creating rotations directory: %w", err)
+	}
+	if err := fsutil.WriteFile(filepath.Join(storage.rotationsDir(), rotation.OldKey()+".json"), data); err != nil {
+		return fmt.Errorf("error writing key rotation: %w", err)
+	}
+	return nil
+}
+
+// loadKeyRotations reads the repository's rotations, by retired key. Ones
+// that don't check out are reported and left out.
+func loadKeyRotations(storage *MGitStorage) (map[string]*KeyRotation, error) {
+	rotations := map[string]*KeyRotation{}
+	entries, err := os.ReadDir(storage.rotationsDir())
+	if os.IsNotExist(err) {
+		return rotations, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading rotations directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(storage.rotationsDir(), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		rotation := &KeyRotation{}
+		if err := json.Unmarshal(data, rotation); err != nil {
+			fmt.Printf("Warning: ignoring key rotation %s: %s\n", entry.Name(), err)
+			continue
+		}
+		if err := rotation.Check(); err != nil {
+			fmt.Printf("Warning: ignoring key rotation %s: %s\n", entry.Name(), err)
+			continue
+		}
+		rotations[rotation.OldKey()] = rotation
+	}
+	return rotations, nil
+}
+
+// rotateKey retires the stored key oldName in favour of newName, which is
+// generated if the store doesn't have it. Running it again for a rotation
+// already made records it in the current repository.
+func rotateKey(oldName, newName, reason string) error {
+	if oldName == newName {
+		return fmt.Errorf("a key can't be rotated to itself")
+	}
+	old, err := loadStoredKey(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := keyPath(newName)
+	if err != nil {
+		return err
+	}
+
+	rotation := old.Rotation
+	if rotation != nil {
+		next, err := loadStoredKey(newName)
+		if err != nil || canonicalNostrPubKey(rotation.NewKey()) != next.Pubkey {
+			return fmt.Errorf("key '%s' was already rotated to %s", oldName, canonicalNostrPubKey(rotation.NewKey()))
+		}
+		fmt.Printf("Key '%s' was rotated to '%s' on %s\n", oldName, newName, rotation.When().Format("2006-01-02"))
+	} else {
+		oldSecret, err := unlockStoredKey(oldName)
+		if err != nil {
+			return err
+		}
+		var newSecret []byte
+		var next *StoredKey
+		if fileExists(newPath) {
+			if newSecret, err = unlockStoredKey(newName); err != nil {
+				return err
+			}
+			if next, err = loadStoredKey(newName); err != nil {
+				return err
+			}
+		} else {
+			if newSecret, err = generateSecretKey(); err != nil {
+				return fmt.Errorf("error generating key: %w", err)
+			}
+			if next, err = storeSecretKey(newName, newSecret, ""); err != nil {
+				return err
+			}
+			fmt.Printf("Generated key '%s'\n", newName)
+			printStoredKey(next)
+		}
+		if next.Rotation != nil {
+			return fmt.Errorf("key '%s' is itself retired", newName)
+		}
+
+		if rotation, err = newKeyRotation(oldSecret, newSecret, reason); err != nil {
+			return err
+		}
+		old.Rotation = rotation
+		if err := updateStoredKey(old); err != nil {
+			return err
+		}
+		fmt.Printf("Rotated key '%s' to '%s'\n", oldName, newName)
+		switchRotatedKey(old, next)
+
+		if relays := configuredRelays(); len(relays) > 0 {
+			accepted := publishEvent(rotation.Statement, relays)
+			accepted = min(accepted, publishEvent(rotation.Acknowledgement, relays))
+			fmt.Printf("Published the rotation to %d of %d relays\n", accepted, len(relays))
+		}
+	}
+
+	if info, err := os.Stat(".mgit"); err == nil && info.IsDir() {
+		if err := recordKeyRotation(currentSession().Storage(), rotation); err != nil {
+			return err
+		}
+		fmt.Println("Recorded the rotation in .mgit/rotations")
+	} else {
+		fmt.Printf("Run mgit key rotate %s %s in each repository to record the rotation there\n", oldName, newName)
+	}
+	return nil
+}
+
+// switchRotatedKey points the global config's nostr.key, user.pubkey and
+// profile signers that named the old key at its successor
+func switchRotatedKey(old, next *StoredKey) {
+	err := UpdateConfig(GetConfigFilePath(true), func(config *Config) error {
+		if config.Get("nostr", "key") == old.Name {
+			config.Set("nostr", "key", next.Name)
+			fmt.Printf("Signing with key '%s' (nostr.key)\n", next.Name)
+		}
+		if canonicalNostrPubKey(config.Get("user", "pubkey")) == old.Pubkey {
+			config.Set("user", "pubkey", next.Pubkey)
+			fmt.Printf("Set user.pubkey to %s\n", next.Pubkey)
+		}
+		for _, profile := range loadProfiles(config) {
+			if profile.Values["signer"] == old.Name {
+				config.Set(profileSection(profile.Name), "signer", next.Name)
+				config.Set(profileSection(profile.Name), "pubkey", next.Pubkey)
+				fmt.Printf("Identity profile '%s' now signs with key '%s'\n", profile.Name, next.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to update config for the new key: %s\n", err)
+	}
+}
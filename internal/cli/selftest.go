@@ -0,0 +1,373 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// selftestPubkey attributes the commits selftest creates (5e1f... in hex)
+const selftestPubkey = "npub1tc04u867ra0p7hsltc04u867ra0p7hsltc04u867ra0p7hsltc0sslujlq"
+
+// SelftestOptions controls what selftest exercises
+type SelftestOptions struct {
+	Server string // Running mgit server repository URL to round-trip against
+	Keep   bool   // Keep the scratch directory for inspection
+}
+
+// selftest runs mgit subcommands in a scratch directory, isolated from the
+// user's config and token store
+type selftest struct {
+	exe    string
+	dir    string
+	env    []string
+	passed int
+	failed int
+}
+
+// HandleSelftest handles the selftest command
+func HandleSelftest(args []string) {
+	opts := &SelftestOptions{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--server":
+			if i+1 >= len(args) {
+				fmt.Println("Error: --server requires a repository URL")
+				os.Exit(1)
+			}
+			opts.Server = args[i+1]
+			i++
+		case "--keep":
+			opts.Keep = true
+		default:
+			fmt.Println("Usage: mgit selftest [--server <repo-url>] [--keep]")
+			os.Exit(1)
+		}
+	}
+
+	t, err := newSelftest()
+	if err != nil {
+		fmt.Printf("Error setting up selftest: %s\n", err)
+		os.Exit(1)
+	}
+	if opts.Keep {
+		fmt.Printf("Scratch directory: %s\n", t.dir)
+	} else {
+		defer os.RemoveAll(t.dir)
+	}
+
+	t.runLocalRoundTrip()
+	t.runEmbeddedServer()
+	if opts.Server != "" {
+		t.runServerRoundTrip(opts.Server)
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", t.passed, t.failed)
+	if t.failed > 0 {
+		if !opts.Keep {
+			os.RemoveAll(t.dir)
+		}
+		os.Exit(1)
+	}
+}
+
+// newSelftest creates the scratch directory and environment
+func newSelftest() (*selftest, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot locate the mgit binary: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "mgit-selftest-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating scratch directory: %w", err)
+	}
+
+	home := filepath.Join(dir, "home")
+	if err := os.MkdirAll(home, 0755); err != nil {
+		return nil, fmt.Errorf("error creating scratch home: %w", err)
+	}
+
+	env := []string{}
+	for _, kv := range os.Environ() {
+		// Drop the user's overrides so only the values below apply
+		if !strings.HasPrefix(kv, "MGIT_") && !strings.HasPrefix(kv, "HOME=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		"HOME="+home,
+		"MGIT_USER_NAME=mgit selftest",
+		"MGIT_USER_EMAIL=selftest@mgit.invalid",
+		"MGIT_USER_PUBKEY="+selftestPubkey,
+		"GIT_AUTHOR_NAME=mgit selftest",
+		"GIT_AUTHOR_EMAIL=selftest@mgit.invalid",
+		"GIT_COMMITTER_NAME=mgit selftest",
+		"GIT_COMMITTER_EMAIL=selftest@mgit.invalid",
+	)
+
+	return &selftest{exe: exe, dir: dir, env: env}, nil
+}
+
+// mgit runs an mgit subcommand in dir (relative to the scratch directory)
+func (t *selftest) mgit(dir string, args ...string) (string, error) {
+	return t.run(dir, t.exe, args...)
+}
+
+// git runs a git command in dir (relative to the scratch directory)
+func (t *selftest) git(dir string, args ...string) (string, error) {
+	return t.run(dir, "git", args...)
+}
+
+func (t *selftest) run(dir, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = filepath.Join(t.dir, dir)
+	cmd.Env = t.env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s: %w\n%s", filepath.Base(name), strings.Join(args, " "), err, indentOutput(string(out)))
+	}
+	return string(out), nil
+}
+
+// step runs one check and records the result. Later steps usually depend on
+// earlier ones, so it reports whether the step passed.
+func (t *selftest) step(name string, check func() error) bool {
+	if err := check(); err != nil {
+		t.failed++
+		fmt.Printf("FAIL  %s\n      %s\n", name, strings.ReplaceAll(err.Error(), "\n", "\n      "))
+		return false
+	}
+	t.passed++
+	fmt.Printf("PASS  %s\n", name)
+	return true
+}
+
+// runLocalRoundTrip exercises commit/push/clone/pull/verify between a
+// workstation repository and a bare repository standing in for the server
+func (t *selftest) runLocalRoundTrip() {
+	fmt.Println("Local round trip:")
+
+	ok := t.step("init bare server repository", func() error {
+		_, err := t.mgit(".", "init", "--bare", "server.git")
+		return err
+	}) && t.step("init workstation repository", func() error {
+		_, err := t.mgit(".", "init", "work")
+		return err
+	}) && t.step("commit with nostr attribution", func() error {
+		if err := os.WriteFile(filepath.Join(t.dir, "work", "record.txt"), []byte("first visit\n"), 0644); err != nil {
+			return err
+		}
+		if _, err := t.mgit("work", "add", "record.txt", ".gitignore"); err != nil {
+			return err
+		}
+		_, err := t.mgit("work", "commit", "-m", "first visit")
+		return err
+	}) && t.step("verify MGit hash chain", func() error {
+		_, err := t.mgit("work", "verify")
+		return err
+	}) && t.step("push to server repository", func() error {
+		if _, err := t.git("work", "remote", "add", "origin", filepath.Join(t.dir, "server.git")); err != nil {
+			return err
+		}
+		_, err := t.mgit("work", "push")
+		return err
+	}) && t.step("clone with MGit metadata", func() error {
+		_, err := t.mgit(".", "clone", filepath.Join(t.dir, "work"), "copy")
+		return err
+	}) && t.step("verify clone", func() error {
+		return t.sameMGitHead("work", "copy")
+	})
+	if !ok {
+		return
+	}
+
+	t.step("pull new commits", func() error {
+		if err := os.WriteFile(filepath.Join(t.dir, "work", "record.txt"), []byte("first visit\nfollow-up\n"), 0644); err != nil {
+			return err
+		}
+		if _, err := t.mgit("work", "add", "record.txt"); err != nil {
+			return err
+		}
+		if _, err := t.mgit("work", "commit", "-m", "follow-up"); err != nil {
+			return err
+		}
+		if _, err := t.mgit("work", "push"); err != nil {
+			return err
+		}
+		if _, err := t.mgit("copy", "pull"); err != nil {
+			return err
+		}
+		return t.sameGitHead("work", "copy")
+	})
+}
+
+// runEmbeddedServer serves the scratch repositories with mgit serve, logs in
+// with a scratch nostr key and clones through the server
+func (t *selftest) runEmbeddedServer() {
+	fmt.Println("Embedded server:")
+	if _, err := os.Stat(filepath.Join(t.dir, "work", ".mgit")); err != nil {
+		fmt.Println("SKIP  the local round trip did not create a repository to serve")
+		return
+	}
+
+	var url string
+	var server *exec.Cmd
+	defer func() {
+		if server != nil && server.Process != nil {
+			server.Process.Kill()
+			server.Wait()
+		}
+	}()
+
+	ok := t.step("start mgit serve", func() error {
+		secret, err := generateSecretKey()
+		if err != nil {
+			return err
+		}
+		pubkey, err := nostr.PublicKey(secret)
+		if err != nil {
+			return err
+		}
+		npub, err := nostr.Npub(hex.EncodeToString(pubkey))
+		if err != nil {
+			return err
+		}
+		// Pick a free port; the server binds it right after
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		addr := listener.Addr().String()
+		listener.Close()
+
+		t.env = append(t.env,
+			"MGIT_SERVE_ADMINS="+npub,
+			"MGIT_NOSTR_SECRETKEY="+hex.EncodeToString(secret),
+			"MGIT_AUTH_TOKENKEY=passphrase",
+			"MGIT_TOKEN_PASSPHRASE=selftest",
+		)
+		log, err := os.Create(filepath.Join(t.dir, "serve.log"))
+		if err != nil {
+			return err
+		}
+		defer log.Close()
+		server = exec.Command(t.exe, "serve", "--listen", addr, "--secret", "selftest", t.dir)
+		server.Env = t.env
+		server.Stdout = log
+		server.Stderr = log
+		if err := server.Start(); err != nil {
+			return err
+		}
+
+		url = "http://" + addr + "/api/mgit/repos/work"
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+			resp, err := http.Get(url + "/info")
+			if err == nil {
+				resp.Body.Close()
+				return nil
+			}
+		}
+		return fmt.Errorf("mgit serve did not start listening on %s; see %s", addr, log.Name())
+	}) && t.step("log in with a nostr key", func() error {
+		_, err := t.mgit(".", "login", "--nostr", url)
+		return err
+	}) && t.step("clone through the server", func() error {
+		_, err := t.mgit(".", "clone", url, "served-copy")
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	t.step("verify server clone", func() error {
+		return t.sameMGitHead("work", "served-copy")
+	})
+}
+
+// runServerRoundTrip clones from a running mgit server and checks the MGit
+// metadata it serves
+func (t *selftest) runServerRoundTrip(url string) {
+	fmt.Printf("Server round trip (%s):\n", url)
+
+	var auth *Credentials
+	ok := t.step("find authentication credentials", func() error {
+		var err error
+		auth, err = lookupAuth(url)
+		if err == nil && auth.Scheme != "Bearer" {
+			// The scratch environment only passes a token through, via -jwt
+			err = fmt.Errorf("%s supplies %s auth; selftest needs a bearer token", auth.Provider, auth.Scheme)
+		}
+		return err
+	}) && t.step("fetch repository info", func() error {
+		_, err := fetchRepositoryInfo(commandContext(), url, auth)
+		return err
+	}) && t.step("clone from server", func() error {
+		_, err := t.mgit(".", "clone", "-jwt", auth.Value, url, "server-copy")
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	t.step("verify server clone", func() error {
+		if _, err := os.Stat(filepath.Join(t.dir, "server-copy", ".mgit", "HEAD")); os.IsNotExist(err) {
+			// A repository without MGit commits has nothing to verify
+			return nil
+		}
+		_, err := t.mgit("server-copy", "verify")
+		return err
+	})
+}
+
+// sameGitHead checks two repositories have the same git HEAD commit
+func (t *selftest) sameGitHead(a, b string) error {
+	headA, err := t.git(a, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	headB, err := t.git(b, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(headA) != strings.TrimSpace(headB) {
+		return fmt.Errorf("%s is at %s but %s is at %s", a, strings.TrimSpace(headA), b, strings.TrimSpace(headB))
+	}
+	return nil
+}
+
+// sameMGitHead checks a clone verifies and resolves to the same MGit HEAD
+// commit as its source
+func (t *selftest) sameMGitHead(source, clone string) error {
+	if _, err := t.mgit(clone, "verify"); err != nil {
+		return err
+	}
+	want, err := (&MGitStorage{RootDir: filepath.Join(t.dir, source, ".mgit")}).GetHeadCommit()
+	if err != nil {
+		return fmt.Errorf("reading %s MGit HEAD: %w", source, err)
+	}
+	got, err := (&MGitStorage{RootDir: filepath.Join(t.dir, clone, ".mgit")}).GetHeadCommit()
+	if err != nil {
+		return fmt.Errorf("reading %s MGit HEAD: %w", clone, err)
+	}
+	if got.MGitHash != want.MGitHash {
+		return fmt.Errorf("%s MGit HEAD is %s, expected %s", clone, shortHash(got.MGitHash), shortHash(want.MGitHash))
+	}
+	return nil
+}
+
+// indentOutput indents command output under a failure message
+func indentOutput(out string) string {
+	out = strings.TrimRight(out, "\n")
+	if out == "" {
+		return "  (no output)"
+	}
+	return "  " + strings.ReplaceAll(out, "\n", "\n  ")
+}
@@ -0,0 +1,927 @@
+package cli
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// mgit serve hosts the repositories in a directory as an mgit server, so a
+// self-hoster needs nothing but the mgit binary and git. Each repository
+// directly under the root (a working copy, or a bare repository made with
+// mgit init --bare) is served under its directory name, without .git:
+//
+//	GET  /api/mgit/auth/challenge?repo_id=<id>     -> challenge
+//	POST /api/mgit/auth/nostr                      {"repo_id", "event"} -> token
+//	POST /api/mgit/auth/refresh                    the token as Bearer auth -> token
+//	GET  /api/mgit/repos/<id>/info                 -> {"id", "name", "access"}
+//	GET  /api/mgit/repos/<id>/metadata[?since=]    mappings and notes, from a sync cursor
+//	POST /api/mgit/repos/<id>/metadata             add mappings and notes (write access;
+//	                                               new mappings must carry the writer's key)
+//	GET|POST|DELETE /api/mgit/repos/<id>/access    the access list (admin access)
+//	/api/mgit/repos/<id>/info/refs, git-upload-pack, git-receive-pack
+//	                                               git's smart HTTP protocol, by git http-backend
+//
+// A client logs in with mgit login --nostr: it signs the challenge as a
+// NIP-98 event and gets a JWT (HS256, signed with serve.secret) naming its
+// key and the repository, valid for serve.tokenTTL (default 24h). Every
+// other request carries that token as Bearer auth. What a key may do is
+// looked up on each request, so revoking access takes effect at once: the
+// repository's owner (repository.owner in its .mgit/config) and the keys in
+// serve.admins have admin access, and others what .mgit/access.json grants
+// them. Mappings and access lists are kept in each repository's .mgit
+// directory, which bare repositories get on first use.
+
+const (
+	serveUsage         = "Usage: mgit serve [--listen <addr>] [--secret <secret>] [--cert-file <file> --key-file <file>] [<root>]"
+	defaultServeListen = "127.0.0.1:7070"
+	defaultServeTTL    = 24 * time.Hour
+	// serveChallengeTTL bounds how long a login challenge can be answered
+	serveChallengeTTL = 5 * time.Minute
+	// serveMaxChallenges bounds the login challenges waiting to be signed,
+	// so unanswered requests can't grow them without limit
+	serveMaxChallenges = 10000
+	// serveMetadataLimit bounds the body of a metadata upload
+	serveMetadataLimit = 64 << 20
+)
+
+// serveRepoIDPattern matches the repository names that can be served
+var serveRepoIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ServeOptions controls the embedded server
+type ServeOptions struct {
+	Listen   string
+	Root     string
+	Secret   string // Signs the tokens the server issues
+	CertFile string // Serve HTTPS with this certificate and key
+	KeyFile  string
+	TokenTTL time.Duration
+	Admins   []string // npubs with admin access to every repository
+	// CORSOrigins are the web origins whose pages may call the server, as
+	// the js/wasm build does; "*" allows any
+	CORSOrigins []string
+}
+
+// mgitServer serves the repositories under a root directory
+type mgitServer struct {
+	opts    *ServeOptions
+	secret  []byte
+	gitPath string
+
+	mu         sync.Mutex
+	challenges map[string]serveChallenge
+}
+
+// serveChallenge is a login challenge waiting to be signed
+type serveChallenge struct {
+	RepoID  string
+	Expires time.Time
+}
+
+// serveClaims are the claims of the tokens the server issues
+type serveClaims struct {
+	Subject  string `json:"sub"` // npub
+	Repo     string `json:"repo"`
+	Access   string `json:"access"` // At the time of issue; checked again on use
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+}
+
+// servedRepo is a repository the server found under its root
+type servedRepo struct {
+	ID  string
+	Dir string // The working copy, or the bare repository
+}
+
+// HandleServe handles the serve command
+func HandleServe(args []string) {
+	opts := &ServeOptions{
+		Listen:   GetConfigValue("serve.listen", defaultServeListen),
+		Root:     ".",
+		Secret:   GetConfigValue("serve.secret", ""),
+		CertFile: GetConfigValue("serve.certFile", ""),
+		KeyFile:  GetConfigValue("serve.keyFile", ""),
+	}
+	rootGiven := false
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") && !rootGiven {
+			opts.Root = args[i]
+			rootGiven = true
+			continue
+		}
+		if i+1 >= len(args) {
+			fmt.Println(serveUsage)
+			os.Exit(1)
+		}
+		switch args[i] {
+		case "--listen":
+			opts.Listen = args[i+1]
+		case "--secret":
+			opts.Secret = args[i+1]
+		case "--cert-file":
+			opts.CertFile = args[i+1]
+		case "--key-file":
+			opts.KeyFile = args[i+1]
+		default:
+			fmt.Println(serveUsage)
+			os.Exit(1)
+		}
+		i++
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		fmt.Println("Error: --cert-file and --key-file go together")
+		os.Exit(1)
+	}
+	ttl := GetConfigDuration("serve.tokenTTL", defaultServeTTL)
+	if ttl <= 0 {
+		fmt.Printf("Error: invalid serve.tokenTTL '%s'\n", GetConfigValue("serve.tokenTTL", ""))
+		os.Exit(1)
+	}
+	opts.TokenTTL = ttl
+	for _, admin := range GetConfigValues("serve.admins") {
+		npub, err := NormalizeNostrPubKey(admin)
+		if err != nil {
+			fmt.Printf("Error: invalid serve.admins key '%s': %s\n", admin, err)
+			os.Exit(1)
+		}
+		opts.Admins = append(opts.Admins, npub)
+	}
+	opts.CORSOrigins = GetConfigValues("serve.corsOrigins")
+	if opts.Secret == "" {
+		fmt.Println("Warning: serve.secret is not set; tokens are signed with a random key and stop working when the server restarts")
+	}
+
+	server, err := newMGitServer(opts)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	repos, err := server.repos()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	scheme := "http"
+	if opts.CertFile != "" {
+		scheme = "https"
+	}
+	fmt.Printf("Serving %d repositor(ies) from %s on %s://%s\n", len(repos), opts.Root, scheme, opts.Listen)
+	for _, repo := range repos {
+		fmt.Printf("  %s://%s%s%s\n", scheme, opts.Listen, apiReposPath, repo.ID)
+	}
+	if opts.CertFile != "" {
+		err = http.ListenAndServeTLS(opts.Listen, expandHome(opts.CertFile), expandHome(opts.KeyFile), server)
+	} else {
+		err = http.ListenAndServe(opts.Listen, server)
+	}
+	fmt.Printf("Error running server: %s\n", err)
+	os.Exit(1)
+}
+
+// newMGitServer prepares a server for the repositories under opts.Root
+func newMGitServer(opts *ServeOptions) (*mgitServer, error) {
+	if info, err := os.Stat(opts.Root); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", opts.Root)
+	}
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return nil, fmt.Errorf("git is needed to serve repositories: %w", err)
+	}
+	secret := []byte(opts.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("error generating a signing key: %w", err)
+		}
+	}
+	return &mgitServer{
+		opts:       opts,
+		secret:     secret,
+		gitPath:    gitPath,
+		challenges: map[string]serveChallenge{},
+	}, nil
+}
+
+// repos lists the repositories under the root
+func (s *mgitServer) repos() ([]*servedRepo, error) {
+	entries, err := os.ReadDir(s.opts.Root)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", s.opts.Root, err)
+	}
+	repos := []*servedRepo{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if repo := s.repo(strings.TrimSuffix(entry.Name(), ".git")); repo != nil && filepath.Base(repo.Dir) == entry.Name() {
+			repos = append(repos, repo)
+		}
+	}
+	return repos, nil
+}
+
+// repo finds the repository served as id, or nil
+func (s *mgitServer) repo(id string) *servedRepo {
+	if !serveRepoIDPattern.MatchString(id) || strings.HasSuffix(id, ".git") {
+		return nil
+	}
+	for _, name := range []string{id, id + ".git"} {
+		dir := filepath.Join(s.opts.Root, name)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return &servedRepo{ID: id, Dir: dir}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "objects")); err == nil {
+			if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+				return &servedRepo{ID: id, Dir: dir}
+			}
+		}
+	}
+	return nil
+}
+
+// storage returns the repository's MGit storage
+func (r *servedRepo) storage() *MGitStorage {
+	return &MGitStorage{RootDir: filepath.Join(r.Dir, ".mgit")}
+}
+
+// name returns the repository's name, from its config or else its ID
+func (r *servedRepo) name() string {
+	if config, err := LoadConfig(filepath.Join(r.Dir, ".mgit", "config")); err == nil {
+		if name := config.Get("repository", "name"); name != "" {
+			return name
+		}
+	}
+	return r.ID
+}
+
+// accessFor returns what a key may do in the repository: "admin", "write",
+// "read" or ""
+func (s *mgitServer) accessFor(repo *servedRepo, npub string) string {
+	if containsString(s.opts.Admins, npub) {
+		return "admin"
+	}
+	if config, err := LoadConfig(filepath.Join(repo.Dir, ".mgit", "config")); err == nil {
+		if owner := config.Get("repository", "owner"); owner != "" && canonicalNostrPubKey(owner) == npub {
+			return "admin"
+		}
+	}
+	grants, err := loadServeGrants(repo)
+	if err != nil {
+		return ""
+	}
+	for _, grant := range grants {
+		if grant.Pubkey == npub {
+			return grant.Access
+		}
+	}
+	return ""
+}
+
+// accessAllows reports whether an access level covers what is needed
+func accessAllows(access, needed string) bool {
+	rank := map[string]int{"read": 1, "write": 2, "admin": 3}
+	return rank[access] > 0 && rank[access] >= rank[needed]
+}
+
+// serveAccessPath returns the file holding a repository's access list
+func serveAccessPath(repo *servedRepo) string {
+	return filepath.Join(repo.Dir, ".mgit", "access.json")
+}
+
+// loadServeGrants reads a repository's access list
+func loadServeGrants(repo *servedRepo) ([]AccessGrant, error) {
+	grants := []AccessGrant{}
+	data, err := os.ReadFile(serveAccessPath(repo))
+	if os.IsNotExist(err) {
+		return grants, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &grants); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", serveAccessPath(repo), err)
+	}
+	return grants, nil
+}
+
+// saveServeGrants writes a repository's access list
+func saveServeGrants(repo *servedRepo, grants []AccessGrant) error {
+	data, err := json.MarshalIndent(grants, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(serveAccessPath(repo)), 0755); err != nil {
+		return err
+	}
+	return fsutil.WriteFile(serveAccessPath(repo), data)
+}
+
+func (s *mgitServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		serveLogf("%s %s %d", r.Method, r.URL.Path, status.status)
+	}()
+	if s.allowCORS(status, r) {
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/mgit/auth/challenge":
+		s.handleChallenge(status, r)
+		return
+	case "/api/mgit/auth/nostr":
+		s.handleNostrLogin(status, r)
+		return
+	case "/api/mgit/auth/refresh":
+		s.handleRefresh(status, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, apiReposPath) {
+		serveError(status, http.StatusNotFound, "not found")
+		return
+	}
+	id, endpoint, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, apiReposPath), "/")
+	repo := s.repo(strings.TrimSuffix(id, ".git"))
+	if repo == nil {
+		serveError(status, http.StatusNotFound, "no such repository")
+		return
+	}
+	endpoint = "/" + endpoint
+
+	// The access each endpoint needs
+	needed := "read"
+	switch {
+	case endpoint == "/metadata" && r.Method == http.MethodPost:
+		needed = "write"
+	case endpoint == "/git-receive-pack" || (endpoint == "/info/refs" && r.URL.Query().Get("service") == "git-receive-pack"):
+		needed = "write"
+	case endpoint == "/access" || strings.HasPrefix(endpoint, "/access/"):
+		needed = "admin"
+	}
+
+	npub, err := s.authenticate(r, repo.ID)
+	if err != nil {
+		status.Header().Set("WWW-Authenticate", `Bearer realm="mgit"`)
+		serveError(status, http.StatusUnauthorized, err.Error())
+		return
+	}
+	access := s.accessFor(repo, npub)
+	if !accessAllows(access, needed) {
+		serveError(status, http.StatusForbidden, fmt.Sprintf("%s access needed", needed))
+		return
+	}
+
+	switch {
+	case endpoint == "/info" && r.Method == http.MethodGet:
+		serveJSON(status, http.StatusOK, &RepositoryInfo{ID: repo.ID, Name: repo.name(), Access: access})
+	case endpoint == "/metadata" && r.Method == http.MethodGet:
+		s.handleMetadataGet(status, r, repo)
+	case endpoint == "/metadata" && r.Method == http.MethodPost:
+		s.handleMetadataPost(status, r, repo, npub, access)
+	case endpoint == "/access" || strings.HasPrefix(endpoint, "/access/"):
+		s.handleAccess(status, r, repo, npub, strings.TrimPrefix(endpoint, "/access"))
+	case endpoint == "/info/refs" || endpoint == "/git-upload-pack" || endpoint == "/git-receive-pack" || endpoint == "/HEAD":
+		s.handleGit(status, r, repo, npub)
+	default:
+		serveError(status, http.StatusNotFound, "not found")
+	}
+}
+
+// allowCORS adds the CORS headers that let a page from one of
+// serve.corsOrigins call the server, and answers the browser's preflight
+// request. It reports whether the request was a preflight.
+func (s *mgitServer) allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !s.corsAllowed(origin) {
+		return false
+	}
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Add("Vary", "Origin")
+	// Metadata fetches read these to continue where they left off
+	header.Set("Access-Control-Expose-Headers", "ETag, X-MGit-Cursor, X-MGit-Since")
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	header.Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+	header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match, Range, Git-Protocol")
+	header.Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// corsAllowed reports whether serve.corsOrigins lets origin in
+func (s *mgitServer) corsAllowed(origin string) bool {
+	for _, allowed := range s.opts.CORSOrigins {
+		if allowed == "*" || strings.TrimSuffix(allowed, "/") == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRecorder notes the status a handler answered with, for the log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streamed responses through as they are written
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// serveLogf prints a timestamped server log line
+func serveLogf(format string, args ...interface{}) {
+	fmt.Printf("[%s] %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+}
+
+// serveJSON answers with a JSON body
+func serveJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// serveError answers with {"error": message}
+func serveError(w http.ResponseWriter, status int, message string) {
+	serveJSON(w, status, map[string]string{"error": message})
+}
+
+// handleChallenge hands out a login challenge for a repository
+func (s *mgitServer) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		serveError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	repoID := r.URL.Query().Get("repo_id")
+	if s.repo(repoID) == nil {
+		serveError(w, http.StatusNotFound, "no such repository")
+		return
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		serveError(w, http.StatusInternalServerError, "error generating challenge")
+		return
+	}
+	challenge := hex.EncodeToString(random)
+
+	s.mu.Lock()
+	now := time.Now()
+	for key, pending := range s.challenges {
+		if now.After(pending.Expires) {
+			delete(s.challenges, key)
+		}
+	}
+	if len(s.challenges) >= serveMaxChallenges {
+		s.mu.Unlock()
+		serveError(w, http.StatusServiceUnavailable, "too many logins in progress; try again later")
+		return
+	}
+	s.challenges[challenge] = serveChallenge{RepoID: repoID, Expires: now.Add(serveChallengeTTL)}
+	s.mu.Unlock()
+
+	serveJSON(w, http.StatusOK, map[string]string{"challenge": challenge})
+}
+
+// handleNostrLogin exchanges a signed challenge for a token
+func (s *mgitServer) handleNostrLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		serveError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var request struct {
+		RepoID string       `json:"repo_id"`
+		Event  *nostr.Event `json:"event"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&request); err != nil || request.Event == nil {
+		serveLoginError(w, http.StatusBadRequest, "invalid_request", "expected a JSON body with repo_id and event")
+		return
+	}
+	event := request.Event
+	if err := event.Verify(); err != nil {
+		serveLoginError(w, http.StatusUnauthorized, "invalid_grant", err.Error())
+		return
+	}
+	if event.Kind != nostr.KindHTTPAuth || nostrTag(event, "method") != http.MethodPost {
+		serveLoginError(w, http.StatusUnauthorized, "invalid_grant", "not a NIP-98 event for this request")
+		return
+	}
+	if signed, err := url.Parse(nostrTag(event, "u")); err != nil || !strings.HasSuffix(signed.Path, "/api/mgit/auth/nostr") {
+		serveLoginError(w, http.StatusUnauthorized, "invalid_grant", "the event was signed for another URL")
+		return
+	}
+	if age := time.Since(time.Unix(event.CreatedAt, 0)); age > serveChallengeTTL || age < -serveChallengeTTL {
+		serveLoginError(w, http.StatusUnauthorized, "invalid_grant", "the event is too old, or from the future")
+		return
+	}
+
+	// Each challenge answers one login
+	s.mu.Lock()
+	pending, found := s.challenges[nostrTag(event, "challenge")]
+	delete(s.challenges, nostrTag(event, "challenge"))
+	s.mu.Unlock()
+	if !found || time.Now().After(pending.Expires) || pending.RepoID != request.RepoID {
+		serveLoginError(w, http.StatusUnauthorized, "invalid_grant", "unknown or expired challenge")
+		return
+	}
+
+	repo := s.repo(request.RepoID)
+	npub, err := nostr.Npub(event.Pubkey)
+	if repo == nil || err != nil {
+		serveLoginError(w, http.StatusBadRequest, "invalid_request", "no such repository")
+		return
+	}
+	s.issueToken(w, repo, npub)
+}
+
+// handleRefresh issues a new token for a valid one
+func (s *mgitServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		serveError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	claims, err := s.verifyToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		serveLoginError(w, http.StatusUnauthorized, "invalid_grant", err.Error())
+		return
+	}
+	repo := s.repo(claims.Repo)
+	if repo == nil {
+		serveLoginError(w, http.StatusUnauthorized, "invalid_grant", "no such repository")
+		return
+	}
+	s.issueToken(w, repo, claims.Subject)
+}
+
+// serveLoginError answers a token request the way OAuth does
+func serveLoginError(w http.ResponseWriter, status int, code, description string) {
+	serveJSON(w, status, &LoginResult{Error: code, ErrorDescription: description})
+}
+
+// nostrTag returns the first value of an event's tag, or ""
+func nostrTag(event *nostr.Event, name string) string {
+	for _, tag := range event.Tags {
+		if len(tag) >= 2 && tag[0] == name {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// issueToken answers with a token for a key with access to the repository
+func (s *mgitServer) issueToken(w http.ResponseWriter, repo *servedRepo, npub string) {
+	access := s.accessFor(repo, npub)
+	if access == "" {
+		serveLoginError(w, http.StatusForbidden, "access_denied", fmt.Sprintf("%s has no access to %s", npub, repo.ID))
+		return
+	}
+	now := time.Now()
+	token, err := s.signToken(&serveClaims{
+		Subject:  npub,
+		Repo:     repo.ID,
+		Access:   access,
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(s.opts.TokenTTL).Unix(),
+	})
+	if err != nil {
+		serveLoginError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	serveJSON(w, http.StatusOK, &LoginResult{Token: token, Access: access})
+}
+
+// signToken encodes claims as an HS256 JWT
+func (s *mgitServer) signToken(claims *serveClaims) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyToken checks a token's signature and expiry and returns its claims
+func (s *mgitServer) verifyToken(token string) (*serveClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("a bearer token is required (log in with mgit login --nostr)")
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+	claims := &serveClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil || claims.Subject == "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, fmt.Errorf("token expired (log in again with mgit login --nostr)")
+	}
+	return claims, nil
+}
+
+// authenticate returns the key a request's token was issued to, for the
+// repository
+func (s *mgitServer) authenticate(r *http.Request, repoID string) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", fmt.Errorf("a bearer token is required (log in with mgit login --nostr)")
+	}
+	claims, err := s.verifyToken(strings.TrimPrefix(auth, "Bearer "))
+	if err != nil {
+		return "", err
+	}
+	if claims.Repo != repoID {
+		return "", fmt.Errorf("the token is for another repository")
+	}
+	return claims.Subject, nil
+}
+
+// handleMetadataGet streams the repository's mappings, with their notes,
+// from the since cursor when one is given. The cursor is the number of
+// mappings already sent; the mappings file only grows at the end, except
+// when gc compacts it, which changes the ETag.
+func (s *mgitServer) handleMetadataGet(w http.ResponseWriter, r *http.Request, repo *servedRepo) {
+	storage := repo.storage()
+	store := storage.Mappings()
+	store.Invalidate()
+	mappings, err := store.All()
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	noted, err := storage.notedCommits()
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The ETag covers every mapping and note, so a client holding it has
+	// everything
+	digest := sha256.New()
+	notes := map[string]CommitNotes{}
+	for _, mapping := range mappings {
+		fmt.Fprintf(digest, "%s %s %s\n", mapping.GitHash, mapping.MGitHash, mapping.Pubkey)
+	}
+	for _, hash := range noted {
+		commitNotes, err := storage.GetNotes(hash)
+		if err != nil {
+			serveError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		notes[hash] = commitNotes
+		data, _ := json.Marshal(commitNotes)
+		fmt.Fprintf(digest, "%s %s\n", hash, data)
+	}
+	etag := `"` + hex.EncodeToString(digest.Sum(nil))[:32] + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	start := 0
+	if since := r.URL.Query().Get("since"); since != "" {
+		if n, err := strconv.Atoi(since); err == nil && n >= 0 && n <= len(mappings) {
+			start = n
+			w.Header().Set("X-MGit-Since", since)
+		}
+	}
+	w.Header().Set("X-MGit-Cursor", strconv.Itoa(len(mappings)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	io.WriteString(w, "[")
+	for i, mapping := range mappings[start:] {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		mapping.Source = ""
+		encoder.Encode(&remoteMetadataEntry{Mapping: mapping, Notes: notes[mapping.MGitHash]})
+	}
+	io.WriteString(w, "]\n")
+}
+
+// handleMetadataPost adds mappings and notes to the repository. A mapping
+// the server already holds is only taken again unchanged, except from an
+// admin; a writer may only attribute new mappings to their own key. Every
+// new or changed mapping must reproduce its MGit hash from the git commit
+// and the key it names. Mappings of commits the server doesn't have yet,
+// as a push of everything local sends for unpushed branches, are left out
+// until they are sent again after those commits arrive.
+func (s *mgitServer) handleMetadataPost(w http.ResponseWriter, r *http.Request, repo *servedRepo, npub, access string) {
+	entries := []remoteMetadataEntry{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, serveMetadataLimit)).Decode(&entries); err != nil {
+		serveError(w, http.StatusBadRequest, "expected a JSON list of mappings")
+		return
+	}
+	gitRepo, err := git.PlainOpen(repo.Dir)
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	storage := repo.storage()
+	store := storage.Mappings()
+	store.Invalidate()
+	existing, err := store.All()
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Parents are looked up among the stored mappings and the ones sent;
+	// a wrong parent mapping fails its own check
+	byGit := make(map[string]string, len(existing)+len(entries))
+	for _, mapping := range existing {
+		byGit[mapping.GitHash] = mapping.MGitHash
+	}
+	for _, entry := range entries {
+		if !isFullHash(entry.GitHash) || !isFullHash(entry.MGitHash) {
+			serveError(w, http.StatusBadRequest, fmt.Sprintf("invalid mapping %s -> %s", entry.GitHash, entry.MGitHash))
+			return
+		}
+		if _, ok := byGit[entry.GitHash]; !ok {
+			byGit[entry.GitHash] = entry.MGitHash
+		}
+	}
+
+	mappings := make([]NostrCommitMapping, 0, len(entries))
+	noted := []remoteMetadataEntry{}
+	for _, entry := range entries {
+		held, ok, err := store.ByGit(entry.GitHash)
+		if err != nil {
+			serveError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		unchanged := ok && held.MGitHash == entry.MGitHash && canonicalNostrPubKey(held.Pubkey) == canonicalNostrPubKey(entry.Pubkey)
+		switch {
+		case unchanged || access == "admin":
+		case ok:
+			serveError(w, http.StatusForbidden, fmt.Sprintf("%s is already attributed to %s", shortHash(entry.GitHash), orNone(held.Pubkey)))
+			return
+		case canonicalNostrPubKey(entry.Pubkey) != npub:
+			serveError(w, http.StatusForbidden, fmt.Sprintf("mapping for %s is attributed to %s, not to you", shortHash(entry.GitHash), orNone(entry.Pubkey)))
+			return
+		}
+
+		if !unchanged {
+			commit, err := gitRepo.CommitObject(plumbing.NewHash(entry.GitHash))
+			if err == plumbing.ErrObjectNotFound {
+				continue
+			}
+			if err != nil {
+				serveError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if _, ok := mgitlib.RebuildCommit(commit, entry.Mapping, byGit); !ok {
+				serveError(w, http.StatusBadRequest, fmt.Sprintf("MGit hash %s does not match commit %s with key %s", shortHash(entry.MGitHash), shortHash(entry.GitHash), orNone(entry.Pubkey)))
+				return
+			}
+		}
+
+		mapping := entry.Mapping
+		mapping.Source = ""
+		mappings = append(mappings, mapping)
+		if len(entry.Notes) > 0 {
+			noted = append(noted, entry)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(storage.mappingsPath()), 0755); err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := store.PutAll(mappings); err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := mergeRemoteNotes(storage, noted); err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAccess lists, grants and revokes access to the repository
+func (s *mgitServer) handleAccess(w http.ResponseWriter, r *http.Request, repo *servedRepo, npub, path string) {
+	grants, err := loadServeGrants(repo)
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		serveJSON(w, http.StatusOK, grants)
+	case path == "" && r.Method == http.MethodPost:
+		grant := AccessGrant{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&grant); err != nil {
+			serveError(w, http.StatusBadRequest, "expected a JSON body with pubkey and access")
+			return
+		}
+		pubkey, err := NormalizeNostrPubKey(grant.Pubkey)
+		if err != nil || (grant.Access != "read" && grant.Access != "write") {
+			serveError(w, http.StatusBadRequest, "expected an npub and read or write access")
+			return
+		}
+		grant = AccessGrant{Pubkey: pubkey, Access: grant.Access, GrantedBy: npub, GrantedAt: time.Now().UTC().Format(time.RFC3339)}
+		kept := []AccessGrant{}
+		for _, existing := range grants {
+			if existing.Pubkey != pubkey {
+				kept = append(kept, existing)
+			}
+		}
+		if err := saveServeGrants(repo, append(kept, grant)); err != nil {
+			serveError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		serveJSON(w, http.StatusOK, grant)
+	case strings.HasPrefix(path, "/") && r.Method == http.MethodDelete:
+		pubkey, err := NormalizeNostrPubKey(strings.TrimPrefix(path, "/"))
+		if err != nil {
+			serveError(w, http.StatusBadRequest, "expected an npub")
+			return
+		}
+		kept := []AccessGrant{}
+		for _, existing := range grants {
+			if existing.Pubkey != pubkey {
+				kept = append(kept, existing)
+			}
+		}
+		if len(kept) == len(grants) {
+			serveError(w, http.StatusNotFound, "no such grant")
+			return
+		}
+		if err := saveServeGrants(repo, kept); err != nil {
+			serveError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		serveError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleGit passes a request of git's smart HTTP protocol to git
+// http-backend
+func (s *mgitServer) handleGit(w http.ResponseWriter, r *http.Request, repo *servedRepo, npub string) {
+	dir, err := filepath.Abs(repo.Dir)
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	prefix := strings.TrimSuffix(r.URL.Path, strings.SplitN(strings.TrimPrefix(r.URL.Path, apiReposPath), "/", 2)[1])
+	handler := &cgi.Handler{
+		Path: s.gitPath,
+		Args: []string{"http-backend"},
+		Root: strings.TrimSuffix(prefix, "/"),
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + dir,
+			"GIT_HTTP_EXPORT_ALL=1",
+			// http-backend allows pushes from an authenticated user
+			"REMOTE_USER=" + npub,
+		},
+		InheritEnv: []string{"HOME"},
+	}
+	handler.ServeHTTP(w, r)
+}
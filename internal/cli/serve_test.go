@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// testNpub returns the npub of a secret key made of one repeated byte
+func testNpub(t *testing.T, b byte) string {
+	t.Helper()
+	pubkey, err := nostr.PublicKey(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	npub, err := nostr.Npub(hex.EncodeToString(pubkey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return npub
+}
+
+// testServedRepo makes a repository with one commit under a server root,
+// with writers granted write access, and returns the server and the commit
+func testServedRepo(t *testing.T, writers ...string) (*mgitServer, *object.Commit) {
+	t.Helper()
+	isolateConfig(t)
+	root := t.TempDir()
+	dir := filepath.Join(root, "records")
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "Tester", Email: "tester@example.com", When: time.Unix(1700000000, 0)}
+	hash, err := worktree.Commit("first\n", &git.CommitOptions{Author: sig, Committer: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := newMGitServer(&ServeOptions{Root: root, Secret: "test", TokenTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := server.repo("records")
+	grants := []AccessGrant{}
+	for _, npub := range writers {
+		grants = append(grants, AccessGrant{Pubkey: npub, Access: "write"})
+	}
+	if err := saveServeGrants(served, grants); err != nil {
+		t.Fatal(err)
+	}
+	return server, commit
+}
+
+// postMetadata posts entries to the test repository's metadata endpoint as
+// npub and returns the response's status
+func postMetadata(t *testing.T, server *mgitServer, npub string, entries []remoteMetadataEntry) int {
+	t.Helper()
+	token, err := server.signToken(&serveClaims{Subject: npub, Repo: "records", Access: "write", Expiry: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", apiReposPath+"records/metadata", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// testMapping returns a commit's mapping attributed to npub
+func testMapping(commit *object.Commit, npub string) remoteMetadataEntry {
+	mgitHash := mgitlib.CommitFromGit(commit, nil, npub).ComputeHash()
+	return remoteMetadataEntry{Mapping: NostrCommitMapping{GitHash: commit.Hash.String(), MGitHash: mgitHash, Pubkey: npub}}
+}
+
+func TestMetadataPostKeepsOthersMappings(t *testing.T) {
+	writerA, writerB := testNpub(t, 1), testNpub(t, 2)
+	server, commit := testServedRepo(t, writerA, writerB)
+
+	if status := postMetadata(t, server, writerB, []remoteMetadataEntry{testMapping(commit, writerB)}); status != http.StatusNoContent {
+		t.Fatalf("writer B's mapping: status %d", status)
+	}
+	// Sending it again unchanged, as a push of everything local does
+	if status := postMetadata(t, server, writerA, []remoteMetadataEntry{testMapping(commit, writerB)}); status != http.StatusNoContent {
+		t.Errorf("resending B's mapping as A: status %d, want %d", status, http.StatusNoContent)
+	}
+	// A claims B's commit with a hash that is valid for A's key
+	if status := postMetadata(t, server, writerA, []remoteMetadataEntry{testMapping(commit, writerA)}); status != http.StatusForbidden {
+		t.Errorf("A overwriting B's mapping: status %d, want %d", status, http.StatusForbidden)
+	}
+
+	mapping, ok, err := server.repo("records").storage().Mappings().ByGit(commit.Hash.String())
+	if err != nil || !ok || mapping.Pubkey != writerB {
+		t.Errorf("stored mapping = %+v, %v, %v; want B's", mapping, ok, err)
+	}
+}
+
+func TestMetadataPostChecksHash(t *testing.T) {
+	writer := testNpub(t, 1)
+	server, commit := testServedRepo(t, writer)
+
+	forged := testMapping(commit, writer)
+	forged.MGitHash = commit.Hash.String()
+	if status := postMetadata(t, server, writer, []remoteMetadataEntry{forged}); status != http.StatusBadRequest {
+		t.Errorf("a mapping whose hash doesn't match: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// A commit the server doesn't have yet is left for a later push
+	unknown := testMapping(commit, writer)
+	unknown.GitHash = "0123456789abcdef0123456789abcdef01234567"
+	if status := postMetadata(t, server, writer, []remoteMetadataEntry{unknown}); status != http.StatusNoContent {
+		t.Errorf("a mapping of an unknown commit: status %d, want %d", status, http.StatusNoContent)
+	}
+	if _, ok, _ := server.repo("records").storage().Mappings().ByGit(unknown.GitHash); ok {
+		t.Error("stored the mapping of a commit the server doesn't have")
+	}
+}
+
+func TestChallengesBounded(t *testing.T) {
+	server, _ := testServedRepo(t)
+	challenge := func() int {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/mgit/auth/challenge?repo_id=records", nil))
+		return recorder.Code
+	}
+
+	for i := 0; i < serveMaxChallenges; i++ {
+		server.challenges[hex.EncodeToString([]byte{byte(i >> 8), byte(i)})] = serveChallenge{RepoID: "records", Expires: time.Now().Add(time.Minute)}
+	}
+	if status := challenge(); status != http.StatusServiceUnavailable {
+		t.Errorf("challenge with %d pending: status %d, want %d", serveMaxChallenges, status, http.StatusServiceUnavailable)
+	}
+
+	// Expired challenges make room
+	for key, pending := range server.challenges {
+		pending.Expires = time.Now().Add(-time.Second)
+		server.challenges[key] = pending
+	}
+	if status := challenge(); status != http.StatusOK {
+		t.Errorf("challenge after the others expired: status %d, want %d", status, http.StatusOK)
+	}
+	if len(server.challenges) != 1 {
+		t.Errorf("%d challenges kept, want the new one only", len(server.challenges))
+	}
+}
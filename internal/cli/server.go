@@ -0,0 +1,327 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Server profiles describe the mgit servers a user works with. Each is a
+// section of the global config:
+//
+//	[server "clinic"]
+//		url = https://git.clinic.example/mgit
+//		match = https://git.clinic.example/*
+//		caFile = /etc/ssl/clinic-ca.pem
+//		certFile = ~/.mgitconfig/clinic.crt
+//		keyFile = ~/.mgitconfig/clinic.key
+//		insecure = false
+//
+// A repository URL uses the first profile, by name, whose match pattern fits
+// it (a profile without one matches its url and everything under it). The
+// profile's url is the server's base URL for API requests, its TLS settings
+// take the place of the http.ssl* settings for those requests and for git's,
+// and a token saved for the server with mgit server add --token is used for
+// any of its repositories that has no token of its own in the token store.
+
+const serverUsage = "Usage: mgit server list | add <name> <url> [--match <url-pattern>]... [--ca-file <file>] [--cert-file <file> --key-file <file>] [--insecure] [--token <token>] | remove <name>"
+
+// ServerProfile is a named server from the global config
+type ServerProfile struct {
+	Name     string
+	URL      string
+	Match    []string
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	Insecure bool
+}
+
+// serverSection returns the config section holding a server profile
+func serverSection(name string) string {
+	return fmt.Sprintf("server \"%s\"", name)
+}
+
+// loadServers reads the server profiles in a config, by name
+func loadServers(config *Config) []*ServerProfile {
+	servers := []*ServerProfile{}
+	for section := range config.Sections {
+		if !strings.HasPrefix(section, "server \"") || !strings.HasSuffix(section, "\"") || len(section) <= len("server \"\"") {
+			continue
+		}
+		servers = append(servers, &ServerProfile{
+			Name:     section[len("server \"") : len(section)-1],
+			URL:      strings.TrimSuffix(config.Get(section, "url"), "/"),
+			Match:    config.GetAll(section, "match"),
+			CAFile:   config.Get(section, "caFile"),
+			CertFile: config.Get(section, "certFile"),
+			KeyFile:  config.Get(section, "keyFile"),
+			Insecure: config.Get(section, "insecure") == "true",
+		})
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers
+}
+
+// globalServers reads the server profiles in the global config
+func globalServers() ([]*ServerProfile, error) {
+	config, err := LoadConfig(GetConfigFilePath(true))
+	if err != nil {
+		return nil, err
+	}
+	return loadServers(config), nil
+}
+
+// Matches reports whether a URL belongs to the server
+func (s *ServerProfile) Matches(url string) bool {
+	url = strings.TrimSuffix(url, "/")
+	if len(s.Match) == 0 {
+		return s.URL != "" && (url == s.URL || strings.HasPrefix(url, s.URL+"/"))
+	}
+	for _, pattern := range s.Match {
+		if matchURLPattern(pattern, url) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverForURL returns the server profile a URL uses, or nil
+func serverForURL(url string) *ServerProfile {
+	if !isServerURL(url) {
+		return nil
+	}
+	servers, err := globalServers()
+	if err != nil {
+		return nil
+	}
+	for _, server := range servers {
+		if server.Matches(url) {
+			return server
+		}
+	}
+	return nil
+}
+
+// expandHome replaces a leading ~/ with the home directory
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// HandleServer handles the server command
+func HandleServer(args []string) {
+	if len(args) == 0 {
+		fmt.Println(serverUsage)
+		os.Exit(1)
+	}
+	var err error
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		err = listServers()
+	case args[0] == "add" && len(args) >= 3:
+		err = addServer(args[1], args[2], args[3:])
+	case args[0] == "remove" && len(args) == 2:
+		err = removeServer(args[1])
+	default:
+		fmt.Println(serverUsage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// listServers prints the server profiles, marking the one the origin
+// remote uses
+func listServers() error {
+	servers, err := globalServers()
+	if err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		fmt.Println("No servers (add one with mgit server add <name> <url>)")
+		return nil
+	}
+	active := serverForURL(originURL())
+	for _, server := range servers {
+		marker := " "
+		if active != nil && active.Name == server.Name {
+			marker = "*"
+		}
+		fmt.Printf("%s %-12s %s\n", marker, server.Name, orNone(server.URL))
+		for _, pattern := range server.Match {
+			fmt.Printf("    match:     %s\n", pattern)
+		}
+		if server.CAFile != "" {
+			fmt.Printf("    ca file:   %s\n", server.CAFile)
+		}
+		if server.CertFile != "" {
+			fmt.Printf("    cert file: %s (key %s)\n", server.CertFile, orNone(server.KeyFile))
+		}
+		if server.Insecure {
+			fmt.Println("    insecure:  TLS certificates are not verified")
+		}
+	}
+	return nil
+}
+
+// addServer creates a server profile, or updates an existing one with the
+// settings given
+func addServer(name, url string, args []string) error {
+	if !keyNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid server name '%s' (use letters, digits, '.', '_' and '-')", name)
+	}
+	url = strings.TrimSuffix(url, "/")
+	if !isServerURL(url) {
+		return fmt.Errorf("%s is not an http(s) URL", url)
+	}
+	values := map[string]string{"url": url}
+	match := []string{}
+	token := ""
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--insecure" {
+			values["insecure"] = "true"
+			continue
+		}
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s", serverUsage)
+		}
+		switch args[i] {
+		case "--match":
+			match = append(match, args[i+1])
+		case "--ca-file", "--cert-file", "--key-file":
+			path, err := filepath.Abs(expandHome(args[i+1]))
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("%s: %w", args[i], err)
+			}
+			key := map[string]string{"--ca-file": "caFile", "--cert-file": "certFile", "--key-file": "keyFile"}[args[i]]
+			values[key] = path
+		case "--token":
+			token = args[i+1]
+		default:
+			return fmt.Errorf("%s", serverUsage)
+		}
+		i++
+	}
+	if (values["certFile"] == "") != (values["keyFile"] == "") {
+		return fmt.Errorf("--cert-file and --key-file go together")
+	}
+	settings := &HTTPSettings{CAFile: values["caFile"], CertFile: values["certFile"], KeyFile: values["keyFile"], From: fmt.Sprintf("server '%s'", name)}
+	if _, err := settings.tlsConfig(); err != nil {
+		return err
+	}
+
+	created := false
+	err := UpdateConfig(GetConfigFilePath(true), func(config *Config) error {
+		section := serverSection(name)
+		if _, exists := config.Sections[section]; !exists {
+			created = true
+		}
+		for key, value := range values {
+			config.Set(section, key, value)
+		}
+		existing := config.GetAll(section, "match")
+		for _, pattern := range match {
+			if !containsString(existing, pattern) {
+				config.Add(section, "match", pattern)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		if err := saveServerToken(name, url, token); err != nil {
+			return fmt.Errorf("error saving token: %w", err)
+		}
+	}
+
+	if created {
+		fmt.Printf("Added server '%s' (%s)\n", name, url)
+	} else {
+		fmt.Printf("Updated server '%s' (%s)\n", name, url)
+	}
+	if token != "" {
+		fmt.Printf("Token saved to %s\n", getTokenConfigPath())
+	}
+	return nil
+}
+
+// removeServer deletes a server profile and any token saved for it
+func removeServer(name string) error {
+	err := UpdateConfig(GetConfigFilePath(true), func(config *Config) error {
+		section := serverSection(name)
+		if _, exists := config.Sections[section]; !exists {
+			return fmt.Errorf("no server named '%s'", name)
+		}
+		delete(config.Sections, section)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(getTokenConfigPath()); err == nil {
+		store, err := loadTokenStore()
+		if err != nil {
+			return err
+		}
+		kept := []AuthToken{}
+		for _, token := range store.Tokens {
+			if token.Server != name {
+				kept = append(kept, token)
+			}
+		}
+		if len(kept) != len(store.Tokens) {
+			store.Tokens = kept
+			if err := store.save(); err != nil {
+				return fmt.Errorf("error saving token file: %w", err)
+			}
+		}
+	}
+	fmt.Printf("Removed server '%s'\n", name)
+	return nil
+}
+
+// saveServerToken stores the token used for a server's repositories,
+// replacing any it had
+func saveServerToken(name, url, token string) error {
+	store, err := loadTokenStore()
+	if err != nil {
+		return err
+	}
+	tokens := []AuthToken{{Token: token, RepoURL: url, Server: name}}
+	for _, t := range store.Tokens {
+		if t.Server != name {
+			tokens = append(tokens, t)
+		}
+	}
+	store.Tokens = tokens
+	return store.save()
+}
+
+// serverToken returns the token saved for the server a repository URL
+// uses, or nil
+func serverToken(store *TokenStore, repoURL string) *AuthToken {
+	server := serverForURL(repoURL)
+	if server == nil {
+		return nil
+	}
+	for i := range store.Tokens {
+		if store.Tokens[i].Server == server.Name {
+			return &store.Tokens[i]
+		}
+	}
+	return nil
+}
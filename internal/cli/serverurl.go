@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// A repository on an mgit server is named by a URL in one of two forms:
+//
+//	https://host[:port][/prefix]/<id>                        direct
+//	https://host[:port][/prefix]/api/mgit/repos/<id>[/...]   API, as git remotes use
+//
+// Both are parsed into the server's base URL and the repository ID, so the
+// endpoints built from them and the token store's matching agree however
+// the URL was written: with a port, a path prefix, a trailing slash or .git,
+// a query string or fragment, user info, or a different case of scheme and
+// host. A server profile whose URL the repository URL falls under sets the
+// base URL, for servers whose prefix is more than one path segment.
+
+// apiReposPath is the path under a server's base URL holding its repositories
+const apiReposPath = "/api/mgit/repos/"
+
+// ServerURL is a repository URL on an mgit server, normalized
+type ServerURL struct {
+	Base   string // scheme://host[:port][/prefix], without a trailing slash
+	RepoID string
+}
+
+// parseServerURL splits a repository URL into its server's base URL and the
+// repository ID
+func parseServerURL(raw string) (*ServerURL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s': %w", raw, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if (scheme != "http" && scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("'%s' is not an http(s) URL", raw)
+	}
+	host := strings.ToLower(u.Host)
+	if port := u.Port(); (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	path := strings.TrimRight(u.Path, "/")
+	path = strings.TrimRight(strings.TrimSuffix(path, ".git"), "/")
+	origin := scheme + "://" + host
+
+	// The API form names the repository right after the repos path,
+	// whatever endpoint follows it
+	if idx := strings.Index(path+"/", apiReposPath); idx != -1 {
+		rest := strings.SplitN((path + "/")[idx+len(apiReposPath):], "/", 2)[0]
+		rest = strings.TrimSuffix(rest, ".git")
+		if rest == "" {
+			return nil, fmt.Errorf("'%s' names no repository", raw)
+		}
+		return &ServerURL{Base: origin + path[:idx], RepoID: rest}, nil
+	}
+
+	slash := strings.LastIndex(path, "/")
+	repoID := path[slash+1:]
+	if repoID == "" {
+		return nil, fmt.Errorf("'%s' names no repository", raw)
+	}
+	base := origin + path[:slash]
+	if server := serverForURL(origin + path); server != nil && server.URL != "" {
+		if profile, err := url.Parse(server.URL); err == nil && strings.HasPrefix(path, strings.TrimRight(profile.Path, "/")+"/") {
+			base = origin + strings.TrimRight(profile.Path, "/")
+		}
+	}
+	return &ServerURL{Base: base, RepoID: repoID}, nil
+}
+
+// Endpoint returns the URL of one of the repository's API endpoints, such
+// as "/metadata", or of the repository itself for ""
+func (s *ServerURL) Endpoint(path string) string {
+	return s.Base + apiReposPath + url.PathEscape(s.RepoID) + path
+}
+
+// sameRepoURL reports whether two URLs name the same repository on the
+// same server. A stored URL that is not an http(s) URL, as older token
+// files may hold, is compared by repository ID alone.
+func sameRepoURL(storedURL, repoURL string) bool {
+	repo, err := parseServerURL(repoURL)
+	if err != nil {
+		return strings.TrimRight(storedURL, "/") == strings.TrimRight(repoURL, "/")
+	}
+	stored, err := parseServerURL(storedURL)
+	if err != nil {
+		id := strings.TrimSuffix(strings.Trim(storedURL, "/"), ".git")
+		return id != "" && id == repo.RepoID
+	}
+	return *stored == *repo
+}
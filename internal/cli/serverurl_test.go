@@ -0,0 +1,104 @@
+package cli
+
+import "testing"
+
+// isolateConfig keeps server profiles in the user's global config from
+// changing how URLs are parsed
+func isolateConfig(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("MGIT_CONFIG_SYSTEM", "/nonexistent")
+}
+
+func TestParseServerURL(t *testing.T) {
+	isolateConfig(t)
+	tests := []struct {
+		url, base, repoID string
+	}{
+		{"https://mgit.example/hello", "https://mgit.example", "hello"},
+		{"https://mgit.example/hello/", "https://mgit.example", "hello"},
+		{"https://mgit.example/hello.git", "https://mgit.example", "hello"},
+		{"HTTPS://MGit.Example:443/hello", "https://mgit.example", "hello"},
+		{"http://mgit.example:80/hello", "http://mgit.example", "hello"},
+		{"http://localhost:3003/hello?ref=main#top", "http://localhost:3003", "hello"},
+		{"https://user@mgit.example/team/git/hello", "https://mgit.example/team/git", "hello"},
+		{"http://localhost:3003/api/mgit/repos/hello", "http://localhost:3003", "hello"},
+		{"http://localhost:3003/api/mgit/repos/hello/metadata", "http://localhost:3003", "hello"},
+		{"https://mgit.example/prefix/api/mgit/repos/hello.git/info/refs?service=git-upload-pack", "https://mgit.example/prefix", "hello"},
+	}
+	for _, tt := range tests {
+		parsed, err := parseServerURL(tt.url)
+		if err != nil {
+			t.Errorf("parseServerURL(%q): %s", tt.url, err)
+			continue
+		}
+		if parsed.Base != tt.base || parsed.RepoID != tt.repoID {
+			t.Errorf("parseServerURL(%q) = %s and %s, want %s and %s", tt.url, parsed.Base, parsed.RepoID, tt.base, tt.repoID)
+		}
+	}
+}
+
+func TestParseServerURLRejects(t *testing.T) {
+	isolateConfig(t)
+	for _, url := range []string{
+		"https://mgit.example",
+		"https://mgit.example/",
+		"https://mgit.example/api/mgit/repos/",
+		"ssh://mgit.example/hello",
+		"/srv/hello",
+		"hello",
+	} {
+		if parsed, err := parseServerURL(url); err == nil {
+			t.Errorf("parseServerURL(%q) = %+v, want an error", url, parsed)
+		}
+	}
+}
+
+func TestServerURLEndpoint(t *testing.T) {
+	isolateConfig(t)
+	tests := []struct {
+		url, path, want string
+	}{
+		{"https://mgit.example/hello", "", "https://mgit.example/api/mgit/repos/hello"},
+		{"https://mgit.example/hello.git", "/metadata", "https://mgit.example/api/mgit/repos/hello/metadata"},
+		{"http://localhost:3003/api/mgit/repos/hello/metadata", "/info", "http://localhost:3003/api/mgit/repos/hello/info"},
+		{"https://mgit.example/team/git/hello", "/access", "https://mgit.example/team/git/api/mgit/repos/hello/access"},
+		{"https://mgit.example/my%20repo", "/info", "https://mgit.example/api/mgit/repos/my%20repo/info"},
+	}
+	for _, tt := range tests {
+		parsed, err := parseServerURL(tt.url)
+		if err != nil {
+			t.Errorf("parseServerURL(%q): %s", tt.url, err)
+			continue
+		}
+		if got := parsed.Endpoint(tt.path); got != tt.want {
+			t.Errorf("%q.Endpoint(%q) = %s, want %s", tt.url, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSameRepoURL(t *testing.T) {
+	isolateConfig(t)
+	tests := []struct {
+		stored, repo string
+		same         bool
+	}{
+		{"http://localhost:3003/api/mgit/repos/hello", "http://localhost:3003/hello", true},
+		{"http://LOCALHOST:3003/hello/", "http://localhost:3003/hello.git", true},
+		{"https://mgit.example:443/hello", "https://mgit.example/hello", true},
+		{"hello", "https://mgit.example/hello", true},
+		{"/hello.git/", "https://mgit.example/hello", true},
+		{"http://localhost:3003/hello", "http://localhost:3004/hello", false},
+		{"https://a.example/hello", "https://b.example/hello", false},
+		{"https://mgit.example/hello", "https://mgit.example/hello-world", false},
+		{"http://mgit.example/hello", "https://mgit.example/hello", false},
+		{"other", "https://mgit.example/hello", false},
+		{"not a url/", "not a url", true},
+	}
+	for _, tt := range tests {
+		if got := sameRepoURL(tt.stored, tt.repo); got != tt.same {
+			t.Errorf("sameRepoURL(%q, %q) = %v, want %v", tt.stored, tt.repo, got, tt.same)
+		}
+	}
+}
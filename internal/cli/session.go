@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// Session is the state one mgit process shares between commands and
+// helpers for a working directory. The git repository is opened lazily, once,
+// and the same handle is handed to every caller instead of each helper
+// re-opening it (and re-reading config and packed-refs).
+type Session struct {
+	Path string
+
+	once sync.Once
+	repo *git.Repository
+	err  error
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]*Session{}
+)
+
+// SessionFor returns the shared session for the repository at path
+func SessionFor(path string) *Session {
+	path = filepath.Clean(path)
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	session, ok := sessions[path]
+	if !ok {
+		session = &Session{Path: path}
+		sessions[path] = session
+	}
+	return session
+}
+
+// currentSession returns the session for the current working directory
+func currentSession() *Session {
+	return SessionFor(".")
+}
+
+// Repo opens the git repository on first use and returns the shared handle.
+// It is safe to call from multiple goroutines.
+func (s *Session) Repo() (*git.Repository, error) {
+	s.once.Do(func() {
+		s.repo, s.err = git.PlainOpen(s.Path)
+	})
+	return s.repo, s.err
+}
+
+// MustRepo returns the repository, exiting if it cannot be opened
+func (s *Session) MustRepo() *git.Repository {
+	repo, err := s.Repo()
+	if err != nil {
+		fmt.Printf("Error opening repository: %s\n", err)
+		os.Exit(1)
+	}
+	return repo
+}
+
+// Storage returns the MGit store of the session's repository
+func (s *Session) Storage() *MGitStorage {
+	return &MGitStorage{RootDir: filepath.Join(s.Path, ".mgit")}
+}
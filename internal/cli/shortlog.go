@@ -0,0 +1,199 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const shortlogUsage = "Usage: mgit shortlog [--json] [<rev> | <rev>..<rev>]"
+
+// ContributorStats is what one identity contributed: commits under a nostr
+// pubkey, or under an email for commits without one
+type ContributorStats struct {
+	Pubkey     string    `json:"pubkey,omitempty"`
+	Email      string    `json:"email,omitempty"` // Set when grouped by email
+	Names      []string  `json:"names"`
+	Emails     []string  `json:"emails"`
+	Commits    int       `json:"commits"`
+	Insertions int       `json:"insertions"`
+	Deletions  int       `json:"deletions"`
+	First      time.Time `json:"first"`
+	Last       time.Time `json:"last"`
+}
+
+// HandleShortlog handles the shortlog command
+func HandleShortlog(args []string) {
+	asJSON := false
+	rangeSpec := ""
+	for _, arg := range args {
+		switch {
+		case arg == "--json":
+			asJSON = true
+		case strings.HasPrefix(arg, "-") || rangeSpec != "":
+			fmt.Println(shortlogUsage)
+			os.Exit(1)
+		default:
+			rangeSpec = arg
+		}
+	}
+
+	session := currentSession()
+	repo := session.MustRepo()
+	stats, err := contributorStats(session, repo, rangeSpec)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(stats); err != nil {
+			fmt.Printf("Error encoding statistics: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, contributor := range stats {
+		names := strings.Join(contributor.Names, ", ")
+		if contributor.Pubkey != "" {
+			fmt.Printf("%s (%s)\n", contributor.Pubkey, names)
+			fmt.Printf("    emails:  %s\n", strings.Join(contributor.Emails, ", "))
+		} else {
+			fmt.Printf("%s <%s> (no pubkey)\n", names, contributor.Email)
+		}
+		fmt.Printf("    commits: %d, +%d -%d lines, %s .. %s\n", contributor.Commits,
+			contributor.Insertions, contributor.Deletions,
+			contributor.First.Format("2006-01-02"), contributor.Last.Format("2006-01-02"))
+	}
+}
+
+// contributorStats aggregates the commits in rangeSpec (HEAD when empty) by
+// pubkey, falling back to email, most commits first. Names and emails go
+// through the identity map. Line counts leave out
+// merges, as git log --numstat does.
+func contributorStats(session *Session, repo *git.Repository, rangeSpec string) ([]*ContributorStats, error) {
+	tips := []plumbing.Hash{}
+	if head, err := repo.Head(); err == nil {
+		tips = append(tips, head.Hash())
+	}
+	include, excluded, err := commitRange(repo, rangeSpec, tips)
+	if err != nil {
+		return nil, err
+	}
+	commits := []*object.Commit{}
+	walkCommits(repo, include, excluded, func(c *object.Commit) {
+		commits = append(commits, c)
+	})
+
+	mappings, err := session.Storage().GetMappings()
+	if err != nil {
+		return nil, err
+	}
+	pubkeys := make(map[string]string, len(mappings))
+	for _, mapping := range mappings {
+		pubkeys[mapping.GitHash] = mapping.Pubkey
+	}
+
+	// Diffing each commit is the slow part, so it runs on the worker pool
+	insertions := make([]int, len(commits))
+	deletions := make([]int, len(commits))
+	failed := make([]error, len(commits))
+	repos := newWorkerRepos(session.Path, repo)
+	runJobs(len(commits), func(worker, i int) {
+		if len(commits[i].ParentHashes) > 1 {
+			return
+		}
+		workerRepo, err := repos.get(worker)
+		if err != nil {
+			failed[i] = err
+			return
+		}
+		commit, err := workerRepo.CommitObject(commits[i].Hash)
+		if err != nil {
+			failed[i] = err
+			return
+		}
+		stats, err := commit.Stats()
+		if err != nil {
+			failed[i] = fmt.Errorf("error diffing commit %s: %w", shortHash(commit.Hash.String()), err)
+			return
+		}
+		for _, file := range stats {
+			insertions[i] += file.Addition
+			deletions[i] += file.Deletion
+		}
+	})
+
+	identities := currentIdentityMap()
+	byIdentity := map[string]*ContributorStats{}
+	for i, commit := range commits {
+		if failed[i] != nil {
+			return nil, failed[i]
+		}
+		// The recorded pubkey is what the MGit hash covers; the identity map
+		// only fills in for commits without one
+		identity := identities.Resolve(commit.Author.Name, commit.Author.Email)
+		email := strings.ToLower(identity.Email)
+		pubkey := pubkeys[commit.Hash.String()]
+		if pubkey == "" {
+			pubkey = identity.Pubkey
+		}
+		key := "pubkey:" + pubkey
+		if pubkey == "" {
+			key = "email:" + email
+		}
+		contributor, ok := byIdentity[key]
+		if !ok {
+			contributor = &ContributorStats{Pubkey: pubkey, First: commit.Author.When, Last: commit.Author.When}
+			if pubkey == "" {
+				contributor.Email = email
+			}
+			byIdentity[key] = contributor
+		}
+		contributor.Names = appendUnique(contributor.Names, identity.Name)
+		contributor.Emails = appendUnique(contributor.Emails, email)
+		contributor.Commits++
+		contributor.Insertions += insertions[i]
+		contributor.Deletions += deletions[i]
+		if commit.Author.When.Before(contributor.First) {
+			contributor.First = commit.Author.When
+		}
+		if commit.Author.When.After(contributor.Last) {
+			contributor.Last = commit.Author.When
+		}
+	}
+
+	stats := make([]*ContributorStats, 0, len(byIdentity))
+	for _, contributor := range byIdentity {
+		sort.Strings(contributor.Names)
+		sort.Strings(contributor.Emails)
+		stats = append(stats, contributor)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Commits != stats[j].Commits {
+			return stats[i].Commits > stats[j].Commits
+		}
+		return stats[i].Pubkey+stats[i].Email < stats[j].Pubkey+stats[j].Email
+	})
+	return stats, nil
+}
+
+// appendUnique appends value to list unless it is already there
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
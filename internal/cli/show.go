@@ -1,7 +1,10 @@
-package main
+package cli
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -44,189 +47,250 @@ func HandleShow(args []string) {
 
 // HandleMGitShow handles the mgit show command, showing a specific MGit commit
 func HandleMGitShow(args []string) {
+	// --no-resolve may come anywhere
+	filtered := []string{}
+	for _, arg := range args {
+			if arg == "--no-resolve" {
+					nip05Disabled = true
+			} else {
+					filtered = append(filtered, arg)
+			}
+	}
+	args = filtered
+
 	if len(args) < 1 {
-			fmt.Println("Usage: mgit show <hash>")
+			fmt.Println("Usage: mgit show [--no-resolve] <hash>")
+			fmt.Println("       mgit show [--no-resolve] --batch < hashes")
+			fmt.Println("       mgit show --links <hash>")
 			os.Exit(1)
 	}
 
-	hash := args[0]
 	storage := NewMGitStorage()
+	repo := getRepo()
+	requireNoHeadDrift(repo, storage)
 
+	if args[0] == "--batch" {
+			showBatch(os.Stdin, os.Stdout, repo, storage)
+			return
+	}
+
+	if args[0] == "--links" {
+			if len(args) != 2 {
+					fmt.Println("Usage: mgit show --links <hash>")
+					os.Exit(1)
+			}
+			mgitHash, err := resolveMGitCommitHash(repo, storage, args[1])
+			if err != nil {
+					fmt.Printf("Error: %s\n", err)
+					os.Exit(1)
+			}
+			if err := writeCommitLinks(os.Stdout, storage, mgitHash); err != nil {
+					fmt.Printf("Error reading links: %s\n", err)
+					os.Exit(1)
+			}
+			return
+	}
+
+	if _, err := showMGitRecord(os.Stdout, repo, storage, args[0]); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+	}
+}
+
+// showBatch reads one hash (git or MGit) per line from in and writes a record
+// for each to out, in the style of git cat-file --batch:
+//
+//	<input> <hash> <size>
+//	<size bytes of show output>
+//
+// or "<input> missing" when the hash cannot be resolved.
+func showBatch(in io.Reader, out io.Writer, repo *git.Repository, storage *MGitStorage) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+			rev := strings.TrimSpace(scanner.Text())
+			if rev == "" {
+					continue
+			}
+
+			var record bytes.Buffer
+			hash, err := showMGitRecord(&record, repo, storage, rev)
+			if err != nil {
+					fmt.Fprintf(out, "%s missing\n", rev)
+					continue
+			}
+
+			fmt.Fprintf(out, "%s %s %d\n", rev, hash, record.Len())
+			out.Write(record.Bytes())
+			fmt.Fprintln(out)
+	}
+	if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading hashes: %s\n", err)
+			os.Exit(1)
+	}
+}
+
+// showMGitRecord writes the show output for a single hash to w and returns
+// the full hash it was rendered for, the MGit hash when one is known. MGit
+// hashes found in the local object store are shown with their MGit details;
+// anything else is resolved through resolveRevision and shown as a git commit.
+func showMGitRecord(w io.Writer, repo *git.Repository, storage *MGitStorage, hash string) (string, error) {
 	// Get the MGit commit
 	mgitCommit, err := storage.GetCommit(hash)
 	if err != nil {
-			fmt.Printf("Error: %s\n", err)
-			os.Exit(1)
+			// Not in the local object store - fall back to resolving through the
+			// mappings (and the server, if resolve.remoteFallback is enabled)
+			gitHash, resolveErr := resolveRevision(repo, hash)
+			if resolveErr != nil {
+					// Only a hash is looked for in the object store; for other
+					// revisions, such as HEAD~2, the resolve error says more
+					if !isHexString(hash) {
+							return "", resolveErr
+					}
+					return "", err
+			}
+			gitCommit, resolveErr := repo.CommitObject(gitHash)
+			if resolveErr != nil {
+					return "", fmt.Errorf("error getting Git commit: %w", resolveErr)
+			}
+			writeCommit(w, gitCommit)
+			shown := gitCommit.Hash.String()
+			if mgitHash := GetMGitHashForCommit(gitCommit.Hash); mgitHash != "" {
+					writeCommitNotes(w, storage, mgitHash)
+					shown = mgitHash
+			}
+			writeCommitDiff(w, repo, gitCommit)
+			return shown, nil
 	}
 
 	// Print the MGit commit details
-	printMGitCommit(mgitCommit)
+	writeMGitCommit(w, mgitCommit)
+	writeCommitNotes(w, storage, mgitCommit.MGitHash)
 
 	// Show parent information
 	if len(mgitCommit.ParentHashes) > 0 {
-			fmt.Println("Parents:")
+			fmt.Fprintln(w, "Parents:")
 			for _, parent := range mgitCommit.ParentHashes {
-					fmt.Printf("  %s\n", parent)
+					fmt.Fprintf(w, "  %s\n", parent)
 			}
-			fmt.Println()
+			fmt.Fprintln(w)
 	}
 
 	// Get the corresponding Git hash
 	gitHash := mgitCommit.GitHash
 	if gitHash == "" {
-			fmt.Println("No Git hash found for this MGit commit")
-			return
+			fmt.Fprintln(w, "No Git hash found for this MGit commit")
+			return mgitCommit.MGitHash, nil
 	}
 
-	// Get repository
-	repo := getRepo()
-
 	// Get the Git commit object
 	gitCommitHash := plumbing.NewHash(gitHash)
 	gitCommit, err := repo.CommitObject(gitCommitHash)
 	if err != nil {
-			fmt.Printf("Error getting Git commit: %s\n", err)
-			return
+			fmt.Fprintf(w, "Error getting Git commit: %s\n", err)
+			return mgitCommit.MGitHash, nil
 	}
 
 	// Show the diff using the existing function
-	showCommitDiff(repo, gitCommit)
+	writeCommitDiff(w, repo, gitCommit)
+	return mgitCommit.MGitHash, nil
 }
 
 // resolveRevision resolves a revision (branch, tag, commit hash) to a commit hash
 func resolveRevision(repo *git.Repository, rev string) (plumbing.Hash, error) {
-	// If it's HEAD, resolve it
-	if rev == "HEAD" {
-			ref, err := repo.Head()
-			if err == nil {
-					return ref.Hash(), nil
-			}
-	}
+	return resolveGitCommit(repo, NewMGitStorage(), rev)
+}
 
-	// Try to resolve as a reference (branch, tag)
-	ref, err := repo.Reference(plumbing.ReferenceName(rev), true)
-	if err == nil {
-			return ref.Hash(), nil
+// resolveRemoteMapping looks up an MGit hash (or prefix) in the mappings held
+// by the origin server, subject to the trust policy, and records any accepted
+// match locally for next time
+func resolveRemoteMapping(repo *git.Repository, rev string) (plumbing.Hash, error) {
+	if len(rev) < 4 {
+		return plumbing.ZeroHash, fmt.Errorf("hash prefix too short")
 	}
 
-	// Try with refs/heads/ prefix
-	ref, err = repo.Reference(plumbing.ReferenceName("refs/heads/"+rev), true)
-	if err == nil {
-			return ref.Hash(), nil
+	// The trust policy decides between the server and any other configured
+	// sources; enabling the fallback always brings the server into it
+	policy, err := LoadTrustPolicy()
+	if err != nil {
+		return plumbing.ZeroHash, err
 	}
-
-	// Try with refs/tags/ prefix
-	ref, err = repo.Reference(plumbing.ReferenceName("refs/tags/"+rev), true)
-	if err == nil {
-			return ref.Hash(), nil
+	if !policy.HasSource("server") {
+		policy.Sources = append(policy.Sources, &serverMappingSource{})
 	}
 
-	// If it's a full 40-char hash
-	if len(rev) == 40 && plumbing.IsHash(rev) {
-			hash := plumbing.NewHash(rev)
-			_, err := repo.CommitObject(hash)
-			if err == nil {
-					return hash, nil
-			}
+	decision := policy.Decide(rev)
+	if decision.Accepted == nil {
+		return plumbing.ZeroHash, fmt.Errorf("%s (see 'mgit trust explain %s')", decision.Reason, rev)
 	}
+	match := decision.Accepted
 
-	// If it's a partial hash, try to find a matching commit
-	if len(rev) >= 4 && len(rev) < 40 {
-			// List all commits and find a match
-			iter, err := repo.CommitObjects()
-			if err != nil {
-					return plumbing.ZeroHash, fmt.Errorf("error listing commits: %s", err)
-			}
-			defer iter.Close()
-
-			var matchingHash plumbing.Hash
-			matchCount := 0
-
-			err = iter.ForEach(func(c *object.Commit) error {
-					if strings.HasPrefix(c.Hash.String(), rev) {
-							matchingHash = c.Hash
-							matchCount++
-					}
-					return nil
-			})
-
-			if err != nil {
-					return plumbing.ZeroHash, fmt.Errorf("error searching commits: %s", err)
-			}
+	hash := plumbing.NewHash(match.GitHash)
+	if _, err := repo.CommitObject(hash); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("MGit %s maps to git commit %s, which is not present locally (try 'mgit pull')",
+			shortHash(match.MGitHash), shortHash(match.GitHash))
+	}
 
-			if matchCount == 1 {
-					return matchingHash, nil
-			} else if matchCount > 1 {
-					return plumbing.ZeroHash, fmt.Errorf("ambiguous commit hash prefix: %s", rev)
-			}
+	storage := NewMGitStorage()
+	if err := storage.StoreMapping(match.GitHash, match.MGitHash, match.Pubkey, decision.Source); err != nil {
+		fmt.Printf("Warning: Failed to record remote mapping locally: %s\n", err)
 	}
 
-	// Check nostr mappings for MGit hashes
-	if pubkey := GetNostrPubKey(); pubkey != "" {
-			// Read all mappings and search for matches
-			mappings := getAllNostrMappings()
-			if len(mappings) > 0 {
-				// fmt.Printf("Checking %d nostr mappings for hash '%s'\n", len(mappings), rev)
-
-				for _, mapping := range mappings {
-					// fmt.Printf("Comparing with: Git=%s, MGit=%s\n", mapping.GitHash, mapping.MGitHash)
-					
-					// Check for exact MGitHash match
-					if mapping.MGitHash == rev {
-							// fmt.Printf("Found mapping: MGit=%s -> Git=%s\n", rev, mapping.GitHash)
-							return plumbing.NewHash(mapping.GitHash), nil
-					}
-					
-					// Check for prefix match if it's a partial hash
-					if len(rev) >= 4 && len(rev) < 40 && strings.HasPrefix(mapping.MGitHash, rev) {
-							// fmt.Printf("Found mapping for partial hash: MGit=%s -> Git=%s\n", mapping.MGitHash, mapping.GitHash)
-							return plumbing.NewHash(mapping.GitHash), nil
-					}
-				}
-			}
-			fmt.Printf("No matching MGit hash found in mappings\n")
-	} else { fmt.Printf("no nostr pubkey!") }
-	return plumbing.ZeroHash, fmt.Errorf("revision not found")
+	return hash, nil
 }
 
 // displayCommit shows formatted commit information
 func displayCommit(commit *object.Commit) {
+	writeCommit(os.Stdout, commit)
+}
+
+// writeCommit writes formatted commit information to w
+func writeCommit(w io.Writer, commit *object.Commit) {
 	// Get the MGit hash for this commit
 	mgitHash := GetMGitHashForCommit(commit.Hash)
 	
 	// If we have an MGit hash, display that
 	if mgitHash != "" {
-			fmt.Printf("commit %s\n", mgitHash)
+			fmt.Fprintf(w, "commit %s\n", mgitHash)
 	} else {
 			// Otherwise fall back to the Git hash
-			fmt.Printf("commit %s\n", commit.Hash.String())
+			fmt.Fprintf(w, "commit %s\n", commit.Hash.String())
 	}
 	
-	// Get the nostr pubkey for this commit
+	// Get the nostr pubkey for this commit, or the one the identity map
+	// gives its author
+	author := currentIdentityMap().Resolve(commit.Author.Name, commit.Author.Email)
 	pubkey := GetCommitNostrPubkey(commit.Hash)
+	if pubkey == "" {
+			pubkey = author.Pubkey
+	}
 	
 	// Display author with pubkey in the format requested
 	if pubkey != "" {
-			fmt.Printf("Author: %s <%s> <%s>\n", commit.Author.Name, commit.Author.Email, pubkey)
+			fmt.Fprintf(w, "Author: %s <%s> <%s>\n", author.Name, author.Email, displayPubkey(pubkey))
 	} else {
-			fmt.Printf("Author: %s <%s>\n", commit.Author.Name, commit.Author.Email)
+			fmt.Fprintf(w, "Author: %s <%s>\n", author.Name, author.Email)
 	}
 	
-	fmt.Printf("Date:   %s\n\n", commit.Author.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	fmt.Fprintf(w, "Date:   %s\n\n", commit.Author.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
 
 	// Print the commit message with indentation
 	for _, line := range strings.Split(commit.Message, "\n") {
-			fmt.Printf("    %s\n", line)
+			fmt.Fprintf(w, "    %s\n", line)
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 }
 
 // showCommitDiff shows the diff for a commit using git's diff command
 func showCommitDiff(repo *git.Repository, commit *object.Commit) {
+	writeCommitDiff(os.Stdout, repo, commit)
+}
+
+// writeCommitDiff writes the diff for a commit to w
+func writeCommitDiff(w io.Writer, repo *git.Repository, commit *object.Commit) {
 	// Get the repository path
 	wt, err := repo.Worktree()
 	if err != nil {
-			fmt.Printf("Error getting worktree: %s\n", err)
+			fmt.Fprintf(w, "Error getting worktree: %s\n", err)
 			return
 	}
 	repoPath := wt.Filesystem.Root()
@@ -244,9 +308,9 @@ func showCommitDiff(repo *git.Repository, commit *object.Commit) {
 	// Run the command and capture output
 	output, err := cmd.Output()
 	if err != nil {
-			fmt.Printf("Error executing git diff: %s\n", err)
+			fmt.Fprintf(w, "Error executing git diff: %s\n", err)
 			if exitErr, ok := err.(*exec.ExitError); ok {
-					fmt.Printf("git diff stderr: %s\n", string(exitErr.Stderr))
+					fmt.Fprintf(w, "git diff stderr: %s\n", string(exitErr.Stderr))
 			}
 			return
 	}
@@ -259,7 +323,7 @@ func showCommitDiff(repo *git.Repository, commit *object.Commit) {
 	}
 	
 	// Print the diff
-	fmt.Println(diffOutput)
+	fmt.Fprintln(w, diffOutput)
 }
 
 // displayFileDiff shows the diff for a single file change
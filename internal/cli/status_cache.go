@@ -0,0 +1,501 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// On a large working copy, mgit status spends its time looking at every
+// file: it hashes each tracked file and reads every directory for
+// untracked ones. With status.cache set to true, it keeps what it found in
+// .mgit/status-cache instead. A tracked file whose size, mtime and mode
+// match the index or the cache isn't read again, and a directory whose
+// mtime and .gitignore haven't changed keeps its cached listing, so only
+// the directories that changed are read.
+//
+// status.fsmonitor goes further: a file watcher tells status which paths
+// changed since the last run, and the others aren't even looked at.
+//
+//	status.fsmonitor = watchman   ask watchman, which must be installed
+//	status.fsmonitor = native     ask mgit fsmonitor, a watcher for this
+//	                              working copy (Linux only)
+//
+// A watcher that doesn't answer, such as mgit fsmonitor not running, means
+// looking at everything. mgit status --no-cache ignores the cache for one
+// run. The cache lives in .mgit rather than in git's index, whose untracked
+// cache and fsmonitor extensions go-git can't read.
+//
+// Files modified within a second of a status are not cached, since a
+// later change in the same mtime tick would go unnoticed.
+
+// statusCacheVersion is the format of the cache file
+const statusCacheVersion = 1
+
+// statusCacheDisabled is set by --no-cache
+var statusCacheDisabled bool
+
+// statusCache is what the last status found
+type statusCache struct {
+	Version int                         `json:"version"`
+	Token   string                      `json:"token,omitempty"`   // The file watcher's, as of the last status
+	Exclude string                      `json:"exclude,omitempty"` // .git/info/exclude's size and mtime
+	Files   map[string]cachedStatusFile `json:"files"`
+	Dirs    map[string]cachedStatusDir  `json:"dirs"`
+}
+
+// cachedStatusFile is a tracked file's stat data and content hash
+type cachedStatusFile struct {
+	Size  int64             `json:"size"`
+	MTime int64             `json:"mtime"`
+	Mode  filemode.FileMode `json:"mode"`
+	Hash  string            `json:"hash"`
+}
+
+// cachedStatusDir is a directory's files and subdirectories, ignored ones
+// left out
+type cachedStatusDir struct {
+	MTime  int64    `json:"mtime"`
+	Ignore string   `json:"ignore,omitempty"` // Its .gitignore's size and mtime
+	Files  []string `json:"files,omitempty"`
+	Dirs   []string `json:"dirs,omitempty"`
+}
+
+// statusScan is one status run through the cache
+type statusScan struct {
+	cache     *statusCache
+	files     map[string]cachedStatusFile // The cache as this run leaves it
+	dirs      map[string]cachedStatusDir
+	start     time.Time
+	tracked   map[string]bool
+	monitored bool            // Only changed paths need looking at
+	changed   map[string]bool // The paths the watcher reported
+	dirty     map[string]bool // Directories with entries added or removed
+	patterns  map[string][]gitignore.Pattern
+}
+
+// statusCachePath returns the file holding the status cache
+func (s *MGitStorage) statusCachePath() string {
+	return filepath.Join(s.RootDir, "status-cache")
+}
+
+// statusCacheEnabled reports whether status goes through the cache
+func statusCacheEnabled() bool {
+	return !statusCacheDisabled && GetConfigBool("status.cache", false)
+}
+
+// worktreeStatus returns the status of the working copy, through the
+// cache when status.cache is set
+func worktreeStatus(repo *git.Repository) (git.Status, error) {
+	if statusCacheEnabled() {
+		return cachedStatus(repo, currentSession().Storage())
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting worktree: %w", err)
+	}
+	return w.Status()
+}
+
+// cachedStatus computes the status with the help of the cache, and saves
+// the cache for next time
+func cachedStatus(repo *git.Repository, storage *MGitStorage) (git.Status, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("error reading index: %w", err)
+	}
+	scan := &statusScan{
+		cache:    readStatusCache(storage.statusCachePath()),
+		files:    map[string]cachedStatusFile{},
+		dirs:     map[string]cachedStatusDir{},
+		start:    time.Now(),
+		tracked:  map[string]bool{},
+		patterns: map[string][]gitignore.Pattern{},
+	}
+	scan.askMonitor()
+	if exclude := statSignature(filepath.Join(".git", "info", "exclude")); exclude != scan.cache.Exclude {
+		scan.cache.Dirs = map[string]cachedStatusDir{}
+		scan.cache.Exclude = exclude
+	}
+
+	status := git.Status{}
+	if err := stagedStatus(repo, idx, status); err != nil {
+		return nil, err
+	}
+	indexTime := time.Time{}
+	if info, err := os.Stat(filepath.Join(".git", "index")); err == nil {
+		indexTime = info.ModTime()
+	}
+	for _, entry := range idx.Entries {
+		scan.tracked[entry.Name] = true
+		if entry.Stage != 0 || entry.Mode == filemode.Submodule || entry.SkipWorktree {
+			continue
+		}
+		if change := scan.worktreeChange(entry, indexTime); change != git.Unmodified {
+			statusEntry(status, entry.Name).Worktree = change
+		}
+	}
+	scan.walk("", false, func(file string) {
+		status[file] = &git.FileStatus{Staging: git.Untracked, Worktree: git.Untracked}
+	})
+
+	scan.cache.Version = statusCacheVersion
+	scan.cache.Files, scan.cache.Dirs = scan.files, scan.dirs
+	if data, err := json.Marshal(scan.cache); err == nil {
+		// The cache only saves work; the next status can do without it
+		fsutil.WriteFile(storage.statusCachePath(), data)
+	}
+	return status, nil
+}
+
+// readStatusCache reads the cache; one that is missing, unreadable or of
+// another version reads as empty
+func readStatusCache(file string) *statusCache {
+	cache := &statusCache{}
+	if data, err := os.ReadFile(file); err == nil {
+		if json.Unmarshal(data, cache) != nil || cache.Version != statusCacheVersion {
+			cache = &statusCache{}
+		}
+	}
+	if cache.Files == nil {
+		cache.Files = map[string]cachedStatusFile{}
+	}
+	if cache.Dirs == nil {
+		cache.Dirs = map[string]cachedStatusDir{}
+	}
+	return cache
+}
+
+// statusEntry returns the status of a path, adding an unmodified one
+func statusEntry(status git.Status, file string) *git.FileStatus {
+	if _, ok := status[file]; !ok {
+		status[file] = &git.FileStatus{Staging: git.Unmodified, Worktree: git.Unmodified}
+	}
+	return status[file]
+}
+
+// stagedStatus adds the differences between HEAD and the index
+func stagedStatus(repo *git.Repository, idx *index.Index, status git.Status) error {
+	head := map[string]object.TreeEntry{}
+	if ref, err := repo.Head(); err == nil {
+		commit, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return fmt.Errorf("error reading HEAD commit: %w", err)
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return fmt.Errorf("error reading HEAD tree: %w", err)
+		}
+		walker := object.NewTreeWalker(tree, true, nil)
+		defer walker.Close()
+		for {
+			name, entry, err := walker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error reading HEAD tree: %w", err)
+			}
+			if entry.Mode != filemode.Dir {
+				head[name] = entry
+			}
+		}
+	}
+
+	inIndex := map[string]bool{}
+	for _, entry := range idx.Entries {
+		inIndex[entry.Name] = true
+		// go-git reads merged entries as stage 0, not index.Merged
+		if entry.Stage != 0 {
+			statusEntry(status, entry.Name).Staging = git.UpdatedButUnmerged
+			statusEntry(status, entry.Name).Worktree = git.UpdatedButUnmerged
+			continue
+		}
+		old, ok := head[entry.Name]
+		switch {
+		case !ok:
+			statusEntry(status, entry.Name).Staging = git.Added
+		case old.Hash != entry.Hash || old.Mode != entry.Mode:
+			statusEntry(status, entry.Name).Staging = git.Modified
+		}
+	}
+	for name := range head {
+		if !inIndex[name] {
+			statusEntry(status, name).Staging = git.Deleted
+		}
+	}
+	return nil
+}
+
+// askMonitor asks the file watcher, if one is configured, what changed
+// since the last status
+func (s *statusScan) askMonitor() {
+	var token string
+	var paths []string
+	var err error
+	switch monitor := GetConfigValue("status.fsmonitor", ""); strings.ToLower(monitor) {
+	case "", "false", "off", "none":
+		s.cache.Token = ""
+		return
+	case "native":
+		token, paths, err = queryNativeMonitor(s.cache.Token)
+	case "watchman":
+		token, paths, err = queryWatchman(s.cache.Token)
+	default:
+		warnConfigValue("status.fsmonitor", monitor, "no monitor")
+		s.cache.Token = ""
+		return
+	}
+	if err != nil {
+		if err != errFsmonitorNotRunning {
+			fmt.Printf("Warning: %s\n", err)
+		}
+		s.cache.Token = ""
+		return
+	}
+
+	s.cache.Token = token
+	s.changed = map[string]bool{}
+	s.dirty = map[string]bool{}
+	for _, changed := range paths {
+		if changed == "/" {
+			return
+		}
+		changed = strings.TrimSuffix(changed, "/")
+		s.changed[changed] = true
+		// Its directory gained or lost an entry, and if it is a
+		// directory itself, so may it have
+		s.dirty[parentDir(changed)] = true
+		s.dirty[changed] = true
+	}
+	s.monitored = true
+}
+
+// parentDir returns the directory holding a path, "" at the top
+func parentDir(file string) string {
+	if dir := path.Dir(file); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// changedSince reports whether the watcher saw a path, or a directory
+// holding it, change
+func (s *statusScan) changedSince(file string) bool {
+	for ; file != ""; file = parentDir(file) {
+		if s.changed[file] {
+			return true
+		}
+	}
+	return false
+}
+
+// worktreeChange reports how a tracked file differs from its index entry,
+// reading it only when its stat data doesn't tell
+func (s *statusScan) worktreeChange(entry *index.Entry, indexTime time.Time) git.StatusCode {
+	cached, ok := s.cache.Files[entry.Name]
+	if s.monitored && ok && !s.changedSince(entry.Name) {
+		s.files[entry.Name] = cached
+		return modifiedIf(cached.Hash != entry.Hash.String() || cached.Mode != entry.Mode)
+	}
+
+	info, err := os.Lstat(entry.Name)
+	if err != nil {
+		return git.Deleted
+	}
+	mode, err := filemode.NewFromOSFileMode(info.Mode())
+	if err != nil || mode == filemode.Dir {
+		return git.Modified
+	}
+	mtime := info.ModTime()
+
+	var hash string
+	switch {
+	case info.Size() == int64(entry.Size) && mtime.Equal(entry.ModifiedAt) && mtime.Before(indexTime) && mode == entry.Mode:
+		hash = entry.Hash.String()
+	case ok && cached.Size == info.Size() && cached.MTime == mtime.UnixNano() && cached.Mode == mode:
+		hash = cached.Hash
+	default:
+		computed, err := hashWorktreeFile(entry.Name, info)
+		if err != nil {
+			return git.Modified
+		}
+		hash = computed.String()
+	}
+	if !s.racy(mtime) {
+		s.files[entry.Name] = cachedStatusFile{Size: info.Size(), MTime: mtime.UnixNano(), Mode: mode, Hash: hash}
+	}
+	return modifiedIf(hash != entry.Hash.String() || mode != entry.Mode)
+}
+
+// modifiedIf returns Modified when changed is set, else Unmodified
+func modifiedIf(changed bool) git.StatusCode {
+	if changed {
+		return git.Modified
+	}
+	return git.Unmodified
+}
+
+// racy reports whether a file or directory changed so recently that
+// another change could leave its mtime as it is
+func (s *statusScan) racy(mtime time.Time) bool {
+	return !mtime.Before(s.start.Add(-time.Second))
+}
+
+// hashWorktreeFile computes the blob hash of a file, or of a symlink's
+// target
+func hashWorktreeFile(file string, info os.FileInfo) (plumbing.Hash, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(file)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return plumbing.ComputeHash(plumbing.BlobObject, []byte(target)), nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer f.Close()
+	hasher := plumbing.NewHasher(plumbing.BlobObject, info.Size())
+	if _, err := io.Copy(hasher, f); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return hasher.Sum(), nil
+}
+
+// walk lists the untracked files under a directory, reading only the
+// directories whose listing the cache doesn't have. ignoreChanged is set
+// when a .gitignore above it changed.
+func (s *statusScan) walk(dir string, ignoreChanged bool, untracked func(string)) {
+	cached, ok := s.cache.Dirs[dir]
+	if s.monitored {
+		ignoreChanged = ignoreChanged || s.changed[path.Join(dir, ".gitignore")]
+		ok = ok && !ignoreChanged && !s.dirty[dir]
+	} else {
+		ignoreChanged = ignoreChanged || statSignature(filepath.Join(dir, ".gitignore")) != cached.Ignore
+		ok = ok && !ignoreChanged
+		if ok {
+			info, err := os.Stat(filepath.Join(".", dir))
+			ok = err == nil && info.ModTime().UnixNano() == cached.MTime
+		}
+	}
+	if !ok {
+		var err error
+		if cached, err = s.readDir(dir); err != nil {
+			// Gone since its parent was listed
+			return
+		}
+	}
+	s.dirs[dir] = cached
+
+	for _, name := range cached.Files {
+		if file := path.Join(dir, name); !s.tracked[file] {
+			untracked(file)
+		}
+	}
+	for _, name := range cached.Dirs {
+		s.walk(path.Join(dir, name), ignoreChanged, untracked)
+	}
+}
+
+// readDir lists a directory's files and subdirectories, leaving out those
+// ignored and .git
+func (s *statusScan) readDir(dir string) (cachedStatusDir, error) {
+	info, err := os.Stat(filepath.Join(".", dir))
+	if err != nil {
+		return cachedStatusDir{}, err
+	}
+	entries, err := os.ReadDir(filepath.Join(".", dir))
+	if err != nil {
+		return cachedStatusDir{}, err
+	}
+
+	listing := cachedStatusDir{Ignore: statSignature(filepath.Join(dir, ".gitignore"))}
+	// A directory changed this recently is read again next time
+	if !s.racy(info.ModTime()) {
+		listing.MTime = info.ModTime().UnixNano()
+	}
+	matcher := gitignore.NewMatcher(s.ignorePatterns(dir))
+	parts := []string{}
+	if dir != "" {
+		parts = strings.Split(dir, "/")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if dir == "" && name == ".git" {
+			continue
+		}
+		isDir := entry.IsDir()
+		if !isDir && !entry.Type().IsRegular() && entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if matcher.Match(append(parts, name), isDir) {
+			continue
+		}
+		if isDir {
+			listing.Dirs = append(listing.Dirs, name)
+		} else {
+			listing.Files = append(listing.Files, name)
+		}
+	}
+	return listing, nil
+}
+
+// ignorePatterns returns the ignore patterns in effect in a directory:
+// .git/info/exclude, then the .gitignore files from the top down to it
+func (s *statusScan) ignorePatterns(dir string) []gitignore.Pattern {
+	if patterns, ok := s.patterns[dir]; ok {
+		return patterns
+	}
+	var patterns []gitignore.Pattern
+	domain := []string{}
+	if dir == "" {
+		patterns = readIgnorePatterns(filepath.Join(".git", "info", "exclude"), domain)
+	} else {
+		patterns = append(patterns, s.ignorePatterns(parentDir(dir))...)
+		domain = strings.Split(dir, "/")
+	}
+	patterns = append(patterns, readIgnorePatterns(filepath.Join(dir, ".gitignore"), domain)...)
+	s.patterns[dir] = patterns
+	return patterns
+}
+
+// readIgnorePatterns reads the patterns of an ignore file, if there is one
+func readIgnorePatterns(file string, domain []string) []gitignore.Pattern {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	patterns := []gitignore.Pattern{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, gitignore.ParsePattern(line, domain))
+		}
+	}
+	return patterns
+}
+
+// statSignature identifies a file's version by its size and mtime; "" when
+// it doesn't exist
+func statSignature(file string) string {
+	info, err := os.Stat(file)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+}
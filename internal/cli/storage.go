@@ -0,0 +1,832 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/mapping"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// MAnnotationStruct is a signed reference from an MGit commit to a document
+// held in an external system (a PACS study URL, a consent form hash, ...)
+type MAnnotationStruct struct {
+	Type       mgitlib.ObjectType `json:"type"`
+	Hash       string         `json:"hash"`
+	Target     string         `json:"target"` // MGit hash of the annotated commit
+	LinkType   string         `json:"link_type"` // imaging, lab or consent
+	URI        string         `json:"uri"`
+	Author     *mgitlib.Signature `json:"author"`
+	Signature  string         `json:"signature"`
+}
+
+// MGitStorage handles the storage and retrieval of MGit objects
+type MGitStorage struct {
+	RootDir string // Usually ".mgit"
+}
+
+// NewMGitStorage creates a new storage instance
+func NewMGitStorage() *MGitStorage {
+	return &MGitStorage{
+		RootDir: ".mgit",
+	}
+}
+
+// Initialize creates the necessary directory structure for MGit
+func (s *MGitStorage) Initialize() error {
+	// Create the main directory
+	if err := os.MkdirAll(s.RootDir, 0755); err != nil {
+		return fmt.Errorf("failed to create MGit directory: %w", err)
+	}
+	
+	// Create subdirectories
+	dirs := []string{
+		filepath.Join(s.RootDir, "objects"),  // For storing commit objects
+		filepath.Join(s.RootDir, "refs"),     // For storing branch refs
+		filepath.Join(s.RootDir, "refs/heads"), // For branch heads
+		filepath.Join(s.RootDir, "refs/tags"),  // For tags
+		filepath.Join(s.RootDir, "mappings"), // For storing hash mappings
+	}
+	
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	// Fold any legacy nostr_mappings.json into the canonical mappings file
+	if _, err := os.Stat(s.legacyMappingsPath()); err == nil {
+		if _, _, err := s.CompactMappings(); err != nil {
+			return fmt.Errorf("failed to migrate legacy mappings: %w", err)
+		}
+	}
+
+	// Create an initial HEAD file if it doesn't exist
+	headPath := filepath.Join(s.RootDir, "HEAD")
+	if _, err := os.Stat(headPath); os.IsNotExist(err) {
+		// Default to "ref: refs/heads/<init.defaultBranch>"
+		if err := s.writeRef("HEAD", "ref: refs/heads/"+defaultBranchName(), "init"); err != nil {
+			return fmt.Errorf("failed to create HEAD file: %w", err)
+		}
+	}
+	
+	return nil
+}
+
+// StoreCommit stores an MGit commit object
+func (s *MGitStorage) StoreCommit(commit *mgitlib.Commit) error {
+	// Ensure the hash is set
+	if commit.MGitHash == "" {
+		return fmt.Errorf("MGit hash cannot be empty")
+	}
+	
+	// Set the object type
+	commit.Type = mgitlib.CommitObject
+	
+	// Only store what can be verified later: the hash must follow from the
+	// commit's own fields
+	if hash := commit.ComputeHash(); hash != commit.MGitHash {
+		return fmt.Errorf("MGit hash %s does not match the commit's contents (format %d gives %s)",
+			commit.MGitHash, commit.Format, hash)
+	}
+	data := commit.Encode(true)
+	
+	if err := s.writeObject(commit.MGitHash, mgitlib.CommitObject, data); err != nil {
+		return fmt.Errorf("failed to write commit object: %w", err)
+	}
+	
+	return nil
+}
+
+// RecordCommit stores a new commit, its mapping to GitHash and moves refName
+// to it in one transaction. HEAD is pointed at refName if it isn't already;
+// with refName empty the commit becomes the detached HEAD. A commit object
+// left behind by a failure is unreachable and removed by gc.
+func (s *MGitStorage) RecordCommit(commit *mgitlib.Commit, pubkey string, refName string) error {
+	t := s.beginTxn()
+	defer t.close()
+	
+	lockName := refName
+	if lockName == "" {
+		lockName = "HEAD"
+	}
+	lock, err := s.lockRef(lockName)
+	if err != nil {
+		return err
+	}
+	t.afterClose(lock.release)
+	
+	if err := s.StoreCommit(commit); err != nil {
+		return err
+	}
+	
+	// The mappings stay locked until the transaction is closed
+	update, err := s.Mappings().BeginPut(NostrCommitMapping{GitHash: commit.GitHash, MGitHash: commit.MGitHash, Pubkey: pubkey, Source: mappingSourceLocal})
+	if err != nil {
+		return err
+	}
+	t.afterClose(update.Release)
+	data, err := update.Data()
+	if err != nil {
+		return err
+	}
+	t.write(s.mappingsPath(), data)
+	t.afterCommit(update.Done)
+	
+	if refName != "" {
+		head, err := s.lockRef("HEAD")
+		if err != nil {
+			return err
+		}
+		t.afterClose(head.release)
+		if head.current != "ref: "+refName {
+			t.write(head.path, []byte("ref: "+refName))
+		}
+	}
+	t.write(lock.path, []byte(commit.MGitHash))
+	
+	old := s.resolveRefContent(lock.current)
+	if err := t.commit(); err != nil {
+		return err
+	}
+	reason := "commit: "
+	if len(commit.ParentHashes) == 0 {
+		reason = "commit (initial): "
+	}
+	return s.logRefUpdate(lockName, old, commit.MGitHash, reason+commitSubject(commit.Message))
+}
+
+// GetCommit retrieves an MGit commit by hash
+func (s *MGitStorage) GetCommit(mgitHash string) (*mgitlib.Commit, error) {
+	if len(mgitHash) < 4 {
+		return nil, fmt.Errorf("MGit hash too short, need at least 4 characters")
+	}
+	
+	// Handle abbreviated hashes by searching
+	if len(mgitHash) < 40 {
+		matches, err := s.findObjectByPrefix(mgitHash)
+		if err != nil {
+			return nil, err
+		}
+		
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no object found with hash prefix %s", mgitHash)
+		}
+		
+		if len(matches) > 1 {
+			return nil, ambiguousPrefixError(mgitHash, matches)
+		}
+		
+		mgitHash = matches[0]
+	}
+	
+	// Read and check the object
+	objType, data, err := s.readObject(mgitHash)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("commit object not found: %s", mgitHash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit object: %w", err)
+	}
+	if objType != mgitlib.CommitObject {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", mgitHash, objType)
+	}
+	
+	// Canonical encoding, or JSON from before it existed
+	return mgitlib.ParseCommit(mgitHash, data)
+}
+
+// findObjectByPrefix finds objects, loose or packed, that start with the
+// given prefix
+func (s *MGitStorage) findObjectByPrefix(prefix string) ([]string, error) {
+	matches, err := s.findLooseObjectsByPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := s.listPackedObjects()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, hash := range matches {
+		seen[hash] = true
+	}
+	for _, hash := range packed {
+		if strings.HasPrefix(hash, prefix) && !seen[hash] {
+			matches = append(matches, hash)
+		}
+	}
+	return matches, nil
+}
+
+// findLooseObjectsByPrefix finds loose objects that start with the given prefix
+func (s *MGitStorage) findLooseObjectsByPrefix(prefix string) ([]string, error) {
+	matches := []string{}
+	
+	// For very short prefixes (1-2 chars), search directory names
+	if len(prefix) <= 2 {
+		objDir := filepath.Join(s.RootDir, "objects", prefix)
+		if _, err := os.Stat(objDir); os.IsNotExist(err) {
+			return matches, nil
+		}
+		
+		files, err := ioutil.ReadDir(objDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object directory: %w", err)
+		}
+		
+		for _, file := range files {
+			if !isTempObject(file.Name()) {
+				matches = append(matches, prefix+file.Name())
+			}
+		}
+		return matches, nil
+	}
+	
+	// For longer prefixes, check the first 2 chars and then match on files
+	dirPrefix := prefix[:2]
+	filePrefix := prefix[2:]
+	objDir := filepath.Join(s.RootDir, "objects", dirPrefix)
+	
+	if _, err := os.Stat(objDir); os.IsNotExist(err) {
+		return matches, nil
+	}
+	
+	files, err := ioutil.ReadDir(objDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object directory: %w", err)
+	}
+	
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), filePrefix) && !isTempObject(file.Name()) {
+			matches = append(matches, dirPrefix+file.Name())
+		}
+	}
+	
+	return matches, nil
+}
+
+// UpdateRef updates an MGit reference (branch or tag). reason is recorded
+// in the reflog.
+func (s *MGitStorage) UpdateRef(refName string, mgitHash string, reason string) error {
+	// Ensure refName is formatted correctly
+	if !strings.HasPrefix(refName, "refs/") {
+		refName = "refs/heads/" + refName
+	}
+	
+	if err := s.writeRef(refName, mgitHash, reason); err != nil {
+		return fmt.Errorf("failed to write ref: %w", err)
+	}
+	
+	return nil
+}
+
+// writeRef replaces a ref's content under its lock and logs the move
+func (s *MGitStorage) writeRef(refName string, content string, reason string) error {
+	lock, err := s.lockRef(refName)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	
+	old := s.resolveRefContent(lock.current)
+	if err := lock.commit(content); err != nil {
+		return err
+	}
+	return s.logRefUpdate(refName, old, s.resolveRefContent(content), reason)
+}
+
+// GetRef gets the MGit hash that a reference points to
+func (s *MGitStorage) GetRef(refName string) (string, error) {
+	// Ensure refName is formatted correctly
+	if !strings.HasPrefix(refName, "refs/") {
+		refName = "refs/heads/" + refName
+	}
+	
+	refPath := filepath.Join(s.RootDir, refName)
+	
+	// Check if the file exists
+	if _, err := os.Stat(refPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("reference not found: %s", refName)
+	}
+	
+	// Read the ref
+	data, err := ioutil.ReadFile(refPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read ref: %w", err)
+	}
+	
+	content := strings.TrimSpace(string(data))
+	if strings.HasPrefix(content, "ref: ") {
+		// Symbolic ref - follow it to the hash
+		return s.resolveSymbolic(strings.TrimPrefix(content, "ref: "), 1)
+	}
+	
+	return content, nil
+}
+
+// maxSymbolicDepth bounds how many symbolic refs are followed, so a cycle
+// (a -> b -> a) fails instead of looping forever
+const maxSymbolicDepth = 5
+
+// resolveSymbolic follows a chain of symbolic refs to an MGit hash
+func (s *MGitStorage) resolveSymbolic(refName string, depth int) (string, error) {
+	if depth > maxSymbolicDepth {
+		return "", fmt.Errorf("symbolic ref chain too deep at %s", refName)
+	}
+	
+	data, err := ioutil.ReadFile(filepath.Join(s.RootDir, refName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("reference not found: %s", refName)
+		}
+		return "", fmt.Errorf("failed to read ref: %w", err)
+	}
+	
+	content := strings.TrimSpace(string(data))
+	if strings.HasPrefix(content, "ref: ") {
+		return s.resolveSymbolic(strings.TrimPrefix(content, "ref: "), depth+1)
+	}
+	
+	return content, nil
+}
+
+// SetSymbolicRef makes refName point at another ref rather than a hash
+func (s *MGitStorage) SetSymbolicRef(refName string, target string) error {
+	if !strings.HasPrefix(refName, "refs/") {
+		refName = "refs/heads/" + refName
+	}
+	if !strings.HasPrefix(target, "refs/") {
+		target = "refs/heads/" + target
+	}
+	if refName == target {
+		return fmt.Errorf("symbolic ref %s cannot point to itself", refName)
+	}
+	
+	if err := s.writeRef(refName, "ref: "+target, "symbolic-ref"); err != nil {
+		return fmt.Errorf("failed to write symbolic ref: %w", err)
+	}
+	
+	return nil
+}
+
+// GetSymbolicRef returns the ref that refName points to, or an error if
+// refName holds a hash rather than a symbolic ref
+func (s *MGitStorage) GetSymbolicRef(refName string) (string, error) {
+	if !strings.HasPrefix(refName, "refs/") {
+		refName = "refs/heads/" + refName
+	}
+	
+	data, err := ioutil.ReadFile(filepath.Join(s.RootDir, refName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("reference not found: %s", refName)
+		}
+		return "", fmt.Errorf("failed to read ref: %w", err)
+	}
+	
+	content := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(content, "ref: ") {
+		return "", fmt.Errorf("%s is not a symbolic ref", refName)
+	}
+	
+	return strings.TrimPrefix(content, "ref: "), nil
+}
+
+// DeleteRef removes an MGit reference
+func (s *MGitStorage) DeleteRef(refName string) error {
+	if !strings.HasPrefix(refName, "refs/") {
+		refName = "refs/heads/" + refName
+	}
+	
+	if err := os.Remove(filepath.Join(s.RootDir, refName)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("reference not found: %s", refName)
+		}
+		return fmt.Errorf("failed to delete ref: %w", err)
+	}
+	// A deleted ref's history goes with it, as in git
+	os.Remove(s.reflogPath(refName))
+	
+	return nil
+}
+
+// ListRefs returns all refs under prefix (e.g. "refs/heads", "refs/tags")
+// mapped to the MGit hash they resolve to
+func (s *MGitStorage) ListRefs(prefix string) (map[string]string, error) {
+	refs := make(map[string]string)
+	root := filepath.Join(s.RootDir, prefix)
+	
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return refs, nil
+	}
+	
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(info.Name(), ".lock") {
+			// Lock files belong to an update-ref in progress
+			return nil
+		}
+		
+		rel, err := filepath.Rel(s.RootDir, path)
+		if err != nil {
+			return err
+		}
+		refName := filepath.ToSlash(rel)
+		
+		hash, err := s.GetRef(refName)
+		if err != nil {
+			// Dangling symbolic refs are skipped rather than failing the listing
+			return nil
+		}
+		refs[refName] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	
+	return refs, nil
+}
+
+// UpdateTag creates or moves an MGit tag
+func (s *MGitStorage) UpdateTag(tagName string, mgitHash string) error {
+	return s.UpdateRef("refs/tags/"+strings.TrimPrefix(tagName, "refs/tags/"), mgitHash, "tag")
+}
+
+// GetTag gets the MGit hash that a tag points to
+func (s *MGitStorage) GetTag(tagName string) (string, error) {
+	return s.GetRef("refs/tags/" + strings.TrimPrefix(tagName, "refs/tags/"))
+}
+
+// DeleteTag removes an MGit tag
+func (s *MGitStorage) DeleteTag(tagName string) error {
+	return s.DeleteRef("refs/tags/" + strings.TrimPrefix(tagName, "refs/tags/"))
+}
+
+// ListTags returns all MGit tags by short name
+func (s *MGitStorage) ListTags() (map[string]string, error) {
+	refs, err := s.ListRefs("refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	
+	tags := make(map[string]string, len(refs))
+	for refName, hash := range refs {
+		tags[strings.TrimPrefix(refName, "refs/tags/")] = hash
+	}
+	
+	return tags, nil
+}
+
+// UpdateHead points HEAD at a branch. reason is recorded in the reflog.
+func (s *MGitStorage) UpdateHead(refName string, reason string) error {
+	// Format the content as "ref: refs/heads/branch-name"
+	// Ensure refName is formatted correctly
+	if !strings.HasPrefix(refName, "refs/") {
+		refName = "refs/heads/" + refName
+	}
+	
+	content := fmt.Sprintf("ref: %s", refName)
+	
+	// Write the HEAD file
+	if err := s.writeRef("HEAD", content, reason); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	
+	return nil
+}
+
+// SetDetachedHead points HEAD directly at an MGit hash. reason is recorded
+// in the reflog.
+func (s *MGitStorage) SetDetachedHead(mgitHash string, reason string) error {
+	if err := s.writeRef("HEAD", mgitHash, reason); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	
+	return nil
+}
+
+// GetHead gets the current HEAD reference
+func (s *MGitStorage) GetHead() (string, error) {
+	headPath := filepath.Join(s.RootDir, "HEAD")
+	
+	// Check if the file exists
+	if _, err := os.Stat(headPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("HEAD not found")
+	}
+	
+	// Read the HEAD file
+	data, err := ioutil.ReadFile(headPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	
+	// Parse the content
+	content := strings.TrimSpace(string(data))
+	if strings.HasPrefix(content, "ref: ") {
+		// It's a reference, return the ref name
+		return strings.TrimPrefix(content, "ref: "), nil
+	} else {
+		// It's a direct hash (detached HEAD)
+		return content, nil
+	}
+}
+
+// GetHeadCommit gets the commit that HEAD points to
+func (s *MGitStorage) GetHeadCommit() (*mgitlib.Commit, error) {
+	head, err := s.GetHead()
+	if err != nil {
+		return nil, err
+	}
+	
+	if strings.HasPrefix(head, "refs/") {
+		// It's a reference, get the hash it points to
+		hash, err := s.GetRef(head)
+		if err != nil {
+			return nil, err
+		}
+		
+		// Get the commit object
+		return s.GetCommit(hash)
+	} else {
+		// It's a direct hash
+		return s.GetCommit(head)
+	}
+}
+
+// mappingsPath returns the canonical location of the hash mappings. This is
+// the only mapping file MGit reads or writes; see CompactMappings for the
+// legacy nostr_mappings.json copy.
+func (s *MGitStorage) mappingsPath() string {
+	return mapping.Path(s.RootDir)
+}
+
+// legacyMappingsPath returns the path of the old nostr_mappings.json duplicate
+func (s *MGitStorage) legacyMappingsPath() string {
+	return filepath.Join(s.RootDir, "nostr_mappings.json")
+}
+
+// Mappings returns the shared, cached store for this repository's hash
+// mappings
+func (s *MGitStorage) Mappings() *mapping.Store {
+	return mapping.StoreFor(s.mappingsPath())
+}
+
+// StoreMapping stores a mapping between Git and MGit hashes, noting where
+// it came from
+func (s *MGitStorage) StoreMapping(gitHash string, mgitHash string, pubkey string, source string) error {
+	return s.Mappings().Put(NostrCommitMapping{
+		GitHash:  gitHash,
+		MGitHash: mgitHash,
+		Pubkey:   pubkey,
+		Source:   source,
+	})
+}
+
+// WriteMappings replaces the stored hash mappings
+func (s *MGitStorage) WriteMappings(mappings []NostrCommitMapping) error {
+	return s.Mappings().Replace(mappings)
+}
+
+// GetMappings gets all hash mappings
+func (s *MGitStorage) GetMappings() ([]NostrCommitMapping, error) {
+	return s.Mappings().All()
+}
+
+// CompactMappings folds the legacy nostr_mappings.json into the canonical
+// mappings file, drops duplicate entries and removes the legacy file. It
+// returns how many entries were merged in and how many duplicates were dropped.
+func (s *MGitStorage) CompactMappings() (merged int, dropped int, err error) {
+	mappings, err := s.GetMappings()
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	// Pull in entries that only exist in the legacy file
+	legacyPath := s.legacyMappingsPath()
+	var legacy []NostrCommitMapping
+	hasLegacy := false
+	if data, readErr := ioutil.ReadFile(legacyPath); readErr == nil {
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return 0, 0, fmt.Errorf("failed to unmarshal %s: %w", legacyPath, err)
+		}
+		hasLegacy = true
+	} else if !os.IsNotExist(readErr) {
+		return 0, 0, fmt.Errorf("failed to read %s: %w", legacyPath, readErr)
+	}
+	
+	// The canonical file wins over the legacy one, and later entries win
+	// over earlier ones, matching StoreMapping's update semantics
+	byGit := map[string]int{}
+	byMGit := map[string]int{}
+	compacted := []NostrCommitMapping{}
+	superseded := map[int]bool{}
+	add := func(mapping NostrCommitMapping, override bool) bool {
+		i, ok := byGit[mapping.GitHash]
+		j, mgitOK := byMGit[mapping.MGitHash]
+		if !ok {
+			i, ok = j, mgitOK
+		}
+		if !ok {
+			byGit[mapping.GitHash] = len(compacted)
+			byMGit[mapping.MGitHash] = len(compacted)
+			compacted = append(compacted, mapping)
+			return true
+		}
+		if override {
+			// An entry sharing only the MGit hash is superseded too
+			if mgitOK && j != i {
+				delete(byGit, compacted[j].GitHash)
+				superseded[j] = true
+				dropped++
+			}
+			// Both indexes move to the new entry's hashes, so no stale
+			// hash leads to the entry it replaced
+			delete(byGit, compacted[i].GitHash)
+			delete(byMGit, compacted[i].MGitHash)
+			compacted[i] = mapping
+			byGit[mapping.GitHash] = i
+			byMGit[mapping.MGitHash] = i
+		}
+		return false
+	}
+	
+	for _, mapping := range mappings {
+		if !add(mapping, true) {
+			dropped++
+		}
+	}
+	for _, mapping := range legacy {
+		if add(mapping, false) {
+			merged++
+		}
+	}
+	if len(superseded) > 0 {
+		kept := compacted[:0]
+		for i, mapping := range compacted {
+			if !superseded[i] {
+				kept = append(kept, mapping)
+			}
+		}
+		compacted = kept
+	}
+	
+	if merged > 0 || dropped > 0 {
+		if err := s.WriteMappings(compacted); err != nil {
+			return 0, 0, err
+		}
+	}
+	
+	if hasLegacy {
+		if err := os.Remove(legacyPath); err != nil {
+			return merged, dropped, fmt.Errorf("failed to remove %s: %w", legacyPath, err)
+		}
+	}
+	
+	return merged, dropped, nil
+}
+
+// GetMGitHashFromGit gets the MGit hash for a Git hash
+func (s *MGitStorage) GetMGitHashFromGit(gitHash string) (string, error) {
+	mapping, ok, err := s.Mappings().ByGit(gitHash)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no MGit hash found for Git hash %s", gitHash)
+	}
+	return mapping.MGitHash, nil
+}
+
+// GetGitHashFromMGit gets the Git hash for an MGit hash
+func (s *MGitStorage) GetGitHashFromMGit(mgitHash string) (string, error) {
+	mapping, ok, err := s.Mappings().ByMGit(mgitHash)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no Git hash found for MGit hash %s", mgitHash)
+	}
+	return mapping.GitHash, nil
+}
+
+// GetPubkeyForCommit gets the nostr pubkey for a commit (Git or MGit hash)
+func (s *MGitStorage) GetPubkeyForCommit(hash string) (string, error) {
+	mapping, ok, err := s.Mappings().Lookup(hash)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no pubkey found for hash %s", hash)
+	}
+	return mapping.Pubkey, nil
+}
+
+// annotationIndexPath returns the file listing the annotations on a commit
+func (s *MGitStorage) annotationIndexPath(mgitHash string) string {
+	return filepath.Join(s.RootDir, "annotations", mgitHash)
+}
+
+// StoreAnnotation stores an annotation object and adds it to its target
+// commit's annotation index
+func (s *MGitStorage) StoreAnnotation(annotation *MAnnotationStruct) error {
+	if annotation.Hash == "" || annotation.Target == "" {
+		return fmt.Errorf("annotation hash and target cannot be empty")
+	}
+	
+	annotation.Type = mgitlib.AnnotationObject
+	
+	data, err := json.MarshalIndent(annotation, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal annotation: %w", err)
+	}
+	if err := s.writeObject(annotation.Hash, mgitlib.AnnotationObject, data); err != nil {
+		return fmt.Errorf("failed to write annotation object: %w", err)
+	}
+	
+	indexPath := s.annotationIndexPath(annotation.Target)
+	lock, err := fsutil.LockFile(indexPath, fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	
+	existing, err := s.annotationHashes(annotation.Target)
+	if err != nil {
+		return err
+	}
+	for _, hash := range existing {
+		if hash == annotation.Hash {
+			return nil
+		}
+	}
+	
+	index := strings.Join(append(existing, annotation.Hash), "\n") + "\n"
+	if err := fsutil.WriteFile(indexPath, []byte(index)); err != nil {
+		return fmt.Errorf("failed to update annotation index: %w", err)
+	}
+	
+	return nil
+}
+
+// GetAnnotation retrieves an annotation object by its full hash
+func (s *MGitStorage) GetAnnotation(hash string) (*MAnnotationStruct, error) {
+	if len(hash) < 40 {
+		return nil, fmt.Errorf("annotation hash must be a full hash")
+	}
+	
+	objType, data, err := s.readObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read annotation object %s: %w", hash, err)
+	}
+	if objType != mgitlib.AnnotationObject {
+		return nil, fmt.Errorf("object %s is not an annotation", hash)
+	}
+	
+	var annotation MAnnotationStruct
+	if err := json.Unmarshal(data, &annotation); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal annotation: %w", err)
+	}
+	
+	return &annotation, nil
+}
+
+// GetAnnotations returns the annotations on an MGit commit, oldest first
+func (s *MGitStorage) GetAnnotations(mgitHash string) ([]*MAnnotationStruct, error) {
+	hashes, err := s.annotationHashes(mgitHash)
+	if err != nil {
+		return nil, err
+	}
+	
+	annotations := make([]*MAnnotationStruct, 0, len(hashes))
+	for _, hash := range hashes {
+		annotation, err := s.GetAnnotation(hash)
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, annotation)
+	}
+	
+	return annotations, nil
+}
+
+// annotationHashes reads the annotation index for a commit
+func (s *MGitStorage) annotationHashes(mgitHash string) ([]string, error) {
+	data, err := ioutil.ReadFile(s.annotationIndexPath(mgitHash))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read annotation index: %w", err)
+	}
+	
+	return strings.Fields(string(data)), nil
+}
@@ -0,0 +1,288 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// Timestamps anchor MGit hashes outside the repository, so a later reader
+// can tell a commit existed by a given time. An anchor is a signed nostr
+// event naming the commits, published to the repository's relays (or else
+// nostr.relays); the relays that accepted it hold a copy the signer can't
+// take back or backdate without it showing. Each anchored commit gets the
+// attestation, with the relays that accepted it, in
+// .mgit/timestamps/<mgit-hash>.json. As an MGit hash covers its parents',
+// an anchored commit also dates its whole history.
+//
+// timestamp.auto picks when commits are anchored: "commit" anchors each new
+// commit, "push" the commits a push sends, and "off" (the default) only
+// those given to mgit timestamp. mgit verify checks the attestations of the
+// commits it verifies and reports the anchored times.
+
+const timestampUsage = "Usage: mgit timestamp [<commit>...] | show <commit>"
+
+// maxReportedAnchors bounds how many anchored commits verify lists
+const maxReportedAnchors = 10
+
+// NostrKindTimestamp is an application-specific (NIP-78) event used for
+// timestamp anchors
+const NostrKindTimestamp = 30078
+
+// TimestampAttestation is an anchor of a commit, as stored
+type TimestampAttestation struct {
+	Event  *nostr.Event `json:"event"`
+	Relays []string     `json:"relays"` // The relays that accepted the event
+}
+
+// HandleTimestamp handles the timestamp command
+func HandleTimestamp(args []string) {
+	session := currentSession()
+	repo := session.MustRepo()
+	storage := session.Storage()
+
+	if len(args) > 0 && args[0] == "show" {
+		if len(args) != 2 {
+			fmt.Println(timestampUsage)
+			os.Exit(1)
+		}
+		hash, err := resolveMGitCommitHash(repo, storage, args[1])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if err := showTimestamps(storage, hash); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) == 0 {
+		args = []string{"HEAD"}
+	}
+	hashes := []string{}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			fmt.Println(timestampUsage)
+			os.Exit(1)
+		}
+		hash, err := resolveMGitCommitHash(repo, storage, arg)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		hashes = appendUnique(hashes, hash)
+	}
+	if err := anchorCommits(storage, hashes); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// autoTimestamp anchors commits when timestamp.auto is set to the event
+// that made them, warning rather than failing
+func autoTimestamp(storage *MGitStorage, event string, hashes []string) {
+	if GetConfigValue("timestamp.auto", "off") != event || len(hashes) == 0 {
+		return
+	}
+	if err := anchorCommits(storage, hashes); err != nil {
+		fmt.Printf("Warning: commits not timestamped: %s\n", err)
+	}
+}
+
+// anchorCommits signs and publishes one anchor for the commits, and stores
+// it for each of them
+func anchorCommits(storage *MGitStorage, hashes []string) error {
+	secret, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+	relays := configuredRelays()
+	tags := [][]string{
+		{"d", fmt.Sprintf("mgit-timestamp:%s:%d", hashes[0], time.Now().UnixNano())},
+		{"t", "mgit-timestamp"},
+	}
+	if addr, err := repoAddress(storage, ""); err == nil {
+		tags = append(tags, []string{"a", addr.Coordinate()})
+		relays = repoRelays(addr, nil)
+	}
+	if len(relays) == 0 {
+		return fmt.Errorf("no relays configured (add one with mgit relay add <url>)")
+	}
+	for _, hash := range hashes {
+		tags = append(tags, []string{"commit", hash})
+	}
+
+	event := nostr.NewEvent(NostrKindTimestamp, tags, fmt.Sprintf("MGit timestamp for %d commit(s)", len(hashes)))
+	if err := event.Sign(secret); err != nil {
+		return fmt.Errorf("error signing timestamp: %w", err)
+	}
+	attestation := &TimestampAttestation{Event: event, Relays: []string{}}
+	for _, result := range publishToRelays(event, relays) {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("  %s: %s\n", result.Relay, result.Err)
+		case !result.Accepted:
+			fmt.Printf("  %s: rejected: %s\n", result.Relay, result.Message)
+		default:
+			attestation.Relays = append(attestation.Relays, result.Relay)
+		}
+	}
+	if len(attestation.Relays) == 0 {
+		return fmt.Errorf("no relay accepted the timestamp")
+	}
+
+	for _, hash := range hashes {
+		if err := storage.AddTimestamp(hash, attestation); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Timestamped %d commit(s) on %d of %d relays (event %s)\n",
+		len(hashes), len(attestation.Relays), len(relays), shortHash(event.ID))
+	return nil
+}
+
+// timestampPath returns the file holding a commit's attestations
+func (s *MGitStorage) timestampPath(hash string) string {
+	return filepath.Join(s.RootDir, "timestamps", hash+".json")
+}
+
+// Timestamps returns a commit's attestations, oldest first
+func (s *MGitStorage) Timestamps(hash string) ([]*TimestampAttestation, error) {
+	data, err := os.ReadFile(s.timestampPath(hash))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading timestamps: %w", err)
+	}
+	attestations := []*TimestampAttestation{}
+	if err := json.Unmarshal(data, &attestations); err != nil {
+		return nil, fmt.Errorf("error parsing timestamps of %s: %w", shortHash(hash), err)
+	}
+	return attestations, nil
+}
+
+// AddTimestamp records an attestation of a commit
+func (s *MGitStorage) AddTimestamp(hash string, attestation *TimestampAttestation) error {
+	path := s.timestampPath(hash)
+	lock, err := fsutil.LockFile(path, fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	attestations, err := s.Timestamps(hash)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(append(attestations, attestation), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fsutil.WriteFile(path, data); err != nil {
+		return fmt.Errorf("error writing timestamp: %w", err)
+	}
+	return nil
+}
+
+// checkAttestation checks an attestation's signature and that it names
+// the commit
+func checkAttestation(hash string, attestation *TimestampAttestation) error {
+	if attestation.Event == nil {
+		return fmt.Errorf("the attestation has no event")
+	}
+	if attestation.Event.Kind != NostrKindTimestamp {
+		return fmt.Errorf("event %s is not a timestamp", shortHash(attestation.Event.ID))
+	}
+	if err := attestation.Event.Verify(); err != nil {
+		return fmt.Errorf("event %s: %w", shortHash(attestation.Event.ID), err)
+	}
+	for _, tag := range attestation.Event.Tags {
+		if len(tag) >= 2 && tag[0] == "commit" && tag[1] == hash {
+			return nil
+		}
+	}
+	return fmt.Errorf("event %s does not name the commit", shortHash(attestation.Event.ID))
+}
+
+// showTimestamps lists a commit's attestations
+func showTimestamps(storage *MGitStorage, hash string) error {
+	attestations, err := storage.Timestamps(hash)
+	if err != nil {
+		return err
+	}
+	if len(attestations) == 0 {
+		fmt.Printf("%s is not timestamped\n", hash)
+		return nil
+	}
+	fmt.Printf("commit %s\n", hash)
+	for _, attestation := range attestations {
+		if err := checkAttestation(hash, attestation); err != nil {
+			fmt.Printf("  invalid: %s\n", err)
+			continue
+		}
+		npub, _ := nostr.Npub(attestation.Event.Pubkey)
+		fmt.Printf("  %s by %s, event %s\n", time.Unix(attestation.Event.CreatedAt, 0).Format(time.RFC3339), npub, attestation.Event.ID)
+		fmt.Printf("    on %s\n", strings.Join(attestation.Relays, ", "))
+	}
+	return nil
+}
+
+// reportTimestamps checks the attestations of the verified commits and
+// reports the anchored times; it returns false when one doesn't check out
+func reportTimestamps(storage *MGitStorage, hashes []string) bool {
+	type anchor struct {
+		hash   string
+		when   time.Time
+		relays int
+	}
+	anchors := []anchor{}
+	valid := true
+	for _, hash := range hashes {
+		attestations, err := storage.Timestamps(hash)
+		if err != nil {
+			fmt.Printf("Timestamp error for commit %s: %s\n", hash, err)
+			valid = false
+			continue
+		}
+		for _, attestation := range attestations {
+			if err := checkAttestation(hash, attestation); err != nil {
+				fmt.Printf("Invalid timestamp for commit %s: %s\n", hash, err)
+				valid = false
+				continue
+			}
+			anchors = append(anchors, anchor{hash, time.Unix(attestation.Event.CreatedAt, 0), len(attestation.Relays)})
+		}
+	}
+	if len(anchors) == 0 {
+		return valid
+	}
+
+	// Earliest anchor first: it dates the commit and all of its history
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i].when.Before(anchors[j].when) })
+	counted := map[string]bool{}
+	for _, anchor := range anchors {
+		if counted[anchor.hash] {
+			continue
+		}
+		counted[anchor.hash] = true
+		if len(counted) > maxReportedAnchors {
+			continue
+		}
+		fmt.Printf("Anchored: %s by %s (%d relay(s))\n", shortHash(anchor.hash), anchor.when.Format(time.RFC3339), anchor.relays)
+	}
+	if len(counted) > maxReportedAnchors {
+		fmt.Printf("... and %d more (mgit timestamp show <commit> lists a commit's anchors)\n", len(counted)-maxReportedAnchors)
+	}
+	fmt.Printf("%d of %d verified commit(s) are timestamped\n", len(counted), len(hashes))
+	return valid
+}
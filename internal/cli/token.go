@@ -0,0 +1,304 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The tokens mgit login saves are JWTs the server can refresh and revoke:
+//
+//	POST /api/mgit/auth/refresh  {"refresh_token"}, or the token itself as
+//	                             Bearer auth -> token, access, refresh_token
+//	POST /api/mgit/auth/revoke   the token as Bearer auth
+//
+// A stored token that expires within auth.refreshBefore (default 5m) is
+// refreshed when a command asks the token store for it, so clone, push and
+// pull get a fresh token rather than a 401 from the server. One that has
+// expired and can't be refreshed is reported as such, with how to log in
+// again.
+
+const (
+	tokenUsage            = "Usage: mgit token list"
+	logoutUsage           = "Usage: mgit logout [<url> | --all]"
+	defaultRefreshBefore  = 5 * time.Minute
+	tokenExpiryDateFormat = "2006-01-02 15:04"
+)
+
+// JWTClaims are the claims mgit reads from a token; signatures are the
+// server's business
+type JWTClaims struct {
+	Subject  string          `json:"sub"`
+	Expiry   int64           `json:"exp"`
+	IssuedAt int64           `json:"iat"`
+	Scope    json.RawMessage `json:"scope"` // A space-separated string or a list
+	Access   string          `json:"access"`
+}
+
+// parseJWTClaims decodes a JWT's payload without checking its signature
+func parseJWTClaims(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT payload: %w", err)
+	}
+	claims := &JWTClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("invalid JWT payload: %w", err)
+	}
+	return claims, nil
+}
+
+// Expires returns when the token expires, if it says
+func (c *JWTClaims) Expires() (time.Time, bool) {
+	if c.Expiry == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(c.Expiry, 0), true
+}
+
+// Scopes returns the token's scopes
+func (c *JWTClaims) Scopes() []string {
+	var scope string
+	if err := json.Unmarshal(c.Scope, &scope); err == nil {
+		return strings.Fields(scope)
+	}
+	var scopes []string
+	if err := json.Unmarshal(c.Scope, &scopes); err == nil {
+		return scopes
+	}
+	return nil
+}
+
+// credentials returns the token as bearer credentials
+func (t *AuthToken) credentials() *Credentials {
+	return &Credentials{Provider: "token-store", Scheme: "Bearer", Value: t.Token, Source: getTokenConfigPath()}
+}
+
+// refreshBefore returns how long before expiry a token is refreshed
+func refreshBefore() time.Duration {
+	if d := GetConfigDuration("auth.refreshBefore", defaultRefreshBefore); d >= 0 {
+		return d
+	}
+	return defaultRefreshBefore
+}
+
+// freshToken returns a stored token, refreshing it first if it expires
+// soon. An expired token that can't be refreshed is an error.
+func freshToken(token *AuthToken) (*AuthToken, error) {
+	claims, err := parseJWTClaims(token.Token)
+	if err != nil {
+		return token, nil
+	}
+	expires, ok := claims.Expires()
+	if !ok || time.Until(expires) > refreshBefore() {
+		return token, nil
+	}
+
+	refreshed, refreshErr := refreshToken(token)
+	if refreshErr == nil {
+		fmt.Printf("Refreshed the token for %s\n", token.RepoURL)
+		return refreshed, nil
+	}
+	if time.Now().Before(expires) {
+		fmt.Printf("Warning: the token for %s expires at %s and could not be refreshed: %s\n",
+			token.RepoURL, expires.Local().Format(tokenExpiryDateFormat), refreshErr)
+		return token, nil
+	}
+	return nil, fmt.Errorf("the token for %s expired at %s and could not be refreshed (%s); log in again with mgit login %s",
+		token.RepoURL, expires.Local().Format(tokenExpiryDateFormat), refreshErr, token.RepoURL)
+}
+
+// refreshToken asks the server for a new token and stores it in place of
+// the old one
+func refreshToken(token *AuthToken) (*AuthToken, error) {
+	body := map[string]string{}
+	var auth *Credentials
+	if token.RefreshToken != "" {
+		body["refresh_token"] = token.RefreshToken
+	} else {
+		auth = token.credentials()
+	}
+	result := &LoginResult{}
+	status, err := loginRequest("POST", extractServerBaseURL(token.RepoURL)+"/api/mgit/auth/refresh", auth, body, result)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || result.Token == "" {
+		if reason := result.reason(); reason != "" {
+			return nil, fmt.Errorf("the server refused: %s", reason)
+		}
+		return nil, fmt.Errorf("the server refused (status %d)", status)
+	}
+
+	refreshed := *token
+	refreshed.Token = result.Token
+	if result.Access != "" {
+		refreshed.Access = result.Access
+	}
+	if result.RefreshToken != "" {
+		refreshed.RefreshToken = result.RefreshToken
+	}
+	store, err := loadTokenStore()
+	if err != nil {
+		return nil, err
+	}
+	for i := range store.Tokens {
+		if store.Tokens[i].Token == token.Token {
+			store.Tokens[i] = refreshed
+		}
+	}
+	if err := store.save(); err != nil {
+		return nil, fmt.Errorf("error saving the refreshed token: %w", err)
+	}
+	return &refreshed, nil
+}
+
+// HandleToken handles the token command
+func HandleToken(args []string) {
+	if len(args) != 1 || args[0] != "list" {
+		fmt.Println(tokenUsage)
+		os.Exit(1)
+	}
+	store, err := loadTokenStore()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if len(store.Tokens) == 0 {
+		fmt.Println("No stored tokens (log in with mgit login <url>)")
+		return
+	}
+
+	for _, token := range store.Tokens {
+		if token.Server != "" {
+			fmt.Printf("%s (server %s)\n", token.RepoURL, token.Server)
+		} else {
+			fmt.Println(token.RepoURL)
+		}
+		claims, err := parseJWTClaims(token.Token)
+		if err != nil {
+			fmt.Printf("  access:  %s\n", orNone(token.Access))
+			fmt.Println("  expires: unknown (not a JWT)")
+			continue
+		}
+		access := token.Access
+		if access == "" {
+			access = claims.Access
+		}
+		fmt.Printf("  access:  %s\n", orNone(access))
+		if scopes := claims.Scopes(); len(scopes) > 0 {
+			fmt.Printf("  scopes:  %s\n", strings.Join(scopes, " "))
+		}
+		if claims.Subject != "" {
+			fmt.Printf("  subject: %s\n", claims.Subject)
+		}
+		expires, ok := claims.Expires()
+		switch {
+		case !ok:
+			fmt.Println("  expires: never")
+		case time.Now().After(expires):
+			fmt.Printf("  expired: %s (%s ago)\n", expires.Local().Format(tokenExpiryDateFormat), time.Since(expires).Round(time.Minute))
+		default:
+			fmt.Printf("  expires: %s (in %s)\n", expires.Local().Format(tokenExpiryDateFormat), time.Until(expires).Round(time.Minute))
+		}
+		if token.RefreshToken != "" {
+			fmt.Println("  refreshable")
+		}
+	}
+}
+
+// HandleLogout handles the logout command: it revokes the tokens for a
+// repository (the origin remote's when none is named), or with --all every
+// stored token, and removes them from the token store
+func HandleLogout(args []string) {
+	all := len(args) == 1 && args[0] == "--all"
+	repoURL := ""
+	switch {
+	case all:
+	case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+		repoURL = strings.TrimSuffix(args[0], "/")
+	case len(args) == 0:
+		if repo, err := currentSession().Repo(); err == nil {
+			if remote, err := repo.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+				repoURL = remote.Config().URLs[0]
+			}
+		}
+		if repoURL == "" {
+			fmt.Println("Error: no origin remote; name the repository URL to log out of")
+			os.Exit(1)
+		}
+	default:
+		fmt.Println(logoutUsage)
+		os.Exit(1)
+	}
+
+	store, err := loadTokenStore()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	kept := []AuthToken{}
+	removed := 0
+	for _, token := range store.Tokens {
+		// A server's token goes only when the server itself is named
+		if !all && (!sameRepoURL(token.RepoURL, repoURL) || token.Server != "" && token.RepoURL != repoURL) {
+			kept = append(kept, token)
+			continue
+		}
+		if err := revokeToken(token); err != nil {
+			fmt.Printf("Warning: the server did not revoke the token for %s: %s\n", token.RepoURL, err)
+		}
+		removed++
+	}
+	if removed == 0 {
+		if all {
+			fmt.Println("No stored tokens")
+		} else {
+			fmt.Printf("Not logged in to %s\n", repoURL)
+		}
+		return
+	}
+	store.Tokens = kept
+	if err := store.save(); err != nil {
+		fmt.Printf("Error saving token file: %s\n", err)
+		os.Exit(1)
+	}
+	if all {
+		noun := "repositories"
+		if removed == 1 {
+			noun = "repository"
+		}
+		fmt.Printf("Logged out of %d %s\n", removed, noun)
+	} else {
+		fmt.Printf("Logged out of %s\n", repoURL)
+	}
+}
+
+// revokeToken asks the server to revoke a token
+func revokeToken(token AuthToken) error {
+	if !isServerURL(token.RepoURL) {
+		return fmt.Errorf("not an mgit server URL")
+	}
+	result := &LoginResult{}
+	status, err := loginRequest("POST", extractServerBaseURL(token.RepoURL)+"/api/mgit/auth/revoke",
+		token.credentials(), nil, result)
+	if err != nil && status == 0 {
+		return err
+	}
+	// An unknown or expired token is as good as revoked
+	if status == http.StatusOK || status == http.StatusNoContent || status == http.StatusUnauthorized {
+		return nil
+	}
+	if reason := result.reason(); reason != "" {
+		return fmt.Errorf("%s", reason)
+	}
+	return fmt.Errorf("status %d", status)
+}
@@ -0,0 +1,350 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The token store is kept encrypted in ~/.mgitconfig/tokens.enc, mode 0600,
+// as JSON naming how it is keyed alongside the XChaCha20-Poly1305 sealed
+// tokens. The key is either a random one kept in the OS keychain (macOS
+// security, or secret-tool on Linux) or derived with scrypt from a
+// passphrase, taken from MGIT_TOKEN_PASSPHRASE or asked for. auth.tokenKey
+// chooses between keychain and passphrase for a new store; by default the
+// keychain is used when one answers. A keychain that doesn't answer, or
+// won't take the key, falls back to a passphrase with a warning.
+//
+// Tokens found in the old plain-text ~/.mgitconfig/tokens.json, which the
+// web interface may still write, are moved into the encrypted store the
+// next time it is read, with a warning, and the plain-text file is removed.
+
+const (
+	tokenStoreVersion = 1
+	// tokenStoreService names the token store key in the OS keychain
+	tokenStoreService = "mgit-token-store"
+)
+
+// SealedTokenStore is the token store as written to disk
+type SealedTokenStore struct {
+	Version    int    `json:"version"`
+	Key        string `json:"key"`            // "keychain" or "passphrase"
+	LogN       int    `json:"logN,omitempty"` // scrypt cost, for a passphrase
+	Salt       string `json:"salt,omitempty"` // base64, for a passphrase
+	Nonce      string `json:"nonce"`          // base64
+	Ciphertext string `json:"ciphertext"`     // base64
+}
+
+// tokenKey is a token store key and where it comes from
+type tokenKey struct {
+	source string // "keychain" or "passphrase"
+	logN   int
+	salt   []byte
+	key    []byte
+}
+
+// tokenStoreKey is the key of the token store once it has been opened or
+// created, so a command asks for the passphrase at most once
+var tokenStoreKey *tokenKey
+
+// legacyTokenConfigPath returns where tokens used to be kept in plain text
+func legacyTokenConfigPath() string {
+	return filepath.Join(mgitUserDir(), "tokens.json")
+}
+
+// loadTokenStore reads the token store, moving any plain-text tokens into
+// it; a missing one is empty
+func loadTokenStore() (*TokenStore, error) {
+	store := &TokenStore{}
+	path := getTokenConfigPath()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading token file: %w", err)
+	}
+	if err == nil {
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+			fmt.Printf("Warning: %s was readable by others; restricting it to you\n", path)
+			if err := os.Chmod(path, 0600); err != nil {
+				return nil, err
+			}
+		}
+		if store, err = openTokenStore(data); err != nil {
+			return nil, fmt.Errorf("error opening %s: %w", path, err)
+		}
+	}
+
+	legacy, err := os.ReadFile(legacyTokenConfigPath())
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading token file: %w", err)
+	}
+	plain := &TokenStore{}
+	if err := json.Unmarshal(legacy, plain); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", legacyTokenConfigPath(), err)
+	}
+	fmt.Printf("Warning: %s holds %d token(s) in plain text; moving them to %s\n",
+		legacyTokenConfigPath(), len(plain.Tokens), path)
+	for _, token := range plain.Tokens {
+		found := false
+		for i := range store.Tokens {
+			if sameRepoURL(store.Tokens[i].RepoURL, token.RepoURL) {
+				// The plain-text file is written by newer logins
+				store.Tokens[i] = token
+				found = true
+			}
+		}
+		if !found {
+			store.Tokens = append(store.Tokens, token)
+		}
+	}
+	if err := store.save(); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(legacyTokenConfigPath()); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// save writes the token store encrypted, readable only by the user
+func (s *TokenStore) save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if tokenStoreKey == nil {
+		if err := newTokenStoreKey(); err != nil {
+			return err
+		}
+	}
+	aead, err := chacha20poly1305.NewX(tokenStoreKey.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := SealedTokenStore{
+		Version:    tokenStoreVersion,
+		Key:        tokenStoreKey.source,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, data, nil)),
+	}
+	if tokenStoreKey.source == "passphrase" {
+		sealed.LogN = tokenStoreKey.logN
+		sealed.Salt = base64.StdEncoding.EncodeToString(tokenStoreKey.salt)
+	}
+	out, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := getTokenConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return fsutil.WritePrivateFile(path, out)
+}
+
+// openTokenStore decrypts the token store
+func openTokenStore(data []byte) (*TokenStore, error) {
+	sealed := &SealedTokenStore{}
+	if err := json.Unmarshal(data, sealed); err != nil {
+		return nil, fmt.Errorf("invalid token store: %w", err)
+	}
+	if sealed.Version != tokenStoreVersion {
+		return nil, fmt.Errorf("unsupported token store version %d", sealed.Version)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil || len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("invalid token store nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token store: %w", err)
+	}
+	if tokenStoreKey == nil {
+		if err := unlockTokenStoreKey(sealed); err != nil {
+			return nil, err
+		}
+	}
+
+	aead, err := chacha20poly1305.NewX(tokenStoreKey.key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		tokenStoreKey = nil
+		if sealed.Key == "passphrase" {
+			return nil, fmt.Errorf("wrong passphrase")
+		}
+		return nil, fmt.Errorf("the keychain's key does not open it")
+	}
+	store := &TokenStore{}
+	if err := json.Unmarshal(plaintext, store); err != nil {
+		return nil, fmt.Errorf("invalid token store: %w", err)
+	}
+	return store, nil
+}
+
+// unlockTokenStoreKey finds the key of an existing store
+func unlockTokenStoreKey(sealed *SealedTokenStore) error {
+	switch sealed.Key {
+	case "keychain":
+		value, err := keychainGet()
+		if err != nil {
+			return fmt.Errorf("error reading the token store key from the keychain: %w", err)
+		}
+		key, err := hex.DecodeString(value)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("the keychain holds an invalid token store key")
+		}
+		tokenStoreKey = &tokenKey{source: "keychain", key: key}
+	case "passphrase":
+		salt, err := base64.StdEncoding.DecodeString(sealed.Salt)
+		if err != nil || len(salt) != 16 || sealed.LogN < 1 || sealed.LogN > 22 {
+			return fmt.Errorf("invalid token store key parameters")
+		}
+		passphrase, err := readPassphraseFrom("MGIT_TOKEN_PASSPHRASE", "Token store passphrase: ")
+		if err != nil {
+			return err
+		}
+		key, err := scrypt.Key([]byte(passphrase), salt, 1<<sealed.LogN, 8, 1, 32)
+		if err != nil {
+			return err
+		}
+		tokenStoreKey = &tokenKey{source: "passphrase", logN: sealed.LogN, salt: salt, key: key}
+	default:
+		return fmt.Errorf("unknown token store key '%s'", sealed.Key)
+	}
+	return nil
+}
+
+// newTokenStoreKey makes the key for a new store, in the keychain if
+// auth.tokenKey allows and there is one, else from a new passphrase
+func newTokenStoreKey() error {
+	source := GetConfigValue("auth.tokenKey", "")
+	switch source {
+	case "":
+		source = "passphrase"
+		if keychainAvailable() {
+			source = "keychain"
+		}
+	case "keychain":
+		if !keychainAvailable() {
+			fmt.Fprintln(os.Stderr, "Warning: auth.tokenKey is keychain, but no keychain answered (macOS security or secret-tool); using a passphrase instead")
+			source = "passphrase"
+		}
+	case "passphrase":
+	default:
+		return fmt.Errorf("auth.tokenKey must be keychain or passphrase, not '%s'", source)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	var salt []byte
+	logN := 0
+	if source == "keychain" {
+		if err := keychainSet(hex.EncodeToString(key)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not save the token store key in the keychain (%s); using a passphrase instead\n", err)
+			source = "passphrase"
+		}
+	}
+	if source == "passphrase" {
+		fmt.Fprintln(os.Stderr, "Choose a passphrase to encrypt the token store")
+		passphrase, err := readNewPassphraseFrom("MGIT_TOKEN_PASSPHRASE")
+		if err != nil {
+			return err
+		}
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		logN = ncryptsecLogN
+		if key, err = scrypt.Key([]byte(passphrase), salt, 1<<logN, 8, 1, 32); err != nil {
+			return err
+		}
+	}
+	tokenStoreKey = &tokenKey{source: source, logN: logN, salt: salt, key: key}
+	return nil
+}
+
+// keychainAvailable reports whether there is an OS keychain that answers.
+// Having the tool isn't enough: secret-tool is often installed where no
+// secret service runs, so a lookup is tried, and finding nothing counts as
+// an answer.
+func keychainAvailable() bool {
+	tool := "secret-tool"
+	if runtime.GOOS == "darwin" {
+		tool = "security"
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return false
+	}
+	_, err := keychainGet()
+	var exitErr *exec.ExitError
+	if err == nil {
+		return true
+	}
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	if runtime.GOOS == "darwin" {
+		// errSecItemNotFound
+		return exitErr.ExitCode() == 44
+	}
+	// secret-tool exits 1 quietly when nothing matches, and explains
+	// itself when the secret service can't be reached
+	return exitErr.ExitCode() == 1 && len(bytes.TrimSpace(exitErr.Stderr)) == 0
+}
+
+// keychainGet reads the token store key from the OS keychain
+func keychainGet() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-a", "mgit", "-s", tokenStoreService, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", tokenStoreService)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keychainSet stores the token store key in the OS keychain
+func keychainSet(value string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// Given as a command on stdin, the key stays out of the process list
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -a mgit -s %s -w %s\n", tokenStoreService, value))
+	} else {
+		cmd = exec.Command("secret-tool", "store", "--label", "mgit token store", "service", tokenStoreService)
+		cmd.Stdin = strings.NewReader(value)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", cmd.Args[0], strings.TrimSpace(string(out)))
+	}
+	return nil
+}
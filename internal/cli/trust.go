@@ -0,0 +1,278 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MappingSource is somewhere a git <-> MGit hash mapping can come from
+type MappingSource interface {
+	// Name identifies the source in trust.sources and in explanations
+	Name() string
+	// Lookup finds the mapping for a git or MGit hash (or unique prefix).
+	// It returns nil, nil when the source simply has no mapping.
+	Lookup(hash string) (*NostrCommitMapping, error)
+}
+
+// Trust policies, set with trust.policy
+const (
+	// TrustPolicyFirst accepts the mapping from the first source (in
+	// trust.sources order) that has one
+	TrustPolicyFirst = "first"
+	// TrustPolicyUnanimous only accepts a mapping when every source that
+	// has one agrees
+	TrustPolicyUnanimous = "unanimous"
+)
+
+// TrustCandidate is what a single source said about a hash
+type TrustCandidate struct {
+	Source  string
+	Mapping *NostrCommitMapping
+	Err     error
+}
+
+// TrustDecision records which mapping was accepted for a hash, and why
+type TrustDecision struct {
+	Hash       string
+	Policy     string
+	Candidates []TrustCandidate
+	Accepted   *NostrCommitMapping
+	Source     string // Source the accepted mapping came from
+	Reason     string
+}
+
+// TrustPolicy decides between mapping sources that may disagree
+type TrustPolicy struct {
+	Policy  string
+	Sources []MappingSource
+}
+
+// localMappingSource looks mappings up in .mgit/mappings
+type localMappingSource struct {
+	storage *MGitStorage
+}
+
+func (s *localMappingSource) Name() string { return "local" }
+
+func (s *localMappingSource) Lookup(hash string) (*NostrCommitMapping, error) {
+	mappings, err := s.storage.GetMappings()
+	if err != nil {
+		return nil, err
+	}
+	return findMapping(mappings, hash)
+}
+
+// serverMappingSource looks mappings up on the origin server. The mappings
+// are fetched once per process.
+type serverMappingSource struct {
+	mappings []NostrCommitMapping
+	err      error
+	loaded   bool
+}
+
+func (s *serverMappingSource) Name() string { return "server" }
+
+func (s *serverMappingSource) Lookup(hash string) (*NostrCommitMapping, error) {
+	if !s.loaded {
+		s.loaded = true
+		s.mappings, s.err = s.fetch()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return findMapping(s.mappings, hash)
+}
+
+func (s *serverMappingSource) fetch() ([]NostrCommitMapping, error) {
+	repo := getRepo()
+	remote, err := repo.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return nil, fmt.Errorf("no origin remote configured")
+	}
+	remoteURL := remote.Config().URLs[0]
+
+	auth, err := lookupAuth(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return fetchRemoteMappings(commandContext(), remoteURL, auth)
+}
+
+// nostrMappingSource stands in for mappings published as nostr events. There
+// is no relay client yet, so it never has an answer.
+type nostrMappingSource struct{}
+
+func (s *nostrMappingSource) Name() string { return "nostr" }
+
+func (s *nostrMappingSource) Lookup(hash string) (*NostrCommitMapping, error) {
+	return nil, fmt.Errorf("nostr relay lookups are not available yet")
+}
+
+// findMapping finds the mapping whose git or MGit hash starts with hash.
+// Ambiguous prefixes are an error.
+func findMapping(mappings []NostrCommitMapping, hash string) (*NostrCommitMapping, error) {
+	var match *NostrCommitMapping
+	for i, mapping := range mappings {
+		if strings.HasPrefix(mapping.GitHash, hash) || strings.HasPrefix(mapping.MGitHash, hash) {
+			if match != nil && (match.GitHash != mapping.GitHash || match.MGitHash != mapping.MGitHash) {
+				return nil, fmt.Errorf("ambiguous hash prefix %s", hash)
+			}
+			match = &mappings[i]
+		}
+	}
+	return match, nil
+}
+
+// mappingSourceByName builds a mapping source from its trust.sources name
+func mappingSourceByName(name string) (MappingSource, error) {
+	switch name {
+	case "local":
+		return &localMappingSource{storage: NewMGitStorage()}, nil
+	case "server":
+		return &serverMappingSource{}, nil
+	case "nostr":
+		return &nostrMappingSource{}, nil
+	}
+	return nil, fmt.Errorf("unknown mapping source '%s'", name)
+}
+
+// LoadTrustPolicy builds the trust policy from config:
+//
+//	trust.sources  comma-separated source order (default "local")
+//	trust.policy   "first" (default) or "unanimous"
+func LoadTrustPolicy() (*TrustPolicy, error) {
+	policy := &TrustPolicy{
+		Policy: GetConfigValue("trust.policy", TrustPolicyFirst),
+	}
+	if policy.Policy != TrustPolicyFirst && policy.Policy != TrustPolicyUnanimous {
+		return nil, fmt.Errorf("invalid trust.policy '%s' (expected %s or %s)",
+			policy.Policy, TrustPolicyFirst, TrustPolicyUnanimous)
+	}
+
+	for _, name := range strings.Split(GetConfigValue("trust.sources", "local"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		source, err := mappingSourceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		policy.Sources = append(policy.Sources, source)
+	}
+	if len(policy.Sources) == 0 {
+		return nil, fmt.Errorf("trust.sources lists no mapping sources")
+	}
+
+	return policy, nil
+}
+
+// HasSource reports whether the named source takes part in the policy
+func (p *TrustPolicy) HasSource(name string) bool {
+	for _, source := range p.Sources {
+		if source.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide asks every source about hash and applies the policy
+func (p *TrustPolicy) Decide(hash string) *TrustDecision {
+	decision := &TrustDecision{Hash: hash, Policy: p.Policy}
+
+	for _, source := range p.Sources {
+		mapping, err := source.Lookup(hash)
+		decision.Candidates = append(decision.Candidates, TrustCandidate{
+			Source:  source.Name(),
+			Mapping: mapping,
+			Err:     err,
+		})
+	}
+
+	switch p.Policy {
+	case TrustPolicyUnanimous:
+		for _, candidate := range decision.Candidates {
+			if candidate.Mapping == nil {
+				continue
+			}
+			if decision.Accepted == nil {
+				decision.Accepted = candidate.Mapping
+				decision.Source = candidate.Source
+				continue
+			}
+			if candidate.Mapping.GitHash != decision.Accepted.GitHash ||
+				candidate.Mapping.MGitHash != decision.Accepted.MGitHash {
+				decision.Reason = fmt.Sprintf("sources disagree: %s and %s map %s differently",
+					decision.Source, candidate.Source, hash)
+				decision.Accepted = nil
+				decision.Source = ""
+				return decision
+			}
+		}
+		if decision.Accepted != nil {
+			decision.Reason = "all sources with a mapping agree"
+		}
+	default:
+		for _, candidate := range decision.Candidates {
+			if candidate.Mapping != nil {
+				decision.Accepted = candidate.Mapping
+				decision.Source = candidate.Source
+				decision.Reason = fmt.Sprintf("%s is the first source in trust.sources with a mapping", candidate.Source)
+				break
+			}
+		}
+	}
+
+	if decision.Accepted == nil && decision.Reason == "" {
+		decision.Reason = "no source has a mapping"
+	}
+
+	return decision
+}
+
+// HandleTrust handles the trust command
+func HandleTrust(args []string) {
+	if len(args) != 2 || args[0] != "explain" {
+		fmt.Println("Usage: mgit trust explain <hash>")
+		os.Exit(1)
+	}
+
+	policy, err := LoadTrustPolicy()
+	if err != nil {
+		fmt.Printf("Error loading trust policy: %s\n", err)
+		os.Exit(1)
+	}
+
+	decision := policy.Decide(args[1])
+
+	fmt.Printf("Hash:    %s\n", decision.Hash)
+	fmt.Printf("Policy:  %s\n", decision.Policy)
+	fmt.Println("Sources:")
+	for _, candidate := range decision.Candidates {
+		switch {
+		case candidate.Err != nil:
+			fmt.Printf("  %-7s error: %s\n", candidate.Source, candidate.Err)
+		case candidate.Mapping == nil:
+			fmt.Printf("  %-7s no mapping\n", candidate.Source)
+		default:
+			fmt.Printf("  %-7s git %s <-> mgit %s", candidate.Source,
+				candidate.Mapping.GitHash, candidate.Mapping.MGitHash)
+			if candidate.Mapping.Pubkey != "" {
+				fmt.Printf(" (%s)", candidate.Mapping.Pubkey)
+			}
+			fmt.Println()
+		}
+	}
+	fmt.Println()
+
+	if decision.Accepted == nil {
+		fmt.Printf("Rejected: %s\n", decision.Reason)
+		os.Exit(1)
+	}
+	fmt.Printf("Accepted from %s: git %s <-> mgit %s\n", decision.Source,
+		decision.Accepted.GitHash, decision.Accepted.MGitHash)
+	fmt.Printf("Reason:  %s\n", decision.Reason)
+}
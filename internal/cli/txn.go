@@ -0,0 +1,167 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// Writes to the .mgit directory are crash-safe:
+//
+//   - Single files are written to a temporary file, synced and renamed over
+//     the old one (fsutil.WriteFile), so readers and crashes only ever see the
+//     old or the new content.
+//   - Read-modify-write updates hold an exclusively created <file>.lock for
+//     their duration, so two mgit processes can't interleave them.
+//   - Updates that touch several files go through a txn: the new contents are
+//     staged in .mgit/txn/ and a journal naming them is written before any is
+//     moved into place. A crash after the journal exists is finished by the
+//     next mgit command (recoverTransaction); one before it leaves the old
+//     files untouched.
+
+// txn is a set of file writes that happen together or not at all
+type txn struct {
+	dir      string // .mgit/txn
+	writes   []txnWrite
+	onCommit []func()
+	onClose  []func()
+}
+
+type txnWrite struct {
+	path string
+	data []byte
+}
+
+// beginTxn starts a transaction on the store
+func (s *MGitStorage) beginTxn() *txn {
+	return &txn{dir: filepath.Join(s.RootDir, "txn")}
+}
+
+// write stages new content for path
+func (t *txn) write(path string, data []byte) {
+	t.writes = append(t.writes, txnWrite{path: path, data: data})
+}
+
+// afterCommit registers fn to run once the transaction's files are in place
+func (t *txn) afterCommit(fn func()) {
+	t.onCommit = append(t.onCommit, fn)
+}
+
+// afterClose registers fn to run when the transaction ends either way, for
+// releasing locks taken while building it
+func (t *txn) afterClose(fn func()) {
+	t.onClose = append(t.onClose, fn)
+}
+
+// close ends the transaction, committed or not
+func (t *txn) close() {
+	for i := len(t.onClose) - 1; i >= 0; i-- {
+		t.onClose[i]()
+	}
+	t.onClose = nil
+}
+
+// commit moves every staged write into place
+func (t *txn) commit() error {
+	lock, err := fsutil.LockFile(t.dir, fsutil.LockWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	if err := os.RemoveAll(t.dir); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", t.dir, err)
+	}
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", t.dir, err)
+	}
+
+	journal := ""
+	for i, w := range t.writes {
+		staged := filepath.Join(t.dir, fmt.Sprintf("%d", i))
+		file, err := os.OpenFile(staged, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			err = fsutil.WriteAndSync(file, w.data)
+		}
+		if err != nil {
+			os.RemoveAll(t.dir)
+			return fmt.Errorf("failed to stage %s: %w", w.path, err)
+		}
+		abs, err := filepath.Abs(w.path)
+		if err != nil {
+			os.RemoveAll(t.dir)
+			return err
+		}
+		journal += fmt.Sprintf("%d %s\n", i, abs)
+	}
+
+	// From here on the transaction happens, if not now then on recovery
+	if err := fsutil.WriteFile(filepath.Join(t.dir, "journal"), []byte(journal)); err != nil {
+		os.RemoveAll(t.dir)
+		return err
+	}
+	if err := replayJournal(t.dir); err != nil {
+		return err
+	}
+	for _, fn := range t.onCommit {
+		fn()
+	}
+	return nil
+}
+
+// replayJournal moves the staged files a journal names into place and
+// removes the transaction directory
+func replayJournal(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "journal"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read transaction journal: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		name, target, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		staged := filepath.Join(dir, name)
+		if _, err := os.Stat(staged); os.IsNotExist(err) {
+			// Already moved before an earlier crash
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+		}
+		if err := os.Rename(staged, target); err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", dir, err)
+	}
+	return nil
+}
+
+// recoverTransaction finishes a transaction a crashed mgit process had
+// committed, or discards one it had only begun. It reports whether there
+// was one.
+func (s *MGitStorage) recoverTransaction() (bool, error) {
+	dir := filepath.Join(s.RootDir, "txn")
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return false, nil
+	}
+	lock, err := fsutil.LockFile(dir, fsutil.LockWait)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Release()
+
+	// A live transaction holds the lock, so whatever is left is a crash's
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := replayJournal(dir); err != nil {
+		return true, err
+	}
+	return true, nil
+}
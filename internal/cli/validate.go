@@ -0,0 +1,300 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Repositories of structured records (FHIR resources as JSON, say) can
+// refuse commits of files that don't hold valid records. Each rule is a
+// [validate "<name>"] section of the repository or global config:
+//
+//	[validate "patients"]
+//		path = records/patients/*.json
+//		schema = schemas/patient.schema.json
+//
+//	[validate "fhir"]
+//		path = **/*.fhir.json
+//		command = fhir-validator --stdin
+//
+// path may be given more than once. A pattern without a slash matches the
+// file name in any directory; * and ? stop at slashes and ** doesn't. A
+// rule checks a file against a JSON Schema (see jsonschema.go), read from
+// the working tree unless the path is absolute, or with a command run
+// through sh. The command gets the file's staged content on stdin and its
+// path as $1 and in MGIT_VALIDATE_PATH, and rejects the file by exiting
+// non-zero; what it prints says why. A rule in the repository's config
+// replaces a global one of the same name.
+//
+// mgit commit checks every staged file a rule matches before it commits
+// anything, and lists the errors for each invalid file; --no-verify skips
+// the check. mgit validate runs the same check without committing.
+
+const validateUsage = "Usage: mgit validate [--all]"
+
+// ValidationRule says how to check the files matching its paths
+type ValidationRule struct {
+	Name    string
+	Paths   []string
+	Schema  string
+	Command string
+}
+
+// ValidationFailure is a file a rule rejected, and why
+type ValidationFailure struct {
+	Path   string
+	Rule   string
+	Errors []string
+}
+
+// HandleValidate handles the validate command
+func HandleValidate(args []string) {
+	all := false
+	for _, arg := range args {
+		switch arg {
+		case "--all":
+			all = true
+		default:
+			fmt.Println(validateUsage)
+			os.Exit(1)
+		}
+	}
+
+	rules, err := loadValidationRules()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if len(rules) == 0 {
+		fmt.Println("No validate rules configured (add a [validate \"<name>\"] section with path and schema or command)")
+		return
+	}
+	files, err := indexFiles(".", !all)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	checked, failures, err := validateFiles(".", rules, files)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	if len(failures) > 0 {
+		printValidationFailures(failures)
+		os.Exit(1)
+	}
+	fmt.Printf("%d file(s) checked, all valid\n", checked)
+}
+
+// validateStaged checks the staged files against the configured rules
+func validateStaged(dir string) ([]ValidationFailure, error) {
+	rules, err := loadValidationRules()
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	files, err := indexFiles(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	_, failures, err := validateFiles(dir, rules, files)
+	return failures, err
+}
+
+// printValidationFailures lists the invalid files and their errors
+func printValidationFailures(failures []ValidationFailure) {
+	fmt.Printf("Error: %d file(s) failed validation:\n", len(failures))
+	for _, failure := range failures {
+		fmt.Printf("  %s (%s):\n", failure.Path, failure.Rule)
+		for _, message := range failure.Errors {
+			fmt.Printf("    %s\n", message)
+		}
+	}
+}
+
+// loadValidationRules reads the validate sections of the system, global
+// and repository config, by name
+func loadValidationRules() ([]*ValidationRule, error) {
+	byName := map[string]*ValidationRule{}
+	for _, scope := range configScopes {
+		config, err := readConfigScope(scope)
+		if err != nil {
+			return nil, err
+		}
+		for section := range config.Sections {
+			if !strings.HasPrefix(section, "validate \"") || !strings.HasSuffix(section, "\"") || len(section) <= len("validate \"\"") {
+				continue
+			}
+			rule := &ValidationRule{
+				Name:    section[len("validate \"") : len(section)-1],
+				Paths:   config.GetAll(section, "path"),
+				Schema:  config.Get(section, "schema"),
+				Command: config.Get(section, "command"),
+			}
+			switch {
+			case len(rule.Paths) == 0:
+				return nil, fmt.Errorf("validate rule '%s' has no path", rule.Name)
+			case (rule.Schema == "") == (rule.Command == ""):
+				return nil, fmt.Errorf("validate rule '%s' needs one of schema or command", rule.Name)
+			}
+			byName[rule.Name] = rule
+		}
+	}
+
+	rules := make([]*ValidationRule, 0, len(byName))
+	for _, rule := range byName {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules, nil
+}
+
+// indexFiles lists the files in the index: only those with staged changes,
+// or all of them. Deleted files have nothing to check and are left out.
+func indexFiles(dir string, stagedOnly bool) ([]string, error) {
+	args := []string{"ls-files", "-z"}
+	if stagedOnly {
+		args = []string{"diff", "--cached", "--name-only", "--diff-filter=d", "-z"}
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	files := []string{}
+	for _, file := range strings.Split(string(output), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// validateFiles checks the staged content of files against the rules that
+// match them, and returns how many were checked and those that failed
+func validateFiles(dir string, rules []*ValidationRule, files []string) (int, []ValidationFailure, error) {
+	schemas := map[string]*JSONSchema{}
+	failures := []ValidationFailure{}
+	checked := 0
+	for _, file := range files {
+		var content []byte
+		for _, rule := range rules {
+			if !rule.matches(file) {
+				continue
+			}
+			if content == nil {
+				cmd := exec.Command("git", "cat-file", "blob", ":"+file)
+				cmd.Dir = dir
+				data, err := cmd.Output()
+				if err != nil {
+					return checked, nil, fmt.Errorf("error reading staged %s: %w", file, err)
+				}
+				content = data
+				checked++
+			}
+			errs, err := rule.check(dir, file, content, schemas)
+			if err != nil {
+				return checked, nil, err
+			}
+			if len(errs) > 0 {
+				failures = append(failures, ValidationFailure{Path: file, Rule: rule.Name, Errors: errs})
+			}
+		}
+	}
+	return checked, failures, nil
+}
+
+// matches reports whether one of the rule's paths matches file
+func (r *ValidationRule) matches(file string) bool {
+	for _, pattern := range r.Paths {
+		if matchPathPattern(pattern, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// check runs the rule on a file's content and returns what is wrong with
+// it; the error is for a rule that can't run at all
+func (r *ValidationRule) check(dir, file string, content []byte, schemas map[string]*JSONSchema) ([]string, error) {
+	if r.Schema != "" {
+		schema, ok := schemas[r.Schema]
+		if !ok {
+			path := expandHome(r.Schema)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("validate rule '%s': error reading schema: %w", r.Name, err)
+			}
+			if schema, err = parseJSONSchema(data); err != nil {
+				return nil, fmt.Errorf("validate rule '%s': schema %s: %w", r.Name, r.Schema, err)
+			}
+			schemas[r.Schema] = schema
+		}
+		return schema.Validate(content), nil
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command("sh", "-c", r.Command+` "$@"`, r.Command, file)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "MGIT_VALIDATE_PATH="+file)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if _, failed := err.(*exec.ExitError); failed {
+		errs := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				errs = append(errs, line)
+			}
+		}
+		if len(errs) == 0 {
+			errs = append(errs, fmt.Sprintf("rejected by '%s' (%s)", r.Command, err))
+		}
+		return errs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("validate rule '%s': %w", r.Name, err)
+	}
+	return nil, nil
+}
+
+// matchPathPattern reports whether a repository path fits a pattern in
+// which * and ? stand for characters other than slashes and ** for any;
+// a pattern without a slash is matched against the file name
+func matchPathPattern(pattern, file string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		file = filepath.Base(file)
+	}
+	expr := strings.Builder{}
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	matched, err := regexp.MatchString(expr.String(), file)
+	return err == nil && matched
+}
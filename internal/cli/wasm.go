@@ -0,0 +1,393 @@
+//go:build js && wasm
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall/js"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+)
+
+// The js/wasm build (GOOS=js GOARCH=wasm, see build/Makefile) runs in a web
+// page instead of on a command line. It puts an mgit object on the global
+// scope whose methods return Promises:
+//
+//	mgit.clone(url, {token, branch, depth, onProgress})
+//	                                        -> {repo, name, access, head, mappings}
+//	mgit.log(repo, {limit})                 -> {commits: [MGit commit]} newest first
+//	mgit.files(repo)                        -> {files: [path]} at HEAD
+//	mgit.readFile(repo, path)               -> {path, content} at HEAD
+//	mgit.verify(repo, {onProgress})         -> {valid, verified, missing, failures}
+//	mgit.close(repo)
+//
+// onProgress, when given, is called with each progress update (see
+// progress.go), such as {operation: "clone", phase: "Receiving objects",
+// done, total, percent, bytes, rate, eta}.
+//
+// A clone lives in memory: git objects in go-git's memory storage, the
+// worktree in an in-memory billy filesystem, and the server's mappings and
+// notes in the wasmRepo. Requests go through the browser's fetch, so the
+// server must allow the page's origin (CORS). The repo handle is the URL the
+// repository was cloned from. The page calls window.onMGitReady, when it is
+// set, once the object is in place.
+
+// wasmBuild reports whether mgit was built for the browser
+const wasmBuild = true
+
+// wasmRepo is a repository cloned into memory
+type wasmRepo struct {
+	url      string
+	repo     *git.Repository
+	mappings []NostrCommitMapping
+	byGit    map[string]NostrCommitMapping
+	notes    map[string]CommitNotes
+}
+
+var (
+	wasmReposMu sync.Mutex
+	wasmRepos   = map[string]*wasmRepo{}
+)
+
+// serveWASM installs the mgit object and keeps the program running for it
+func serveWASM() {
+	api := js.Global().Get("Object").New()
+	api.Set("clone", wasmFunc(wasmClone))
+	api.Set("log", wasmFunc(wasmLog))
+	api.Set("files", wasmFunc(wasmFiles))
+	api.Set("readFile", wasmFunc(wasmReadFile))
+	api.Set("verify", wasmFunc(wasmVerify))
+	api.Set("close", wasmFunc(wasmClose))
+	js.Global().Set("mgit", api)
+
+	if ready := js.Global().Get("onMGitReady"); ready.Type() == js.TypeFunction {
+		ready.Invoke()
+	}
+	select {}
+}
+
+// wasmFunc wraps fn as a JavaScript function returning a Promise. fn runs
+// on its own goroutine, as blocking on a request from the JavaScript event
+// loop would deadlock; its result reaches JavaScript through JSON.
+func wasmFunc(fn func(args []js.Value) (interface{}, error)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		executor := js.FuncOf(func(this js.Value, settle []js.Value) interface{} {
+			resolve, reject := settle[0], settle[1]
+			go func() {
+				result, err := fn(args)
+				var data []byte
+				if err == nil {
+					data, err = json.Marshal(result)
+				}
+				if err != nil {
+					reject.Invoke(js.Global().Get("Error").New(err.Error()))
+					return
+				}
+				resolve.Invoke(js.Global().Get("JSON").Call("parse", string(data)))
+			}()
+			return nil
+		})
+		// The executor runs before the constructor returns
+		defer executor.Release()
+		return js.Global().Get("Promise").New(executor)
+	})
+}
+
+// wasmArg returns args[i] as a string, or "" when it is missing
+func wasmArg(args []js.Value, i int) string {
+	if i >= len(args) || args[i].Type() != js.TypeString {
+		return ""
+	}
+	return args[i].String()
+}
+
+// wasmOption returns an option from the options object in args[i]
+func wasmOption(args []js.Value, i int, key string) js.Value {
+	if i >= len(args) || args[i].Type() != js.TypeObject {
+		return js.Undefined()
+	}
+	return args[i].Get(key)
+}
+
+// wasmProgress returns a Progress reporting to the onProgress function in
+// the options object in args[i], or nil when there is none
+func wasmProgress(args []js.Value, i int, operation string) *Progress {
+	onProgress := wasmOption(args, i, "onProgress")
+	if onProgress.Type() != js.TypeFunction {
+		return nil
+	}
+	return newProgress(operation, func(update ProgressUpdate) {
+		data, err := json.Marshal(update)
+		if err == nil {
+			onProgress.Invoke(js.Global().Get("JSON").Call("parse", string(data)))
+		}
+	})
+}
+
+// wasmRepoArg finds the repository args[0] names
+func wasmRepoArg(args []js.Value) (*wasmRepo, error) {
+	url := strings.TrimSuffix(wasmArg(args, 0), "/")
+	wasmReposMu.Lock()
+	defer wasmReposMu.Unlock()
+	r, ok := wasmRepos[url]
+	if !ok {
+		return nil, fmt.Errorf("no repository cloned from '%s'", url)
+	}
+	return r, nil
+}
+
+// credentialsAuth passes Credentials to go-git's HTTP transport
+type credentialsAuth struct {
+	creds *Credentials
+}
+
+func (a credentialsAuth) SetAuth(req *http.Request) { a.creds.setHeader(req) }
+func (a credentialsAuth) Name() string              { return a.creds.Provider }
+func (a credentialsAuth) String() string            { return a.creds.Scheme + " " + a.creds.Provider }
+
+// wasmClone clones a repository and its MGit metadata into memory
+func wasmClone(args []js.Value) (interface{}, error) {
+	url := strings.TrimSuffix(wasmArg(args, 0), "/")
+	if !isServerURL(url) {
+		return nil, fmt.Errorf("clone needs an mgit server URL")
+	}
+	token := wasmOption(args, 1, "token")
+	if token.Type() != js.TypeString || token.String() == "" {
+		return nil, fmt.Errorf("clone needs a token (log in with mgit login and pass its token)")
+	}
+	auth := &Credentials{Provider: "wasm", Scheme: "Bearer", Value: token.String()}
+	ctx := context.Background()
+
+	info, err := fetchRepositoryInfo(ctx, url, auth)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching repository metadata: %w", err)
+	}
+
+	cloneOpts := &git.CloneOptions{URL: mgitGitURL(url), Auth: credentialsAuth{auth}}
+	if branch := wasmOption(args, 1, "branch"); branch.Type() == js.TypeString && branch.String() != "" {
+		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(branch.String())
+		cloneOpts.SingleBranch = true
+	}
+	if depth := wasmOption(args, 1, "depth"); depth.Type() == js.TypeNumber {
+		cloneOpts.Depth = depth.Int()
+	}
+	progress := wasmProgress(args, 1, "clone")
+	if progress != nil {
+		// go-git relays the server's progress lines as git prints them
+		cloneOpts.Progress = &gitProgressWriter{progress: progress}
+	}
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), memfs.New(), cloneOpts)
+	if progress != nil {
+		progress.Finish()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error cloning Git repository: %w", err)
+	}
+
+	collected := &metadataCollector{}
+	if _, err := streamRemoteMetadata(ctx, url, auth, nil, collected.add); err != nil {
+		return nil, fmt.Errorf("error fetching MGit metadata: %w", err)
+	}
+	r := &wasmRepo{
+		url:      url,
+		repo:     repo,
+		mappings: collected.mappings,
+		byGit:    make(map[string]NostrCommitMapping, len(collected.mappings)),
+		notes:    map[string]CommitNotes{},
+	}
+	for _, mapping := range collected.mappings {
+		r.byGit[mapping.GitHash] = mapping
+	}
+	for _, entry := range collected.noted {
+		r.notes[entry.MGitHash] = entry.Notes
+	}
+
+	wasmReposMu.Lock()
+	wasmRepos[url] = r
+	wasmReposMu.Unlock()
+
+	head := ""
+	if ref, err := repo.Head(); err == nil {
+		if mapping, ok := r.byGit[ref.Hash().String()]; ok {
+			head = mapping.MGitHash
+		}
+	}
+	return map[string]interface{}{
+		"repo":     url,
+		"name":     info.Name,
+		"access":   info.Access,
+		"head":     head,
+		"mappings": len(r.mappings),
+	}, nil
+}
+
+// wasmLog lists the MGit commits reachable from HEAD, newest first
+func wasmLog(args []js.Value) (interface{}, error) {
+	r, err := wasmRepoArg(args)
+	if err != nil {
+		return nil, err
+	}
+	limit := 10
+	if value := wasmOption(args, 1, "limit"); value.Type() == js.TypeNumber && value.Int() > 0 {
+		limit = value.Int()
+	}
+
+	byGit := r.mgitHashes()
+	iter, err := r.repo.Log(&git.LogOptions{Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	type logEntry struct {
+		*mgitlib.Commit
+		Notes CommitNotes `json:"notes,omitempty"`
+	}
+	commits := []logEntry{}
+	err = iter.ForEach(func(commit *object.Commit) error {
+		if len(commits) >= limit {
+			return io.EOF
+		}
+		mapping, ok := r.byGit[commit.Hash.String()]
+		if !ok {
+			// Made with plain git; there is no MGit commit to show
+			return nil
+		}
+		mgitCommit, _ := mgitlib.RebuildCommit(commit, mapping, byGit)
+		commits = append(commits, logEntry{mgitCommit, r.notes[mapping.MGitHash]})
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	return map[string]interface{}{"commits": commits}, nil
+}
+
+// wasmFiles lists the files at HEAD
+func wasmFiles(args []js.Value) (interface{}, error) {
+	r, err := wasmRepoArg(args)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := r.headTree()
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	err = tree.Files().ForEach(func(file *object.File) error {
+		files = append(files, file.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing files: %w", err)
+	}
+	return map[string]interface{}{"files": files}, nil
+}
+
+// wasmReadFile returns a file's content at HEAD
+func wasmReadFile(args []js.Value) (interface{}, error) {
+	r, err := wasmRepoArg(args)
+	if err != nil {
+		return nil, err
+	}
+	path := wasmArg(args, 1)
+	tree, err := r.headTree()
+	if err != nil {
+		return nil, err
+	}
+	file, err := tree.File(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return map[string]interface{}{"path": path, "content": content}, nil
+}
+
+// wasmVerify recomputes the MGit hash of every mapped commit in the clone,
+// as mgit verify --full does from the stored objects
+func wasmVerify(args []js.Value) (interface{}, error) {
+	r, err := wasmRepoArg(args)
+	if err != nil {
+		return nil, err
+	}
+	type failure struct {
+		MGitHash string `json:"mgit_hash"`
+		GitHash  string `json:"git_hash"`
+	}
+	byGit := r.mgitHashes()
+	failures := []failure{}
+	verified, missing := 0, 0
+	progress := wasmProgress(args, 1, "verify")
+	if progress != nil {
+		progress.Phase("Verifying commits", int64(len(r.mappings)))
+		defer progress.Finish()
+	}
+	for _, mapping := range r.mappings {
+		if progress != nil {
+			progress.Add(1)
+		}
+		commit, err := r.repo.CommitObject(plumbing.NewHash(mapping.GitHash))
+		if err != nil {
+			// Expected for shallow and single-branch clones
+			missing++
+			continue
+		}
+		if _, ok := mgitlib.RebuildCommit(commit, mapping, byGit); !ok {
+			failures = append(failures, failure{mapping.MGitHash, mapping.GitHash})
+			continue
+		}
+		verified++
+	}
+	return map[string]interface{}{
+		"valid":    len(failures) == 0,
+		"verified": verified,
+		"missing":  missing,
+		"failures": failures,
+	}, nil
+}
+
+// wasmClose drops a repository from memory
+func wasmClose(args []js.Value) (interface{}, error) {
+	r, err := wasmRepoArg(args)
+	if err != nil {
+		return nil, err
+	}
+	wasmReposMu.Lock()
+	delete(wasmRepos, r.url)
+	wasmReposMu.Unlock()
+	return nil, nil
+}
+
+// mgitHashes maps git hashes to MGit hashes, as mgitlib.RebuildCommit wants
+func (r *wasmRepo) mgitHashes() map[string]string {
+	byGit := make(map[string]string, len(r.mappings))
+	for _, mapping := range r.mappings {
+		byGit[mapping.GitHash] = mapping.MGitHash
+	}
+	return byGit
+}
+
+// headTree returns the tree of the clone's HEAD commit
+func (r *wasmRepo) headTree() (*object.Tree, error) {
+	ref, err := r.repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("error reading HEAD: %w", err)
+	}
+	commit, err := r.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("error reading HEAD commit: %w", err)
+	}
+	return commit.Tree()
+}
@@ -0,0 +1,9 @@
+//go:build !(js && wasm)
+
+package cli
+
+// wasmBuild reports whether mgit was built for the browser (see wasm.go)
+const wasmBuild = false
+
+// serveWASM is only available in the js/wasm build
+func serveWASM() {}
@@ -0,0 +1,220 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RemoteEvent is a single event from the server's event stream
+type RemoteEvent struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"` // e.g. push, branch, permission
+	Data string `json:"data"`
+}
+
+// WatchOptions controls how remote events are delivered
+type WatchOptions struct {
+	JSON    bool   // Print events as JSON lines instead of text
+	Exec    string // Command to run for each event, with the event as JSON on stdin
+	Retries int    // Reconnect attempts after the stream drops (0 = forever)
+}
+
+// HandleWatchRemote handles the watch-remote command
+func HandleWatchRemote(args []string) {
+	opts := &WatchOptions{}
+	remoteName := "origin"
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--json":
+			opts.JSON = true
+		case "--exec":
+			if i+1 >= len(args) {
+				fmt.Println("Error: --exec requires a command")
+				os.Exit(1)
+			}
+			opts.Exec = args[i+1]
+			i++
+		case "--retries":
+			if i+1 >= len(args) {
+				fmt.Println("Error: --retries requires a number")
+				os.Exit(1)
+			}
+			fmt.Sscanf(args[i+1], "%d", &opts.Retries)
+			i++
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				fmt.Println("Usage: mgit watch-remote [--json] [--exec <command>] [--retries <n>] [<remote>]")
+				os.Exit(1)
+			}
+			remoteName = args[i]
+		}
+	}
+
+	repo := getRepo()
+	remote, err := repo.Remote(remoteName)
+	if err != nil || len(remote.Config().URLs) == 0 {
+		fmt.Printf("Error: remote '%s' not found\n", remoteName)
+		os.Exit(1)
+	}
+	remoteURL := remote.Config().URLs[0]
+	auth := authForRepo(remoteURL)
+
+	eventsURL := repoEndpoint(remoteURL, "/events")
+
+	if err := watchRemoteEvents(eventsURL, auth, opts, func(event *RemoteEvent) {
+		deliverRemoteEvent(event, opts)
+	}); err != nil {
+		fmt.Printf("Error watching remote: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// watchRemoteEvents subscribes to a server-sent event stream and calls handle
+// for each event, reconnecting (and resuming from the last event ID) when the
+// connection drops
+func watchRemoteEvents(eventsURL string, auth *Credentials, opts *WatchOptions, handle func(*RemoteEvent)) error {
+	lastID := ""
+	failures := 0
+	backoff := time.Second
+
+	for {
+		received, err := streamRemoteEvents(eventsURL, auth, lastID, func(event *RemoteEvent) {
+			if event.ID != "" {
+				lastID = event.ID
+			}
+			handle(event)
+		})
+		if received {
+			// A working connection resets the retry budget
+			failures = 0
+			backoff = time.Second
+		}
+
+		failures++
+		if opts.Retries > 0 && failures > opts.Retries {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("event stream closed")
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Event stream interrupted: %s (reconnecting in %s)\n", err, backoff)
+		}
+		time.Sleep(backoff)
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// streamRemoteEvents reads one connection's worth of events. It reports
+// whether any event was received before the stream ended.
+func streamRemoteEvents(eventsURL string, auth *Credentials, lastID string, handle func(*RemoteEvent)) (bool, error) {
+	req, err := http.NewRequest("GET", eventsURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %w", err)
+	}
+	auth.setHeader(req)
+	req.Header.Add("Accept", "text/event-stream")
+	if lastID != "" {
+		req.Header.Add("Last-Event-ID", lastID)
+	}
+
+	client := serverHTTPClient(eventsURL, 0)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("error response from server: %s", string(bodyBytes))
+	}
+
+	received := false
+	err = parseEventStream(resp.Body, func(event *RemoteEvent) {
+		received = true
+		handle(event)
+	})
+	return received, err
+}
+
+// parseEventStream parses the text/event-stream format: "field: value" lines,
+// with a blank line ending each event. Comment lines (":") are keep-alives.
+func parseEventStream(r io.Reader, handle func(*RemoteEvent)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	event := &RemoteEvent{}
+	data := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			if len(data) > 0 {
+				event.Data = strings.Join(data, "\n")
+				if event.Type == "" {
+					event.Type = "message"
+				}
+				handle(event)
+			}
+			event = &RemoteEvent{}
+			data = []string{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if idx := strings.Index(line, ":"); idx != -1 {
+			field = line[:idx]
+			value = strings.TrimPrefix(line[idx+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			event.Type = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			event.ID = value
+		}
+	}
+
+	return scanner.Err()
+}
+
+// deliverRemoteEvent prints an event and, if configured, forwards it to a command
+func deliverRemoteEvent(event *RemoteEvent, opts *WatchOptions) {
+	encoded, _ := json.Marshal(event)
+
+	if opts.JSON {
+		fmt.Println(string(encoded))
+	} else {
+		fmt.Printf("[%s] %s: %s\n", time.Now().Format("15:04:05"), event.Type, event.Data)
+	}
+
+	if opts.Exec == "" {
+		return
+	}
+
+	cmd := exec.Command("sh", "-c", opts.Exec)
+	cmd.Stdin = strings.NewReader(string(encoded) + "\n")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "MGIT_EVENT_TYPE="+event.Type, "MGIT_EVENT_ID="+event.ID)
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: event handler failed: %s\n", err)
+	}
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// Per-commit work over a whole history (hash recomputation, object reads and
+// writes) runs on a bounded pool of mgit.jobs goroutines, one per CPU by
+// default. A go-git repository handle is not safe for concurrent use, so each
+// worker reads git objects through a handle of its own.
+
+// jobCount returns how many workers to run
+func jobCount() int {
+	if jobs := GetConfigInt("mgit.jobs", runtime.NumCPU()); jobs > 0 {
+		return jobs
+	}
+	return runtime.NumCPU()
+}
+
+// runJobs calls fn for every index in [0, n) on up to jobCount() goroutines
+// and waits for them. worker identifies the goroutine running fn, from 0 to
+// jobCount()-1; fn must only write to state owned by its index or worker.
+func runJobs(n int, fn func(worker, i int)) {
+	runJobsContext(context.Background(), n, fn)
+}
+
+// runJobsContext is runJobs that stops handing out indexes once ctx is
+// done, returning ctx's error when some were never run
+func runJobsContext(ctx context.Context, n int, fn func(worker, i int)) error {
+	workers := jobCount()
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fn(0, i)
+		}
+		return nil
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := range next {
+				fn(worker, i)
+			}
+		}(w)
+	}
+	var err error
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	return err
+}
+
+// workerRepos hands each worker its own handle on the git repository at path
+type workerRepos struct {
+	path  string
+	repos []*git.Repository
+}
+
+// newWorkerRepos returns handles for runJobs' workers; worker 0 uses primary
+func newWorkerRepos(path string, primary *git.Repository) *workerRepos {
+	repos := make([]*git.Repository, jobCount())
+	repos[0] = primary
+	return &workerRepos{path: path, repos: repos}
+}
+
+// get returns worker's handle, opening it on first use
+func (w *workerRepos) get(worker int) (*git.Repository, error) {
+	if w.repos[worker] == nil {
+		repo, err := git.PlainOpen(w.path)
+		if err != nil {
+			return nil, err
+		}
+		w.repos[worker] = repo
+	}
+	return w.repos[worker], nil
+}
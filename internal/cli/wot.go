@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/pkg/mgitlib"
+	"github.com/imyjimmy/mgit/pkg/nostr"
+)
+
+// mgit verify --wot checks that every commit's pubkey is in a trust set,
+// the union of the sources listed in wot.sources (default "follows,config"):
+//
+//	follows  user.pubkey and the keys in its NIP-02 follow list on nostr.relays
+//	config   the keys in wot.trusted, which may be given more than once
+//	server   the trusted_pubkeys the origin server's repository info lists
+//
+// Key rotations recorded in .mgit/rotations carry trust both ways between a
+// retired key and its successor, but commits by a retired key dated after
+// its rotation are never trusted.
+
+// defaultWotSources are the trust set sources used when wot.sources is unset
+const defaultWotSources = "follows,config"
+
+// TrustSet is a set of trusted pubkeys (hex), each with the sources that
+// trust it
+type TrustSet struct {
+	Keys map[string][]string
+	// Counts is how many keys each source added, in wot.sources order
+	Counts []TrustSetCount
+
+	rotations map[string]*KeyRotation
+	trusted   map[string]bool
+}
+
+// TrustSetCount is how many keys one source contributed
+type TrustSetCount struct {
+	Source string
+	Keys   int
+}
+
+// followRotations extends the set along key rotations: a key is trusted
+// when the key it replaced, or the key that replaced it, is
+func (t *TrustSet) followRotations(rotations map[string]*KeyRotation) {
+	t.rotations = rotations
+	t.trusted = map[string]bool{}
+	for key := range t.Keys {
+		t.trusted[key] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, rotation := range rotations {
+			if t.trusted[rotation.OldKey()] != t.trusted[rotation.NewKey()] {
+				t.trusted[rotation.OldKey()], t.trusted[rotation.NewKey()] = true, true
+				changed = true
+			}
+		}
+	}
+}
+
+// TrustsCommit reports whether a commit made with a pubkey (npub or hex) at
+// the given time is trusted, and if not, any reason beyond the key not
+// being in the set
+func (t *TrustSet) TrustsCommit(pubkey string, when time.Time) (bool, string) {
+	hexKey, err := nostr.PubkeyHex(pubkey)
+	if err != nil {
+		return false, ""
+	}
+	if rotation := t.rotations[hexKey]; rotation != nil && when.After(rotation.When()) {
+		return false, fmt.Sprintf("made after the key was retired on %s", rotation.When().Format("2006-01-02"))
+	}
+	if t.trusted == nil {
+		return len(t.Keys[hexKey]) > 0, ""
+	}
+	return t.trusted[hexKey], ""
+}
+
+// add puts keys in the set on behalf of a source
+func (t *TrustSet) add(source string, keys []string) error {
+	for _, key := range keys {
+		hexKey, err := nostr.PubkeyHex(key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		t.Keys[hexKey] = appendUnique(t.Keys[hexKey], source)
+	}
+	t.Counts = append(t.Counts, TrustSetCount{Source: source, Keys: len(keys)})
+	return nil
+}
+
+// LoadTrustSet builds the trust set from the sources in wot.sources
+func LoadTrustSet() (*TrustSet, error) {
+	set := &TrustSet{Keys: map[string][]string{}}
+	for _, name := range strings.Split(GetConfigValue("wot.sources", defaultWotSources), ",") {
+		var keys []string
+		var err error
+		switch name = strings.TrimSpace(name); name {
+		case "":
+			continue
+		case "follows":
+			keys, err = followedKeys()
+		case "config":
+			keys = GetConfigValues("wot.trusted")
+		case "server":
+			keys, err = serverTrustedKeys()
+		default:
+			return nil, fmt.Errorf("unknown trust set source '%s' in wot.sources (expected follows, config or server)", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := set.add(name, keys); err != nil {
+			return nil, err
+		}
+	}
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("the trust set is empty (set user.pubkey and follow keys on nostr, or list keys in wot.trusted)")
+	}
+	return set, nil
+}
+
+// followedKeys returns user.pubkey and the keys its newest follow list on
+// the configured relays follows
+func followedKeys() ([]string, error) {
+	pubkey := GetNostrPubKey()
+	if pubkey == "" {
+		return nil, fmt.Errorf("user.pubkey is not set")
+	}
+	hexKey, err := nostr.PubkeyHex(pubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user.pubkey: %w", err)
+	}
+	relays := configuredRelays()
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("no relays configured (add one with mgit relay add <url>)")
+	}
+	events, err := queryRelays(NostrFilter{"kinds": []int{nostr.KindFollowList}, "authors": []string{hexKey}}, relays)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching follow list: %w", err)
+	}
+
+	keys := []string{hexKey}
+	var newest *nostr.Event
+	for _, event := range events {
+		if event.Pubkey == hexKey && (newest == nil || event.CreatedAt > newest.CreatedAt) {
+			newest = event
+		}
+	}
+	if newest == nil {
+		return keys, nil
+	}
+	for _, tag := range newest.Tags {
+		if len(tag) >= 2 && tag[0] == "p" {
+			if followed, err := nostr.PubkeyHex(tag[1]); err == nil {
+				keys = appendUnique(keys, followed)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// serverTrustedKeys returns the keys the origin server's policy trusts
+func serverTrustedKeys() ([]string, error) {
+	remote, err := getRepo().Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return nil, fmt.Errorf("no origin remote configured")
+	}
+	remoteURL := remote.Config().URLs[0]
+	auth, err := lookupAuth(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fetchRepositoryInfo(commandContext(), remoteURL, auth)
+	if err != nil {
+		return nil, err
+	}
+	return info.TrustedPubkeys, nil
+}
+
+// describe summarizes where the set's keys came from
+func (t *TrustSet) describe() string {
+	parts := []string{}
+	for _, count := range t.Counts {
+		parts = append(parts, fmt.Sprintf("%s: %d", count.Source, count.Keys))
+	}
+	noun := "keys"
+	if len(t.Keys) == 1 {
+		noun = "key"
+	}
+	description := fmt.Sprintf("%d %s (%s)", len(t.Keys), noun, strings.Join(parts, ", "))
+	if len(t.rotations) == 1 {
+		description += ", following 1 key rotation"
+	} else if len(t.rotations) > 1 {
+		description += fmt.Sprintf(", following %d key rotations", len(t.rotations))
+	}
+	return description
+}
+
+// reportUntrusted prints the commits whose pubkey is not in the set,
+// grouped by key, and returns how many there were
+func reportUntrusted(set *TrustSet, commits []*mgitlib.Commit) int {
+	byKey := map[string][]*mgitlib.Commit{}
+	reasons := map[string]string{}
+	for _, commit := range commits {
+		if trusted, reason := set.TrustsCommit(commit.Author.Pubkey, commit.Author.When); !trusted {
+			key := canonicalNostrPubKey(commit.Author.Pubkey)
+			byKey[key] = append(byKey[key], commit)
+			reasons[commit.MGitHash] = reason
+		}
+	}
+	keys := []string{}
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	// The keys with the most commits first
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := byKey[keys[i]], byKey[keys[j]]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return keys[i] < keys[j]
+	})
+
+	untrusted := 0
+	for _, key := range keys {
+		list := byKey[key]
+		untrusted += len(list)
+		author := currentIdentityMap().Resolve(list[0].Author.Name, list[0].Author.Email)
+		if key == "" {
+			fmt.Printf("No pubkey: %d commit(s) by %s <%s>\n", len(list), author.Name, author.Email)
+		} else {
+			fmt.Printf("Untrusted key %s: %d commit(s) by %s <%s>\n", key, len(list), author.Name, author.Email)
+		}
+		for i, commit := range list {
+			if i == 5 {
+				fmt.Printf("  ... and %d more\n", len(list)-i)
+				break
+			}
+			if reason := reasons[commit.MGitHash]; reason != "" {
+				fmt.Printf("  %s %s (%s)\n", shortHash(commit.MGitHash), commitSubject(commit.Message), reason)
+			} else {
+				fmt.Printf("  %s %s\n", shortHash(commit.MGitHash), commitSubject(commit.Message))
+			}
+		}
+	}
+	return untrusted
+}
@@ -0,0 +1,137 @@
+// Package fsutil holds the crash-safe file writes and lock files mgit and
+// its packages keep their state with. A file is written to a temporary file
+// next to it, synced and renamed over the old one, so readers and crashes
+// only ever see the old or the new content. A read-modify-write update
+// holds an exclusively created <file>.lock for its duration, so two
+// processes can't interleave it.
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockWait is how long a lock waits for another process to release it
+const LockWait = 10 * time.Second
+
+// WriteFile writes data to a temporary file next to path and renames it
+// into place. The file keeps its mode, and a new one is made 0644.
+func WriteFile(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return writeFile(path, data, mode)
+}
+
+// WritePrivateFile is WriteFile for files only the user may read, such as
+// keys and tokens
+func WritePrivateFile(path string, data []byte) error {
+	return writeFile(path, data, 0600)
+}
+
+// writeFile writes a file atomically with the given mode, set on the
+// temporary file before anything is written to it
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp_"+filepath.Base(path)+"_")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(path), err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(path), err)
+	}
+	if err := WriteAndSync(tmp, data); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// WriteAndSync writes data to file, flushes it to disk and closes it
+func WriteAndSync(file *os.File, data []byte) error {
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Lock is a held lock file
+type Lock struct {
+	path string // The lock file itself
+	file *os.File
+	done bool
+}
+
+// CreateLock creates the lock file path, failing with an error os.IsExist
+// recognises when another process holds it
+func CreateLock(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{path: path, file: file}, nil
+}
+
+// LockFile takes the lock for path, <path>.lock, waiting up to wait for
+// another process to release it
+func LockFile(path string, wait time.Duration) (*Lock, error) {
+	lockPath := path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(lockPath), err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		lock, err := CreateLock(lockPath)
+		if err == nil {
+			return lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is locked by another process (remove %s if it crashed)", path, lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// File returns the open lock file, for content about its holder
+func (l *Lock) File() *os.File {
+	return l.file
+}
+
+// Commit writes data to the lock file and renames it over path, as git
+// commits a lock file, which releases the lock
+func (l *Lock) Commit(path string, data []byte) error {
+	if err := WriteAndSync(l.file, data); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, path); err != nil {
+		return err
+	}
+	l.done = true
+	return nil
+}
+
+// Release removes the lock file
+func (l *Lock) Release() {
+	if !l.done {
+		l.file.Close()
+		os.Remove(l.path)
+		l.done = true
+	}
+}
@@ -0,0 +1,78 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteFileModes(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		write func(string, []byte) error
+		start os.FileMode // 0 for a new file
+		want  os.FileMode
+	}{
+		{"new", WriteFile, 0, 0644},
+		{"existing", WriteFile, 0755, 0755},
+		{"private", WritePrivateFile, 0644, 0600},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if tt.start != 0 {
+			if err := os.WriteFile(path, []byte("old"), tt.start); err != nil {
+				t.Fatal(err)
+			}
+			os.Chmod(path, tt.start)
+		}
+		if err := tt.write(path, []byte("new")); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != tt.want {
+			t.Errorf("%s: mode %v, want %v", tt.name, info.Mode().Perm(), tt.want)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "new" {
+			t.Errorf("%s: content %q, want new", tt.name, data)
+		}
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	lock, err := LockFile(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockFile(path, 50*time.Millisecond); err == nil {
+		t.Fatal("took a lock that is held")
+	}
+	if _, err := CreateLock(path + ".lock"); !os.IsExist(err) {
+		t.Errorf("CreateLock of a held lock = %v, want an os.IsExist error", err)
+	}
+
+	if err := lock.Commit(path, []byte("committed")); err != nil {
+		t.Fatal(err)
+	}
+	lock.Release()
+	if data, _ := os.ReadFile(path); string(data) != "committed" {
+		t.Errorf("content %q after Commit, want committed", data)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Error("the lock file was left behind")
+	}
+
+	again, err := LockFile(path, 0)
+	if err != nil {
+		t.Fatalf("lock after Commit: %s", err)
+	}
+	again.Release()
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Error("Release left the lock file behind")
+	}
+}
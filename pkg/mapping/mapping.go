@@ -0,0 +1,355 @@
+// Package mapping keeps an MGit repository's hash mappings: which MGit
+// commit hash each git commit has, and whose nostr key made it. They are
+// stored as a JSON array in .mgit/mappings/hash_mappings.json.
+//
+// A Store is the process-wide view of one mappings file. It is read and
+// parsed once, on first use; every write goes through the store and
+// updates the cached copy, so callers can look mappings up as often as
+// they like without touching the disk again. Writes lock the file against
+// other processes and re-read it first.
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/imyjimmy/mgit/internal/fsutil"
+)
+
+// Mapping ties a git commit to its MGit commit and the nostr key that made it
+type Mapping struct {
+	GitHash  string `json:"git_hash"`
+	MGitHash string `json:"mgit_hash"`
+	Pubkey   string `json:"pubkey"`
+	// Source records where the mapping came from; empty for mappings
+	// recorded before sources were tracked
+	Source string `json:"source,omitempty"`
+}
+
+// Mapping sources
+const (
+	// SourceLocal is a mapping made by a commit or migrate here
+	SourceLocal = "local"
+	// SourceServer is a mapping fetched from the server
+	SourceServer = "server"
+	// SourceReconstructed is a mapping rebuilt from a git commit after the
+	// original was lost
+	SourceReconstructed = "reconstructed"
+	// SourceBundle is a mapping imported from an MGit bundle
+	SourceBundle = "bundle"
+)
+
+// Path returns the mappings file of the repository whose .mgit directory
+// is root
+func Path(root string) string {
+	return filepath.Join(root, "mappings", "hash_mappings.json")
+}
+
+// Store is the shared view of one mappings file
+type Store struct {
+	path string
+
+	mu       sync.RWMutex
+	loaded   bool
+	mappings []Mapping
+	byGit    map[string]int
+	byMGit   map[string]int
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = map[string]*Store{}
+)
+
+// StoreFor returns the shared store for the mappings file at path
+func StoreFor(path string) *Store {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	store, ok := stores[path]
+	if !ok {
+		store = &Store{path: path}
+		stores[path] = store
+	}
+	return store
+}
+
+// Path returns the store's mappings file
+func (m *Store) Path() string {
+	return m.path
+}
+
+// load reads the mappings file if it has not been read yet. The caller must
+// hold the write lock.
+func (m *Store) load() error {
+	if m.loaded {
+		return nil
+	}
+
+	mappings := []Mapping{}
+	data, err := os.ReadFile(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read hash mappings: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &mappings); err != nil {
+			return fmt.Errorf("failed to unmarshal hash mappings: %w", err)
+		}
+	}
+
+	m.set(mappings)
+	return nil
+}
+
+// set replaces the cached mappings and rebuilds the lookup indexes. Later
+// entries win, as they do for a linear scan from the end.
+func (m *Store) set(mappings []Mapping) {
+	m.mappings = mappings
+	m.byGit = make(map[string]int, len(mappings))
+	m.byMGit = make(map[string]int, len(mappings))
+	for i, mapping := range mappings {
+		m.byGit[mapping.GitHash] = i
+		m.byMGit[mapping.MGitHash] = i
+	}
+	m.loaded = true
+}
+
+// read runs fn with the mappings loaded and the read lock held
+func (m *Store) read(fn func()) error {
+	m.mu.RLock()
+	if m.loaded {
+		defer m.mu.RUnlock()
+		fn()
+		return nil
+	}
+	m.mu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.load(); err != nil {
+		return err
+	}
+	fn()
+	return nil
+}
+
+// All returns a copy of every mapping, in file order
+func (m *Store) All() ([]Mapping, error) {
+	var mappings []Mapping
+	err := m.read(func() {
+		mappings = append([]Mapping{}, m.mappings...)
+	})
+	return mappings, err
+}
+
+// ByGit looks up the mapping for a git commit hash
+func (m *Store) ByGit(gitHash string) (Mapping, bool, error) {
+	var mapping Mapping
+	var ok bool
+	err := m.read(func() {
+		var i int
+		if i, ok = m.byGit[gitHash]; ok {
+			mapping = m.mappings[i]
+		}
+	})
+	return mapping, ok, err
+}
+
+// ByMGit looks up the mapping for an MGit commit hash
+func (m *Store) ByMGit(mgitHash string) (Mapping, bool, error) {
+	var mapping Mapping
+	var ok bool
+	err := m.read(func() {
+		var i int
+		if i, ok = m.byMGit[mgitHash]; ok {
+			mapping = m.mappings[i]
+		}
+	})
+	return mapping, ok, err
+}
+
+// Lookup finds the mapping for either a git or an MGit hash
+func (m *Store) Lookup(hash string) (Mapping, bool, error) {
+	mapping, ok, err := m.ByGit(hash)
+	if err != nil || ok {
+		return mapping, ok, err
+	}
+	return m.ByMGit(hash)
+}
+
+// Put adds a mapping, replacing any entry with the same git or MGit hash,
+// and writes the result to disk
+func (m *Store) Put(mapping Mapping) error {
+	unlock, err := m.lockForUpdate()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return m.write(m.withMapping(mapping))
+}
+
+// PutAll is Put for several mappings at once, with a single write
+func (m *Store) PutAll(added []Mapping) error {
+	unlock, err := m.lockForUpdate()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	mappings := append([]Mapping{}, m.mappings...)
+	byGit := make(map[string]int, len(m.byGit))
+	byMGit := make(map[string]int, len(m.byMGit))
+	for i, mapping := range mappings {
+		byGit[mapping.GitHash] = i
+		byMGit[mapping.MGitHash] = i
+	}
+	for _, mapping := range added {
+		i, found := byGit[mapping.GitHash]
+		if !found {
+			i, found = byMGit[mapping.MGitHash]
+		}
+		if found {
+			mappings[i] = mapping
+		} else {
+			i = len(mappings)
+			mappings = append(mappings, mapping)
+		}
+		byGit[mapping.GitHash] = i
+		byMGit[mapping.MGitHash] = i
+	}
+	return m.write(mappings)
+}
+
+// Update is a Put whose file is written by the caller, as part of a larger
+// set of writes. The store stays locked until Release.
+type Update struct {
+	store    *Store
+	mappings []Mapping
+	unlock   func()
+}
+
+// BeginPut locks the store and prepares Put's change for the caller to
+// write
+func (m *Store) BeginPut(mapping Mapping) (*Update, error) {
+	unlock, err := m.lockForUpdate()
+	if err != nil {
+		return nil, err
+	}
+	return &Update{store: m, mappings: m.withMapping(mapping), unlock: unlock}, nil
+}
+
+// Data returns the mappings file's new content
+func (u *Update) Data() ([]byte, error) {
+	return encode(u.mappings)
+}
+
+// Done makes the new mappings the cached copy, once the caller has written
+// Data to the store's file
+func (u *Update) Done() {
+	u.store.set(u.mappings)
+}
+
+// Release unlocks the store; an update that isn't Done leaves it as it was
+func (u *Update) Release() {
+	if u.unlock != nil {
+		u.unlock()
+		u.unlock = nil
+	}
+}
+
+// lockForUpdate takes the store's write lock and the mappings file's lock,
+// and re-reads the file, which another process may have changed. The
+// returned function releases both.
+func (m *Store) lockForUpdate() (func(), error) {
+	m.mu.Lock()
+	lock, err := fsutil.LockFile(m.path, fsutil.LockWait)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.loaded = false
+	if err := m.load(); err != nil {
+		lock.Release()
+		m.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		lock.Release()
+		m.mu.Unlock()
+	}, nil
+}
+
+// withMapping returns the cached mappings with mapping added or replacing
+// the entry for the same git or MGit hash. The caller must hold the write
+// lock.
+func (m *Store) withMapping(mapping Mapping) []Mapping {
+	mappings := append([]Mapping{}, m.mappings...)
+	i, found := m.byGit[mapping.GitHash]
+	if !found {
+		i, found = m.byMGit[mapping.MGitHash]
+	}
+	if found {
+		mappings[i] = mapping
+	} else {
+		mappings = append(mappings, mapping)
+	}
+	return mappings
+}
+
+// Replace writes mappings as the complete mapping set
+func (m *Store) Replace(mappings []Mapping) error {
+	unlock, err := m.lockForUpdate()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return m.write(append([]Mapping{}, mappings...))
+}
+
+// Invalidate drops the cached copy, so the next lookup re-reads the file.
+// Only needed when something outside the store has changed the file.
+func (m *Store) Invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loaded = false
+	m.mappings = nil
+	m.byGit = nil
+	m.byMGit = nil
+}
+
+// write saves mappings to disk and, once that succeeded, makes them the
+// cached copy. The caller must hold the write lock.
+func (m *Store) write(mappings []Mapping) error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to create mapping directory: %w", err)
+	}
+
+	data, err := encode(mappings)
+	if err != nil {
+		return err
+	}
+	// Written to a temporary file and renamed, so the file on disk is
+	// always either the old or the new set
+	if err := fsutil.WriteFile(m.path, data); err != nil {
+		return fmt.Errorf("failed to write hash mappings: %w", err)
+	}
+
+	m.set(mappings)
+	return nil
+}
+
+// encode returns the mappings file's content
+func encode(mappings []Mapping) ([]byte, error) {
+	data, err := json.MarshalIndent(mappings, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal hash mappings: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,135 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorePutAndLookup(t *testing.T) {
+	store := StoreFor(Path(t.TempDir()))
+	first := Mapping{GitHash: "g1", MGitHash: "m1", Pubkey: "npub1a", Source: SourceLocal}
+	second := Mapping{GitHash: "g2", MGitHash: "m2", Pubkey: "npub1b", Source: SourceServer}
+	if err := store.Put(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutAll([]Mapping{second}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hash string
+		want Mapping
+	}{
+		{"g1", first},
+		{"m1", first},
+		{"g2", second},
+		{"m2", second},
+	}
+	for _, tt := range tests {
+		got, ok, err := store.Lookup(tt.hash)
+		if err != nil || !ok || got != tt.want {
+			t.Errorf("Lookup(%s) = %+v, %v, %v; want %+v", tt.hash, got, ok, err, tt.want)
+		}
+	}
+	if _, ok, err := store.ByGit("m1"); ok || err != nil {
+		t.Errorf("ByGit found an MGit hash")
+	}
+	if _, ok, err := store.Lookup("g3"); ok || err != nil {
+		t.Errorf("Lookup(g3) = %v, %v; want not found", ok, err)
+	}
+}
+
+func TestStorePutReplaces(t *testing.T) {
+	store := StoreFor(Path(t.TempDir()))
+	if err := store.PutAll([]Mapping{
+		{GitHash: "g1", MGitHash: "m1"},
+		{GitHash: "g2", MGitHash: "m2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The same git commit under a new MGit hash, and a new git commit for
+	// an MGit hash already mapped
+	if err := store.PutAll([]Mapping{
+		{GitHash: "g1", MGitHash: "m1b"},
+		{GitHash: "g2b", MGitHash: "m2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mapping{{GitHash: "g1", MGitHash: "m1b"}, {GitHash: "g2b", MGitHash: "m2"}}
+	if len(all) != len(want) || all[0] != want[0] || all[1] != want[1] {
+		t.Errorf("All() = %+v, want %+v", all, want)
+	}
+}
+
+func TestStoreRereadsFile(t *testing.T) {
+	path := Path(t.TempDir())
+	store := StoreFor(path)
+	if err := store.Put(Mapping{GitHash: "g1", MGitHash: "m1"}); err != nil {
+		t.Fatal(err)
+	}
+	if StoreFor(filepath.Join(filepath.Dir(path), ".", filepath.Base(path))) != store {
+		t.Error("StoreFor returned another store for the same file")
+	}
+
+	// Another process replaces the file
+	if err := os.WriteFile(path, []byte(`[{"git_hash":"g9","mgit_hash":"m9","pubkey":""}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := store.ByGit("g9"); ok {
+		t.Error("lookup read the file again without Invalidate")
+	}
+	store.Invalidate()
+	if _, ok, err := store.ByGit("g9"); !ok || err != nil {
+		t.Errorf("ByGit(g9) after Invalidate = %v, %v; want found", ok, err)
+	}
+
+	// A write re-reads the file first, keeping what the other process added
+	if err := store.Put(Mapping{GitHash: "g2", MGitHash: "m2"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Invalidate()
+	all, err := store.All()
+	if err != nil || len(all) != 2 {
+		t.Errorf("All() = %+v, %v; want g9 and g2", all, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	store := StoreFor(Path(t.TempDir()))
+	update, err := store.BeginPut(Mapping{GitHash: "g1", MGitHash: "m1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := update.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(store.Path()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.Path(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	update.Done()
+	update.Release()
+
+	if _, ok, err := store.ByMGit("m1"); !ok || err != nil {
+		t.Errorf("ByMGit(m1) = %v, %v; want found", ok, err)
+	}
+
+	// An update released without Done changes nothing
+	update, err = store.BeginPut(Mapping{GitHash: "g2", MGitHash: "m2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update.Release()
+	if _, ok, _ := store.ByGit("g2"); ok {
+		t.Error("an abandoned update was applied")
+	}
+}
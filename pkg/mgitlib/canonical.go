@@ -0,0 +1,225 @@
+package mgitlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MGit commits are stored in a canonical text encoding, close to git's own
+// commit format:
+//
+//	mgit-commit <format>
+//	tree <tree hash>
+//	parent <MGit parent hash>            one line per parent, in order
+//	author <name> <<email>> <unix time> <+hhmm> <pubkey>
+//	committer <name> <<email>> <unix time> <+hhmm> <pubkey>
+//	git <git commit hash>
+//	meta <key> <quoted value>             sorted by key
+//	<empty line>
+//	<message, verbatim>
+//
+// Names and emails lose any '<', '>' and newline characters, as in git. An
+// empty pubkey is written as nothing (the line then ends after the offset).
+//
+// The MGit hash depends on the format:
+//
+//	1  SHA-1 of the encoding without its git and meta lines, so it can be
+//	   recomputed from the stored object or from the git commit and pubkey
+//	0  the formula used before the canonical encoding existed, computed from
+//	   the same fields; existing commits keep their hashes this way
+//
+// Both are computed in hash.go.
+//
+// New commits are written in format 1. Commits stored as JSON by earlier
+// versions are still read, and mgit gc converts them to format 0.
+
+const (
+	commitHeader = "mgit-commit "
+
+	// FormatLegacy and FormatCurrent are the encodings' versions
+	FormatLegacy  = 0
+	FormatCurrent = 1
+)
+
+// IsEncodedCommit reports whether a stored body is in the canonical
+// encoding rather than JSON
+func IsEncodedCommit(body []byte) bool {
+	return bytes.HasPrefix(body, []byte(commitHeader))
+}
+
+// Encode returns the canonical encoding of a commit. Without full, the git
+// and meta lines are left out, which gives the bytes a format 1 hash
+// covers.
+func (c *Commit) Encode(full bool) []byte {
+	in := c.HashInput()
+	var buf bytes.Buffer
+	writeHashedHeader(&buf, in)
+	if full {
+		if c.GitHash != "" {
+			fmt.Fprintf(&buf, "git %s\n", c.GitHash)
+		}
+		keys := make([]string, 0, len(c.Metadata))
+		for key := range c.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "meta %s %s\n", SanitizeIdent(strings.ReplaceAll(key, " ", "_")), strconv.Quote(c.Metadata[key]))
+		}
+	}
+	buf.WriteString("\n")
+	buf.WriteString(in.Message)
+	return buf.Bytes()
+}
+
+// writeHashedHeader writes the header lines a format 1 hash covers
+func writeHashedHeader(buf *bytes.Buffer, in HashInput) {
+	fmt.Fprintf(buf, "%s%d\n", commitHeader, in.Format)
+	fmt.Fprintf(buf, "tree %s\n", in.Tree)
+	for _, parent := range in.Parents {
+		fmt.Fprintf(buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(buf, "author %s\n", encodeSignature(in.Author))
+	fmt.Fprintf(buf, "committer %s\n", encodeSignature(in.Committer))
+}
+
+// encodeSignature formats a signature line's value
+func encodeSignature(sig *Signature) string {
+	if sig == nil {
+		sig = &Signature{}
+	}
+	line := fmt.Sprintf("%s <%s> %d %s", SanitizeIdent(sig.Name), SanitizeIdent(sig.Email),
+		sig.When.Unix(), sig.When.Format("-0700"))
+	if sig.Pubkey != "" {
+		line += " " + SanitizeIdent(strings.ReplaceAll(sig.Pubkey, " ", ""))
+	}
+	return line
+}
+
+// SanitizeIdent drops the characters that would break a signature line
+func SanitizeIdent(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// DecodeCommit parses a canonical commit body. MGitHash is left for the
+// caller, who knows which hash the object was stored under.
+func DecodeCommit(body []byte) (*Commit, error) {
+	headerEnd := bytes.Index(body, []byte("\n\n"))
+	if headerEnd == -1 {
+		return nil, fmt.Errorf("commit has no message separator")
+	}
+	commit := &Commit{
+		Type:         CommitObject,
+		ParentHashes: []string{},
+		Message:      string(body[headerEnd+2:]),
+	}
+
+	lines := strings.Split(string(body[:headerEnd]), "\n")
+	format, err := strconv.Atoi(strings.TrimPrefix(lines[0], commitHeader))
+	if !strings.HasPrefix(lines[0], commitHeader) || err != nil {
+		return nil, fmt.Errorf("malformed commit header %q", lines[0])
+	}
+	if format != FormatLegacy && format != FormatCurrent {
+		return nil, fmt.Errorf("unsupported commit format %d", format)
+	}
+	commit.Format = format
+
+	for _, line := range lines[1:] {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			commit.TreeHash = value
+		case "parent":
+			commit.ParentHashes = append(commit.ParentHashes, value)
+		case "author", "committer":
+			sig, err := DecodeSignature(value)
+			if err != nil {
+				return nil, fmt.Errorf("malformed %s line: %w", key, err)
+			}
+			if key == "author" {
+				commit.Author = sig
+			} else {
+				commit.Committer = sig
+			}
+		case "git":
+			commit.GitHash = value
+		case "meta":
+			name, quoted, _ := strings.Cut(value, " ")
+			text, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("malformed meta line %q", line)
+			}
+			if commit.Metadata == nil {
+				commit.Metadata = map[string]string{}
+			}
+			commit.Metadata[name] = text
+		default:
+			return nil, fmt.Errorf("unknown commit line %q", line)
+		}
+	}
+	if commit.TreeHash == "" || commit.Author == nil || commit.Committer == nil {
+		return nil, fmt.Errorf("commit is missing its tree, author or committer")
+	}
+	return commit, nil
+}
+
+// DecodeSignature parses a signature line's value,
+// "<name> <<email>> <unix> <+hhmm> [pubkey]"
+func DecodeSignature(value string) (*Signature, error) {
+	open := strings.Index(value, " <")
+	end := strings.Index(value, "> ")
+	if open == -1 || end < open {
+		return nil, fmt.Errorf("missing <email>")
+	}
+	fields := strings.Fields(value[end+2:])
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("expected time, offset and optional pubkey")
+	}
+	unix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad time %q", fields[0])
+	}
+	offset, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("bad offset %q", fields[1])
+	}
+	_, seconds := offset.Zone()
+
+	sig := &Signature{
+		Name:  value[:open],
+		Email: value[open+2 : end],
+		When:  time.Unix(unix, 0).In(time.FixedZone("", seconds)),
+	}
+	if len(fields) == 3 {
+		sig.Pubkey = fields[2]
+	}
+	return sig, nil
+}
+
+// ParseCommit reads a commit body stored under hash, in either encoding
+func ParseCommit(hash string, body []byte) (*Commit, error) {
+	if IsEncodedCommit(body) {
+		commit, err := DecodeCommit(body)
+		if err != nil {
+			return nil, err
+		}
+		commit.MGitHash = hash
+		return commit, nil
+	}
+
+	var commit Commit
+	if err := json.Unmarshal(body, &commit); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal commit: %w", err)
+	}
+	return &commit, nil
+}
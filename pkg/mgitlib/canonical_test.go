@@ -0,0 +1,105 @@
+package mgitlib
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testCommit() *Commit {
+	when := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("", -5*3600))
+	return &Commit{
+		Type:         CommitObject,
+		Format:       FormatCurrent,
+		GitHash:      "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		TreeHash:     "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		ParentHashes: []string{"a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"},
+		Author:       &Signature{Name: "Alice", Email: "alice@example.com", Pubkey: "npub1alice", When: when},
+		Committer:    &Signature{Name: "Bob", Email: "bob@example.com", Pubkey: "npub1bob", When: when},
+		Message:      "Add records\n\nWith a body.\n",
+		Metadata:     map[string]string{"origin": "import"},
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	commit := testCommit()
+	commit.MGitHash = commit.ComputeHash()
+
+	body := commit.Encode(true)
+	if !IsEncodedCommit(body) {
+		t.Fatalf("IsEncodedCommit(%q) = false", body)
+	}
+	parsed, err := ParseCommit(commit.MGitHash, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.HashInput(), commit.HashInput()) ||
+		parsed.MGitHash != commit.MGitHash || parsed.GitHash != commit.GitHash ||
+		!reflect.DeepEqual(parsed.Metadata, commit.Metadata) {
+		t.Errorf("ParseCommit(Encode) = %+v, want %+v", parsed, commit)
+	}
+	if parsed.ComputeHash() != commit.MGitHash {
+		t.Errorf("decoded commit hashes to %s, want %s", parsed.ComputeHash(), commit.MGitHash)
+	}
+}
+
+func TestHashCoversEncoding(t *testing.T) {
+	commit := testCommit()
+	sum := sha1.Sum(commit.Encode(false))
+	if got, want := commit.ComputeHash(), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("ComputeHash() = %s, want the SHA-1 of Encode(false), %s", got, want)
+	}
+}
+
+func TestHashInputs(t *testing.T) {
+	base := testCommit().ComputeHash()
+	changes := map[string]func(*Commit){
+		"message": func(c *Commit) { c.Message = "Something else\n" },
+		"pubkey":  func(c *Commit) { c.Author.Pubkey = "npub1mallory" },
+		"parent":  func(c *Commit) { c.ParentHashes = nil },
+		"tree":    func(c *Commit) { c.TreeHash = "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3" },
+	}
+	for name, change := range changes {
+		commit := testCommit()
+		change(commit)
+		if commit.ComputeHash() == base {
+			t.Errorf("changing the %s left the hash unchanged", name)
+		}
+	}
+
+	// Neither the git hash nor metadata is covered
+	commit := testCommit()
+	commit.GitHash = ""
+	commit.Metadata = nil
+	if commit.ComputeHash() != base {
+		t.Error("the git hash or metadata changed the hash")
+	}
+}
+
+func TestLegacyHash(t *testing.T) {
+	commit := testCommit()
+	commit.Format = FormatLegacy
+	legacy := commit.ComputeHash()
+	if legacy == testCommit().ComputeHash() {
+		t.Error("formats 0 and 1 give the same hash")
+	}
+	// Format 0 hashes never covered the message
+	commit.Message = "Something else\n"
+	if commit.ComputeHash() != legacy {
+		t.Error("the message changed a format 0 hash")
+	}
+}
+
+func TestDecodeCommitRejects(t *testing.T) {
+	for _, body := range []string{
+		"mgit-commit 1\ntree abc\n",
+		"mgit-commit 1\ntree abc\nauthor nobody\n\nmessage",
+		"mgit-commit x\n\nmessage",
+	} {
+		if commit, err := DecodeCommit([]byte(body)); err == nil {
+			t.Errorf("DecodeCommit(%q) = %+v, want an error", body, commit)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package mgitlib
+
+import (
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mapping"
+)
+
+// ObjectType is the kind of an object in the MGit object store
+type ObjectType string
+
+const (
+	CommitObject     ObjectType = "commit"
+	TreeObject       ObjectType = "tree"
+	BlobObject       ObjectType = "blob"
+	AnnotationObject ObjectType = "annotation"
+)
+
+// Commit is an MGit commit: a git commit whose hash also covers its
+// author's nostr key, with the MGit hashes of its parents in place of
+// their git hashes
+type Commit struct {
+	Type         ObjectType        `json:"type"`
+	Format       int               `json:"format,omitempty"` // Canonical encoding version, see canonical.go
+	MGitHash     string            `json:"mgit_hash"`
+	GitHash      string            `json:"git_hash"`
+	TreeHash     string            `json:"tree_hash"`
+	ParentHashes []string          `json:"parent_hashes"` // MGit hashes of parents
+	Author       *Signature        `json:"author"`
+	Committer    *Signature        `json:"committer"`
+	Message      string            `json:"message"`
+	Metadata     map[string]string `json:"metadata,omitempty"` // For extensibility
+}
+
+// Signature is an author or committer of an MGit commit
+type Signature struct {
+	Name   string    `json:"name"`
+	Email  string    `json:"email"`
+	Pubkey string    `json:"pubkey,omitempty"`
+	When   time.Time `json:"when"`
+}
+
+// SignatureFromGit returns a git signature with a nostr key added
+func SignatureFromGit(sig object.Signature, pubkey string) *Signature {
+	return &Signature{
+		Name:   sig.Name,
+		Email:  sig.Email,
+		Pubkey: pubkey,
+		When:   sig.When,
+	}
+}
+
+// CommitFromGit returns the MGit commit, in the current format, of a git
+// commit whose parents have the given MGit hashes. Its MGitHash is left
+// for the caller to compute or check.
+func CommitFromGit(commit *object.Commit, parentMGitHashes []string, pubkey string) *Commit {
+	in := GitHashInput(commit, parentMGitHashes, pubkey)
+	return &Commit{
+		Type:         CommitObject,
+		Format:       in.Format,
+		GitHash:      commit.Hash.String(),
+		TreeHash:     in.Tree,
+		ParentHashes: in.Parents,
+		Author:       in.Author,
+		Committer:    in.Committer,
+		Message:      in.Message,
+	}
+}
+
+// RebuildCommit rebuilds the MGit commit a mapping names from its git
+// commit, in whichever format its hash was made in. byGit maps git hashes
+// to MGit hashes; parents with no entry stand in by their git hash, as
+// they do on commit. ok is false if neither format reproduces the mapped
+// hash.
+func RebuildCommit(commit *object.Commit, m mapping.Mapping, byGit map[string]string) (rebuilt *Commit, ok bool) {
+	parentMGitHashes := []string{}
+	for _, parentGitHash := range commit.ParentHashes {
+		if parentMGitHash, ok := byGit[parentGitHash.String()]; ok {
+			parentMGitHashes = append(parentMGitHashes, parentMGitHash)
+		} else {
+			parentMGitHashes = append(parentMGitHashes, parentGitHash.String())
+		}
+	}
+
+	rebuilt = CommitFromGit(commit, parentMGitHashes, m.Pubkey)
+	rebuilt.MGitHash = m.MGitHash
+	if rebuilt.ComputeHash() != m.MGitHash {
+		rebuilt.Format = FormatLegacy
+	}
+	return rebuilt, rebuilt.ComputeHash() == m.MGitHash
+}
@@ -0,0 +1,116 @@
+package mgitlib
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Every MGit commit hash is computed by Hash from a HashInput. Commit,
+// verify, migrate, clone reconstruction and object reads all build the
+// input the same way, from either a stored MGit commit (Commit.HashInput)
+// or a git commit plus the MGit hashes of its parents and the author's
+// pubkey (GitHashInput), so verification can't drift from creation.
+
+// Tracef, when set, is given a line describing each hash computed, for
+// tracing hash mismatches
+var Tracef func(category, format string, args ...interface{})
+
+// HashInput is everything an MGit commit hash covers
+type HashInput struct {
+	Format    int        // FormatLegacy or FormatCurrent
+	Tree      string     // Git tree hash
+	Parents   []string   // MGit hashes of the parents, in order
+	Author    *Signature // Including the author's pubkey
+	Committer *Signature
+	Message   string // Not covered by format 0 hashes
+}
+
+// HashInput returns the hash input of a stored MGit commit
+func (c *Commit) HashInput() HashInput {
+	return HashInput{
+		Format:    c.Format,
+		Tree:      c.TreeHash,
+		Parents:   c.ParentHashes,
+		Author:    c.Author,
+		Committer: c.Committer,
+		Message:   c.Message,
+	}
+}
+
+// GitHashInput returns the hash input, in the current format, of a git
+// commit whose parents have the given MGit hashes. Both signatures carry
+// pubkey.
+func GitHashInput(commit *object.Commit, parentMGitHashes []string, pubkey string) HashInput {
+	if parentMGitHashes == nil {
+		parentMGitHashes = []string{}
+	}
+	return HashInput{
+		Format:    FormatCurrent,
+		Tree:      commit.TreeHash.String(),
+		Parents:   parentMGitHashes,
+		Author:    SignatureFromGit(commit.Author, pubkey),
+		Committer: SignatureFromGit(commit.Committer, pubkey),
+		Message:   commit.Message,
+	}
+}
+
+// Hash computes the MGit hash of a commit in the input's format
+func Hash(in HashInput) plumbing.Hash {
+	if in.Format == FormatLegacy {
+		return legacyHash(in)
+	}
+	var buf bytes.Buffer
+	writeHashedHeader(&buf, in)
+	header := buf.Len()
+	buf.WriteString("\n")
+	buf.WriteString(in.Message)
+	hash := plumbing.Hash(sha1.Sum(buf.Bytes()))
+	if Tracef != nil {
+		Tracef("hash", "%s from %q and a %d-byte message", hash, buf.String()[:header], len(in.Message))
+	}
+	return hash
+}
+
+// ComputeHash is Hash for a stored commit, as a hex string
+func (c *Commit) ComputeHash() string {
+	return Hash(c.HashInput()).String()
+}
+
+// legacyHash reproduces the format 0 hash exactly, including its quirks:
+// the message is not hashed, the committer string is hashed twice, and it
+// ends in the %!(EXTRA ...) text Sprintf added for its unused pubkey
+// argument.
+func legacyHash(in HashInput) plumbing.Hash {
+	if in.Author == nil || in.Committer == nil {
+		return plumbing.ZeroHash
+	}
+	hasher := sha1.New()
+
+	tree := plumbing.NewHash(in.Tree)
+	hasher.Write(tree[:])
+	for _, parentHashStr := range in.Parents {
+		parentHash := plumbing.NewHash(parentHashStr)
+		hasher.Write(parentHash[:])
+	}
+
+	author, committer := in.Author, in.Committer
+	authorStr := fmt.Sprintf("%s <%s> %d %s",
+		author.Name, author.Email, author.When.Unix(), author.Pubkey)
+	hasher.Write([]byte(authorStr))
+
+	committerStr := fmt.Sprintf("%s <%s> %d%%!(EXTRA string=%s)",
+		committer.Name, committer.Email, committer.When.Unix(), author.Pubkey)
+	hasher.Write([]byte(committerStr))
+	hasher.Write([]byte(committerStr))
+
+	var hash plumbing.Hash
+	copy(hash[:], hasher.Sum(nil))
+	if Tracef != nil {
+		Tracef("hash", "%s in the legacy format, from tree %s, parents %v, author %q", hash, in.Tree, in.Parents, authorStr)
+	}
+	return hash
+}
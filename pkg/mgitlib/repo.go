@@ -0,0 +1,211 @@
+// Package mgitlib is MGit's commit model and a read-only view of MGit
+// repositories, for programs that want MGit hashes without running the
+// mgit command.
+//
+// An MGit commit (Commit) is a git commit whose hash also covers its
+// author's nostr key. Hash computes that hash, Encode and DecodeCommit
+// convert a commit to and from its stored form, and RebuildCommit rebuilds
+// one from its git commit and mapping.
+//
+// Open opens a repository on disk:
+//
+//	repo, err := mgitlib.Open("/src/records")
+//	head, err := repo.Git().Head()
+//	commits, err := repo.Log(ctx, head.Hash(), 20)
+//	result, err := repo.Verify(ctx, nil)
+//
+// Commits are named by their git hashes; the mapping store looks MGit
+// hashes up. Resolving revision names, with their MGit refs, reflogs and
+// prefixes, is the mgit command's job, as is making commits.
+package mgitlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/imyjimmy/mgit/pkg/mapping"
+)
+
+// ErrNotMapped is returned for a git commit that has no MGit hash
+var ErrNotMapped = errors.New("commit has no MGit hash")
+
+// Repo is an MGit repository: a git repository with a .mgit directory
+// beside its .git
+type Repo struct {
+	root     string // The worktree
+	git      *git.Repository
+	mappings *mapping.Store
+}
+
+// Open opens the MGit repository whose worktree holds path
+func Open(path string) (*Repo, error) {
+	repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("error opening repository: %w", err)
+	}
+	root := worktree.Filesystem.Root()
+	mgitDir := filepath.Join(root, ".mgit")
+	if info, err := os.Stat(mgitDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not an MGit repository (no .mgit directory)", root)
+	}
+	return &Repo{
+		root:     root,
+		git:      repo,
+		mappings: mapping.StoreFor(mapping.Path(mgitDir)),
+	}, nil
+}
+
+// Root returns the repository's worktree
+func (r *Repo) Root() string {
+	return r.root
+}
+
+// Git returns the underlying git repository
+func (r *Repo) Git() *git.Repository {
+	return r.git
+}
+
+// Mappings returns the repository's hash mappings
+func (r *Repo) Mappings() *mapping.Store {
+	return r.mappings
+}
+
+// Commit returns the MGit commit of a git commit, rebuilt from the commit
+// and its mapping. It returns ErrNotMapped for a commit with no MGit hash.
+func (r *Repo) Commit(ctx context.Context, hash plumbing.Hash) (*Commit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	byGit, err := r.mgitHashes()
+	if err != nil {
+		return nil, err
+	}
+	return r.rebuild(hash, byGit)
+}
+
+// rebuild rebuilds the MGit commit of a mapped git commit
+func (r *Repo) rebuild(hash plumbing.Hash, byGit map[string]string) (*Commit, error) {
+	commit, err := r.git.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("error reading commit %s: %w", hash, err)
+	}
+	m, ok, err := r.mappings.ByGit(hash.String())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", hash, ErrNotMapped)
+	}
+	rebuilt, _ := RebuildCommit(commit, m, byGit)
+	return rebuilt, nil
+}
+
+// mgitHashes maps git hashes to MGit hashes, as RebuildCommit wants
+func (r *Repo) mgitHashes() (map[string]string, error) {
+	all, err := r.mappings.All()
+	if err != nil {
+		return nil, err
+	}
+	byGit := make(map[string]string, len(all))
+	for _, m := range all {
+		byGit[m.GitHash] = m.MGitHash
+	}
+	return byGit, nil
+}
+
+// Log returns the MGit commits reachable from a git commit, newest first,
+// at most limit of them when limit is above 0. Commits with no MGit hash,
+// such as those made with plain git, are left out.
+func (r *Repo) Log(ctx context.Context, from plumbing.Hash, limit int) ([]*Commit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	byGit, err := r.mgitHashes()
+	if err != nil {
+		return nil, err
+	}
+	iter, err := r.git.Log(&git.LogOptions{From: from, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	defer iter.Close()
+
+	commits := []*Commit{}
+	err = iter.ForEach(func(commit *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m, ok, err := r.mappings.ByGit(commit.Hash.String())
+		if err != nil || !ok {
+			return err
+		}
+		rebuilt, _ := RebuildCommit(commit, m, byGit)
+		commits = append(commits, rebuilt)
+		if limit > 0 && len(commits) >= limit {
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
+
+// VerifyResult is the outcome of Verify
+type VerifyResult struct {
+	Verified int               // Mappings whose MGit hash was reproduced
+	Missing  []mapping.Mapping // Mappings whose git commit isn't in the repository
+	Failed   []mapping.Mapping // Mappings whose MGit hash doesn't match their commit
+}
+
+// Valid reports whether every mapping that could be checked verified
+func (v *VerifyResult) Valid() bool {
+	return len(v.Failed) == 0
+}
+
+// Verify recomputes the MGit hash of every mapped commit from its git
+// commit and checks it against the mapping. progress, when set, is called
+// after each mapping with the number checked and the total. Commits
+// missing from the repository, as in shallow clones, are counted apart
+// from failures.
+func (r *Repo) Verify(ctx context.Context, progress func(done, total int)) (*VerifyResult, error) {
+	all, err := r.mappings.All()
+	if err != nil {
+		return nil, err
+	}
+	byGit := make(map[string]string, len(all))
+	for _, m := range all {
+		byGit[m.GitHash] = m.MGitHash
+	}
+
+	result := &VerifyResult{}
+	for i, m := range all {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		commit, err := r.git.CommitObject(plumbing.NewHash(m.GitHash))
+		if err != nil {
+			result.Missing = append(result.Missing, m)
+		} else if _, ok := RebuildCommit(commit, m, byGit); ok {
+			result.Verified++
+		} else {
+			result.Failed = append(result.Failed, m)
+		}
+		if progress != nil {
+			progress(i+1, len(all))
+		}
+	}
+	return result, nil
+}
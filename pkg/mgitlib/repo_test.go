@@ -0,0 +1,175 @@
+package mgitlib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/imyjimmy/mgit/pkg/mapping"
+)
+
+// testRepo makes an MGit repository with three commits, the last made with
+// plain git and so unmapped. It returns the repository's path and the
+// mappings of the first two, oldest first.
+func testRepo(t *testing.T) (string, []mapping.Mapping) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mappings := []mapping.Mapping{}
+	parents := []string{}
+	when := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, pubkey := range []string{"npub1alice", "npub1bob", ""} {
+		name := filepath.Join(dir, "file.txt")
+		if err := os.WriteFile(name, []byte{byte('a' + i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worktree.Add("file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		sig := &object.Signature{Name: "Tester", Email: "tester@example.com", When: when.Add(time.Duration(i) * time.Hour)}
+		hash, err := worktree.Commit("commit\n", &git.CommitOptions{Author: sig, Committer: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pubkey == "" {
+			continue
+		}
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mgitHash := CommitFromGit(commit, parents, pubkey).ComputeHash()
+		mappings = append(mappings, mapping.Mapping{GitHash: hash.String(), MGitHash: mgitHash, Pubkey: pubkey})
+		parents = []string{mgitHash}
+	}
+
+	store := mapping.StoreFor(mapping.Path(filepath.Join(dir, ".mgit")))
+	if err := store.PutAll(mappings); err != nil {
+		t.Fatal(err)
+	}
+	return dir, mappings
+}
+
+func TestOpen(t *testing.T) {
+	dir, _ := testRepo(t)
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := Open(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Root() != dir {
+		t.Errorf("Root() = %s, want %s", repo.Root(), dir)
+	}
+
+	plain := t.TempDir()
+	if _, err := git.PlainInit(plain, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(plain); err == nil {
+		t.Error("opened a git repository with no .mgit directory")
+	}
+}
+
+func TestCommit(t *testing.T) {
+	dir, mappings := testRepo(t)
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	second := mappings[1]
+
+	head, err := repo.Git().Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit(ctx, head.Hash()); !errors.Is(err, ErrNotMapped) {
+		t.Errorf("Commit(HEAD) = %v, want ErrNotMapped", err)
+	}
+	commit, err := repo.Commit(ctx, plumbing.NewHash(second.GitHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.MGitHash != second.MGitHash || commit.Author.Pubkey != "npub1bob" ||
+		len(commit.ParentHashes) != 1 || commit.ParentHashes[0] != mappings[0].MGitHash {
+		t.Errorf("Commit(%s) = %+v", second.GitHash[:8], commit)
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir, mappings := testRepo(t)
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Git().Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := repo.Log(context.Background(), head.Hash(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 || commits[0].MGitHash != mappings[1].MGitHash || commits[1].MGitHash != mappings[0].MGitHash {
+		t.Errorf("Log(HEAD) = %d commits, want the two mapped ones, newest first", len(commits))
+	}
+
+	commits, err = repo.Log(context.Background(), head.Hash(), 1)
+	if err != nil || len(commits) != 1 {
+		t.Errorf("Log(HEAD, 1) = %d commits, %v; want 1", len(commits), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.Log(ctx, head.Hash(), 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Log with a canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, mappings := testRepo(t)
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	result, err := repo.Verify(context.Background(), func(done, total int) { calls++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid() || result.Verified != 2 || len(result.Missing) != 0 || calls != 2 {
+		t.Errorf("Verify() = %+v after %d progress calls, want 2 verified", result, calls)
+	}
+
+	// A mapping naming the wrong key, and one for a commit that isn't here
+	forged := mappings[1]
+	forged.Pubkey = "npub1mallory"
+	missing := mapping.Mapping{GitHash: plumbing.ZeroHash.String(), MGitHash: "m"}
+	if err := repo.Mappings().PutAll([]mapping.Mapping{forged, missing}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = repo.Verify(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid() || result.Verified != 1 || len(result.Failed) != 1 || len(result.Missing) != 1 {
+		t.Errorf("Verify() = %+v, want 1 verified, 1 failed and 1 missing", result)
+	}
+}
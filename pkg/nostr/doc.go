@@ -0,0 +1,5 @@
+// Package nostr is the nostr protocol support mgit is built on, usable on
+// its own: NIP-19 keys (npub, nsec) and bech32, BIP-340 Schnorr signatures
+// over secp256k1, NIP-01 events and NIP-44 encryption. It has no network
+// code; relays, NIP-05 and NIP-98 login live with their callers in mgit.
+package nostr
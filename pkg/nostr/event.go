@@ -0,0 +1,115 @@
+package nostr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event is a signed nostr event (NIP-01)
+type Event struct {
+	ID        string     `json:"id"`
+	Pubkey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// Event kinds
+const (
+	// KindMetadata is a user's NIP-01 profile metadata
+	KindMetadata = 0
+	// KindTextNote is a NIP-01 short text note
+	KindTextNote = 1
+	// KindFollowList is a user's NIP-02 follow list
+	KindFollowList = 3
+	// KindRepoAnnouncement is a NIP-34 repository announcement
+	KindRepoAnnouncement = 30617
+	// KindPatch is a NIP-34 patch, the text of one git format-patch
+	// patch
+	KindPatch = 1617
+	// KindIssue is a NIP-34 issue
+	KindIssue = 1621
+	// KindComment is a NIP-22 comment
+	KindComment = 1111
+	// NIP-34 status events, which set the state of an issue or patch
+	KindStatusOpen    = 1630
+	KindStatusApplied = 1631
+	KindStatusClosed  = 1632
+	KindStatusDraft   = 1633
+	// KindHTTPAuth is a NIP-98 HTTP auth event
+	KindHTTPAuth = 27235
+)
+
+// NewEvent creates an unsigned event stamped with the current time
+func NewEvent(kind int, tags [][]string, content string) *Event {
+	if tags == nil {
+		tags = [][]string{}
+	}
+	return &Event{
+		CreatedAt: time.Now().Unix(),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+}
+
+// computeID returns the event ID: the sha256 of the NIP-01 serialization
+// [0, pubkey, created_at, kind, tags, content]
+func (e *Event) computeID() ([]byte, error) {
+	serialized, err := json.Marshal([]interface{}{0, e.Pubkey, e.CreatedAt, e.Kind, e.Tags, e.Content})
+	if err != nil {
+		return nil, fmt.Errorf("error serializing event: %w", err)
+	}
+	id := sha256.Sum256(serialized)
+	return id[:], nil
+}
+
+// Sign sets the event's pubkey, ID and signature from a secret key
+func (e *Event) Sign(secret []byte) error {
+	pubkey, err := PublicKey(secret)
+	if err != nil {
+		return err
+	}
+	e.Pubkey = hex.EncodeToString(pubkey)
+
+	id, err := e.computeID()
+	if err != nil {
+		return err
+	}
+	sig, err := SignSchnorr(secret, id)
+	if err != nil {
+		return err
+	}
+
+	e.ID = hex.EncodeToString(id)
+	e.Sig = hex.EncodeToString(sig)
+	return nil
+}
+
+// Verify checks the event's ID and signature
+func (e *Event) Verify() error {
+	id, err := e.computeID()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(id) != e.ID {
+		return fmt.Errorf("event id does not match its content")
+	}
+	pubkey, err := hex.DecodeString(e.Pubkey)
+	if err != nil {
+		return fmt.Errorf("invalid event pubkey: %w", err)
+	}
+	sig, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid event signature: %w", err)
+	}
+	if !VerifySchnorr(pubkey, id, sig) {
+		return fmt.Errorf("invalid event signature")
+	}
+	return nil
+}
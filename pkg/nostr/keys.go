@@ -0,0 +1,38 @@
+package nostr
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Public keys are 32-byte x-only keys, written as 64 hex characters in
+// events and as npubs (NIP-19) for people.
+
+// PubkeyHex converts a public key given as an npub or in hex to hex
+func PubkeyHex(pubkey string) (string, error) {
+	pubkey = strings.TrimSpace(pubkey)
+	if !strings.HasPrefix(strings.ToLower(pubkey), "npub1") {
+		if data, err := hex.DecodeString(pubkey); err == nil && len(data) == 32 {
+			return hex.EncodeToString(data), nil
+		}
+		return "", fmt.Errorf("public key must be an npub or 64 hex characters")
+	}
+	hrp, data, err := Bech32Decode(pubkey)
+	if err != nil {
+		return "", fmt.Errorf("invalid npub: %w", err)
+	}
+	if hrp != "npub" || len(data) != 32 {
+		return "", fmt.Errorf("invalid npub")
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// Npub converts a hex public key to an npub
+func Npub(pubkey string) (string, error) {
+	data, err := hex.DecodeString(pubkey)
+	if err != nil || len(data) != 32 {
+		return "", fmt.Errorf("invalid public key")
+	}
+	return Bech32Encode("npub", data)
+}
@@ -0,0 +1,139 @@
+package nostr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/hkdf"
+)
+
+// NIP-44 (version 2) encrypts messages between two keys: the x coordinate of
+// their ECDH point is run through HKDF-extract (salt "nip44-v2") to give a
+// conversation key, and each message is padded, encrypted with ChaCha20 and
+// authenticated with HMAC-SHA256 under keys expanded from the conversation
+// key and a 32-byte nonce. The payload is base64 of
+//
+//	version (0x02) | nonce (32) | ciphertext | mac (32)
+
+const nip44Version = 0x02
+
+// NIP44ConversationKey returns the key shared by the owner of secret and the
+// owner of pubkey (hex, x-only)
+func NIP44ConversationKey(secret []byte, pubkey string) ([]byte, error) {
+	if _, err := secretKey(secret); err != nil {
+		return nil, err
+	}
+	x, err := hex.DecodeString(pubkey)
+	if err != nil || len(x) != 32 {
+		return nil, fmt.Errorf("invalid public key %s", pubkey)
+	}
+	shared, err := sharedX(secret, x)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", pubkey, err)
+	}
+	return hkdf.Extract(sha256.New, shared, []byte("nip44-v2")), nil
+}
+
+// NIP44MessageKeys expands a conversation key and nonce into the ChaCha20
+// key and nonce and the HMAC key for one message
+func NIP44MessageKeys(conversationKey, nonce []byte) (chachaKey, chachaNonce, hmacKey []byte, err error) {
+	keys := make([]byte, 76)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, conversationKey, nonce), keys); err != nil {
+		return nil, nil, nil, err
+	}
+	return keys[:32], keys[32:44], keys[44:], nil
+}
+
+// nip44PaddedLen returns the length a message of n bytes is padded to
+func nip44PaddedLen(n int) int {
+	if n <= 32 {
+		return 32
+	}
+	next := 1
+	for next < n {
+		next <<= 1
+	}
+	chunk := 32
+	if next > 256 {
+		chunk = next / 8
+	}
+	return chunk * ((n-1)/chunk + 1)
+}
+
+// NIP44Mac authenticates a ciphertext with the nonce as associated data
+func NIP44Mac(hmacKey, nonce, ciphertext []byte) []byte {
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	return mac.Sum(nil)
+}
+
+// NIP44Encrypt encrypts a message of 1 to 65535 bytes with a conversation
+// key and a 32-byte nonce, which must not be reused with the key for a
+// different message
+func NIP44Encrypt(conversationKey, nonce, plaintext []byte) (string, error) {
+	if len(plaintext) < 1 || len(plaintext) > 65535 {
+		return "", fmt.Errorf("NIP-44 messages must be 1 to 65535 bytes")
+	}
+	if len(nonce) != 32 {
+		return "", fmt.Errorf("invalid NIP-44 nonce")
+	}
+	chachaKey, chachaNonce, hmacKey, err := NIP44MessageKeys(conversationKey, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	padded := make([]byte, 2+nip44PaddedLen(len(plaintext)))
+	binary.BigEndian.PutUint16(padded, uint16(len(plaintext)))
+	copy(padded[2:], plaintext)
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return "", err
+	}
+	cipher.XORKeyStream(padded, padded)
+
+	payload := append([]byte{nip44Version}, nonce...)
+	payload = append(payload, padded...)
+	payload = append(payload, NIP44Mac(hmacKey, nonce, padded)...)
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// NIP44Decrypt decrypts a payload with a conversation key
+func NIP44Decrypt(conversationKey []byte, payload string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NIP-44 payload: %w", err)
+	}
+	if len(data) < 99 || len(data) > 65603 {
+		return nil, fmt.Errorf("invalid NIP-44 payload length")
+	}
+	if data[0] != nip44Version {
+		return nil, fmt.Errorf("unsupported NIP-44 version %d", data[0])
+	}
+	nonce, ciphertext, mac := data[1:33], data[33:len(data)-32], data[len(data)-32:]
+	chachaKey, chachaNonce, hmacKey, err := NIP44MessageKeys(conversationKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, NIP44Mac(hmacKey, nonce, ciphertext)) {
+		return nil, fmt.Errorf("NIP-44 payload failed authentication")
+	}
+
+	padded := make([]byte, len(ciphertext))
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return nil, err
+	}
+	cipher.XORKeyStream(padded, ciphertext)
+	n := int(binary.BigEndian.Uint16(padded))
+	if n < 1 || len(padded) != 2+nip44PaddedLen(n) {
+		return nil, fmt.Errorf("invalid NIP-44 padding")
+	}
+	return padded[2 : 2+n], nil
+}
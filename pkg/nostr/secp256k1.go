@@ -0,0 +1,81 @@
+package nostr
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// The secp256k1 arithmetic and BIP-340 Schnorr signatures nostr signs events
+// with come from btcec, the implementation btcd and lnd use, so secret keys
+// are only ever handled by its vetted code.
+
+// secretKey checks a 32-byte secret key and returns it as a btcec key
+func secretKey(secret []byte) (*btcec.PrivateKey, error) {
+	var d btcec.ModNScalar
+	if len(secret) != 32 || d.SetByteSlice(secret) || d.IsZero() {
+		return nil, fmt.Errorf("invalid secret key")
+	}
+	return btcec.PrivKeyFromScalar(&d), nil
+}
+
+// PublicKey returns the 32-byte x-only public key for a secret key
+func PublicKey(secret []byte) ([]byte, error) {
+	key, err := secretKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.SerializePubKey(key.PubKey()), nil
+}
+
+// SignSchnorr produces a BIP-340 signature of a 32-byte message
+func SignSchnorr(secret, msg []byte) ([]byte, error) {
+	var aux [32]byte
+	if _, err := rand.Read(aux[:]); err != nil {
+		return nil, fmt.Errorf("error reading randomness: %w", err)
+	}
+	return signSchnorrWithAux(secret, msg, aux)
+}
+
+// signSchnorrWithAux signs with the given auxiliary randomness, deriving
+// the nonce as BIP-340 specifies
+func signSchnorrWithAux(secret, msg []byte, aux [32]byte) ([]byte, error) {
+	key, err := secretKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := schnorr.Sign(key, msg, schnorr.CustomNonce(aux))
+	if err != nil {
+		return nil, fmt.Errorf("signing failed: %w", err)
+	}
+	return sig.Serialize(), nil
+}
+
+// VerifySchnorr checks a BIP-340 signature against an x-only public key
+func VerifySchnorr(pubkey, msg, sig []byte) bool {
+	key, err := schnorr.ParsePubKey(pubkey)
+	if err != nil {
+		return false
+	}
+	parsed, err := schnorr.ParseSignature(sig)
+	if err != nil {
+		return false
+	}
+	return parsed.Verify(msg, key)
+}
+
+// sharedX returns the x coordinate of the ECDH point of a secret key and an
+// x-only public key, the point with an even y being taken for the latter
+func sharedX(secret, pubkey []byte) ([]byte, error) {
+	key, err := secretKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	point, err := schnorr.ParsePubKey(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	return btcec.GenerateSharedSecret(key, point), nil
+}
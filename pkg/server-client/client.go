@@ -0,0 +1,318 @@
+// Package serverclient talks to a repository's API on an mgit server:
+//
+//	GET    <repo>/info                repository details and the caller's access
+//	GET    <repo>/metadata            hash mappings and notes, as a JSON array
+//	POST   <repo>/metadata            add mappings and notes
+//	GET    <repo>/access              the keys granted access
+//	POST   <repo>/access              grant a key read or write access
+//	DELETE <repo>/access/<npub>       revoke a key's access
+//
+// where <repo> is the repository's API URL, such as
+// https://mgit.example/api/mgit/repos/hello. Every call takes a context
+// that cancels it. Git transfers go to the same URL through git's smart
+// HTTP protocol and are not part of this package.
+package serverclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imyjimmy/mgit/pkg/mapping"
+)
+
+// Doer sends an HTTP request; *http.Client is one
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client calls one repository's API
+type Client struct {
+	// URL is the repository's API URL
+	URL string
+	// HTTP sends the requests; http.DefaultClient when nil
+	HTTP Doer
+	// Authorize, when set, adds the caller's credentials to a request
+	Authorize func(req *http.Request)
+	// ResponseError, when set, turns an answer a call does not accept into
+	// its error, in place of a *StatusError
+	ResponseError func(resp *http.Response) error
+}
+
+// StatusError is an answer from the server a call does not accept
+type StatusError struct {
+	Method  string
+	URL     string
+	Status  int
+	Message string // The response body, trimmed
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.URL, e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// RepoInfo describes a repository on the server
+type RepoInfo struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Access string `json:"access"` // The caller's: read, write or admin
+	// TrustedPubkeys are the keys the server's policy trusts to commit
+	TrustedPubkeys []string `json:"trusted_pubkeys,omitempty"`
+}
+
+// Note is one key's value on a commit
+type Note struct {
+	Value   string    `json:"value,omitempty"`
+	Pubkey  string    `json:"pubkey,omitempty"`
+	When    time.Time `json:"when"`
+	Deleted bool      `json:"deleted,omitempty"` // A removal, kept so it syncs
+}
+
+// Notes are the notes on one commit, by key
+type Notes map[string]Note
+
+// Merge folds other into n, keeping the later change to each key. It
+// reports whether n changed.
+func (n Notes) Merge(other Notes) bool {
+	changed := false
+	for key, note := range other {
+		if current, ok := n[key]; !ok || note.When.After(current.When) {
+			n[key] = note
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Keys returns the keys of the notes that are set, sorted
+func (n Notes) Keys() []string {
+	keys := []string{}
+	for key, note := range n {
+		if !note.Deleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MetadataEntry is one entry on the metadata endpoint: a hash mapping,
+// with the commit's notes alongside
+type MetadataEntry struct {
+	mapping.Mapping
+	Notes Notes `json:"notes,omitempty"`
+}
+
+// MetadataCursor is where a metadata fetch left off. The server hands out
+// a cursor with each response; a later fetch from it gets only the
+// entries added since. A server without cursors may still answer an
+// unchanged ETag with 304 Not Modified.
+type MetadataCursor struct {
+	Cursor string `json:"cursor,omitempty"`
+	ETag   string `json:"etag,omitempty"`
+}
+
+// MetadataFetch describes one metadata response
+type MetadataFetch struct {
+	Next        MetadataCursor // Where the next fetch starts
+	Incremental bool           // Only entries added since the cursor were sent
+	NotModified bool           // Nothing changed since the last fetch
+}
+
+// endpoint returns the URL of one of the repository's endpoints
+func (c *Client) endpoint(path string) string {
+	return strings.TrimSuffix(c.URL, "/") + path
+}
+
+// do sends a request, with body encoded as JSON when it is set
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, header http.Header) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint(path), reader)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Authorize != nil {
+		c.Authorize(req)
+	}
+
+	doer := c.HTTP
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	resp, err := doer.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// responseError returns the error for an answer a call does not accept,
+// closing its body
+func (c *Client) responseError(resp *http.Response) error {
+	if c.ResponseError != nil {
+		return c.ResponseError(resp)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &StatusError{
+		Method:  resp.Request.Method,
+		URL:     resp.Request.URL.String(),
+		Status:  resp.StatusCode,
+		Message: string(bytes.TrimSpace(data)),
+	}
+}
+
+// call sends a request, checks the answer is a 2xx and decodes any JSON
+// it carries into result
+func (c *Client) call(ctx context.Context, method, path string, body, result interface{}) error {
+	resp, err := c.do(ctx, method, path, body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return c.responseError(resp)
+	}
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("error parsing response: %w", err)
+		}
+	}
+	return nil
+}
+
+// Info fetches the repository's details
+func (c *Client) Info(ctx context.Context) (*RepoInfo, error) {
+	var info RepoInfo
+	if err := c.call(ctx, "GET", "/info", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Metadata fetches the repository's hash mappings and notes, from where
+// since left off when it is given, and passes each entry to handle as it
+// is decoded. An error from handle stops the fetch and is returned.
+func (c *Client) Metadata(ctx context.Context, since *MetadataCursor, handle func(MetadataEntry) error) (*MetadataFetch, error) {
+	path := "/metadata"
+	header := http.Header{}
+	if since != nil && since.Cursor != "" {
+		path += "?since=" + url.QueryEscape(since.Cursor)
+	}
+	if since != nil && since.ETag != "" {
+		header.Set("If-None-Match", since.ETag)
+	}
+	resp, err := c.do(ctx, "GET", path, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && since != nil {
+		return &MetadataFetch{Next: *since, Incremental: true, NotModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.responseError(resp)
+	}
+
+	fetch := &MetadataFetch{
+		Next: MetadataCursor{
+			Cursor: resp.Header.Get("X-MGit-Cursor"),
+			ETag:   resp.Header.Get("ETag"),
+		},
+		Incremental: since != nil && since.Cursor != "" && resp.Header.Get("X-MGit-Since") == since.Cursor,
+	}
+	if err := DecodeMetadata(resp.Body, handle); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	return fetch, nil
+}
+
+// DecodeMetadata decodes a metadata response, a JSON array of entries, one
+// entry at a time, so a large mapping set is never held in memory as one
+// document
+func DecodeMetadata(r io.Reader, handle func(MetadataEntry) error) error {
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		if err == nil {
+			err = fmt.Errorf("expected a JSON array")
+		}
+		return fmt.Errorf("error parsing metadata response: %w", err)
+	}
+	for decoder.More() {
+		var entry MetadataEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return fmt.Errorf("error parsing metadata response: %w", err)
+		}
+		if err := handle(entry); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("error parsing metadata response: %w", err)
+	}
+	return nil
+}
+
+// PostMetadata sends mappings, with any notes, to the repository. Only
+// the caller's own mappings are accepted unless it has admin access.
+func (c *Client) PostMetadata(ctx context.Context, entries []MetadataEntry) error {
+	return c.call(ctx, "POST", "/metadata", entries, nil)
+}
+
+// AccessGrant is one key's access to the repository
+type AccessGrant struct {
+	Pubkey    string `json:"pubkey"`
+	Access    string `json:"access"` // read or write
+	GrantedBy string `json:"granted_by,omitempty"`
+	GrantedAt string `json:"granted_at,omitempty"` // RFC 3339
+}
+
+// Access lists the keys granted access to the repository. Managing access
+// needs admin access.
+func (c *Client) Access(ctx context.Context) ([]AccessGrant, error) {
+	grants := []AccessGrant{}
+	if err := c.call(ctx, "GET", "/access", nil, &grants); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// Grant gives a key read or write access, or changes the access it has
+func (c *Client) Grant(ctx context.Context, npub, access string) error {
+	return c.call(ctx, "POST", "/access", AccessGrant{Pubkey: npub, Access: access}, nil)
+}
+
+// Revoke removes a key's access
+func (c *Client) Revoke(ctx context.Context, npub string) error {
+	return c.call(ctx, "DELETE", "/access/"+url.PathEscape(npub), nil, nil)
+}
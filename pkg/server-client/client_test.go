@@ -0,0 +1,208 @@
+package serverclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/imyjimmy/mgit/pkg/mapping"
+)
+
+// testServer serves one repository's API under /api/mgit/repos/hello
+func testServer(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "who are you", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return &Client{
+		URL:       server.URL + "/api/mgit/repos/hello",
+		Authorize: func(req *http.Request) { req.Header.Set("Authorization", "Bearer secret") },
+	}
+}
+
+func TestInfo(t *testing.T) {
+	client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/api/mgit/repos/hello/info" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"id":"hello","name":"Hello","access":"write","trusted_pubkeys":["npub1a"]}`))
+	})
+	info, err := client.Info(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "hello" || info.Access != "write" || len(info.TrustedPubkeys) != 1 {
+		t.Errorf("Info() = %+v", info)
+	}
+
+	client.Authorize = nil
+	_, err = client.Info(context.Background())
+	var status *StatusError
+	if !errors.As(err, &status) || status.Status != http.StatusUnauthorized || status.Message != "who are you" {
+		t.Errorf("Info() without credentials = %v, want a 401 StatusError", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	entries := []MetadataEntry{
+		{Mapping: mapping.Mapping{GitHash: "g1", MGitHash: "m1", Pubkey: "npub1a"}},
+		{Mapping: mapping.Mapping{GitHash: "g2", MGitHash: "m2", Pubkey: "npub1b"},
+			Notes: Notes{"review": {Value: "ok", When: time.Unix(1700000000, 0).UTC()}}},
+	}
+	client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		sent := entries
+		if since := r.URL.Query().Get("since"); since == "c1" {
+			w.Header().Set("X-MGit-Since", since)
+			sent = entries[1:]
+		}
+		w.Header().Set("X-MGit-Cursor", "c2")
+		w.Header().Set("ETag", `"v2"`)
+		json.NewEncoder(w).Encode(sent)
+	})
+	ctx := context.Background()
+
+	got := []MetadataEntry{}
+	collect := func(entry MetadataEntry) error {
+		got = append(got, entry)
+		return nil
+	}
+	fetch, err := client.Metadata(ctx, nil, collect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Notes["review"].Value != "ok" || fetch.Incremental ||
+		fetch.Next != (MetadataCursor{Cursor: "c2", ETag: `"v2"`}) {
+		t.Errorf("Metadata(nil) = %+v with %+v", fetch, got)
+	}
+
+	got = got[:0]
+	fetch, err = client.Metadata(ctx, &MetadataCursor{Cursor: "c1"}, collect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].MGitHash != "m2" || !fetch.Incremental {
+		t.Errorf("Metadata(c1) = %+v with %+v, want only m2", fetch, got)
+	}
+
+	fetch, err = client.Metadata(ctx, &fetch.Next, collect)
+	if err != nil || !fetch.NotModified {
+		t.Errorf("Metadata(c2) = %+v, %v; want not modified", fetch, err)
+	}
+
+	stop := errors.New("stop")
+	if _, err := client.Metadata(ctx, nil, func(MetadataEntry) error { return stop }); err != stop {
+		t.Errorf("Metadata with a failing handler = %v, want its error", err)
+	}
+}
+
+func TestPostMetadata(t *testing.T) {
+	var posted []MetadataEntry
+	client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&posted)
+		w.WriteHeader(http.StatusCreated)
+	})
+	entries := []MetadataEntry{{Mapping: mapping.Mapping{GitHash: "g1", MGitHash: "m1", Pubkey: "npub1a"}}}
+	if err := client.PostMetadata(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 || posted[0].Mapping != entries[0].Mapping {
+		t.Errorf("server received %+v, want %+v", posted, entries)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	grants := map[string]string{"npub1a": "read"}
+	client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/mgit/repos/hello/access":
+			list := []AccessGrant{}
+			for pubkey, access := range grants {
+				list = append(list, AccessGrant{Pubkey: pubkey, Access: access})
+			}
+			json.NewEncoder(w).Encode(list)
+		case r.Method == "POST" && r.URL.Path == "/api/mgit/repos/hello/access":
+			var grant AccessGrant
+			json.NewDecoder(r.Body).Decode(&grant)
+			grants[grant.Pubkey] = grant.Access
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "DELETE" && r.URL.Path == "/api/mgit/repos/hello/access/npub1a":
+			delete(grants, "npub1a")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	ctx := context.Background()
+	if err := client.Grant(ctx, "npub1b", "write"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Revoke(ctx, "npub1a"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := client.Access(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0] != (AccessGrant{Pubkey: "npub1b", Access: "write"}) {
+		t.Errorf("Access() = %+v, want npub1b with write access", list)
+	}
+}
+
+func TestResponseErrorAndCancel(t *testing.T) {
+	client := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	})
+	refused := errors.New("refused")
+	client.ResponseError = func(resp *http.Response) error {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return refused
+	}
+	if _, err := client.Access(context.Background()); err != refused {
+		t.Errorf("Access() = %v, want the ResponseError's error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Info(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Info with a canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestNotesMerge(t *testing.T) {
+	early, late := time.Unix(1700000000, 0), time.Unix(1700000100, 0)
+	notes := Notes{"a": {Value: "1", When: late}, "b": {Value: "2", When: early}}
+	changed := notes.Merge(Notes{
+		"a": {Value: "old", When: early},
+		"b": {Deleted: true, When: late},
+		"c": {Value: "3", When: early},
+	})
+	if !changed || notes["a"].Value != "1" || !notes["b"].Deleted || notes["c"].Value != "3" {
+		t.Errorf("Merge gave %+v", notes)
+	}
+	if keys := notes.Keys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("Keys() = %v, want [a c]", keys)
+	}
+	if notes.Merge(Notes{"a": {Value: "old", When: early}}) {
+		t.Error("Merge of an older note reported a change")
+	}
+}